@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/controller"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// runAgent implements "sensor-logger agent": run the local readers only and
+// forward their samples to a central logger.
+func runAgent(args []string) int {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	var c configFlags
+	c.sensorsFlags(fs)
+	server := fs.String("server", "", "central logger host:port (overrides agent.server)")
+	id := fs.String("id", "", "agent name reported to the logger (overrides agent.id)")
+	logFile := fs.String("log-file", "", "also write the log to this file")
+	logLevel := fs.String("log-level", "info", "debug, info, warn or error")
+	statsInterval := fs.Duration("stats-interval", 10*time.Second, "reader stats logging interval")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger agent [-sensors file] [-profile p] [-server host:port] [-id name]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := utils.InitLogger(*logFile, *logLevel); err != nil {
+		utils.L().Errorf("logger: %v", err)
+		return 1
+	}
+	log := utils.L()
+	if *statsInterval <= 0 {
+		log.Errorf("-stats-interval must be positive")
+		return 1
+	}
+	cfg, err := c.sensors()
+	if err != nil {
+		log.Errorf("config: %v", err)
+		return 1
+	}
+	if *server != "" {
+		cfg.Agent.Server = *server
+	}
+	if *id != "" {
+		cfg.Agent.ID = *id
+	}
+	if cfg.Agent.Server == "" {
+		log.Errorf("agent: no server configured (set agent.server or -server)")
+		return 1
+	}
+	if cfg.UsesRemote() {
+		log.Errorf("agent: sensors with device \"remote\" cannot be forwarded by an agent")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sensors := controller.NewSensorsController(cfg, events.NewBus(log), log, utils.RealClock)
+	agent, err := controller.NewAgentController(cfg.Agent, sensors, log)
+	if err != nil {
+		log.Errorf("agent: %v", err)
+		return 1
+	}
+	log.Infof("agent %q forwarding to %s", cfg.Agent.ID, cfg.Agent.Server)
+	sensors.Start(ctx)
+	go sensors.LogStats(ctx, *statsInterval)
+	agent.Run(ctx)
+	timeout := utils.DefaultStopTimeoutS * time.Second
+	if running := sensors.Stop(time.Now().Add(timeout)); len(running) > 0 {
+		log.Errorf("agent: %s still running after %v", strings.Join(running, ", "), timeout)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/controller"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/preflight"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// runBench implements "sensor-logger bench": measure how fast base_dir
+// takes sequential writes and saved frames, against what a session of the
+// sensor config needs with the pre-flight margin, and how many samples
+// the fusion stage takes per second.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	var c configFlags
+	c.sensorsFlags(fs)
+	c.storageFlag(fs, "storage config file")
+	baseDir := fs.String("dir", "", "directory to measure (overrides base_dir)")
+	mb := fs.Int("mb", 256, "MiB of the sequential write test")
+	frames := fs.Int("frames", 300, "frames written with frame_sync in the frame test (0 skips it)")
+	fusion := fs.Int("fusion", 1000000, "lidar packets and IMU samples each handed to the fusion stage in the fusion test (0 skips it)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger bench [-sensors file] [-storage file] [-profile p] [-dir dir] [-mb 256] [-frames 300] [-fusion 1000000]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	log := utils.L()
+	if *mb <= 0 || *frames < 0 || *fusion < 0 {
+		log.Errorf("bench: -mb must be positive, -frames and -fusion not negative")
+		return 2
+	}
+	sensors, err := c.sensors()
+	if err != nil {
+		log.Errorf("config: %v", err)
+		return 1
+	}
+	storage, err := c.storage(sensors)
+	if err != nil {
+		log.Errorf("config: %v", err)
+		return 1
+	}
+	if *baseDir == "" {
+		*baseDir = storage.BaseDir
+	}
+	if err := os.MkdirAll(*baseDir, 0o755); err != nil {
+		log.Errorf("bench: %v", err)
+		return 1
+	}
+
+	margin := storage.Preflight.Margin
+	need := preflight.EstimateRate(sensors, storage)
+	speed, err := preflight.WriteSpeed(*baseDir, *mb)
+	if err != nil {
+		log.Errorf("bench: %v", err)
+		return 1
+	}
+	code := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "directory\t%s\n", *baseDir)
+	fmt.Fprintf(tw, "estimated data rate\t%s/s\n", utils.FormatBytes(int64(need)))
+	fmt.Fprintf(tw, "sequential writes\t%s/s\t%s\n", utils.FormatBytes(int64(speed)), headroom(speed, need, margin, &code))
+
+	size := preflight.FrameSize(sensors, storage)
+	if size > 0 && *frames > 0 {
+		rate, err := frameWrites(*baseDir, storage, size, *frames, log)
+		if err != nil {
+			log.Errorf("bench: %v", err)
+			return 1
+		}
+		fps := float64(sensors.Camera.FPS) / float64(sensors.Camera.Decimate)
+		fmt.Fprintf(tw, "frame writes (%s, %s)\t%.0f/s\t%s\n", utils.FormatBytes(int64(size)), storage.FrameSync, rate, headroom(rate, fps, margin, &code))
+	}
+	if *fusion > 0 {
+		rate := controller.MeasureFusion(sensors.Fusion, *fusion)
+		fmt.Fprintf(tw, "fusion stage\t%.2f M samples/s\t%.0f ns each\n", rate/1e6, 1e9/rate)
+	}
+	tw.Flush()
+	return code
+}
+
+// headroom describes how far got is above need, setting code to 1 when it
+// is below need times margin.
+func headroom(got, need, margin float64, code *int) string {
+	if need <= 0 {
+		return ""
+	}
+	if got < need*margin {
+		*code = 1
+		return fmt.Sprintf("%.1fx the need, below the %.1fx margin", got/need, margin)
+	}
+	return fmt.Sprintf("%.1fx the need", got/need)
+}
+
+// frameWrites writes n frames of size bytes into a scratch directory in
+// dir as the recording saves them, and returns the frames per second.
+func frameWrites(dir string, storage *utils.StorageConfig, size, n int, log utils.Logger) (float64, error) {
+	tmp, err := os.MkdirTemp(dir, ".bench-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	blobs := views.NewBlobWriter(storage.FrameSync, int64(storage.SyncChunkMB)<<20, log)
+	data := make([]byte, size)
+	start := time.Now()
+	for i := range n {
+		if err := blobs.WriteFile(filepath.Join(tmp, fmt.Sprintf("%08d.jpg", i)), data); err != nil {
+			return 0, err
+		}
+	}
+	return float64(n) / time.Since(start).Seconds(), nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/export"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// Bounds of a stretch of IMU samples calibrate takes as still: the spread
+// of the measured gravity and the mean rotation rate.
+const (
+	stillAccelStdMps2 = 0.2
+	stillGyroRads     = 0.05
+	minStillSamples   = 50
+)
+
+// runCalibrate implements "sensor-logger calibrate": estimate the roll and
+// pitch of the IMU mounting from a session, or part of one, recorded with
+// the vehicle standing on level ground, and print the imu extrinsic of
+// sensors.yaml with them.
+func runCalibrate(args []string) int {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	var c configFlags
+	c.sensorsFlags(fs)
+	from := fs.String("from", "", "use the samples from this time: RFC 3339, Unix seconds or an offset from the session start such as 1m")
+	to := fs.String("to", "", "use the samples up to this time, in the same forms as -from")
+	force := fs.Bool("force", false, "calibrate even if the IMU was not still")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger calibrate [-from t] [-to t] [-force] <session dir>")
+		fmt.Fprintln(fs.Output(), "The translation and yaw are kept from the session's calib.yaml, else from -sensors.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+	log := utils.L()
+	filter, err := exportFilter(dir, *from, *to, "imu")
+	if err != nil {
+		log.Errorf("calibrate: %v", err)
+		return 2
+	}
+	samples, err := export.ReadIMU(dir, filter)
+	if err != nil {
+		log.Errorf("calibrate: %v", err)
+		return 1
+	}
+	if len(samples) < minStillSamples {
+		log.Errorf("calibrate: %d IMU samples, at least %d needed", len(samples), minStillSamples)
+		return 1
+	}
+
+	var accel, gyro [3]float64
+	var norm, norm2 float64
+	for _, s := range samples {
+		accel = [3]float64{accel[0] + s.AccelX, accel[1] + s.AccelY, accel[2] + s.AccelZ}
+		gyro = [3]float64{gyro[0] + s.GyroX, gyro[1] + s.GyroY, gyro[2] + s.GyroZ}
+		n := math.Sqrt(s.AccelX*s.AccelX + s.AccelY*s.AccelY + s.AccelZ*s.AccelZ)
+		norm += n
+		norm2 += n * n
+	}
+	k := float64(len(samples))
+	for i := range 3 {
+		accel[i] /= k
+		gyro[i] /= k
+	}
+	norm /= k
+	std := math.Sqrt(max(norm2/k-norm*norm, 0))
+	spin := math.Sqrt(gyro[0]*gyro[0] + gyro[1]*gyro[1] + gyro[2]*gyro[2])
+	if std > stillAccelStdMps2 || spin > stillGyroRads {
+		msg := fmt.Sprintf("the IMU was not still: gravity varies by %.3f m/s² (at most %.1f) and it turns at %.3f rad/s (at most %.2f); pick a still stretch with -from and -to",
+			std, stillAccelStdMps2, spin, stillGyroRads)
+		if !*force {
+			log.Errorf("calibrate: %s", msg)
+			return 1
+		}
+		log.Warnf("calibrate: %s", msg)
+	}
+
+	cal, source, err := currentCalibration(dir, &c)
+	if err != nil {
+		log.Errorf("calibrate: %v", err)
+		return 1
+	}
+	imu := cal.Extrinsics["imu"]
+	// The rotation that brings the measured gravity onto the vehicle's z
+	// axis; its third row does not depend on the yaw, which is kept.
+	imu.Rotation[0] = degrees(math.Atan2(accel[1], accel[2]))
+	imu.Rotation[1] = degrees(math.Atan2(-accel[0], math.Hypot(accel[1], accel[2])))
+
+	span := samples[len(samples)-1].Timestamp.Sub(samples[0].Timestamp).Seconds()
+	fmt.Printf("# %d IMU samples over %.1f s of %s; translation and yaw from %s\n", len(samples), span, filepath.Base(filepath.Clean(dir)), source)
+	fmt.Printf("# gravity %.3f m/s² (spread %.3f), gyro bias [%.5f, %.5f, %.5f] rad/s\n", norm, std, gyro[0], gyro[1], gyro[2])
+	fmt.Println("calibration:")
+	fmt.Println("  extrinsics:")
+	fmt.Printf("    imu:    {translation: [%.2f, %.2f, %.2f], rotation_rpy_deg: [%.2f, %.2f, %.2f]}\n",
+		imu.Translation[0], imu.Translation[1], imu.Translation[2], imu.Rotation[0], imu.Rotation[1], imu.Rotation[2])
+	return 0
+}
+
+// currentCalibration returns the calibration the session in dir was
+// recorded with, else that of the sensors config, and where it came from.
+func currentCalibration(dir string, c *configFlags) (utils.CalibrationConfig, string, error) {
+	path := filepath.Join(dir, views.CalibYAML)
+	cal, err := utils.LoadCalibration(path)
+	if err == nil {
+		return *cal, views.CalibYAML, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return utils.CalibrationConfig{}, "", err
+	}
+	sensors, err := c.sensors()
+	if err != nil {
+		return utils.CalibrationConfig{}, "", err
+	}
+	return sensors.Calibration, *c.sensorsPath, nil
+}
+
+// degrees converts rad to degrees rounded to the hundredth printed, without
+// a negative zero.
+func degrees(rad float64) float64 {
+	return math.Round(rad*180/math.Pi*100)/100 + 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// configDirEnv names the environment variable holding the directory the
+// config files are read from when no flag names them; config/ otherwise.
+const configDirEnv = "SENSOR_LOGGER_CONFIG"
+
+// configPath returns the default path of the config file name.
+func configPath(name string) string {
+	dir := os.Getenv(configDirEnv)
+	if dir == "" {
+		dir = "config"
+	}
+	return filepath.Join(dir, name)
+}
+
+// configFlags are the config file flags the commands share, so that every
+// command finds sensors.yaml and storage.yaml the same way.
+type configFlags struct {
+	sensorsPath *string
+	profile     *string
+	storagePath *string
+}
+
+// sensorsFlags adds -sensors and -profile to fs.
+func (c *configFlags) sensorsFlags(fs *flag.FlagSet) {
+	c.sensorsPath = fs.String("sensors", configPath("sensors.yaml"), "sensors config file")
+	c.profile = fs.String("profile", "", "apply this profile of the sensors config, e.g. highway")
+}
+
+// storageFlag adds -storage to fs, described by usage.
+func (c *configFlags) storageFlag(fs *flag.FlagSet, usage string) {
+	c.storagePath = fs.String("storage", configPath("storage.yaml"), usage)
+}
+
+// sensors loads the sensors config with the profile applied.
+func (c *configFlags) sensors() (*utils.SensorsConfig, error) {
+	return utils.LoadSensorsConfig(*c.sensorsPath, *c.profile)
+}
+
+// storage loads the storage config, with the storage settings of the
+// profile of sensors applied when it is not nil.
+func (c *configFlags) storage(sensors *utils.SensorsConfig) (*utils.StorageConfig, error) {
+	var p utils.Profile
+	if sensors != nil {
+		p = sensors.Profile
+	}
+	return utils.LoadStorageConfig(*c.storagePath, p)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/ingest"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// runDiscover implements "sensor-logger discover": probe for attached
+// sensors and print a sensors.yaml skeleton for them.
+func runDiscover(args []string) int {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	wait := fs.Duration("wait", 2*time.Second, "how long to listen on each serial port, baud rate and network port")
+	lidarPort := fs.Int("lidar-port", 2368, "UDP port to listen on for lidar data")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger discover [-wait d] [-lidar-port n] > sensors.yaml")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *wait <= 0 {
+		fs.Usage()
+		return 2
+	}
+	d := ingest.Discover(*wait, *lidarPort, utils.L())
+	if err := d.WriteYAML(os.Stdout); err != nil {
+		utils.L().Errorf("discover: %v", err)
+		return 1
+	}
+	return 0
+}
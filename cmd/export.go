@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/export"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// runExport implements "sensor-logger export <session dir>": convert a
+// session into formats other tools read, such as GPX and GeoJSON tracks,
+// ROS 1 bags and the MCAP and Parquet files of the sinks, or into a
+// preview.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	formats := fs.String("format", "gpx,geojson", "comma-separated formats: gpx, geojson, bag, mcap, parquet, preview")
+	out := fs.String("o", "", "output directory (default: the session directory)")
+	from := fs.String("from", "", "export from this time: RFC 3339, Unix seconds or an offset from the session start such as 1h20m")
+	to := fs.String("to", "", "export up to this time, in the same forms as -from")
+	sensors := fs.String("sensors", "", "comma-separated sensors to export (default all): "+strings.Join(export.Sensors, ","))
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger export [-format gpx,geojson,bag,mcap,parquet,preview] [-from t] [-to t] [-sensors list] [-o dir] <session dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+	if *out == "" {
+		*out = dir
+	}
+	var list, sinks []string
+	bag, preview := false, false
+	for _, f := range strings.Split(*formats, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case "bag":
+			bag = true
+			continue
+		case "preview":
+			preview = true
+			continue
+		case utils.SinkMCAP, utils.SinkParquet:
+			sinks = append(sinks, f)
+			continue
+		}
+		if _, ok := export.TrackFiles[f]; !ok {
+			utils.L().Errorf("export: unknown format %q (gpx, geojson, bag, mcap, parquet or preview)", f)
+			return 2
+		}
+		list = append(list, f)
+	}
+	filter, err := exportFilter(dir, *from, *to, *sensors)
+	if err != nil {
+		utils.L().Errorf("export: %v", err)
+		return 2
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		utils.L().Errorf("export: %v", err)
+		return 1
+	}
+
+	if len(list) > 0 {
+		paths, err := export.WriteTracks(dir, *out, list, filter)
+		for _, p := range paths {
+			utils.L().Infof("export: wrote %s", p)
+		}
+		if err != nil {
+			utils.L().Errorf("export: %v", err)
+			return 1
+		}
+	}
+	if bag {
+		path := filepath.Join(*out, export.BagFile)
+		n, err := export.WriteBag(dir, path, filter)
+		if err != nil {
+			utils.L().Errorf("export: %s: %v", path, err)
+			return 1
+		}
+		utils.L().Infof("export: wrote %s, %d messages", path, n)
+	}
+	for _, format := range sinks {
+		n, err := export.WriteSink(dir, *out, format, filter)
+		if err != nil {
+			utils.L().Errorf("export: %s: %v", format, err)
+			return 1
+		}
+		utils.L().Infof("export: wrote %s files to %s, %d rows", format, *out, n)
+	}
+	if preview {
+		st, err := export.WritePreview(dir, filepath.Join(*out, export.PreviewDir), utils.DefaultPreview, filter)
+		for _, p := range st.Paths {
+			utils.L().Infof("export: wrote %s", p)
+		}
+		if err != nil {
+			utils.L().Errorf("export: preview: %v", err)
+			return 1
+		}
+		utils.L().Infof("export: preview of %d fused rows, %d frames, track of %d of %d fixes", st.Rows, st.Frames, st.Points, st.Fixes)
+	}
+	return 0
+}
+
+func exportFilter(dir, from, to, sensors string) (export.Filter, error) {
+	var f export.Filter
+	var err error
+	if f.Sensors, err = export.ParseSensors(sensors); err != nil {
+		return f, err
+	}
+	if from == "" && to == "" {
+		return f, nil
+	}
+	// Only relative times need the start; ParseTime reports its absence.
+	start, _ := export.SessionStart(dir)
+	if from != "" {
+		if f.From, err = export.ParseTime(from, start); err != nil {
+			return f, err
+		}
+	}
+	if to != "" {
+		if f.To, err = export.ParseTime(to, start); err != nil {
+			return f, err
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("-from %s is not before -to %s", from, to)
+	}
+	return f, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/controller"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/phonelog"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// runImport implements "sensor-logger import <export>": record the GPS
+// fixes and IMU samples of a phone app's export into a new session, as if
+// they had been logged here.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var c configFlags
+	c.storageFlag(fs, "storage config file")
+	baseDir := fs.String("dir", "", "sessions directory (overrides base_dir)")
+	format := fs.String("format", "", "export format: "+strings.Join(phonelog.Formats, " or ")+" (default from the path: a folder or zip, or a CSV)")
+	start := fs.String("start", "", "physicstoolbox: when the recording started, RFC 3339 or a date such as 2024-05-01 for times of day (default the day the file was last modified)")
+	tags := fs.String("tags", "", "comma-separated tags for the session, e.g. phone,commute")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger import [-format f] [-start t] [-tags t,...] [-dir dir] <export>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	log := utils.L()
+	var startTime time.Time
+	if *start != "" {
+		var err error
+		if startTime, err = time.Parse(time.RFC3339, *start); err != nil {
+			if startTime, err = time.ParseInLocation(time.DateOnly, *start, time.Local); err != nil {
+				log.Errorf("import: -start wants RFC 3339 or a date, got %q", *start)
+				return 2
+			}
+		}
+	}
+	cfg, err := c.storage(nil)
+	if err != nil {
+		log.Errorf("config: %v", err)
+		return 1
+	}
+	if *baseDir == "" {
+		*baseDir = cfg.BaseDir
+	}
+	src := fs.Arg(0)
+	l, err := phonelog.Read(src, *format, startTime)
+	if err != nil {
+		log.Errorf("import: %v", err)
+		return 1
+	}
+	first := l.Start()
+	dir := filepath.Join(*baseDir, utils.SessionName(first))
+	if _, err := os.Stat(dir); err == nil {
+		log.Errorf("import: %s already exists", dir)
+		return 1
+	}
+
+	// The imported records are not live: they are not produced to Kafka,
+	// and the recording is not shed as the disk fills.
+	cfg.Sinks = slices.DeleteFunc(cfg.Sinks, func(s utils.SinkConfig) bool { return s.Type == utils.SinkKafka })
+	cfg.Degrade.Enabled = false
+	cfg.Tags = utils.CleanTags(append(cfg.Tags, *tags))
+	sensors := utils.DefaultSensorsConfig()
+	sensors.GPS.Enabled = len(l.GPS) > 0
+	sensors.GPS.RateHz = sampleRate(len(l.GPS), func(i int) time.Time { return l.GPS[i].Timestamp })
+	sensors.IMU.Enabled = len(l.IMU) > 0
+	sensors.IMU.RateHz = sampleRate(len(l.IMU), func(i int) time.Time { return l.IMU[i].Timestamp })
+
+	// The session runs on the clock of the export, so that it is named,
+	// started and noted at its times.
+	clock := utils.NewFakeClock(first)
+	rc, err := controller.NewRecordingController(*cfg, sensors, dir, events.NewBus(log), log, clock)
+	if err != nil {
+		log.Errorf("import: %v", err)
+		return 1
+	}
+	rc.AddNote("start", fmt.Sprintf("imported from %s (%s)", filepath.Base(filepath.Clean(src)), l.Format))
+	gps, imu := l.GPS, l.IMU
+	for len(gps) > 0 || len(imu) > 0 {
+		if len(imu) == 0 || len(gps) > 0 && !imu[0].Timestamp.Before(gps[0].Timestamp) {
+			advanceTo(clock, gps[0].Timestamp)
+			rc.RecordGPS(gps[0])
+			gps = gps[1:]
+		} else {
+			advanceTo(clock, imu[0].Timestamp)
+			rc.RecordIMU(imu[0])
+			imu = imu[1:]
+		}
+	}
+	rc.Stop(time.Now().Add(time.Duration(cfg.StopTimeoutS) * time.Second))
+	if err := rc.Err(); err != nil {
+		log.Errorf("import: %v", err)
+		return 1
+	}
+	log.Infof("import: %d GPS fixes and %d IMU samples of %s into %s", len(l.GPS), len(l.IMU), l.Format, dir)
+	return 0
+}
+
+// advanceTo moves clock on to t, if it is not past it already.
+func advanceTo(clock *utils.FakeClock, t time.Time) {
+	if d := t.Sub(clock.Now()); d > 0 {
+		clock.Advance(d)
+	}
+}
+
+// sampleRate estimates the rate of n samples timed by at from their
+// median interval, for the gap detection of the recording.
+func sampleRate(n int, at func(i int) time.Time) int {
+	var intervals []time.Duration
+	for i := 1; i < n; i++ {
+		if d := at(i).Sub(at(i - 1)); d > 0 {
+			intervals = append(intervals, d)
+		}
+	}
+	if len(intervals) == 0 {
+		return 1
+	}
+	slices.Sort(intervals)
+	return utils.ClampRate(int(math.Round(float64(time.Second) / float64(intervals[len(intervals)/2]))))
+}
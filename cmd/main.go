@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a subcommand of sensor-logger, run with the arguments that
+// follow its name and exiting with the code it returns.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands are the subcommands, in the order help lists them.
+var commands = []command{
+	{"record", "record the sensors into sessions (the default)", runRecord},
+	{"agent", "stream the sensors of this machine to a central logger", runAgent},
+	{"discover", "look for sensors and print a sensors.yaml for them", runDiscover},
+	{"bench", "measure whether base_dir keeps up with the sensor config", runBench},
+	{"calibrate", "estimate the IMU mounting from a stationary session", runCalibrate},
+	{"sessions", "list, prune, repair, redact, pack and unpack sessions", runSessions},
+	{"pack", "pack sessions into archives, as \"sessions pack\" does", runPack},
+	{"verify", "check sessions and packed archives for missing or damaged files", runVerify},
+	{"validate", "check jsonl and mcap records against the record schemas", runValidate},
+	{"report", "write the HTML or Markdown report of a session", runReport},
+	{"export", "export a session as GPX, GeoJSON, a ROS bag or a preview", runExport},
+	{"replay", "play a session to Foxglove Studio", runReplay},
+	{"import", "record a phone app's sensor export into a session", runImport},
+}
+
+func main() {
+	args := os.Args[1:]
+	// Without a command the arguments are the flags of record, as they
+	// were before there were commands.
+	name := "record"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		if len(args) == 1 {
+			if c := findCommand(args[0]); c != nil {
+				os.Exit(c.run([]string{"-h"}))
+			}
+		}
+		usage(os.Stdout)
+		return
+	}
+	c := findCommand(name)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "sensor-logger: unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	os.Exit(c.run(args))
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// usage lists the commands on w.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: sensor-logger [command] [flags] [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun \"sensor-logger help <command>\" for the flags of a command.\n"+
+		"Config files are read from $%s, else from config/,\nunless -sensors or -storage name them.\n", configDirEnv)
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/controller"
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/alerts"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/catalog"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/foxglove"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/hooks"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/metrics"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/overlay"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/pack"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/preflight"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/web"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// runOptions are the command-line options shared by every pipeline.
+type runOptions struct {
+	duration      time.Duration
+	statsInterval time.Duration
+	dryRun        bool
+	// serve is set when -http-addr is.
+	serve bool
+	// tags are added to those of storage.yaml; startNote is the note
+	// taken as the sessions start and stopNote, when set, asks for the
+	// one taken as they stop.
+	tags      []string
+	startNote string
+	stopNote  func() string
+}
+
+// pipeline is one recording: its readers, fusion, session and the
+// outputs fed from them. Pipelines run side by side share nothing but the
+// logger and the HTTP server, where a named pipeline is served under
+// /<name>/ and its metrics carry a pipeline label.
+type pipeline struct {
+	name    string
+	sensors *utils.SensorsConfig
+	storage *utils.StorageConfig
+	opts    runOptions
+	log     utils.Logger
+	// bus carries the events of the pipeline's readers and session.
+	bus *events.Bus
+
+	recording *controller.RecordingController
+	readers   *controller.SensorsController
+	fusion    *controller.FusionController
+	fanout    *controller.FusedFanout
+	publisher *views.ZMQPublisher
+	thumbs    *views.Thumbnails
+	fox       *foxglove.Server
+	bridge    *views.FoxgloveBridge
+	// arrow are the servers of the arrow fused outputs, by output name.
+	arrow     map[string]*views.ArrowFusedServer
+	telemetry *controller.TelemetryController
+	alerts    *alerts.Engine
+	// stages hand the raw samples to the sinks above, in the order of
+	// storage.yaml.
+	stages *controller.Stages
+
+	// rotating is set when SIGUSR1 closes the session for the next one,
+	// and dump asks run to log the state of the pipeline.
+	rotating atomic.Bool
+	dump     chan struct{}
+}
+
+// newPipeline runs the pre-flight checks and opens the session of a
+// pipeline; name is empty when it runs alone. A pipeline opened on
+// rotation gets prev, the one it follows, and keeps its log and bus
+// without the checks.
+func newPipeline(name string, sensorsCfg *utils.SensorsConfig, storageCfg *utils.StorageConfig, opts runOptions, prev *pipeline, log utils.Logger) (*pipeline, error) {
+	p := &pipeline{name: name, sensors: sensorsCfg, storage: storageCfg, opts: opts, dump: make(chan struct{}, 1)}
+	if prev != nil {
+		p.log, p.bus = prev.log, prev.bus
+	} else {
+		if name != "" {
+			log = utils.WithPrefix(log, pipelinePrefix(name))
+		}
+		p.log, p.bus = log, events.NewBus(log)
+	}
+	log = p.log
+	storageCfg.DryRun = opts.dryRun
+	storageCfg.Tags = utils.CleanTags(append(storageCfg.Tags, opts.tags...))
+	if storageCfg.Preflight.Enabled && prev == nil {
+		if err := preflight.Run(sensorsCfg, storageCfg, opts.duration, !opts.dryRun, log); err != nil {
+			for _, line := range strings.Split(err.Error(), "\n") {
+				log.Errorf("preflight: %s", line)
+			}
+			return nil, errors.New("preflight failed")
+		}
+		log.Infof("preflight: all checks passed")
+	}
+
+	sessionDir := filepath.Join(storageCfg.BaseDir, utils.SessionName(utils.Now()))
+	resumeDir := ""
+	if storageCfg.Resume.Enabled && !opts.dryRun && prev == nil {
+		s, err := catalog.Resumable(storageCfg.BaseDir, time.Duration(storageCfg.Resume.WindowS)*time.Second)
+		if err != nil {
+			log.Warnf("resume: %v", err)
+		} else if s != nil {
+			resumeDir = s.Dir
+		}
+	}
+	var err error
+	if resumeDir != "" {
+		p.recording, err = controller.NewRecordingController(*storageCfg, sensorsCfg, resumeDir, p.bus, log, utils.RealClock)
+		if err != nil {
+			log.Warnf("resume: cannot continue %s: %v; starting a new session", resumeDir, err)
+		} else {
+			sessionDir = resumeDir
+		}
+	}
+	if p.recording == nil {
+		p.recording, err = controller.NewRecordingController(*storageCfg, sensorsCfg, sessionDir, p.bus, log, utils.RealClock)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recording: %w", err)
+	}
+	p.recording.AddNote("start", opts.startNote)
+	if prev != nil {
+		p.recording.AddNote("start", "rotated from "+filepath.Base(prev.recording.Dir()))
+	}
+	sinks := map[string]controller.SampleRecorder{utils.StageRecording: p.recording}
+	surfaceAuth, err := auth.New(storageCfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if storageCfg.ZMQ.Enabled {
+		p.publisher, err = views.NewZMQPublisher(storageCfg.ZMQ, surfaceAuth, log)
+		if err != nil {
+			return nil, fmt.Errorf("zmq: %w", err)
+		}
+		log.Infof("zmq: publishing sensor streams on %s", storageCfg.ZMQ.Endpoint)
+		sinks[utils.StageZMQ] = p.publisher
+	}
+	if storageCfg.Thumbnails.Enabled && sensorsCfg.Camera.Enabled {
+		p.thumbs = views.NewThumbnails(storageCfg.Thumbnails, log)
+		sinks[utils.StageThumbnails] = p.thumbs
+	}
+	if storageCfg.Foxglove.Enabled {
+		if !opts.serve {
+			log.Warnf("foxglove: enabled but not served without -http-addr")
+		} else {
+			p.fox = foxglove.NewServer("sensor-logger "+filepath.Base(sessionDir), storageCfg.Foxglove.Queue, log)
+			p.bridge = views.NewFoxgloveBridge(p.fox, sensorsCfg.Calibration, log)
+			sinks[utils.StageFoxglove] = p.bridge
+		}
+	}
+	for _, o := range storageCfg.FusedOutputs {
+		if o.Sink != utils.FusedSinkArrow {
+			continue
+		}
+		srv, err := views.NewArrowFusedServer(o, p.recording.FusedLayout(), storageCfg.Units, surfaceAuth, log)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", o.Name, err)
+		}
+		if p.arrow == nil {
+			p.arrow = make(map[string]*views.ArrowFusedServer)
+		}
+		p.arrow[o.Name] = srv
+	}
+	p.readers = controller.NewSensorsController(sensorsCfg, p.bus, log, utils.RealClock)
+	if p.readers.Throttle != nil {
+		p.recording.ReportThrottle(p.readers.Throttle)
+	}
+	if c := p.recording.Capture("lidar"); c != nil {
+		p.readers.Lidar.UseCapture(c)
+	}
+	if c := p.recording.Capture("radar"); c != nil {
+		p.readers.Radar.UseCapture(c)
+	}
+	if storageCfg.Telemetry.Enabled {
+		p.telemetry, err = controller.NewTelemetryController(storageCfg.Telemetry, p.readers, p.recording, p.bus, log)
+		if err != nil {
+			return nil, fmt.Errorf("telemetry: %w", err)
+		}
+		log.Infof("telemetry: reporting as %s to %s", storageCfg.Telemetry.Vehicle, telemetryTarget(storageCfg.Telemetry))
+		sinks[utils.StageTelemetry] = p.telemetry
+	}
+	if storageCfg.Alerts.Enabled {
+		p.alerts, err = alerts.New(storageCfg.Alerts, sensorsCfg, filepath.Base(sessionDir), p.bus, log)
+		if err != nil {
+			return nil, fmt.Errorf("alerts: %w", err)
+		}
+		log.Infof("alerts: watching %d rules", len(storageCfg.Alerts.Rules))
+	}
+	p.stages, err = controller.ComposeStages(storageCfg, sensorsCfg, sinks, log)
+	if err != nil {
+		return nil, fmt.Errorf("stages: %w", err)
+	}
+	if !opts.dryRun {
+		p.stages.UseSession(sessionDir)
+	}
+	p.fusion = controller.NewFusionController(sensorsCfg.Fusion, sensorsCfg.Calibration, p.recording.Projector(), p.readers, p.stages, utils.RealClock)
+	p.fanout = controller.NewFusedFanout(p.fusion.Out, sensorsCfg.Fusion.RateHz, log, utils.RealClock)
+	return p, nil
+}
+
+// route returns the HTTP path of the pipeline's path.
+func (p *pipeline) route(path string) string {
+	if p.name == "" {
+		return path
+	}
+	return "/" + p.name + path
+}
+
+// title names a status page link of the pipeline.
+func (p *pipeline) title(t string) string {
+	if p.name == "" {
+		return t
+	}
+	return t + " (" + p.name + ")"
+}
+
+// register adds the metrics and handlers of the slot's pipeline to the
+// HTTP server. They follow the pipeline across rotations.
+func (s *slot) register(srv *web.Server, reg *metrics.Registry) {
+	p := s.current()
+	collectors := []metrics.Collector{
+		func() []metrics.Sample { return s.current().readers.Metrics() },
+		func() []metrics.Sample { return s.current().fanout.Metrics() },
+		func() []metrics.Sample { return s.current().recording.Metrics() },
+	}
+	for _, c := range collectors {
+		if p.name != "" {
+			c = metrics.WithLabel(c, "pipeline", p.name)
+		}
+		reg.Register(c)
+	}
+	srv.Handle("POST "+p.route("/sensors/{name}/restart"), auth.Control, s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(p.readers.ServeRestart) }), "", "")
+	srv.Handle("GET "+p.route("/events"), auth.Read, p.bus, p.route("/events"), p.title("Recent events"))
+	notes := s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(p.recording.ServeNotes) })
+	srv.Handle("GET "+p.route("/notes"), auth.Read, notes, p.route("/notes"), p.title("Session tags and notes"))
+	srv.Handle("POST "+p.route("/notes"), auth.Control, notes, "", "")
+	if p.thumbs != nil {
+		srv.Handle("GET "+p.route("/camera"), auth.Read, s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(p.thumbs.ServePage) }), p.route("/camera"), p.title("Camera thumbnails"))
+		srv.Handle("GET "+p.route("/thumbnails"), auth.Read, s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(p.thumbs.ServeList) }), "", "")
+		srv.Handle("GET "+p.route("/thumbnails/{name}"), auth.Read, s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(p.thumbs.ServeImage) }), "", "")
+	}
+	if p.storage.FrameProcessing.Enabled && p.storage.SaveFrames && p.sensors.Camera.Enabled {
+		srv.Handle("POST "+p.route("/camera/full-res"), auth.Control, s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(p.recording.ServeFullRes) }), "", "")
+	}
+	for _, pc := range p.storage.Processors {
+		if pc.Type != utils.ProcessorRadarOverlay {
+			continue
+		}
+		name := pc.Name
+		o := func(p *pipeline) *overlay.RadarOverlay { return p.stages.Processor(name).(*overlay.RadarOverlay) }
+		page := p.route("/overlays/" + name)
+		srv.Handle("GET "+page, auth.Read, s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(o(p).ServePage) }), page, p.title("Radar overlay "+name))
+		srv.Handle("GET "+page+"/latest.jpg", auth.Read, s.serve(func(p *pipeline) http.Handler { return http.HandlerFunc(o(p).ServeImage) }), "", "")
+	}
+	if p.fox != nil {
+		srv.Handle("GET "+p.route("/foxglove"), auth.Read, s.serve(func(p *pipeline) http.Handler { return p.fox }), "", "")
+	}
+}
+
+// run records until ctx is cancelled or a write error stops the session,
+// then closes it and runs the hooks. It returns the error that stopped
+// the session, if any.
+func (p *pipeline) run(ctx context.Context) error {
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+	go func() {
+		select {
+		case <-p.recording.Failed():
+			p.log.Errorf("recording: stopping after write error: %v", p.recording.Err())
+			abort()
+		case <-ctx.Done():
+		}
+	}()
+
+	fanout := p.fanout
+	var fusedCSV <-chan models.FusedRecord
+	var sinks sync.WaitGroup
+	for _, o := range p.storage.FusedOutputs {
+		ch := fanout.Add(o.Name, o.RateHz, o.BufferSize)
+		switch o.Sink {
+		case utils.FusedSinkCSV:
+			fusedCSV = ch
+		case utils.FusedSinkMQTT:
+			pub := views.NewMQTTFusedPublisher(o, p.log)
+			sinks.Add(1)
+			go func() {
+				defer sinks.Done()
+				pub.Run(ch)
+			}()
+		case utils.FusedSinkArrow:
+			srv := p.arrow[o.Name]
+			sinks.Add(1)
+			go func() {
+				defer sinks.Done()
+				srv.Run(ch)
+			}()
+		}
+	}
+	if p.alerts != nil {
+		ch := fanout.Add("alerts", p.storage.Alerts.RateHz, 64)
+		sinks.Add(1)
+		go func() {
+			defer sinks.Done()
+			p.alerts.Run(ch)
+		}()
+	}
+
+	if p.opts.dryRun {
+		p.log.Infof("dry run: nothing is written")
+	} else {
+		p.log.Infof("recording session %s", p.recording.Dir())
+	}
+	if p.sensors.Profile.Name != "" {
+		p.log.Infof("profile %s", p.sensors.Profile.Name)
+	}
+	p.readers.Start(ctx)
+	go p.readers.LogStats(ctx, p.opts.statsInterval)
+	go fanout.LogStats(ctx, p.opts.statsInterval)
+	go p.recording.LogStats(ctx, p.opts.statsInterval)
+	p.recording.SampleSystem(ctx)
+	go p.fusion.Run(ctx)
+	go fanout.Run()
+	reported := make(chan struct{})
+	if p.telemetry != nil {
+		go func() {
+			defer close(reported)
+			p.telemetry.Run(ctx)
+		}()
+	} else {
+		close(reported)
+	}
+	recorded := make(chan struct{})
+	go func() {
+		defer close(recorded)
+		p.recording.Run(fusedCSV)
+	}()
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-p.dump:
+			p.readers.LogState()
+			fanout.LogState()
+			p.recording.LogState()
+		}
+	}
+
+	// Every stage gets until the stop deadline to wind down; those still
+	// running then are reported and left behind.
+	timeout := time.Duration(p.storage.StopTimeoutS) * time.Second
+	deadline := time.Now().Add(timeout)
+	unstopped := p.readers.Stop(deadline)
+	if !utils.WaitUntil(deadline, func() { <-recorded }) {
+		unstopped = append(unstopped, "fusion")
+	}
+	if !utils.WaitUntil(deadline, sinks.Wait) {
+		unstopped = append(unstopped, "fused_outputs")
+	}
+	if !utils.WaitUntil(deadline, func() { <-reported }) {
+		unstopped = append(unstopped, utils.StageTelemetry)
+	}
+	if len(unstopped) > 0 {
+		p.log.Errorf("stop: %s still running after %v, closing the session without them", strings.Join(unstopped, ", "), timeout)
+		p.recording.MarkUnstopped(unstopped...)
+	}
+	if p.opts.stopNote != nil && !p.rotating.Load() {
+		p.recording.AddNote("stop", p.opts.stopNote())
+	}
+	p.stages.LogSummary(p.log)
+	// The pending writes get a full timeout of their own.
+	p.recording.Stop(time.Now().Add(timeout))
+	type closer struct {
+		name  string
+		close func()
+	}
+	var closers []closer
+	if p.publisher != nil {
+		closers = append(closers, closer{utils.StageZMQ, func() { p.publisher.Close() }})
+	}
+	if p.thumbs != nil {
+		closers = append(closers, closer{utils.StageThumbnails, p.thumbs.Close})
+	}
+	if p.bridge != nil {
+		closers = append(closers, closer{utils.StageFoxglove, func() { p.bridge.Close() }})
+	}
+	deadline = time.Now().Add(timeout)
+	for _, c := range closers {
+		if !utils.WaitUntil(deadline, c.close) {
+			p.log.Errorf("stop: %s still closing after %v", c.name, timeout)
+		}
+	}
+	p.dryRunReport()
+	// A forced stop does not wait for hooks or packing either.
+	if len(p.storage.Hooks.Commands) > 0 && !p.opts.dryRun && !utils.Forced() {
+		runner := hooks.NewRunner(p.storage.Hooks, p.log)
+		runner.Submit(p.recording.Dir())
+		if !utils.WaitUnlessForced(runner.Wait) {
+			p.log.Warnf("stop: hooks of %s left running", filepath.Base(p.recording.Dir()))
+		}
+	}
+	if p.storage.Pack.Enabled && !p.opts.dryRun && !utils.Forced() {
+		p.packSession()
+	}
+	return p.recording.Err()
+}
+
+// packSession bundles the closed session into one archive, removing the
+// session directory once the archive checked out if configured. A session
+// a hook moved or removed is left alone.
+func (p *pipeline) packSession() {
+	dir := p.recording.Dir()
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+	cfg := p.storage.Pack
+	out := cfg.Dir
+	if out == "" {
+		out = filepath.Dir(dir)
+	}
+	start := time.Now()
+	archive, err := pack.Pack(dir, out, cfg.Format)
+	if err != nil {
+		p.log.Errorf("pack: %s: %v", filepath.Base(dir), err)
+		return
+	}
+	p.log.Infof("pack: %s in %.1f s", archive, time.Since(start).Seconds())
+	if cfg.Remove {
+		if err := os.RemoveAll(dir); err != nil {
+			p.log.Errorf("pack: %v", err)
+		}
+	}
+}
+
+// telemetryTarget names where the telemetry goes, for the log.
+func telemetryTarget(t utils.TelemetryConfig) string {
+	if t.Transport == utils.TelemetryMQTT {
+		return t.MQTT.Broker + " on " + t.MQTT.Topic
+	}
+	return t.URL
+}
+
+// dryRunReport prints the dry-run report of the pipeline, under its name
+// when it has one.
+func (p *pipeline) dryRunReport() {
+	if p.name != "" && p.opts.dryRun {
+		fmt.Fprintf(os.Stdout, "== %s\n", p.name)
+	}
+	p.recording.DryRunReport(os.Stdout)
+}
+
+// pipelinePrefix returns the log prefix of a named pipeline.
+func pipelinePrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "[" + name + "] "
+}
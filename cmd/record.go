@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/metrics"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/power"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/web"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// runRecord implements "sensor-logger record": run the sensors of one
+// pipeline, or of every pipeline of -pipelines, and record them into
+// sessions until interrupted or -duration is up.
+func runRecord(args []string) int {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	var files configFlags
+	files.sensorsFlags(fs)
+	files.storageFlag(fs, "storage config file")
+	pipelinesPath := fs.String("pipelines", "", "run the pipelines listed in this file side by side instead of -sensors and -storage")
+	logFile := fs.String("log-file", "", "also write the log to this file")
+	logLevel := fs.String("log-level", "info", "debug, info, warn or error")
+	duration := fs.Duration("duration", 0, "stop after this long (0 = until interrupted)")
+	statsInterval := fs.Duration("stats-interval", 10*time.Second, "reader stats logging interval")
+	httpAddr := fs.String("http-addr", "", "serve metrics, thumbnails and the status page on this address, e.g. :9100")
+	fs.StringVar(httpAddr, "metrics-addr", "", "same as -http-addr")
+	dryRun := fs.Bool("dry-run", false, "run sensors and fusion but only count what would be recorded")
+	tags := fs.String("tags", "", "comma-separated tags for the sessions, e.g. rain,night,highway")
+	note := fs.String("note", "", "note taken as the sessions start")
+	askNote := fs.Bool("ask-note", false, "ask on the terminal for a note as the sessions start and stop")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger [record] [-sensors file] [-storage file] [-profile p] [-pipelines file] [-duration d] [-dry-run] [-http-addr addr] [-tags t,...] [-note text]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := utils.InitLogger(*logFile, *logLevel); err != nil {
+		utils.L().Errorf("logger: %v", err)
+		return 1
+	}
+	log := utils.L()
+	if *statsInterval <= 0 {
+		log.Errorf("-stats-interval must be positive")
+		return 1
+	}
+	configs := []utils.PipelineConfig{{Sensors: *files.sensorsPath, Storage: *files.storagePath, Profile: *files.profile}}
+	if *pipelinesPath != "" {
+		var err error
+		if configs, err = utils.LoadPipelinesConfig(*pipelinesPath); err != nil {
+			log.Errorf("config: %v", err)
+			return 1
+		}
+	}
+	type loaded struct {
+		sensors *utils.SensorsConfig
+		storage *utils.StorageConfig
+	}
+	var cfgs []loaded
+	baseDirs := map[string]string{}
+	for _, c := range configs {
+		sensorsCfg, err := utils.LoadSensorsConfig(c.Sensors, c.Profile)
+		if err != nil {
+			log.Errorf("config: %v", err)
+			return 1
+		}
+		storageCfg, err := utils.LoadStorageConfig(c.Storage, sensorsCfg.Profile)
+		if err != nil {
+			log.Errorf("config: %v", err)
+			return 1
+		}
+		dir := filepath.Clean(storageCfg.BaseDir)
+		if other, ok := baseDirs[dir]; ok {
+			log.Errorf("config: pipelines %s and %s both record to %s", other, c.Name, dir)
+			return 1
+		}
+		baseDirs[dir] = c.Name
+		cfgs = append(cfgs, loaded{sensorsCfg, storageCfg})
+	}
+	// The clock guard is process-wide: the first pipeline sets it.
+	clock := cfgs[0].storage.Clock
+	for i, c := range cfgs[1:] {
+		if c.storage.Clock != clock {
+			log.Warnf("config: pipeline %s: clock settings differ from %s's, which apply to every pipeline", configs[i+1].Name, configs[0].Name)
+		}
+	}
+	utils.SetClockGuard(clock.Mode, time.Duration(clock.JumpMs)*time.Millisecond)
+
+	opts := runOptions{duration: *duration, statsInterval: *statsInterval, dryRun: *dryRun, serve: *httpAddr != "",
+		tags: []string{*tags}, startNote: *note}
+	var monitor *power.Monitor
+	if *askNote {
+		if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			log.Warnf("-ask-note: stdin is not a terminal, not asking for notes")
+		} else {
+			in := bufio.NewReader(os.Stdin)
+			if opts.startNote == "" {
+				opts.startNote = ask(in, "Note on the session (Enter to skip): ")
+			}
+			// Asked once for every pipeline, but not with the supply failing.
+			opts.stopNote = sync.OnceValue(func() string {
+				if monitor != nil && monitor.Tripped() {
+					return ""
+				}
+				return ask(in, "\nNote on the session as it stops (Enter to skip): ")
+			})
+		}
+	}
+	var slots []*slot
+	for i, c := range cfgs {
+		p, err := newPipeline(configs[i].Name, c.sensors, c.storage, opts, nil, log)
+		if err != nil {
+			log.Errorf("%s%v", pipelinePrefix(configs[i].Name), err)
+			return 1
+		}
+		slots = append(slots, &slot{p: p})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	// A failing supply closes the sessions as SIGTERM would.
+	if pw := cfgs[0].storage.Power; pw.Enabled {
+		var cut context.CancelFunc
+		ctx, cut = context.WithCancel(ctx)
+		defer cut()
+		var buses []*events.Bus
+		for _, s := range slots {
+			buses = append(buses, s.p.bus)
+		}
+		monitor = power.NewMonitor(pw, buses, log)
+		go monitor.Run(ctx, cut)
+	}
+
+	if *httpAddr != "" {
+		// The server is guarded by the auth settings of the first pipeline.
+		surfaceAuth, err := auth.New(cfgs[0].storage.Auth)
+		if err != nil {
+			log.Errorf("config: %v", err)
+			return 1
+		}
+		reg := metrics.NewRegistry()
+		srv := web.NewServer(surfaceAuth)
+		srv.Handle("GET /metrics", auth.Read, reg, "/metrics", "Prometheus metrics")
+		for _, s := range slots {
+			s.register(srv, reg)
+		}
+		if _, err := srv.Serve(*httpAddr); err != nil {
+			log.Errorf("%v", err)
+			return 1
+		}
+		scheme := "http"
+		if surfaceAuth.TLS() != nil {
+			scheme = "https"
+		}
+		log.Infof("status page on %s://%s/", scheme, *httpAddr)
+		for _, s := range slots {
+			if p := s.p; p.fox != nil {
+				p.log.Infof("foxglove: connect Foxglove Studio to %s", foxgloveURL(surfaceAuth, *httpAddr, p.route("/foxglove")))
+			}
+		}
+		if !surfaceAuth.Required() {
+			log.Warnf("http: no auth configured, the status page is open to anyone who can reach %s", *httpAddr)
+		}
+	}
+
+	go handleSignals(ctx, slots, log)
+	go forceOnSignal(ctx, log)
+	failed := false
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, s := range slots {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.run(ctx); err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if monitor != nil && monitor.Tripped() && cfgs[0].storage.Power.Shutdown {
+		if err := monitor.Shutdown(); err != nil {
+			log.Errorf("power: %v", err)
+			failed = true
+		}
+	}
+	if failed || utils.Forced() {
+		return 1
+	}
+	return 0
+}
+
+// ask prints prompt on stderr and returns the line read from in.
+func ask(in *bufio.Reader, prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	line, _ := in.ReadString('\n')
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/controller"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/export"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/foxglove"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/web"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// runReplay implements "sensor-logger replay <session dir>": play a
+// recorded session to Foxglove Studio over the Foxglove WebSocket bridge.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var c configFlags
+	c.storageFlag(fs, "storage config file, for auth and the foxglove queue")
+	httpAddr := fs.String("http-addr", ":9100", "serve the bridge at /foxglove on this address")
+	rate := fs.Float64("rate", 1, "playback speed relative to the recording")
+	from := fs.String("from", "", "replay from this time: RFC 3339, Unix seconds or an offset from the session start such as 1h20m")
+	to := fs.String("to", "", "replay up to this time, in the same forms as -from")
+	sensors := fs.String("sensors", "", "comma-separated sensors to replay (default all): "+strings.Join(export.Sensors, ","))
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger replay [-rate 1] [-http-addr :9100] [-from t] [-to t] [-sensors list] <session dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+	log := utils.L()
+	if *rate <= 0 {
+		log.Errorf("replay: -rate must be positive")
+		return 2
+	}
+	filter, err := exportFilter(dir, *from, *to, *sensors)
+	if err != nil {
+		log.Errorf("replay: %v", err)
+		return 2
+	}
+	storageCfg, err := c.storage(nil)
+	if err != nil {
+		log.Errorf("config: %v", err)
+		return 1
+	}
+	surfaceAuth, err := auth.New(storageCfg.Auth)
+	if err != nil {
+		log.Errorf("config: %v", err)
+		return 1
+	}
+	var cal utils.CalibrationConfig
+	if c, err := utils.LoadCalibration(filepath.Join(dir, views.CalibYAML)); err == nil {
+		cal = *c
+	} else if !os.IsNotExist(err) {
+		log.Warnf("replay: %v", err)
+	}
+
+	fox := foxglove.NewServer("sensor-logger replay "+filepath.Base(filepath.Clean(dir)), storageCfg.Foxglove.Queue, log)
+	bridge := views.NewFoxgloveBridge(fox, cal, log)
+	defer bridge.Close()
+	replay, err := controller.NewReplayController(dir, filter, *rate, bridge, log)
+	if err != nil {
+		log.Errorf("replay: %v", err)
+		return 1
+	}
+	if replay.Samples() == 0 {
+		log.Errorf("replay: no samples to play in %s", dir)
+		return 1
+	}
+	srv := web.NewServer(surfaceAuth)
+	srv.Handle("GET /foxglove", auth.Read, fox, "", "")
+	if _, err := srv.Serve(*httpAddr); err != nil {
+		log.Errorf("%v", err)
+		return 1
+	}
+	log.Infof("replay: %d samples over %v at %gx; waiting for Foxglove Studio on %s",
+		replay.Samples(), replay.Span().Round(time.Second), *rate, foxgloveURL(surfaceAuth, *httpAddr, "/foxglove"))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-fox.Ready():
+	case <-ctx.Done():
+		return 0
+	}
+	log.Infof("replay: playing")
+	replay.Run(ctx)
+	log.Infof("replay: done")
+	return 0
+}
+
+// foxgloveURL returns the URL Foxglove Studio connects to for the bridge
+// served on addr.
+func foxgloveURL(a *auth.Authenticator, addr, path string) string {
+	scheme := "ws"
+	if a.TLS() != nil {
+		scheme = "wss"
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return scheme + "://" + addr + path
+}
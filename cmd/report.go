@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/report"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// runReport implements "sensor-logger report <session dir>": write a summary
+// page with plots into the session directory.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "html", "html or md")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger report [-format html|md] <session dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+	if _, ok := report.Files[*format]; !ok {
+		utils.L().Errorf("report: unknown format %q (html or md)", *format)
+		return 2
+	}
+	path, err := report.Write(dir, *format)
+	if err != nil {
+		utils.L().Errorf("report: %v", err)
+		return 1
+	}
+	utils.L().Infof("report written to %s", path)
+	return 0
+}
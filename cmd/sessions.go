@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/catalog"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/hooks"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/pack"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/privacy"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// runSessions implements "sensor-logger sessions list|info|rm|hooks|repair|redact|decrypt|pack|unpack":
+// browse, prune and fix up the sessions under the storage base directory.
+func runSessions(args []string) int {
+	fs := flag.NewFlagSet("sessions", flag.ExitOnError)
+	var c configFlags
+	c.storageFlag(fs, "storage config file")
+	baseDir := fs.String("dir", "", "sessions directory (overrides base_dir)")
+	yes := fs.Bool("y", false, "rm: do not ask for confirmation")
+	archive := fs.String("archive", "", "rm: move the sessions into this directory instead of deleting them")
+	tags := fs.String("tags", "", "list: only the sessions tagged with all of these, e.g. rain,night")
+	zones := fs.String("zones", "", "redact: GeoJSON file of the polygons to redact")
+	blur := fs.Bool("blur", false, "redact: pixelate the frames inside the polygons instead of removing them")
+	keyFile := fs.String("key-file", "", "decrypt: group key file (default location_encryption.key_file)")
+	format := fs.String("format", "", "pack: tar.zst or zip (default pack.format)")
+	out := fs.String("out", "", "pack: directory of the archives (default pack.dir, else the sessions directory)")
+	remove := fs.Bool("remove", false, "pack: delete each session once its archive checked out")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger sessions list [-tags t,...] | info <session> | rm [-y] [-archive dir] <session>... | hooks <session>... | repair <session>... | redact -zones file [-blur] <session>... | decrypt [-key-file file] <session>... | pack [-format f] [-out dir] [-remove] <session>... | unpack <archive>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	// Flags may also follow the command.
+	cmd := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	names := fs.Args()
+	cfg, err := c.storage(nil)
+	if err != nil {
+		utils.L().Errorf("config: %v", err)
+		return 1
+	}
+	if *baseDir == "" {
+		*baseDir = cfg.BaseDir
+	}
+
+	switch {
+	case cmd == "list" && len(names) == 0:
+		return sessionsList(*baseDir, utils.CleanTags([]string{*tags}))
+	case cmd == "info" && len(names) == 1:
+		return sessionsInfo(*baseDir, names[0])
+	case cmd == "rm" && len(names) > 0:
+		return sessionsRemove(*baseDir, names, *archive, *yes)
+	case cmd == "hooks" && len(names) > 0:
+		return sessionsHooks(*baseDir, names, cfg.Hooks)
+	case cmd == "repair" && len(names) > 0:
+		return sessionsRepair(*baseDir, names)
+	case cmd == "redact" && len(names) > 0 && *zones != "":
+		return sessionsRedact(*baseDir, names, *zones, *blur)
+	case cmd == "decrypt" && len(names) > 0:
+		if *keyFile == "" {
+			*keyFile = cfg.LocationEncryption.KeyFile
+		}
+		if *keyFile == "" {
+			break
+		}
+		return sessionsDecrypt(*baseDir, names, *keyFile)
+	case cmd == "pack" && len(names) > 0:
+		if *format == "" {
+			*format = cfg.Pack.Format
+		}
+		if *out == "" {
+			*out = cfg.Pack.Dir
+		}
+		if *out == "" {
+			*out = *baseDir
+		}
+		return sessionsPack(*baseDir, names, *format, *out, *remove)
+	case cmd == "unpack" && len(names) > 0:
+		return sessionsUnpack(*baseDir, names)
+	}
+	fs.Usage()
+	return 2
+}
+
+// sessionsList lists the sessions carrying all of tags.
+func sessionsList(baseDir string, tags []string) int {
+	sessions, err := catalog.Scan(baseDir)
+	if err != nil {
+		utils.L().Errorf("sessions: %v", err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tSTART (UTC)\tDURATION\tDISTANCE\tSENSORS\tSIZE\tSTATUS\tTAGS")
+	var n int
+	var total int64
+	for _, s := range sessions {
+		if !s.HasTags(tags) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Start.Format("2006-01-02 15:04:05"),
+			duration(s), distance(s), strings.Join(s.Sensors, ","), utils.FormatBytes(s.Bytes), status(s), strings.Join(s.Tags(), ","))
+		n++
+		total += s.Bytes
+	}
+	w.Flush()
+	fmt.Printf("%d sessions, %s\n", n, utils.FormatBytes(total))
+	return 0
+}
+
+func sessionsInfo(baseDir, name string) int {
+	s, err := catalog.Open(baseDir, name)
+	if err != nil {
+		utils.L().Errorf("sessions: %v", err)
+		return 1
+	}
+	fmt.Printf("session   %s\n", s.Name)
+	fmt.Printf("path      %s\n", s.Dir)
+	fmt.Printf("start     %s\n", s.Start.Format(time.RFC3339))
+	fmt.Printf("duration  %s\n", duration(s))
+	fmt.Printf("sensors   %s\n", strings.Join(s.Sensors, ", "))
+	fmt.Printf("size      %s in %d files\n", utils.FormatBytes(s.Bytes), s.Files)
+	fmt.Printf("status    %s\n", status(s))
+	m := s.Manifest
+	if m == nil {
+		return 0
+	}
+	if m.SessionID != "" {
+		fmt.Printf("id        %s\n", m.SessionID)
+	}
+	if m.Profile != "" {
+		fmt.Printf("profile   %s\n", m.Profile)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Printf("tags      %s\n", strings.Join(m.Tags, ", "))
+	}
+	if len(m.Custom) > 0 {
+		var cols []string
+		for name, value := range m.Custom {
+			cols = append(cols, name+"="+value)
+		}
+		sort.Strings(cols)
+		fmt.Printf("custom    %s\n", strings.Join(cols, ", "))
+	}
+	if len(m.Notes) > 0 {
+		fmt.Println("\nnotes")
+		for _, n := range m.Notes {
+			at := ""
+			if n.At != "" {
+				at = " (" + n.At + ")"
+			}
+			fmt.Printf("  %s%s  %s\n", n.Time.Format(time.RFC3339), at, n.Text)
+		}
+	}
+	if len(m.Partial) > 0 {
+		fmt.Printf("partial   %s (not finalized)\n", strings.Join(m.Partial, ", "))
+	}
+	fmt.Println("\nrows")
+	files := make([]string, 0, len(m.Rows))
+	for f := range m.Rows {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, f := range files {
+		fmt.Fprintf(w, "  %s\t%d\t\n", f, m.Rows[f])
+	}
+	w.Flush()
+	fmt.Println("\ngaps")
+	for _, line := range m.GapReport {
+		fmt.Printf("  %s\n", line)
+	}
+	if len(m.Interruptions) > 0 {
+		fmt.Println("\ninterruptions (logger restarts)")
+		for _, in := range m.Interruptions {
+			fmt.Printf("  %s, down %.1f s\n", in.Start.Format(time.RFC3339), in.DurationS)
+		}
+	}
+	if r := m.FixReport(); r != "" {
+		fmt.Printf("\ngps fixes\n  %s\n", r)
+	}
+	if m.Odometer != nil {
+		fmt.Printf("\nodometer\n  %s\n", m.Odometer)
+	}
+	if lines := m.AnomalyReport(); len(lines) > 0 {
+		fmt.Println("\ninvalid records")
+		for _, line := range lines {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if len(m.Hooks) > 0 {
+		fmt.Println("\nhooks")
+		for _, h := range m.Hooks {
+			switch {
+			case h.Skipped:
+				fmt.Printf("  %s: skipped\n", h.Name)
+			case h.Error != "":
+				fmt.Printf("  %s: failed after %.1f s: %s\n", h.Name, h.DurationS, h.Error)
+			default:
+				fmt.Printf("  %s: ok in %.1f s\n", h.Name, h.DurationS)
+			}
+		}
+	}
+	return 0
+}
+
+func sessionsRemove(baseDir string, names []string, archive string, yes bool) int {
+	var sessions []*catalog.Session
+	for _, name := range names {
+		s, err := catalog.Open(baseDir, name)
+		if err != nil {
+			utils.L().Errorf("sessions: %v", err)
+			return 1
+		}
+		sessions = append(sessions, s)
+	}
+	if !yes {
+		verb := "Delete"
+		if archive != "" {
+			verb = "Move to " + archive
+		}
+		for _, s := range sessions {
+			fmt.Printf("  %s (%s)\n", s.Name, utils.FormatBytes(s.Bytes))
+		}
+		fmt.Printf("%s %d sessions? [y/N] ", verb, len(sessions))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.TrimSpace(strings.ToLower(answer)); a != "y" && a != "yes" {
+			return 1
+		}
+	}
+	code := 0
+	for _, s := range sessions {
+		var err error
+		done := "removed"
+		if archive != "" {
+			err = catalog.Archive(s, archive)
+			done = "archived to " + archive + ":"
+		} else {
+			err = catalog.Remove(s)
+		}
+		if err != nil {
+			utils.L().Errorf("sessions: %s: %v", s.Name, err)
+			code = 1
+			continue
+		}
+		utils.L().Infof("sessions: %s %s", done, s.Name)
+	}
+	return code
+}
+
+func duration(s *catalog.Session) string {
+	if s.Manifest == nil {
+		return "-"
+	}
+	return s.Duration.Round(time.Second).String()
+}
+
+// distance returns the distance travelled in the session, "-" without
+// an odometer.
+func distance(s *catalog.Session) string {
+	if s.Manifest == nil || s.Manifest.Odometer == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f km", s.Manifest.Odometer.DistanceM/1000)
+}
+
+func status(s *catalog.Session) string {
+	if s.Manifest == nil {
+		return "incomplete"
+	}
+	if len(s.Manifest.Partial) > 0 {
+		return "partial files"
+	}
+	return "ok"
+}
+
+// sessionsHooks runs the configured post-processing commands again on
+// closed sessions, replacing the results in their manifests.
+func sessionsHooks(baseDir string, names []string, cfg utils.HooksConfig) int {
+	if len(cfg.Commands) == 0 {
+		utils.L().Errorf("sessions: no hooks configured")
+		return 1
+	}
+	var sessions []*catalog.Session
+	for _, name := range names {
+		s, err := catalog.Open(baseDir, name)
+		if err != nil {
+			utils.L().Errorf("sessions: %v", err)
+			return 1
+		}
+		if s.Manifest == nil {
+			utils.L().Errorf("sessions: %s did not close cleanly", s.Name)
+			return 1
+		}
+		sessions = append(sessions, s)
+	}
+	runner := hooks.NewRunner(cfg, utils.L())
+	for _, s := range sessions {
+		runner.Submit(s.Dir)
+	}
+	runner.Wait()
+	if runner.Failed() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// sessionsRepair clears the references to frames, clouds and grids that a
+// crash left unwritten, for sessions that will not be resumed.
+func sessionsRepair(baseDir string, names []string) int {
+	code := 0
+	for _, name := range names {
+		s, err := catalog.Open(baseDir, name)
+		if err != nil {
+			utils.L().Errorf("sessions: %v", err)
+			return 1
+		}
+		cleared, err := views.ReconcileBlobs(s.Dir)
+		if err != nil {
+			utils.L().Errorf("sessions: %s: %v", s.Name, err)
+			code = 1
+			continue
+		}
+		utils.L().Infof("sessions: %s: cleared %d references to missing files", s.Name, len(cleared))
+	}
+	return code
+}
+
+// sinkFiles are the outputs of the sinks other than csv, which redact
+// and decrypt leave as recorded.
+var sinkFiles = []string{"*.jsonl", "*.parquet", "*.sqlite", "*.mcap", "*.bag"}
+
+// sessionsRedact strips what was recorded inside the polygons of the
+// GeoJSON file zones from closed sessions.
+func sessionsRedact(baseDir string, names []string, zones string, blur bool) int {
+	fences, err := privacy.LoadGeofences(zones)
+	if err != nil {
+		utils.L().Errorf("sessions: %v", err)
+		return 1
+	}
+	code := 0
+	for _, name := range names {
+		s, err := catalog.Open(baseDir, name)
+		if err != nil {
+			utils.L().Errorf("sessions: %v", err)
+			return 1
+		}
+		r, err := privacy.Redact(s.Dir, fences, blur)
+		if err != nil {
+			utils.L().Errorf("sessions: %s: %v", s.Name, err)
+			code = 1
+			continue
+		}
+		done := "removed"
+		if blur {
+			done = "pixelated"
+		}
+		utils.L().Infof("sessions: %s: %d gps rows removed, %d fused rows cleared, %d frames %s", s.Name, r.GPSRows, r.FusedRows, r.Frames, done)
+		for _, pattern := range sinkFiles {
+			left, _ := filepath.Glob(filepath.Join(s.Dir, pattern))
+			for _, f := range left {
+				utils.L().Warnf("sessions: %s: %s is left as recorded", s.Name, filepath.Base(f))
+			}
+		}
+	}
+	return code
+}
+
+// sessionsDecrypt writes the encrypted location columns of closed
+// sessions back in the clear with the group key in keyFile.
+func sessionsDecrypt(baseDir string, names []string, keyFile string) int {
+	group, err := privacy.LoadGroupKey(keyFile)
+	if err != nil {
+		utils.L().Errorf("sessions: %v", err)
+		return 1
+	}
+	code := 0
+	for _, name := range names {
+		s, err := catalog.Open(baseDir, name)
+		if err != nil {
+			utils.L().Errorf("sessions: %v", err)
+			return 1
+		}
+		n, err := privacy.DecryptLocation(s.Dir, group)
+		if err != nil {
+			utils.L().Errorf("sessions: %s: %v", s.Name, err)
+			code = 1
+			continue
+		}
+		utils.L().Infof("sessions: %s: %d location values decrypted", s.Name, n)
+		for _, pattern := range sinkFiles {
+			left, _ := filepath.Glob(filepath.Join(s.Dir, pattern))
+			for _, f := range left {
+				utils.L().Warnf("sessions: %s: %s is left as recorded", s.Name, filepath.Base(f))
+			}
+		}
+	}
+	return code
+}
+
+// runPack implements "sensor-logger pack", the same as "sessions pack".
+func runPack(args []string) int {
+	return runSessions(append([]string{"pack"}, args...))
+}
+
+// sessionsPack bundles closed sessions into one archive each in out.
+func sessionsPack(baseDir string, names []string, format, out string, remove bool) int {
+	if format != pack.TarZst && format != pack.Zip {
+		utils.L().Errorf("sessions: -format must be %s or %s, got %q", pack.TarZst, pack.Zip, format)
+		return 2
+	}
+	code := 0
+	for _, name := range names {
+		s, err := catalog.Open(baseDir, name)
+		if err != nil {
+			utils.L().Errorf("sessions: %v", err)
+			return 1
+		}
+		archive, err := pack.Pack(s.Dir, out, format)
+		if err != nil {
+			utils.L().Errorf("sessions: %s: %v", s.Name, err)
+			code = 1
+			continue
+		}
+		fi, _ := os.Stat(archive)
+		utils.L().Infof("sessions: %s packed into %s (%s of %s)", s.Name, archive, utils.FormatBytes(fi.Size()), utils.FormatBytes(s.Bytes))
+		if remove {
+			if err := catalog.Remove(s); err != nil {
+				utils.L().Errorf("sessions: %s: %v", s.Name, err)
+				code = 1
+			}
+		}
+	}
+	return code
+}
+
+// sessionsUnpack extracts packed sessions into baseDir, checking every
+// file against its checksum.
+func sessionsUnpack(baseDir string, archives []string) int {
+	code := 0
+	for _, archive := range archives {
+		dir, err := pack.Unpack(archive, baseDir)
+		if err != nil {
+			utils.L().Errorf("sessions: %s: %v", archive, err)
+			code = 1
+			continue
+		}
+		utils.L().Infof("sessions: %s unpacked into %s", archive, dir)
+	}
+	return code
+}
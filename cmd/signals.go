@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// slot runs the pipeline of one configuration, replaced by a new one each
+// time SIGUSR1 rotates its session.
+type slot struct {
+	mu     sync.Mutex
+	p      *pipeline
+	rotate context.CancelFunc // closes the current session, nil before run
+}
+
+func (s *slot) current() *pipeline {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.p
+}
+
+// serve returns a handler passing the requests to the one h picks from the
+// current pipeline.
+func (s *slot) serve(h func(p *pipeline) http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(s.current()).ServeHTTP(w, r)
+	})
+}
+
+// run runs the pipeline until ctx is cancelled or a write error stops its
+// session, opening the next session whenever the current one is rotated.
+// It returns the error that stopped the last session, if any.
+func (s *slot) run(ctx context.Context) error {
+	for {
+		session, rotate := context.WithCancel(ctx)
+		s.mu.Lock()
+		p := s.p
+		s.rotate = rotate
+		s.mu.Unlock()
+		err := p.run(session)
+		rotate()
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+		next, err := p.next()
+		if err != nil {
+			p.log.Errorf("rotate: %v", err)
+			return err
+		}
+		s.mu.Lock()
+		s.p = next
+		s.mu.Unlock()
+	}
+}
+
+// next opens the session that follows p's.
+func (p *pipeline) next() (*pipeline, error) {
+	// Session names are to the second.
+	for utils.SessionName(utils.Now()) == filepath.Base(p.recording.Dir()) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return newPipeline(p.name, p.sensors, p.storage, p.opts, p, p.log)
+}
+
+// rotateSession closes the current session of the slot; run then opens
+// the next one. The samples read while one closes and the next opens are
+// lost.
+func (s *slot) rotateSession() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rotate == nil || s.p.rotating.Load() {
+		return
+	}
+	s.p.log.Infof("rotate: closing %s for a new session", filepath.Base(s.p.recording.Dir()))
+	s.p.rotating.Store(true)
+	s.rotate()
+}
+
+// dumpState asks the current pipeline of the slot to log its state.
+func (s *slot) dumpState() {
+	select {
+	case s.current().dump <- struct{}{}:
+	default:
+	}
+}
+
+// handleSignals rotates the sessions of every slot on SIGUSR1 and dumps
+// the goroutines of the process and the state of every pipeline to the
+// log on SIGUSR2, until ctx is cancelled.
+func handleSignals(ctx context.Context, slots []*slot, log utils.Logger) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-c:
+			switch sig {
+			case syscall.SIGUSR1:
+				for _, s := range slots {
+					s.rotateSession()
+				}
+			case syscall.SIGUSR2:
+				logGoroutines(log)
+				for _, s := range slots {
+					s.dumpState()
+				}
+			}
+		}
+	}
+}
+
+// forceGrace is how long a forced stop may take before the process exits
+// without closing the sessions.
+const forceGrace = 10 * time.Second
+
+// forceOnSignal waits for ctx, whose end stops the sessions, and forces
+// the stop on the next SIGINT or SIGTERM: the stop deadlines give way (see
+// utils.ForceStop), hooks and packing are skipped and the manifests note
+// the forced shutdown. Should closing the sessions hang even so, say in a
+// device driver, the process exits after forceGrace, or at once on a
+// third signal.
+func forceOnSignal(ctx context.Context, log utils.Logger) {
+	<-ctx.Done()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	log.Warnf("stop: interrupted again, giving up on what is still pending; exiting within %v, or now on another interrupt", forceGrace)
+	utils.ForceStop()
+	select {
+	case <-c:
+		log.Errorf("stop: exiting without closing the sessions")
+	case <-time.After(forceGrace):
+		log.Errorf("stop: sessions still closing %v after the forced stop, exiting without them", forceGrace)
+	}
+	os.Exit(1)
+}
+
+// logGoroutines logs the goroutines of the process, those with the same
+// stack once with their count.
+func logGoroutines(log utils.Logger) {
+	log.Infof("state: %d goroutines", runtime.NumGoroutine())
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			log.Infof("state goroutines: %s", line)
+		}
+	}
+}
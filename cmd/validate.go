@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/mcap"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// runValidate implements "sensor-logger validate": check the records of
+// jsonl and mcap sink files against the record schemas (views.RecordSchema),
+// or write the schemas out for other tools.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	schemaDir := fs.String("write-schemas", "", "write the record schemas as JSON Schema files into this directory instead")
+	maxShown := fs.Int("max-errors", 10, "invalid records listed per file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger validate [-max-errors n] <session dir | .jsonl | .mcap>... | validate -write-schemas <dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *schemaDir != "" {
+		if err := writeSchemas(*schemaDir); err != nil {
+			utils.L().Errorf("validate: %v", err)
+			return 1
+		}
+		return 0
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	var files []string
+	for _, arg := range fs.Args() {
+		if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+			found, _ := filepath.Glob(filepath.Join(arg, views.JSONLFile))
+			mcaps, _ := filepath.Glob(filepath.Join(arg, "session*.mcap"))
+			if len(found)+len(mcaps) == 0 {
+				utils.L().Errorf("validate: %s has no %s or session.mcap", arg, views.JSONLFile)
+				return 1
+			}
+			files = append(files, append(found, mcaps...)...)
+			continue
+		}
+		files = append(files, arg)
+	}
+
+	code := 0
+	for _, path := range files {
+		v := &validation{path: path, maxShown: *maxShown}
+		// The custom columns of fused records are in the manifest of
+		// their session.
+		if m, err := views.ReadManifest(filepath.Dir(path)); err == nil {
+			for name := range m.Custom {
+				v.custom = append(v.custom, name)
+			}
+		}
+		var err error
+		switch filepath.Ext(path) {
+		case ".jsonl":
+			err = v.jsonl()
+		case ".mcap":
+			err = v.mcap()
+		default:
+			err = errors.New("not a .jsonl or .mcap file")
+		}
+		if err != nil {
+			utils.L().Errorf("validate: %s: %v", path, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%s: %d records, %d invalid (schema v%d)\n", path, v.records, v.invalid, views.SchemaVersion)
+		if v.invalid > 0 {
+			code = 1
+		}
+	}
+	return code
+}
+
+// writeSchemas writes a <table>.json file per record schema into dir,
+// refusing to while the models and views.SchemaColumns disagree.
+func writeSchemas(dir string) error {
+	if err := views.CheckSchema(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, s := range views.RecordSchemas {
+		path := filepath.Join(dir, s.Table+".json")
+		if err := os.WriteFile(path, s.JSON(), 0o644); err != nil {
+			return err
+		}
+		utils.L().Infof("validate: wrote %s", path)
+	}
+	return nil
+}
+
+// validation counts the records of one file and lists the first invalid
+// ones.
+type validation struct {
+	path     string
+	maxShown int
+	records  int
+	invalid  int
+	// custom are the custom columns of the fused records.
+	custom []string
+}
+
+// check validates the record data of table at where, e.g. a line number.
+func (v *validation) check(where, table string, data []byte) {
+	v.records++
+	var problems []string
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var rec map[string]any
+	if err := dec.Decode(&rec); err != nil {
+		problems = []string{err.Error()}
+	} else {
+		if table == "" {
+			table, _ = rec["sensor"].(string)
+		}
+		if s, ok := views.LookupRecordSchema(table); ok {
+			if table == views.FusedTable {
+				s.Custom = v.custom
+			}
+			problems = s.Validate(rec)
+		} else {
+			problems = []string{fmt.Sprintf("unknown table %q", table)}
+		}
+	}
+	if len(problems) == 0 {
+		return
+	}
+	v.invalid++
+	if v.invalid <= v.maxShown {
+		if table != "" {
+			where += ": " + table
+		}
+		fmt.Printf("%s:%s: %s\n", v.path, where, strings.Join(problems, "; "))
+	}
+}
+
+func (v *validation) jsonl() error {
+	f, err := os.Open(v.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		v.check(fmt.Sprint(line), "", sc.Bytes())
+	}
+	return sc.Err()
+}
+
+func (v *validation) mcap() error {
+	r, err := mcap.Open(v.path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	noted := map[*mcap.Channel]bool{}
+	for {
+		m, err := r.Next()
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			utils.L().Warnf("validate: %s: cut short after %d messages, the session did not close cleanly", v.path, v.records)
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		table := strings.TrimPrefix(m.Channel.Topic, "/")
+		if !noted[m.Channel] {
+			noted[m.Channel] = true
+			if id := schemaID(m.Channel.Schema); id != "" {
+				if s, ok := views.LookupRecordSchema(table); ok && id != s.ID() {
+					utils.L().Warnf("validate: %s: %s was written with schema %s", v.path, m.Channel.Topic, id)
+				}
+			}
+		}
+		v.check(fmt.Sprintf("%s#%d", m.Channel.Topic, m.Seq), table, m.Data)
+	}
+}
+
+// schemaID returns the $id of a JSON Schema, "unversioned" for one
+// without, as written before the schemas had versions.
+func schemaID(s *mcap.Schema) string {
+	if s == nil || s.Encoding != "jsonschema" {
+		return ""
+	}
+	var doc struct {
+		ID string `json:"$id"`
+	}
+	if json.Unmarshal(s.Data, &doc) != nil || doc.ID == "" {
+		return "unversioned"
+	}
+	return doc.ID
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/pack"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// runVerify implements "sensor-logger verify": check that sessions hold
+// what their manifests say, and packed archives what their checksums say.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sensor-logger verify <session dir | .tar.zst | .zip>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	code := 0
+	for _, arg := range fs.Args() {
+		var problems []string
+		if pack.Format(arg) != "" {
+			if _, err := pack.Verify(arg); err != nil {
+				problems = append(problems, err.Error())
+			}
+		} else {
+			var err error
+			if problems, err = verifySession(arg); err != nil {
+				utils.L().Errorf("verify: %s: %v", arg, err)
+				code = 1
+				continue
+			}
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: ok\n", arg)
+			continue
+		}
+		code = 1
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", arg, p)
+		}
+	}
+	return code
+}
+
+// verifySession returns what is wrong with the session in dir: the
+// manifest missing or listing partial files, CSV files missing or not
+// holding the rows it counted, referenced frames, clouds and grids
+// missing, and files not matching pack.ChecksumFile when the session was
+// unpacked.
+func verifySession(dir string) ([]string, error) {
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, errors.New("not a session directory or packed archive")
+	}
+	var problems []string
+	m, err := views.ReadManifest(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		problems = append(problems, fmt.Sprintf("no %s, the session did not close cleanly", views.ManifestFile))
+	case err != nil:
+		return nil, err
+	default:
+		if len(m.Partial) > 0 {
+			problems = append(problems, "left partial: "+strings.Join(m.Partial, ", "))
+		}
+		if len(m.Unstopped) > 0 {
+			problems = append(problems, "closed with "+strings.Join(m.Unstopped, ", ")+" still running")
+		}
+		names := make([]string, 0, len(m.Rows))
+		for name := range m.Rows {
+			if strings.HasSuffix(name, ".csv") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			n, err := csvRows(filepath.Join(dir, name))
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				problems = append(problems, name+" is missing")
+			case err != nil:
+				problems = append(problems, err.Error())
+			case n != m.Rows[name]:
+				problems = append(problems, fmt.Sprintf("%s has %d rows, the manifest counted %d", name, n, m.Rows[name]))
+			}
+		}
+	}
+
+	refs, err := views.BlobRefs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var frames map[string]bool
+	var remote bool
+	if m != nil && m.FrameStore == views.FramesTar {
+		if frames, err = tarMembers(filepath.Join(dir, views.FramesTar)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", views.FramesTar, err))
+		}
+	} else if m != nil && m.FrameStore != "" {
+		remote = true
+	}
+	var missing []string
+	for file, paths := range refs {
+		for _, p := range paths {
+			switch {
+			case file == views.CameraCSV && remote:
+			case file == views.CameraCSV && frames != nil:
+				if !frames[p] {
+					missing = append(missing, p)
+				}
+			default:
+				if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+					missing = append(missing, p)
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		problems = append(problems, fmt.Sprintf("%d referenced files missing, the first %s", len(missing), missing[0]))
+	}
+	if remote {
+		utils.L().Infof("verify: %s: frames in %s not checked", filepath.Base(dir), m.FrameStore)
+	}
+
+	err = pack.VerifyDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		problems = append(problems, pack.ChecksumFile+": "+err.Error())
+	}
+	return problems, nil
+}
+
+// csvRows counts the data rows of the CSV file at path.
+func csvRows(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	var n int64
+	for {
+		if _, err := r.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		n++
+	}
+	return max(n-1, 0), nil
+}
+
+// tarMembers returns the names of the complete members of the tar archive
+// at path.
+func tarMembers(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	members := map[string]bool{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return members, err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return members, err
+		}
+		members[hdr.Name] = true
+	}
+}
@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/remote"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	agentDialTimeout = 5 * time.Second
+	agentMaxBackoff  = 10 * time.Second
+)
+
+// AgentController runs on an edge computer: it drains the local readers
+// and forwards every sample to a central logger's remote source, queueing
+// samples in memory while the link is down.
+type AgentController struct {
+	cfg     utils.AgentConfig
+	sensors *SensorsController
+	queue   *remote.Queue
+	log     utils.Logger
+	token   string
+	tls     *tls.Config
+}
+
+func NewAgentController(cfg utils.AgentConfig, sensors *SensorsController, log utils.Logger) (*AgentController, error) {
+	token, err := utils.ReadSecret(cfg.Token, cfg.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("token: %w", err)
+	}
+	tc, err := auth.ClientTLS(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	return &AgentController{
+		cfg:     cfg,
+		sensors: sensors,
+		log:     log,
+		queue:   remote.NewQueue(cfg.BufferMB << 20),
+		token:   token,
+		tls:     tc,
+	}, nil
+}
+
+// Run forwards samples until ctx is cancelled and the readers have stopped.
+func (a *AgentController) Run(ctx context.Context) {
+	s := a.sensors
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for v := range s.Mux.C {
+			s.Mux.Done(v)
+			switch v := v.(type) {
+			case models.CameraFrame:
+				a.queue.Push(&remote.Record{Camera: &v})
+			case models.GPSData:
+				a.queue.Push(&remote.Record{GPS: &v})
+			case models.IMUData:
+				a.queue.Push(&remote.Record{IMU: &v})
+			case models.LidarPacket:
+				a.queue.Push(&remote.Record{Lidar: &v})
+			case models.RadarScan:
+				a.queue.Push(&remote.Record{Radar: &v})
+			case models.EnvData:
+				a.queue.Push(&remote.Record{Env: &v})
+			}
+		}
+	}()
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := a.session(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		n, bytes := a.queue.Len()
+		a.log.Warnf("agent: link to %s down: %v (queued %d records, %d KiB, dropped %d)",
+			a.cfg.Server, err, n, bytes>>10, a.queue.Dropped())
+		if time.Since(start) > agentMaxBackoff {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, agentMaxBackoff)
+	}
+	<-drained
+}
+
+// session connects to the server and forwards queued records until the
+// connection fails or ctx is cancelled.
+func (a *AgentController) session(ctx context.Context) error {
+	var c net.Conn
+	var err error
+	if a.tls != nil {
+		d := tls.Dialer{NetDialer: &net.Dialer{Timeout: agentDialTimeout}, Config: a.tls}
+		c, err = d.DialContext(ctx, "tcp", a.cfg.Server)
+	} else {
+		d := net.Dialer{Timeout: agentDialTimeout}
+		c, err = d.DialContext(ctx, "tcp", a.cfg.Server)
+	}
+	if err != nil {
+		return err
+	}
+	conn := remote.NewConn(c)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if err := conn.Send(&remote.Message{Kind: remote.KindHello, Hello: &remote.Hello{Version: remote.ProtocolVersion, AgentID: a.cfg.ID, Token: a.token}}); err != nil {
+		return fmt.Errorf("hello: %w", err)
+	}
+	a.log.Infof("agent: connected to %s as %q", a.cfg.Server, a.cfg.ID)
+
+	// Answer clock pings; a receive error ends the session. Records are
+	// held back until the first pong is sent, since the logger discards
+	// records it cannot yet map to its own clock.
+	synced := make(chan struct{})
+	rejected := make(chan string, 1)
+	go func() {
+		defer cancel()
+		first := true
+		for {
+			m, err := conn.Recv()
+			if err != nil {
+				return
+			}
+			if m.Kind == remote.KindReject {
+				rejected <- m.Reason
+				return
+			}
+			if m.Kind != remote.KindPing || m.Sync == nil {
+				continue
+			}
+			t2 := time.Now().UnixNano()
+			reply := &remote.Sync{T1: m.Sync.T1, T2: t2, T3: time.Now().UnixNano()}
+			if err := conn.Send(&remote.Message{Kind: remote.KindPong, Sync: reply}); err != nil {
+				return
+			}
+			if first {
+				close(synced)
+				first = false
+			}
+		}
+	}()
+
+	select {
+	case <-synced:
+	case <-ctx.Done():
+		select {
+		case reason := <-rejected:
+			return fmt.Errorf("rejected by the logger: %s", reason)
+		default:
+			return errors.New("connection closed before clock sync")
+		}
+	}
+	for {
+		r, ok := a.queue.Pop(ctx)
+		if !ok {
+			return errors.New("connection closed")
+		}
+		if err := conn.Send(&remote.Message{Kind: remote.KindRecord, Record: r}); err != nil {
+			a.queue.PushFront(r)
+			return err
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/metrics"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// FusedFanout distributes the fusion output to several consumers, each at
+// its own rate. A consumer slower than the fusion rate receives one record
+// per period holding the latest sample of every sensor seen during that
+// period, so low-rate sensors are not lost to decimation.
+type FusedFanout struct {
+	in           <-chan models.FusedRecord
+	fusionPeriod time.Duration
+	branches     []*fusedBranch
+	clock        utils.Clock
+	log          utils.Logger
+}
+
+type fusedBranch struct {
+	name    string
+	period  time.Duration
+	out     chan models.FusedRecord
+	pending *models.FusedRecord
+	last    time.Time
+	dropped atomic.Uint64
+}
+
+// NewFusedFanout distributes the records of in, fused at fusionRateHz. Its
+// stats are logged at the ticks of clock.
+func NewFusedFanout(in <-chan models.FusedRecord, fusionRateHz int, log utils.Logger, clock utils.Clock) *FusedFanout {
+	return &FusedFanout{in: in, fusionPeriod: utils.Period(fusionRateHz), clock: clock, log: log}
+}
+
+// Add registers a consumer receiving records at rateHz (0 = every fused
+// record) and returns its channel. It must be called before Run.
+func (f *FusedFanout) Add(name string, rateHz, bufferSize int) <-chan models.FusedRecord {
+	b := &fusedBranch{name: name, out: make(chan models.FusedRecord, bufferSize)}
+	if rateHz > 0 {
+		b.period = utils.Period(rateHz)
+	}
+	f.branches = append(f.branches, b)
+	return b.out
+}
+
+// Run distributes records from in until it is closed, then closes every
+// consumer channel. A consumer that falls behind loses records rather than
+// stalling the others.
+func (f *FusedFanout) Run() {
+	for rec := range f.in {
+		for _, b := range f.branches {
+			b.offer(rec, f.fusionPeriod)
+		}
+	}
+	for _, b := range f.branches {
+		close(b.out)
+		if n := b.dropped.Load(); n > 0 {
+			f.log.Warnf("fanout: %s dropped %d fused records", b.name, n)
+		}
+	}
+}
+
+func (b *fusedBranch) offer(rec models.FusedRecord, fusionPeriod time.Duration) {
+	if b.pending == nil {
+		b.pending = &rec
+	} else {
+		b.pending.Merge(rec)
+	}
+	// Half a fusion period of slack keeps ticker jitter from skipping a
+	// whole period.
+	if b.period > 0 && rec.Timestamp.Sub(b.last) < b.period-fusionPeriod/2 {
+		return
+	}
+	select {
+	case b.out <- *b.pending:
+	default:
+		b.dropped.Add(1)
+	}
+	b.pending = nil
+	b.last = rec.Timestamp
+}
+
+// LogState logs, for a debug dump, how many records wait in the fusion
+// output and in the channel of every consumer.
+func (f *FusedFanout) LogState() {
+	f.log.Infof("state fusion: queue %d/%d", len(f.in), cap(f.in))
+	for _, b := range f.branches {
+		f.log.Infof("state fused output %s: queue %d/%d", b.name, len(b.out), cap(b.out))
+	}
+}
+
+// LogStats logs, every interval until ctx is cancelled, how full the
+// fusion output and the channel of every consumer are, and the records
+// each consumer dropped so far.
+func (f *FusedFanout) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := f.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		f.log.Infof("stats fusion: queue %d/%d (%.0f%%)", len(f.in), cap(f.in), percent(len(f.in), cap(f.in)))
+		for _, b := range f.branches {
+			f.log.Infof("stats fused output %s: queue %d/%d (%.0f%%), dropped=%d",
+				b.name, len(b.out), cap(b.out), percent(len(b.out), cap(b.out)), b.dropped.Load())
+		}
+	}
+}
+
+// Metrics reports the occupancy of the fusion output and of the channel
+// of every consumer, and the records each consumer dropped.
+func (f *FusedFanout) Metrics() []metrics.Sample {
+	out := []metrics.Sample{
+		{Name: "sensor_logger_fused_queue_length", Help: "Fused records waiting to be distributed to the fused outputs.", Type: metrics.Gauge, Value: float64(len(f.in))},
+		{Name: "sensor_logger_fused_queue_capacity", Help: "Fused records that may wait to be distributed.", Type: metrics.Gauge, Value: float64(cap(f.in))},
+	}
+	for _, b := range f.branches {
+		l := map[string]string{"output": b.name}
+		out = append(out,
+			metrics.Sample{Name: "sensor_logger_fused_output_queue_length", Help: "Fused records waiting in the channel of the fused output.", Type: metrics.Gauge, Labels: l, Value: float64(len(b.out))},
+			metrics.Sample{Name: "sensor_logger_fused_output_queue_capacity", Help: "Fused records that may wait in the channel of the fused output.", Type: metrics.Gauge, Labels: l, Value: float64(cap(b.out))},
+			metrics.Sample{Name: "sensor_logger_fused_output_dropped_total", Help: "Fused records the output fell too far behind to take.", Type: metrics.Counter, Labels: l, Value: float64(b.dropped.Load())},
+		)
+	}
+	return out
+}
+
+// percent returns n as a percentage of of, 0 when of is.
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(of)
+}
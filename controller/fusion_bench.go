@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// MeasureFusion times the fusion stage on its own: two goroutines hand it
+// n lidar packets and n IMU samples each, as the drain and the trigger
+// matching do, while a third merges records at cfg.RateHz. The samples
+// are not recorded. It returns the samples taken per second.
+func MeasureFusion(cfg utils.FusionConfig, n int) float64 {
+	f := &FusionController{cfg: cfg, clock: utils.RealClock, recorder: discard{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		ticker := utils.NewRateTicker(f.clock, cfg.RateHz)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ts := <-ticker.C:
+				f.merge(ts)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, v := range []models.SensorSample{models.LidarPacket{NumPoints: 384}, models.IMUData{AccelZ: 9.80665}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range n {
+				f.take(v)
+			}
+		}()
+	}
+	wg.Wait()
+	return float64(2*n) / time.Since(start).Seconds()
+}
+
+// discard is a SampleRecorder that records nothing.
+type discard struct{}
+
+func (discard) RecordCamera(models.CameraFrame)   {}
+func (discard) RecordGPS(models.GPSData)          {}
+func (discard) RecordIMU(models.IMUData)          {}
+func (discard) RecordLidar(models.LidarPacket)    {}
+func (discard) RecordRadar(models.RadarScan)      {}
+func (discard) RecordEnv(models.EnvData)          {}
+func (discard) RecordTrigger(models.TriggerPulse) {}
+func (discard) RecordINS(models.INSData)          {}
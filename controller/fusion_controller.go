@@ -0,0 +1,488 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/attitude"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/heading"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/transform"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// SampleRecorder receives every raw sample as it is drained from a reader,
+// before fusion.
+type SampleRecorder interface {
+	RecordCamera(models.CameraFrame)
+	RecordGPS(models.GPSData)
+	RecordIMU(models.IMUData)
+	RecordLidar(models.LidarPacket)
+	RecordRadar(models.RadarScan)
+	RecordEnv(models.EnvData)
+	RecordTrigger(models.TriggerPulse)
+	RecordINS(models.INSData)
+}
+
+// Tee returns a SampleRecorder that hands every sample to each of rs in
+// order.
+func Tee(rs ...SampleRecorder) SampleRecorder { return tee(rs) }
+
+type tee []SampleRecorder
+
+func (t tee) RecordCamera(v models.CameraFrame) {
+	for _, r := range t {
+		r.RecordCamera(v)
+	}
+}
+
+func (t tee) RecordGPS(v models.GPSData) {
+	for _, r := range t {
+		r.RecordGPS(v)
+	}
+}
+
+func (t tee) RecordIMU(v models.IMUData) {
+	for _, r := range t {
+		r.RecordIMU(v)
+	}
+}
+
+func (t tee) RecordLidar(v models.LidarPacket) {
+	for _, r := range t {
+		r.RecordLidar(v)
+	}
+}
+
+func (t tee) RecordRadar(v models.RadarScan) {
+	for _, r := range t {
+		r.RecordRadar(v)
+	}
+}
+
+func (t tee) RecordEnv(v models.EnvData) {
+	for _, r := range t {
+		r.RecordEnv(v)
+	}
+}
+
+func (t tee) RecordTrigger(v models.TriggerPulse) {
+	for _, r := range t {
+		r.RecordTrigger(v)
+	}
+}
+
+func (t tee) RecordINS(v models.INSData) {
+	for _, r := range t {
+		r.RecordINS(v)
+	}
+}
+
+// maxIMUBatch bounds the IMU samples batched into one fused record, while
+// the fused outputs hold up the fusion.
+const maxIMUBatch = 10000
+
+// FusionController drains the samples of every reader, keeps the latest
+// sample of each sensor and, on every tick, publishes a FusedRecord
+// snapshot on Out.
+type FusionController struct {
+	cfg      utils.FusionConfig
+	clock    utils.Clock
+	sensors  *SensorsController
+	recorder SampleRecorder
+	heading  *heading.Estimator
+	// attitude sets the attitude of every IMU sample; nil when disabled.
+	attitude *attitude.Estimator
+	// projector sets the projected position of every fix; nil without
+	// a projection.
+	projector *transform.Projector
+	Out       chan models.FusedRecord
+
+	// The latest sample of each sensor, swapped out by merge. Each has a
+	// slot of its own, so the samples of one sensor never wait for those
+	// of another.
+	camera atomic.Pointer[models.CameraFrame]
+	gps    atomic.Pointer[models.GPSData]
+	imu    atomic.Pointer[models.IMUData]
+	lidar  atomic.Pointer[models.LidarPacket]
+	radar  atomic.Pointer[models.RadarScan]
+	env    atomic.Pointer[models.EnvData]
+	// imuBatch are the IMU samples of the window, with cfg.IMUBatch;
+	// imuMu keeps them together with the latest IMU sample.
+	imuMu    sync.Mutex
+	imuBatch []models.IMUData
+
+	// stale are the policies of cfg.Stale other than clear, in the order
+	// of the Present bits. last has the latest sample of each of their
+	// sensors, and pending the records held back until the samples to
+	// interpolate their gaps between have come, up to holdBack.
+	stale    []stalePolicy
+	last     models.FusedRecord
+	pending  []models.FusedRecord
+	holdBack bool
+}
+
+// stalePolicy is the utils.StaleConfig of the sensor with Present bit
+// bit.
+type stalePolicy struct {
+	bit    int
+	policy string
+	maxAge time.Duration
+}
+
+// NewFusionController fuses the readers of sensors. cal places the IMU
+// for the heading and attitude estimates, see utils.HeadingConfig and
+// utils.AttitudeConfig; projector, if not nil, projects every fix before
+// it is recorded. The records are stamped with the ticks of clock.
+func NewFusionController(cfg utils.FusionConfig, cal utils.CalibrationConfig, projector *transform.Projector, sensors *SensorsController, recorder SampleRecorder, clock utils.Clock) *FusionController {
+	f := &FusionController{
+		cfg:       cfg,
+		clock:     clock,
+		sensors:   sensors,
+		recorder:  recorder,
+		projector: projector,
+		Out:       make(chan models.FusedRecord, cfg.BufferSize),
+	}
+	if cfg.Heading.Enabled && sensors.IMU != nil {
+		f.heading = heading.NewEstimator(cfg.Heading, cal)
+	}
+	if cfg.Attitude.Enabled && sensors.IMU != nil {
+		f.attitude = attitude.NewEstimator(cfg.Attitude, cal)
+	}
+	for i, s := range models.FusedSensors {
+		st, ok := cfg.Stale[s.Name]
+		if !ok || st.Policy == utils.StaleClear {
+			continue
+		}
+		f.stale = append(f.stale, stalePolicy{1 << i, st.Policy, time.Duration(st.MaxAgeMs) * time.Millisecond})
+		f.holdBack = f.holdBack || st.Policy == utils.StaleInterpolate
+	}
+	return f
+}
+
+// Run fuses until ctx is cancelled, then waits for the drains to finish and
+// closes Out.
+func (f *FusionController) Run(ctx context.Context) {
+	defer close(f.Out)
+	var wg sync.WaitGroup
+	drain := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	s := f.sensors
+	// Match waits up to the trigger window for a pulse stamped after the
+	// sample, so the frames and sweeps to match go to a goroutine each
+	// rather than holding up the samples of the other sensors.
+	var cameras chan models.CameraFrame
+	if s.cameraPulses != nil {
+		cameras = make(chan models.CameraFrame, s.Camera.Stats().Capacity)
+		drain(func() {
+			for v := range cameras {
+				v.Trigger = s.cameraPulses.Match(v.Timestamp)
+				f.take(v)
+			}
+		})
+	}
+	var sweeps chan models.LidarPacket
+	if s.lidarPulses != nil {
+		sweeps = make(chan models.LidarPacket, s.Lidar.Stats().Capacity)
+		drain(func() {
+			for v := range sweeps {
+				v.Trigger = s.lidarPulses.Match(v.Timestamp)
+				f.take(v)
+			}
+		})
+	}
+	drain(func() {
+		for v := range s.Mux.C {
+			s.Mux.Done(v)
+			switch v := v.(type) {
+			case models.CameraFrame:
+				if cameras != nil {
+					cameras <- v
+					continue
+				}
+			case models.LidarPacket:
+				if sweeps != nil {
+					sweeps <- v
+					continue
+				}
+			}
+			f.take(v)
+		}
+		if cameras != nil {
+			close(cameras)
+		}
+		if sweeps != nil {
+			close(sweeps)
+		}
+	})
+
+	var tick <-chan time.Time
+	if f.cfg.Align.Enabled {
+		ticker := utils.NewAlignedTicker(f.clock, f.cfg.RateHz, time.Duration(f.cfg.Align.PhaseMs)*time.Millisecond)
+		defer ticker.Stop()
+		tick = ticker.C
+	} else {
+		ticker := utils.NewRateTicker(f.clock, f.cfg.RateHz)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		var ts time.Time
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			// The records held back go out with the gaps they have.
+			for _, rec := range f.pending {
+				f.Out <- rec
+			}
+			return
+		case ts = <-tick:
+		}
+		if !f.cfg.Align.Enabled {
+			ts = f.clock.Now()
+		}
+		for _, rec := range f.fill(f.merge(ts)) {
+			select {
+			case f.Out <- rec:
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// take records v and keeps it as the latest sample of its sensor.
+func (f *FusionController) take(v models.SensorSample) {
+	switch v := v.(type) {
+	case models.CameraFrame:
+		f.recorder.RecordCamera(v)
+		f.camera.Store(&v)
+	case models.GPSData:
+		if f.projector != nil {
+			v.Projected = f.projector.Project(v)
+		}
+		f.recorder.RecordGPS(v)
+		if f.heading != nil {
+			f.heading.ObserveGPS(v)
+		}
+		f.gps.Store(&v)
+	case models.IMUData:
+		if f.attitude != nil {
+			v.Attitude = f.attitude.Update(v)
+		}
+		f.recorder.RecordIMU(v)
+		if f.heading != nil {
+			f.heading.ObserveIMU(v)
+		}
+		if !f.cfg.IMUBatch {
+			f.imu.Store(&v)
+			break
+		}
+		f.imuMu.Lock()
+		f.imu.Store(&v)
+		if len(f.imuBatch) < maxIMUBatch {
+			f.imuBatch = append(f.imuBatch, v)
+		}
+		f.imuMu.Unlock()
+	case models.LidarPacket:
+		f.recorder.RecordLidar(v)
+		f.lidar.Store(&v)
+	case models.RadarScan:
+		f.recorder.RecordRadar(v)
+		f.radar.Store(&v)
+	case models.EnvData:
+		f.recorder.RecordEnv(v)
+		f.env.Store(&v)
+	case models.TriggerPulse:
+		f.recorder.RecordTrigger(v)
+	case models.INSData:
+		f.recorder.RecordINS(v)
+	}
+}
+
+// merge takes the latest samples into a record and clears them, so each
+// sample appears in at most one fused row. The slots are swapped one after
+// the other: a sample taken meanwhile goes into this record or the next.
+func (f *FusionController) merge(ts time.Time) models.FusedRecord {
+	rec := models.FusedRecord{
+		Timestamp: ts,
+		Camera:    f.camera.Swap(nil),
+		GPS:       f.gps.Swap(nil),
+		Lidar:     f.lidar.Swap(nil),
+		Radar:     f.radar.Swap(nil),
+		Env:       f.env.Swap(nil),
+	}
+	f.imuMu.Lock()
+	rec.IMU, rec.IMUBatch = f.imu.Swap(nil), f.imuBatch
+	f.imuBatch = nil
+	f.imuMu.Unlock()
+	if f.heading != nil {
+		if h, ok := f.heading.Heading(); ok {
+			rec.HeadingDeg = &h
+		}
+	}
+	return rec
+}
+
+// fill applies the stale policies to rec and returns the records ready to
+// go out: rec, or with interpolation the records held back up to it whose
+// gaps are filled or will not be.
+func (f *FusionController) fill(rec models.FusedRecord) []models.FusedRecord {
+	present := rec.Present()
+	for _, st := range f.stale {
+		switch {
+		case present&st.bit != 0:
+			if st.policy == utils.StaleInterpolate && f.last.Present()&st.bit != 0 {
+				f.interpolate(st, rec)
+			}
+			copySample(&f.last, rec, st.bit)
+		case st.policy == utils.StaleHold && f.last.Present()&st.bit != 0 &&
+			rec.Timestamp.Sub(sampleTime(f.last, st.bit)) <= st.maxAge:
+			copySample(&rec, f.last, st.bit)
+			rec.Held |= st.bit
+		}
+	}
+	if !f.holdBack {
+		return []models.FusedRecord{rec}
+	}
+	f.pending = append(f.pending, rec)
+	n := 0
+	for n < len(f.pending) && f.settled(f.pending[n], rec.Timestamp) {
+		n++
+	}
+	out := slices.Clone(f.pending[:n])
+	f.pending = append(f.pending[:0], f.pending[n:]...)
+	return out
+}
+
+// settled reports whether nothing more can be interpolated into p at now:
+// for every sensor interpolated, p has a sample, a later one has come or
+// the wait for it is over.
+func (f *FusionController) settled(p models.FusedRecord, now time.Time) bool {
+	for _, st := range f.stale {
+		if st.policy != utils.StaleInterpolate || p.Present()&st.bit != 0 {
+			continue
+		}
+		if f.last.Present()&st.bit != 0 && !sampleTime(f.last, st.bit).Before(p.Timestamp) {
+			continue
+		}
+		if now.Sub(p.Timestamp) < st.maxAge {
+			return false
+		}
+	}
+	return true
+}
+
+// interpolate fills the gaps of the pending records between the last
+// sample of the sensor of st and the one rec carries, unless they are
+// more than st.maxAge apart.
+func (f *FusionController) interpolate(st stalePolicy, rec models.FusedRecord) {
+	from, to := sampleTime(f.last, st.bit), sampleTime(rec, st.bit)
+	if to.Sub(from) > st.maxAge || !to.After(from) {
+		return
+	}
+	k := func(t time.Time) float64 { return float64(t.Sub(from)) / float64(to.Sub(from)) }
+	for i := range f.pending {
+		p := &f.pending[i]
+		if p.Present()&st.bit != 0 || !p.Timestamp.After(from) || !p.Timestamp.Before(to) {
+			continue
+		}
+		switch st.bit {
+		case models.PresentGPS:
+			g := lerpGPS(*f.last.GPS, *rec.GPS, k(p.Timestamp))
+			g.Timestamp = p.Timestamp
+			p.GPS = &g
+		case models.PresentIMU:
+			d := lerpIMU(*f.last.IMU, *rec.IMU, k(p.Timestamp))
+			d.Timestamp = p.Timestamp
+			p.IMU = &d
+		case models.PresentEnv:
+			e := lerpEnv(*f.last.Env, *rec.Env, k(p.Timestamp))
+			e.Timestamp = p.Timestamp
+			p.Env = &e
+		}
+		p.Interpolated |= st.bit
+	}
+}
+
+// sampleTime returns the timestamp of the sample r has of the sensor with
+// Present bit bit.
+func sampleTime(r models.FusedRecord, bit int) time.Time {
+	switch bit {
+	case models.PresentCamera:
+		return r.Camera.Timestamp
+	case models.PresentGPS:
+		return r.GPS.Timestamp
+	case models.PresentIMU:
+		return r.IMU.Timestamp
+	case models.PresentLidar:
+		return r.Lidar.Timestamp
+	case models.PresentRadar:
+		return r.Radar.Timestamp
+	}
+	return r.Env.Timestamp
+}
+
+// copySample gives dst the sample src has of the sensor with Present bit
+// bit.
+func copySample(dst *models.FusedRecord, src models.FusedRecord, bit int) {
+	switch bit {
+	case models.PresentCamera:
+		dst.Camera = src.Camera
+	case models.PresentGPS:
+		dst.GPS = src.GPS
+	case models.PresentIMU:
+		dst.IMU = src.IMU
+	case models.PresentLidar:
+		dst.Lidar = src.Lidar
+	case models.PresentRadar:
+		dst.Radar = src.Radar
+	case models.PresentEnv:
+		dst.Env = src.Env
+	}
+}
+
+func lerp(a, b, k float64) float64 { return a + (b-a)*k }
+
+// lerpAngle interpolates between two angles in degrees the short way
+// round.
+func lerpAngle(a, b, k float64) float64 {
+	return math.Mod(a+math.Remainder(b-a, 360)*k+360, 360)
+}
+
+// lerpGPS interpolates the position, speed and course of a fix; the
+// quality figures are a's.
+func lerpGPS(a, b models.GPSData, k float64) models.GPSData {
+	g := a
+	g.Lat, g.Lon, g.Alt = lerp(a.Lat, b.Lat, k), lerp(a.Lon, b.Lon, k), lerp(a.Alt, b.Alt, k)
+	g.SpeedMps = lerp(a.SpeedMps, b.SpeedMps, k)
+	g.HeadingDeg = lerpAngle(a.HeadingDeg, b.HeadingDeg, k)
+	if pa, pb := a.Projected, b.Projected; pa != nil && pb != nil {
+		g.Projected = &models.Projection{X: lerp(pa.X, pb.X, k), Y: lerp(pa.Y, pb.Y, k), Zone: pa.Zone}
+	}
+	return g
+}
+
+func lerpIMU(a, b models.IMUData, k float64) models.IMUData {
+	d := a
+	d.AccelX, d.AccelY, d.AccelZ = lerp(a.AccelX, b.AccelX, k), lerp(a.AccelY, b.AccelY, k), lerp(a.AccelZ, b.AccelZ, k)
+	d.GyroX, d.GyroY, d.GyroZ = lerp(a.GyroX, b.GyroX, k), lerp(a.GyroY, b.GyroY, k), lerp(a.GyroZ, b.GyroZ, k)
+	d.MagX, d.MagY, d.MagZ = lerp(a.MagX, b.MagX, k), lerp(a.MagY, b.MagY, k), lerp(a.MagZ, b.MagZ, k)
+	return d
+}
+
+func lerpEnv(a, b models.EnvData, k float64) models.EnvData {
+	e := a
+	e.TemperatureC = lerp(a.TemperatureC, b.TemperatureC, k)
+	e.HumidityPct = lerp(a.HumidityPct, b.HumidityPct, k)
+	e.PressureHPa = lerp(a.PressureHPa, b.PressureHPa, k)
+	return e
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// awaitWaiters waits for the goroutines under test to be waiting on clock.
+func awaitWaiters(t *testing.T, clock *utils.FakeClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters on the clock, want %d", clock.Waiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// nextRecord returns the next record fused by f.
+func nextRecord(t *testing.T, f *FusionController) models.FusedRecord {
+	t.Helper()
+	select {
+	case rec := <-f.Out:
+		return rec
+	case <-time.After(5 * time.Second):
+		t.Fatal("no fused record")
+		return models.FusedRecord{}
+	}
+}
+
+func TestFusionTicksOnClock(t *testing.T) {
+	noon := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	start := noon.Add(30 * time.Millisecond)
+	tests := []struct {
+		name   string
+		rateHz int
+		align  bool
+		// tick returns the instant of tick i after start.
+		tick func(i int) time.Time
+	}{
+		{"rate", 10, false, func(i int) time.Time { return start.Add(time.Duration(i+1) * 100 * time.Millisecond) }},
+		{"aligned", 10, true, func(i int) time.Time { return noon.Add(time.Duration(i+1) * 100 * time.Millisecond) }},
+		// A period of 33.3 ms: the instants are rounded up to the
+		// nanosecond.
+		{"aligned_30hz", 30, true, func(i int) time.Time { return noon.Add(time.Duration((int64(i+1)*1e9 + 29) / 30)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := utils.NewFakeClock(start)
+			sensors := NewSensorsController(&utils.SensorsConfig{}, events.NewBus(utils.Discard), utils.Discard, clock)
+			cfg := utils.FusionConfig{RateHz: tt.rateHz, BufferSize: 4, Align: utils.AlignConfig{Enabled: tt.align}}
+			f := NewFusionController(cfg, utils.CalibrationConfig{}, nil, sensors, discard{}, clock)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				f.Run(ctx)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			for i := range 2 * tt.rateHz {
+				awaitWaiters(t, clock, 1)
+				if i == 2 {
+					f.take(models.IMUData{Timestamp: clock.Now(), AccelZ: 9.80665})
+				}
+				want := tt.tick(i)
+				clock.Advance(want.Sub(clock.Now()))
+				rec := nextRecord(t, f)
+				if !rec.Timestamp.Equal(want) {
+					t.Fatalf("tick %d at %v, want %v", i, rec.Timestamp, want)
+				}
+				if (rec.IMU != nil) != (i == 2) {
+					t.Errorf("tick %d: IMU sample %v", i, rec.IMU)
+				}
+			}
+		})
+	}
+}
+
+// mutexFusion keeps the latest samples as the fusion stage did before
+// each sensor had an atomic slot: all of them, and the IMU batch, behind
+// one mutex. It is the baseline of BenchmarkFusion.
+type mutexFusion struct {
+	recorder SampleRecorder
+	imuBatch bool
+
+	mu     sync.Mutex
+	camera *models.CameraFrame
+	gps    *models.GPSData
+	imu    *models.IMUData
+	lidar  *models.LidarPacket
+	radar  *models.RadarScan
+	env    *models.EnvData
+	batch  []models.IMUData
+}
+
+func (f *mutexFusion) take(v models.SensorSample) {
+	switch v := v.(type) {
+	case models.CameraFrame:
+		f.recorder.RecordCamera(v)
+		f.mu.Lock()
+		f.camera = &v
+		f.mu.Unlock()
+	case models.GPSData:
+		f.recorder.RecordGPS(v)
+		f.mu.Lock()
+		f.gps = &v
+		f.mu.Unlock()
+	case models.IMUData:
+		f.recorder.RecordIMU(v)
+		f.mu.Lock()
+		f.imu = &v
+		if f.imuBatch && len(f.batch) < maxIMUBatch {
+			f.batch = append(f.batch, v)
+		}
+		f.mu.Unlock()
+	case models.LidarPacket:
+		f.recorder.RecordLidar(v)
+		f.mu.Lock()
+		f.lidar = &v
+		f.mu.Unlock()
+	case models.RadarScan:
+		f.recorder.RecordRadar(v)
+		f.mu.Lock()
+		f.radar = &v
+		f.mu.Unlock()
+	case models.EnvData:
+		f.recorder.RecordEnv(v)
+		f.mu.Lock()
+		f.env = &v
+		f.mu.Unlock()
+	}
+}
+
+func (f *mutexFusion) merge(ts time.Time) models.FusedRecord {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rec := models.FusedRecord{
+		Timestamp: ts,
+		Camera:    f.camera,
+		GPS:       f.gps,
+		IMU:       f.imu,
+		Lidar:     f.lidar,
+		Radar:     f.radar,
+		Env:       f.env,
+		IMUBatch:  f.batch,
+	}
+	f.camera, f.gps, f.imu, f.lidar, f.radar, f.env = nil, nil, nil, nil, nil, nil
+	f.batch = nil
+	return rec
+}
+
+// benchMergeEvery is how many samples a goroutine of BenchmarkFusion
+// takes for every record it merges.
+const benchMergeEvery = 100
+
+// BenchmarkFusion takes samples and merges records from every goroutine
+// at once, each goroutine taking the samples of one sensor, with the
+// atomic slots of FusionController and with mutexFusion.
+func BenchmarkFusion(b *testing.B) {
+	samples := []models.SensorSample{
+		models.LidarPacket{NumPoints: 384},
+		models.IMUData{AccelZ: 9.80665},
+		models.CameraFrame{Width: 640, Height: 480},
+		models.GPSData{Lat: 29.865, Lon: 77.897, FixQuality: 1},
+		models.RadarScan{},
+		models.EnvData{TemperatureC: 21},
+	}
+	type stage interface {
+		take(models.SensorSample)
+		merge(time.Time) models.FusedRecord
+	}
+	for _, batch := range []bool{false, true} {
+		stages := []struct {
+			name  string
+			stage stage
+		}{
+			{"atomic", &FusionController{cfg: utils.FusionConfig{IMUBatch: batch}, clock: utils.RealClock, recorder: discard{}}},
+			{"mutex", &mutexFusion{recorder: discard{}, imuBatch: batch}},
+		}
+		for _, s := range stages {
+			b.Run(fmt.Sprintf("%s/imu_batch=%t", s.name, batch), func(b *testing.B) {
+				var next atomic.Int64
+				ts := time.Now()
+				b.RunParallel(func(pb *testing.PB) {
+					v := samples[int(next.Add(1)-1)%len(samples)]
+					for i := 1; pb.Next(); i++ {
+						if i%benchMergeEvery == 0 {
+							s.stage.merge(ts)
+						} else {
+							s.stage.take(v)
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,2096 @@
+package controller
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/capture"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/cloudcodec"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/degrade"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/export"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/framecodec"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/ingest"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/metrics"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/privacy"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/quality"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/radargrid"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/sysstat"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/thermal"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/transform"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/validate"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+const (
+	framesDir            = "frames"
+	leftFramesDir        = "frames/left"
+	rightFramesDir       = "frames/right"
+	cloudsDir            = "clouds"
+	transformedCloudsDir = "clouds_transformed"
+	radarGridsDir        = "radar_grids"
+	radarDetectionsDir   = "radar_detections"
+
+	// resumeGapSensor names the gaps.csv rows of logger restarts.
+	resumeGapSensor = "logger"
+)
+
+// RecordingController writes one session directory: a CSV file (or binary
+// log, see utils.StorageConfig.BinarySensors) per enabled sensor, fused.csv, gaps.csv, optionally camera frames and lidar clouds,
+// and manifest.json when the session closes.
+//
+// If a write fails and a fallback directory is configured, the session
+// continues in a directory of the same name under the fallback. A session
+// directory left by an interrupted run is resumed: its files are appended
+// to and the interruption is noted in gaps.csv.
+type RecordingController struct {
+	cfg    utils.StorageConfig
+	layout models.FusedLayout
+	start  time.Time
+	clock  utils.Clock
+	log    utils.Logger
+	bus    *events.Bus
+	// profile is the sensors.yaml profile the session runs with.
+	profile string
+	// notes are the free-text notes taken on the session, for the
+	// manifest; its tags are in cfg.
+	notesMu sync.Mutex
+	notes   []views.Note
+	// unstopped are the stages that did not stop by the stop deadline.
+	unstopped []string
+	// stereo is set when the camera is a stereo pair, whose frames are
+	// saved under frames/left and frames/right.
+	stereo bool
+	// trigger is set when a hardware trigger runs: its pulses go to
+	// trigger.csv and camera.csv and lidar.csv get the trigger columns.
+	trigger bool
+	// freeze is set when the camera is watched for a frozen picture, for
+	// the frozen_ms column of camera.csv.
+	freeze bool
+	// attitude is set when the fusion estimates the attitude of the IMU
+	// samples, for the attitude columns of imu.csv.
+	attitude bool
+	// sessionID is the UUID of the session. With cfg.RecordIDs every CSV
+	// row but those of the blob journal ends in it and an ID counted up
+	// from recordID.
+	sessionID string
+	recordID  atomic.Uint64
+	// custom are the values of cfg.CustomColumns, in the order of their
+	// columns in fused.csv.
+	custom []string
+
+	// subdirs and calibration are recreated in the fallback directory.
+	subdirs     []string
+	calibration utils.CalibrationConfig
+
+	dirMu    sync.RWMutex
+	dir      string
+	failover *views.Failover
+
+	// sinks receive the sensor and fused records, the csv sink first; see
+	// utils.StorageConfig.Sinks.
+	csv   *views.CSVSink
+	sinks []*sink
+
+	// Binary logs replacing imu.csv and radar.csv when configured.
+	imuLog   *views.RecordWriter
+	radarLog *views.RecordWriter
+	// Packet captures of the lidar and the radar; nil unless
+	// cfg.CapturePackets.
+	lidarPcap *views.PcapWriter
+	radarPcap *views.PcapWriter
+	// radarDetections lists the detections saved under
+	// radarDetectionsDir; nil unless cfg.SaveRadarDetections.
+	radarDetections *views.CSVWriter
+
+	// Optional sensor→vehicle/world outputs, see utils.TransformConfig.
+	transformer      *transform.Transformer
+	frame            transform.Frame
+	radarTransformed *views.CSVWriter
+	// deskew corrects lidar sweeps for the ego motion; nil when disabled.
+	deskew *transform.Deskewer
+	// projector projects the fixes for the columns of cfg.Projection,
+	// handed to the fusion; nil without a projection or GPS.
+	projector *transform.Projector
+
+	// radarGrid accumulates radar detections between fused.csv rows;
+	// radarGrids counts the grids saved. Both are nil/unused when disabled.
+	radarGrid  *radargrid.Grid
+	radarGrids int
+
+	// fixes counts GPS fixes by fix quality for the manifest; lastFix is
+	// the latest fix, for the EXIF of frames.
+	fixMu   sync.Mutex
+	fixes   map[int]int64
+	lastFix *models.GPSData
+	// exifWarned is set once a frame could not be tagged.
+	exifWarned atomic.Bool
+
+	// location encrypts the columns of privacy.LocationColumns, found at
+	// sealed in each file's rows; nil unless location_encryption is on.
+	location *privacy.LocationCipher
+	sealed   map[string][]int
+	// units writes the rows of each file with unit columns in the units
+	// of storage.yaml; nil for the files in the units of the models.
+	units map[string]*views.UnitConverter
+
+	// tally counts the records failing validation; nil when disabled.
+	tally *validate.Tally
+
+	// Clock jumps are counted from clockBase, the count when the session
+	// started; clockLogged is the count logged so far and clockSeen the
+	// count each table was last marked at, see clockEvent.
+	clockMu     sync.Mutex
+	clockBase   int
+	clockLogged atomic.Int64
+	clockSeen   map[string]int
+
+	// Per-sensor gap detectors, nil for disabled sensors; detected gaps
+	// go to gaps.csv as they happen.
+	cameraGaps  *quality.GapDetector
+	gpsGaps     *quality.GapDetector
+	imuGaps     *quality.GapDetector
+	lidarGaps   *quality.GapDetector
+	radarGaps   *quality.GapDetector
+	envGaps     *quality.GapDetector
+	triggerGaps *quality.GapDetector
+	insGaps     *quality.GapDetector
+	gaps        *views.CSVWriter
+
+	// journal lists the files saveBlob completed, see views.BlobJournal.
+	journal *views.CSVWriter
+
+	// system has the samples of SampleSystem; nil when disabled.
+	system *views.CSVWriter
+
+	// Clock estimates of the sensors that stamp their samples, nil for
+	// the others; they go to clockSync every clock.sync_interval_s.
+	lidarClock *quality.ClockSync
+	radarClock *quality.ClockSync
+	clockSync  *views.CSVWriter
+
+	// events has the events of the bus, received on eventSub until Stop;
+	// eventsDone is closed once the last one is written.
+	events     *views.CSVWriter
+	eventSub   *events.Subscription
+	eventsDone chan struct{}
+
+	// odometer totals the distance and speeds of the session for the
+	// manifest; nil without GPS.
+	odometer *quality.Odometer
+
+	// policy thins out saved frames and clouds while the vehicle is
+	// stationary; nil when disabled.
+	policy *capture.Policy
+
+	// degrade sheds saved frames and clouds while the disk is behind; nil
+	// when disabled.
+	degrade *degrade.Governor
+
+	// throttle is the thermal throttle of the simulated sensors, reported
+	// in the manifest; nil when disabled.
+	throttle *thermal.Throttle
+
+	// dryRun counts the files saveBlob would write in a dry run; nil
+	// otherwise.
+	dryRun *blobCounts
+
+	// run counts the resumptions of the session, 0 for a new one;
+	// interruptions are the spans the logger was down before them.
+	run           int
+	interruptions []views.Interruption
+
+	// frames queues the frames to transcode to cfg.FrameFormat; nil when
+	// frames are saved as captured. jpegFallbacks counts the frames saved
+	// as captured because the queue was full.
+	frames        chan frameJob
+	jpegFallbacks atomic.Int64
+
+	// processing crops and scales saved frames, nil when disabled; see
+	// utils.FrameProcessingConfig. Frames are saved as captured until
+	// fullResUntil (Unix ns), extended by the events of kinds in fullResOn.
+	// processed, fullRes and processSkipped count the saved frames for
+	// the manifest.
+	processing     *framecodec.Processing
+	fullResUntil   atomic.Int64
+	fullResOn      map[string]bool
+	processed      atomic.Int64
+	fullRes        atomic.Int64
+	processSkipped atomic.Int64
+
+	// Frames and clouds written by saveBlob: clouds with blobs, frames to
+	// frameStore, whose failures are not escalated when it is remote.
+	blobs        *views.BlobWriter
+	frameStore   views.FrameStore
+	remoteFrames bool
+	savedFiles   atomic.Int64
+	savedBytes   atomic.Int64
+	saveErrors   atomic.Int64
+
+	// failed is closed when a write error is escalated to abort the run,
+	// see utils.StorageConfig.OnWriteError.
+	failed   chan struct{}
+	failOnce sync.Once
+	failErr  error
+
+	wg sync.WaitGroup
+}
+
+// NewRecordingController creates the session directory and the CSV files of
+// every sensor enabled in sensors. Write failures are published to bus,
+// and every event of bus is written to events.csv. The session is timed,
+// and its files flushed, by clock.
+//
+// With cfg.DryRun nothing is created: rows, frames and clouds are only
+// counted, for DryRunReport.
+func NewRecordingController(cfg utils.StorageConfig, sensors *utils.SensorsConfig, dir string, bus *events.Bus, log utils.Logger, clock utils.Clock) (*RecordingController, error) {
+	layout := models.FusedLayout{
+		Env:       sensors.Env.Enabled && sensors.Env.FusedColumns,
+		Heading:   sensors.IMU.Enabled && sensors.Fusion.Heading.Enabled,
+		RadarGrid: sensors.Radar.Enabled && cfg.RadarGrid.Enabled,
+		IMUBatch:  sensors.IMU.Enabled && sensors.Fusion.IMUBatch,
+	}
+	if sensors.GPS.Enabled {
+		layout.Projection = cfg.Projection
+	}
+	for _, s := range models.FusedSensors {
+		if st, ok := sensors.Fusion.Stale[s.Name]; ok && st.Policy != utils.StaleClear {
+			layout.Fill = append(layout.Fill, s.Name)
+		}
+	}
+	if err := views.CheckSchema(layout); err != nil {
+		return nil, fmt.Errorf("csv schema: %w", err)
+	}
+	if !cfg.DryRun {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create session dir: %w", err)
+		}
+	}
+	rc := &RecordingController{
+		cfg:         cfg,
+		dir:         dir,
+		layout:      layout,
+		start:       clock.Now(),
+		clock:       clock,
+		log:         log,
+		bus:         bus,
+		profile:     sensors.Profile.Name,
+		stereo:      sensors.Camera.Stereo.Enabled,
+		trigger:     sensors.Trigger.Enabled,
+		freeze:      sensors.Camera.Freeze.Enabled,
+		attitude:    sensors.IMU.Enabled && sensors.Fusion.Attitude.Enabled,
+		calibration: sensors.Calibration,
+		blobs:       views.NewBlobWriter(cfg.FrameSync, int64(cfg.SyncChunkMB)<<20, log),
+		fixes:       map[int]int64{},
+		failed:      make(chan struct{}),
+		csv:         views.NewCSVSink(),
+		projector:   newProjector(layout.Projection),
+		clockBase:   utils.ClockJumpCount(),
+		clockSeen:   map[string]int{},
+	}
+	rc.clockLogged.Store(int64(rc.clockBase))
+	rc.sessionID = views.NewSessionID()
+	if err := views.CheckCustomColumns(cfg.CustomColumnNames()); err != nil {
+		return nil, fmt.Errorf("custom_columns: %w", err)
+	}
+	for _, name := range cfg.CustomColumnNames() {
+		rc.custom = append(rc.custom, cfg.CustomColumns[name])
+	}
+	rc.sinks = []*sink{{Sink: rc.csv}}
+	rc.units = map[string]*views.UnitConverter{
+		views.GPSCSV:   views.NewUnitConverter(views.SchemaColumns[views.GPSCSV], cfg.Units),
+		views.RadarCSV: views.NewUnitConverter(views.SchemaColumns[views.RadarCSV], cfg.Units),
+		views.IMUCSV:   views.NewUnitConverter(append(slices.Clone(views.SchemaColumns[views.IMUCSV]), models.AttitudeCSVHeader()...), cfg.Units),
+		views.FusedCSV: views.NewUnitConverter(views.FusedColumns(layout), cfg.Units),
+	}
+	if cfg.DryRun {
+		rc.dryRun = &blobCounts{files: map[string]int64{}, bytes: map[string]int64{}}
+	}
+	// An earlier run left fused.csv behind; its last write starts the
+	// interruption.
+	var resumed time.Time
+	if fi, err := os.Stat(views.SessionFile(filepath.Join(dir, views.FusedCSV))); err == nil && !cfg.DryRun {
+		resumed = fi.ModTime()
+		if start, ok := utils.SessionStart(filepath.Base(dir)); ok {
+			rc.start = start
+		}
+		if err := rc.reconcile(dir); err != nil {
+			return nil, err
+		}
+		// Sessions from before session IDs get one from here on.
+		if id, err := views.ReadSessionID(dir); err == nil {
+			rc.sessionID = id
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if le := cfg.LocationEncryption; le.Enabled {
+		group, err := privacy.LoadGroupKey(le.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("location encryption: %w", err)
+		}
+		switch {
+		case cfg.DryRun:
+			// Nothing is written; any key gives values of the right size.
+			rc.location, err = privacy.NewLocationCipher(make([]byte, 32))
+		case !resumed.IsZero():
+			rc.location, err = privacy.OpenLocationKey(dir, group)
+		default:
+			rc.location, err = privacy.CreateLocationKey(dir, group)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("location encryption: %w", err)
+		}
+		rc.sealed = map[string][]int{}
+		for file, cols := range map[string][]string{views.GPSCSV: views.SchemaColumns[views.GPSCSV], views.FusedCSV: views.FusedColumns(layout)} {
+			for _, name := range privacy.LocationColumns[file] {
+				rc.sealed[file] = append(rc.sealed[file], slices.Index(cols, name))
+			}
+		}
+	} else if !resumed.IsZero() && exists(filepath.Join(dir, privacy.LocationKeyFile)) {
+		return nil, errors.New("the locations of the session are encrypted and location_encryption is off")
+	}
+	open := func(name string, header []string) (*views.CSVWriter, error) {
+		if cfg.DryRun {
+			return views.NewDiscardCSVWriter(name, header), nil
+		}
+		path := filepath.Join(dir, name)
+		if resumed.IsZero() || !exists(views.SessionFile(path)) {
+			return views.NewCSVWriter(path, header)
+		}
+		w, err := views.AppendCSVWriter(path, header)
+		if err != nil || !cfg.RecordIDs {
+			return w, err
+		}
+		// The IDs of the run go on from those of the earlier runs.
+		last, err := views.LastRecordID(views.SessionFile(path))
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		if last > rc.recordID.Load() {
+			rc.recordID.Store(last)
+		}
+		return w, nil
+	}
+	if cfg.Validation.Enabled {
+		rc.tally = validate.NewTally()
+	}
+	cameraHeader := slices.Clone(views.SchemaColumns[views.CameraCSV])
+	if rc.stereo {
+		cameraHeader = append(cameraHeader, models.CameraFrame{}.StereoCSVHeader()...)
+	}
+	gpsHeader := append(slices.Clone(views.SchemaColumns[views.GPSCSV]), models.ProjectionCSVHeader(layout.Projection)...)
+	imuHeader := slices.Clone(views.SchemaColumns[views.IMUCSV])
+	if rc.attitude {
+		imuHeader = append(imuHeader, models.AttitudeCSVHeader()...)
+	}
+	lidarHeader := slices.Clone(views.SchemaColumns[views.LidarCSV])
+	if rc.trigger {
+		cameraHeader = append(cameraHeader, (*models.TriggerMatch)(nil).CSVHeader()...)
+		lidarHeader = append(lidarHeader, (*models.TriggerMatch)(nil).CSVHeader()...)
+	}
+	if rc.freeze {
+		cameraHeader = append(cameraHeader, models.CameraFrame{}.FreezeCSVHeader()...)
+	}
+	// identified adds the record ID columns to the header of a file.
+	identified := func(header []string) []string {
+		if !cfg.RecordIDs {
+			return header
+		}
+		return append(slices.Clone(header), views.SessionIDColumn, views.RecordIDColumn)
+	}
+	// marked adds the valid and clock_event columns to the files of
+	// sensor records, and the record IDs after them.
+	marked := func(header []string) []string {
+		header = slices.Clone(header)
+		if cfg.Validation.Enabled && cfg.Validation.Action == utils.ValidationMark {
+			header = append(header, views.ValidColumn)
+		}
+		if cfg.Clock.Mode == utils.ClockFlag {
+			header = append(header, views.ClockEventColumn)
+		}
+		return identified(header)
+	}
+	// The tables of the sinks; the csv sink has a file per table, but for
+	// the sensors logged in binary.
+	tables := []struct {
+		enabled bool
+		table   string
+		name    string
+		header  []string
+	}{
+		{sensors.Camera.Enabled, "camera", views.CameraCSV, marked(cameraHeader)},
+		{sensors.GPS.Enabled, "gps", views.GPSCSV, marked(gpsHeader)},
+		{sensors.IMU.Enabled, "imu", views.IMUCSV, marked(imuHeader)},
+		{sensors.Lidar.Enabled, "lidar", views.LidarCSV, marked(lidarHeader)},
+		{sensors.Radar.Enabled, "radar", views.RadarCSV, marked(views.SchemaColumns[views.RadarCSV])},
+		{sensors.Env.Enabled, "env", views.EnvCSV, marked(views.SchemaColumns[views.EnvCSV])},
+		{rc.trigger, "trigger", views.TriggerCSV, marked(views.SchemaColumns[views.TriggerCSV])},
+		{sensors.INS.Enabled, "ins", views.INSCSV, marked(views.SchemaColumns[views.INSCSV])},
+		{true, views.FusedTable, views.FusedCSV, marked(append(views.FusedColumns(rc.layout), cfg.CustomColumnNames()...))},
+		{layout.IMUBatch, views.FusedIMUTable, views.FusedIMUCSV, marked(views.SchemaColumns[views.FusedIMUCSV])},
+	}
+	var sinkTables []views.SinkTable
+	for _, t := range tables {
+		if !t.enabled {
+			continue
+		}
+		sinkTables = append(sinkTables, views.SinkTable{Name: t.table, Columns: t.header})
+		if cfg.Binary(t.table) {
+			continue
+		}
+		w, err := open(t.name, t.header)
+		if err != nil {
+			rc.closeWriters()
+			return nil, err
+		}
+		rc.csv.Add(t.table, w)
+	}
+	files := []struct {
+		enabled bool
+		dst     **views.CSVWriter
+		name    string
+		header  []string
+	}{
+		{cfg.Transform.Radar && sensors.Radar.Enabled, &rc.radarTransformed, views.RadarTransformedCSV, identified(views.SchemaColumns[views.RadarTransformedCSV])},
+		{cfg.SaveRadarDetections && sensors.Radar.Enabled, &rc.radarDetections, views.RadarDetectionsCSV, identified(views.SchemaColumns[views.RadarDetectionsCSV])},
+		{true, &rc.gaps, views.GapsCSV, identified(views.SchemaColumns[views.GapsCSV])},
+		{true, &rc.journal, views.BlobJournal, views.BlobJournalHeader},
+		{cfg.SystemStats.Enabled, &rc.system, views.SystemCSV, identified(views.SchemaColumns[views.SystemCSV])},
+		{true, &rc.events, views.EventsCSV, identified(views.SchemaColumns[views.EventsCSV])},
+		{stampsLidar(sensors) || stampsRadar(sensors), &rc.clockSync, views.ClockSyncCSV, identified(views.SchemaColumns[views.ClockSyncCSV])},
+	}
+	for _, f := range files {
+		if !f.enabled {
+			continue
+		}
+		w, err := open(f.name, f.header)
+		if err != nil {
+			rc.closeWriters()
+			return nil, err
+		}
+		*f.dst = w
+	}
+	logs := []struct {
+		enabled bool
+		dst     **views.RecordWriter
+		name    string
+		kind    string
+	}{
+		{sensors.IMU.Enabled && cfg.Binary("imu"), &rc.imuLog, views.IMUBin, "imu"},
+		{sensors.Radar.Enabled && cfg.Binary("radar"), &rc.radarLog, views.RadarBin, "radar"},
+	}
+	interval := time.Duration(cfg.BinaryIndexIntervalMs) * time.Millisecond
+	for _, l := range logs {
+		if !l.enabled {
+			continue
+		}
+		if cfg.DryRun {
+			*l.dst = views.NewDiscardRecordWriter(l.name, l.kind, interval)
+			continue
+		}
+		path := filepath.Join(dir, l.name)
+		create := views.NewRecordWriter
+		if !resumed.IsZero() && exists(path) {
+			create = views.AppendRecordWriter
+		}
+		w, err := create(path, l.kind, interval)
+		if err != nil {
+			rc.closeWriters()
+			return nil, err
+		}
+		*l.dst = w
+	}
+	captures := []struct {
+		enabled  bool
+		dst      **views.PcapWriter
+		name     string
+		linkType uint32
+	}{
+		{cfg.CapturePackets && capturesLidar(sensors), &rc.lidarPcap, views.LidarPcap, views.LinkTypeRaw},
+		{cfg.CapturePackets && capturesRadar(sensors), &rc.radarPcap, views.RadarPcap, views.LinkTypeCAN},
+	}
+	for _, c := range captures {
+		if !c.enabled {
+			continue
+		}
+		if cfg.DryRun {
+			*c.dst = views.NewDiscardPcapWriter(c.name, c.linkType)
+			continue
+		}
+		path := filepath.Join(dir, c.name)
+		create := views.NewPcapWriter
+		if !resumed.IsZero() && exists(path) {
+			create = views.AppendPcapWriter
+		}
+		w, err := create(path, c.linkType)
+		if err != nil {
+			rc.closeWriters()
+			return nil, err
+		}
+		*c.dst = w
+	}
+	detectors := []struct {
+		enabled bool
+		dst     **quality.GapDetector
+		name    string
+		rateHz  int
+		every   int
+	}{
+		{sensors.Camera.Enabled, &rc.cameraGaps, "camera", sensors.Camera.FPS, sensors.Camera.Decimate},
+		{sensors.GPS.Enabled, &rc.gpsGaps, "gps", sensors.GPS.RateHz, sensors.GPS.Decimate},
+		{sensors.IMU.Enabled, &rc.imuGaps, "imu", sensors.IMU.RateHz, sensors.IMU.Decimate},
+		{sensors.Lidar.Enabled, &rc.lidarGaps, "lidar", sensors.Lidar.RateHz, sensors.Lidar.Decimate},
+		{sensors.Radar.Enabled, &rc.radarGaps, "radar", sensors.Radar.RateHz, sensors.Radar.Decimate},
+		{sensors.Env.Enabled, &rc.envGaps, "env", sensors.Env.RateHz, sensors.Env.Decimate},
+		{rc.trigger, &rc.triggerGaps, "trigger", sensors.Trigger.RateHz, 1},
+		{sensors.INS.Enabled, &rc.insGaps, "ins", sensors.INS.RateHz, sensors.INS.Decimate},
+	}
+	for _, d := range detectors {
+		if d.enabled {
+			*d.dst = quality.NewGapDetector(d.name, d.rateHz, d.every)
+		}
+	}
+	if sensors.GPS.Enabled {
+		rc.odometer = quality.NewOdometer()
+	}
+	syncInterval := time.Duration(cfg.Clock.SyncIntervalS) * time.Second
+	if stampsLidar(sensors) {
+		rc.lidarClock = quality.NewClockSync("lidar", syncInterval)
+	}
+	if stampsRadar(sensors) {
+		rc.radarClock = quality.NewClockSync("radar", syncInterval)
+	}
+	if sensors.Adaptive.Enabled {
+		rc.policy = capture.NewPolicy(sensors.Adaptive, log)
+	}
+	var shed []string
+	if cfg.Degrade.Enabled {
+		shed = degradeSteps(cfg, sensors)
+		rc.degrade = degrade.NewGovernor(cfg.Degrade, shed, bus)
+	}
+	if cfg.SaveFrames && sensors.Camera.Enabled {
+		if rc.stereo {
+			rc.subdirs = append(rc.subdirs, leftFramesDir, rightFramesDir)
+		} else {
+			rc.subdirs = append(rc.subdirs, framesDir)
+		}
+		if fp := cfg.FrameProcessing; fp.Enabled {
+			rc.processing = &framecodec.Processing{ROI: fp.ROI.Rect(), MaxWidth: fp.MaxWidth, Quality: fp.Quality}
+			rc.fullResOn = map[string]bool{}
+			for _, kind := range fp.FullResOn {
+				rc.fullResOn[kind] = true
+			}
+		}
+		if cfg.FrameFormat != framecodec.JPEG || slices.Contains(shed, utils.DegradeJPEGQuality) || rc.processing != nil {
+			rc.frames = make(chan frameJob, 2*cfg.FrameWorkers)
+		}
+	}
+	if cfg.SaveClouds && sensors.Lidar.Enabled {
+		rc.subdirs = append(rc.subdirs, cloudsDir)
+	}
+	if cfg.Transform.Lidar && sensors.Lidar.Enabled {
+		rc.subdirs = append(rc.subdirs, transformedCloudsDir)
+	}
+	if rc.radarDetections != nil {
+		rc.subdirs = append(rc.subdirs, radarDetectionsDir)
+	}
+	if rc.layout.RadarGrid {
+		rc.radarGrid = radargrid.New(cfg.RadarGrid)
+		rc.subdirs = append(rc.subdirs, radarGridsDir)
+	}
+	if cfg.Transform.Lidar || cfg.Transform.Radar {
+		rc.transformer = transform.NewTransformer(sensors.Calibration)
+		rc.frame = transform.Frame(cfg.Transform.Frame)
+	}
+	if cfg.Transform.Deskew && sensors.Lidar.Enabled {
+		rc.deskew = transform.NewDeskewer(sensors.Calibration)
+	}
+	if err := rc.prepareDir(dir); err != nil {
+		rc.closeWriters()
+		return nil, err
+	}
+	if err := rc.openFrameStore(dir, !resumed.IsZero()); err != nil {
+		rc.closeWriters()
+		return nil, fmt.Errorf("frame store: %w", err)
+	}
+	if !resumed.IsZero() {
+		if err := rc.resume(resumed); err != nil {
+			rc.closeWriters()
+			return nil, err
+		}
+	}
+	// A dry run only counts the csv sink.
+	for _, sc := range cfg.Sinks {
+		if sc.Type == utils.SinkCSV || cfg.DryRun {
+			continue
+		}
+		s, err := views.OpenSink(sc, dir, rc.run, sinkTables, log)
+		if err != nil {
+			rc.closeWriters()
+			return nil, fmt.Errorf("sink %s: %w", sc.Type, err)
+		}
+		rc.sinks = append(rc.sinks, &sink{Sink: s})
+	}
+	if rc.frames != nil {
+		for range cfg.FrameWorkers {
+			go rc.encodeFrames()
+		}
+	}
+	rc.eventSub = bus.Subscribe(64)
+	rc.eventsDone = make(chan struct{})
+	go func() {
+		defer close(rc.eventsDone)
+		for e := range rc.eventSub.C {
+			rc.write(rc.events, e.CSVRow())
+			if rc.fullResOn[e.Kind] {
+				rc.KeepFullRes(time.Duration(cfg.FrameProcessing.FullResS) * time.Second)
+			}
+		}
+	}()
+	return rc, nil
+}
+
+// resume notes the interruption since lastWrite in gaps.csv, after those
+// of earlier resumptions.
+func (rc *RecordingController) resume(lastWrite time.Time) error {
+	t, err := views.ReadTable(rc.gaps.Path())
+	if err != nil {
+		return err
+	}
+	for i := range t.Rows {
+		if t.String(i, "sensor") != resumeGapSensor {
+			continue
+		}
+		start, err1 := utils.ParseTimestamp(t.String(i, "start"))
+		end, err2 := utils.ParseTimestamp(t.String(i, "end"))
+		if err1 == nil && err2 == nil {
+			rc.interruptions = append(rc.interruptions, views.Interruption{Start: start, End: end, DurationS: end.Sub(start).Seconds()})
+		}
+	}
+	g := models.Gap{Sensor: resumeGapSensor, Start: lastWrite.UTC(), End: rc.clock.Now()}
+	rc.interruptions = append(rc.interruptions, views.Interruption{Start: g.Start, End: g.End, DurationS: g.Duration().Seconds()})
+	rc.run = len(rc.interruptions)
+	rc.write(rc.gaps, g.CSVRow())
+	rc.log.Infof("recording: resuming after %v down", g.Duration().Round(time.Second))
+	return nil
+}
+
+// openFrameStore opens the configured store for the frames of the
+// session in dir, continuing the archive of an earlier run when resumed.
+// A dry run has none.
+func (rc *RecordingController) openFrameStore(dir string, resumed bool) error {
+	if rc.dryRun != nil || !slices.ContainsFunc(rc.subdirs, isFramesDir) {
+		return nil
+	}
+	var err error
+	switch rc.cfg.FrameStore.Type {
+	case utils.FrameStoreTar:
+		if resumed && exists(filepath.Join(dir, views.FramesTar)) {
+			rc.frameStore, err = views.AppendTarFrameStore(dir)
+		} else {
+			rc.frameStore, err = views.NewTarFrameStore(dir)
+		}
+	case utils.FrameStoreS3:
+		rc.frameStore, err = views.NewS3FrameStore(rc.cfg.FrameStore.S3, dir)
+		rc.remoteFrames = true
+	default:
+		rc.frameStore = views.NewDirFrameStore(dir, rc.blobs)
+	}
+	return err
+}
+
+// isFramesDir reports whether sub is a directory of saved frames.
+func isFramesDir(sub string) bool {
+	return sub == framesDir || sub == leftFramesDir || sub == rightFramesDir
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// blobPath names the file of frame, cloud or grid n under dir. The runs of
+// a resumed session number their files from 0 again and so get a prefix.
+func (rc *RecordingController) blobPath(dir string, n uint64, ext string) string {
+	if rc.run == 0 {
+		return filepath.Join(dir, fmt.Sprintf("%08d.%s", n, ext))
+	}
+	return filepath.Join(dir, fmt.Sprintf("r%d_%08d.%s", rc.run, n, ext))
+}
+
+// prepareDir creates the subdirectories and calibration files of a session
+// directory.
+func (rc *RecordingController) prepareDir(dir string) error {
+	if rc.dryRun != nil {
+		return nil
+	}
+	for _, sub := range rc.subdirs {
+		if isFramesDir(sub) && rc.cfg.FrameStore.Type != utils.FrameStoreDir {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return err
+		}
+	}
+	if err := views.WriteSessionID(dir, rc.sessionID); err != nil {
+		return err
+	}
+	if rc.calibration.Configured() {
+		return views.WriteCalibration(dir, rc.calibration)
+	}
+	return nil
+}
+
+// FusedLayout returns the optional column groups of the session's fused
+// records.
+func (rc *RecordingController) FusedLayout() models.FusedLayout { return rc.layout }
+
+// Projector returns the projector of the fixes of the session, for the
+// fusion to set their projected positions with; nil without a projection.
+func (rc *RecordingController) Projector() *transform.Projector { return rc.projector }
+
+func newProjector(kind string) *transform.Projector {
+	if kind == "" {
+		return nil
+	}
+	return transform.NewProjector(kind)
+}
+
+// Dir returns the session directory, which changes on failover.
+func (rc *RecordingController) Dir() string {
+	rc.dirMu.RLock()
+	defer rc.dirMu.RUnlock()
+	return rc.dir
+}
+
+func (rc *RecordingController) RecordCamera(f models.CameraFrame) {
+	rc.noteGap(rc.cameraGaps.ObserveSeq(f.Timestamp, f.FrameID))
+	invalid := validate.Camera(f)
+	if !rc.check("camera", invalid) {
+		return
+	}
+	if rc.cfg.SaveFrames && (rc.policy == nil || rc.policy.KeepFrame(f.Timestamp)) && (rc.degrade == nil || rc.degrade.KeepFrame()) {
+		exif := rc.exif(f.Timestamp)
+		if rc.stereo {
+			f.Path = rc.saveFrame(leftFramesDir, f.FrameID, f.Data, exif)
+			if f.Right != nil {
+				// Right is shared with the other recorders of the frame.
+				right := *f.Right
+				right.Path = rc.saveFrame(rightFramesDir, f.FrameID, right.Data, exif)
+				f.Right = &right
+			}
+		} else {
+			f.Path = rc.saveFrame(framesDir, f.FrameID, f.Data, exif)
+		}
+	}
+	row := f.CSVRow()
+	if rc.stereo {
+		row = append(row, f.StereoCSVRow()...)
+	}
+	if rc.trigger {
+		row = append(row, f.Trigger.CSVRow()...)
+	}
+	if rc.freeze {
+		row = append(row, f.FreezeCSVRow()...)
+	}
+	rc.writeSensor("camera", f.Timestamp, rc.mark(row, invalid))
+}
+
+// exifFixAge is the age of the last GPS fix past which frames are saved
+// without a position.
+const exifFixAge = 2 * time.Second
+
+// exif returns the EXIF of a frame captured at ts, nil unless frame_exif
+// is set. The position is left out when the session encrypts it.
+func (rc *RecordingController) exif(ts time.Time) *framecodec.EXIF {
+	if !rc.cfg.FrameEXIF {
+		return nil
+	}
+	e := &framecodec.EXIF{Time: ts}
+	if rc.cfg.LocationEncryption.Enabled {
+		return e
+	}
+	rc.fixMu.Lock()
+	g := rc.lastFix
+	rc.fixMu.Unlock()
+	if g != nil && ts.Sub(g.Timestamp).Abs() <= exifFixAge {
+		e.GPS = &framecodec.EXIFGPS{
+			Time: g.Timestamp, Lat: g.Lat, Lon: g.Lon, Alt: g.Alt, SpeedMps: g.SpeedMps, HeadingDeg: g.HeadingDeg,
+		}
+	}
+	return e
+}
+
+// withEXIF returns the JPEG frame jpg tagged with e, or as it is if e is
+// nil or jpg cannot be tagged.
+func (rc *RecordingController) withEXIF(jpg []byte, e *framecodec.EXIF) []byte {
+	if e == nil {
+		return jpg
+	}
+	tagged, err := framecodec.WithEXIF(jpg, *e)
+	if err != nil {
+		if rc.exifWarned.CompareAndSwap(false, true) {
+			rc.log.Warnf("recording: frames saved without EXIF: %v", err)
+		}
+		return jpg
+	}
+	return tagged
+}
+
+// frameJob is a frame waiting to be transcoded and saved at path; a
+// non-zero quality re-encodes it as JPEG instead, and process crops and
+// scales it first. exif is embedded in the result when set.
+type frameJob struct {
+	path    string
+	jpg     []byte
+	quality int
+	process bool
+	exif    *framecodec.EXIF
+}
+
+// saveFrame saves the JPEG frame jpg of the given id under dir in the
+// configured frame format, with exif if set, and returns its path.
+// Frames are handed to the encoders without waiting; one that finds them
+// all busy and the queue full is saved as captured instead, unless it was
+// to be cropped or scaled: that one is not saved and its path is empty.
+func (rc *RecordingController) saveFrame(dir string, id uint64, jpg []byte, exif *framecodec.EXIF) string {
+	job := frameJob{jpg: jpg, exif: exif}
+	if rc.degrade != nil && rc.cfg.FrameFormat == framecodec.JPEG {
+		job.quality = rc.degrade.JPEGQuality()
+	}
+	if rc.processing != nil {
+		if rc.clock.Now().UnixNano() < rc.fullResUntil.Load() {
+			rc.fullRes.Add(1)
+		} else {
+			job.process = true
+		}
+	}
+	if rc.frames != nil && (rc.cfg.FrameFormat != framecodec.JPEG || job.quality > 0 || job.process) {
+		job.path = rc.blobPath(dir, id, framecodec.Ext(rc.cfg.FrameFormat))
+		rc.wg.Add(1)
+		select {
+		case rc.frames <- job:
+			return job.path
+		default:
+			rc.wg.Done()
+			if job.process {
+				rc.processSkipped.Add(1)
+				return ""
+			}
+			if job.quality == 0 {
+				rc.jpegFallbacks.Add(1)
+			}
+		}
+	}
+	path := rc.blobPath(dir, id, framecodec.Ext(framecodec.JPEG))
+	rc.storeFrame(path, rc.withEXIF(jpg, exif))
+	return path
+}
+
+// encodeFrames transcodes and saves queued frames until Stop closes the
+// queue. A frame that fails to transcode counts as a save error; its
+// camera.csv row loses the path when the session closes.
+func (rc *RecordingController) encodeFrames() {
+	for job := range rc.frames {
+		var data []byte
+		var err error
+		switch {
+		case job.process:
+			p := *rc.processing
+			if job.quality > 0 {
+				p.Quality = min(p.Quality, job.quality)
+			}
+			data, err = framecodec.Process(job.jpg, p, rc.cfg.FrameFormat)
+			if err == nil {
+				rc.processed.Add(1)
+			}
+		case job.quality > 0:
+			data, err = framecodec.Reencode(job.jpg, job.quality)
+		default:
+			data, err = framecodec.Encode(rc.cfg.FrameFormat, job.jpg)
+		}
+		if err != nil {
+			if rc.saveErrors.Add(1) == 1 {
+				rc.bus.Publishf(events.WriteFailed, events.Error, "recording", "save %s: %v (further save errors are only counted)", job.path, err)
+			}
+		} else {
+			rc.storeFrame(job.path, rc.withEXIF(data, job.exif))
+		}
+		rc.wg.Done()
+	}
+}
+
+// KeepFullRes saves frames as captured, without cropping or scaling, for
+// d from now; a span already running is extended. It does nothing unless
+// frame processing is enabled.
+func (rc *RecordingController) KeepFullRes(d time.Duration) {
+	if rc.processing == nil {
+		return
+	}
+	now := rc.clock.Now()
+	until := now.Add(d).UnixNano()
+	for {
+		cur := rc.fullResUntil.Load()
+		if cur >= until {
+			return
+		}
+		if rc.fullResUntil.CompareAndSwap(cur, until) {
+			if cur <= now.UnixNano() {
+				rc.bus.Publishf(events.FullRes, events.Info, "recording", "saving full-resolution frames for %v", d)
+			}
+			return
+		}
+	}
+}
+
+// ReportThrottle makes the manifest report how t throttled the simulated
+// sensors; see utils.SimThrottleConfig.
+func (rc *RecordingController) ReportThrottle(t *thermal.Throttle) { rc.throttle = t }
+
+// ServeFullRes answers POST /camera/full-res by saving frames at full
+// resolution for the seconds given by the s parameter, frame_processing's
+// full_res_s by default.
+func (rc *RecordingController) ServeFullRes(w http.ResponseWriter, r *http.Request) {
+	secs := rc.cfg.FrameProcessing.FullResS
+	if v := r.FormValue("s"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "s must be a positive number of seconds", http.StatusBadRequest)
+			return
+		}
+		secs = n
+	}
+	rc.KeepFullRes(time.Duration(secs) * time.Second)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// maxNoteLen bounds the notes added over HTTP.
+const maxNoteLen = 4096
+
+// AddNote takes a free-text note on the session, at "start", "stop" or
+// "" while it runs. The note goes to the manifest and, as a note event, to
+// events.csv, which keeps it should the session not close cleanly. Blank
+// notes are ignored.
+func (rc *RecordingController) AddNote(at, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	n := views.Note{Time: rc.clock.Now(), At: at, Text: text}
+	rc.notesMu.Lock()
+	rc.notes = append(rc.notes, n)
+	rc.notesMu.Unlock()
+	source := "note"
+	if at != "" {
+		source = at + " note"
+	}
+	rc.bus.Publish(models.Event{Time: n.Time, Kind: events.Note, Level: events.Info, Source: source, Message: text})
+}
+
+// Notes returns the notes taken so far.
+func (rc *RecordingController) Notes() []views.Note {
+	rc.notesMu.Lock()
+	defer rc.notesMu.Unlock()
+	return slices.Clone(rc.notes)
+}
+
+// ServeNotes answers GET /notes with the tags and notes of the session as
+// JSON and POST /notes with form value text by adding a note.
+func (rc *RecordingController) ServeNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		text := strings.TrimSpace(r.FormValue("text"))
+		if text == "" || len(text) > maxNoteLen {
+			http.Error(w, fmt.Sprintf("text must be between 1 and %d bytes", maxNoteLen), http.StatusBadRequest)
+			return
+		}
+		rc.AddNote("", text)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Tags  []string     `json:"tags"`
+		Notes []views.Note `json:"notes"`
+	}{rc.cfg.Tags, rc.Notes()})
+}
+
+func (rc *RecordingController) RecordGPS(g models.GPSData) {
+	rc.noteGap(rc.gpsGaps.ObserveTime(g.Timestamp))
+	invalid := validate.GPS(g)
+	if !rc.check("gps", invalid) {
+		return
+	}
+	if rc.transformer != nil {
+		rc.transformer.UpdatePose(g)
+	}
+	if rc.deskew != nil {
+		rc.deskew.ObserveGPS(g)
+	}
+	if rc.policy != nil {
+		rc.policy.ObserveGPS(g)
+	}
+	if rc.odometer != nil {
+		rc.odometer.ObserveGPS(g)
+	}
+	row := rc.seal(views.GPSCSV, rc.units[views.GPSCSV].Convert(g.CSVRow()))
+	if rc.projector != nil {
+		row = append(row, g.Projected.CSVRow(rc.projector.Kind())...)
+	}
+	rc.writeSensor("gps", g.Timestamp, rc.mark(row, invalid))
+	rc.fixMu.Lock()
+	rc.fixes[g.FixQuality]++
+	// Encrypted positions are not given away in the frames.
+	if g.FixQuality > 0 && rc.cfg.FrameEXIF && rc.location == nil {
+		rc.lastFix = &g
+	}
+	rc.fixMu.Unlock()
+}
+
+func (rc *RecordingController) RecordIMU(d models.IMUData) {
+	rc.noteGap(rc.imuGaps.ObserveSeq(d.Timestamp, d.Seq))
+	invalid := validate.IMU(d)
+	if !rc.check("imu", invalid) {
+		return
+	}
+	if rc.deskew != nil {
+		rc.deskew.ObserveIMU(d)
+	}
+	if rc.imuLog != nil {
+		rc.writeRecord(rc.imuLog, d.Timestamp, d)
+	}
+	row := d.CSVRow()
+	if rc.attitude {
+		row = rc.units[views.IMUCSV].Convert(append(row, d.Attitude.CSVRow()...))
+	}
+	rc.writeSensor("imu", d.Timestamp, rc.mark(row, invalid))
+}
+
+func (rc *RecordingController) RecordLidar(p models.LidarPacket) {
+	rc.noteGap(rc.lidarGaps.ObserveSeq(p.Timestamp, p.Seq))
+	rc.noteClock(rc.lidarClock, p.Timestamp, p.DeviceTime)
+	invalid := validate.Lidar(p)
+	if !rc.check("lidar", invalid) {
+		return
+	}
+	keep := (rc.cfg.SaveClouds || rc.cfg.Transform.Lidar) && (rc.policy == nil || rc.policy.KeepCloud(p.Timestamp)) &&
+		(rc.degrade == nil || rc.degrade.KeepCloud())
+	if keep && rc.deskew != nil {
+		p = rc.deskew.Deskew(p)
+	}
+	if keep && rc.cfg.SaveClouds {
+		p.Path = rc.blobPath(cloudsDir, p.Seq, cloudcodec.Ext(rc.cfg.CloudCompression))
+		rc.saveCloud(p)
+	}
+	if keep && rc.cfg.Transform.Lidar {
+		if pts, ok := rc.transformer.LidarPoints(p, rc.frame); ok {
+			rc.saveFile(rc.blobPath(transformedCloudsDir, p.Seq, "bin"), models.EncodePoints(pts, p.Format))
+		}
+	}
+	row := p.CSVRow()
+	if rc.trigger {
+		row = append(row, p.Trigger.CSVRow()...)
+	}
+	rc.writeSensor("lidar", p.Timestamp, rc.mark(row, invalid))
+}
+
+func (rc *RecordingController) RecordRadar(s models.RadarScan) {
+	rc.noteGap(rc.radarGaps.ObserveSeq(s.Timestamp, s.Seq))
+	rc.noteClock(rc.radarClock, s.Timestamp, s.DeviceTime)
+	invalid := validate.Radar(s)
+	if !rc.check("radar", invalid) {
+		return
+	}
+	if rc.radarLog != nil {
+		rc.writeRecord(rc.radarLog, s.Timestamp, s)
+	}
+	if rc.radarDetections != nil && len(s.Detections) > 0 {
+		s.DetectionsPath = rc.blobPath(radarDetectionsDir, s.Seq, "bin")
+		rc.saveFile(s.DetectionsPath, models.EncodeDetections(s.Detections))
+		rc.write(rc.radarDetections, s.DetectionsCSVRow())
+	}
+	for _, row := range s.CSVRows() {
+		rc.writeSensor("radar", s.Timestamp, rc.mark(rc.units[views.RadarCSV].Convert(row), invalid))
+	}
+	if rc.radarGrid != nil {
+		rc.radarGrid.Add(s)
+	}
+	if rc.radarTransformed != nil {
+		pts, ok := rc.transformer.RadarTargets(s, rc.frame)
+		if !ok {
+			return
+		}
+		ts := utils.FormatTimestamp(s.Timestamp)
+		seq := strconv.FormatUint(s.Seq, 10)
+		for i, pt := range pts {
+			rc.write(rc.radarTransformed, []string{
+				ts, seq, strconv.Itoa(s.Targets[i].ID), string(rc.frame),
+				strconv.FormatFloat(pt.X, 'f', 3, 64),
+				strconv.FormatFloat(pt.Y, 'f', 3, 64),
+				strconv.FormatFloat(pt.Z, 'f', 3, 64),
+			})
+		}
+	}
+}
+
+func (rc *RecordingController) RecordEnv(e models.EnvData) {
+	rc.noteGap(rc.envGaps.ObserveTime(e.Timestamp))
+	invalid := validate.Env(e)
+	if !rc.check("env", invalid) {
+		return
+	}
+	rc.writeSensor("env", e.Timestamp, rc.mark(e.CSVRow(), invalid))
+}
+
+func (rc *RecordingController) RecordTrigger(p models.TriggerPulse) {
+	rc.noteGap(rc.triggerGaps.ObserveSeq(p.Timestamp, p.ID))
+	rc.writeSensor("trigger", p.Timestamp, rc.mark(p.CSVRow(), ""))
+}
+
+func (rc *RecordingController) RecordINS(d models.INSData) {
+	rc.noteGap(rc.insGaps.ObserveSeq(d.Timestamp, d.Seq))
+	invalid := validate.INS(d)
+	if !rc.check("ins", invalid) {
+		return
+	}
+	rc.writeSensor("ins", d.Timestamp, rc.mark(d.CSVRow(), invalid))
+}
+
+// seal encrypts the location columns of row, a row of file, when
+// location encryption is on.
+func (rc *RecordingController) seal(file string, row []string) []string {
+	if rc.location == nil {
+		return row
+	}
+	for i, c := range rc.sealed[file] {
+		row[c] = rc.location.Seal(privacy.LocationColumns[file][i], row[c])
+	}
+	return row
+}
+
+// check counts a record of sensor that failed validation for the reason
+// invalid ("" for a valid record) and reports whether to record it.
+func (rc *RecordingController) check(sensor, invalid string) bool {
+	if rc.tally == nil || invalid == "" {
+		return true
+	}
+	rc.tally.Add(sensor, invalid)
+	return rc.cfg.Validation.Action != utils.ValidationDrop
+}
+
+// mark appends the valid column to row when validation marks records.
+func (rc *RecordingController) mark(row []string, invalid string) []string {
+	if rc.tally == nil || rc.cfg.Validation.Action != utils.ValidationMark {
+		return row
+	}
+	if invalid != "" {
+		return append(row, "0")
+	}
+	return append(row, "1")
+}
+
+func (rc *RecordingController) noteGap(g models.Gap, ok bool) {
+	if !ok {
+		return
+	}
+	rc.write(rc.gaps, g.CSVRow())
+	rc.log.Debugf("recording: %s gap of %v (%d samples missing)", g.Sensor, g.Duration(), g.Missing)
+}
+
+// noteClock hands a sample stamped device by its sensor to c, and writes
+// the estimate c completes to clock_sync.csv.
+func (rc *RecordingController) noteClock(c *quality.ClockSync, host time.Time, device *time.Time) {
+	if c == nil || device == nil {
+		return
+	}
+	if o, ok := c.Observe(host, *device); ok {
+		rc.write(rc.clockSync, o.CSVRow())
+	}
+}
+
+// capturesLidar and capturesRadar report whether the lidar and the radar
+// of sensors are devices whose packets can be captured. A lidar replayed
+// from a capture has one already.
+func capturesLidar(sensors *utils.SensorsConfig) bool {
+	return sensors.Lidar.Enabled && sensors.Lidar.Address != utils.SimDevice && sensors.Lidar.Address != utils.RemoteDevice &&
+		!strings.HasPrefix(sensors.Lidar.Address, utils.PcapPrefix)
+}
+
+func capturesRadar(sensors *utils.SensorsConfig) bool {
+	return sensors.Radar.Enabled && sensors.Radar.Protocol == utils.RadarARS408 &&
+		sensors.Radar.Address != utils.SimDevice && sensors.Radar.Address != utils.RemoteDevice
+}
+
+// Capture returns what the reader of sensor, lidar or radar, hands its
+// packets to, nil when they are not captured.
+func (rc *RecordingController) Capture(sensor string) ingest.Capture {
+	w := map[string]*views.PcapWriter{"lidar": rc.lidarPcap, "radar": rc.radarPcap}[sensor]
+	if w == nil {
+		return nil
+	}
+	return func(ts time.Time, packet []byte) {
+		if err := w.Write(ts, packet); err != nil {
+			rc.writeFailed(w, err)
+		}
+	}
+}
+
+// stampsLidar and stampsRadar report whether the lidar and the radar of
+// sensors send the time of their samples by their own clock. The clock
+// of a replayed lidar ran at another time than the host's.
+func stampsLidar(sensors *utils.SensorsConfig) bool {
+	return sensors.Lidar.Enabled && sensors.Lidar.Format == utils.LidarVLP16 && sensors.Lidar.Address != utils.SimDevice &&
+		!strings.HasPrefix(sensors.Lidar.Address, utils.PcapPrefix)
+}
+
+func stampsRadar(sensors *utils.SensorsConfig) bool {
+	return sensors.Radar.Enabled && sensors.Radar.Protocol == utils.RadarJSON && sensors.Radar.Address != utils.SimDevice
+}
+
+// saveFile writes data to rel (relative to the session dir).
+func (rc *RecordingController) saveFile(rel string, data []byte) {
+	rc.saveBlob(rel, data, func() error {
+		return rc.blobs.WriteFile(filepath.Join(rc.Dir(), rel), data)
+	}, true)
+}
+
+// storeFrame saves the frame data at rel, relative to the session, in the
+// frame store.
+func (rc *RecordingController) storeFrame(rel string, data []byte) {
+	rc.saveBlob(rel, data, func() error {
+		return rc.frameStore.Put(filepath.ToSlash(rel), data)
+	}, !rc.remoteFrames)
+}
+
+// saveBlob saves data at rel with put in the background so the drain
+// goroutines are never blocked on disk, and journals rel once it is
+// complete. A failure is counted, and escalated if local.
+func (rc *RecordingController) saveBlob(rel string, data []byte, put func() error, local bool) {
+	if rc.dryRun != nil {
+		rc.dryRun.add(rel, len(data))
+		rc.savedFiles.Add(1)
+		rc.savedBytes.Add(int64(len(data)))
+		return
+	}
+	queued := time.Now()
+	rc.wg.Add(1)
+	go func() {
+		defer rc.wg.Done()
+		if err := put(); err != nil {
+			if rc.saveErrors.Add(1) == 1 {
+				rc.bus.Publishf(events.WriteFailed, events.Error, "recording", "save %s: %v (further save errors are only counted)", rel, err)
+			}
+			if local {
+				rc.escalate(err)
+			}
+			return
+		}
+		if rc.degrade != nil {
+			rc.degrade.ObserveWrite(time.Since(queued))
+		}
+		rc.write(rc.journal, []string{filepath.ToSlash(rel)})
+		rc.savedFiles.Add(1)
+		rc.savedBytes.Add(int64(len(data)))
+	}()
+}
+
+// saveCloud saves the cloud of p at p.Path, compressed in the background
+// unless clouds are saved as received.
+func (rc *RecordingController) saveCloud(p models.LidarPacket) {
+	if rc.cfg.CloudCompression == cloudcodec.None {
+		rc.saveFile(p.Path, p.RawCloud)
+		return
+	}
+	rc.wg.Add(1)
+	go func() {
+		defer rc.wg.Done()
+		rc.saveFile(p.Path, cloudcodec.Encode(rc.cfg.CloudCompression, p.RawCloud,
+			models.PointSize(p.PointFormat()), rc.cfg.CloudStepMM/1000))
+	}()
+}
+
+// write appends row to w and escalates a failure.
+func (rc *RecordingController) write(w *views.CSVWriter, row []string) {
+	if w != rc.journal {
+		row = rc.identify(row)
+	}
+	if err := w.Write(row); err != nil {
+		rc.writeFailed(w, err)
+	}
+}
+
+// sink is a sink of the session and its failure count.
+type sink struct {
+	views.Sink
+	errors atomic.Int64
+}
+
+// writeSensor hands a row of sensor to every sink and escalates failures.
+func (rc *RecordingController) writeSensor(sensor string, ts time.Time, row []string) {
+	row = rc.identify(rc.clockEvent(sensor, row))
+	for _, s := range rc.sinks {
+		if err := s.WriteSensor(sensor, ts, row); err != nil {
+			rc.sinkFailed(s, err)
+		}
+	}
+}
+
+// writeFused hands a fused row to every sink and escalates failures.
+func (rc *RecordingController) writeFused(ts time.Time, row []string) {
+	row = rc.identify(rc.clockEvent(views.FusedTable, row))
+	for _, s := range rc.sinks {
+		if err := s.WriteFused(ts, row); err != nil {
+			rc.sinkFailed(s, err)
+		}
+	}
+}
+
+// identify appends the session and the next record ID to row when
+// record IDs are on.
+func (rc *RecordingController) identify(row []string) []string {
+	if !rc.cfg.RecordIDs {
+		return row
+	}
+	return append(row, rc.sessionID, strconv.FormatUint(rc.recordID.Add(1), 10))
+}
+
+// clockEvent logs the clock jumps detected since the last row written and,
+// when jumps are flagged, appends the clock_event column to a row of table:
+// the sum of the jumps since the last row of table, empty if there were
+// none.
+func (rc *RecordingController) clockEvent(table string, row []string) []string {
+	flag := rc.cfg.Clock.Mode == utils.ClockFlag
+	n := utils.ClockJumpCount()
+	if !flag && int64(n) == rc.clockLogged.Load() {
+		return row
+	}
+	rc.clockMu.Lock()
+	defer rc.clockMu.Unlock()
+	var jumps []utils.ClockJump
+	if logged := int(rc.clockLogged.Load()); n > logged {
+		jumps = utils.ClockJumps()
+		for _, j := range jumps[logged:n] {
+			action := "timestamps follow the wall clock"
+			if rc.cfg.Clock.Mode == utils.ClockCorrect {
+				action = "timestamps continue from the monotonic clock"
+			}
+			rc.bus.Publish(models.Event{Time: j.At, Kind: events.ClockJump, Level: events.Warn, Source: "recording",
+				Message: fmt.Sprintf("wall clock jumped %+.3fs at %s; %s", j.StepS, j.At.Format(time.RFC3339Nano), action)})
+		}
+		rc.clockLogged.Store(int64(n))
+	}
+	if !flag {
+		return row
+	}
+	seen, ok := rc.clockSeen[table]
+	if !ok {
+		seen = rc.clockBase
+	}
+	rc.clockSeen[table] = n
+	if n == seen {
+		return append(row, "")
+	}
+	if jumps == nil {
+		jumps = utils.ClockJumps()
+	}
+	var step float64
+	for _, j := range jumps[seen:n] {
+		step += j.StepS
+	}
+	return append(row, strconv.FormatFloat(step, 'f', 3, 64))
+}
+
+// sinkFailed publishes the first error of each sink and escalates it
+// according to the write error policy.
+func (rc *RecordingController) sinkFailed(s *sink, err error) {
+	if s.errors.Add(1) == 1 {
+		rc.bus.Publishf(events.WriteFailed, events.Error, "recording", "%s sink: %v (further errors of this sink are only counted)", s.Name(), err)
+	}
+	rc.escalate(err)
+}
+
+// writeRecord appends a record to a binary log and escalates a failure.
+func (rc *RecordingController) writeRecord(w *views.RecordWriter, ts time.Time, rec encoding.BinaryMarshaler) {
+	if err := w.Write(ts, rec); err != nil {
+		rc.writeFailed(w, err)
+	}
+}
+
+// writeFailed publishes the first error of each file, since a failed file
+// keeps failing, and escalates it according to the write error policy.
+func (rc *RecordingController) writeFailed(w outputFile, err error) {
+	if e := w.Errors(); e.Write+e.Flush == 1 {
+		rc.bus.Publishf(events.WriteFailed, events.Error, "recording", "%v (further errors on this file are only counted)", err)
+	}
+	rc.escalate(err)
+}
+
+func (rc *RecordingController) escalate(err error) {
+	if rc.cfg.FallbackDir != "" && rc.failOver(err) {
+		return
+	}
+	if rc.cfg.OnWriteError != utils.WriteErrorAbort {
+		return
+	}
+	rc.failOnce.Do(func() {
+		rc.failErr = err
+		close(rc.failed)
+	})
+}
+
+// failOver moves the session to the fallback directory. Every file is
+// reopened there, so rows buffered for the failed disk are lost but
+// recording continues. It reports false if the session already failed
+// over (or cannot), leaving err to the write error policy. Late errors of
+// files on the old disk are absorbed.
+func (rc *RecordingController) failOver(err error) bool {
+	rc.dirMu.Lock()
+	if rc.failover != nil {
+		var pe *fs.PathError
+		stale := errors.As(err, &pe) && !strings.HasPrefix(pe.Path, rc.failover.To+string(filepath.Separator))
+		rc.dirMu.Unlock()
+		return stale
+	}
+	from := rc.dir
+	to := filepath.Join(rc.cfg.FallbackDir, filepath.Base(from))
+	rc.failover = &views.Failover{At: rc.clock.Now(), From: from, To: to, Reason: err.Error()}
+	rc.dirMu.Unlock()
+
+	rc.bus.Publishf(events.Failover, events.Error, "recording", "%v; failing over to %s", err, to)
+	if err := os.MkdirAll(to, 0o755); err != nil {
+		rc.log.Errorf("recording: failover: %v", err)
+		return false
+	}
+	if err := rc.prepareDir(to); err != nil {
+		rc.log.Errorf("recording: failover: %v", err)
+		return false
+	}
+	if rc.location != nil {
+		if err := rc.location.WriteKey(to); err != nil {
+			rc.log.Errorf("recording: failover: %v", err)
+			return false
+		}
+	}
+	for _, w := range rc.writers() {
+		if err := w.Reopen(filepath.Join(to, filepath.Base(w.Path()))); err != nil {
+			rc.log.Errorf("recording: failover: %v", err)
+			return false
+		}
+	}
+	if r, ok := rc.frameStore.(interface{ Reopen(dir string) error }); ok {
+		if err := r.Reopen(to); err != nil {
+			rc.log.Errorf("recording: failover: frame store: %v", err)
+			return false
+		}
+	}
+	for _, s := range rc.sinks {
+		if d, ok := s.Sink.(views.DirSink); ok {
+			if err := d.Reopen(to); err != nil {
+				rc.log.Errorf("recording: failover: %s sink: %v", s.Name(), err)
+				return false
+			}
+		}
+	}
+	rc.dirMu.Lock()
+	rc.dir = to
+	rc.dirMu.Unlock()
+	rc.log.Infof("recording: session continues in %s", to)
+	return true
+}
+
+// Failed is closed when a write error requires the run to stop; Err then
+// returns that error.
+func (rc *RecordingController) Failed() <-chan struct{} { return rc.failed }
+
+func (rc *RecordingController) Err() error {
+	select {
+	case <-rc.failed:
+		return rc.failErr
+	default:
+		return nil
+	}
+}
+
+// Metrics reports the row, byte, flush and error counters of every file
+// and the error counts of the sinks.
+func (rc *RecordingController) Metrics() []metrics.Sample {
+	var out []metrics.Sample
+	for _, w := range rc.writers() {
+		file := map[string]string{"file": filepath.Base(w.Path())}
+		st := w.Stats()
+		healthy := 1.0
+		if st.Err != nil {
+			healthy = 0
+		}
+		out = append(out,
+			metrics.Sample{Name: "sensor_logger_writer_rows_total", Help: "Rows written per file.", Type: metrics.Counter, Labels: file, Value: float64(st.Rows)},
+			metrics.Sample{Name: "sensor_logger_writer_bytes_total", Help: "Bytes written per file.", Type: metrics.Counter, Labels: file, Value: float64(st.Bytes)},
+			metrics.Sample{Name: "sensor_logger_writer_pending_bytes", Help: "Bytes buffered per file, not yet handed to the operating system.", Type: metrics.Gauge, Labels: file, Value: float64(st.Pending)},
+			metrics.Sample{Name: "sensor_logger_writer_flushes_total", Help: "Flushes per file.", Type: metrics.Counter, Labels: file, Value: float64(st.Flushes)},
+			metrics.Sample{Name: "sensor_logger_writer_flush_seconds_total", Help: "Time spent flushing per file.", Type: metrics.Counter, Labels: file, Value: st.FlushTime.Seconds()},
+			metrics.Sample{Name: "sensor_logger_writer_flush_seconds_max", Help: "Slowest flush per file.", Type: metrics.Gauge, Labels: file, Value: st.MaxFlush.Seconds()},
+			metrics.Sample{Name: "sensor_logger_writer_healthy", Help: "Whether the last write and flush of the file succeeded.", Type: metrics.Gauge, Labels: file, Value: healthy},
+			metrics.Sample{Name: "sensor_logger_writer_errors_total", Help: "Failed writes and flushes per file.", Type: metrics.Counter,
+				Labels: map[string]string{"file": file["file"], "op": "write"}, Value: float64(st.Errors.Write)},
+			metrics.Sample{Name: "sensor_logger_writer_errors_total", Help: "Failed writes and flushes per file.", Type: metrics.Counter,
+				Labels: map[string]string{"file": file["file"], "op": "flush"}, Value: float64(st.Errors.Flush)},
+		)
+	}
+	for _, s := range rc.sinks {
+		out = append(out, metrics.Sample{Name: "sensor_logger_sink_errors_total", Help: "Failed writes and flushes per sink.", Type: metrics.Counter,
+			Labels: map[string]string{"sink": s.Name()}, Value: float64(s.errors.Load())})
+	}
+	out = append(out,
+		metrics.Sample{Name: "sensor_logger_saved_files_total", Help: "Frames and clouds saved.", Type: metrics.Counter, Value: float64(rc.savedFiles.Load())},
+		metrics.Sample{Name: "sensor_logger_saved_bytes_total", Help: "Bytes of frames and clouds saved.", Type: metrics.Counter, Value: float64(rc.savedBytes.Load())},
+		metrics.Sample{Name: "sensor_logger_save_errors_total", Help: "Frames and clouds that failed to save.", Type: metrics.Counter, Value: float64(rc.saveErrors.Load())},
+		metrics.Sample{Name: "sensor_logger_jpeg_fallbacks_total", Help: "Frames saved as captured because the frame encoders were behind.", Type: metrics.Counter, Value: float64(rc.jpegFallbacks.Load())},
+	)
+	return out
+}
+
+// Run writes fused records until in is closed, flushing every file
+// at the configured interval.
+func (rc *RecordingController) Run(in <-chan models.FusedRecord) {
+	ticker := rc.clock.NewTicker(time.Duration(rc.cfg.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec, ok := <-in:
+			if !ok {
+				return
+			}
+			if rc.radarGrid != nil {
+				rec.RadarGrid = rc.saveRadarGrid()
+			}
+			if rc.tally != nil && rc.cfg.Validation.Action == utils.ValidationDrop {
+				validate.Drop(&rec)
+			}
+			row := rc.seal(views.FusedCSV, rc.units[views.FusedCSV].Convert(rec.CSVRow(rc.layout)))
+			rc.writeFused(rec.Timestamp, rc.mark(append(row, rc.custom...), validate.Fused(rec)))
+			for _, d := range rec.IMUBatch {
+				rc.writeSensor(views.FusedIMUTable, rec.Timestamp, rc.mark(d.BatchCSVRow(rec.Timestamp), validate.IMU(d)))
+			}
+		case t := <-ticker.C:
+			rc.flush()
+			if rc.degrade != nil {
+				rc.degrade.Tick(t)
+			}
+		}
+	}
+}
+
+// saveRadarGrid saves the radar grid of the window just ended and returns
+// its path, or "" when the window had no detections.
+func (rc *RecordingController) saveRadarGrid() string {
+	data, err := rc.radarGrid.Take()
+	if err != nil {
+		rc.log.Errorf("recording: %v", err)
+		return ""
+	}
+	if data == nil {
+		return ""
+	}
+	rc.radarGrids++
+	path := rc.blobPath(radarGridsDir, uint64(rc.radarGrids), rc.cfg.RadarGrid.Format)
+	rc.saveFile(path, data)
+	return path
+}
+
+// LogStats logs the row and byte rate and the mean flush time of every
+// file, and the rate of the saved frames and clouds, over each interval
+// until ctx is cancelled.
+func (rc *RecordingController) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := rc.clock.NewTicker(interval)
+	defer ticker.Stop()
+	type sample struct{ rows, bytes int64 }
+	prev := map[string]sample{}
+	prevIO := map[string]views.WriterStats{}
+	last := rc.clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := rc.clock.Now()
+		secs := now.Sub(last).Seconds()
+		last = now
+		cur := map[string]sample{}
+		for _, w := range rc.writers() {
+			name := filepath.Base(w.Path())
+			st, pst := w.Stats(), prevIO[name]
+			c, p := sample{st.Rows, st.Bytes}, prev[name]
+			var flush time.Duration
+			if n := st.Flushes - pst.Flushes; n > 0 {
+				flush = (st.FlushTime - pst.FlushTime) / time.Duration(n)
+			}
+			msg := fmt.Sprintf("stats %s: %.1f rows/s, %s/s, flush %s", name,
+				float64(c.rows-p.rows)/secs, utils.FormatBytes(int64(float64(c.bytes-p.bytes)/secs)), flush.Round(time.Microsecond))
+			if st.Err != nil {
+				rc.log.Warnf("%s, failing: %v", msg, st.Err)
+			} else {
+				rc.log.Infof("%s", msg)
+			}
+			cur[name] = c
+			prevIO[name] = st
+		}
+		if c, p := (sample{rc.savedFiles.Load(), rc.savedBytes.Load()}), prev[framesDir]; c.rows > 0 {
+			rc.log.Infof("stats frames/clouds: %.1f files/s, %s/s",
+				float64(c.rows-p.rows)/secs, utils.FormatBytes(int64(float64(c.bytes-p.bytes)/secs)))
+			cur[framesDir] = c
+		}
+		prev = cur
+	}
+}
+
+// LogState logs, for a debug dump, the counters of every file and of the
+// saved frames and clouds, and the frames waiting for the encoders.
+func (rc *RecordingController) LogState() {
+	for _, w := range rc.writers() {
+		st := w.Stats()
+		msg := fmt.Sprintf("state %s: rows=%d bytes=%d pending=%d flushes=%d max_flush=%s write_errors=%d flush_errors=%d",
+			filepath.Base(w.Path()), st.Rows, st.Bytes, st.Pending, st.Flushes, st.MaxFlush.Round(time.Microsecond), st.Errors.Write, st.Errors.Flush)
+		if st.Err != nil {
+			rc.log.Warnf("%s, failing: %v", msg, st.Err)
+		} else {
+			rc.log.Infof("%s", msg)
+		}
+	}
+	rc.log.Infof("state frames/clouds: saved=%d bytes=%d errors=%d", rc.savedFiles.Load(), rc.savedBytes.Load(), rc.saveErrors.Load())
+	if rc.frames != nil {
+		rc.log.Infof("state frame encoders: queue %d/%d, fallbacks=%d", len(rc.frames), cap(rc.frames), rc.jpegFallbacks.Load())
+	}
+}
+
+// SampleSystem starts writing the resource use of the logger and the host
+// to system.csv every system_stats.interval_s until ctx is cancelled; Stop
+// waits for the last sample. It does nothing unless system_stats is
+// enabled.
+func (rc *RecordingController) SampleSystem(ctx context.Context) {
+	if rc.system == nil {
+		return
+	}
+	c := sysstat.NewCollector()
+	rc.wg.Add(1)
+	go func() {
+		defer rc.wg.Done()
+		ticker := rc.clock.NewTicker(time.Duration(rc.cfg.SystemStats.IntervalS) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			rc.write(rc.system, c.Sample(rc.Dir()).CSVRow())
+		}
+	}()
+}
+
+// MarkUnstopped records stages of the pipeline still running past the
+// stop deadline, for the manifest.
+func (rc *RecordingController) MarkUnstopped(stages ...string) {
+	rc.notesMu.Lock()
+	defer rc.notesMu.Unlock()
+	rc.unstopped = append(rc.unstopped, stages...)
+}
+
+// Stop waits for pending frame and cloud writes, closes every file and
+// writes the session manifest and the GPS tracks asked for. Events
+// published after Stop only go to the log.
+//
+// Writes still pending at deadline are given up on: the files are
+// flushed and closed without them, the rows referencing what they did not
+// save are cleared, and the manifest lists the recording as unstopped.
+func (rc *RecordingController) Stop(deadline time.Time) {
+	if !utils.WaitUntil(deadline, rc.wg.Wait) {
+		rc.log.Errorf("recording: frame and cloud writes still pending at the stop deadline, closing the session without them")
+		rc.MarkUnstopped("recording")
+	}
+	rc.eventSub.Close()
+	if !utils.WaitUntil(deadline, func() { <-rc.eventsDone }) {
+		rc.log.Errorf("recording: events still being written at the stop deadline, closing events.csv")
+		rc.MarkUnstopped("events")
+	}
+	rc.notesMu.Lock()
+	clean := len(rc.unstopped) == 0
+	rc.notesMu.Unlock()
+	// A stage left running may still hand over frames; the encoders are
+	// then left to exit with the process.
+	if rc.frames != nil && clean {
+		close(rc.frames)
+	}
+	for _, c := range []*quality.ClockSync{rc.lidarClock, rc.radarClock} {
+		if c == nil {
+			continue
+		}
+		if o, ok := c.Flush(); ok {
+			rc.write(rc.clockSync, o.CSVRow())
+		}
+	}
+	if n := rc.processSkipped.Load(); n > 0 {
+		rc.log.Warnf("recording: %d frames not saved, the encoders could not keep up with cropping and scaling", n)
+	}
+	if n := rc.jpegFallbacks.Load(); n > 0 {
+		rc.log.Warnf("recording: %d frames saved as JPEG, the %s encoders could not keep up", n, rc.cfg.FrameFormat)
+	}
+	rc.closeWriters()
+	if rc.dryRun == nil {
+		if err := rc.reconcile(rc.Dir()); err != nil {
+			rc.log.Errorf("recording: %v", err)
+		}
+	}
+	if len(rc.cfg.Tracks) > 0 && rc.csv.File("gps") != nil && rc.dryRun == nil {
+		if _, err := export.WriteTracks(rc.Dir(), rc.Dir(), rc.cfg.Tracks, export.Filter{Units: rc.cfg.Units}); err != nil {
+			rc.log.Errorf("recording: tracks: %v", err)
+		}
+	}
+	m := rc.manifest()
+	// Files a failed close or an earlier, crashed run of the session
+	// left partial.
+	partial, _ := filepath.Glob(filepath.Join(rc.Dir(), "*"+views.PartialSuffix))
+	for _, p := range partial {
+		m.Partial = append(m.Partial, filepath.Base(p))
+	}
+	m.Finalized = len(m.Partial) == 0
+	if !m.Finalized {
+		rc.log.Warnf("recording: not finalized: %s", strings.Join(m.Partial, ", "))
+	}
+	rc.notesMu.Lock()
+	m.Unstopped = slices.Clone(rc.unstopped)
+	rc.notesMu.Unlock()
+	m.ForcedShutdown = utils.Forced()
+	for _, line := range m.GapReport {
+		rc.log.Infof("recording: %s", line)
+	}
+	if r := m.FixReport(); r != "" {
+		rc.log.Infof("recording: gps fixes: %s", r)
+	}
+	if m.Odometer != nil {
+		rc.log.Infof("recording: odometer: %s", m.Odometer)
+	}
+	for _, line := range m.AnomalyReport() {
+		rc.log.Warnf("recording: invalid records: %s", line)
+	}
+	if rc.dryRun != nil {
+		rc.log.Infof("recording: dry run finished, nothing was written")
+		return
+	}
+	if err := views.WriteManifest(rc.Dir(), m); err != nil {
+		rc.log.Errorf("recording: %v", err)
+	}
+	if rc.cfg.Preview.Enabled {
+		rc.writePreview()
+	}
+	rc.log.Infof("recording: session closed at %s", rc.Dir())
+}
+
+// writePreview writes the preview of the session under its preview
+// directory, after the manifest so that it holds a copy.
+func (rc *RecordingController) writePreview() {
+	dir := filepath.Join(rc.Dir(), export.PreviewDir)
+	st, err := export.WritePreview(rc.Dir(), dir, rc.cfg.Preview, export.Filter{Units: rc.cfg.Units})
+	if err != nil {
+		rc.log.Errorf("recording: preview: %v", err)
+		return
+	}
+	rc.log.Infof("recording: preview in %s: %d fused rows, %d frames, track of %d of %d fixes", dir, st.Rows, st.Frames, st.Points, st.Fixes)
+	if st.Skipped > 0 {
+		rc.log.Warnf("recording: preview: %d frames left out, missing or not decodable", st.Skipped)
+	}
+}
+
+func (rc *RecordingController) manifest() *views.Manifest {
+	end := rc.clock.Now()
+	rc.dirMu.RLock()
+	failover := rc.failover
+	rc.dirMu.RUnlock()
+	m := &views.Manifest{
+		Session:     filepath.Base(rc.Dir()),
+		SessionID:   rc.sessionID,
+		Custom:      rc.cfg.CustomColumns,
+		Profile:     rc.profile,
+		Tags:        rc.cfg.Tags,
+		Notes:       rc.Notes(),
+		Failover:    failover,
+		Start:       rc.start,
+		End:         end,
+		DurationS:   end.Sub(rc.start).Seconds(),
+		Rows:        map[string]int64{},
+		WriteErrors: map[string]views.WriterErrors{},
+		SaveErrors:  rc.saveErrors.Load(),
+
+		JPEGFallbacks: rc.jpegFallbacks.Load(),
+		Gaps:          map[string]models.GapSummary{},
+
+		Interruptions: rc.interruptions,
+
+		LocationEncrypted: rc.location != nil,
+		Units:             rc.cfg.Units,
+	}
+	if rc.projector != nil {
+		m.Projection = &views.Projection{Kind: rc.projector.Kind()}
+		if o := rc.projector.Origin(); o != nil {
+			m.Projection.Origin = &views.ProjectionOrigin{Lat: o.Lat, Lon: o.Lon, Alt: o.Alt}
+			if p := rc.projector.Project(*o); p != nil {
+				m.Projection.Zone = p.Zone
+			}
+		}
+	}
+	if jumps := utils.ClockJumps(); len(jumps) > rc.clockBase {
+		m.ClockJumps = jumps[rc.clockBase:]
+	}
+	switch store := rc.frameStore.(type) {
+	case *views.TarFrameStore:
+		m.FrameStore = views.FramesTar
+	case *views.S3FrameStore:
+		m.FrameStore = store.URL()
+	}
+	if rc.processing != nil {
+		m.FrameProcessing = &views.FrameProcessing{
+			ROI:      rc.cfg.FrameProcessing.ROI,
+			MaxWidth: rc.cfg.FrameProcessing.MaxWidth,
+			Frames:   rc.processed.Load(),
+			FullRes:  rc.fullRes.Load(),
+			Skipped:  rc.processSkipped.Load(),
+		}
+	}
+	if rc.policy != nil {
+		s := rc.policy.Stats(end)
+		m.Adaptive = &s
+	}
+	if rc.degrade != nil {
+		m.Degrade = rc.degrade.Stats(end)
+	}
+	if rc.odometer != nil {
+		m.Odometer = rc.odometer.Summary()
+	}
+	if rc.throttle != nil {
+		if s := rc.throttle.Stats(); s.MaxTempC > 0 {
+			m.SimThrottle = &s
+		}
+	}
+	if rc.tally != nil {
+		m.Anomalies = rc.tally.Counts()
+	}
+	rc.fixMu.Lock()
+	if len(rc.fixes) > 0 {
+		m.Fixes = map[string]int64{}
+		for q, n := range rc.fixes {
+			m.Fixes[models.FixQualityName(q)] = n
+		}
+	}
+	rc.fixMu.Unlock()
+	for _, w := range rc.writers() {
+		st := w.Stats()
+		m.Rows[filepath.Base(w.Path())] = st.Rows
+		m.WriteErrors[filepath.Base(w.Path())] = st.Errors
+	}
+	for _, d := range []struct {
+		name string
+		d    *quality.GapDetector
+	}{
+		{"camera", rc.cameraGaps}, {"gps", rc.gpsGaps}, {"imu", rc.imuGaps},
+		{"lidar", rc.lidarGaps}, {"radar", rc.radarGaps}, {"env", rc.envGaps},
+		{"trigger", rc.triggerGaps}, {"ins", rc.insGaps},
+	} {
+		if d.d == nil {
+			continue
+		}
+		s := d.d.Summary()
+		m.Gaps[d.name] = s
+		m.GapReport = append(m.GapReport, d.name+": "+s.String())
+	}
+	return m
+}
+
+// outputFile is a session file with row, byte and error counters, either
+// a CSV file or a binary log.
+type outputFile interface {
+	Flush() error
+	Reopen(path string) error
+	Errors() views.WriterErrors
+	Close() error
+	Rows() int64
+	Bytes() int64
+	Stats() views.WriterStats
+	Path() string
+}
+
+// writers lists the open files: those of the csv sink and the files
+// written outside the sinks.
+func (rc *RecordingController) writers() []outputFile {
+	var ws []outputFile
+	for _, w := range rc.csv.Files() {
+		ws = append(ws, w)
+	}
+	return append(ws, rc.files()...)
+}
+
+// files lists the open files written outside the sinks, the blob journal
+// first so that flush writes it out before the rows referencing the files
+// it lists.
+func (rc *RecordingController) files() []outputFile {
+	var ws []outputFile
+	for _, w := range []*views.CSVWriter{rc.journal, rc.radarTransformed, rc.radarDetections, rc.gaps, rc.system, rc.events, rc.clockSync} {
+		if w != nil {
+			ws = append(ws, w)
+		}
+	}
+	for _, w := range []*views.RecordWriter{rc.imuLog, rc.radarLog} {
+		if w != nil {
+			ws = append(ws, w)
+		}
+	}
+	for _, w := range []*views.PcapWriter{rc.lidarPcap, rc.radarPcap} {
+		if w != nil {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+func (rc *RecordingController) flush() {
+	for _, w := range rc.files() {
+		if err := w.Flush(); err != nil {
+			rc.writeFailed(w, err)
+		}
+	}
+	for _, s := range rc.sinks {
+		if err := s.Flush(); err != nil {
+			rc.sinkFailed(s, err)
+		}
+	}
+}
+
+func (rc *RecordingController) closeWriters() {
+	if rc.frameStore != nil {
+		if err := rc.frameStore.Close(); err != nil && rc.saveErrors.Load() == 0 {
+			rc.log.Errorf("recording: close frame store: %v", err)
+		}
+	}
+	for _, w := range rc.files() {
+		failed := w.Errors() != views.WriterErrors{}
+		if err := w.Close(); err != nil && !failed {
+			rc.log.Errorf("recording: close %s: %v", w.Path(), err)
+		}
+	}
+	for _, s := range rc.sinks {
+		if err := s.Close(); err != nil && s.errors.Load() == 0 {
+			rc.log.Errorf("recording: close %s sink: %v", s.Name(), err)
+		}
+	}
+}
+
+// degradeSteps returns the configured degradation steps that affect what
+// the session saves.
+func degradeSteps(cfg utils.StorageConfig, sensors *utils.SensorsConfig) []string {
+	frames := cfg.SaveFrames && sensors.Camera.Enabled
+	var steps []string
+	for _, step := range cfg.Degrade.Steps {
+		switch step {
+		case utils.DegradeJPEGQuality:
+			if !frames || cfg.FrameFormat != framecodec.JPEG {
+				continue
+			}
+		case utils.DegradeAlternateFrames:
+			if !frames {
+				continue
+			}
+		case utils.DegradeNoClouds:
+			if !sensors.Lidar.Enabled || !cfg.SaveClouds && !cfg.Transform.Lidar {
+				continue
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// reconcile clears the CSV references to frames, clouds and grids of the
+// session in dir that were not completely written.
+func (rc *RecordingController) reconcile(dir string) error {
+	cleared, err := views.ReconcileBlobs(dir)
+	if len(cleared) > 0 {
+		rc.log.Warnf("recording: %d rows referenced files that were not completely written; cleared them", len(cleared))
+	}
+	if err != nil {
+		return fmt.Errorf("reconcile %s: %w", dir, err)
+	}
+	return nil
+}
+
+// blobCounts counts the frames, clouds and grids a dry run would have
+// saved, by directory.
+type blobCounts struct {
+	mu    sync.Mutex
+	files map[string]int64
+	bytes map[string]int64
+}
+
+func (b *blobCounts) add(rel string, n int) {
+	dir, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	b.mu.Lock()
+	b.files[dir]++
+	b.bytes[dir] += int64(n)
+	b.mu.Unlock()
+}
+
+// DryRunReport prints the rows and bytes of every file and directory a dry
+// run would have written, the storage they need per minute and the mean
+// time a flush of each file took to encode. It prints nothing for a real
+// session.
+func (rc *RecordingController) DryRunReport(out io.Writer) {
+	if rc.dryRun == nil {
+		return
+	}
+	elapsed := rc.clock.Now().Sub(rc.start)
+	mins := elapsed.Minutes()
+	perMin := func(n int64) string {
+		if mins <= 0 {
+			return "-"
+		}
+		return utils.FormatBytes(int64(float64(n)/mins)) + "/min"
+	}
+	fmt.Fprintf(out, "dry run of %s\n", elapsed.Round(time.Second))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	var total int64
+	for _, f := range rc.writers() {
+		st := f.Stats()
+		total += st.Bytes
+		flush := "-"
+		if st.Flushes > 0 {
+			flush = (st.FlushTime / time.Duration(st.Flushes)).Round(time.Microsecond).String() + "/flush"
+		}
+		fmt.Fprintf(w, "  %s\t%d rows\t%s\t%s\t%s\t\n", filepath.Base(f.Path()), st.Rows, utils.FormatBytes(st.Bytes), perMin(st.Bytes), flush)
+	}
+	rc.dryRun.mu.Lock()
+	for _, dir := range rc.subdirs {
+		n := rc.dryRun.bytes[dir]
+		total += n
+		fmt.Fprintf(w, "  %s/\t%d files\t%s\t%s\t\t\n", dir, rc.dryRun.files[dir], utils.FormatBytes(n), perMin(n))
+	}
+	rc.dryRun.mu.Unlock()
+	fmt.Fprintf(w, "  total\t\t%s\t%s\t\t\n", utils.FormatBytes(total), perMin(total))
+	w.Flush()
+}
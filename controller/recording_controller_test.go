@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+func TestFrameEXIFPosition(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	fix := &models.GPSData{Timestamp: ts.Add(-time.Second), Lat: 29.865, Lon: 77.897, Alt: 268, FixQuality: 1}
+	tests := []struct {
+		name    string
+		encrypt bool
+		fix     *models.GPSData
+		gps     bool
+	}{
+		{"fix", false, fix, true},
+		{"no_fix", false, nil, false},
+		{"encrypted", true, fix, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := utils.StorageConfig{FrameEXIF: true}
+			cfg.LocationEncryption.Enabled = tt.encrypt
+			rc := &RecordingController{cfg: cfg, lastFix: tt.fix}
+			e := rc.exif(ts)
+			if e == nil || !e.Time.Equal(ts) {
+				t.Fatalf("EXIF %+v, want the time %v", e, ts)
+			}
+			if (e.GPS != nil) != tt.gps {
+				t.Errorf("EXIF position %+v", e.GPS)
+			}
+		})
+	}
+}
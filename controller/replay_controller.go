@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/export"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// ReplayController plays a recorded session into a SampleRecorder in
+// timestamp order, paced like the recording was. Frames and clouds are
+// read from disk as they are played.
+type ReplayController struct {
+	dir      string
+	rate     float64
+	recorder SampleRecorder
+	log      utils.Logger
+	// frames are the saved frames of the session, nil without any.
+	frames views.FrameReader
+
+	events []replayEvent
+}
+
+type replayEvent struct {
+	ts   time.Time
+	play func()
+}
+
+// NewReplayController reads the samples of the session in dir that f keeps.
+// rate scales the pace: 2 plays twice as fast as recorded.
+func NewReplayController(dir string, f export.Filter, rate float64, recorder SampleRecorder, log utils.Logger) (*ReplayController, error) {
+	r := &ReplayController{dir: dir, rate: rate, recorder: recorder, log: log}
+	frames, err := export.ReadCamera(dir, f)
+	if err != nil {
+		return nil, err
+	}
+	if r.frames, err = export.OpenFrames(dir, frames); err != nil {
+		return nil, err
+	}
+	for _, v := range frames {
+		r.add(v.Timestamp, func() {
+			if v.Path != "" {
+				if err := export.LoadFrame(r.frames, &v); err != nil {
+					log.Debugf("replay: frame %d: %v", v.FrameID, err)
+				}
+			}
+			recorder.RecordCamera(v)
+		})
+	}
+	if f.Sensor("gps") {
+		fixes, err := export.ReadGPS(dir, f)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, v := range fixes {
+			r.add(v.Timestamp, func() { recorder.RecordGPS(v) })
+		}
+	}
+	imu, err := export.ReadIMU(dir, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range imu {
+		r.add(v.Timestamp, func() { recorder.RecordIMU(v) })
+	}
+	packets, err := export.ReadLidar(dir, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range packets {
+		r.add(v.Timestamp, func() {
+			if v.Path != "" {
+				if err := export.LoadCloud(dir, &v); err != nil {
+					log.Debugf("replay: cloud %d: %v", v.Seq, err)
+				}
+			}
+			recorder.RecordLidar(v)
+		})
+	}
+	scans, err := export.ReadRadar(dir, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range scans {
+		r.add(v.Timestamp, func() { recorder.RecordRadar(v) })
+	}
+	env, err := export.ReadEnv(dir, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range env {
+		r.add(v.Timestamp, func() { recorder.RecordEnv(v) })
+	}
+	sort.SliceStable(r.events, func(i, j int) bool { return r.events[i].ts.Before(r.events[j].ts) })
+	return r, nil
+}
+
+func (r *ReplayController) add(ts time.Time, play func()) {
+	r.events = append(r.events, replayEvent{ts, play})
+}
+
+// Samples returns the number of samples to play.
+func (r *ReplayController) Samples() int { return len(r.events) }
+
+// Span returns the recorded time the samples cover.
+func (r *ReplayController) Span() time.Duration {
+	if len(r.events) == 0 {
+		return 0
+	}
+	return r.events[len(r.events)-1].ts.Sub(r.events[0].ts)
+}
+
+// Run plays every sample and returns when done or when ctx is cancelled.
+func (r *ReplayController) Run(ctx context.Context) {
+	if r.frames != nil {
+		defer r.frames.Close()
+	}
+	if len(r.events) == 0 {
+		return
+	}
+	start, first := time.Now(), r.events[0].ts
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for _, e := range r.events {
+		if wait := time.Until(start.Add(time.Duration(float64(e.ts.Sub(first)) / r.rate))); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		e.play()
+	}
+}
@@ -0,0 +1,598 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/ingest"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/metrics"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/preflight"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/sched"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/thermal"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// SensorsController owns the readers of all enabled sensors. Readers of
+// disabled sensors are nil.
+type SensorsController struct {
+	Camera *ingest.CameraReader
+	GPS    *ingest.GPSReader
+	IMU    *ingest.IMUReader
+	Lidar  *ingest.LidarReader
+	Radar  *ingest.RadarReader
+	Env    *ingest.EnvReader
+
+	// Trigger reads the hardware trigger; nil unless enabled. The frames
+	// and sweeps of the local camera and lidar are matched to the pulses
+	// in cameraPulses and lidarPulses by the fusion stage.
+	Trigger      *ingest.TriggerReader
+	cameraPulses *ingest.Pulses
+	lidarPulses  *ingest.Pulses
+
+	// INS reads the combined INS; nil unless enabled. It feeds the imu and
+	// gps readers of device "ins".
+	INS *ingest.INSReader
+
+	// Throttle slows the simulated sensors down while the host runs hot;
+	// nil unless enabled.
+	Throttle *thermal.Throttle
+
+	// Remote is the listener for remote agents; nil unless some sensor
+	// uses device "remote".
+	Remote *ingest.RemoteSource
+
+	// Mux carries the samples of every reader to the fusion stage, or to
+	// the link of an agent.
+	Mux *ingest.Mux
+
+	log     utils.Logger
+	bus     *events.Bus
+	clock   utils.Clock
+	restart utils.RestartConfig
+	buffers utils.AdaptiveBuffersConfig
+	sched   map[string]utils.SchedulingConfig
+	limits  map[string]utils.RateLimitConfig
+	readers []ingest.Reader
+	runs    []*readerRun
+	wg      sync.WaitGroup
+
+	// check looks for the device of the named reader every retry while it
+	// is missing; nil unless missing_devices.wait is set.
+	check func(name string) error
+	retry time.Duration
+}
+
+// readerRun is the supervision state of one reader.
+type readerRun struct {
+	restarts atomic.Uint64
+	// stopped is set once the reader returned and was closed.
+	stopped atomic.Bool
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // stops the current run, nil between runs
+
+	// kick asks for a restart, see SensorsController.Restart.
+	kick chan struct{}
+}
+
+// dropBurstEnd is how long a reader must drop nothing for a burst of drops
+// to be over, see watchDrops.
+const dropBurstEnd = time.Second
+
+// NewSensorsController creates the readers of the enabled sensors, which
+// stamp their samples with clock. Reader failures, restarts and bursts of
+// dropped samples are published to bus.
+func NewSensorsController(cfg *utils.SensorsConfig, bus *events.Bus, log utils.Logger, clock utils.Clock) *SensorsController {
+	c := &SensorsController{log: log, bus: bus, clock: clock, restart: cfg.Restart, buffers: cfg.AdaptiveBuffers, sched: cfg.Scheduling, limits: cfg.RateLimits}
+	if cfg.SimThrottle.Enabled {
+		c.Throttle = thermal.NewThrottle(cfg.SimThrottle, log)
+	}
+	if cfg.MissingDevices.Wait {
+		c.check = func(name string) error { return preflight.CheckDevice(cfg, name) }
+		c.retry = time.Duration(cfg.MissingDevices.RetryS * float64(time.Second))
+	}
+	// throttle hands the throttle to the reader of a simulated device.
+	throttle := func(device string, r interface{ UseThrottle(*thermal.Throttle) }) {
+		if c.Throttle != nil && device == utils.SimDevice {
+			r.UseThrottle(c.Throttle)
+		}
+	}
+	if cfg.UsesRemote() {
+		c.Remote = ingest.NewRemoteSource(cfg.Remote, log)
+		c.readers = append(c.readers, c.Remote)
+	}
+	if cfg.Trigger.Enabled {
+		c.Trigger = ingest.NewTriggerReader(cfg.Trigger, log, clock)
+		c.readers = append(c.readers, c.Trigger)
+	}
+	if cfg.INS.Enabled {
+		c.INS = ingest.NewINSReader(cfg.INS, log, clock)
+		throttle(cfg.INS.Device, c.INS)
+		c.readers = append(c.readers, c.INS)
+	}
+	if cfg.Camera.Enabled {
+		c.Camera = ingest.NewCameraReader(cfg.Camera, log, clock)
+		if cfg.Camera.Device == utils.RemoteDevice {
+			c.Camera.UseRemote(c.Remote.Camera())
+		} else if c.Trigger != nil {
+			c.cameraPulses = c.Trigger.Pulses()
+		}
+		throttle(cfg.Camera.Device, c.Camera)
+		c.readers = append(c.readers, c.Camera)
+	}
+	if cfg.GPS.Enabled {
+		c.GPS = ingest.NewGPSReader(cfg.GPS, log, clock)
+		if cfg.GPS.Device == utils.RemoteDevice {
+			c.GPS.UseRemote(c.Remote.GPS())
+		} else if cfg.GPS.Device == utils.INSDevice {
+			c.GPS.UseINS(c.INS.GPS())
+		}
+		throttle(cfg.GPS.Device, c.GPS)
+		c.readers = append(c.readers, c.GPS)
+	}
+	if cfg.IMU.Enabled {
+		c.IMU = ingest.NewIMUReader(cfg.IMU, log, clock)
+		if cfg.IMU.Device == utils.RemoteDevice {
+			c.IMU.UseRemote(c.Remote.IMU())
+		} else if cfg.IMU.Device == utils.INSDevice {
+			c.IMU.UseINS(c.INS.IMU())
+		}
+		throttle(cfg.IMU.Device, c.IMU)
+		c.readers = append(c.readers, c.IMU)
+	}
+	if cfg.Lidar.Enabled {
+		c.Lidar = ingest.NewLidarReader(cfg.Lidar, log, clock)
+		if cfg.Lidar.Address == utils.RemoteDevice {
+			c.Lidar.UseRemote(c.Remote.Lidar())
+		} else if c.Trigger != nil && !strings.HasPrefix(cfg.Lidar.Address, utils.PcapPrefix) {
+			c.lidarPulses = c.Trigger.Pulses()
+		}
+		throttle(cfg.Lidar.Address, c.Lidar)
+		c.readers = append(c.readers, c.Lidar)
+	}
+	if cfg.Radar.Enabled {
+		c.Radar = ingest.NewRadarReader(cfg.Radar, log, clock)
+		if cfg.Radar.Address == utils.RemoteDevice {
+			c.Radar.UseRemote(c.Remote.Radar())
+		}
+		throttle(cfg.Radar.Address, c.Radar)
+		c.readers = append(c.readers, c.Radar)
+	}
+	if cfg.Env.Enabled {
+		c.Env = ingest.NewEnvReader(cfg.Env, log, clock)
+		if cfg.Env.Device == utils.RemoteDevice {
+			c.Env.UseRemote(c.Remote.Env())
+		}
+		throttle(cfg.Env.Device, c.Env)
+		c.readers = append(c.readers, c.Env)
+	}
+	var sensors []ingest.Reader
+	for _, r := range c.readers {
+		c.runs = append(c.runs, &readerRun{kick: make(chan struct{}, 1)})
+		if _, ok := r.(*ingest.RemoteSource); !ok {
+			sensors = append(sensors, r)
+			if cfg.AdaptiveBuffers.Enabled {
+				r.(interface{ UseBufferGrowth(int) }).UseBufferGrowth(cfg.AdaptiveBuffers.MaxFactor)
+			}
+		}
+		if l, ok := cfg.RateLimits[r.Name()]; ok {
+			r.(interface{ UseRateLimit(utils.RateLimitConfig) }).UseRateLimit(l)
+		}
+	}
+	c.Mux = ingest.NewMux(sensors...)
+	return c
+}
+
+// Start launches every reader in its own goroutine. A reader whose device
+// fails is run again as configured by utils.RestartConfig, or when
+// Restart is called; with utils.MissingDevicesConfig, one whose device is
+// missing is started once it is found. The Mux stays open until ctx is cancelled, so the
+// fusion and recording stages are never torn down by a failed device.
+// A reader with scheduling settings keeps its goroutine on one tuned OS
+// thread for all its runs.
+func (c *SensorsController) Start(ctx context.Context) {
+	for i, r := range c.readers {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			defer c.runs[i].stopped.Store(true)
+			defer r.Close()
+			if s, ok := c.sched[r.Name()]; ok {
+				if err := sched.Apply(s); err != nil {
+					c.log.Warnf("%s: scheduling: %v; skipped", r.Name(), err)
+				}
+			}
+			c.supervise(ctx, r, c.runs[i])
+		}()
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.watchDrops(ctx)
+	}()
+	if c.buffers.Enabled {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.growBuffers(ctx)
+		}()
+	}
+	if c.Throttle != nil {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.Throttle.Run(ctx)
+		}()
+	}
+}
+
+func (c *SensorsController) supervise(ctx context.Context, r ingest.Reader, run *readerRun) {
+	first := time.Duration(c.restart.DelayMs) * time.Millisecond
+	limit := time.Duration(c.restart.MaxDelayMs) * time.Millisecond
+	delay := first
+	if !c.awaitDevice(ctx, r, run, nil) {
+		c.log.Infof("%s: stopped", r.Name())
+		return
+	}
+	for {
+		c.log.Infof("%s: started", r.Name())
+		start := c.clock.Now()
+		err := run.run(ctx, r)
+		if ctx.Err() != nil {
+			if err != nil {
+				c.bus.Publishf(events.ReaderFailed, events.Error, r.Name(), "%v", err)
+			}
+			c.log.Infof("%s: stopped", r.Name())
+			return
+		}
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+		if c.check != nil {
+			if gone := c.check(r.Name()); gone != nil {
+				c.bus.Publishf(events.ReaderFailed, events.Error, r.Name(), "%v; waiting for its device", err)
+				if !c.awaitDevice(ctx, r, run, gone) {
+					c.log.Infof("%s: stopped", r.Name())
+					return
+				}
+				delay = first
+				run.restarts.Add(1)
+				continue
+			}
+		}
+
+		// Wait for the backoff delay or a Restart, whichever comes first.
+		var wait <-chan time.Time
+		select {
+		case <-run.kick:
+		default:
+			if c.restart.Enabled {
+				// A run that outlasted the longest delay starts the backoff over.
+				if c.clock.Now().Sub(start) > limit {
+					delay = first
+				}
+				c.bus.Publishf(events.ReaderFailed, events.Error, r.Name(), "%v; restarting in %v", err, delay)
+				wait = c.clock.After(delay)
+			} else {
+				c.bus.Publishf(events.ReaderFailed, events.Error, r.Name(), "%v; down until restarted", err)
+			}
+			select {
+			case <-ctx.Done():
+				c.log.Infof("%s: stopped", r.Name())
+				return
+			case <-wait:
+				delay = min(2*delay, limit)
+				run.restarts.Add(1)
+				continue
+			case <-run.kick:
+			}
+		}
+		c.bus.Publishf(events.ReaderRestarted, events.Info, r.Name(), "restarting on request")
+		delay = first
+		run.restarts.Add(1)
+	}
+}
+
+// awaitDevice waits while the device of r is missing, when missing devices
+// are waited for: gone is what the check that found it missing returned,
+// nil to check first. DeviceDetached and DeviceAttached events mark the
+// wait, which a Restart ends early. It returns false if ctx was cancelled
+// first.
+func (c *SensorsController) awaitDevice(ctx context.Context, r ingest.Reader, run *readerRun, gone error) bool {
+	if c.check == nil {
+		return true
+	}
+	if gone == nil {
+		if gone = c.check(r.Name()); gone == nil {
+			return true
+		}
+	}
+	// The check names the sensor, as does the event.
+	msg := strings.TrimPrefix(gone.Error(), r.Name()+": ")
+	c.bus.Publishf(events.DeviceDetached, events.Warn, r.Name(), "%s; looking for it every %v", msg, c.retry)
+	since := c.clock.Now()
+	ticker := c.clock.NewTicker(c.retry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-run.kick:
+			c.bus.Publishf(events.ReaderRestarted, events.Info, r.Name(), "restarting on request")
+			return true
+		case <-ticker.C:
+		}
+		if c.check(r.Name()) == nil {
+			c.bus.Publishf(events.DeviceAttached, events.Info, r.Name(), "device found after %v", c.clock.Now().Sub(since).Round(time.Second))
+			return true
+		}
+	}
+}
+
+// watchDrops publishes a SamplesDropped event for every burst of samples
+// a reader dropped, once the burst is over or ctx is cancelled. A reader
+// over its rate limit is reported as soon as it starts discarding
+// samples, as that is a device misbehaving rather than a pipeline falling
+// behind, and again once it is back under the limit.
+func (c *SensorsController) watchDrops(ctx context.Context) {
+	ticker := c.clock.NewTicker(dropBurstEnd / 4)
+	defer ticker.Stop()
+	drops := make([]burst, len(c.readers))
+	limits := make([]burst, len(c.readers))
+	reportDrops := func(r ingest.Reader, b *burst) {
+		c.bus.Publishf(events.SamplesDropped, events.Warn, r.Name(), "dropped %d samples over %v, the pipeline fell behind",
+			b.n, b.span())
+	}
+	reportLimit := func(r ingest.Reader, b *burst) {
+		c.bus.Publishf(events.RateLimited, events.Info, r.Name(), "back under its rate limit after discarding %d samples over %v",
+			b.n, b.span())
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for i, r := range c.readers {
+				if drops[i].open {
+					reportDrops(r, &drops[i])
+				}
+				if limits[i].open {
+					reportLimit(r, &limits[i])
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+		now := c.clock.Now()
+		for i, r := range c.readers {
+			s := r.Stats()
+			if drops[i].observe(now, s.Dropped) == burstEnded {
+				reportDrops(r, &drops[i])
+			}
+			switch limits[i].observe(now, s.Limited) {
+			case burstStarted:
+				c.bus.Publishf(events.RateLimited, events.Warn, r.Name(), "over its rate limit of %g Hz, discarding samples",
+					c.limits[r.Name()].MaxHz)
+			case burstEnded:
+				reportLimit(r, &limits[i])
+			}
+		}
+	}
+}
+
+// growOccupancy is the part of its buffer a reader must keep filled, for
+// the hold of utils.AdaptiveBuffersConfig, for the buffer to grow.
+const growOccupancy = 0.8
+
+// growBuffers doubles the buffer of every reader that keeps more than
+// growOccupancy of it filled for the hold, up to its most, and publishes
+// a BufferGrown event for each step. Occupancy is sampled as often as
+// watchDrops samples the drops.
+func (c *SensorsController) growBuffers(ctx context.Context) {
+	hold := time.Duration(c.buffers.HoldS * float64(time.Second))
+	ticker := c.clock.NewTicker(dropBurstEnd / 4)
+	defer ticker.Stop()
+	// full is when each reader was first seen over growOccupancy this
+	// time, zero while it is not.
+	full := make([]time.Time, len(c.readers))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := c.clock.Now()
+		for i, r := range c.readers {
+			g, ok := r.(interface{ GrowBuffer() (int, bool) })
+			s := r.Stats()
+			if !ok || float64(s.Queued) <= growOccupancy*float64(s.Capacity) {
+				full[i] = time.Time{}
+				continue
+			}
+			if full[i].IsZero() {
+				full[i] = now
+			}
+			if now.Sub(full[i]) < hold {
+				continue
+			}
+			full[i] = time.Time{}
+			if n, ok := g.GrowBuffer(); ok {
+				c.bus.Publishf(events.BufferGrown, events.Info, r.Name(), "buffer grown from %d to %d samples, over %.0f%% full for %v",
+					s.Capacity, n, 100*growOccupancy, hold)
+			}
+		}
+	}
+}
+
+// burst follows a cumulative counter of a reader, such as its dropped
+// samples, through its bursts: a burst ends once the counter has stood
+// still for dropBurstEnd.
+type burst struct {
+	prev        uint64
+	open        bool
+	start, last time.Time
+	n           uint64
+}
+
+const (
+	burstNone = iota
+	burstStarted
+	burstEnded
+)
+
+// observe takes the counter at now and reports whether a burst started
+// or ended. An ended burst keeps its count and span until the next one
+// starts.
+func (b *burst) observe(now time.Time, total uint64) int {
+	n := total - b.prev
+	b.prev = total
+	switch {
+	case n > 0 && !b.open:
+		b.open, b.start, b.last, b.n = true, now, now, n
+		return burstStarted
+	case n > 0:
+		b.last, b.n = now, b.n+n
+	case b.open && now.Sub(b.last) >= dropBurstEnd:
+		b.open = false
+		return burstEnded
+	}
+	return burstNone
+}
+
+func (b *burst) span() time.Duration { return b.last.Sub(b.start).Round(time.Millisecond) }
+
+// run runs r once, cancellable by Restart.
+func (run *readerRun) run(ctx context.Context, r ingest.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	run.mu.Lock()
+	run.cancel = cancel
+	run.mu.Unlock()
+	defer func() {
+		run.mu.Lock()
+		run.cancel = nil
+		run.mu.Unlock()
+	}()
+	return r.Run(ctx)
+}
+
+// Restart stops the current run of the named reader and runs it again at
+// once, e.g. after its device was reconnected. A reader waiting to be
+// restarted after a failure is restarted without further delay.
+func (c *SensorsController) Restart(name string) error {
+	for i, r := range c.readers {
+		if r.Name() != name {
+			continue
+		}
+		run := c.runs[i]
+		select {
+		case run.kick <- struct{}{}:
+		default:
+		}
+		run.mu.Lock()
+		if run.cancel != nil {
+			run.cancel()
+		}
+		run.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("no reader %q", name)
+}
+
+// ServeRestart restarts the reader named in the request path, see Restart.
+func (c *SensorsController) ServeRestart(w http.ResponseWriter, r *http.Request) {
+	if err := c.Restart(r.PathValue("name")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Wait blocks until every reader has returned.
+func (c *SensorsController) Wait() {
+	c.wg.Wait()
+}
+
+// Stop waits until deadline for the readers to return once the context of
+// Start is cancelled, and returns the names of those still running then,
+// stuck in a read or in closing their device. They are left behind.
+func (c *SensorsController) Stop(deadline time.Time) []string {
+	if utils.WaitUntil(deadline, c.wg.Wait) {
+		return nil
+	}
+	var running []string
+	for i, r := range c.readers {
+		if !c.runs[i].stopped.Load() {
+			running = append(running, r.Name())
+		}
+	}
+	if len(running) == 0 {
+		// A reader returned; the drop watcher or the throttle did not.
+		running = append(running, "sensors")
+	}
+	return running
+}
+
+// Metrics reports the counters and queue occupancy of every reader.
+func (c *SensorsController) Metrics() []metrics.Sample {
+	var out []metrics.Sample
+	for i, r := range c.readers {
+		s := r.Stats()
+		l := map[string]string{"sensor": r.Name()}
+		out = append(out,
+			metrics.Sample{Name: "sensor_logger_samples_produced_total", Help: "Samples handed to the pipeline.", Type: metrics.Counter, Labels: l, Value: float64(s.Produced)},
+			metrics.Sample{Name: "sensor_logger_samples_dropped_total", Help: "Samples dropped because the pipeline fell behind.", Type: metrics.Counter, Labels: l, Value: float64(s.Dropped)},
+			metrics.Sample{Name: "sensor_logger_samples_limited_total", Help: "Samples discarded for going over the reader's rate limit.", Type: metrics.Counter, Labels: l, Value: float64(s.Limited)},
+			metrics.Sample{Name: "sensor_logger_queue_length", Help: "Samples of the reader waiting in the pipeline's sample channel.", Type: metrics.Gauge, Labels: l, Value: float64(s.Queued)},
+			metrics.Sample{Name: "sensor_logger_queue_capacity", Help: "Samples the reader may have waiting in the pipeline's sample channel.", Type: metrics.Gauge, Labels: l, Value: float64(s.Capacity)},
+			metrics.Sample{Name: "sensor_logger_reader_restarts_total", Help: "Times the reader was run again after a failure or on request.", Type: metrics.Counter, Labels: l, Value: float64(c.runs[i].restarts.Load())},
+		)
+	}
+	return out
+}
+
+// LogState logs, for a debug dump, the queue of every reader in the
+// sample channel, its lifetime counters and whether it has stopped.
+func (c *SensorsController) LogState() {
+	for i, r := range c.readers {
+		s := r.Stats()
+		c.log.Infof("state %s: queue %d/%d, produced=%d dropped=%d limited=%d restarts=%d stopped=%t",
+			r.Name(), s.Queued, s.Capacity, s.Produced, s.Dropped, s.Limited, c.runs[i].restarts.Load(), c.runs[i].stopped.Load())
+	}
+}
+
+// LogStats logs, every interval until ctx is cancelled, each reader's
+// sample and drop rates over that interval, how much of its share of the
+// sample channel it fills and its lifetime counters.
+func (c *SensorsController) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+	prev := make([]ingest.Stats, len(c.readers))
+	last := c.clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := c.clock.Now()
+		secs := now.Sub(last).Seconds()
+		last = now
+		for i, r := range c.readers {
+			s := r.Stats()
+			limited := ""
+			if _, ok := c.limits[r.Name()]; ok {
+				limited = fmt.Sprintf(", limited %.1f/s", float64(s.Limited-prev[i].Limited)/secs)
+			}
+			c.log.Infof("stats %s: %.1f Hz, dropped %.1f/s%s, queue %d/%d (%.0f%%), total produced=%d dropped=%d restarts=%d",
+				r.Name(), float64(s.Produced-prev[i].Produced)/secs, float64(s.Dropped-prev[i].Dropped)/secs, limited,
+				s.Queued, s.Capacity, percent(s.Queued, s.Capacity), s.Produced, s.Dropped, c.runs[i].restarts.Load())
+			prev[i] = s
+		}
+	}
+}
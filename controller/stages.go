@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/depthmap"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/overlay"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/privacy"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Processor changes or drops the raw samples on their way from fusion to
+// the stages listed after it in storage.yaml. Process is called from
+// several goroutines at once and returns the sample to pass on, if any.
+type Processor interface {
+	Process(models.SensorSample) (models.SensorSample, bool)
+}
+
+// ProcessorFactory creates the processor p declares, for a pipeline
+// recording sensors.
+type ProcessorFactory func(p utils.ProcessorConfig, sensors *utils.SensorsConfig, log utils.Logger) (Processor, error)
+
+var processorTypes = map[string]ProcessorFactory{
+	utils.ProcessorPrivacyZone: func(p utils.ProcessorConfig, sensors *utils.SensorsConfig, log utils.Logger) (Processor, error) {
+		if !sensors.GPS.Enabled {
+			return nil, fmt.Errorf("privacy_zone needs gps")
+		}
+		return privacy.NewZoneFilter(p.Name, p.PrivacyZone, log), nil
+	},
+	utils.ProcessorRadarOverlay: func(p utils.ProcessorConfig, sensors *utils.SensorsConfig, log utils.Logger) (Processor, error) {
+		if !sensors.Camera.Enabled || !sensors.Radar.Enabled {
+			return nil, fmt.Errorf("radar_overlay needs camera and radar")
+		}
+		if err := sensors.Calibration.Camera.Validate(); err != nil {
+			return nil, fmt.Errorf("radar_overlay needs the camera intrinsics: %w", err)
+		}
+		return overlay.NewRadarOverlay(p.Name, p.RadarOverlay, sensors.Calibration, log), nil
+	},
+	utils.ProcessorLidarDepth: func(p utils.ProcessorConfig, sensors *utils.SensorsConfig, log utils.Logger) (Processor, error) {
+		if !sensors.Camera.Enabled || !sensors.Lidar.Enabled {
+			return nil, fmt.Errorf("lidar_depth needs camera and lidar")
+		}
+		if err := sensors.Calibration.Camera.Validate(); err != nil {
+			return nil, fmt.Errorf("lidar_depth needs the camera intrinsics: %w", err)
+		}
+		return depthmap.New(p.Name, p.LidarDepth, sensors.Calibration, log), nil
+	},
+}
+
+// RegisterProcessor makes the processors of type typ available to the
+// processors section of storage.yaml. It is meant to be called from init.
+func RegisterProcessor(typ string, f ProcessorFactory) {
+	processorTypes[typ] = f
+}
+
+// Stages is the chain of the stages of storage.yaml: fusion hands its
+// samples to it as to any SampleRecorder.
+type Stages struct {
+	SampleRecorder
+	processors []*processStage
+}
+
+// ComposeStages chains the stages of cfg in their order. sinks has the
+// SampleRecorder of each built-in stage that is enabled.
+func ComposeStages(cfg *utils.StorageConfig, sensors *utils.SensorsConfig, sinks map[string]SampleRecorder, log utils.Logger) (*Stages, error) {
+	declared := map[string]utils.ProcessorConfig{}
+	for _, p := range cfg.Processors {
+		declared[p.Name] = p
+	}
+	s := &Stages{}
+	// after holds the stages after the one at hand, in order.
+	var after []SampleRecorder
+	for i := len(cfg.Stages) - 1; i >= 0; i-- {
+		name := cfg.Stages[i]
+		pc, ok := declared[name]
+		if !ok {
+			if r := sinks[name]; r != nil {
+				after = append([]SampleRecorder{r}, after...)
+			}
+			continue
+		}
+		factory, ok := processorTypes[pc.Type]
+		if !ok {
+			return nil, fmt.Errorf("processor %s: unknown type %q", name, pc.Type)
+		}
+		p, err := factory(pc, sensors, log)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %w", name, err)
+		}
+		ps := &processStage{name: name, p: p, next: Tee(after...)}
+		s.processors = append([]*processStage{ps}, s.processors...)
+		after = []SampleRecorder{ps}
+	}
+	s.SampleRecorder = Tee(after...)
+	return s, nil
+}
+
+// Processor returns the processor named name, nil if there is none.
+func (s *Stages) Processor(name string) Processor {
+	for _, ps := range s.processors {
+		if ps.name == name {
+			return ps.p
+		}
+	}
+	return nil
+}
+
+// UseSession tells the processors that keep files of their own that the
+// session is recorded in dir.
+func (s *Stages) UseSession(dir string) {
+	for _, ps := range s.processors {
+		if u, ok := ps.p.(interface{ UseSession(dir string) }); ok {
+			u.UseSession(dir)
+		}
+	}
+}
+
+// LogSummary logs the samples each processor dropped.
+func (s *Stages) LogSummary(log utils.Logger) {
+	for _, ps := range s.processors {
+		log.Infof("stages: %s dropped %d samples", ps.name, ps.dropped.Load())
+	}
+}
+
+// processStage runs a processor and hands what it keeps to next.
+type processStage struct {
+	name    string
+	p       Processor
+	next    SampleRecorder
+	dropped atomic.Uint64
+}
+
+func (s *processStage) pass(v models.SensorSample) {
+	v, ok := s.p.Process(v)
+	if !ok {
+		s.dropped.Add(1)
+		return
+	}
+	switch v := v.(type) {
+	case models.CameraFrame:
+		s.next.RecordCamera(v)
+	case models.GPSData:
+		s.next.RecordGPS(v)
+	case models.IMUData:
+		s.next.RecordIMU(v)
+	case models.LidarPacket:
+		s.next.RecordLidar(v)
+	case models.RadarScan:
+		s.next.RecordRadar(v)
+	case models.EnvData:
+		s.next.RecordEnv(v)
+	case models.TriggerPulse:
+		s.next.RecordTrigger(v)
+	case models.INSData:
+		s.next.RecordINS(v)
+	}
+}
+
+func (s *processStage) RecordCamera(v models.CameraFrame)   { s.pass(v) }
+func (s *processStage) RecordGPS(v models.GPSData)          { s.pass(v) }
+func (s *processStage) RecordIMU(v models.IMUData)          { s.pass(v) }
+func (s *processStage) RecordLidar(v models.LidarPacket)    { s.pass(v) }
+func (s *processStage) RecordRadar(v models.RadarScan)      { s.pass(v) }
+func (s *processStage) RecordEnv(v models.EnvData)          { s.pass(v) }
+func (s *processStage) RecordTrigger(v models.TriggerPulse) { s.pass(v) }
+func (s *processStage) RecordINS(v models.INSData)          { s.pass(v) }
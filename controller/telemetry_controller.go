@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/ingest"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/telemetry"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// TelemetryController reports on a recording to the fleet server: a
+// status every status_interval_s built from the readers, the session and
+// the events of the bus, and the GPS fixes it records, one every
+// position_interval_s, as breadcrumbs. It is a SampleRecorder for the
+// fixes; the other samples are ignored.
+type TelemetryController struct {
+	cfg       utils.TelemetryConfig
+	readers   *SensorsController
+	recording *RecordingController
+	uplink    *telemetry.Uplink
+	events    *events.Subscription
+	start     time.Time
+	// previous are the reader stats at the last status, sent at last.
+	previous []ingest.Stats
+	last     time.Time
+
+	mu      sync.Mutex
+	lastFix time.Time
+	alerts  []models.Event
+	errored bool
+}
+
+func NewTelemetryController(cfg utils.TelemetryConfig, readers *SensorsController, recording *RecordingController, bus *events.Bus, log utils.Logger) (*TelemetryController, error) {
+	u, err := telemetry.NewUplink(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &TelemetryController{
+		cfg:       cfg,
+		readers:   readers,
+		recording: recording,
+		uplink:    u,
+		events:    bus.Subscribe(64),
+		start:     now,
+		previous:  make([]ingest.Stats, len(readers.readers)),
+		last:      now,
+	}, nil
+}
+
+// Run sends a status every interval until ctx is cancelled, and a last one
+// marking the recording stopped before the uplink makes its last attempt.
+func (t *TelemetryController) Run(ctx context.Context) {
+	upCtx, stopUplink := context.WithCancel(context.Background())
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		t.uplink.Run(upCtx)
+	}()
+	ticker := time.NewTicker(time.Duration(t.cfg.StatusIntervalS) * time.Second)
+	defer ticker.Stop()
+	t.uplink.Queue(t.status(true))
+	for done := false; !done; {
+		select {
+		case e := <-t.events.C:
+			t.note(e)
+		case <-ticker.C:
+			t.uplink.Queue(t.status(true))
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	t.events.Close()
+	for e := range t.events.C {
+		t.note(e)
+	}
+	t.uplink.Queue(t.status(false))
+	stopUplink()
+	<-sent
+}
+
+// note keeps the warnings and errors for the next status.
+func (t *TelemetryController) note(e models.Event) {
+	if e.Level == events.Info {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.alerts = append(t.alerts, e)
+	t.errored = t.errored || e.Level == events.Error
+}
+
+func (t *TelemetryController) status(recording bool) telemetry.Message {
+	now := time.Now()
+	secs := now.Sub(t.last).Seconds()
+	t.last = now
+	st := &telemetry.Status{
+		UptimeS:   now.Sub(t.start).Seconds(),
+		Recording: recording,
+		Sensors:   map[string]telemetry.SensorStatus{},
+	}
+	for i, r := range t.readers.readers {
+		s := r.Stats()
+		rate := 0.0
+		if secs > 0 {
+			rate = float64(s.Produced-t.previous[i].Produced) / secs
+		}
+		st.Sensors[r.Name()] = telemetry.SensorStatus{
+			RateHz:   rate,
+			Dropped:  s.Dropped - t.previous[i].Dropped,
+			Restarts: t.readers.runs[i].restarts.Load(),
+		}
+		t.previous[i] = s
+	}
+	var fs syscall.Statfs_t
+	if syscall.Statfs(t.recording.Dir(), &fs) == nil {
+		st.DiskFreeBytes = int64(fs.Bavail) * fs.Bsize
+	}
+	t.mu.Lock()
+	st.Events = t.alerts[max(0, len(t.alerts)-telemetry.MaxEvents):]
+	st.Healthy = !t.errored && t.recording.Err() == nil
+	t.alerts, t.errored = nil, false
+	t.mu.Unlock()
+	st.Spooled, st.Dropped = t.uplink.Backlog()
+	m := t.message(telemetry.KindStatus, utils.Now())
+	m.Status = st
+	return m
+}
+
+func (t *TelemetryController) message(kind string, at time.Time) telemetry.Message {
+	return telemetry.Message{Kind: kind, Vehicle: t.cfg.Vehicle, Session: filepath.Base(t.recording.Dir()), Time: at}
+}
+
+// RecordGPS sends a breadcrumb of g when the last one is at least
+// position_interval_s old and g has a fix.
+func (t *TelemetryController) RecordGPS(g models.GPSData) {
+	if g.FixQuality == 0 {
+		return
+	}
+	t.mu.Lock()
+	due := g.Timestamp.Sub(t.lastFix) >= time.Duration(t.cfg.PositionIntervalS)*time.Second
+	if due {
+		t.lastFix = g.Timestamp
+	}
+	t.mu.Unlock()
+	if !due {
+		return
+	}
+	m := t.message(telemetry.KindPosition, g.Timestamp)
+	m.Position = &telemetry.Position{
+		Lat: g.Lat, Lon: g.Lon, Alt: g.Alt, SpeedMps: g.SpeedMps, HeadingDeg: g.HeadingDeg,
+		FixQuality: g.FixQuality, Satellites: g.Satellites,
+	}
+	t.uplink.Queue(m)
+}
+
+func (t *TelemetryController) RecordCamera(models.CameraFrame)   {}
+func (t *TelemetryController) RecordIMU(models.IMUData)          {}
+func (t *TelemetryController) RecordLidar(models.LidarPacket)    {}
+func (t *TelemetryController) RecordRadar(models.RadarScan)      {}
+func (t *TelemetryController) RecordEnv(models.EnvData)          {}
+func (t *TelemetryController) RecordTrigger(models.TriggerPulse) {}
+func (t *TelemetryController) RecordINS(models.INSData)          {}
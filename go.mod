@@ -0,0 +1,9 @@
+module github.com/lkumar3-iitr/Sensor-Logger
+
+go 1.22
+
+require (
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/image v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
+)
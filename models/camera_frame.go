@@ -0,0 +1,108 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// CameraFrame is one captured image. Data holds the encoded JPEG; Path is
+// set by the recorder once the frame has been assigned a file on disk.
+//
+// ExposureUs and GainDB are filled in when the camera reports them, and
+// Stats when frame statistics are enabled; the matching camera.csv columns
+// are left empty otherwise. Right is set for the frames of a stereo pair,
+// of which this is the left camera, and Trigger for frames taken near a
+// pulse of the hardware trigger.
+type CameraFrame struct {
+	Timestamp  time.Time     `json:"timestamp"`
+	FrameID    uint64        `json:"frame_id"`
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	ExposureUs *float64      `json:"exposure_us,omitempty"`
+	GainDB     *float64      `json:"gain_db,omitempty"`
+	Stats      *ImageStats   `json:"stats,omitempty"`
+	Data       []byte        `json:"data,omitempty"`
+	Path       string        `json:"path,omitempty"`
+	Right      *StereoFrame  `json:"right,omitempty"`
+	Trigger    *TriggerMatch `json:"trigger,omitempty"`
+	// Frozen is how long the picture had been the same by this frame, 0
+	// for a frame that differs from the one before; see
+	// utils.FreezeConfig.
+	Frozen time.Duration `json:"frozen_ns,omitempty"`
+}
+
+// StereoFrame is the right image of a stereo pair. Skew is the time it was
+// captured after the left one, negative if before.
+type StereoFrame struct {
+	Width  int           `json:"width"`
+	Height int           `json:"height"`
+	Skew   time.Duration `json:"skew_ns"`
+	Data   []byte        `json:"data,omitempty"`
+	Path   string        `json:"path,omitempty"`
+}
+
+// ImageStats summarises the luminance of one frame, for filtering dark,
+// clipped or blurred frames during dataset curation. Luminance values are
+// on the 0-255 scale of the JPEG Y channel.
+type ImageStats struct {
+	MeanLum float64 `json:"mean_lum"`
+	P05Lum  float64 `json:"p05_lum"`
+	P50Lum  float64 `json:"p50_lum"`
+	P95Lum  float64 `json:"p95_lum"`
+	// DarkPct and BrightPct are the percentages of pixels at or below 5 and
+	// at or above 250, i.e. crushed blacks and blown highlights.
+	DarkPct   float64 `json:"dark_pct"`
+	BrightPct float64 `json:"bright_pct"`
+	// Sharpness is the variance of the Laplacian of the luminance. Low
+	// values indicate motion blur or defocus; the threshold depends on the
+	// scene and lens.
+	Sharpness float64 `json:"sharpness"`
+}
+
+func (CameraFrame) Sensor() string { return "camera" }
+
+func (CameraFrame) CSVHeader() []string { return schemaHeader(CameraCSV) }
+
+// StereoCSVHeader lists the columns appended to camera.csv for a stereo
+// pair: the path of the right frame and the skew in milliseconds.
+func (CameraFrame) StereoCSVHeader() []string {
+	return []string{"right_path", "skew_ms"}
+}
+
+func (f CameraFrame) StereoCSVRow() []string {
+	if f.Right == nil {
+		return blanks(2)
+	}
+	return []string{f.Right.Path, formatFloat(float64(f.Right.Skew)/float64(time.Millisecond), 3)}
+}
+
+// FreezeCSVHeader lists the column appended to camera.csv with freeze
+// detection: how long the picture had been the same, in milliseconds.
+func (CameraFrame) FreezeCSVHeader() []string {
+	return []string{"frozen_ms"}
+}
+
+func (f CameraFrame) FreezeCSVRow() []string {
+	return []string{strconv.FormatInt(f.Frozen.Milliseconds(), 10)}
+}
+
+func (f CameraFrame) CSVRow() []string {
+	row := []string{
+		utils.FormatTimestamp(f.Timestamp),
+		strconv.FormatUint(f.FrameID, 10),
+		strconv.Itoa(f.Width),
+		strconv.Itoa(f.Height),
+		f.Path,
+		formatOptional(f.ExposureUs, 1),
+		formatOptional(f.GainDB, 2),
+	}
+	if s := f.Stats; s != nil {
+		row = append(row, formatFloat(s.MeanLum, 2), formatFloat(s.P05Lum, 0), formatFloat(s.P50Lum, 0),
+			formatFloat(s.P95Lum, 0), formatFloat(s.DarkPct, 2), formatFloat(s.BrightPct, 2), formatFloat(s.Sharpness, 1))
+	} else {
+		row = append(row, blanks(7)...)
+	}
+	return row
+}
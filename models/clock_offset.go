@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// ClockOffset estimates the clock of a sensor that stamps its samples
+// against the host clock over one interval. Host and Device are the
+// arrival and device time of the sample of the interval that came in with
+// the least delay; Offset is Device - Host for it, which trails the true
+// offset by that delay. Spread is how far the other samples came in later.
+// DriftPPM is the rate at which Offset changes, in microseconds a second,
+// once there are two intervals to compare.
+type ClockOffset struct {
+	Sensor     string
+	Host       time.Time
+	Device     time.Time
+	Samples    int
+	Offset     time.Duration
+	Spread     time.Duration
+	DriftPPM   float64
+	DriftKnown bool
+}
+
+func (ClockOffset) CSVHeader() []string { return schemaHeader(ClockSyncCSV) }
+
+func (o ClockOffset) CSVRow() []string {
+	drift := ""
+	if o.DriftKnown {
+		drift = strconv.FormatFloat(o.DriftPPM, 'f', 2, 64)
+	}
+	return []string{
+		utils.FormatTimestamp(o.Host),
+		o.Sensor,
+		utils.FormatTimestamp(o.Device),
+		strconv.Itoa(o.Samples),
+		strconv.FormatFloat(float64(o.Offset)/1e6, 'f', 3, 64),
+		strconv.FormatFloat(float64(o.Spread)/1e6, 'f', 3, 64),
+		drift,
+	}
+}
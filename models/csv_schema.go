@@ -0,0 +1,111 @@
+package models
+
+import "slices"
+
+// File names of the per-session CSV outputs.
+const (
+	CameraCSV  = "camera.csv"
+	GPSCSV     = "gps.csv"
+	IMUCSV     = "imu.csv"
+	LidarCSV   = "lidar.csv"
+	RadarCSV   = "radar.csv"
+	EnvCSV     = "env.csv"
+	TriggerCSV = "trigger.csv"
+	INSCSV     = "ins.csv"
+	FusedCSV   = "fused.csv"
+	// FusedIMUCSV holds the IMU samples batched into fused records.
+	FusedIMUCSV = "fused_imu.csv"
+
+	RadarTransformedCSV = "radar_transformed.csv"
+	RadarDetectionsCSV  = "radar_detections.csv"
+	GapsCSV             = "gaps.csv"
+	SystemCSV           = "system.csv"
+	EventsCSV           = "events.csv"
+	ClockSyncCSV        = "clock_sync.csv"
+)
+
+// SchemaColumns is the source of truth for the column order of every CSV
+// file, keyed by file name. Optional fused.csv column groups are listed in
+// FusedOptionalColumns and appended in that order when enabled. The
+// CSVHeader methods of the models are made of these.
+var SchemaColumns = map[string][]string{
+	CameraCSV: {
+		"timestamp", "frame_id", "width", "height", "path",
+		"exposure_us", "gain_db",
+		"mean_lum", "p05_lum", "p50_lum", "p95_lum", "dark_pct", "bright_pct", "sharpness",
+	},
+	GPSCSV: {
+		"timestamp", "lat", "lon", "alt", "speed_mps", "heading_deg", "hdop", "satellites", "fix_quality", "correction_age_s",
+		"h_acc_m", "v_acc_m", "speed_acc_mps", "heading_acc_deg",
+	},
+	IMUCSV:              {"timestamp", "seq", "ax", "ay", "az", "gx", "gy", "gz", "mx", "my", "mz"},
+	FusedIMUCSV:         {"fused_timestamp", "timestamp", "seq", "ax", "ay", "az", "gx", "gy", "gz", "mx", "my", "mz"},
+	LidarCSV:            {"timestamp", "seq", "num_points", "path", "point_format"},
+	RadarCSV:            {"timestamp", "scan_seq", "target_id", "range_m", "azimuth_deg", "velocity_mps", "rcs_dbsm"},
+	EnvCSV:              {"timestamp", "temperature_c", "humidity_pct", "pressure_hpa"},
+	TriggerCSV:          {"timestamp", "trigger_id"},
+	RadarTransformedCSV: {"timestamp", "scan_seq", "target_id", "frame", "x", "y", "z"},
+	RadarDetectionsCSV:  {"timestamp", "scan_seq", "num_detections", "path"},
+	GapsCSV:             {"sensor", "start", "end", "duration_ms", "missing"},
+	SystemCSV: {
+		"timestamp", "cpu_pct", "host_cpu_pct", "rss_bytes", "goroutines", "gomaxprocs",
+		"disk_inflight", "temperature_c", "cpu_freq_mhz",
+	},
+	INSCSV: {
+		"timestamp", "seq", "mode", "status", "gnss_fix", "roll_deg", "pitch_deg", "yaw_deg",
+		"vel_n_mps", "vel_e_mps", "vel_d_mps", "att_acc_deg", "pos_acc_m", "vel_acc_mps",
+	},
+	EventsCSV:    {"timestamp", "kind", "level", "source", "message"},
+	ClockSyncCSV: {"timestamp", "sensor", "device_time", "samples", "offset_ms", "spread_ms", "drift_ppm"},
+	FusedCSV: {
+		"timestamp",
+		"cam_frame_id",
+		"gps_lat", "gps_lon", "gps_alt", "gps_speed_mps", "gps_heading_deg",
+		"imu_ax", "imu_ay", "imu_az", "imu_gx", "imu_gy", "imu_gz",
+		"lidar_seq", "lidar_num_points",
+		"radar_seq", "radar_num_targets",
+		"present_mask", "cam_age_ms", "gps_age_ms", "imu_age_ms", "lidar_age_ms", "radar_age_ms",
+	},
+}
+
+// ValidColumn is appended to the sensor CSVs and fused.csv when validation
+// marks records: 1 for a valid record, 0 for an invalid one. For fused.csv
+// a row is invalid when any sample in it is.
+const ValidColumn = "valid"
+
+// ClockEventColumn is appended to the sensor CSVs and fused.csv when clock
+// jumps are flagged (utils.ClockFlag). The first row of a file after a
+// jump holds the step in seconds, e.g. -3.215; other rows leave it empty.
+const ClockEventColumn = "clock_event"
+
+// SessionIDColumn and RecordIDColumn are appended to every CSV file of a
+// session but the blob journal when record IDs are on
+// (utils.StorageConfig.RecordIDs), after the marks: the UUID of the
+// session and an ID unique among the rows of all its files, counting up in
+// the order the rows were written.
+const (
+	SessionIDColumn = "session_id"
+	RecordIDColumn  = "record_id"
+)
+
+// FusedOptionalColumns lists the fused.csv column groups that are only
+// present when the corresponding option is enabled. Of the fill group,
+// only the columns of the sensors with a stale policy are.
+var FusedOptionalColumns = map[string][]string{
+	"env":        {"env_temperature_c", "env_humidity_pct", "env_pressure_hpa", "env_age_ms"},
+	"heading":    {"heading_deg"},
+	"radar_grid": {"radar_grid"},
+	"imu_batch":  {"imu_count"},
+	"utm":        {"gps_utm_easting", "gps_utm_northing", "gps_utm_zone"},
+	"enu":        {"gps_enu_x", "gps_enu_y"},
+	"fill":       {"cam_fill", "gps_fill", "imu_fill", "lidar_fill", "radar_fill", "env_fill"},
+	"valid":      {ValidColumn},
+	"clock":      {ClockEventColumn},
+	"ids":        {SessionIDColumn, RecordIDColumn},
+}
+
+// schemaHeader returns a copy of the columns of file, for a CSVHeader
+// method to hand out.
+func schemaHeader(file string) []string {
+	return slices.Clone(SchemaColumns[file])
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// EnvData is one ambient reading from the environment sensor.
+type EnvData struct {
+	Timestamp    time.Time `json:"timestamp"`
+	TemperatureC float64   `json:"temperature_c"`
+	HumidityPct  float64   `json:"humidity_pct"`
+	PressureHPa  float64   `json:"pressure_hpa"`
+}
+
+func (EnvData) Sensor() string { return "env" }
+
+func (EnvData) CSVHeader() []string { return schemaHeader(EnvCSV) }
+
+func (e EnvData) CSVRow() []string {
+	return []string{
+		utils.FormatTimestamp(e.Timestamp),
+		formatFloat(e.TemperatureC, 2),
+		formatFloat(e.HumidityPct, 2),
+		formatFloat(e.PressureHPa, 2),
+	}
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Event is something that happened to the pipeline rather than a sensor
+// sample: a reader failing, a burst of dropped samples, a file that cannot
+// be written. Kind is one of the kinds of package events; Source names the
+// reader or stage it happened to, e.g. "gps" or "recording".
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Level   string    `json:"level"`
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+}
+
+func (Event) CSVHeader() []string { return schemaHeader(EventsCSV) }
+
+func (e Event) CSVRow() []string {
+	return []string{utils.FormatTimestamp(e.Time), e.Kind, e.Level, e.Source, e.Message}
+}
@@ -0,0 +1,250 @@
+package models
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// FusedRecord is the snapshot of the latest sample of every sensor taken at
+// one fusion tick. A nil field means the sensor produced nothing during the
+// window.
+type FusedRecord struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Camera    *CameraFrame `json:"camera,omitempty"`
+	GPS       *GPSData     `json:"gps,omitempty"`
+	IMU       *IMUData     `json:"imu,omitempty"`
+	Lidar     *LidarPacket `json:"lidar,omitempty"`
+	Radar     *RadarScan   `json:"radar,omitempty"`
+	Env       *EnvData     `json:"env,omitempty"`
+
+	// HeadingDeg is the magnetometer heading estimate, clockwise from true
+	// north; nil while unknown or when disabled.
+	HeadingDeg *float64 `json:"heading_deg,omitempty"`
+
+	// RadarGrid is the path, relative to the session directory, of the
+	// radar grid of the window ending at Timestamp; set by the recorder.
+	RadarGrid string `json:"radar_grid,omitempty"`
+
+	// IMUBatch holds every IMU sample received during the window, oldest
+	// first, when the fusion batches them; IMU is the last of them.
+	IMUBatch []IMUData `json:"imu_batch,omitempty"`
+
+	// Held and Interpolated have the Present bits of the sensors whose
+	// sample was not received during the window but held from an earlier
+	// one or interpolated, see utils.StaleConfig.
+	Held         int `json:"held,omitempty"`
+	Interpolated int `json:"interpolated,omitempty"`
+}
+
+// Merge folds a later record into r: r takes next's timestamp and every
+// sensor sample next carries, keeping its own where next has none.
+func (r *FusedRecord) Merge(next FusedRecord) {
+	r.Timestamp = next.Timestamp
+	if next.Camera != nil {
+		r.Camera = next.Camera
+	}
+	if next.GPS != nil {
+		r.GPS = next.GPS
+	}
+	if next.IMU != nil {
+		r.IMU = next.IMU
+	}
+	if next.Lidar != nil {
+		r.Lidar = next.Lidar
+	}
+	if next.Radar != nil {
+		r.Radar = next.Radar
+	}
+	if next.Env != nil {
+		r.Env = next.Env
+	}
+	if next.HeadingDeg != nil {
+		r.HeadingDeg = next.HeadingDeg
+	}
+	// A sample next carries replaces r's, and with it how it was filled.
+	r.Held = r.Held&^next.Present() | next.Held
+	r.Interpolated = r.Interpolated&^next.Present() | next.Interpolated
+	if len(next.IMUBatch) > 0 {
+		// Clipped so that the batch is copied rather than appended to in
+		// place: records are shared between the fused outputs.
+		r.IMUBatch = append(slices.Clip(r.IMUBatch), next.IMUBatch...)
+	}
+}
+
+// Bits of FusedRecord.Present, one per sensor.
+const (
+	PresentCamera = 1 << iota
+	PresentGPS
+	PresentIMU
+	PresentLidar
+	PresentRadar
+	PresentEnv
+)
+
+// FusedSensors are the sensors of a fused record in the order of their
+// Present bits, with the prefix of their columns in fused.csv.
+var FusedSensors = []struct{ Name, Prefix string }{
+	{"camera", "cam"}, {"gps", "gps"}, {"imu", "imu"}, {"lidar", "lidar"}, {"radar", "radar"}, {"env", "env"},
+}
+
+// Values of the fill columns of fused.csv, see FusedLayout.Fill.
+const (
+	FillSample       = "sample"
+	FillHeld         = "held"
+	FillInterpolated = "interpolated"
+)
+
+// Fill tells how r came by the sample of the sensor with Present bit bit:
+// FillSample, FillHeld, FillInterpolated, or "" when it has none.
+func (r FusedRecord) Fill(bit int) string {
+	switch {
+	case r.Present()&bit == 0:
+		return ""
+	case r.Held&bit != 0:
+		return FillHeld
+	case r.Interpolated&bit != 0:
+		return FillInterpolated
+	}
+	return FillSample
+}
+
+// Present returns the bitmask of sensors carried by r.
+func (r FusedRecord) Present() int {
+	var m int
+	for i, ok := range []bool{r.Camera != nil, r.GPS != nil, r.IMU != nil, r.Lidar != nil, r.Radar != nil, r.Env != nil} {
+		if ok {
+			m |= 1 << i
+		}
+	}
+	return m
+}
+
+// age renders how long before the fusion timestamp a sample was taken, in
+// milliseconds.
+func (r FusedRecord) age(ts time.Time) string {
+	return formatFloat(float64(r.Timestamp.Sub(ts).Microseconds())/1000, 1)
+}
+
+// FusedLayout selects the optional column groups of fused.csv. Fill
+// names the sensors, in the order of FusedSensors, that get a
+// <prefix>_fill column telling how the record came by their sample.
+type FusedLayout struct {
+	Env       bool
+	Heading   bool
+	RadarGrid bool
+	IMUBatch  bool
+	// Projection is the kind of the projected GPS columns, "" for none.
+	Projection string
+	Fill       []string
+}
+
+// CSVHeader returns the columns of fused.csv for layout l, before the
+// valid and clock_event marks: those of SchemaColumns, then the enabled
+// groups of FusedOptionalColumns.
+func (FusedRecord) CSVHeader(l FusedLayout) []string {
+	h := schemaHeader(FusedCSV)
+	for _, g := range []struct {
+		on    bool
+		group string
+	}{{l.Env, "env"}, {l.Heading, "heading"}, {l.RadarGrid, "radar_grid"}, {l.IMUBatch, "imu_batch"}} {
+		if g.on {
+			h = append(h, FusedOptionalColumns[g.group]...)
+		}
+	}
+	h = append(h, FusedOptionalColumns[l.Projection]...)
+	for i, s := range FusedSensors {
+		if slices.Contains(l.Fill, s.Name) {
+			h = append(h, FusedOptionalColumns["fill"][i])
+		}
+	}
+	return h
+}
+
+func (r FusedRecord) CSVRow(l FusedLayout) []string {
+	row := []string{utils.FormatTimestamp(r.Timestamp)}
+	if r.Camera != nil {
+		row = append(row, strconv.FormatUint(r.Camera.FrameID, 10))
+	} else {
+		row = append(row, "")
+	}
+	if g := r.GPS; g != nil {
+		row = append(row, formatFloat(g.Lat, 8), formatFloat(g.Lon, 8), formatFloat(g.Alt, 2),
+			formatFloat(g.SpeedMps, 3), formatFloat(g.HeadingDeg, 2))
+	} else {
+		row = append(row, blanks(5)...)
+	}
+	if d := r.IMU; d != nil {
+		row = append(row, formatFloat(d.AccelX, 4), formatFloat(d.AccelY, 4), formatFloat(d.AccelZ, 4),
+			formatFloat(d.GyroX, 5), formatFloat(d.GyroY, 5), formatFloat(d.GyroZ, 5))
+	} else {
+		row = append(row, blanks(6)...)
+	}
+	if p := r.Lidar; p != nil {
+		row = append(row, strconv.FormatUint(p.Seq, 10), strconv.Itoa(p.NumPoints))
+	} else {
+		row = append(row, blanks(2)...)
+	}
+	if s := r.Radar; s != nil {
+		row = append(row, strconv.FormatUint(s.Seq, 10), strconv.Itoa(len(s.Targets)))
+	} else {
+		row = append(row, blanks(2)...)
+	}
+	row = append(row, strconv.Itoa(r.Present()))
+	var ages [5]string
+	if r.Camera != nil {
+		ages[0] = r.age(r.Camera.Timestamp)
+	}
+	if r.GPS != nil {
+		ages[1] = r.age(r.GPS.Timestamp)
+	}
+	if r.IMU != nil {
+		ages[2] = r.age(r.IMU.Timestamp)
+	}
+	if r.Lidar != nil {
+		ages[3] = r.age(r.Lidar.Timestamp)
+	}
+	if r.Radar != nil {
+		ages[4] = r.age(r.Radar.Timestamp)
+	}
+	row = append(row, ages[:]...)
+	if l.Env {
+		if e := r.Env; e != nil {
+			row = append(row, formatFloat(e.TemperatureC, 2), formatFloat(e.HumidityPct, 2), formatFloat(e.PressureHPa, 2), r.age(e.Timestamp))
+		} else {
+			row = append(row, blanks(4)...)
+		}
+	}
+	if l.Heading {
+		if r.HeadingDeg != nil {
+			row = append(row, formatFloat(*r.HeadingDeg, 2))
+		} else {
+			row = append(row, "")
+		}
+	}
+	if l.RadarGrid {
+		row = append(row, r.RadarGrid)
+	}
+	if l.IMUBatch {
+		row = append(row, strconv.Itoa(len(r.IMUBatch)))
+	}
+	if l.Projection != "" {
+		var p *Projection
+		if r.GPS != nil {
+			p = r.GPS.Projected
+		}
+		row = append(row, p.CSVRow(l.Projection)...)
+	}
+	for i, s := range FusedSensors {
+		if slices.Contains(l.Fill, s.Name) {
+			row = append(row, r.Fill(1<<i))
+		}
+	}
+	return row
+}
+
+func blanks(n int) []string {
+	return make([]string, n)
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Gap is a span in which a sensor delivered no samples although it should
+// have. Start and End are the timestamps of the samples on either side;
+// Missing estimates how many samples were lost.
+type Gap struct {
+	Sensor  string
+	Start   time.Time
+	End     time.Time
+	Missing uint64
+}
+
+func (g Gap) Duration() time.Duration { return g.End.Sub(g.Start) }
+
+func (Gap) CSVHeader() []string { return schemaHeader(GapsCSV) }
+
+func (g Gap) CSVRow() []string {
+	return []string{
+		g.Sensor,
+		utils.FormatTimestamp(g.Start),
+		utils.FormatTimestamp(g.End),
+		strconv.FormatInt(g.Duration().Milliseconds(), 10),
+		strconv.FormatUint(g.Missing, 10),
+	}
+}
+
+// GapSummary totals the gaps of one sensor over a session.
+type GapSummary struct {
+	Samples  uint64 `json:"samples"`
+	Gaps     uint64 `json:"gaps"`
+	Missing  uint64 `json:"missing"`
+	MaxGapMs int64  `json:"max_gap_ms"`
+}
+
+func (s GapSummary) String() string {
+	if s.Gaps == 0 {
+		return "no gaps"
+	}
+	return fmt.Sprintf("%d gaps, max %d ms, ~%d samples missing", s.Gaps, s.MaxGapMs, s.Missing)
+}
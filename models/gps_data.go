@@ -0,0 +1,114 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// GPSData is one navigation fix.
+type GPSData struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Lat        float64   `json:"lat"` // degrees, WGS84
+	Lon        float64   `json:"lon"` // degrees, WGS84
+	Alt        float64   `json:"alt"` // metres above mean sea level
+	SpeedMps   float64   `json:"speed_mps"`
+	HeadingDeg float64   `json:"heading_deg"` // course over ground, degrees from true north
+	HDOP       float64   `json:"hdop"`
+	Satellites int       `json:"satellites"`
+	FixQuality int       `json:"fix_quality"` // NMEA GGA fix quality, 0 = no fix
+
+	// CorrectionAgeS is the age of the differential (DGPS/RTK) corrections
+	// the fix was computed with, nil without corrections.
+	CorrectionAgeS *float64 `json:"correction_age_s,omitempty"`
+
+	// Accuracy estimates reported by UBX receivers, nil from NMEA:
+	// horizontal and vertical position, speed and heading.
+	HAccM         *float64 `json:"h_acc_m,omitempty"`
+	VAccM         *float64 `json:"v_acc_m,omitempty"`
+	SpeedAccMps   *float64 `json:"speed_acc_mps,omitempty"`
+	HeadingAccDeg *float64 `json:"heading_acc_deg,omitempty"`
+
+	// Projected is the position in the flat frame of the projection of
+	// storage.yaml, nil without one.
+	Projected *Projection `json:"projected,omitempty"`
+}
+
+// Projection is a position in metres in a flat frame: the UTM easting and
+// northing in Zone (e.g. "32N"), or the east and north offsets of a local
+// ENU frame, whose Zone is empty.
+type Projection struct {
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Zone string  `json:"zone,omitempty"`
+}
+
+// ProjectionCSVHeader lists the columns appended to gps.csv for a
+// projection of kind utils.ProjectionUTM or utils.ProjectionENU.
+func ProjectionCSVHeader(kind string) []string {
+	switch kind {
+	case utils.ProjectionUTM:
+		return []string{"utm_easting", "utm_northing", "utm_zone"}
+	case utils.ProjectionENU:
+		return []string{"enu_x", "enu_y"}
+	}
+	return nil
+}
+
+// CSVRow renders p for the columns of ProjectionCSVHeader(kind); a nil p
+// as empty cells.
+func (p *Projection) CSVRow(kind string) []string {
+	n := len(ProjectionCSVHeader(kind))
+	if p == nil {
+		return blanks(n)
+	}
+	row := []string{formatFloat(p.X, 3), formatFloat(p.Y, 3), p.Zone}
+	return row[:n]
+}
+
+// fixQualityNames names the NMEA GGA fix qualities.
+var fixQualityNames = []string{"none", "gps", "dgps", "pps", "rtk_fixed", "rtk_float", "dead_reckoning", "manual", "sim"}
+
+// FixQualityName names a GGA fix quality, e.g. "rtk_fixed".
+func FixQualityName(q int) string {
+	if q < 0 || q >= len(fixQualityNames) {
+		return "quality_" + strconv.Itoa(q)
+	}
+	return fixQualityNames[q]
+}
+
+func (GPSData) Sensor() string { return "gps" }
+
+func (GPSData) CSVHeader() []string { return schemaHeader(GPSCSV) }
+
+func (g GPSData) CSVRow() []string {
+	return []string{
+		utils.FormatTimestamp(g.Timestamp),
+		formatFloat(g.Lat, 8),
+		formatFloat(g.Lon, 8),
+		formatFloat(g.Alt, 2),
+		formatFloat(g.SpeedMps, 3),
+		formatFloat(g.HeadingDeg, 2),
+		formatFloat(g.HDOP, 2),
+		strconv.Itoa(g.Satellites),
+		strconv.Itoa(g.FixQuality),
+		formatOptional(g.CorrectionAgeS, 1),
+		formatOptional(g.HAccM, 4),
+		formatOptional(g.VAccM, 4),
+		formatOptional(g.SpeedAccMps, 3),
+		formatOptional(g.HeadingAccDeg, 2),
+	}
+}
+
+func formatFloat(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// formatOptional renders a value that may be unknown as an empty cell.
+func formatOptional(v *float64, prec int) string {
+	if v == nil {
+		return ""
+	}
+	return formatFloat(*v, prec)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// IMUData is one inertial sample. Accelerations are in m/s², angular
+// rates in rad/s and the magnetic field in µT.
+type IMUData struct {
+	Timestamp time.Time `json:"timestamp"`
+	Seq       uint64    `json:"seq"`
+	AccelX    float64   `json:"ax"`
+	AccelY    float64   `json:"ay"`
+	AccelZ    float64   `json:"az"`
+	GyroX     float64   `json:"gx"`
+	GyroY     float64   `json:"gy"`
+	GyroZ     float64   `json:"gz"`
+	MagX      float64   `json:"mx"`
+	MagY      float64   `json:"my"`
+	MagZ      float64   `json:"mz"`
+
+	// Attitude is the estimated attitude of the vehicle after the sample,
+	// nil without an attitude filter.
+	Attitude *Attitude `json:"attitude,omitempty"`
+}
+
+// Attitude is the orientation of the vehicle: roll positive with the
+// right side down, pitch positive nose up and yaw clockwise from magnetic
+// north in [0, 360), and the quaternion QW..QZ rotating vectors of the
+// vehicle frame into a local frame with x to magnetic north, y to the
+// west and z up.
+type Attitude struct {
+	RollDeg  float64 `json:"roll_deg"`
+	PitchDeg float64 `json:"pitch_deg"`
+	YawDeg   float64 `json:"yaw_deg"`
+	QW       float64 `json:"qw"`
+	QX       float64 `json:"qx"`
+	QY       float64 `json:"qy"`
+	QZ       float64 `json:"qz"`
+}
+
+// AttitudeCSVHeader lists the columns appended to imu.csv by an attitude
+// filter.
+func AttitudeCSVHeader() []string {
+	return []string{"roll_deg", "pitch_deg", "yaw_deg", "qw", "qx", "qy", "qz"}
+}
+
+// CSVRow renders a for the columns of AttitudeCSVHeader; a nil a as empty
+// cells.
+func (a *Attitude) CSVRow() []string {
+	if a == nil {
+		return blanks(7)
+	}
+	return []string{
+		formatFloat(a.RollDeg, 2), formatFloat(a.PitchDeg, 2), formatFloat(a.YawDeg, 2),
+		formatFloat(a.QW, 6), formatFloat(a.QX, 6), formatFloat(a.QY, 6), formatFloat(a.QZ, 6),
+	}
+}
+
+func (IMUData) Sensor() string { return "imu" }
+
+func (IMUData) CSVHeader() []string { return schemaHeader(IMUCSV) }
+
+func (d IMUData) CSVRow() []string {
+	return []string{
+		utils.FormatTimestamp(d.Timestamp),
+		strconv.FormatUint(d.Seq, 10),
+		formatFloat(d.AccelX, 4), formatFloat(d.AccelY, 4), formatFloat(d.AccelZ, 4),
+		formatFloat(d.GyroX, 5), formatFloat(d.GyroY, 5), formatFloat(d.GyroZ, 5),
+		formatFloat(d.MagX, 3), formatFloat(d.MagY, 3), formatFloat(d.MagZ, 3),
+	}
+}
+
+// BatchCSVHeader is the header of the IMU samples batched into fused
+// records: the timestamp of the record, then the columns of imu.csv.
+func (IMUData) BatchCSVHeader() []string { return schemaHeader(FusedIMUCSV) }
+
+// BatchCSVRow is the row of d in the batch of the fused record stamped
+// fused.
+func (d IMUData) BatchCSVRow(fused time.Time) []string {
+	return append([]string{utils.FormatTimestamp(fused)}, d.CSVRow()...)
+}
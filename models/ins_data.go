@@ -0,0 +1,53 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// INSData is one navigation solution of a combined INS. The attitude
+// follows Attitude but for the yaw, which is from true north; velocities
+// are north, east and down in m/s. Mode is the solution mode the unit
+// reports, e.g. "tracking" or "nav_position", Status its raw status word
+// and GNSSFix whether its receiver's fix went into the solution. The
+// accuracies are the unit's one-sigma estimates, 0 when it gives none.
+// Its position and speed go out as fixes of the gps reader.
+type INSData struct {
+	Timestamp time.Time `json:"timestamp"`
+	Seq       uint64    `json:"seq"`
+	Mode      string    `json:"mode"`
+	Status    uint32    `json:"status"`
+	GNSSFix   bool      `json:"gnss_fix"`
+	RollDeg   float64   `json:"roll_deg"`
+	PitchDeg  float64   `json:"pitch_deg"`
+	YawDeg    float64   `json:"yaw_deg"`
+	VelN      float64   `json:"vel_n_mps"`
+	VelE      float64   `json:"vel_e_mps"`
+	VelD      float64   `json:"vel_d_mps"`
+	AttAccDeg float64   `json:"att_acc_deg"`
+	PosAccM   float64   `json:"pos_acc_m"`
+	VelAccMps float64   `json:"vel_acc_mps"`
+}
+
+func (INSData) Sensor() string { return "ins" }
+
+func (INSData) CSVHeader() []string { return schemaHeader(INSCSV) }
+
+func (d INSData) CSVRow() []string {
+	fix := "0"
+	if d.GNSSFix {
+		fix = "1"
+	}
+	return []string{
+		utils.FormatTimestamp(d.Timestamp),
+		strconv.FormatUint(d.Seq, 10),
+		d.Mode,
+		strconv.FormatUint(uint64(d.Status), 10),
+		fix,
+		formatFloat(d.RollDeg, 3), formatFloat(d.PitchDeg, 3), formatFloat(d.YawDeg, 3),
+		formatFloat(d.VelN, 3), formatFloat(d.VelE, 3), formatFloat(d.VelD, 3),
+		formatFloat(d.AttAccDeg, 3), formatFloat(d.PosAccM, 3), formatFloat(d.VelAccMps, 3),
+	}
+}
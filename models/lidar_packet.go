@@ -0,0 +1,144 @@
+package models
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Point formats of LidarPacket.RawCloud. Both start with x, y, z and
+// intensity as little-endian float32.
+const (
+	// PointsXYZI has nothing more, 16 bytes a point. The generic UDP
+	// source sends it.
+	PointsXYZI = "xyzi"
+	// PointsXYZIR adds a word holding the LidarReturn and the ring of the
+	// point in its first two bytes, 20 bytes a point.
+	PointsXYZIR = "xyzir"
+	// PointsXYZIRT adds the time of the point to PointsXYZIR as a float32,
+	// 24 bytes a point. Velodyne sweeps use it.
+	PointsXYZIRT = "xyzirt"
+)
+
+// LidarPointSize is the size in bytes of a PointsXYZI point.
+const LidarPointSize = 16
+
+// LidarPacket is one UDP packet, sweep or simulated sweep from the lidar.
+// RawCloud holds NumPoints raw points in Format, PointsXYZI when empty;
+// Path is set by the recorder when the cloud is saved to disk. Point times
+// are relative to Timestamp. Trigger is set for a sweep started near a
+// pulse of the hardware trigger. DeviceTime is Timestamp by the clock of
+// the sensor, for sensors that send it.
+type LidarPacket struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Seq       uint64        `json:"seq"`
+	NumPoints int           `json:"num_points"`
+	Format    string        `json:"format,omitempty"`
+	RawCloud  []byte        `json:"raw_cloud,omitempty"`
+	Path      string        `json:"path,omitempty"`
+	Trigger   *TriggerMatch `json:"trigger,omitempty"`
+
+	DeviceTime *time.Time `json:"device_time,omitempty"`
+}
+
+// LidarReturn tells which echo of a laser pulse a point is. A point can be
+// both the strongest and the last echo; 0 means unknown.
+type LidarReturn uint8
+
+const (
+	ReturnStrongest LidarReturn = 1 << iota
+	ReturnLast
+	// ReturnSecond is the second-strongest echo, which dual-return
+	// lidars report when the strongest echo is also the last.
+	ReturnSecond
+)
+
+// LidarPoint is one decoded point in the sensor frame, in metres. Ring
+// numbers the beams from the lowest up; Time is the offset of the firing
+// from the packet timestamp in seconds.
+type LidarPoint struct {
+	X, Y, Z   float32
+	Intensity float32
+	Return    LidarReturn
+	Ring      uint8
+	Time      float32
+}
+
+// PointFormat returns Format, defaulting to PointsXYZI.
+func (p LidarPacket) PointFormat() string {
+	if p.Format == "" {
+		return PointsXYZI
+	}
+	return p.Format
+}
+
+// PointSize returns the size in bytes of one point of format.
+func PointSize(format string) int {
+	switch format {
+	case PointsXYZIR:
+		return LidarPointSize + 4
+	case PointsXYZIRT:
+		return LidarPointSize + 8
+	}
+	return LidarPointSize
+}
+
+// DecodePoints decodes RawCloud into points. Trailing bytes that do not
+// form a whole point are ignored.
+func (p LidarPacket) DecodePoints() []LidarPoint {
+	size := PointSize(p.Format)
+	pts := make([]LidarPoint, len(p.RawCloud)/size)
+	for i := range pts {
+		b := p.RawCloud[i*size:]
+		pts[i] = LidarPoint{
+			X:         math.Float32frombits(binary.LittleEndian.Uint32(b[0:])),
+			Y:         math.Float32frombits(binary.LittleEndian.Uint32(b[4:])),
+			Z:         math.Float32frombits(binary.LittleEndian.Uint32(b[8:])),
+			Intensity: math.Float32frombits(binary.LittleEndian.Uint32(b[12:])),
+		}
+		if size > LidarPointSize {
+			pts[i].Return, pts[i].Ring = LidarReturn(b[16]), b[17]
+		}
+		if size > LidarPointSize+4 {
+			pts[i].Time = math.Float32frombits(binary.LittleEndian.Uint32(b[20:]))
+		}
+	}
+	return pts
+}
+
+// EncodePoints is the inverse of DecodePoints for points in format.
+func EncodePoints(pts []LidarPoint, format string) []byte {
+	size := PointSize(format)
+	buf := make([]byte, len(pts)*size)
+	for i, pt := range pts {
+		b := buf[i*size:]
+		binary.LittleEndian.PutUint32(b[0:], math.Float32bits(pt.X))
+		binary.LittleEndian.PutUint32(b[4:], math.Float32bits(pt.Y))
+		binary.LittleEndian.PutUint32(b[8:], math.Float32bits(pt.Z))
+		binary.LittleEndian.PutUint32(b[12:], math.Float32bits(pt.Intensity))
+		if size > LidarPointSize {
+			b[16], b[17] = byte(pt.Return), pt.Ring
+		}
+		if size > LidarPointSize+4 {
+			binary.LittleEndian.PutUint32(b[20:], math.Float32bits(pt.Time))
+		}
+	}
+	return buf
+}
+
+func (LidarPacket) Sensor() string { return "lidar" }
+
+func (LidarPacket) CSVHeader() []string { return schemaHeader(LidarCSV) }
+
+func (p LidarPacket) CSVRow() []string {
+	return []string{
+		utils.FormatTimestamp(p.Timestamp),
+		strconv.FormatUint(p.Seq, 10),
+		strconv.Itoa(p.NumPoints),
+		p.Path,
+		p.PointFormat(),
+	}
+}
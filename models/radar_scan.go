@@ -0,0 +1,107 @@
+package models
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// RadarTarget is one tracked object reported by the radar, in polar
+// coordinates of the sensor frame. Azimuth is zero straight ahead and
+// positive to the left.
+type RadarTarget struct {
+	ID          int     `json:"id"`
+	RangeM      float64 `json:"range_m"`
+	AzimuthDeg  float64 `json:"azimuth_deg"`
+	VelocityMps float64 `json:"velocity_mps"` // radial, positive moving away
+	RCS         float64 `json:"rcs_dbsm"`
+}
+
+// RadarDetection is one raw detection of the radar, a cell of its
+// range-azimuth-doppler map above the detection threshold, in the polar
+// coordinates of RadarTarget. Elevation is positive upwards, zero for
+// radars that do not measure it.
+type RadarDetection struct {
+	RangeM       float64 `json:"range_m"`
+	AzimuthDeg   float64 `json:"azimuth_deg"`
+	ElevationDeg float64 `json:"elevation_deg"`
+	DopplerMps   float64 `json:"doppler_mps"` // radial, positive moving away
+	PowerDB      float64 `json:"power_db"`
+}
+
+// RadarDetectionSize is the size in bytes of an encoded detection: range,
+// azimuth, elevation, doppler and power as little-endian float32.
+const RadarDetectionSize = 20
+
+// RadarScan is the target list of one radar measurement cycle, and the
+// detections the targets were tracked from for radars that report them;
+// DetectionsPath is set by the recorder when those are saved to disk.
+// DeviceTime is when the radar stamped the scan by its own clock, for
+// radars that do.
+type RadarScan struct {
+	Timestamp      time.Time        `json:"timestamp"`
+	Seq            uint64           `json:"seq"`
+	Targets        []RadarTarget    `json:"targets"`
+	Detections     []RadarDetection `json:"detections,omitempty"`
+	DetectionsPath string           `json:"detections_path,omitempty"`
+
+	DeviceTime *time.Time `json:"device_time,omitempty"`
+}
+
+// EncodeDetections encodes ds as consecutive RadarDetectionSize records.
+func EncodeDetections(ds []RadarDetection) []byte {
+	buf := make([]byte, len(ds)*RadarDetectionSize)
+	for i, d := range ds {
+		b := buf[i*RadarDetectionSize:]
+		for j, v := range []float64{d.RangeM, d.AzimuthDeg, d.ElevationDeg, d.DopplerMps, d.PowerDB} {
+			binary.LittleEndian.PutUint32(b[4*j:], math.Float32bits(float32(v)))
+		}
+	}
+	return buf
+}
+
+// DecodeDetections is the inverse of EncodeDetections. Trailing bytes that
+// do not form a whole detection are ignored.
+func DecodeDetections(buf []byte) []RadarDetection {
+	ds := make([]RadarDetection, len(buf)/RadarDetectionSize)
+	for i := range ds {
+		b := buf[i*RadarDetectionSize:]
+		f := func(j int) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*j:]))) }
+		ds[i] = RadarDetection{RangeM: f(0), AzimuthDeg: f(1), ElevationDeg: f(2), DopplerMps: f(3), PowerDB: f(4)}
+	}
+	return ds
+}
+
+// DetectionsCSVHeader describes radar_detections.csv, which holds one row
+// per scan with detections, pointing at the file they were saved to.
+func (RadarScan) DetectionsCSVHeader() []string { return schemaHeader(RadarDetectionsCSV) }
+
+func (s RadarScan) DetectionsCSVRow() []string {
+	return []string{utils.FormatTimestamp(s.Timestamp), strconv.FormatUint(s.Seq, 10), strconv.Itoa(len(s.Detections)), s.DetectionsPath}
+}
+
+// CSVHeader describes radar.csv, which holds one row per target.
+func (RadarScan) Sensor() string { return "radar" }
+
+func (RadarScan) CSVHeader() []string { return schemaHeader(RadarCSV) }
+
+// CSVRows returns one row per target. A scan without targets yields no rows.
+func (s RadarScan) CSVRows() [][]string {
+	rows := make([][]string, 0, len(s.Targets))
+	ts := utils.FormatTimestamp(s.Timestamp)
+	seq := strconv.FormatUint(s.Seq, 10)
+	for _, t := range s.Targets {
+		rows = append(rows, []string{
+			ts, seq,
+			strconv.Itoa(t.ID),
+			formatFloat(t.RangeM, 3),
+			formatFloat(t.AzimuthDeg, 3),
+			formatFloat(t.VelocityMps, 3),
+			formatFloat(t.RCS, 2),
+		})
+	}
+	return rows
+}
@@ -0,0 +1,10 @@
+package models
+
+// SensorSample is a sample of any sensor. The readers hand their samples
+// to the pipeline on a single channel of SensorSample, and the consumers
+// tell them apart with a type switch.
+type SensorSample interface {
+	// Sensor names the sensor the sample is from, as its reader does:
+	// "camera", "gps", "imu", "lidar", "radar", "env", "trigger" or "ins".
+	Sensor() string
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// SystemStats is one sample of the resource use of the logger and of the
+// host it runs on. CPU percentages are over the time since the previous
+// sample; CPUPct is of one CPU, so a logger busy on two CPUs shows 200.
+// Values the host does not expose are nil.
+type SystemStats struct {
+	Timestamp  time.Time `json:"timestamp"`
+	CPUPct     float64   `json:"cpu_pct"`
+	HostCPUPct float64   `json:"host_cpu_pct"`
+	RSSBytes   int64     `json:"rss_bytes"`
+	Goroutines int       `json:"goroutines"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	// DiskInflight is the number of requests in flight on the block device
+	// of the session directory.
+	DiskInflight *int `json:"disk_inflight,omitempty"`
+	// TemperatureC is the hottest thermal zone; CPUFreqMHz the current
+	// frequency of CPU 0, which drops when the board throttles.
+	TemperatureC *float64 `json:"temperature_c,omitempty"`
+	CPUFreqMHz   *float64 `json:"cpu_freq_mhz,omitempty"`
+}
+
+func (SystemStats) CSVHeader() []string { return schemaHeader(SystemCSV) }
+
+func (s SystemStats) CSVRow() []string {
+	inflight := ""
+	if s.DiskInflight != nil {
+		inflight = strconv.Itoa(*s.DiskInflight)
+	}
+	return []string{
+		utils.FormatTimestamp(s.Timestamp),
+		formatFloat(s.CPUPct, 1),
+		formatFloat(s.HostCPUPct, 1),
+		strconv.FormatInt(s.RSSBytes, 10),
+		strconv.Itoa(s.Goroutines),
+		strconv.Itoa(s.GOMAXPROCS),
+		inflight,
+		formatOptional(s.TemperatureC, 1),
+		formatOptional(s.CPUFreqMHz, 0),
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// TriggerPulse is one pulse of the external hardware trigger, timestamped
+// on the host when its edge or line arrived. ID numbers the pulses from 1,
+// or is the pulse count printed by a serial bridge.
+type TriggerPulse struct {
+	Timestamp time.Time `json:"timestamp"`
+	ID        uint64    `json:"id"`
+}
+
+func (TriggerPulse) Sensor() string { return "trigger" }
+
+func (TriggerPulse) CSVHeader() []string { return schemaHeader(TriggerCSV) }
+
+func (p TriggerPulse) CSVRow() []string {
+	return []string{utils.FormatTimestamp(p.Timestamp), strconv.FormatUint(p.ID, 10)}
+}
+
+// TriggerMatch ties a camera frame or lidar sweep to the trigger pulse
+// nearest to it. Offset is the sample's timestamp minus the pulse's.
+type TriggerMatch struct {
+	ID     uint64        `json:"id"`
+	Offset time.Duration `json:"offset_ns"`
+}
+
+// CSVHeader lists the columns appended to camera.csv and lidar.csv when a
+// trigger is enabled.
+func (*TriggerMatch) CSVHeader() []string {
+	return []string{"trigger_id", "trigger_offset_us"}
+}
+
+// CSVRow returns the trigger columns of a sample, empty for nil m: a
+// sample no pulse fell near.
+func (m *TriggerMatch) CSVRow() []string {
+	if m == nil {
+		return blanks(2)
+	}
+	return []string{strconv.FormatUint(m.ID, 10), formatFloat(float64(m.Offset)/float64(time.Microsecond), 1)}
+}
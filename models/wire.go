@@ -0,0 +1,249 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The binary encodings of IMUData and RadarScan use the protobuf wire
+// format, so the records can be decoded by any protobuf library with the
+// schema below. Field 1 of every record is the timestamp, which lets
+// readers index a log without knowing the record type. Measurements are
+// stored as float32, which is finer than the precision of the CSV files.
+//
+//	message IMUData {
+//	  sfixed64 timestamp_ns = 1;
+//	  uint64 seq = 2;
+//	  float ax = 3; float ay = 4; float az = 5;
+//	  float gx = 6; float gy = 7; float gz = 8;
+//	  float mx = 9; float my = 10; float mz = 11;
+//	  optional Attitude attitude = 12;
+//	}
+//	message Attitude {
+//	  float roll_deg = 1; float pitch_deg = 2; float yaw_deg = 3;
+//	  float qw = 4; float qx = 5; float qy = 6; float qz = 7;
+//	}
+//	message RadarTarget {
+//	  sint64 id = 1;
+//	  float range_m = 2; float azimuth_deg = 3;
+//	  float velocity_mps = 4; float rcs_dbsm = 5;
+//	}
+//	message RadarScan {
+//	  sfixed64 timestamp_ns = 1;
+//	  uint64 seq = 2;
+//	  repeated RadarTarget targets = 3;
+//	}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated record")
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendFixed64(b []byte, field int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(appendTag(b, field, wireFixed64), v)
+}
+
+func appendFloat(b []byte, field int, v float64) []byte {
+	return binary.LittleEndian.AppendUint32(appendTag(b, field, wireFixed32), math.Float32bits(float32(v)))
+}
+
+func appendTime(b []byte, field int, t time.Time) []byte {
+	return appendFixed64(b, field, uint64(t.UnixNano()))
+}
+
+func appendUvarint(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendSvarint(b []byte, field int, v int64) []byte {
+	return binary.AppendVarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendMessage(b []byte, field int, msg []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(msg)))
+	return append(b, msg...)
+}
+
+// wireField is one decoded field. For varint and fixed fields the value is
+// in v; length-delimited fields are in data.
+type wireField struct {
+	num  int
+	wire int
+	v    uint64
+	data []byte
+}
+
+func (f wireField) float() float64  { return float64(math.Float32frombits(uint32(f.v))) }
+func (f wireField) time() time.Time { return time.Unix(0, int64(f.v)).UTC() }
+func (f wireField) svarint() int64  { return int64(f.v>>1) ^ -int64(f.v&1) }
+
+// eachField calls fn for every field of msg in order.
+func eachField(msg []byte, fn func(wireField) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errTruncated
+		}
+		msg = msg[n:]
+		f := wireField{num: int(tag >> 3), wire: int(tag & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.v, n = binary.Uvarint(msg); n <= 0 {
+				return errTruncated
+			}
+			msg = msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return errTruncated
+			}
+			f.v, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return errTruncated
+			}
+			f.data, msg = msg[n:n+int(l)], msg[n+int(l):]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return errTruncated
+			}
+			f.v, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", f.wire)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordTime returns the timestamp (field 1) of an encoded record.
+func RecordTime(msg []byte) (time.Time, error) {
+	var t time.Time
+	found := errors.New("found")
+	err := eachField(msg, func(f wireField) error {
+		if f.num == 1 && f.wire == wireFixed64 {
+			t = f.time()
+			return found
+		}
+		return nil
+	})
+	if err == found {
+		return t, nil
+	}
+	if err == nil {
+		err = errors.New("record has no timestamp")
+	}
+	return time.Time{}, err
+}
+
+// MarshalBinary encodes d in the protobuf wire format.
+func (d IMUData) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 64)
+	b = appendTime(b, 1, d.Timestamp)
+	b = appendUvarint(b, 2, d.Seq)
+	for i, v := range []float64{d.AccelX, d.AccelY, d.AccelZ, d.GyroX, d.GyroY, d.GyroZ, d.MagX, d.MagY, d.MagZ} {
+		b = appendFloat(b, 3+i, v)
+	}
+	if a := d.Attitude; a != nil {
+		var m []byte
+		for i, v := range []float64{a.RollDeg, a.PitchDeg, a.YawDeg, a.QW, a.QX, a.QY, a.QZ} {
+			m = appendFloat(m, 1+i, v)
+		}
+		b = appendMessage(b, 12, m)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a record written by MarshalBinary. Unknown fields
+// are skipped.
+func (d *IMUData) UnmarshalBinary(msg []byte) error {
+	*d = IMUData{}
+	values := []*float64{&d.AccelX, &d.AccelY, &d.AccelZ, &d.GyroX, &d.GyroY, &d.GyroZ, &d.MagX, &d.MagY, &d.MagZ}
+	return eachField(msg, func(f wireField) error {
+		switch {
+		case f.num == 1 && f.wire == wireFixed64:
+			d.Timestamp = f.time()
+		case f.num == 2 && f.wire == wireVarint:
+			d.Seq = f.v
+		case f.num >= 3 && f.num <= 11 && f.wire == wireFixed32:
+			*values[f.num-3] = f.float()
+		case f.num == 12 && f.wire == wireBytes:
+			a := &Attitude{}
+			d.Attitude = a
+			values := []*float64{&a.RollDeg, &a.PitchDeg, &a.YawDeg, &a.QW, &a.QX, &a.QY, &a.QZ}
+			return eachField(f.data, func(f wireField) error {
+				if f.num >= 1 && f.num <= 7 && f.wire == wireFixed32 {
+					*values[f.num-1] = f.float()
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// MarshalBinary encodes s in the protobuf wire format.
+func (s RadarScan) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 16+len(s.Targets)*28)
+	b = appendTime(b, 1, s.Timestamp)
+	b = appendUvarint(b, 2, s.Seq)
+	var t []byte
+	for _, tg := range s.Targets {
+		t = appendSvarint(t[:0], 1, int64(tg.ID))
+		t = appendFloat(t, 2, tg.RangeM)
+		t = appendFloat(t, 3, tg.AzimuthDeg)
+		t = appendFloat(t, 4, tg.VelocityMps)
+		t = appendFloat(t, 5, tg.RCS)
+		b = appendMessage(b, 3, t)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a record written by MarshalBinary. Unknown fields
+// are skipped.
+func (s *RadarScan) UnmarshalBinary(msg []byte) error {
+	*s = RadarScan{}
+	return eachField(msg, func(f wireField) error {
+		switch {
+		case f.num == 1 && f.wire == wireFixed64:
+			s.Timestamp = f.time()
+		case f.num == 2 && f.wire == wireVarint:
+			s.Seq = f.v
+		case f.num == 3 && f.wire == wireBytes:
+			var tg RadarTarget
+			err := eachField(f.data, func(f wireField) error {
+				switch {
+				case f.num == 1 && f.wire == wireVarint:
+					tg.ID = int(f.svarint())
+				case f.num == 2 && f.wire == wireFixed32:
+					tg.RangeM = f.float()
+				case f.num == 3 && f.wire == wireFixed32:
+					tg.AzimuthDeg = f.float()
+				case f.num == 4 && f.wire == wireFixed32:
+					tg.VelocityMps = f.float()
+				case f.num == 5 && f.wire == wireFixed32:
+					tg.RCS = f.float()
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("radar target: %w", err)
+			}
+			s.Targets = append(s.Targets, tg)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,213 @@
+// Package alerts raises live alerts on the fused records of a session,
+// such as a speed limit exceeded, a geofence left or a sensor gone quiet,
+// so that the people in the vehicle hear of them while they still can act.
+package alerts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/privacy"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// clearMarginMps is how far below above_mps the speed must drop to clear
+// a speed alert, so that a vehicle cruising at the limit does not flood
+// events.csv.
+const clearMarginMps = 0.5
+
+// presentBits maps the sensors a stall rule can watch to their bit of
+// models.FusedRecord.Present.
+var presentBits = map[string]int{
+	"camera": models.PresentCamera,
+	"gps":    models.PresentGPS,
+	"imu":    models.PresentIMU,
+	"lidar":  models.PresentLidar,
+	"radar":  models.PresentRadar,
+	"env":    models.PresentEnv,
+}
+
+// Alert is a rule raised or cleared, as published to MQTT and POSTed to
+// the webhook. The position is that of the last fix, when there was one.
+type Alert struct {
+	Time     time.Time `json:"time"`
+	Session  string    `json:"session"`
+	Rule     string    `json:"rule"`
+	Type     string    `json:"type"`
+	State    string    `json:"state"` // "raised" or "cleared"
+	Message  string    `json:"message"`
+	Lat      *float64  `json:"lat,omitempty"`
+	Lon      *float64  `json:"lon,omitempty"`
+	SpeedMps *float64  `json:"speed_mps,omitempty"`
+}
+
+// rule is a rule of the config and where it stands.
+type rule struct {
+	cfg    utils.AlertRuleConfig
+	fences []privacy.Geofence
+	bit    int
+
+	since  time.Time // the condition has held since, zero while it does not
+	raised bool
+}
+
+// Engine evaluates the rules of a utils.AlertsConfig on fused records.
+type Engine struct {
+	session string
+	rules   []*rule
+	bus     *events.Bus
+	notify  *notifier
+	log     utils.Logger
+
+	fix      *models.GPSData // the last fix
+	lastSeen map[int]time.Time
+}
+
+// New loads the geofences of cfg and checks that the sensors its rules
+// watch are enabled. session names the session in the alerts delivered.
+func New(cfg utils.AlertsConfig, sensors *utils.SensorsConfig, session string, bus *events.Bus, log utils.Logger) (*Engine, error) {
+	enabled := map[string]bool{
+		"camera": sensors.Camera.Enabled,
+		"gps":    sensors.GPS.Enabled,
+		"imu":    sensors.IMU.Enabled,
+		"lidar":  sensors.Lidar.Enabled,
+		"radar":  sensors.Radar.Enabled,
+		"env":    sensors.Env.Enabled,
+	}
+	e := &Engine{session: session, bus: bus, log: log, lastSeen: map[int]time.Time{}}
+	for _, rc := range cfg.Rules {
+		r := &rule{cfg: rc}
+		switch rc.Type {
+		case utils.AlertSpeed, utils.AlertGeofence:
+			if !sensors.GPS.Enabled {
+				return nil, fmt.Errorf("rule %s: %s needs gps", rc.Name, rc.Type)
+			}
+			if rc.Type == utils.AlertGeofence {
+				fences, err := privacy.LoadGeofences(rc.Zones)
+				if err != nil {
+					return nil, fmt.Errorf("rule %s: %w", rc.Name, err)
+				}
+				r.fences = fences
+			}
+		case utils.AlertStall:
+			bit, ok := presentBits[rc.Sensor]
+			if !ok {
+				return nil, fmt.Errorf("rule %s: unknown sensor %q", rc.Name, rc.Sensor)
+			}
+			if !enabled[rc.Sensor] {
+				return nil, fmt.Errorf("rule %s: %s is not enabled", rc.Name, rc.Sensor)
+			}
+			r.bit = bit
+		}
+		e.rules = append(e.rules, r)
+	}
+	e.notify = newNotifier(cfg, log)
+	return e, nil
+}
+
+// Run evaluates the rules on every record from in until it is closed, then
+// delivers the alerts still queued.
+func (e *Engine) Run(in <-chan models.FusedRecord) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.notify.run()
+	}()
+	for rec := range in {
+		e.observe(rec)
+	}
+	e.notify.close()
+	<-done
+}
+
+func (e *Engine) observe(rec models.FusedRecord) {
+	if rec.GPS != nil && rec.GPS.FixQuality > 0 {
+		e.fix = rec.GPS
+	}
+	// A sample held or interpolated by the fusion was not received.
+	present := rec.Present() &^ (rec.Held | rec.Interpolated)
+	for bit := range e.lastSeen {
+		if present&bit != 0 {
+			e.lastSeen[bit] = rec.Timestamp
+		}
+	}
+	for _, r := range e.rules {
+		holds, detail := e.check(r, rec.Timestamp, present)
+		switch {
+		case holds && r.since.IsZero():
+			r.since = rec.Timestamp
+		case !holds:
+			r.since = time.Time{}
+		}
+		hold := time.Duration(r.cfg.ForS * float64(time.Second))
+		if r.cfg.Type == utils.AlertStall {
+			// The stall is its own hold.
+			hold = 0
+		}
+		switch {
+		case holds && !r.raised && rec.Timestamp.Sub(r.since) >= hold:
+			r.raised = true
+			e.publish(r, rec.Timestamp, true, detail)
+		case !holds && r.raised:
+			r.raised = false
+			e.publish(r, rec.Timestamp, false, detail)
+		}
+	}
+}
+
+// check reports whether the condition of r holds at ts, and describes it.
+func (e *Engine) check(r *rule, ts time.Time, present int) (bool, string) {
+	switch r.cfg.Type {
+	case utils.AlertSpeed:
+		if e.fix == nil {
+			return false, "no fix"
+		}
+		limit := r.cfg.AboveMps
+		if r.raised {
+			limit -= clearMarginMps
+		}
+		return e.fix.SpeedMps > limit, fmt.Sprintf("speed %.1f m/s, limit %.1f m/s", e.fix.SpeedMps, r.cfg.AboveMps)
+	case utils.AlertGeofence:
+		if e.fix == nil {
+			return false, "no fix"
+		}
+		for _, f := range r.fences {
+			if f.Contains(e.fix.Lat, e.fix.Lon) {
+				return false, fmt.Sprintf("inside %s at %.6f, %.6f", f.Name, e.fix.Lat, e.fix.Lon)
+			}
+		}
+		return true, fmt.Sprintf("outside %s at %.6f, %.6f", r.cfg.Zones, e.fix.Lat, e.fix.Lon)
+	case utils.AlertStall:
+		last, ok := e.lastSeen[r.bit]
+		if !ok {
+			// Watched from the first record on.
+			e.lastSeen[r.bit] = ts
+			last = ts
+		}
+		if present&r.bit != 0 {
+			return false, fmt.Sprintf("%s is back", r.cfg.Sensor)
+		}
+		quiet := ts.Sub(last)
+		return quiet.Seconds() > r.cfg.ForS, fmt.Sprintf("no %s sample for %v", r.cfg.Sensor, quiet.Round(100*time.Millisecond))
+	}
+	return false, ""
+}
+
+// publish puts the alert on the bus, which logs it and writes it to
+// events.csv, and hands it to the notifier.
+func (e *Engine) publish(r *rule, ts time.Time, raised bool, detail string) {
+	a := Alert{Time: ts, Session: e.session, Rule: r.cfg.Name, Type: r.cfg.Type, State: "cleared", Message: detail}
+	if raised {
+		a.State = "raised"
+		e.bus.Publishf(events.Alert, events.Warn, "alerts", "%s: %s", r.cfg.Name, detail)
+	} else {
+		e.bus.Publishf(events.AlertCleared, events.Info, "alerts", "%s cleared: %s", r.cfg.Name, detail)
+	}
+	if e.fix != nil {
+		lat, lon, speed := e.fix.Lat, e.fix.Lon, e.fix.SpeedMps
+		a.Lat, a.Lon, a.SpeedMps = &lat, &lon, &speed
+	}
+	e.notify.send(a)
+}
@@ -0,0 +1,131 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/mqtt"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	// queueSize bounds the alerts waiting for a slow broker or webhook;
+	// the ones beyond it are dropped, they are in events.csv anyway.
+	queueSize = 64
+	// mqttRetry is the wait before dialling a broker that failed again.
+	mqttRetry = 10 * time.Second
+)
+
+// notifier delivers alerts to the MQTT broker and the webhook of the
+// config, one at a time, so that a slow one holds up neither the rules nor
+// the recording.
+type notifier struct {
+	cfg   utils.AlertsConfig
+	log   utils.Logger
+	queue chan Alert
+
+	client   *mqtt.Client
+	nextDial time.Time
+	http     *http.Client
+}
+
+func newNotifier(cfg utils.AlertsConfig, log utils.Logger) *notifier {
+	return &notifier{
+		cfg:   cfg,
+		log:   log,
+		queue: make(chan Alert, queueSize),
+		http:  &http.Client{Timeout: time.Duration(cfg.Webhook.TimeoutS) * time.Second},
+	}
+}
+
+// send queues a for delivery, or drops it when the queue is full.
+func (n *notifier) send(a Alert) {
+	if n.cfg.MQTT.Broker == "" && n.cfg.Webhook.URL == "" {
+		return
+	}
+	select {
+	case n.queue <- a:
+	default:
+		n.log.Warnf("alerts: delivery queue full, %s not delivered", a.Rule)
+	}
+}
+
+// close ends run once the alerts queued are delivered.
+func (n *notifier) close() { close(n.queue) }
+
+func (n *notifier) run() {
+	for a := range n.queue {
+		payload, err := json.Marshal(a)
+		if err != nil {
+			n.log.Errorf("alerts: encode: %v", err)
+			continue
+		}
+		if n.cfg.MQTT.Broker != "" {
+			if err := n.publish(payload); err != nil {
+				n.log.Warnf("alerts: %s not published: %v", a.Rule, err)
+			}
+		}
+		if n.cfg.Webhook.URL != "" {
+			if err := n.post(payload); err != nil {
+				n.log.Warnf("alerts: %s not posted: %v", a.Rule, err)
+			}
+		}
+	}
+	if n.client != nil {
+		n.client.Close()
+	}
+	n.http.CloseIdleConnections()
+}
+
+// publish publishes payload at QoS 0, dialling the broker first if need
+// be.
+func (n *notifier) publish(payload []byte) error {
+	if n.client != nil && n.client.Err() != nil {
+		n.client.Close()
+		n.client = nil
+	}
+	if n.client == nil {
+		if time.Now().Before(n.nextDial) {
+			return fmt.Errorf("%s unreachable", n.cfg.MQTT.Broker)
+		}
+		c, err := mqtt.Dial(mqtt.Options{
+			Broker:    n.cfg.MQTT.Broker,
+			ClientID:  n.cfg.MQTT.ClientID,
+			Username:  n.cfg.MQTT.Username,
+			Password:  n.cfg.MQTT.Password,
+			KeepAlive: time.Duration(n.cfg.MQTT.KeepAliveS) * time.Second,
+		})
+		if err != nil {
+			n.nextDial = time.Now().Add(mqttRetry)
+			return err
+		}
+		n.client = c
+	}
+	return n.client.Publish(n.cfg.MQTT.Topic, payload, n.cfg.MQTT.Retain)
+}
+
+// post POSTs payload to the webhook.
+func (n *notifier) post(payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.cfg.Webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.cfg.Webhook.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.cfg.Webhook.Token)
+	}
+	resp, err := n.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", n.cfg.Webhook.URL, resp.Status)
+	}
+	return nil
+}
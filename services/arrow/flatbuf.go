@@ -0,0 +1,133 @@
+package arrow
+
+import "encoding/binary"
+
+// The IPC metadata are flatbuffers. fbTable and the other fb types
+// describe one, and build writes it front to back: each table is laid out
+// right after its vtable, and the strings, vectors and tables it refers
+// to are appended after it, since flatbuffer offsets only point forward.
+
+// fbTable is a table; slots are indexed by field ID, nil for a field
+// left out. A union takes two slots, its type (fbScalar) and its value.
+type fbTable []any
+
+// fbScalar is a little-endian scalar of size bytes.
+type fbScalar struct {
+	size int
+	v    uint64
+}
+
+func fbByte(v uint8) fbScalar  { return fbScalar{1, uint64(v)} }
+func fbShort(v int16) fbScalar { return fbScalar{2, uint64(uint16(v))} }
+func fbInt(v int32) fbScalar   { return fbScalar{4, uint64(uint32(v))} }
+func fbLong(v int64) fbScalar  { return fbScalar{8, uint64(v)} }
+
+func fbBool(v bool) fbScalar {
+	if v {
+		return fbByte(1)
+	}
+	return fbByte(0)
+}
+
+// fbStructs is a vector of structs of 8-byte fields, such as Arrow's
+// FieldNode and Buffer, given as its fields in order.
+type fbStructs []int64
+
+type fbRef struct {
+	at  int
+	obj any
+}
+
+type builder struct {
+	b       []byte
+	pending []fbRef
+}
+
+// build returns the flatbuffer of root.
+func build(root fbTable) []byte {
+	w := &builder{}
+	w.ref(root)
+	for len(w.pending) > 0 {
+		r := w.pending[0]
+		w.pending = w.pending[1:]
+		pos := w.put(r.obj)
+		binary.LittleEndian.PutUint32(w.b[r.at:], uint32(pos-r.at))
+	}
+	return w.b
+}
+
+func (w *builder) pad(align int) {
+	for len(w.b)%align != 0 {
+		w.b = append(w.b, 0)
+	}
+}
+
+// ref writes an offset to obj, which is written later.
+func (w *builder) ref(obj any) {
+	w.pad(4)
+	w.pending = append(w.pending, fbRef{len(w.b), obj})
+	w.b = append(w.b, 0, 0, 0, 0)
+}
+
+// put writes obj and returns its position.
+func (w *builder) put(obj any) int {
+	switch v := obj.(type) {
+	case fbTable:
+		return w.table(v)
+	case string:
+		w.pad(4)
+		pos := len(w.b)
+		w.b = binary.LittleEndian.AppendUint32(w.b, uint32(len(v)))
+		w.b = append(append(w.b, v...), 0)
+		return pos
+	case []fbTable:
+		w.pad(4)
+		pos := len(w.b)
+		w.b = binary.LittleEndian.AppendUint32(w.b, uint32(len(v)))
+		for _, t := range v {
+			w.ref(t)
+		}
+		return pos
+	case fbStructs:
+		// The length is 4 bytes before the 8-aligned elements.
+		for len(w.b)%8 != 4 {
+			w.b = append(w.b, 0)
+		}
+		pos := len(w.b)
+		w.b = binary.LittleEndian.AppendUint32(w.b, uint32(len(v)/2))
+		for _, x := range v {
+			w.b = binary.LittleEndian.AppendUint64(w.b, uint64(x))
+		}
+		return pos
+	}
+	panic("arrow: cannot encode flatbuffer value")
+}
+
+func (w *builder) table(t fbTable) int {
+	w.pad(2)
+	vt := len(w.b)
+	w.b = append(w.b, make([]byte, 4+2*len(t))...)
+	w.pad(8)
+	start := len(w.b)
+	w.b = append(w.b, 0, 0, 0, 0) // offset to the vtable
+	for i, v := range t {
+		if v == nil {
+			continue
+		}
+		if s, ok := v.(fbScalar); ok {
+			w.pad(s.size)
+			binary.LittleEndian.PutUint16(w.b[vt+4+2*i:], uint16(len(w.b)-start))
+			for k := range s.size {
+				w.b = append(w.b, byte(s.v>>(8*k)))
+			}
+			continue
+		}
+		w.pad(4)
+		binary.LittleEndian.PutUint16(w.b[vt+4+2*i:], uint16(len(w.b)-start))
+		w.ref(v)
+	}
+	binary.LittleEndian.PutUint16(w.b[vt:], uint16(4+2*len(t)))
+	binary.LittleEndian.PutUint16(w.b[vt+2:], uint16(len(w.b)-start))
+	binary.LittleEndian.PutUint32(w.b[start:], uint32(start-vt))
+	return start
+}
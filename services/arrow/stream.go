@@ -0,0 +1,138 @@
+// Package arrow writes Apache Arrow IPC streams of flat, nullable columns
+// of 64-bit integers, 64-bit floats and UTF-8 strings, uncompressed. A
+// stream is the Schema message, any number of RecordBatch messages and
+// EOS, as read by pyarrow.ipc.open_stream and polars.
+package arrow
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// Type is the type of a column.
+type Type int
+
+const (
+	Int64 Type = iota
+	Float64
+	String
+)
+
+// Field is a column of the stream.
+type Field struct {
+	Name string
+	Type Type
+}
+
+// EOS ends a stream.
+var EOS = []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}
+
+// Flatbuffer enums of the Arrow format.
+const (
+	metadataV5       = 4
+	headerSchema     = 1
+	headerBatch      = 3
+	typeInt          = 2
+	typeFloatingPt   = 3
+	typeUtf8         = 5
+	precisionDouble  = 2
+	endiannessLittle = 0
+)
+
+// Schema returns the message declaring fields, which starts a stream.
+func Schema(fields []Field) []byte {
+	fs := make([]fbTable, len(fields))
+	for i, f := range fields {
+		var kind uint8
+		var typ fbTable
+		switch f.Type {
+		case Int64:
+			kind, typ = typeInt, fbTable{fbInt(64), fbBool(true)}
+		case Float64:
+			kind, typ = typeFloatingPt, fbTable{fbShort(precisionDouble)}
+		default:
+			kind, typ = typeUtf8, fbTable{}
+		}
+		// name, nullable, type, dictionary, children
+		fs[i] = fbTable{f.Name, fbBool(true), fbByte(kind), typ, nil, []fbTable{}}
+	}
+	schema := fbTable{fbShort(endiannessLittle), fs}
+	return message(headerSchema, schema, nil)
+}
+
+// RecordBatch returns the message carrying rows, each a cell per field as
+// in a CSV row. An empty cell, or one that does not parse as the type of
+// its field, is null.
+func RecordBatch(fields []Field, rows [][]string) []byte {
+	n := len(rows)
+	var body []byte
+	var nodes, buffers fbStructs
+	// buffer appends b to the body, padded to 8 bytes.
+	buffer := func(b []byte) {
+		buffers = append(buffers, int64(len(body)), int64(len(b)))
+		body = append(body, b...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	for c, f := range fields {
+		valid := make([]byte, (n+7)/8)
+		nulls := 0
+		var data, offsets []byte
+		if f.Type == String {
+			offsets = binary.LittleEndian.AppendUint32(offsets, 0)
+		}
+		for r, row := range rows {
+			cell := ""
+			if c < len(row) {
+				cell = row[c]
+			}
+			ok := cell != ""
+			switch f.Type {
+			case Int64:
+				v, err := strconv.ParseInt(cell, 10, 64)
+				ok = ok && err == nil
+				data = binary.LittleEndian.AppendUint64(data, uint64(v))
+			case Float64:
+				v, err := strconv.ParseFloat(cell, 64)
+				ok = ok && err == nil
+				data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+			default:
+				data = append(data, cell...)
+				offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+			}
+			if ok {
+				valid[r/8] |= 1 << (r % 8)
+			} else {
+				nulls++
+			}
+		}
+		nodes = append(nodes, int64(n), int64(nulls))
+		if nulls == 0 {
+			valid = nil
+		}
+		buffer(valid)
+		if f.Type == String {
+			buffer(offsets)
+		}
+		buffer(data)
+	}
+	batch := fbTable{fbLong(int64(n)), nodes, buffers}
+	return message(headerBatch, batch, body)
+}
+
+// message encapsulates a Message of header, followed by body: the
+// continuation marker, the length of the metadata, the metadata padded to
+// 8 bytes and the body.
+func message(kind uint8, header fbTable, body []byte) []byte {
+	meta := build(fbTable{fbShort(metadataV5), fbByte(kind), header, fbLong(int64(len(body)))})
+	for len(meta)%8 != 0 {
+		meta = append(meta, 0)
+	}
+	b := make([]byte, 0, 8+len(meta)+len(body))
+	b = binary.LittleEndian.AppendUint32(b, 0xffffffff)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(meta)))
+	b = append(b, meta...)
+	return append(b, body...)
+}
@@ -0,0 +1,241 @@
+// Package attitude estimates the roll, pitch and yaw of the vehicle from
+// the accelerometer, gyro and magnetometer of the IMU.
+package attitude
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// maxStep is the longest gap between IMU samples the gyro is integrated
+// over; after a longer one the filter restarts from the accelerometer and
+// magnetometer.
+const maxStep = time.Second
+
+// Estimator runs a Madgwick or Mahony filter over the IMU samples, turned
+// into the vehicle frame with the mount of the calibration. Samples
+// without a magnetometer reading only correct roll and pitch; yaw then
+// follows the gyro from where it was, 0 when the filter starts without
+// one. It is safe for concurrent use.
+type Estimator struct {
+	cfg   utils.AttitudeConfig
+	mount utils.Mat4 // IMU to vehicle rotation
+
+	mu       sync.Mutex
+	q        quat
+	integral vec // Mahony integral term, rad/s
+	started  bool
+	last     time.Time
+}
+
+func NewEstimator(cfg utils.AttitudeConfig, cal utils.CalibrationConfig) *Estimator {
+	e := &Estimator{cfg: cfg, mount: cal.SensorToVehicle("imu")}
+	e.mount[0][3], e.mount[1][3], e.mount[2][3] = 0, 0, 0
+	return e
+}
+
+// Update advances the filter with d and returns the attitude after it,
+// nil while the accelerometer reads nothing to start from.
+func (e *Estimator) Update(d models.IMUData) *models.Attitude {
+	ax, ay, az := e.mount.Apply(d.AccelX, d.AccelY, d.AccelZ)
+	gx, gy, gz := e.mount.Apply(d.GyroX, d.GyroY, d.GyroZ)
+	mx, my, mz := e.mount.Apply(d.MagX, d.MagY, d.MagZ)
+	a, okAccel := unit(vec{ax, ay, az})
+	m, okMag := unit(vec{mx, my, mz})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	dt := d.Timestamp.Sub(e.last)
+	e.last = d.Timestamp
+	if !e.started || dt <= 0 || dt > maxStep {
+		if !okAccel {
+			e.started = false
+			return nil
+		}
+		e.q, e.integral, e.started = initial(a, m, okMag), vec{}, true
+		return e.q.attitude()
+	}
+	g := vec{gx, gy, gz}
+	if e.cfg.Filter == utils.AttitudeMahony {
+		e.q = e.mahony(g, a, m, okAccel, okMag, dt.Seconds())
+	} else {
+		e.q = e.madgwick(g, a, m, okAccel, okMag, dt.Seconds())
+	}
+	return e.q.attitude()
+}
+
+// madgwick takes a gradient-descent step of size beta towards the
+// attitude that best explains a and m, on top of the gyro rate g.
+func (e *Estimator) madgwick(g, a, m vec, okAccel, okMag bool, dt float64) quat {
+	q := e.q
+	dot := q.mul(quat{0, g[0], g[1], g[2]}).scale(0.5)
+	if okAccel {
+		q0, q1, q2, q3 := q[0], q[1], q[2], q[3]
+		// Gravity, and the magnetic field as it points in the horizontal
+		// and vertical of the current estimate.
+		f := []float64{
+			2*(q1*q3-q0*q2) - a[0],
+			2*(q0*q1+q2*q3) - a[1],
+			1 - 2*(q1*q1+q2*q2) - a[2],
+		}
+		j := [][4]float64{
+			{-2 * q2, 2 * q3, -2 * q0, 2 * q1},
+			{2 * q1, 2 * q0, 2 * q3, 2 * q2},
+			{0, -4 * q1, -4 * q2, 0},
+		}
+		if okMag {
+			h := q.rotate(m)
+			bx, bz := math.Hypot(h[0], h[1]), h[2]
+			f = append(f,
+				bx*(1-2*(q2*q2+q3*q3))+2*bz*(q1*q3-q0*q2)-m[0],
+				2*bx*(q1*q2-q0*q3)+2*bz*(q0*q1+q2*q3)-m[1],
+				2*bx*(q0*q2+q1*q3)+bz*(1-2*(q1*q1+q2*q2))-m[2],
+			)
+			j = append(j,
+				[4]float64{-2 * bz * q2, 2 * bz * q3, -4*bx*q2 - 2*bz*q0, -4*bx*q3 + 2*bz*q1},
+				[4]float64{-2*bx*q3 + 2*bz*q1, 2*bx*q2 + 2*bz*q0, 2*bx*q1 + 2*bz*q3, -2*bx*q0 + 2*bz*q2},
+				[4]float64{2 * bx * q2, 2*bx*q3 - 4*bz*q1, 2*bx*q0 - 4*bz*q2, 2 * bx * q1},
+			)
+		}
+		var step quat
+		for i, row := range j {
+			for k := range step {
+				step[k] += row[k] * f[i]
+			}
+		}
+		if n := step.norm(); n > 0 {
+			dot = dot.add(step.scale(-e.cfg.Beta / n))
+		}
+	}
+	return q.add(dot.scale(dt)).normalized()
+}
+
+// mahony corrects the gyro rate g by the angle between the measured and
+// the estimated directions of gravity and the magnetic field, in
+// proportion and integrated.
+func (e *Estimator) mahony(g, a, m vec, okAccel, okMag bool, dt float64) quat {
+	q := e.q
+	if okAccel {
+		// Directions of gravity and of the magnetic field in the vehicle
+		// frame by the current estimate.
+		errv := a.cross(q.conj().rotate(vec{0, 0, 1}))
+		if okMag {
+			h := q.rotate(m)
+			b := vec{math.Hypot(h[0], h[1]), 0, h[2]}
+			errv = errv.add(m.cross(q.conj().rotate(b)))
+		}
+		if e.cfg.Ki > 0 {
+			e.integral = e.integral.add(errv.scale(e.cfg.Ki * dt))
+			g = g.add(e.integral)
+		}
+		g = g.add(errv.scale(e.cfg.Kp))
+	}
+	return q.add(q.mul(quat{0, g[0], g[1], g[2]}).scale(0.5 * dt)).normalized()
+}
+
+// initial returns the attitude at which gravity and the magnetic field
+// read a and m, or with yaw 0 without m.
+func initial(a, m vec, okMag bool) quat {
+	up := a // specific force at rest points up
+	north, ok := vec{}, false
+	if okMag {
+		north, ok = unit(m.sub(up.scale(m.dot(up))))
+	}
+	if !ok {
+		fwd := vec{1, 0, 0}
+		if north, ok = unit(fwd.sub(up.scale(fwd.dot(up)))); !ok {
+			north = vec{0, 0, 1}
+		}
+	}
+	west := up.cross(north)
+	// The rows are the local axes in the vehicle frame.
+	return fromMatrix([3]vec{north, west, up})
+}
+
+// quat is a unit quaternion w, x, y, z rotating vehicle vectors into the
+// local frame.
+type quat [4]float64
+
+func (p quat) mul(q quat) quat {
+	return quat{
+		p[0]*q[0] - p[1]*q[1] - p[2]*q[2] - p[3]*q[3],
+		p[0]*q[1] + p[1]*q[0] + p[2]*q[3] - p[3]*q[2],
+		p[0]*q[2] - p[1]*q[3] + p[2]*q[0] + p[3]*q[1],
+		p[0]*q[3] + p[1]*q[2] - p[2]*q[1] + p[3]*q[0],
+	}
+}
+
+func (p quat) add(q quat) quat { return quat{p[0] + q[0], p[1] + q[1], p[2] + q[2], p[3] + q[3]} }
+
+func (p quat) scale(s float64) quat { return quat{p[0] * s, p[1] * s, p[2] * s, p[3] * s} }
+
+func (p quat) conj() quat { return quat{p[0], -p[1], -p[2], -p[3]} }
+
+func (p quat) norm() float64 { return math.Sqrt(p[0]*p[0] + p[1]*p[1] + p[2]*p[2] + p[3]*p[3]) }
+
+func (p quat) normalized() quat { return p.scale(1 / p.norm()) }
+
+// rotate returns v rotated by p.
+func (p quat) rotate(v vec) vec {
+	r := p.mul(quat{0, v[0], v[1], v[2]}).mul(p.conj())
+	return vec{r[1], r[2], r[3]}
+}
+
+// fromMatrix returns the quaternion of the rotation matrix with rows r.
+func fromMatrix(r [3]vec) quat {
+	var q quat
+	switch tr := r[0][0] + r[1][1] + r[2][2]; {
+	case tr > 0:
+		s := math.Sqrt(tr+1) * 2
+		q = quat{s / 4, (r[2][1] - r[1][2]) / s, (r[0][2] - r[2][0]) / s, (r[1][0] - r[0][1]) / s}
+	case r[0][0] > r[1][1] && r[0][0] > r[2][2]:
+		s := math.Sqrt(1+r[0][0]-r[1][1]-r[2][2]) * 2
+		q = quat{(r[2][1] - r[1][2]) / s, s / 4, (r[0][1] + r[1][0]) / s, (r[0][2] + r[2][0]) / s}
+	case r[1][1] > r[2][2]:
+		s := math.Sqrt(1+r[1][1]-r[0][0]-r[2][2]) * 2
+		q = quat{(r[0][2] - r[2][0]) / s, (r[0][1] + r[1][0]) / s, s / 4, (r[1][2] + r[2][1]) / s}
+	default:
+		s := math.Sqrt(1+r[2][2]-r[0][0]-r[1][1]) * 2
+		q = quat{(r[1][0] - r[0][1]) / s, (r[0][2] + r[2][0]) / s, (r[1][2] + r[2][1]) / s, s / 4}
+	}
+	return q.normalized()
+}
+
+// attitude returns q as a models.Attitude. The Euler angles of q, about
+// axes pointing forward, left and up, are turned into the usual vehicle
+// ones about forward, right and down.
+func (q quat) attitude() *models.Attitude {
+	w, x, y, z := q[0], q[1], q[2], q[3]
+	roll := math.Atan2(2*(w*x+y*z), 1-2*(x*x+y*y))
+	pitch := math.Asin(max(-1, min(1, 2*(w*y-z*x))))
+	yaw := math.Atan2(2*(w*z+x*y), 1-2*(y*y+z*z))
+	deg := 180 / math.Pi
+	heading := math.Mod(-yaw*deg+360, 360)
+	return &models.Attitude{RollDeg: roll * deg, PitchDeg: -pitch * deg, YawDeg: heading, QW: w, QX: x, QY: y, QZ: z}
+}
+
+type vec [3]float64
+
+func (a vec) dot(b vec) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+func (a vec) add(b vec) vec { return vec{a[0] + b[0], a[1] + b[1], a[2] + b[2]} }
+
+func (a vec) sub(b vec) vec { return vec{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+
+func (a vec) scale(s float64) vec { return vec{a[0] * s, a[1] * s, a[2] * s} }
+
+func (a vec) cross(b vec) vec {
+	return vec{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func unit(a vec) (vec, bool) {
+	n := math.Sqrt(a.dot(a))
+	if n == 0 {
+		return a, false
+	}
+	return a.scale(1 / n), true
+}
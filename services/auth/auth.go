@@ -0,0 +1,175 @@
+// Package auth checks the clients of the logger's network surfaces (HTTP,
+// ZeroMQ and the remote-agent listener) against static tokens and client
+// certificates, granting a read or control role.
+package auth
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Role is what a client may do. Roles are ordered: control includes read.
+type Role int
+
+const (
+	None Role = iota
+	Read
+	Control
+)
+
+func (r Role) String() string {
+	switch r {
+	case Read:
+		return utils.RoleRead
+	case Control:
+		return utils.RoleControl
+	}
+	return "none"
+}
+
+func parseRole(s string) Role {
+	if s == utils.RoleControl {
+		return Control
+	}
+	return Read
+}
+
+// Authenticator grants roles on one surface. A nil Authenticator is an
+// open surface that grants every client Control.
+type Authenticator struct {
+	tokens []token
+	tls    *tls.Config
+	mtls   bool
+}
+
+type token struct {
+	value []byte
+	role  Role
+}
+
+// New reads the tokens and certificates of cfg. It returns nil for a
+// surface that neither requires authentication nor serves TLS.
+func New(cfg utils.AuthConfig) (*Authenticator, error) {
+	if !cfg.Enabled() && cfg.TLS.Cert == "" {
+		return nil, nil
+	}
+	a := &Authenticator{}
+	for i, t := range cfg.Tokens {
+		v, err := utils.ReadSecret(t.Token, t.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: tokens[%d]: %w", i, err)
+		}
+		if v == "" {
+			return nil, fmt.Errorf("auth: tokens[%d] is empty", i)
+		}
+		a.tokens = append(a.tokens, token{[]byte(v), parseRole(t.Role)})
+	}
+	if cfg.TLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("auth: tls: %w", err)
+		}
+		a.tls = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if cfg.TLS.ClientCA != "" {
+		pool, err := loadPool(cfg.TLS.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("auth: tls: %w", err)
+		}
+		// Clients may still authenticate with a token instead.
+		a.tls.ClientAuth = tls.VerifyClientCertIfGiven
+		a.tls.ClientCAs = pool
+		a.mtls = true
+	}
+	return a, nil
+}
+
+// Required reports whether clients must authenticate.
+func (a *Authenticator) Required() bool {
+	return a != nil && (len(a.tokens) > 0 || a.mtls)
+}
+
+// TLS returns the server TLS config, nil when the surface is plain TCP.
+func (a *Authenticator) TLS() *tls.Config {
+	if a == nil {
+		return nil
+	}
+	return a.tls
+}
+
+// Listen listens on addr, with TLS when configured.
+func (a *Authenticator) Listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || a.TLS() == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, a.tls), nil
+}
+
+// Role returns the role of a client presenting tok (may be empty) over a
+// connection with TLS state cs (nil for plain TCP). The higher of the
+// token's and the client certificate's role wins.
+func (a *Authenticator) Role(tok string, cs *tls.ConnectionState) Role {
+	if !a.Required() {
+		return Control
+	}
+	role := None
+	if tok != "" {
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare(t.value, []byte(tok)) == 1 {
+				role = max(role, t.role)
+			}
+		}
+	}
+	if a.mtls && cs != nil && len(cs.VerifiedChains) > 0 {
+		r := Read
+		if slices.Contains(cs.PeerCertificates[0].Subject.OrganizationalUnit, utils.RoleControl) {
+			r = Control
+		}
+		role = max(role, r)
+	}
+	return role
+}
+
+// ClientTLS builds the TLS config of a client of a surface served with
+// TLSConfig, nil when cfg is disabled.
+func ClientTLS(cfg utils.ClientTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CA != "" {
+		pool, err := loadPool(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+func loadPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,130 @@
+// Package capture decides which camera frames and lidar clouds are worth
+// saving, based on the vehicle's motion.
+package capture
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// fixTimeout is how long the last GPS fix is trusted. Without a recent fix
+// the vehicle is assumed to be moving so nothing is lost.
+const fixTimeout = 5 * time.Second
+
+// Policy tracks the vehicle speed from GPS fixes and thins out saved
+// frames and clouds while the vehicle is stationary. It is safe for
+// concurrent use.
+type Policy struct {
+	cfg utils.AdaptiveConfig
+	log utils.Logger
+
+	mu         sync.Mutex
+	stationary bool
+	since      time.Time // start of the current state
+	below      time.Time // first fix below the stationary speed, or zero
+	lastFix    time.Time
+	lastFrame  time.Time
+	lastCloud  time.Time
+	stats      Stats
+}
+
+// Stats summarises the effect of a Policy on a session.
+type Stats struct {
+	StationaryS   float64 `json:"stationary_s"`
+	FramesSkipped int64   `json:"frames_skipped"`
+	CloudsSkipped int64   `json:"clouds_skipped"`
+}
+
+func NewPolicy(cfg utils.AdaptiveConfig, log utils.Logger) *Policy {
+	return &Policy{cfg: cfg, log: log}
+}
+
+// ObserveGPS updates the motion state from a fix.
+func (p *Policy) ObserveGPS(g models.GPSData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if g.FixQuality == 0 {
+		p.below = time.Time{}
+		p.setStationary(false, g.Timestamp)
+		return
+	}
+	p.lastFix = g.Timestamp
+	switch {
+	case g.SpeedMps >= p.cfg.MovingSpeedMps:
+		p.below = time.Time{}
+		p.setStationary(false, g.Timestamp)
+	case g.SpeedMps < p.cfg.StationarySpeedMps:
+		if p.below.IsZero() {
+			p.below = g.Timestamp
+		}
+		if g.Timestamp.Sub(p.below).Seconds() >= p.cfg.HoldS {
+			p.setStationary(true, g.Timestamp)
+		}
+	default:
+		p.below = time.Time{}
+	}
+}
+
+func (p *Policy) setStationary(stationary bool, ts time.Time) {
+	if stationary == p.stationary {
+		return
+	}
+	if p.stationary && !p.since.IsZero() {
+		p.stats.StationaryS += ts.Sub(p.since).Seconds()
+	}
+	p.stationary, p.since = stationary, ts
+	if stationary {
+		p.log.Infof("capture: vehicle stationary, saving %g frames/s and %g clouds/s", p.cfg.CameraFPS, p.cfg.LidarHz)
+	} else {
+		p.log.Infof("capture: vehicle moving, saving every frame and cloud")
+	}
+}
+
+// stationaryAt reports whether the vehicle is stationary at ts, falling
+// back to moving once the last fix is too old.
+func (p *Policy) stationaryAt(ts time.Time) bool {
+	if p.stationary && ts.Sub(p.lastFix) > fixTimeout {
+		p.below = time.Time{}
+		p.setStationary(false, ts)
+	}
+	return p.stationary
+}
+
+// KeepFrame reports whether the camera frame taken at ts should be saved.
+func (p *Policy) KeepFrame(ts time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keep(ts, &p.lastFrame, p.cfg.CameraFPS, &p.stats.FramesSkipped)
+}
+
+// KeepCloud reports whether the lidar cloud taken at ts should be saved.
+func (p *Policy) KeepCloud(ts time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keep(ts, &p.lastCloud, p.cfg.LidarHz, &p.stats.CloudsSkipped)
+}
+
+func (p *Policy) keep(ts time.Time, last *time.Time, rateHz float64, skipped *int64) bool {
+	if p.stationaryAt(ts) {
+		if rateHz <= 0 || ts.Sub(*last).Seconds() < 1/rateHz {
+			*skipped++
+			return false
+		}
+	}
+	*last = ts
+	return true
+}
+
+// Stats returns the totals up to end.
+func (p *Policy) Stats(end time.Time) Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	if p.stationary {
+		s.StationaryS += end.Sub(p.since).Seconds()
+	}
+	return s
+}
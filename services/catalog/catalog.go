@@ -0,0 +1,241 @@
+// Package catalog lists, inspects and removes the sessions under a storage
+// base directory.
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+const sessionPrefix = "session_"
+
+// sensorFiles maps the per-sensor CSV files and binary logs to sensor
+// names.
+var sensorFiles = []struct {
+	name  string
+	files []string
+}{
+	{"camera", []string{views.CameraCSV}},
+	{"gps", []string{views.GPSCSV}},
+	{"imu", []string{views.IMUCSV, views.IMUBin}},
+	{"lidar", []string{views.LidarCSV}},
+	{"radar", []string{views.RadarCSV, views.RadarBin}},
+	{"env", []string{views.EnvCSV}},
+}
+
+// Session is one recorded session directory.
+type Session struct {
+	Name     string
+	Dir      string
+	Start    time.Time
+	Duration time.Duration
+	Sensors  []string
+	Files    int
+	Bytes    int64
+
+	// LastWrite is the newest modification time of its files.
+	LastWrite time.Time
+
+	// Manifest is nil for sessions that did not close cleanly.
+	Manifest *views.Manifest
+}
+
+// Tags returns the tags of the session, none for one that did not close
+// cleanly.
+func (s *Session) Tags() []string {
+	if s.Manifest == nil {
+		return nil
+	}
+	return s.Manifest.Tags
+}
+
+// HasTags reports whether the session carries every one of tags.
+func (s *Session) HasTags(tags []string) bool {
+	for _, t := range tags {
+		if !slices.Contains(s.Tags(), t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan returns the sessions under baseDir, oldest first.
+func Scan(baseDir string) ([]*Session, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var out []*Session
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), sessionPrefix) {
+			continue
+		}
+		s, err := load(filepath.Join(baseDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out, nil
+}
+
+// Open returns the session called name under baseDir. name may also be a
+// path to a session directory.
+func Open(baseDir, name string) (*Session, error) {
+	dir := name
+	if !strings.ContainsRune(name, filepath.Separator) {
+		dir = filepath.Join(baseDir, name)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("no session %s", dir)
+	}
+	return load(dir)
+}
+
+func load(dir string) (*Session, error) {
+	s := &Session{Name: filepath.Base(dir), Dir: dir}
+	m, err := views.ReadManifest(dir)
+	switch {
+	case err == nil:
+		s.Manifest = m
+		s.Start, s.Duration = m.Start, m.End.Sub(m.Start)
+	case errors.Is(err, fs.ErrNotExist):
+		s.Start, _ = utils.SessionStart(s.Name)
+	default:
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	for _, c := range sensorFiles {
+		for _, f := range c.files {
+			if _, err := os.Stat(views.SessionFile(filepath.Join(dir, f))); err == nil {
+				s.Sensors = append(s.Sensors, c.name)
+				break
+			}
+		}
+	}
+	err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		s.Files++
+		s.Bytes += info.Size()
+		if info.ModTime().After(s.LastWrite) {
+			s.LastWrite = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Resumable returns the newest session under baseDir if it did not close
+// cleanly and was written to within window, or nil.
+func Resumable(baseDir string, window time.Duration) (*Session, error) {
+	entries, err := os.ReadDir(baseDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Session names sort by start time.
+	latest := ""
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), sessionPrefix) && e.Name() > latest {
+			latest = e.Name()
+		}
+	}
+	if latest == "" {
+		return nil, nil
+	}
+	s, err := load(filepath.Join(baseDir, latest))
+	if err != nil {
+		return nil, err
+	}
+	if s.Manifest != nil || s.LastWrite.IsZero() || time.Since(s.LastWrite) > window {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// Remove deletes the session directory.
+func Remove(s *Session) error {
+	return os.RemoveAll(s.Dir)
+}
+
+// Archive moves the session directory into destDir, copying it when
+// destDir is on another filesystem.
+func Archive(s *Session, destDir string) error {
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+	dst := filepath.Join(destDir, s.Name)
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	err := os.Rename(s.Dir, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(s.Dir, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(s.Dir)
+}
+
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,196 @@
+// Package cloudcodec compresses the lidar clouds saved by the recorder.
+// Compressed clouds are stored in a small container: a 16-byte header
+// followed by a deflate or zstd stream.
+//
+//	0   "SLPC"
+//	4   codec: 1 raw points, 2 quantized
+//	5   point size in bytes
+//	6   stream: 0 deflate, 1 zstd
+//	7   reserved
+//	8   length of the raw cloud, uint32 little-endian
+//	12  quantization step in metres, float32 (quantized only)
+//
+// Raw points are the cloud as received. Quantized rounds x, y and z to
+// multiples of the step and stores each axis as zigzag varint deltas from
+// the previous point, then the rest of every point (intensity, ring,
+// time) byte plane by byte plane, then any trailing bytes; neighbouring
+// points of a sweep are close, so the deltas are small and the planes
+// repetitive. Clouds written before zstd was added have 0 at offset 6
+// and read as deflate.
+package cloudcodec
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Cloud compressions, see utils.StorageConfig.CloudCompression.
+const (
+	// None stores the points as received.
+	None = "none"
+	// Deflate compresses the points losslessly.
+	Deflate = "deflate"
+	// Zstd compresses the points losslessly with zstd, a little smaller and
+	// several times faster than deflate.
+	Zstd = "zstd"
+	// Quantized rounds the coordinates to a step before compressing with
+	// zstd; the other fields are kept exactly.
+	Quantized = "quantized"
+)
+
+const (
+	magic      = "SLPC"
+	headerSize = 16
+
+	codecRaw       = 1
+	codecQuantized = 2
+
+	streamDeflate = 0
+	streamZstd    = 1
+
+	// maxSteps bounds the coordinates that can be quantized, in steps.
+	maxSteps = 1 << 31
+)
+
+// Ext returns the file extension of clouds saved with compression.
+func Ext(compression string) string {
+	if compression == None {
+		return "bin"
+	}
+	return "clz"
+}
+
+// The zstd encoder and decoder are shared: EncodeAll and DecodeAll are
+// safe for concurrent use.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// Encode compresses raw, a cloud of pointSize-byte points. step is the
+// quantization step in metres. A cloud with coordinates that cannot be
+// quantized (NaN, infinite or too far for the step) is compressed with
+// zstd as received instead.
+func Encode(compression string, raw []byte, pointSize int, step float64) []byte {
+	if compression == None {
+		return raw
+	}
+	h := make([]byte, headerSize)
+	copy(h, magic)
+	h[4], h[5] = codecRaw, byte(pointSize)
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(raw)))
+	payload := raw
+	if compression == Quantized {
+		if q, ok := quantize(raw, pointSize, step); ok {
+			h[4] = codecQuantized
+			binary.LittleEndian.PutUint32(h[12:], math.Float32bits(float32(step)))
+			payload = q
+		}
+	}
+	if compression != Deflate {
+		h[6] = streamZstd
+		return zstdEncoder.EncodeAll(payload, h)
+	}
+	buf := bytes.NewBuffer(h)
+	buf.Grow(len(payload) / 2)
+	zw, _ := flate.NewWriter(buf, flate.BestSpeed)
+	zw.Write(payload)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func quantize(raw []byte, size int, step float64) ([]byte, bool) {
+	n := len(raw) / size
+	out := make([]byte, 0, len(raw))
+	for axis := range 3 {
+		var prev int64
+		for i := range n {
+			v := float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[i*size+4*axis:])))
+			s := math.Round(v / step)
+			if !(math.Abs(s) < maxSteps) {
+				return nil, false
+			}
+			q := int64(s)
+			out = binary.AppendVarint(out, q-prev)
+			prev = q
+		}
+	}
+	for j := 12; j < size; j++ {
+		for i := range n {
+			out = append(out, raw[i*size+j])
+		}
+	}
+	return append(out, raw[n*size:]...), true
+}
+
+// Decode returns the raw cloud of a compressed cloud. Quantized clouds
+// come back with the coordinates on the quantization grid.
+func Decode(data []byte) ([]byte, error) {
+	if len(data) < headerSize || string(data[:4]) != magic {
+		return nil, errors.New("cloud: not a compressed cloud")
+	}
+	size := int(data[5])
+	rawLen := int(binary.LittleEndian.Uint32(data[8:]))
+	if size < 12 {
+		return nil, fmt.Errorf("cloud: bad point size %d", size)
+	}
+	var payload []byte
+	var err error
+	switch data[6] {
+	case streamDeflate:
+		payload, err = io.ReadAll(flate.NewReader(bytes.NewReader(data[headerSize:])))
+	case streamZstd:
+		payload, err = zstdDecoder.DecodeAll(data[headerSize:], nil)
+	default:
+		return nil, fmt.Errorf("cloud: unknown stream %d", data[6])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cloud: %w", err)
+	}
+	switch data[4] {
+	case codecRaw:
+		if len(payload) != rawLen {
+			return nil, fmt.Errorf("cloud: %d bytes for %d", len(payload), rawLen)
+		}
+		return payload, nil
+	case codecQuantized:
+		step := float64(math.Float32frombits(binary.LittleEndian.Uint32(data[12:])))
+		return dequantize(payload, rawLen, size, step)
+	}
+	return nil, fmt.Errorf("cloud: unknown codec %d", data[4])
+}
+
+func dequantize(p []byte, rawLen, size int, step float64) ([]byte, error) {
+	n := rawLen / size
+	raw := make([]byte, rawLen)
+	for axis := range 3 {
+		var q int64
+		for i := range n {
+			d, k := binary.Varint(p)
+			if k <= 0 {
+				return nil, errors.New("cloud: truncated coordinates")
+			}
+			p = p[k:]
+			q += d
+			binary.LittleEndian.PutUint32(raw[i*size+4*axis:], math.Float32bits(float32(float64(q)*step)))
+		}
+	}
+	if len(p) != (size-12)*n+rawLen-n*size {
+		return nil, fmt.Errorf("cloud: %d bytes of fields for %d points", len(p), n)
+	}
+	for j := 12; j < size; j++ {
+		for i := range n {
+			raw[i*size+j] = p[0]
+			p = p[1:]
+		}
+	}
+	copy(raw[n*size:], p)
+	return raw, nil
+}
@@ -0,0 +1,183 @@
+// Package degrade sheds saved data step by step while the disk cannot
+// keep up with the frames and clouds written to it.
+package degrade
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// buckets is the size of the latency histogram: bucket i > 0 holds the
+// latencies from 1<<(i-1) up to 1<<i ms, bucket 0 those under 1 ms and
+// the last everything from 1<<(buckets-2) ms.
+const buckets = 17
+
+// Stats summarises the degradation of a session.
+type Stats struct {
+	// StepsS is how long each step was in effect, in seconds.
+	StepsS        map[string]float64 `json:"steps_s"`
+	FramesSkipped int64              `json:"frames_skipped"`
+	CloudsSkipped int64              `json:"clouds_skipped"`
+}
+
+// Governor decides which steps of utils.DegradeConfig are in effect from
+// the write latencies it observes. It is safe for concurrent use.
+type Governor struct {
+	cfg   utils.DegradeConfig
+	steps []string
+	bus   *events.Bus
+
+	mu     sync.Mutex
+	hist   [buckets]int64
+	window time.Time   // start of the current window, zero before the first Tick
+	since  []time.Time // start of every step in effect, in order of steps
+	skip   bool        // the last frame was skipped under alternate_frames
+	stats  Stats
+}
+
+// NewGovernor returns a governor taking steps in order; those of the
+// configuration without effect on the session should be left out. Every
+// step taken or lifted is published to bus.
+func NewGovernor(cfg utils.DegradeConfig, steps []string, bus *events.Bus) *Governor {
+	return &Governor{cfg: cfg, steps: steps, bus: bus, stats: Stats{StepsS: map[string]float64{}}}
+}
+
+// ObserveWrite records the time from handing a frame or cloud to the
+// recorder to having it written.
+func (g *Governor) ObserveWrite(d time.Duration) {
+	i := 0
+	for i < buckets-1 && d >= time.Millisecond<<i {
+		i++
+	}
+	g.mu.Lock()
+	g.hist[i]++
+	g.mu.Unlock()
+}
+
+// Tick ends the current window once it is WindowS long and takes or
+// lifts a step according to its 95th percentile latency. A window
+// without writes counts as a disk keeping up.
+func (g *Governor) Tick(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.window.IsZero() {
+		g.window = now
+		return
+	}
+	if now.Sub(g.window).Seconds() < g.cfg.WindowS {
+		return
+	}
+	p95, n := g.percentile(0.95)
+	g.hist, g.window = [buckets]int64{}, now
+	limit := time.Duration(g.cfg.LatencyMs) * time.Millisecond
+	level := len(g.since)
+	switch {
+	case p95 > limit && level < len(g.steps):
+		g.since = append(g.since, now)
+		g.bus.Publishf(events.DiskSlow, events.Warn, "degrade", "disk behind, 95%% of %d writes took up to %v; %s", n, p95.Round(time.Millisecond), g.describe(g.steps[level]))
+	case p95 < limit/2 && level > 0:
+		step := g.steps[level-1]
+		g.stats.StepsS[step] += now.Sub(g.since[level-1]).Seconds()
+		g.since = g.since[:level-1]
+		g.bus.Publishf(events.DiskRecovered, events.Info, "degrade", "disk caught up, 95%% of %d writes took up to %v; lifting %s", n, p95.Round(time.Millisecond), step)
+	}
+}
+
+// percentile returns the latency below which fraction q of the writes of
+// the window fell, interpolated within its bucket, and the number of
+// writes.
+func (g *Governor) percentile(q float64) (time.Duration, int64) {
+	var n int64
+	for _, c := range g.hist {
+		n += c
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	rank := q * float64(n)
+	var below float64
+	for i, c := range g.hist {
+		if below+float64(c) < rank {
+			below += float64(c)
+			continue
+		}
+		lo, hi := time.Duration(0), time.Millisecond
+		if i > 0 {
+			lo, hi = time.Millisecond<<(i-1), time.Millisecond<<i
+		}
+		return lo + time.Duration(float64(hi-lo)*(rank-below)/float64(c)), n
+	}
+	return time.Millisecond << (buckets - 1), n
+}
+
+func (g *Governor) describe(step string) string {
+	switch step {
+	case utils.DegradeJPEGQuality:
+		return "re-encoding frames at JPEG quality " + strconv.Itoa(g.cfg.JPEGQuality)
+	case utils.DegradeAlternateFrames:
+		return "saving every other frame"
+	}
+	return "pausing lidar clouds"
+}
+
+// active reports whether step is in effect; g.mu must be held.
+func (g *Governor) active(step string) bool {
+	return slices.Contains(g.steps[:len(g.since)], step)
+}
+
+// JPEGQuality returns the quality frames are re-encoded at, 0 while they
+// are saved as captured.
+func (g *Governor) JPEGQuality() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active(utils.DegradeJPEGQuality) {
+		return g.cfg.JPEGQuality
+	}
+	return 0
+}
+
+// KeepFrame reports whether a frame due to be saved should be.
+func (g *Governor) KeepFrame() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.active(utils.DegradeAlternateFrames) {
+		return true
+	}
+	g.skip = !g.skip
+	if g.skip {
+		g.stats.FramesSkipped++
+	}
+	return !g.skip
+}
+
+// KeepCloud reports whether a cloud due to be saved should be.
+func (g *Governor) KeepCloud() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active(utils.DegradeNoClouds) {
+		g.stats.CloudsSkipped++
+		return false
+	}
+	return true
+}
+
+// Stats returns the totals up to end, or nil if no step was ever taken.
+func (g *Governor) Stats(end time.Time) *Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.stats
+	s.StepsS = maps.Clone(g.stats.StepsS)
+	for i, t := range g.since {
+		s.StepsS[g.steps[i]] += end.Sub(t).Seconds()
+	}
+	if len(s.StepsS) == 0 {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,133 @@
+// Package depthmap projects lidar sweeps into the camera image, as sparse
+// depth maps aligned to the frames for depth-supervision datasets.
+package depthmap
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/transform"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Dir is the directory of a session the depth maps are saved under, in a
+// subdirectory per processor.
+const Dir = "depth"
+
+// Scale is the pixel value of a metre of depth, as in the KITTI depth
+// benchmark: a pixel holds depth×256 and 0 where no point fell.
+const Scale = 256
+
+// Processor saves a depth map for the first camera frame within max_age_ms
+// of each lidar sweep, as a 16-bit grayscale PNG of the frame's size named
+// after the frame id. Where several points fall on a pixel the nearest is
+// kept. It passes every sample on unchanged, and saves nothing until it is
+// told the session.
+type Processor struct {
+	name   string
+	camera *transform.Camera
+	lidar  *transform.Transformer
+	maxAge time.Duration
+	log    utils.Logger
+
+	mu     sync.Mutex
+	sweep  *models.LidarPacket // not yet used for a depth map
+	dir    string
+	failed bool
+}
+
+func New(name string, cfg utils.LidarDepthConfig, cal utils.CalibrationConfig, log utils.Logger) *Processor {
+	return &Processor{
+		name:   name,
+		camera: transform.NewCamera(cal),
+		lidar:  transform.NewTransformer(cal),
+		maxAge: time.Duration(cfg.MaxAgeMs) * time.Millisecond,
+		log:    log,
+	}
+}
+
+// UseSession saves the depth maps from now on under the session in dir.
+func (d *Processor) UseSession(dir string) {
+	path := filepath.Join(dir, Dir, d.name)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		d.log.Errorf("%s: %v", d.name, err)
+		return
+	}
+	d.mu.Lock()
+	d.dir = path
+	d.mu.Unlock()
+}
+
+func (d *Processor) Process(s models.SensorSample) (models.SensorSample, bool) {
+	switch v := s.(type) {
+	case models.LidarPacket:
+		d.mu.Lock()
+		d.sweep = &v
+		d.mu.Unlock()
+	case models.CameraFrame:
+		d.observe(v)
+	}
+	return s, true
+}
+
+// observe saves the depth map of f if the pending sweep is close enough.
+func (d *Processor) observe(f models.CameraFrame) {
+	if f.Width == 0 || f.Height == 0 {
+		return
+	}
+	d.mu.Lock()
+	sweep, dir := d.sweep, d.dir
+	if dir == "" || sweep == nil || f.Timestamp.Sub(sweep.Timestamp).Abs() > d.maxAge {
+		d.mu.Unlock()
+		return
+	}
+	d.sweep = nil
+	d.mu.Unlock()
+
+	data, err := encode(d.project(*sweep, f.Width, f.Height))
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, fmt.Sprintf("%08d.png", f.FrameID)), data, 0o644)
+	}
+	if err != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if !d.failed {
+			d.log.Warnf("%s: frame %d: %v (further errors are not logged)", d.name, f.FrameID, err)
+			d.failed = true
+		}
+	}
+}
+
+// project returns the depth map of sweep in an image of w×h pixels.
+func (d *Processor) project(sweep models.LidarPacket, w, h int) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, w, h))
+	pts, _ := d.lidar.LidarPoints(sweep, transform.FrameVehicle)
+	for _, pt := range pts {
+		u, v, depth, ok := d.camera.Project(transform.Point{X: float64(pt.X), Y: float64(pt.Y), Z: float64(pt.Z)}, w, h)
+		if !ok || depth*Scale > 0xffff {
+			continue
+		}
+		i := img.PixOffset(int(u), int(v))
+		px := uint16(depth*Scale + 0.5)
+		if old := uint16(img.Pix[i])<<8 | uint16(img.Pix[i+1]); old == 0 || px < old {
+			img.Pix[i], img.Pix[i+1] = byte(px>>8), byte(px)
+		}
+	}
+	return img
+}
+
+func encode(img *image.Gray16) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,179 @@
+// Package events carries the structured events of a pipeline from the
+// readers and writers that see them to whoever is interested: the log,
+// events.csv of the session and the HTTP API.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Event kinds.
+const (
+	// ReaderFailed is a reader whose device failed or disconnected.
+	ReaderFailed = "reader_failed"
+	// ReaderRestarted is a reader run again on request.
+	ReaderRestarted = "reader_restarted"
+	// DeviceDetached is the device of a reader missing, at start or after
+	// the reader failed, and DeviceAttached it found again; see
+	// utils.MissingDevicesConfig.
+	DeviceDetached = "device_detached"
+	DeviceAttached = "device_attached"
+	// SamplesDropped is a burst of samples dropped because the pipeline
+	// fell behind a reader.
+	SamplesDropped = "samples_dropped"
+	// BufferGrown is the buffer of a reader grown after its samples kept
+	// filling it, see utils.AdaptiveBuffersConfig.
+	BufferGrown = "buffer_grown"
+	// RateLimited is a reader going over its rate limit and discarding
+	// samples, and again once it is back under it; see
+	// utils.RateLimitConfig.
+	RateLimited = "rate_limited"
+	// WriteFailed is a file or sink of the session that cannot be written.
+	WriteFailed = "write_failed"
+	// Failover is the session moving to the fallback directory.
+	Failover = "failover"
+	// DiskSlow is the disk falling behind and the recorder shedding load,
+	// see utils.DegradeConfig; DiskRecovered is it catching up again.
+	DiskSlow      = "disk_slow"
+	DiskRecovered = "disk_recovered"
+	// ClockJump is a step of the wall clock, see utils.ClockJump.
+	ClockJump = "clock_jump"
+	// FullRes is saved frames going back to full resolution for a while,
+	// see utils.FrameProcessingConfig.
+	FullRes = "full_res"
+	// PowerLow is the supply voltage dropping below warn_v and
+	// PowerRestored it coming back; PowerShutdown is the sessions being
+	// closed on a supply below low_v. See utils.PowerConfig.
+	PowerLow      = "power_low"
+	PowerRestored = "power_restored"
+	PowerShutdown = "power_shutdown"
+	// Alert is a rule of utils.AlertsConfig raised and AlertCleared the
+	// same rule no longer holding.
+	Alert        = "alert"
+	AlertCleared = "alert_cleared"
+	// Note is a free-text note taken on the session, see views.Note.
+	Note = "note"
+)
+
+// Event levels, matching those of the log.
+const (
+	Info  = "info"
+	Warn  = "warn"
+	Error = "error"
+)
+
+// recentSize is the number of events kept for Recent.
+const recentSize = 256
+
+// Bus hands every event published to all subscriptions and logs it. It is
+// safe for concurrent use.
+type Bus struct {
+	log utils.Logger
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	recent []models.Event
+	next   int // index in recent of the next event once it is full
+}
+
+// NewBus returns a bus logging its events to log.
+func NewBus(log utils.Logger) *Bus {
+	return &Bus{log: log, subs: map[*Subscription]struct{}{}}
+}
+
+// Publish stamps e if it has no time, logs it as "source: message" at its
+// level and hands it to every subscription. A subscription whose buffer is
+// full misses the event rather than holding up the publisher.
+func (b *Bus) Publish(e models.Event) {
+	if e.Time.IsZero() {
+		e.Time = utils.Now()
+	}
+	switch e.Level {
+	case Error:
+		b.log.Errorf("%s: %s", e.Source, e.Message)
+	case Warn:
+		b.log.Warnf("%s: %s", e.Source, e.Message)
+	default:
+		b.log.Infof("%s: %s", e.Source, e.Message)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.recent) < recentSize {
+		b.recent = append(b.recent, e)
+	} else {
+		b.recent[b.next] = e
+		b.next = (b.next + 1) % recentSize
+	}
+	for s := range b.subs {
+		select {
+		case s.c <- e:
+		default:
+			s.missed.Add(1)
+		}
+	}
+}
+
+// Publishf publishes an event of kind at level with a formatted message.
+func (b *Bus) Publishf(kind, level, source, format string, args ...any) {
+	b.Publish(models.Event{Kind: kind, Level: level, Source: source, Message: fmt.Sprintf(format, args...)})
+}
+
+// Recent returns the last events published, oldest first.
+func (b *Bus) Recent() []models.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]models.Event, 0, len(b.recent))
+	out = append(out, b.recent[b.next:]...)
+	return append(out, b.recent[:b.next]...)
+}
+
+// ServeHTTP answers GET /events with the recent events as a JSON array.
+func (b *Bus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(b.Recent())
+}
+
+// Subscription receives the events published after Subscribe on C until
+// it is closed.
+type Subscription struct {
+	C <-chan models.Event
+
+	bus    *Bus
+	c      chan models.Event
+	missed atomic.Int64
+}
+
+// Subscribe returns a subscription buffering up to buffer events.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	c := make(chan models.Event, buffer)
+	s := &Subscription{C: c, bus: b, c: c}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Close ends the subscription and closes C; the events buffered can still
+// be received.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
+
+// Missed returns the number of events the subscription missed with its
+// buffer full.
+func (s *Subscription) Missed() int64 {
+	return s.missed.Load()
+}
@@ -0,0 +1,150 @@
+package export
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+// aviHeaderSize is the length of the RIFF, hdrl and movi headers that
+// come before the first frame of an aviWriter.
+const aviHeaderSize = 224
+
+// aviWriter writes a Motion JPEG AVI file of JPEG frames: the headers,
+// which need the frame count and size, are written over a placeholder on
+// Close, followed by the idx1 index.
+type aviWriter struct {
+	f      *os.File
+	fps    float64
+	width  int
+	height int
+	sizes  []uint32
+	maxLen uint32
+	err    error
+}
+
+func createAVI(path string, fps float64) (*aviWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &aviWriter{f: f, fps: fps}
+	_, w.err = f.Write(make([]byte, aviHeaderSize))
+	return w, nil
+}
+
+// frame appends a JPEG frame of width×height; the first sets the size of
+// the video.
+func (w *aviWriter) frame(jpg []byte, width, height int) {
+	if w.err != nil {
+		return
+	}
+	if len(w.sizes) == 0 {
+		w.width, w.height = width, height
+	}
+	b := append([]byte("00dc"), binary.LittleEndian.AppendUint32(nil, uint32(len(jpg)))...)
+	b = append(b, jpg...)
+	if len(jpg)%2 != 0 {
+		b = append(b, 0)
+	}
+	_, w.err = w.f.Write(b)
+	w.sizes = append(w.sizes, uint32(len(jpg)))
+	w.maxLen = max(w.maxLen, uint32(len(jpg)))
+}
+
+// Close writes the index and the headers and closes the file.
+func (w *aviWriter) Close() error {
+	err := w.finish()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *aviWriter) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	// Offsets in idx1 count from the "movi" fourcc.
+	var idx []byte
+	offset := uint32(4)
+	for _, n := range w.sizes {
+		idx = append(idx, "00dc"...)
+		idx = binary.LittleEndian.AppendUint32(idx, 0x10) // AVIIF_KEYFRAME
+		idx = binary.LittleEndian.AppendUint32(idx, offset)
+		idx = binary.LittleEndian.AppendUint32(idx, n)
+		offset += 8 + n + n%2
+	}
+	movi := offset
+	chunk := append([]byte("idx1"), binary.LittleEndian.AppendUint32(nil, uint32(len(idx)))...)
+	if _, err := w.f.Write(append(chunk, idx...)); err != nil {
+		return err
+	}
+
+	u32 := binary.LittleEndian.AppendUint32
+	u16 := binary.LittleEndian.AppendUint16
+	frames := uint32(len(w.sizes))
+	width, height := uint32(w.width), uint32(w.height)
+	rate := uint32(math.Round(w.fps * 1000))
+
+	avih := u32(nil, uint32(math.Round(1e6/w.fps)))
+	avih = u32(avih, uint32(float64(w.maxLen)*w.fps))
+	avih = u32(avih, 0)
+	avih = u32(avih, 0x10) // AVIF_HASINDEX
+	avih = u32(avih, frames)
+	avih = u32(avih, 0)
+	avih = u32(avih, 1)
+	avih = u32(avih, w.maxLen)
+	avih = u32(avih, width)
+	avih = u32(avih, height)
+	avih = append(avih, make([]byte, 16)...)
+
+	strh := append([]byte("vidsMJPG"), make([]byte, 12)...) // flags, priority, language, initial frames
+	strh = u32(strh, 1000)
+	strh = u32(strh, rate)
+	strh = u32(strh, 0)
+	strh = u32(strh, frames)
+	strh = u32(strh, w.maxLen)
+	strh = u32(strh, math.MaxUint32) // default quality
+	strh = u32(strh, 0)
+	strh = u16(u16(u16(u16(strh, 0), 0), uint16(width)), uint16(height))
+
+	strf := u32(nil, 40)
+	strf = u32(strf, width)
+	strf = u32(strf, height)
+	strf = u16(strf, 1)
+	strf = u16(strf, 24)
+	strf = append(strf, "MJPG"...)
+	strf = u32(strf, width*height*3)
+	strf = append(strf, make([]byte, 16)...)
+
+	strl := riffList("strl", riffChunk("strh", strh), riffChunk("strf", strf))
+	hdrl := riffList("hdrl", riffChunk("avih", avih), strl)
+	total := 4 + len(hdrl) + 8 + int(movi) + 8 + len(idx)
+	h := append([]byte("RIFF"), u32(nil, uint32(total))...)
+	h = append(append(h, "AVI "...), hdrl...)
+	h = append(append(h, "LIST"...), u32(nil, movi)...)
+	h = append(h, "movi"...)
+	if len(h) != aviHeaderSize {
+		panic("export: avi header size mismatch")
+	}
+	_, err := w.f.Seek(0, io.SeekStart)
+	if err == nil {
+		_, err = w.f.Write(h)
+	}
+	return err
+}
+
+func riffChunk(id string, data []byte) []byte {
+	b := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	return append(b, data...)
+}
+
+func riffList(kind string, chunks ...[]byte) []byte {
+	data := []byte(kind)
+	for _, c := range chunks {
+		data = append(data, c...)
+	}
+	return riffChunk("LIST", data)
+}
@@ -0,0 +1,300 @@
+package export
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/framecodec"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/rosbag"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/transform"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// BagFile is the name of the ROS 1 bag written into the output directory.
+const BagFile = "session.bag"
+
+// Topics of the bag, by sensor.
+const (
+	BagTopicCamera      = "/camera/image/compressed"
+	BagTopicGPS         = "/gps/fix"
+	BagTopicIMU         = "/imu/data"
+	BagTopicMag         = "/imu/mag"
+	BagTopicLidar       = "/lidar/points"
+	BagTopicRadar       = "/radar/points"
+	BagTopicTemperature = "/env/temperature"
+	BagTopicHumidity    = "/env/humidity"
+	BagTopicPressure    = "/env/pressure"
+	BagTopicTF          = "/tf_static"
+)
+
+// sensor_msgs/PointField datatypes.
+const (
+	pointUint8   = 2
+	pointFloat32 = 7
+)
+
+// lidarPointFields describes models.PointsXYZIRT points; the other formats
+// are prefixes of it.
+var lidarPointFields = []struct {
+	name     string
+	offset   uint32
+	datatype uint8
+}{
+	{"x", 0, pointFloat32}, {"y", 4, pointFloat32}, {"z", 8, pointFloat32}, {"intensity", 12, pointFloat32},
+	{"return", 16, pointUint8}, {"ring", 17, pointUint8}, {"time", 20, pointFloat32},
+}
+
+// bagMessage is a message due to be written, encoded when its turn comes
+// so that frames and clouds are read one at a time. encode returns nil for
+// a frame or cloud whose file is gone.
+type bagMessage struct {
+	ts     time.Time
+	conn   uint32
+	encode func(seq uint32) ([]byte, error)
+}
+
+// WriteBag converts the session in dir into a ROS 1 bag at path, with
+// the standard message types: sensor_msgs/CompressedImage for frames,
+// PointCloud2 for lidar clouds and radar targets, NavSatFix, Imu and
+// MagneticField, and Temperature, RelativeHumidity and FluidPressure for
+// the environment sensor. The calibration extrinsics are latched on
+// /tf_static. Frames and clouds that were not saved, or that are gone,
+// are left out; frames put to S3 are an error. It returns the number of
+// messages written.
+func WriteBag(dir, path string, f Filter) (int, error) {
+	bag, err := rosbag.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	msgs, frames, err := bagMessages(dir, bag, f)
+	if frames != nil {
+		defer frames.Close()
+	}
+	if err != nil {
+		bag.Close()
+		return 0, err
+	}
+	seq := map[uint32]uint32{}
+	n := 0
+	for _, m := range msgs {
+		data, err := m.encode(seq[m.conn])
+		if err != nil {
+			bag.Close()
+			return n, err
+		}
+		if data == nil {
+			continue
+		}
+		seq[m.conn]++
+		if err := bag.Write(m.conn, m.ts, data); err != nil {
+			bag.Close()
+			return n, err
+		}
+		n++
+	}
+	return n, bag.Close()
+}
+
+// bagMessages reads the samples of the session and returns their messages
+// in timestamp order, adding the connections of the topics they go to,
+// and the frames the messages of the camera read from, nil without any.
+// The frames are returned with an error too, for the caller to close.
+func bagMessages(dir string, bag *rosbag.Writer, f Filter) ([]bagMessage, views.FrameReader, error) {
+	conns := map[string]uint32{}
+	var msgs []bagMessage
+	add := func(topic string, typ *rosbag.MsgType, ts time.Time, encode func(seq uint32) ([]byte, error)) {
+		id, ok := conns[topic]
+		if !ok {
+			id = bag.Connection(topic, typ, false)
+			conns[topic] = id
+		}
+		msgs = append(msgs, bagMessage{ts, id, encode})
+	}
+
+	frames, err := ReadCamera(dir, f)
+	if err != nil {
+		return nil, nil, err
+	}
+	saved, err := OpenFrames(dir, frames)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, v := range frames {
+		if v.Path == "" {
+			continue
+		}
+		add(BagTopicCamera, rosbag.CompressedImage, v.Timestamp, func(seq uint32) ([]byte, error) {
+			if err := LoadFrame(saved, &v); errors.Is(err, fs.ErrNotExist) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			format := framecodec.Sniff(v.Data)
+			if format == "" {
+				return nil, fmt.Errorf("%s: unknown image format", v.Path)
+			}
+			m := new(rosbag.Message).Header(seq, v.Timestamp, "camera").String(format).Bytes8(v.Data)
+			return m.Bytes(), nil
+		})
+	}
+	if f.Sensor("gps") {
+		fixes, err := ReadGPS(dir, f)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, saved, err
+		}
+		for _, v := range fixes {
+			add(BagTopicGPS, rosbag.NavSatFix, v.Timestamp, func(seq uint32) ([]byte, error) { return navSatFix(seq, v), nil })
+		}
+	}
+	imu, err := ReadIMU(dir, f)
+	if err != nil {
+		return nil, saved, err
+	}
+	for _, v := range imu {
+		add(BagTopicIMU, rosbag.Imu, v.Timestamp, func(seq uint32) ([]byte, error) {
+			// Orientation is not estimated; a covariance of -1 says so.
+			m := new(rosbag.Message).Header(seq, v.Timestamp, "imu").Quaternion(0, 0, 0, 1).Float64s(-1, 0, 0, 0, 0, 0, 0, 0, 0).
+				Vector3(v.GyroX, v.GyroY, v.GyroZ).Float64s(make([]float64, 9)...).
+				Vector3(v.AccelX, v.AccelY, v.AccelZ).Float64s(make([]float64, 9)...)
+			return m.Bytes(), nil
+		})
+		add(BagTopicMag, rosbag.MagneticField, v.Timestamp, func(seq uint32) ([]byte, error) {
+			m := new(rosbag.Message).Header(seq, v.Timestamp, "imu").
+				Vector3(v.MagX*1e-6, v.MagY*1e-6, v.MagZ*1e-6).Float64s(make([]float64, 9)...)
+			return m.Bytes(), nil
+		})
+	}
+	packets, err := ReadLidar(dir, f)
+	if err != nil {
+		return nil, saved, err
+	}
+	for _, v := range packets {
+		if v.Path == "" {
+			continue
+		}
+		add(BagTopicLidar, rosbag.PointCloud2, v.Timestamp, func(seq uint32) ([]byte, error) {
+			if err := LoadCloud(dir, &v); errors.Is(err, fs.ErrNotExist) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			stride := models.PointSize(v.PointFormat())
+			m := new(rosbag.Message).Header(seq, v.Timestamp, "lidar")
+			fields := lidarPointFields[:0:0]
+			for _, pf := range lidarPointFields {
+				if int(pf.offset) < stride {
+					fields = append(fields, pf)
+				}
+			}
+			n := len(v.RawCloud) / stride
+			m.Uint32(1).Uint32(uint32(n)).Uint32(uint32(len(fields)))
+			for _, pf := range fields {
+				m.String(pf.name).Uint32(pf.offset).Uint8(pf.datatype).Uint32(1)
+			}
+			m.Bool(false).Uint32(uint32(stride)).Uint32(uint32(n * stride)).Bytes8(v.RawCloud[:n*stride]).Bool(true)
+			return m.Bytes(), nil
+		})
+	}
+	scans, err := ReadRadar(dir, f)
+	if err != nil {
+		return nil, saved, err
+	}
+	for _, v := range scans {
+		add(BagTopicRadar, rosbag.PointCloud2, v.Timestamp, func(seq uint32) ([]byte, error) { return radarCloud(seq, v), nil })
+	}
+	env, err := ReadEnv(dir, f)
+	if err != nil {
+		return nil, saved, err
+	}
+	for _, v := range env {
+		add(BagTopicTemperature, rosbag.Temperature, v.Timestamp, func(seq uint32) ([]byte, error) {
+			return new(rosbag.Message).Header(seq, v.Timestamp, "env").Float64s(v.TemperatureC, 0).Bytes(), nil
+		})
+		add(BagTopicHumidity, rosbag.RelativeHumidity, v.Timestamp, func(seq uint32) ([]byte, error) {
+			return new(rosbag.Message).Header(seq, v.Timestamp, "env").Float64s(v.HumidityPct/100, 0).Bytes(), nil
+		})
+		add(BagTopicPressure, rosbag.FluidPressure, v.Timestamp, func(seq uint32) ([]byte, error) {
+			return new(rosbag.Message).Header(seq, v.Timestamp, "env").Float64s(v.PressureHPa*100, 0).Bytes(), nil
+		})
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].ts.Before(msgs[j].ts) })
+
+	cal, err := utils.LoadCalibration(filepath.Join(dir, views.CalibYAML))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, saved, err
+	}
+	if cal != nil && len(cal.Extrinsics) > 0 && len(msgs) > 0 {
+		ts := msgs[0].ts
+		tf := bag.Connection(BagTopicTF, rosbag.TFMessage, true)
+		msgs = slices.Insert(msgs, 0, bagMessage{ts, tf, func(uint32) ([]byte, error) { return staticTransforms(ts, cal.Extrinsics), nil }})
+	}
+	return msgs, saved, nil
+}
+
+// navSatFix encodes g as a sensor_msgs/NavSatFix.
+func navSatFix(seq uint32, g models.GPSData) []byte {
+	// NavSatStatus: -1 no fix, 0 fix, 1 SBAS (differential), 2 GBAS (RTK).
+	status := int8(0)
+	switch g.FixQuality {
+	case 0:
+		status = -1
+	case 2:
+		status = 1
+	case 4, 5:
+		status = 2
+	}
+	m := new(rosbag.Message).Header(seq, g.Timestamp, "gps").Int8(status).Uint16(1).Float64s(g.Lat, g.Lon, g.Alt)
+	// Covariance types: 0 unknown, 2 diagonal known.
+	if g.HAccM != nil && g.VAccM != nil {
+		h, v := *g.HAccM**g.HAccM, *g.VAccM**g.VAccM
+		m.Float64s(h, 0, 0, 0, h, 0, 0, 0, v).Uint8(2)
+	} else {
+		m.Float64s(make([]float64, 9)...).Uint8(0)
+	}
+	return m.Bytes()
+}
+
+// radarCloud encodes the targets of s as a sensor_msgs/PointCloud2 of x,
+// y, z, radial velocity and RCS points.
+func radarCloud(seq uint32, s models.RadarScan) []byte {
+	const stride = 20
+	m := new(rosbag.Message).Header(seq, s.Timestamp, "radar").Uint32(1).Uint32(uint32(len(s.Targets))).Uint32(5)
+	for i, name := range []string{"x", "y", "z", "velocity", "rcs"} {
+		m.String(name).Uint32(uint32(4 * i)).Uint8(pointFloat32).Uint32(1)
+	}
+	data := make([]byte, 0, stride*len(s.Targets))
+	for _, t := range s.Targets {
+		sin, cos := math.Sincos(t.AzimuthDeg * math.Pi / 180)
+		for _, v := range []float64{t.RangeM * cos, t.RangeM * sin, 0, t.VelocityMps, t.RCS} {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(v)))
+		}
+	}
+	m.Bool(false).Uint32(stride).Uint32(uint32(len(data))).Bytes8(data).Bool(true)
+	return m.Bytes()
+}
+
+// staticTransforms encodes the extrinsics as a tf2_msgs/TFMessage from the
+// vehicle frame to each sensor frame.
+func staticTransforms(ts time.Time, extrinsics map[string]utils.Extrinsic) []byte {
+	names := make([]string, 0, len(extrinsics))
+	for name := range extrinsics {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	m := new(rosbag.Message).Uint32(uint32(len(names)))
+	for _, name := range names {
+		e := extrinsics[name]
+		m.Header(0, ts, string(transform.FrameVehicle)).String(name).
+			Vector3(e.Translation[0], e.Translation[1], e.Translation[2]).Quaternion(e.Quaternion())
+	}
+	return m.Bytes()
+}
@@ -0,0 +1,94 @@
+package export
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// Sensors are the sensor names a Filter accepts.
+var Sensors = []string{"camera", "gps", "imu", "lidar", "radar", "env"}
+
+// Filter selects the part of a session an exporter converts. Zero From or
+// To leave that end open; an empty Sensors keeps every sensor.
+type Filter struct {
+	From, To time.Time
+	Sensors  []string
+	// Units are those of a session without a manifest, utils.DefaultUnits
+	// when zero; see views.SessionUnits.
+	Units utils.UnitsConfig
+}
+
+// toModelUnits converts t, read from a CSV file of the session in dir,
+// into the units of the models.
+func (f Filter) toModelUnits(dir string, t *views.Table) error {
+	u, err := views.SessionUnits(dir, f.Units)
+	if err != nil {
+		return err
+	}
+	t.ToModelUnits(u)
+	return nil
+}
+
+// Sensor reports whether the filter keeps the named sensor.
+func (f Filter) Sensor(name string) bool {
+	return len(f.Sensors) == 0 || slices.Contains(f.Sensors, name)
+}
+
+// Contains reports whether t lies in [From, To).
+func (f Filter) Contains(t time.Time) bool {
+	return (f.From.IsZero() || !t.Before(f.From)) && (f.To.IsZero() || t.Before(f.To))
+}
+
+// ParseSensors parses a comma-separated list of sensor names.
+func ParseSensors(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(Sensors, name) {
+			return nil, fmt.Errorf("unknown sensor %q (%s)", name, strings.Join(Sensors, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// ParseTime parses an RFC 3339 time, Unix seconds as in the CSV files, or
+// a duration such as 1h20m counted from start.
+func ParseTime(s string, start time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := utils.ParseTimestamp(s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q: want RFC 3339, Unix seconds or an offset such as 90s", s)
+	}
+	if start.IsZero() {
+		return time.Time{}, fmt.Errorf("time %q is relative but the session start is unknown", s)
+	}
+	return start.Add(d), nil
+}
+
+// SessionStart returns the start of the session in dir from its manifest,
+// or from the directory name while the session is still open.
+func SessionStart(dir string) (time.Time, error) {
+	if m, err := views.ReadManifest(dir); err == nil {
+		return m.Start, nil
+	}
+	t, err := time.Parse("session_20060102_150405", filepath.Base(filepath.Clean(dir)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: no manifest and not a session directory name", dir)
+	}
+	return t, nil
+}
@@ -0,0 +1,278 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/image/webp"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/framecodec"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// PreviewDir is the directory of a session the preview is written to.
+const PreviewDir = "preview"
+
+// PreviewVideo is the name of the camera video of a preview.
+const PreviewVideo = "camera.avi"
+
+// PreviewStats counts what a preview holds.
+type PreviewStats struct {
+	Paths []string
+	// Rows of fused.csv, frames of the video and points of the track,
+	// out of the fixes of the session.
+	Rows, Frames, Points, Fixes int
+	// Skipped counts the saved frames left out of the video because their
+	// file is gone or cannot be decoded.
+	Skipped int
+}
+
+// WritePreview writes a preview of the session in dir into outDir, small
+// enough to upload over a mobile link for a first look: fused.csv thinned
+// to p.RateHz, the camera at p.FPS as a Motion JPEG video and the GPS
+// track simplified to p.ToleranceM, each left out when the session does
+// not have it, plus a copy of the manifest. The frames of a session that
+// put them to S3 are not read back: that is an error.
+func WritePreview(dir, outDir string, p utils.PreviewConfig, f Filter) (PreviewStats, error) {
+	var st PreviewStats
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return st, err
+	}
+	steps := []struct {
+		file string
+		fn   func(path string) (bool, error)
+	}{
+		{views.FusedCSV, func(path string) (bool, error) { return previewFused(dir, path, p.RateHz, f, &st) }},
+		{PreviewVideo, func(path string) (bool, error) { return previewVideo(dir, path, p, f, &st) }},
+		{TrackFiles["geojson"], func(path string) (bool, error) { return previewTrack(dir, path, p.ToleranceM, f, &st) }},
+		{views.ManifestFile, func(path string) (bool, error) { return copyFile(filepath.Join(dir, views.ManifestFile), path) }},
+	}
+	for _, s := range steps {
+		path := filepath.Join(outDir, s.file)
+		wrote, err := s.fn(path)
+		if err != nil {
+			return st, fmt.Errorf("%s: %w", path, err)
+		}
+		if wrote {
+			st.Paths = append(st.Paths, path)
+			continue
+		}
+		// What an earlier preview had and this one does not goes.
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+// previewFused keeps the first row of fused.csv in each 1/rate seconds.
+func previewFused(dir, path string, rate float64, f Filter, st *PreviewStats) (bool, error) {
+	t, err := views.ReadTable(filepath.Join(dir, views.FusedCSV))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return false, err
+	}
+	w := csv.NewWriter(out)
+	w.Write(t.Header)
+	period := time.Duration(float64(time.Second) / rate)
+	var next time.Time
+	for i, row := range t.Rows {
+		ts, ok := t.Time(i)
+		if !ok || !f.Contains(ts) || ts.Before(next) {
+			continue
+		}
+		w.Write(row)
+		st.Rows++
+		next = ts.Truncate(period).Add(period)
+	}
+	w.Flush()
+	err = w.Error()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return true, err
+}
+
+// previewVideo writes the first saved frame in each 1/fps seconds, scaled
+// down to p.Width. Seconds without one are left out rather than filled.
+func previewVideo(dir, path string, p utils.PreviewConfig, f Filter, st *PreviewStats) (bool, error) {
+	if !f.Sensor("camera") {
+		return false, nil
+	}
+	all, err := ReadCamera(dir, f)
+	if err != nil {
+		return false, err
+	}
+	var frames []models.CameraFrame
+	for _, fr := range all {
+		if fr.Path != "" {
+			frames = append(frames, fr)
+		}
+	}
+	if len(frames) == 0 {
+		return false, nil
+	}
+	saved, err := OpenFrames(dir, frames)
+	if err != nil {
+		return false, err
+	}
+	defer saved.Close()
+	w, err := createAVI(path, p.FPS)
+	if err != nil {
+		return false, err
+	}
+	period := time.Duration(float64(time.Second) / p.FPS)
+	var next time.Time
+	for _, fr := range frames {
+		if fr.Timestamp.Before(next) {
+			continue
+		}
+		jpg, size, ok := previewFrame(saved, &fr, p)
+		if !ok {
+			st.Skipped++
+			continue
+		}
+		w.frame(jpg, size.X, size.Y)
+		st.Frames++
+		next = fr.Timestamp.Truncate(period).Add(period)
+	}
+	return true, w.Close()
+}
+
+// previewFrame loads frame from frames and returns it as a JPEG no wider
+// than p.Width, and its size.
+func previewFrame(frames views.FrameReader, frame *models.CameraFrame, p utils.PreviewConfig) ([]byte, image.Point, bool) {
+	if LoadFrame(frames, frame) != nil {
+		return nil, image.Point{}, false
+	}
+	jpg := frame.Data
+	if format := framecodec.Sniff(jpg); format == framecodec.PNG || format == framecodec.WebP {
+		decode := png.Decode
+		if format == framecodec.WebP {
+			decode = webp.Decode
+		}
+		img, err := decode(bytes.NewReader(jpg))
+		if err != nil {
+			return nil, image.Point{}, false
+		}
+		var buf bytes.Buffer
+		if jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}) != nil {
+			return nil, image.Point{}, false
+		}
+		jpg = buf.Bytes()
+	}
+	if framecodec.Sniff(jpg) != framecodec.JPEG {
+		return nil, image.Point{}, false
+	}
+	out, err := framecodec.Process(jpg, framecodec.Processing{MaxWidth: p.Width, Quality: p.Quality}, framecodec.JPEG)
+	if err != nil {
+		return nil, image.Point{}, false
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		return nil, image.Point{}, false
+	}
+	return out, image.Pt(cfg.Width, cfg.Height), true
+}
+
+// previewTrack writes the fixes of gps.csv simplified with Douglas-Peucker
+// in local east-north metres, keeping every fix further than tolerance
+// from the line through the points kept around it.
+func previewTrack(dir, path string, tolerance float64, f Filter, st *PreviewStats) (bool, error) {
+	if !f.Sensor("gps") {
+		return false, nil
+	}
+	fixes, err := ReadGPS(dir, f)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	kept := simplify(fixes, tolerance)
+	st.Fixes, st.Points = len(fixes), len(kept)
+	out, err := os.Create(path)
+	if err != nil {
+		return false, err
+	}
+	err = writeGeoJSON(out, filepath.Base(filepath.Clean(dir))+" (preview)", kept)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return true, err
+}
+
+func simplify(fixes []models.GPSData, tolerance float64) []models.GPSData {
+	if len(fixes) < 3 {
+		return fixes
+	}
+	o := fixes[0]
+	pts := make([][2]float64, len(fixes))
+	for i, g := range fixes {
+		e, n, _ := utils.GeodeticToENU(g.Lat, g.Lon, g.Alt, o.Lat, o.Lon, o.Alt)
+		pts[i] = [2]float64{e, n}
+	}
+	keep := make([]bool, len(fixes))
+	keep[0], keep[len(fixes)-1] = true, true
+	spans := [][2]int{{0, len(fixes) - 1}}
+	for len(spans) > 0 {
+		a, b := spans[len(spans)-1][0], spans[len(spans)-1][1]
+		spans = spans[:len(spans)-1]
+		far, dist := -1, tolerance
+		for i := a + 1; i < b; i++ {
+			if d := segmentDistance(pts[i], pts[a], pts[b]); d > dist {
+				far, dist = i, d
+			}
+		}
+		if far >= 0 {
+			keep[far] = true
+			spans = append(spans, [2]int{a, far}, [2]int{far, b})
+		}
+	}
+	var kept []models.GPSData
+	for i, g := range fixes {
+		if keep[i] {
+			kept = append(kept, g)
+		}
+	}
+	return kept
+}
+
+// segmentDistance returns the distance from p to the segment ab.
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = max(0, min(1, ((p[0]-a[0])*dx+(p[1]-a[1])*dy)/l))
+	}
+	return math.Hypot(p[0]-a[0]-t*dx, p[1]-a[1]-t*dy)
+}
+
+func copyFile(src, dst string) (bool, error) {
+	data, err := os.ReadFile(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(dst, data, 0o644)
+}
@@ -0,0 +1,229 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/cloudcodec"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/framecodec"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// The readers below return the samples of one sensor of a session that a
+// Filter keeps, in file order, and none if the sensor was not recorded.
+// Rows marked invalid are skipped. Frames and clouds are not read; their
+// Path is relative to the session, see LoadFrame and LoadCloud.
+
+// readTable reads the CSV file of a sensor, in the units of the models, or
+// its binary log when the session has one, and nil when it has neither.
+func readTable(dir, csvName, binName string, f Filter) (*views.Table, error) {
+	if binName != "" {
+		t, err := views.ReadRecordTable(filepath.Join(dir, binName))
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return t, err
+		}
+	}
+	t, err := views.ReadTable(filepath.Join(dir, csvName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, f.toModelUnits(dir, t)
+}
+
+// rows calls each with the index and timestamp of every row of t that f
+// keeps.
+func rows(t *views.Table, sensor string, f Filter, each func(i int, ts time.Time)) {
+	if t == nil || !f.Sensor(sensor) {
+		return
+	}
+	for i := range t.Rows {
+		ts, ok := t.Time(i)
+		if !ok || !f.Contains(ts) || t.String(i, views.ValidColumn) == "0" {
+			continue
+		}
+		each(i, ts)
+	}
+}
+
+func float(t *views.Table, i int, name string) float64 {
+	v, _ := t.Float(i, name)
+	return v
+}
+
+func uint64Of(t *views.Table, i int, name string) uint64 {
+	v, _ := strconv.ParseUint(t.String(i, name), 10, 64)
+	return v
+}
+
+func intOf(t *views.Table, i int, name string) int {
+	v, _ := strconv.Atoi(t.String(i, name))
+	return v
+}
+
+// optional returns the named cell of row i, nil when empty.
+func optional(t *views.Table, i int, name string) *float64 {
+	if v, ok := t.Float(i, name); ok {
+		return &v
+	}
+	return nil
+}
+
+// ReadCamera reads camera.csv.
+func ReadCamera(dir string, f Filter) ([]models.CameraFrame, error) {
+	t, err := readTable(dir, views.CameraCSV, "", f)
+	if err != nil {
+		return nil, err
+	}
+	var frames []models.CameraFrame
+	rows(t, "camera", f, func(i int, ts time.Time) {
+		frames = append(frames, models.CameraFrame{
+			Timestamp: ts, FrameID: uint64Of(t, i, "frame_id"),
+			Width: intOf(t, i, "width"), Height: intOf(t, i, "height"),
+			ExposureUs: optional(t, i, "exposure_us"), GainDB: optional(t, i, "gain_db"),
+			Path: t.String(i, "path"),
+		})
+	})
+	return frames, nil
+}
+
+// ReadIMU reads imu.bin or imu.csv.
+func ReadIMU(dir string, f Filter) ([]models.IMUData, error) {
+	t, err := readTable(dir, views.IMUCSV, views.IMUBin, f)
+	if err != nil {
+		return nil, err
+	}
+	var samples []models.IMUData
+	rows(t, "imu", f, func(i int, ts time.Time) {
+		samples = append(samples, models.IMUData{
+			Timestamp: ts, Seq: uint64Of(t, i, "seq"),
+			AccelX: float(t, i, "ax"), AccelY: float(t, i, "ay"), AccelZ: float(t, i, "az"),
+			GyroX: float(t, i, "gx"), GyroY: float(t, i, "gy"), GyroZ: float(t, i, "gz"),
+			MagX: float(t, i, "mx"), MagY: float(t, i, "my"), MagZ: float(t, i, "mz"),
+		})
+	})
+	return samples, nil
+}
+
+// ReadLidar reads lidar.csv.
+func ReadLidar(dir string, f Filter) ([]models.LidarPacket, error) {
+	t, err := readTable(dir, views.LidarCSV, "", f)
+	if err != nil {
+		return nil, err
+	}
+	var packets []models.LidarPacket
+	rows(t, "lidar", f, func(i int, ts time.Time) {
+		packets = append(packets, models.LidarPacket{
+			Timestamp: ts, Seq: uint64Of(t, i, "seq"), NumPoints: intOf(t, i, "num_points"),
+			Format: t.String(i, "point_format"), Path: t.String(i, "path"),
+		})
+	})
+	return packets, nil
+}
+
+// ReadRadar reads radar.bin or radar.csv, gathering the targets of a scan
+// from its consecutive rows.
+func ReadRadar(dir string, f Filter) ([]models.RadarScan, error) {
+	t, err := readTable(dir, views.RadarCSV, views.RadarBin, f)
+	if err != nil {
+		return nil, err
+	}
+	var scans []models.RadarScan
+	rows(t, "radar", f, func(i int, ts time.Time) {
+		seq := uint64Of(t, i, "scan_seq")
+		if n := len(scans); n == 0 || scans[n-1].Seq != seq || !scans[n-1].Timestamp.Equal(ts) {
+			scans = append(scans, models.RadarScan{Timestamp: ts, Seq: seq})
+		}
+		if t.String(i, "target_id") == "" {
+			return
+		}
+		s := &scans[len(scans)-1]
+		s.Targets = append(s.Targets, models.RadarTarget{
+			ID: intOf(t, i, "target_id"), RangeM: float(t, i, "range_m"), AzimuthDeg: float(t, i, "azimuth_deg"),
+			VelocityMps: float(t, i, "velocity_mps"), RCS: float(t, i, "rcs_dbsm"),
+		})
+	})
+	return scans, nil
+}
+
+// ReadEnv reads env.csv.
+func ReadEnv(dir string, f Filter) ([]models.EnvData, error) {
+	t, err := readTable(dir, views.EnvCSV, "", f)
+	if err != nil {
+		return nil, err
+	}
+	var samples []models.EnvData
+	rows(t, "env", f, func(i int, ts time.Time) {
+		samples = append(samples, models.EnvData{
+			Timestamp: ts, TemperatureC: float(t, i, "temperature_c"),
+			HumidityPct: float(t, i, "humidity_pct"), PressureHPa: float(t, i, "pressure_hpa"),
+		})
+	})
+	return samples, nil
+}
+
+// OpenFrames opens the frames of the session in dir, see views.OpenFrames,
+// when any of frames was saved; it returns nil otherwise.
+func OpenFrames(dir string, frames []models.CameraFrame) (views.FrameReader, error) {
+	if !slices.ContainsFunc(frames, func(v models.CameraFrame) bool { return v.Path != "" }) {
+		return nil, nil
+	}
+	return views.OpenFrames(dir)
+}
+
+// LoadFrame reads the saved image of frame from frames into its Data.
+// Raw I420 frames are converted to JPEG so that Data is always an image
+// file; see framecodec.Sniff for its format.
+func LoadFrame(frames views.FrameReader, frame *models.CameraFrame) error {
+	if frame.Path == "" {
+		return fmt.Errorf("frame %d was not saved", frame.FrameID)
+	}
+	data, err := frames.ReadFrame(frame.Path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(frame.Path), "."+framecodec.Ext(framecodec.Raw)) {
+		img, err := framecodec.DecodeI420(data, frame.Width, frame.Height)
+		if err != nil {
+			return fmt.Errorf("%s: %w", frame.Path, err)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	frame.Data = data
+	return nil
+}
+
+// LoadCloud reads the saved cloud of p into its RawCloud, decompressing
+// it if it was saved compressed.
+func LoadCloud(dir string, p *models.LidarPacket) error {
+	if p.Path == "" {
+		return fmt.Errorf("cloud %d was not saved", p.Seq)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, p.Path))
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(p.Path), "."+cloudcodec.Ext(cloudcodec.Deflate)) {
+		if data, err = cloudcodec.Decode(data); err != nil {
+			return fmt.Errorf("%s: %w", p.Path, err)
+		}
+	}
+	p.RawCloud = data
+	return nil
+}
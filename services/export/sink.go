@@ -0,0 +1,123 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// SinkFormats are the sink formats a recorded session can be converted
+// into, see WriteSink.
+var SinkFormats = []string{utils.SinkMCAP, utils.SinkParquet}
+
+// sinkTables are the files a sink export reads, by table, with the binary
+// log kept instead of the CSV file of some sensors.
+var sinkTables = []struct{ table, csv, bin string }{
+	{"camera", views.CameraCSV, ""},
+	{"gps", views.GPSCSV, ""},
+	{"imu", views.IMUCSV, views.IMUBin},
+	{"lidar", views.LidarCSV, ""},
+	{"radar", views.RadarCSV, views.RadarBin},
+	{"env", views.EnvCSV, ""},
+	{"trigger", views.TriggerCSV, ""},
+	{"ins", views.INSCSV, ""},
+	{views.FusedTable, views.FusedCSV, ""},
+	{views.FusedIMUTable, views.FusedIMUCSV, ""},
+}
+
+// sinkRow is a row due to be written to a table of the sink.
+type sinkRow struct {
+	ts    time.Time
+	table string
+	row   []string
+}
+
+// WriteSink converts the session in dir into the files the sink of format,
+// one of SinkFormats, writes while recording, in the directory out: a
+// table per sensor with the columns of its file, in the units of the
+// session, and the fused records. Rows are written in timestamp order.
+// The fused records carry every sensor, so they are left out when f keeps
+// only some. It returns the number of rows written.
+func WriteSink(dir, out, format string, f Filter) (int, error) {
+	u, err := views.SessionUnits(dir, f.Units)
+	if err != nil {
+		return 0, err
+	}
+	var tables []views.SinkTable
+	var queue []sinkRow
+	for _, st := range sinkTables {
+		keep := f.Sensor(st.table)
+		if st.table == views.FusedTable || st.table == views.FusedIMUTable {
+			keep = len(f.Sensors) == 0
+		}
+		if !keep {
+			continue
+		}
+		t, err := readSinkTable(dir, st.csv, st.bin, u)
+		if err != nil {
+			return 0, err
+		}
+		if t == nil {
+			continue
+		}
+		tables = append(tables, views.SinkTable{Name: st.table, Columns: t.Header})
+		for i, row := range t.Rows {
+			ts, ok := t.Time(i)
+			if !ok || !f.Contains(ts) || t.String(i, views.ValidColumn) == "0" {
+				continue
+			}
+			queue = append(queue, sinkRow{ts, st.table, row})
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].ts.Before(queue[j].ts) })
+
+	var sink views.Sink
+	switch format {
+	case utils.SinkMCAP:
+		sink, err = views.NewMCAPSink(out, 0, tables)
+	case utils.SinkParquet:
+		sink, err = views.NewParquetSink(out, 0, tables, utils.DefaultRowGroupRows)
+	default:
+		return 0, fmt.Errorf("sink %s: not an export format", format)
+	}
+	if err != nil {
+		return 0, err
+	}
+	for n, r := range queue {
+		if err := sink.WriteSensor(r.table, r.ts, r.row); err != nil {
+			sink.Close()
+			return n, err
+		}
+	}
+	return len(queue), sink.Close()
+}
+
+// readSinkTable reads the binary log bin of a table, in the units of the
+// models, or else its CSV file, and returns it in the units u of the
+// session; nil when the table was not recorded.
+func readSinkTable(dir, csvName, binName string, u utils.UnitsConfig) (*views.Table, error) {
+	if binName != "" {
+		t, err := views.ReadRecordTable(filepath.Join(dir, binName))
+		if err == nil {
+			conv := views.NewUnitConverter(t.Header, u)
+			for _, row := range t.Rows {
+				conv.Convert(row)
+			}
+			return t, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	t, err := views.ReadTable(filepath.Join(dir, csvName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return t, err
+}
@@ -0,0 +1,193 @@
+// Package export converts recorded sessions into formats understood by
+// other tools.
+package export
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// Track output file names, by format.
+var TrackFiles = map[string]string{
+	"gpx":     "track.gpx",
+	"geojson": "track.geojson",
+}
+
+// ReadGPS reads the fixes of gps.csv in dir that f keeps. Rows without a
+// fix, marked invalid or whose position is encrypted are skipped.
+func ReadGPS(dir string, f Filter) ([]models.GPSData, error) {
+	t, err := views.ReadTable(filepath.Join(dir, views.GPSCSV))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.toModelUnits(dir, t); err != nil {
+		return nil, err
+	}
+	var fixes []models.GPSData
+	for i := range t.Rows {
+		ts, ok := t.Time(i)
+		if !ok || !f.Contains(ts) || t.String(i, views.ValidColumn) == "0" {
+			continue
+		}
+		g := models.GPSData{Timestamp: ts}
+		var okLat, okLon bool
+		g.Lat, okLat = t.Float(i, "lat")
+		g.Lon, okLon = t.Float(i, "lon")
+		g.Alt, _ = t.Float(i, "alt")
+		g.SpeedMps, _ = t.Float(i, "speed_mps")
+		g.HeadingDeg, _ = t.Float(i, "heading_deg")
+		g.HDOP, _ = t.Float(i, "hdop")
+		g.Satellites, _ = strconv.Atoi(t.String(i, "satellites"))
+		g.FixQuality, _ = strconv.Atoi(t.String(i, "fix_quality"))
+		g.HAccM, g.VAccM = optional(t, i, "h_acc_m"), optional(t, i, "v_acc_m")
+		if g.FixQuality == 0 || !okLat || !okLon {
+			continue
+		}
+		fixes = append(fixes, g)
+	}
+	return fixes, nil
+}
+
+// WriteTrack writes fixes as a track named name in format "gpx" or
+// "geojson".
+func WriteTrack(w io.Writer, format, name string, fixes []models.GPSData) error {
+	switch format {
+	case "gpx":
+		return writeGPX(w, name, fixes)
+	case "geojson":
+		return writeGeoJSON(w, name, fixes)
+	}
+	return fmt.Errorf("unknown track format %q (gpx or geojson)", format)
+}
+
+// GPX 1.1 with speed and course in the Garmin TrackPointExtension, which
+// most mapping tools read.
+type gpxDoc struct {
+	XMLName xml.Name `xml:"gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	NS      string   `xml:"xmlns,attr"`
+	NSTPX   string   `xml:"xmlns:gpxtpx,attr"`
+	Name    string   `xml:"trk>name"`
+	Points  []gpxPt  `xml:"trk>trkseg>trkpt"`
+}
+
+type gpxPt struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  float64 `xml:"ele"`
+	Time string  `xml:"time"`
+	Sat  int     `xml:"sat"`
+	HDOP float64 `xml:"hdop"`
+	Ext  gpxExt  `xml:"extensions>gpxtpx:TrackPointExtension"`
+}
+
+type gpxExt struct {
+	Speed  float64 `xml:"gpxtpx:speed"`
+	Course float64 `xml:"gpxtpx:course"`
+}
+
+func writeGPX(w io.Writer, name string, fixes []models.GPSData) error {
+	doc := gpxDoc{
+		Version: "1.1",
+		Creator: "Sensor-Logger",
+		NS:      "http://www.topografix.com/GPX/1/1",
+		NSTPX:   "http://www.garmin.com/xmlschemas/TrackPointExtension/v2",
+		Name:    name,
+		Points:  make([]gpxPt, len(fixes)),
+	}
+	for i, g := range fixes {
+		doc.Points[i] = gpxPt{
+			Lat: g.Lat, Lon: g.Lon, Ele: g.Alt,
+			Time: g.Timestamp.UTC().Format(time.RFC3339Nano),
+			Sat:  g.Satellites, HDOP: g.HDOP,
+			Ext: gpxExt{Speed: g.SpeedMps, Course: g.HeadingDeg},
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeGeoJSON writes one LineString feature. Per-point times, speeds and
+// headings are parallel arrays in the feature properties, as there is no
+// standard place for them in the geometry.
+func writeGeoJSON(w io.Writer, name string, fixes []models.GPSData) error {
+	coords := make([][3]float64, len(fixes))
+	times := make([]string, len(fixes))
+	speeds := make([]float64, len(fixes))
+	headings := make([]float64, len(fixes))
+	for i, g := range fixes {
+		coords[i] = [3]float64{g.Lon, g.Lat, g.Alt}
+		times[i] = g.Timestamp.UTC().Format(time.RFC3339Nano)
+		speeds[i], headings[i] = g.SpeedMps, g.HeadingDeg
+	}
+	props := map[string]any{
+		"name":        name,
+		"times":       times,
+		"speed_mps":   speeds,
+		"heading_deg": headings,
+	}
+	if len(fixes) > 0 {
+		props["start"], props["end"] = times[0], times[len(times)-1]
+	}
+	doc := map[string]any{
+		"type": "FeatureCollection",
+		"features": []any{map[string]any{
+			"type":       "Feature",
+			"geometry":   map[string]any{"type": "LineString", "coordinates": coords},
+			"properties": props,
+		}},
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// WriteTracks reads gps.csv in dir and writes one track file per format
+// into outDir, named after TrackFiles. It returns the paths written.
+func WriteTracks(dir, outDir string, formats []string, f Filter) ([]string, error) {
+	if !f.Sensor("gps") {
+		return nil, fmt.Errorf("tracks need gps, which the sensor filter leaves out")
+	}
+	fixes, err := ReadGPS(dir, f)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(filepath.Clean(dir))
+	var paths []string
+	for _, format := range formats {
+		file, ok := TrackFiles[format]
+		if !ok {
+			return paths, fmt.Errorf("unknown track format %q (gpx or geojson)", format)
+		}
+		path := filepath.Join(outDir, file)
+		out, err := os.Create(path)
+		if err != nil {
+			return paths, err
+		}
+		err = WriteTrack(out, format, name, fixes)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return paths, fmt.Errorf("%s: %w", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
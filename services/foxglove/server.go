@@ -0,0 +1,315 @@
+// Package foxglove implements the server side of the Foxglove WebSocket
+// protocol (foxglove.websocket.v1), so Foxglove Studio can connect and
+// show the channels a server advertises.
+package foxglove
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Subprotocol is the WebSocket subprotocol clients must offer.
+const Subprotocol = "foxglove.websocket.v1"
+
+// opMessageData starts a binary message carrying one message of a
+// subscribed channel.
+const opMessageData = 0x01
+
+// closeGoingAway is the close status sent when the server shuts down.
+const closeGoingAway = 1001
+
+// writeTimeout bounds the write of one frame to a client.
+const writeTimeout = 10 * time.Second
+
+// Status levels of a status message.
+const (
+	statusInfo    = 0
+	statusWarning = 1
+	statusError   = 2
+)
+
+// Channel is a topic advertised to clients. Schema describes the
+// messages, in JSON Schema for the json encoding.
+type Channel struct {
+	ID             uint32 `json:"id"`
+	Topic          string `json:"topic"`
+	Encoding       string `json:"encoding"`
+	SchemaName     string `json:"schemaName"`
+	Schema         string `json:"schema"`
+	SchemaEncoding string `json:"schemaEncoding,omitempty"`
+}
+
+// Server advertises channels to every client and forwards the messages
+// published on a channel to the clients subscribed to it. A client whose
+// queue of messages is full misses messages; Publish never blocks.
+type Server struct {
+	name  string
+	queue int
+	log   utils.Logger
+	start time.Time
+
+	mu       sync.Mutex
+	channels []Channel
+	latched  map[uint32]latched
+	clients  map[*client]struct{}
+	closed   bool
+	ready    chan struct{} // closed on the first subscription
+
+	writers sync.WaitGroup
+	dropped atomic.Int64
+}
+
+// latched is the last message of a channel sent to new subscribers.
+type latched struct {
+	ts      time.Time
+	payload []byte
+}
+
+type client struct {
+	conn net.Conn
+	out  chan []byte // frames to write
+	done chan struct{}
+	subs map[uint32]uint32 // channel ID to subscription ID; Server.mu guards it
+}
+
+// NewServer returns a server introducing itself as name, buffering up to
+// queue messages per client.
+func NewServer(name string, queue int, log utils.Logger) *Server {
+	return &Server{
+		name: name, queue: queue, log: log, start: time.Now(),
+		latched: map[uint32]latched{}, clients: map[*client]struct{}{}, ready: make(chan struct{}),
+	}
+}
+
+// Advertise adds a channel and returns it with its ID set.
+func (s *Server) Advertise(ch Channel) Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch.ID = uint32(len(s.channels) + 1)
+	s.channels = append(s.channels, ch)
+	msg := advertise([]Channel{ch})
+	for c := range s.clients {
+		s.send(c, msg)
+	}
+	return ch
+}
+
+// Subscribed reports whether any client is subscribed to channel, so
+// messages nobody receives need not be encoded.
+func (s *Server) Subscribed(channel uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		if _, ok := c.subs[channel]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends payload, a message of channel received at ts, to the
+// subscribed clients.
+func (s *Server) Publish(channel uint32, ts time.Time, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		if sub, ok := c.subs[channel]; ok {
+			s.send(c, messageData(sub, ts, payload))
+		}
+	}
+}
+
+// Latch publishes payload and keeps it for clients subscribing later, for
+// channels that change rarely.
+func (s *Server) Latch(channel uint32, ts time.Time, payload []byte) {
+	s.mu.Lock()
+	s.latched[channel] = latched{ts, payload}
+	s.mu.Unlock()
+	s.Publish(channel, ts, payload)
+}
+
+// Ready returns a channel closed once a client has subscribed to a
+// channel, to hold back a replay until someone watches it.
+func (s *Server) Ready() <-chan struct{} { return s.ready }
+
+// Dropped returns the number of messages clients missed.
+func (s *Server) Dropped() int64 { return s.dropped.Load() }
+
+// Close disconnects every client once the messages queued for it are
+// written.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	closing := appendFrame(nil, opClose, binary.BigEndian.AppendUint16(nil, closeGoingAway))
+	for c := range s.clients {
+		select {
+		case c.out <- closing:
+		default:
+			c.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	s.writers.Wait()
+	return nil
+}
+
+// ServeHTTP accepts a client connection and serves it until it closes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := upgrade(w, r, Subprotocol)
+	if err != nil {
+		s.log.Debugf("foxglove: %s: %v", r.RemoteAddr, err)
+		return
+	}
+	c := &client{conn: conn, out: make(chan []byte, s.queue), done: make(chan struct{}), subs: map[uint32]uint32{}}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	info, _ := json.Marshal(map[string]any{
+		"op": "serverInfo", "name": s.name, "capabilities": []string{}, "supportedEncodings": []string{},
+		"metadata": map[string]string{}, "sessionId": strconv.FormatInt(s.start.UnixNano(), 10),
+	})
+	c.out <- appendFrame(nil, opText, info)
+	if len(s.channels) > 0 {
+		c.out <- advertise(s.channels)
+	}
+	s.clients[c] = struct{}{}
+	s.writers.Add(1)
+	s.mu.Unlock()
+	s.log.Infof("foxglove: %s connected", r.RemoteAddr)
+
+	go func() {
+		defer s.writers.Done()
+		c.writeLoop()
+	}()
+	err = s.readLoop(c, rw.Reader)
+	s.mu.Lock()
+	delete(s.clients, c)
+	s.mu.Unlock()
+	close(c.done)
+	conn.Close()
+	if err != nil && err != io.EOF {
+		s.log.Debugf("foxglove: %s: %v", r.RemoteAddr, err)
+	}
+	s.log.Infof("foxglove: %s disconnected", r.RemoteAddr)
+}
+
+// send queues a frame for c, dropping it if the queue is full; s.mu must
+// be held.
+func (s *Server) send(c *client, frame []byte) {
+	select {
+	case c.out <- frame:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (c *client) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case frame := <-c.out:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := c.conn.Write(frame); err != nil || frame[0]&0x0F == opClose {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// readLoop handles the requests of c until it disconnects.
+func (s *Server) readLoop(c *client, r *bufio.Reader) error {
+	pong := func(p []byte) error {
+		s.mu.Lock()
+		s.send(c, appendFrame(nil, opPong, p))
+		s.mu.Unlock()
+		return nil
+	}
+	for {
+		op, msg, err := readMessage(r, pong)
+		if err != nil {
+			return err
+		}
+		if op != opText {
+			continue
+		}
+		var req struct {
+			Op            string `json:"op"`
+			Subscriptions []struct {
+				ID        uint32 `json:"id"`
+				ChannelID uint32 `json:"channelId"`
+			} `json:"subscriptions"`
+			SubscriptionIDs []uint32 `json:"subscriptionIds"`
+		}
+		if err := json.Unmarshal(msg, &req); err != nil {
+			s.status(c, statusError, "invalid request: "+err.Error())
+			continue
+		}
+		s.mu.Lock()
+		switch req.Op {
+		case "subscribe":
+			for _, sub := range req.Subscriptions {
+				if sub.ChannelID == 0 || int(sub.ChannelID) > len(s.channels) {
+					s.mu.Unlock()
+					s.status(c, statusWarning, "unknown channel "+strconv.Itoa(int(sub.ChannelID)))
+					s.mu.Lock()
+					continue
+				}
+				c.subs[sub.ChannelID] = sub.ID
+				select {
+				case <-s.ready:
+				default:
+					close(s.ready)
+				}
+				if l, ok := s.latched[sub.ChannelID]; ok {
+					s.send(c, messageData(sub.ID, l.ts, l.payload))
+				}
+			}
+		case "unsubscribe":
+			for _, id := range req.SubscriptionIDs {
+				for ch, sub := range c.subs {
+					if sub == id {
+						delete(c.subs, ch)
+					}
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// status sends a status message to c.
+func (s *Server) status(c *client, level int, message string) {
+	msg, _ := json.Marshal(map[string]any{"op": "status", "level": level, "message": message})
+	s.mu.Lock()
+	s.send(c, appendFrame(nil, opText, msg))
+	s.mu.Unlock()
+}
+
+func advertise(channels []Channel) []byte {
+	msg, _ := json.Marshal(map[string]any{"op": "advertise", "channels": channels})
+	return appendFrame(nil, opText, msg)
+}
+
+// messageData frames a message for subscription sub.
+func messageData(sub uint32, ts time.Time, payload []byte) []byte {
+	b := make([]byte, 13, 13+len(payload))
+	b[0] = opMessageData
+	binary.LittleEndian.PutUint32(b[1:], sub)
+	binary.LittleEndian.PutUint64(b[5:], uint64(ts.UnixNano()))
+	return appendFrame(nil, opBinary, append(b, payload...))
+}
@@ -0,0 +1,225 @@
+package framecodec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// EXIF is the metadata WithEXIF embeds in a frame: when it was captured
+// and, when a fix was at hand, where.
+type EXIF struct {
+	Time time.Time
+	GPS  *EXIFGPS
+}
+
+// EXIFGPS is the position of a frame, from the GPS fix taken at Time.
+// HeadingDeg is the course of the vehicle, clockwise from true north.
+type EXIFGPS struct {
+	Time       time.Time
+	Lat, Lon   float64
+	Alt        float64
+	SpeedMps   float64
+	HeadingDeg float64
+}
+
+// TIFF field types.
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+)
+
+// tiffField is one entry of an IFD, its value encoded big-endian.
+type tiffField struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiField(tag uint16, s string) tiffField {
+	b := append([]byte(s), 0)
+	return tiffField{tag, tiffASCII, uint32(len(b)), b}
+}
+
+func byteField(tag uint16, b ...byte) tiffField {
+	return tiffField{tag, tiffByte, uint32(len(b)), b}
+}
+
+func longField(tag uint16, v uint32) tiffField {
+	return tiffField{tag, tiffLong, 1, binary.BigEndian.AppendUint32(nil, v)}
+}
+
+// rationalField holds vs as fractions over denom.
+func rationalField(tag uint16, denom uint32, vs ...float64) tiffField {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, uint32(math.Round(math.Abs(v)*float64(denom))))
+		b = binary.BigEndian.AppendUint32(b, denom)
+	}
+	return tiffField{tag, tiffRational, uint32(len(vs)), b}
+}
+
+// ifdSize is the size of an IFD of fields with the values that do not fit
+// in their entry.
+func ifdSize(fields []tiffField) uint32 {
+	n := uint32(2 + 12*len(fields) + 4)
+	for _, f := range fields {
+		if len(f.value) > 4 {
+			n += uint32(len(f.value) + len(f.value)%2)
+		}
+	}
+	return n
+}
+
+// appendIFD appends the IFD of fields, placed at off in the TIFF data,
+// with no next IFD.
+func appendIFD(b []byte, off uint32, fields []tiffField) []byte {
+	slices.SortFunc(fields, func(a, b tiffField) int { return int(a.tag) - int(b.tag) })
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+	data := off + uint32(2+12*len(fields)+4)
+	var values []byte
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint16(b, f.tag)
+		b = binary.BigEndian.AppendUint16(b, f.typ)
+		b = binary.BigEndian.AppendUint32(b, f.count)
+		if len(f.value) <= 4 {
+			var v [4]byte
+			copy(v[:], f.value)
+			b = append(b, v[:]...)
+			continue
+		}
+		b = binary.BigEndian.AppendUint32(b, data+uint32(len(values)))
+		values = append(values, f.value...)
+		if len(f.value)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, 0)
+	return append(b, values...)
+}
+
+// dms splits deg into degrees, minutes and seconds.
+func dms(deg float64) []float64 {
+	deg = math.Abs(deg)
+	d := math.Floor(deg)
+	m := math.Floor((deg - d) * 60)
+	return []float64{d, m, (deg-d)*3600 - m*60}
+}
+
+// tiff encodes e as the TIFF structure of an EXIF segment: IFD0, then the
+// Exif IFD and the GPS IFD it points to.
+func (e EXIF) tiff() []byte {
+	t := e.Time.UTC()
+	ifd0 := []tiffField{
+		asciiField(0x0131, "sensor-logger"),
+		asciiField(0x0132, t.Format("2006:01:02 15:04:05")),
+		longField(0x8769, 0),
+	}
+	exif := []tiffField{
+		{0x9000, tiffUndefined, 4, []byte("0232")},
+		asciiField(0x9003, t.Format("2006:01:02 15:04:05")),
+		asciiField(0x9011, "+00:00"),
+		asciiField(0x9291, fmt.Sprintf("%03d", t.Nanosecond()/1e6)),
+	}
+	var gps []tiffField
+	if g := e.GPS; g != nil {
+		ifd0 = append(ifd0, longField(0x8825, 0))
+		gt := g.Time.UTC()
+		latRef, lonRef, altRef := "N", "E", byte(0)
+		if g.Lat < 0 {
+			latRef = "S"
+		}
+		if g.Lon < 0 {
+			lonRef = "W"
+		}
+		if g.Alt < 0 {
+			altRef = 1
+		}
+		secs := float64(gt.Hour()*3600+gt.Minute()*60+gt.Second()) + float64(gt.Nanosecond())/1e9
+		gps = []tiffField{
+			byteField(0x0000, 2, 3, 0, 0),
+			asciiField(0x0001, latRef),
+			rationalField(0x0002, 1e6, dms(g.Lat)...),
+			asciiField(0x0003, lonRef),
+			rationalField(0x0004, 1e6, dms(g.Lon)...),
+			byteField(0x0005, altRef),
+			rationalField(0x0006, 100, g.Alt),
+			rationalField(0x0007, 1000, math.Floor(secs/3600), math.Floor(math.Mod(secs, 3600)/60), math.Mod(secs, 60)),
+			asciiField(0x000C, "K"),
+			rationalField(0x000D, 100, g.SpeedMps*3.6),
+			asciiField(0x000E, "T"),
+			rationalField(0x000F, 100, g.HeadingDeg),
+			asciiField(0x0010, "T"),
+			rationalField(0x0011, 100, g.HeadingDeg),
+			asciiField(0x001D, gt.Format("2006:01:02")),
+		}
+	}
+
+	exifOff := 8 + ifdSize(ifd0)
+	gpsOff := exifOff + ifdSize(exif)
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case 0x8769:
+			ifd0[i] = longField(0x8769, exifOff)
+		case 0x8825:
+			ifd0[i] = longField(0x8825, gpsOff)
+		}
+	}
+	b := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	b = appendIFD(b, 8, ifd0)
+	b = appendIFD(b, exifOff, exif)
+	if gps != nil {
+		b = appendIFD(b, gpsOff, gps)
+	}
+	return b
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// WithEXIF returns the JPEG jpg with e in an EXIF segment, right after
+// its JFIF segment if it has one. An EXIF segment jpg already has, from
+// the camera, is replaced.
+func WithEXIF(jpg []byte, e EXIF) ([]byte, error) {
+	if len(jpg) < 4 || jpg[0] != 0xFF || jpg[1] != 0xD8 {
+		return nil, errors.New("exif: not a JPEG")
+	}
+	tiff := e.tiff()
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+len(exifHeader)+len(tiff)))
+	app1 = append(append(app1, exifHeader...), tiff...)
+
+	out := make([]byte, 0, len(jpg)+len(app1))
+	out = append(out, jpg[:2]...)
+	inserted := false
+	i := 2
+	// The application segments come first; the frame data after them is
+	// copied as is.
+	for i+4 <= len(jpg) && jpg[i] == 0xFF && jpg[i+1] >= 0xE0 && jpg[i+1] <= 0xEF {
+		end := i + 2 + int(binary.BigEndian.Uint16(jpg[i+2:]))
+		if end > len(jpg) {
+			return nil, errors.New("exif: truncated JPEG segment")
+		}
+		seg := jpg[i:end]
+		i = end
+		if seg[1] == 0xE1 && bytes.HasPrefix(seg[4:], exifHeader) {
+			continue
+		}
+		if seg[1] != 0xE0 && !inserted {
+			out = append(out, app1...)
+			inserted = true
+		}
+		out = append(out, seg...)
+	}
+	if !inserted {
+		out = append(out, app1...)
+	}
+	return append(out, jpg[i:]...), nil
+}
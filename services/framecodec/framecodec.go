@@ -0,0 +1,125 @@
+// Package framecodec transcodes the JPEG frames delivered by the cameras
+// into the formats frames can be saved in.
+package framecodec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+)
+
+// Frame formats, see utils.StorageConfig.FrameFormat.
+const (
+	// JPEG stores frames as the camera delivered them.
+	JPEG = "jpeg"
+	// WebP re-encodes frames as lossless WebP.
+	WebP = "webp"
+	// PNG re-encodes frames as PNG.
+	PNG = "png"
+	// Raw stores the decoded frame as planar YUV 4:2:0 (I420).
+	Raw = "raw"
+)
+
+// Ext returns the file extension of frames saved in format.
+func Ext(format string) string {
+	switch format {
+	case WebP:
+		return "webp"
+	case PNG:
+		return "png"
+	case Raw:
+		return "yuv"
+	}
+	return "jpg"
+}
+
+// Encode transcodes the JPEG frame jpg into format.
+func Encode(format string, jpg []byte) ([]byte, error) {
+	if format == JPEG {
+		return jpg, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(jpg))
+	if err != nil {
+		return nil, fmt.Errorf("decode frame: %w", err)
+	}
+	return encodeImage(format, img)
+}
+
+// encodeImage encodes img in format, one of the lossless formats.
+func encodeImage(format string, img image.Image) ([]byte, error) {
+	switch format {
+	case WebP:
+		return encodeWebP(img)
+	case PNG:
+		var buf bytes.Buffer
+		enc := png.Encoder{CompressionLevel: png.BestSpeed}
+		if err := enc.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Raw:
+		return encodeI420(img), nil
+	}
+	return nil, fmt.Errorf("unknown frame format %q", format)
+}
+
+// Sniff returns the format of an encoded frame from its signature, ""
+// for raw frames and anything else it does not recognise.
+func Sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return JPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return PNG
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return WebP
+	}
+	return ""
+}
+
+// Reencode returns the JPEG frame jpg encoded again at quality (1-100).
+func Reencode(jpg []byte, quality int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(jpg))
+	if err != nil {
+		return nil, fmt.Errorf("decode frame: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// argb returns the pixels of img, row by row, as 0xAARRGGBB.
+func argb(img image.Image) []uint32 {
+	b := img.Bounds()
+	px := make([]uint32, 0, b.Dx()*b.Dy())
+	switch m := img.(type) {
+	case *image.YCbCr:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := m.COffset(x, y)
+				r, g, bl := color.YCbCrToRGB(m.Y[m.YOffset(x, y)], m.Cb[c], m.Cr[c])
+				px = append(px, 0xff000000|uint32(r)<<16|uint32(g)<<8|uint32(bl))
+			}
+		}
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for _, v := range m.Pix[m.PixOffset(b.Min.X, y):m.PixOffset(b.Max.X, y)] {
+				g := uint32(v)
+				px = append(px, 0xff000000|g<<16|g<<8|g)
+			}
+		}
+	default:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, _ := img.At(x, y).RGBA()
+				px = append(px, 0xff000000|(r>>8)<<16|(g>>8)<<8|bl>>8)
+			}
+		}
+	}
+	return px
+}
@@ -0,0 +1,81 @@
+package framecodec
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// encodeI420 returns the planes of img as I420: the full-resolution Y
+// plane, then the Cb and Cr planes subsampled 2x2, each row by row. Odd
+// widths and heights round the chroma planes up. JPEGs are usually 4:2:0
+// already, so their planes are copied; other subsamplings are averaged
+// down, and grayscale frames get neutral chroma.
+func encodeI420(img image.Image) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cw, ch := (w+1)/2, (h+1)/2
+	out := make([]byte, w*h+2*cw*ch)
+	yp, cb, cr := out[:w*h], out[w*h:w*h+cw*ch], out[w*h+cw*ch:]
+	switch m := img.(type) {
+	case *image.YCbCr:
+		for y := range h {
+			copy(yp[y*w:(y+1)*w], m.Y[m.YOffset(b.Min.X, b.Min.Y+y):])
+		}
+		for cy := range ch {
+			for cx := range cw {
+				var sb, sr, n int
+				for _, p := range [4]image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+					x, y := 2*cx+p.X, 2*cy+p.Y
+					if x >= w || y >= h {
+						continue
+					}
+					c := m.COffset(b.Min.X+x, b.Min.Y+y)
+					sb += int(m.Cb[c])
+					sr += int(m.Cr[c])
+					n++
+				}
+				cb[cy*cw+cx] = uint8((sb + n/2) / n)
+				cr[cy*cw+cx] = uint8((sr + n/2) / n)
+			}
+		}
+	case *image.Gray:
+		for y := range h {
+			copy(yp[y*w:(y+1)*w], m.Pix[m.PixOffset(b.Min.X, b.Min.Y+y):])
+		}
+		for i := range cb {
+			cb[i], cr[i] = 128, 128
+		}
+	default:
+		sb, sr, n := make([]int, cw*ch), make([]int, cw*ch), make([]int, cw*ch)
+		for y := range h {
+			for x := range w {
+				c := color.YCbCrModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.YCbCr)
+				yp[y*w+x] = c.Y
+				i := (y/2)*cw + x/2
+				sb[i] += int(c.Cb)
+				sr[i] += int(c.Cr)
+				n[i]++
+			}
+		}
+		for i := range cb {
+			cb[i] = uint8((sb[i] + n[i]/2) / n[i])
+			cr[i] = uint8((sr[i] + n[i]/2) / n[i])
+		}
+	}
+	return out
+}
+
+// DecodeI420 is the inverse of encodeI420 for a w×h frame.
+func DecodeI420(b []byte, w, h int) (*image.YCbCr, error) {
+	cw, ch := (w+1)/2, (h+1)/2
+	if w <= 0 || h <= 0 || len(b) != w*h+2*cw*ch {
+		return nil, fmt.Errorf("%d bytes is not a %dx%d I420 frame", len(b), w, h)
+	}
+	return &image.YCbCr{
+		Y: b[:w*h], Cb: b[w*h : w*h+cw*ch], Cr: b[w*h+cw*ch:],
+		YStride: w, CStride: cw,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect:           image.Rect(0, 0, w, h),
+	}, nil
+}
@@ -0,0 +1,119 @@
+package framecodec
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+)
+
+// Processing crops and scales frames before they are saved, see
+// utils.FrameProcessingConfig.
+type Processing struct {
+	// ROI is the region kept, in pixels of the captured frame; an empty
+	// one keeps the whole frame.
+	ROI image.Rectangle
+	// MaxWidth scales wider frames down to it, keeping the aspect ratio;
+	// 0 never scales.
+	MaxWidth int
+	// Quality is the JPEG quality (1-100) processed frames are saved at in
+	// format jpeg.
+	Quality int
+}
+
+// Process crops and scales the JPEG frame jpg as p asks and encodes it in
+// format.
+func Process(jpg []byte, p Processing, format string) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(jpg))
+	if err != nil {
+		return nil, fmt.Errorf("decode frame: %w", err)
+	}
+	if !p.ROI.Empty() {
+		r := p.ROI.Add(img.Bounds().Min).Intersect(img.Bounds())
+		if r.Empty() {
+			return nil, fmt.Errorf("roi %v outside the %v frame", p.ROI, img.Bounds().Size())
+		}
+		img = crop(img, r)
+	}
+	if p.MaxWidth > 0 && img.Bounds().Dx() > p.MaxWidth {
+		img = scale(img, p.MaxWidth)
+	}
+	if format == JPEG {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: p.Quality}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return encodeImage(format, img)
+}
+
+func crop(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	out := image.NewRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			out.Set(x, y, img.At(x, y))
+		}
+	}
+	return out
+}
+
+// scale returns img scaled down to width w, keeping its aspect ratio, as
+// 4:2:0 YCbCr. Every pixel is the mean of the source pixels it covers.
+func scale(img image.Image, w int) *image.YCbCr {
+	b := img.Bounds()
+	h := max(1, int(math.Round(float64(b.Dy())*float64(w)/float64(b.Dx()))))
+	out := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	luma, cb, cr := planes(img)
+	cw, ch := (w+1)/2, (h+1)/2
+	box(out.Y, out.YStride, w, h, b, luma)
+	box(out.Cb, out.CStride, cw, ch, b, cb)
+	box(out.Cr, out.CStride, cw, ch, b, cr)
+	return out
+}
+
+// planes returns the Y, Cb and Cr samples of img at each pixel.
+func planes(img image.Image) (luma, cb, cr func(x, y int) uint8) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return func(x, y int) uint8 { return m.Y[m.YOffset(x, y)] },
+			func(x, y int) uint8 { return m.Cb[m.COffset(x, y)] },
+			func(x, y int) uint8 { return m.Cr[m.COffset(x, y)] }
+	case *image.Gray:
+		neutral := func(int, int) uint8 { return 128 }
+		return func(x, y int) uint8 { return m.Pix[m.PixOffset(x, y)] }, neutral, neutral
+	}
+	at := func(x, y int) color.YCbCr { return color.YCbCrModel.Convert(img.At(x, y)).(color.YCbCr) }
+	return func(x, y int) uint8 { return at(x, y).Y },
+		func(x, y int) uint8 { return at(x, y).Cb },
+		func(x, y int) uint8 { return at(x, y).Cr }
+}
+
+// box fills the dw×dh plane dst with the means of the samples of src
+// falling in each of its pixels.
+func box(dst []uint8, stride, dw, dh int, src image.Rectangle, at func(x, y int) uint8) {
+	sw, sh := src.Dx(), src.Dy()
+	for y := range dh {
+		y0 := src.Min.Y + y*sh/dh
+		y1 := max(src.Min.Y+(y+1)*sh/dh, y0+1)
+		for x := range dw {
+			x0 := src.Min.X + x*sw/dw
+			x1 := max(src.Min.X+(x+1)*sw/dw, x0+1)
+			sum, n := 0, 0
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sum += int(at(sx, sy))
+					n++
+				}
+			}
+			dst[y*stride+x] = uint8((sum + n/2) / n)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package framecodec
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"math/bits"
+	"slices"
+)
+
+// Lossless WebP (VP8L) encoding. Frames are coded with the subtract-green
+// and predictor transforms and one set of prefix codes for the whole
+// image; backward references and the color cache are not used, which
+// keeps encoding fast at the cost of some size.
+
+const (
+	vp8lSignature = 0x2f
+	vp8lMaxSize   = 1 << 14
+
+	// predictorBits sets the tile size, 32x32, of the predictor transform.
+	predictorBits = 5
+
+	// Alphabet sizes of the five prefix codes of an image without color
+	// cache: green with the 24 length prefixes, red, blue, alpha and
+	// distance.
+	greenAlphabet    = 256 + 24
+	literalAlphabet  = 256
+	distanceAlphabet = 40
+
+	maxCodeLength       = 15
+	maxCodeLengthLength = 7
+)
+
+// Predictor modes tried for every tile: left, top and their average.
+var predictorModes = []uint32{1, 2, 7}
+
+// codeLengthOrder is the order in which the code length code lengths are
+// stored.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+func encodeWebP(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 1 || h < 1 || w > vp8lMaxSize || h > vp8lMaxSize {
+		return nil, errors.New("webp: frame size out of range")
+	}
+	px := argb(img)
+	subtractGreen(px)
+	modes, tw := choosePredictors(px, w, h)
+	residuals := predict(px, w, h, modes, tw)
+
+	var bw bitWriter
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	bw.write(0, 1) // alpha is not used
+	bw.write(0, 3) // version
+	bw.write(1, 1) // transform: subtract green
+	bw.write(2, 2)
+	bw.write(1, 1) // transform: predictor
+	bw.write(0, 2)
+	bw.write(predictorBits-2, 3)
+	writeImage(&bw, modes, false)
+	bw.write(0, 1) // no more transforms
+	writeImage(&bw, residuals, true)
+	data := bw.bytes()
+
+	out := make([]byte, 0, 20+len(data)+1)
+	out = append(out, "RIFF"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+8+len(data)+len(data)%2))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out, nil
+}
+
+// subtractGreen subtracts the green channel from red and blue.
+func subtractGreen(px []uint32) {
+	for i, p := range px {
+		g := p >> 8 & 0xff
+		r := (p>>16 - g) & 0xff
+		bl := (p - g) & 0xff
+		px[i] = p&0xff00ff00 | r<<16 | bl
+	}
+}
+
+// choosePredictors picks for every tile the mode with the smallest
+// residuals. It returns the predictor image, the mode in the green
+// channel, and its width in tiles.
+func choosePredictors(px []uint32, w, h int) ([]uint32, int) {
+	size := 1 << predictorBits
+	tw, th := (w+size-1)/size, (h+size-1)/size
+	modes := make([]uint32, tw*th)
+	for ty := range th {
+		for tx := range tw {
+			best, bestCost := predictorModes[0], -1
+			for _, mode := range predictorModes {
+				cost := 0
+				for y := max(ty*size, 1); y < min((ty+1)*size, h); y++ {
+					for x := max(tx*size, 1); x < min((tx+1)*size, w); x++ {
+						cost += residualCost(sub(px[y*w+x], predictor(px, w, x, y, mode)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[ty*tw+tx] = 0xff000000 | best<<8
+		}
+	}
+	return modes, tw
+}
+
+// predict returns the residuals of px under the predictor modes, with
+// the fixed predictions of the first row and column.
+func predict(px []uint32, w, h int, modes []uint32, tw int) []uint32 {
+	out := make([]uint32, len(px))
+	for y := range h {
+		for x := range w {
+			var pred uint32
+			switch {
+			case x == 0 && y == 0:
+				pred = 0xff000000
+			case y == 0:
+				pred = px[x-1]
+			case x == 0:
+				pred = px[(y-1)*w]
+			default:
+				pred = predictor(px, w, x, y, modes[(y>>predictorBits)*tw+x>>predictorBits]>>8&0xff)
+			}
+			out[y*w+x] = sub(px[y*w+x], pred)
+		}
+	}
+	return out
+}
+
+// predictor returns the prediction of the pixel at x, y, neither in the
+// first row nor column, in one of predictorModes.
+func predictor(px []uint32, w, x, y int, mode uint32) uint32 {
+	l, t := px[y*w+x-1], px[(y-1)*w+x]
+	switch mode {
+	case 1:
+		return l
+	case 2:
+		return t
+	}
+	return average2(l, t)
+}
+
+// sub subtracts b from a channel by channel, modulo 256.
+func sub(a, b uint32) uint32 {
+	ag := (a | 0x00ff00ff) - (b & 0xff00ff00)
+	rb := (a | 0xff00ff00) - (b & 0x00ff00ff)
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xfefefefe)>>1 + a&b
+}
+
+// residualCost estimates the bits a residual costs by its distance from
+// zero in each channel.
+func residualCost(r uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		c := int(int8(r >> shift))
+		if c < 0 {
+			c = -c
+		}
+		cost += c
+	}
+	return cost
+}
+
+// writeImage writes px as an entropy-coded image: the main image when
+// main is set, otherwise a transform's subimage.
+func writeImage(bw *bitWriter, px []uint32, main bool) {
+	bw.write(0, 1) // no color cache
+	if main {
+		bw.write(0, 1) // one set of prefix codes for the whole image
+	}
+	green := make([]int, greenAlphabet)
+	red := make([]int, literalAlphabet)
+	blue := make([]int, literalAlphabet)
+	alpha := make([]int, literalAlphabet)
+	for _, p := range px {
+		green[p>>8&0xff]++
+		red[p>>16&0xff]++
+		blue[p&0xff]++
+		alpha[p>>24]++
+	}
+	codes := [5]*prefixCode{
+		newPrefixCode(green, maxCodeLength),
+		newPrefixCode(red, maxCodeLength),
+		newPrefixCode(blue, maxCodeLength),
+		newPrefixCode(alpha, maxCodeLength),
+		newPrefixCode(make([]int, distanceAlphabet), maxCodeLength),
+	}
+	for _, c := range codes {
+		c.store(bw)
+	}
+	for _, p := range px {
+		codes[0].writeSymbol(bw, int(p>>8&0xff))
+		codes[1].writeSymbol(bw, int(p>>16&0xff))
+		codes[2].writeSymbol(bw, int(p&0xff))
+		codes[3].writeSymbol(bw, int(p>>24))
+	}
+}
+
+// prefixCode is a canonical Huffman code over an alphabet.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16 // bit-reversed, as they are written
+	used    []int    // the symbols with a code, ascending
+}
+
+// newPrefixCode builds the code for the symbol counts, with codes no
+// longer than maxLen. Unused alphabets get a code for symbol 0.
+func newPrefixCode(counts []int, maxLen int) *prefixCode {
+	c := &prefixCode{lengths: huffmanLengths(counts, maxLen), codes: make([]uint16, len(counts))}
+	for s, l := range c.lengths {
+		if l > 0 {
+			c.used = append(c.used, s)
+		}
+	}
+	if len(c.used) == 0 {
+		c.lengths[0], c.used = 1, []int{0}
+	}
+	var count, next [maxCodeLength + 2]uint16
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	code := uint16(0)
+	for l := 1; l <= maxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range c.lengths {
+		if l > 0 {
+			c.codes[s] = bits.Reverse16(next[l]) >> (16 - l)
+			next[l]++
+		}
+	}
+	return c
+}
+
+// writeSymbol writes the code of s. A code with a single symbol takes no
+// bits.
+func (c *prefixCode) writeSymbol(bw *bitWriter, s int) {
+	if len(c.used) > 1 {
+		bw.write(uint32(c.codes[s]), uint(c.lengths[s]))
+	}
+}
+
+// store writes the code lengths, as a simple code when there are at most
+// two symbols below 256 and as a normal one otherwise.
+func (c *prefixCode) store(bw *bitWriter) {
+	if len(c.used) <= 2 && c.used[len(c.used)-1] < 256 {
+		bw.write(1, 1)
+		bw.write(uint32(len(c.used)-1), 1)
+		if s := c.used[0]; s < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(s), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(s), 8)
+		}
+		if len(c.used) == 2 {
+			bw.write(uint32(c.used[1]), 8)
+		}
+		return
+	}
+	bw.write(0, 1)
+	counts := make([]int, len(codeLengthOrder))
+	for _, l := range c.lengths {
+		counts[l]++
+	}
+	lengthCode := newPrefixCode(counts, maxCodeLengthLength)
+	n := 4
+	for i, s := range codeLengthOrder {
+		if lengthCode.lengths[s] > 0 {
+			n = max(n, i+1)
+		}
+	}
+	bw.write(uint32(n-4), 4)
+	for _, s := range codeLengthOrder[:n] {
+		bw.write(uint32(lengthCode.lengths[s]), 3)
+	}
+	bw.write(0, 1) // code lengths for the whole alphabet follow
+	for _, l := range c.lengths {
+		lengthCode.writeSymbol(bw, int(l))
+	}
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for counts,
+// 0 for unused symbols. A code longer than maxLen is avoided by raising
+// the smallest counts until the tree is flat enough. A single used symbol
+// gets length 1.
+func huffmanLengths(counts []int, maxLen int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	var used []int
+	for s, n := range counts {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+	switch len(used) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[used[0]] = 1
+		return lengths
+	}
+	for floor := 1; ; floor *= 2 {
+		if huffmanTree(counts, used, floor, lengths) <= maxLen {
+			return lengths
+		}
+	}
+}
+
+// huffmanTree sets the depths of the used symbols in a Huffman tree for
+// their counts, raised to at least floor, and returns the largest depth.
+func huffmanTree(counts, used []int, floor int, lengths []uint8) int {
+	type node struct {
+		weight int
+		parent int
+	}
+	leaves := slices.Clone(used)
+	slices.SortStableFunc(leaves, func(a, b int) int { return max(counts[a], floor) - max(counts[b], floor) })
+	nodes := make([]node, 0, 2*len(leaves))
+	for _, s := range leaves {
+		nodes = append(nodes, node{weight: max(counts[s], floor), parent: -1})
+	}
+	// Leaves and merged nodes are each taken in increasing weight, the
+	// merged ones from the end of nodes.
+	nextLeaf, nextMerged := 0, len(leaves)
+	take := func() int {
+		if nextLeaf < len(leaves) && (nextMerged >= len(nodes) || nodes[nextLeaf].weight <= nodes[nextMerged].weight) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextMerged++
+		return nextMerged - 1
+	}
+	for range len(leaves) - 1 {
+		a, b := take(), take()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+	}
+	depth := make([]int, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+	}
+	deepest := 0
+	for i, s := range leaves {
+		lengths[s] = uint8(min(depth[i], 255))
+		deepest = max(deepest, depth[i])
+	}
+	return deepest
+}
+
+// bitWriter packs bits least significant first, as VP8L reads them.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+// bytes returns the bits written, the last byte padded with zeros.
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
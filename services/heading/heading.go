@@ -0,0 +1,139 @@
+// Package heading estimates the vehicle's true heading from the IMU
+// magnetometer and gyro, for when the GPS course is unreliable at low
+// speed.
+package heading
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// maxStep is the longest gap between IMU samples the gyro is integrated
+// over; after a longer one the estimate restarts from the magnetometer.
+const maxStep = time.Second
+
+// Estimator runs a complementary filter: every IMU sample the heading is
+// propagated with the gyro yaw rate and pulled towards the tilt-compensated
+// magnetic heading by 1-alpha. The declination comes from the config or,
+// failing that, from the latest GPS fix. It is safe for concurrent use.
+type Estimator struct {
+	alpha     float64
+	mount     utils.Mat4 // IMU to vehicle rotation
+	fixedDecl bool
+
+	mu       sync.Mutex
+	decl     float64
+	haveDecl bool
+	mag      float64 // magnetic heading, radians clockwise from magnetic north
+	haveMag  bool
+	last     time.Time
+}
+
+func NewEstimator(cfg utils.HeadingConfig, cal utils.CalibrationConfig) *Estimator {
+	e := &Estimator{alpha: cfg.Alpha, mount: cal.SensorToVehicle("imu")}
+	e.mount[0][3], e.mount[1][3], e.mount[2][3] = 0, 0, 0
+	if cfg.DeclinationDeg != nil {
+		e.decl, e.haveDecl, e.fixedDecl = *cfg.DeclinationDeg*math.Pi/180, true, true
+	}
+	return e
+}
+
+// ObserveGPS updates the declination from a fix unless it is configured.
+func (e *Estimator) ObserveGPS(g models.GPSData) {
+	if e.fixedDecl || g.FixQuality == 0 {
+		return
+	}
+	d := Declination(g.Lat, g.Lon)
+	e.mu.Lock()
+	e.decl, e.haveDecl = d, true
+	e.mu.Unlock()
+}
+
+// ObserveIMU advances the filter. Samples without a magnetometer reading
+// are ignored.
+func (e *Estimator) ObserveIMU(d models.IMUData) {
+	ax, ay, az := e.mount.Apply(d.AccelX, d.AccelY, d.AccelZ)
+	mx, my, mz := e.mount.Apply(d.MagX, d.MagY, d.MagZ)
+	gx, gy, gz := e.mount.Apply(d.GyroX, d.GyroY, d.GyroZ)
+	up, ok := unit(vec{ax, ay, az}) // specific force at rest points up
+	if !ok {
+		return
+	}
+	m := vec{mx, my, mz}
+	north, ok := unit(m.sub(up.scale(m.dot(up))))
+	if !ok {
+		return
+	}
+	east := north.cross(up)
+	fwd := vec{1, 0, 0}
+	measured := math.Atan2(fwd.dot(east), fwd.dot(north))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	dt := d.Timestamp.Sub(e.last)
+	e.last = d.Timestamp
+	if !e.haveMag || dt <= 0 || dt > maxStep {
+		e.mag, e.haveMag = measured, true
+		return
+	}
+	// Yaw rate is counter-clockwise about up; heading runs clockwise.
+	predicted := e.mag - vec{gx, gy, gz}.dot(up)*dt.Seconds()
+	e.mag = wrap(predicted + (1-e.alpha)*wrap(measured-predicted))
+}
+
+// Heading returns the true heading in degrees clockwise from north, in
+// [0, 360), once a magnetometer sample and the declination are known.
+func (e *Estimator) Heading() (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.haveMag || !e.haveDecl {
+		return 0, false
+	}
+	deg := wrap(e.mag+e.decl) * 180 / math.Pi
+	if deg < 0 {
+		deg += 360
+	}
+	return deg, true
+}
+
+// Declination returns the magnetic declination in radians (positive east)
+// at lat/lon in degrees from the dipole terms of the World Magnetic Model
+// 2025. It is good to a few degrees at mid latitudes; configure
+// declination_deg where that is not enough.
+func Declination(lat, lon float64) float64 {
+	const g10, g11, h11 = -29351.8, -1410.8, 4545.4 // nT
+	theta := (90 - lat) * math.Pi / 180
+	phi := lon * math.Pi / 180
+	x := -g10*math.Sin(theta) + (g11*math.Cos(phi)+h11*math.Sin(phi))*math.Cos(theta)
+	y := g11*math.Sin(phi) - h11*math.Cos(phi)
+	return math.Atan2(y, x)
+}
+
+// wrap maps an angle in radians to (-π, π].
+func wrap(a float64) float64 {
+	return math.Remainder(a, 2*math.Pi)
+}
+
+type vec [3]float64
+
+func (a vec) dot(b vec) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+func (a vec) sub(b vec) vec { return vec{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+
+func (a vec) scale(s float64) vec { return vec{a[0] * s, a[1] * s, a[2] * s} }
+
+func (a vec) cross(b vec) vec {
+	return vec{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func unit(a vec) (vec, bool) {
+	n := math.Sqrt(a.dot(a))
+	if n == 0 {
+		return a, false
+	}
+	return a.scale(1 / n), true
+}
@@ -0,0 +1,136 @@
+// Package hooks runs the configured post-processing commands (blurring,
+// compression, upload, ...) on closed sessions and records their outcome
+// in the session manifest.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// outputTail bounds the command output kept in the manifest.
+const outputTail = 2048
+
+// Runner post-processes sessions in the background, at most
+// cfg.Concurrency at once. The commands of one session run in order, and
+// a failed command stops the ones after it, so an upload never follows a
+// failed anonymization.
+type Runner struct {
+	cfg utils.HooksConfig
+	log utils.Logger
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	failed atomic.Int64
+}
+
+func NewRunner(cfg utils.HooksConfig, log utils.Logger) *Runner {
+	return &Runner{cfg: cfg, log: log, sem: make(chan struct{}, max(cfg.Concurrency, 1))}
+}
+
+// Submit queues the session in dir.
+func (r *Runner) Submit(dir string) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.sem <- struct{}{}
+		defer func() { <-r.sem }()
+		r.run(dir)
+	}()
+}
+
+// Wait blocks until every submitted session is processed.
+func (r *Runner) Wait() {
+	r.wg.Wait()
+}
+
+// Failed returns the number of sessions on which a command failed.
+func (r *Runner) Failed() int64 {
+	return r.failed.Load()
+}
+
+func (r *Runner) run(dir string) {
+	results := make([]views.HookResult, len(r.cfg.Commands))
+	failed := false
+	for i, h := range r.cfg.Commands {
+		results[i].Name = h.Name
+		if failed {
+			results[i].Skipped = true
+			continue
+		}
+		r.log.Infof("hooks: %s: running %s", dir, h.Name)
+		results[i] = r.exec(dir, h)
+		if res := results[i]; res.Error != "" {
+			failed = true
+			r.failed.Add(1)
+			r.log.Errorf("hooks: %s: %s failed after %.1f s: %s", dir, h.Name, res.DurationS, res.Error)
+			if res.Output != "" {
+				r.log.Errorf("hooks: %s: %s output:\n%s", dir, h.Name, res.Output)
+			}
+		}
+	}
+
+	// A hook may have moved or removed the session, e.g. after an upload.
+	m, err := views.ReadManifest(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			r.log.Warnf("hooks: %s: results not recorded: %v", dir, err)
+		}
+		return
+	}
+	m.Hooks = results
+	if err := views.WriteManifest(dir, m); err != nil {
+		r.log.Warnf("hooks: %s: results not recorded: %v", dir, err)
+	}
+}
+
+func (r *Runner) exec(dir string, h utils.HookConfig) views.HookResult {
+	ctx := context.Background()
+	if r.cfg.TimeoutS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(r.cfg.TimeoutS)*time.Second)
+		defer cancel()
+	}
+	args := make([]string, len(h.Command))
+	for i, a := range h.Command {
+		args[i] = strings.ReplaceAll(a, "{session}", dir)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "SESSION_DIR="+dir)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// Children of a killed command may hold its output open.
+	cmd.WaitDelay = time.Second
+
+	res := views.HookResult{Name: h.Name, Start: utils.Now()}
+	err := cmd.Run()
+	res.DurationS = utils.Now().Sub(res.Start).Seconds()
+	if err == nil {
+		return res
+	}
+	res.ExitCode = -1
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		res.ExitCode = ee.ExitCode()
+	}
+	res.Error = err.Error()
+	if ctx.Err() != nil {
+		res.Error = "timed out"
+	}
+	b := bytes.TrimSpace(out.Bytes())
+	if len(b) > outputTail {
+		b = b[len(b)-outputTail:]
+	}
+	res.Output = string(b)
+	return res
+}
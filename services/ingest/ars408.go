@@ -0,0 +1,95 @@
+package ingest
+
+import (
+	"math"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+// CAN identifiers of the Continental ARS 408-21 messages for sensor id 0;
+// sensor id n adds 0x10*n. The sensor sends either clusters (raw
+// detections) or tracked objects, as configured on it: every cycle a
+// status message with the count, then one general message per target.
+const (
+	ars408ClusterStatus  = 0x600
+	ars408ObjectStatus   = 0x60A
+	ars408ObjectGeneral  = 0x60B
+	ars408ClusterGeneral = 0x701
+)
+
+// ars408 assembles the general messages of a measurement cycle into a
+// target list.
+type ars408 struct {
+	base    uint32
+	open    bool // a status message was seen and targets are missing
+	want    int
+	targets []models.RadarTarget
+	// partial counts the cycles published before all their targets came.
+	partial int64
+}
+
+func newARS408(sensorID int) *ars408 {
+	return &ars408{base: 0x10 * uint32(sensorID)}
+}
+
+// handle takes one frame and calls emit with every completed target list.
+// A cycle cut short by the next status message is emitted as it is.
+func (a *ars408) handle(f canFrame, emit func([]models.RadarTarget)) {
+	if f.id < a.base {
+		return
+	}
+	switch id := f.id - a.base; id {
+	case ars408ClusterStatus, ars408ObjectStatus:
+		if len(f.data) < 2 {
+			return
+		}
+		if a.open {
+			a.partial++
+			emit(a.targets)
+		}
+		a.want = int(f.data[0])
+		if id == ars408ClusterStatus {
+			a.want += int(f.data[1]) // near and far scan
+		}
+		a.targets, a.open = make([]models.RadarTarget, 0, a.want), true
+	case ars408ClusterGeneral, ars408ObjectGeneral:
+		if !a.open || len(f.data) < 8 {
+			return
+		}
+		a.targets = append(a.targets, decodeARS408General(f.data, id == ars408ObjectGeneral))
+	default:
+		return
+	}
+	if a.open && len(a.targets) >= a.want {
+		a.open = false
+		emit(a.targets)
+	}
+}
+
+// decodeARS408General converts a Cluster_1_General or Object_1_General
+// message into a target. The sensor reports Cartesian distances (x
+// forward, y left) and relative velocities; the radial velocity is the
+// relative velocity along the line of sight.
+func decodeARS408General(b []byte, object bool) models.RadarTarget {
+	long := float64(uint16(b[1])<<5|uint16(b[2])>>3)*0.2 - 500
+	var lat float64
+	if object {
+		lat = float64(uint16(b[2]&0x07)<<8|uint16(b[3]))*0.2 - 204.6
+	} else {
+		lat = float64(uint16(b[2]&0x03)<<8|uint16(b[3]))*0.2 - 102.3
+	}
+	vLong := float64(uint16(b[4])<<2|uint16(b[5])>>6)*0.25 - 128
+	vLat := float64(uint16(b[5]&0x3F)<<3|uint16(b[6])>>5)*0.25 - 64
+	r := math.Hypot(long, lat)
+	var radial float64
+	if r > 0 {
+		radial = (vLong*long + vLat*lat) / r
+	}
+	return models.RadarTarget{
+		ID:          int(b[0]),
+		RangeM:      r,
+		AzimuthDeg:  math.Atan2(lat, long) * 180 / math.Pi,
+		VelocityMps: radial,
+		RCS:         float64(b[7])*0.5 - 64,
+	}
+}
@@ -0,0 +1,157 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// i2cSlave is the Linux I2C_SLAVE ioctl selecting the target address.
+const i2cSlave = 0x0703
+
+const (
+	bme280RegChipID   = 0xD0
+	bme280RegCalib00  = 0x88
+	bme280RegCalib26  = 0xE1
+	bme280RegCtrlHum  = 0xF2
+	bme280RegCtrlMeas = 0xF4
+	bme280RegConfig   = 0xF5
+	bme280RegData     = 0xF7
+	bme280ChipID      = 0x60
+)
+
+// bme280 talks to a Bosch BME280 through /dev/i2c-N.
+type bme280 struct {
+	f *os.File
+
+	t1         uint16
+	t2, t3     int16
+	p1         uint16
+	p2, p3, p4 int16
+	p5, p6, p7 int16
+	p8, p9     int16
+	h1, h3     uint8
+	h2         int16
+	h4, h5     int16
+	h6         int8
+}
+
+func openBME280(device string, addr int) (*bme280, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", device, err)
+	}
+	if err := ioctl(f.Fd(), i2cSlave, uintptr(addr)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("select i2c address 0x%02x: %w", addr, err)
+	}
+	s := &bme280{f: f}
+	if err := s.init(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *bme280) readReg(reg byte, n int) ([]byte, error) {
+	if _, err := s.f.Write([]byte{reg}); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := s.f.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *bme280) writeReg(reg, v byte) error {
+	_, err := s.f.Write([]byte{reg, v})
+	return err
+}
+
+func (s *bme280) init() error {
+	id, err := s.readReg(bme280RegChipID, 1)
+	if err != nil {
+		return fmt.Errorf("bme280 read chip id: %w", err)
+	}
+	if id[0] != bme280ChipID {
+		return fmt.Errorf("bme280: unexpected chip id 0x%02x", id[0])
+	}
+	c, err := s.readReg(bme280RegCalib00, 26)
+	if err != nil {
+		return fmt.Errorf("bme280 read calibration: %w", err)
+	}
+	le := binary.LittleEndian
+	s.t1 = le.Uint16(c[0:])
+	s.t2 = int16(le.Uint16(c[2:]))
+	s.t3 = int16(le.Uint16(c[4:]))
+	s.p1 = le.Uint16(c[6:])
+	s.p2 = int16(le.Uint16(c[8:]))
+	s.p3 = int16(le.Uint16(c[10:]))
+	s.p4 = int16(le.Uint16(c[12:]))
+	s.p5 = int16(le.Uint16(c[14:]))
+	s.p6 = int16(le.Uint16(c[16:]))
+	s.p7 = int16(le.Uint16(c[18:]))
+	s.p8 = int16(le.Uint16(c[20:]))
+	s.p9 = int16(le.Uint16(c[22:]))
+	s.h1 = c[25]
+
+	h, err := s.readReg(bme280RegCalib26, 7)
+	if err != nil {
+		return fmt.Errorf("bme280 read calibration: %w", err)
+	}
+	s.h2 = int16(le.Uint16(h[0:]))
+	s.h3 = h[2]
+	s.h4 = int16(int8(h[3]))<<4 | int16(h[4]&0x0F)
+	s.h5 = int16(int8(h[5]))<<4 | int16(h[4]>>4)
+	s.h6 = int8(h[6])
+
+	// x1 oversampling on all channels, normal mode, 1 s standby.
+	if err := s.writeReg(bme280RegCtrlHum, 0x01); err != nil {
+		return err
+	}
+	if err := s.writeReg(bme280RegCtrlMeas, 0x27); err != nil {
+		return err
+	}
+	return s.writeReg(bme280RegConfig, 0xA0)
+}
+
+// read returns temperature (°C), relative humidity (%) and pressure (hPa)
+// using the floating-point compensation formulas of the datasheet.
+func (s *bme280) read() (tempC, humPct, pressHPa float64, err error) {
+	d, err := s.readReg(bme280RegData, 8)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("bme280 read data: %w", err)
+	}
+	adcP := float64(int32(d[0])<<12 | int32(d[1])<<4 | int32(d[2])>>4)
+	adcT := float64(int32(d[3])<<12 | int32(d[4])<<4 | int32(d[5])>>4)
+	adcH := float64(int32(d[6])<<8 | int32(d[7]))
+
+	v1 := (adcT/16384.0 - float64(s.t1)/1024.0) * float64(s.t2)
+	v2 := (adcT/131072.0 - float64(s.t1)/8192.0) * (adcT/131072.0 - float64(s.t1)/8192.0) * float64(s.t3)
+	tFine := v1 + v2
+	tempC = tFine / 5120.0
+
+	v1 = tFine/2.0 - 64000.0
+	v2 = v1 * v1 * float64(s.p6) / 32768.0
+	v2 += v1 * float64(s.p5) * 2.0
+	v2 = v2/4.0 + float64(s.p4)*65536.0
+	v1 = (float64(s.p3)*v1*v1/524288.0 + float64(s.p2)*v1) / 524288.0
+	v1 = (1.0 + v1/32768.0) * float64(s.p1)
+	if v1 != 0 {
+		p := 1048576.0 - adcP
+		p = (p - v2/4096.0) * 6250.0 / v1
+		v1 = float64(s.p9) * p * p / 2147483648.0
+		v2 = p * float64(s.p8) / 32768.0
+		pressHPa = (p + (v1+v2+float64(s.p7))/16.0) / 100.0
+	}
+
+	h := tFine - 76800.0
+	h = (adcH - (float64(s.h4)*64.0 + float64(s.h5)/16384.0*h)) *
+		(float64(s.h2) / 65536.0 * (1.0 + float64(s.h6)/67108864.0*h*(1.0+float64(s.h3)/67108864.0*h)))
+	h *= 1.0 - float64(s.h1)*h/524288.0
+	humPct = min(max(h, 0), 100)
+	return tempC, humPct, pressHPa, nil
+}
+
+func (s *bme280) Close() error { return s.f.Close() }
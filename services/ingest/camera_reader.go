@@ -0,0 +1,252 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// cameraBackend delivers encoded JPEG frames.
+type cameraBackend interface {
+	Grab() (grab, error)
+	Close() error
+}
+
+// grab is one frame as delivered by a backend. Exposure and gain are nil
+// when the camera does not report them.
+type grab struct {
+	data          []byte
+	width, height int
+	exposureUs    *float64
+	gainDB        *float64
+}
+
+// CameraReader captures frames at the configured FPS and publishes them
+// on its Mux. For a stereo pair, the right camera is grabbed alongside the
+// left one and rides along in the frame's Right.
+type CameraReader struct {
+	cfg    utils.CameraConfig
+	log    utils.Logger
+	remote <-chan models.CameraFrame
+
+	// Last frame ID, kept across runs so frames/ names stay unique.
+	frameID uint64
+	counters
+}
+
+func NewCameraReader(cfg utils.CameraConfig, log utils.Logger, clock utils.Clock) *CameraReader {
+	cfg.FPS = checkRate(log, "camera", cfg.FPS)
+	r := &CameraReader{cfg: cfg, log: log, counters: counters{buffer: cfg.BufferSize, clock: clock}}
+	r.decimate(cfg.Decimate)
+	return r
+}
+
+func (r *CameraReader) Name() string { return "camera" }
+
+// UseRemote makes the reader publish frames received from a remote agent
+// instead of opening a local device.
+func (r *CameraReader) UseRemote(in <-chan models.CameraFrame) { r.remote = in }
+
+func (r *CameraReader) Run(ctx context.Context) error {
+	if r.remote != nil {
+		return forward(ctx, r.remote, &r.counters)
+	}
+	backend, err := r.openBackend(ctx, r.cfg.Device, 0)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	var right cameraBackend
+	if r.cfg.Stereo.Enabled {
+		// The simulated right camera sees the scene shifted, as a real one
+		// would by the disparity.
+		if right, err = r.openBackend(ctx, r.cfg.Stereo.RightDevice, 16); err != nil {
+			return fmt.Errorf("right camera: %w", err)
+		}
+		defer right.Close()
+	}
+
+	var freeze *freezeWatch
+	if r.cfg.Freeze.Enabled {
+		freeze = newFreezeWatch(time.Duration(r.cfg.Freeze.HoldS * float64(time.Second)))
+	}
+
+	ticker := utils.NewRateTicker(r.clock, r.cfg.FPS)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !r.simTick() {
+			continue
+		}
+		var pair *stereoGrab
+		if right != nil {
+			pair = grabAsync(right, r.clock)
+		}
+		g, err := backend.Grab()
+		ts := r.clock.Now()
+		if pair != nil {
+			<-pair.done
+			if err == nil && pair.err != nil {
+				err = fmt.Errorf("right camera: %w", pair.err)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("camera grab: %w", err)
+		}
+		r.frameID++
+		f := models.CameraFrame{
+			Timestamp:  ts,
+			FrameID:    r.frameID,
+			Width:      g.width,
+			Height:     g.height,
+			ExposureUs: g.exposureUs,
+			GainDB:     g.gainDB,
+			Data:       g.data,
+		}
+		if pair != nil {
+			f.Right = &models.StereoFrame{Width: pair.g.width, Height: pair.g.height, Skew: pair.at.Sub(ts), Data: pair.g.data}
+		}
+		if r.cfg.FrameStats {
+			if f.Stats, err = imageStats(g.data); err != nil {
+				r.log.Warnf("camera: frame %d stats: %v", r.frameID, err)
+			}
+		}
+		var frozen, moving bool
+		if freeze != nil {
+			f.Frozen, frozen, moving = freeze.observe(g.data, ts)
+		}
+		emit(f, &r.counters)
+		switch {
+		case frozen && r.cfg.Freeze.Action == utils.FreezeRestart:
+			return fmt.Errorf("camera frozen: the same picture for %v", f.Frozen.Round(time.Millisecond))
+		case frozen:
+			r.log.Warnf("camera: frozen, the same picture for %v by frame %d", f.Frozen.Round(time.Millisecond), r.frameID)
+		case moving:
+			r.log.Infof("camera: picture moving again at frame %d", r.frameID)
+		}
+	}
+}
+
+// openBackend opens device; shift offsets the picture of a simulated one.
+func (r *CameraReader) openBackend(ctx context.Context, device string, shift int) (cameraBackend, error) {
+	switch {
+	case device == utils.SimDevice:
+		return &simCamera{width: r.cfg.Width, height: r.cfg.Height, n: shift}, nil
+	case strings.HasPrefix(device, "http://"), strings.HasPrefix(device, "https://"):
+		return openMJPEG(ctx, device)
+	}
+	return nil, fmt.Errorf("unsupported camera device %q", device)
+}
+
+// stereoGrab is a grab of the right camera of a stereo pair, taken in the
+// background while the left one is grabbed; done is closed once it has
+// returned, at time at.
+type stereoGrab struct {
+	g    grab
+	err  error
+	at   time.Time
+	done chan struct{}
+}
+
+func grabAsync(b cameraBackend, clock utils.Clock) *stereoGrab {
+	s := &stereoGrab{done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.g, s.err = b.Grab()
+		s.at = clock.Now()
+	}()
+	return s
+}
+
+// simCamera renders a moving gradient so the pipeline can run without
+// hardware. It reports an exposure that drifts like a slow auto-exposure
+// loop and a fixed gain.
+type simCamera struct {
+	width, height int
+	n             int
+}
+
+func (c *simCamera) Grab() (grab, error) {
+	img := image.NewGray(image.Rect(0, 0, c.width, c.height))
+	for y := 0; y < c.height; y++ {
+		for x := 0; x < c.width; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x + y + c.n)})
+		}
+	}
+	c.n += 4
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return grab{}, err
+	}
+	exposure := 8000 + 4000*math.Sin(float64(c.n)/200)
+	gain := 0.0
+	return grab{data: buf.Bytes(), width: c.width, height: c.height, exposureUs: &exposure, gainDB: &gain}, nil
+}
+
+func (c *simCamera) Close() error { return nil }
+
+// mjpegCamera reads a multipart/x-mixed-replace MJPEG stream over HTTP, as
+// served by most IP cameras and camera bridge daemons.
+type mjpegCamera struct {
+	body io.Closer
+	mr   *multipart.Reader
+}
+
+func openMJPEG(ctx context.Context, url string) (*mjpegCamera, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("open mjpeg stream: %w", err)
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		resp.Body.Close()
+		return nil, fmt.Errorf("mjpeg stream %s: missing multipart boundary", url)
+	}
+	return &mjpegCamera{body: resp.Body, mr: multipart.NewReader(resp.Body, params["boundary"])}, nil
+}
+
+// Grab reads the next part. Exposure and gain are taken from the frame's
+// EXIF block when the camera embeds one.
+func (c *mjpegCamera) Grab() (grab, error) {
+	part, err := c.mr.NextPart()
+	if err != nil {
+		return grab{}, err
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		return grab{}, err
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return grab{}, fmt.Errorf("decode frame header: %w", err)
+	}
+	g := grab{data: data, width: cfg.Width, height: cfg.Height}
+	g.exposureUs, g.gainDB = exifExposure(data)
+	return g, nil
+}
+
+func (c *mjpegCamera) Close() error { return c.body.Close() }
@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// SocketCAN constants, which package syscall does not export.
+const (
+	afCAN        = 29
+	canRaw       = 1
+	canFrameSize = 16
+	canEFFFlag   = 0x80000000
+	canRTRFlag   = 0x40000000
+	canERRFlag   = 0x20000000
+	canSFFMask   = 0x7FF
+)
+
+// canFrame is a classic CAN data frame with an 11-bit identifier.
+type canFrame struct {
+	id   uint32
+	data []byte
+}
+
+// openCAN opens a raw SocketCAN socket on the network interface name, e.g.
+// can0. Closing the file interrupts a pending read.
+func openCAN(name string) (*os.File, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("can interface %s: %w", name, err)
+	}
+	fd, err := syscall.Socket(afCAN, syscall.SOCK_RAW, canRaw)
+	if err != nil {
+		return nil, fmt.Errorf("can socket: %w", err)
+	}
+	// struct sockaddr_can; the address union is unused by CAN_RAW.
+	addr := struct {
+		family  uint16
+		_       uint16
+		ifindex int32
+		_       [16]byte
+	}{family: afCAN, ifindex: int32(ifi.Index)}
+	if _, _, errno := syscall.Syscall(syscall.SYS_BIND, uintptr(fd), uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr)); errno != 0 {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("bind %s: %w", name, errno)
+	}
+	// A non-blocking descriptor makes os.File use the runtime poller.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("can socket: %w", err)
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// readCANFrame reads the next standard data frame, skipping extended,
+// remote and error frames. buf holds the frame and is reused.
+func readCANFrame(r io.Reader, buf *[canFrameSize]byte) (canFrame, error) {
+	for {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return canFrame{}, err
+		}
+		id := binary.NativeEndian.Uint32(buf[:4])
+		if id&(canEFFFlag|canRTRFlag|canERRFlag) != 0 {
+			continue
+		}
+		n := min(int(buf[4]), 8)
+		return canFrame{id: id & canSFFMask, data: buf[8 : 8+n]}, nil
+	}
+}
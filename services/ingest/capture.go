@@ -0,0 +1,112 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Capture receives the datagrams or frames of a device as they arrive,
+// before they are decoded: UDP datagrams as the IP packets that carried
+// them, CAN frames as SocketCAN frames with the identifier big-endian.
+// These are the packets of views.LinkTypeRaw and views.LinkTypeCAN.
+type Capture func(ts time.Time, packet []byte)
+
+// udpPacket returns the IPv4 or IPv6 packet, by the address of src, that
+// carries payload from src to dst.
+func udpPacket(src, dst netip.AddrPort, payload []byte) []byte {
+	from, to := src.Addr().Unmap(), dst.Addr().Unmap()
+	// A socket bound to all addresses has none of its own to give.
+	if from.Is4() && !to.Is4() {
+		to = netip.IPv4Unspecified()
+	} else if !from.Is4() && to.Is4() {
+		to = netip.IPv6Unspecified()
+	}
+	udpLen := 8 + len(payload)
+	var pkt []byte
+	if from.Is4() {
+		pkt = make([]byte, 20, 20+udpLen)
+		pkt[0] = 0x45 // version 4, 5 words of header
+		binary.BigEndian.PutUint16(pkt[2:], uint16(20+udpLen))
+		binary.BigEndian.PutUint16(pkt[6:], 0x4000) // don't fragment
+		pkt[8], pkt[9] = 64, 17                     // TTL, UDP
+		a, b := from.As4(), to.As4()
+		copy(pkt[12:], a[:])
+		copy(pkt[16:], b[:])
+		binary.BigEndian.PutUint16(pkt[10:], ^fold(checksum(0, pkt[:20])))
+	} else {
+		pkt = make([]byte, 40, 40+udpLen)
+		pkt[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(pkt[4:], uint16(udpLen))
+		pkt[6], pkt[7] = 17, 64 // UDP, hop limit
+		a, b := from.As16(), to.As16()
+		copy(pkt[8:], a[:])
+		copy(pkt[24:], b[:])
+	}
+	ip := len(pkt)
+	pkt = binary.BigEndian.AppendUint16(pkt, src.Port())
+	pkt = binary.BigEndian.AppendUint16(pkt, dst.Port())
+	pkt = binary.BigEndian.AppendUint16(pkt, uint16(udpLen))
+	pkt = append(pkt, 0, 0)
+	pkt = append(pkt, payload...)
+
+	// The UDP checksum covers a pseudo header of the addresses, protocol
+	// and length; 0 is sent as all ones.
+	var sum uint32
+	if from.Is4() {
+		sum = checksum(0, pkt[12:20])
+	} else {
+		sum = checksum(0, pkt[8:40])
+	}
+	sum += 17 + uint32(udpLen)
+	c := ^fold(checksum(sum, pkt[ip:]))
+	if c == 0 {
+		c = 0xffff
+	}
+	binary.BigEndian.PutUint16(pkt[ip+6:], c)
+	return pkt
+}
+
+// checksum adds b to sum as big-endian 16-bit words, the last padded
+// with a zero byte.
+func checksum(sum uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+// fold reduces a checksum to 16 bits with end-around carry.
+func fold(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
+
+// captureReader passes on the reads of a CAN socket, one frame each, to
+// capture.
+type captureReader struct {
+	r       io.Reader
+	capture Capture
+	clock   utils.Clock
+}
+
+func (c captureReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n == canFrameSize {
+		frame := make([]byte, n)
+		copy(frame, p[:n])
+		// Linux hands the identifier over in host order.
+		binary.BigEndian.PutUint32(frame, binary.NativeEndian.Uint32(p))
+		c.capture(c.clock.Now(), frame)
+	}
+	return n, err
+}
@@ -0,0 +1,503 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Discovery lists the sensors Discover found.
+type Discovery struct {
+	Cameras []FoundCamera
+	GPS     []FoundSerial
+	IMU     []FoundSerial
+	Env     []FoundEnv
+	Lidars  []FoundLidar
+	Radars  []FoundRadar
+}
+
+// FoundCamera is a V4L2 capture device and its pixel formats, each with
+// its largest frame size, e.g. "MJPG 1920x1080".
+type FoundCamera struct {
+	Device, Card string
+	Formats      []string
+}
+
+// FoundSerial is a serial port sending a stream a reader understands at
+// Baud; Protocol is nmea or ubx for a GPS.
+type FoundSerial struct {
+	Device   string
+	Baud     int
+	Protocol string
+}
+
+// FoundEnv is a BME280 on an I2C bus or a serial environment bridge.
+type FoundEnv struct {
+	Transport string
+	Device    string
+	Address   int // i2c
+	Baud      int // serial
+}
+
+// FoundLidar is a lidar sending to Port from Sender. Product names a
+// Velodyne sensor, "" for datagrams of raw points.
+type FoundLidar struct {
+	Port    int
+	Sender  string
+	Product string
+	Format  string
+}
+
+// FoundRadar is a CAN interface, with the sensor id of the ARS 408 heard
+// on it, -1 for none.
+type FoundRadar struct {
+	Interface string
+	SensorID  int
+}
+
+// Serial ports probed; /dev/ttyS* are left out, as PCs have dozens of them
+// with nothing attached. Bauds are tried in this order.
+var (
+	discoverPorts = []string{"/dev/ttyUSB*", "/dev/ttyACM*", "/dev/ttyAMA*", "/dev/ttyTHS*"}
+	discoverBauds = []int{9600, 115200, 38400, 57600, 230400, 460800}
+)
+
+// velodyneProducts maps the product id byte of a data packet to its model.
+var velodyneProducts = map[byte]string{
+	0x21: "HDL-32E",
+	0x22: "VLP-16",
+	0x24: "Puck Hi-Res",
+	0x28: "VLP-32C",
+	0x31: "Velarray",
+}
+
+// Discover probes this machine for sensors, listening up to wait on every
+// serial port and baud, the lidar port and CAN interfaces. The probes run
+// concurrently; serial ports are only read, never written to.
+func Discover(wait time.Duration, lidarPort int, log utils.Logger) *Discovery {
+	d := &Discovery{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+
+	d.Cameras = discoverCameras(log)
+	d.Env = discoverBME280(log)
+	for _, pattern := range discoverPorts {
+		ports, _ := filepath.Glob(pattern)
+		for _, port := range ports {
+			run(func() {
+				kind, found, ok := probeSerial(port, wait, log)
+				if !ok {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				switch kind {
+				case "gps":
+					d.GPS = append(d.GPS, found)
+				case "imu":
+					d.IMU = append(d.IMU, found)
+				case "env":
+					d.Env = append(d.Env, FoundEnv{Transport: "serial", Device: found.Device, Baud: found.Baud})
+				}
+			})
+		}
+	}
+	run(func() {
+		if l, ok := probeLidar(lidarPort, wait, log); ok {
+			mu.Lock()
+			d.Lidars = append(d.Lidars, l)
+			mu.Unlock()
+		}
+	})
+	for _, name := range canInterfaces() {
+		run(func() {
+			r := probeCAN(name, wait, log)
+			mu.Lock()
+			d.Radars = append(d.Radars, r)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	sortBy := func(a, b FoundSerial) int { return strings.Compare(a.Device, b.Device) }
+	slices.SortFunc(d.GPS, sortBy)
+	slices.SortFunc(d.IMU, sortBy)
+	slices.SortFunc(d.Radars, func(a, b FoundRadar) int { return strings.Compare(a.Interface, b.Interface) })
+	return d
+}
+
+// V4L2 ioctls and flags from linux/videodev2.h.
+const (
+	vidiocQueryCap       = 0x80685600
+	vidiocEnumFmt        = 0xc0405602
+	vidiocEnumFrameSizes = 0xc02c564a
+	v4l2CapVideoCapture  = 0x1
+	v4l2CapDeviceCaps    = 0x80000000
+	v4l2BufVideoCapture  = 1
+	v4l2FrmSizeDiscrete  = 1
+)
+
+func discoverCameras(log utils.Logger) []FoundCamera {
+	devices, _ := filepath.Glob("/dev/video*")
+	var found []FoundCamera
+	for _, dev := range devices {
+		f, err := os.OpenFile(dev, os.O_RDWR, 0)
+		if err != nil {
+			log.Debugf("discover: %v", err)
+			continue
+		}
+		var vc struct {
+			driver     [16]byte
+			card       [32]byte
+			busInfo    [32]byte
+			version    uint32
+			caps       uint32
+			deviceCaps uint32
+			_          [3]uint32
+		}
+		if err := ioctl(f.Fd(), vidiocQueryCap, uintptr(unsafe.Pointer(&vc))); err != nil {
+			f.Close()
+			continue
+		}
+		caps := vc.caps
+		if caps&v4l2CapDeviceCaps != 0 {
+			caps = vc.deviceCaps
+		}
+		// UVC cameras also expose metadata nodes, which cannot capture.
+		if caps&v4l2CapVideoCapture != 0 {
+			found = append(found, FoundCamera{Device: dev, Card: cString(vc.card[:]), Formats: v4l2Formats(f)})
+		}
+		f.Close()
+	}
+	return found
+}
+
+// v4l2Formats lists the capture formats of f with their largest discrete
+// frame size.
+func v4l2Formats(f *os.File) []string {
+	var formats []string
+	for i := uint32(0); ; i++ {
+		var desc struct {
+			index, typ, flags uint32
+			description       [32]byte
+			pixelFormat       uint32
+			mbusCode          uint32
+			_                 [3]uint32
+		}
+		desc.index, desc.typ = i, v4l2BufVideoCapture
+		if ioctl(f.Fd(), vidiocEnumFmt, uintptr(unsafe.Pointer(&desc))) != nil {
+			return formats
+		}
+		fourcc := make([]byte, 4)
+		binary.LittleEndian.PutUint32(fourcc, desc.pixelFormat)
+		s := strings.TrimSpace(string(fourcc))
+		var w, h uint32
+		for j := uint32(0); ; j++ {
+			var size struct {
+				index, pixelFormat, typ uint32
+				width, height           uint32
+				_                       [4]uint32 // rest of the stepwise union
+				_                       [2]uint32
+			}
+			size.index, size.pixelFormat = j, desc.pixelFormat
+			if ioctl(f.Fd(), vidiocEnumFrameSizes, uintptr(unsafe.Pointer(&size))) != nil || size.typ != v4l2FrmSizeDiscrete {
+				break
+			}
+			if size.width*size.height > w*h {
+				w, h = size.width, size.height
+			}
+		}
+		if w > 0 {
+			s += fmt.Sprintf(" %dx%d", w, h)
+		}
+		formats = append(formats, s)
+	}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// discoverBME280 reads the chip id register at both BME280 addresses of
+// every I2C bus.
+func discoverBME280(log utils.Logger) []FoundEnv {
+	buses, _ := filepath.Glob("/dev/i2c-*")
+	var found []FoundEnv
+	for _, bus := range buses {
+		for _, addr := range []int{0x76, 0x77} {
+			f, err := os.OpenFile(bus, os.O_RDWR, 0)
+			if err != nil {
+				log.Debugf("discover: %v", err)
+				break
+			}
+			id := make([]byte, 1)
+			if ioctl(f.Fd(), i2cSlave, uintptr(addr)) == nil {
+				if _, err := f.Write([]byte{bme280RegChipID}); err == nil {
+					if _, err := f.Read(id); err == nil && id[0] == bme280ChipID {
+						found = append(found, FoundEnv{Transport: "i2c", Device: bus, Address: addr})
+					}
+				}
+			}
+			f.Close()
+		}
+	}
+	return found
+}
+
+// probeSerial listens on port at each baud in turn and classifies what it
+// hears as gps, imu or env.
+func probeSerial(port string, wait time.Duration, log utils.Logger) (kind string, found FoundSerial, ok bool) {
+	for _, baud := range discoverBauds {
+		f, err := OpenSerial(port, baud)
+		if err != nil {
+			log.Debugf("discover: %v", err)
+			return "", found, false
+		}
+		if err := f.SetReadDeadline(time.Now().Add(wait)); err != nil {
+			f.Close()
+			log.Debugf("discover: %s: %v", port, err)
+			return "", found, false
+		}
+		data, _ := io.ReadAll(io.LimitReader(f, 4096))
+		f.Close()
+		found = FoundSerial{Device: port, Baud: baud}
+		if kind, found.Protocol = classifySerial(data); kind != "" {
+			log.Infof("discover: %s at %d baud: %s", port, baud, kind)
+			return kind, found, true
+		}
+		// A USB CDC device ignores the baud; one try is enough.
+		if strings.HasPrefix(filepath.Base(port), "ttyACM") {
+			break
+		}
+	}
+	return "", found, false
+}
+
+// classifySerial recognises UBX frames, NMEA sentences with a valid
+// checksum, IMU and environment lines. Two complete records are required,
+// so that noise at a wrong baud rate does not count.
+func classifySerial(data []byte) (kind, protocol string) {
+	if i := bytes.Index(data, []byte{ubxSync1, ubxSync2}); i >= 0 {
+		br := bufio.NewReader(bytes.NewReader(data[i:]))
+		if _, err := readUBX(br); err == nil {
+			return "gps", utils.GPSUBX
+		}
+	}
+	var nmea, imu, env int
+	lines := strings.Split(string(data), "\n")
+	// The first and last lines may be cut off.
+	if len(lines) > 2 {
+		lines = lines[1 : len(lines)-1]
+	}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "$") && strings.Contains(line, "*"):
+			if _, err := nmeaFields(line); err == nil {
+				nmea++
+			}
+		case isIMULine(line):
+			imu++
+		default:
+			if _, err := parseEnvLine(line); err == nil {
+				env++
+			}
+		}
+	}
+	switch {
+	case nmea >= 2:
+		return "gps", utils.GPSNMEA
+	case imu >= 2:
+		return "imu", ""
+	case env >= 2:
+		return "env", ""
+	}
+	return "", ""
+}
+
+func isIMULine(line string) bool {
+	_, err := parseIMULine(line)
+	return err == nil
+}
+
+// probeLidar waits for a datagram on port, which Velodyne sensors
+// broadcast to by default.
+func probeLidar(port int, wait time.Duration, log utils.Logger) (FoundLidar, bool) {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Warnf("discover: lidar port %d: %v; is a logger running?", port, err)
+		return FoundLidar{}, false
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, maxLidarDatagram)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		return FoundLidar{}, false
+	}
+	sender, _, _ := net.SplitHostPort(from.String())
+	l := FoundLidar{Port: port, Sender: sender, Format: utils.LidarRaw}
+	if n == vlpPacketSize {
+		l.Product = velodyneProducts[buf[vlpPacketSize-1]]
+		if l.Product == "" {
+			l.Product = fmt.Sprintf("Velodyne (product id 0x%02x)", buf[vlpPacketSize-1])
+		}
+		l.Format = utils.LidarVLP16
+	}
+	log.Infof("discover: lidar data on port %d from %s", port, l.Sender)
+	return l, true
+}
+
+// canInterfaces lists the network interfaces of type CAN.
+func canInterfaces() []string {
+	const arphrdCAN = "280"
+	ifaces, _ := net.Interfaces()
+	var names []string
+	for _, ifi := range ifaces {
+		b, err := os.ReadFile(filepath.Join("/sys/class/net", ifi.Name, "type"))
+		if err == nil && strings.TrimSpace(string(b)) == arphrdCAN {
+			names = append(names, ifi.Name)
+		}
+	}
+	return names
+}
+
+// probeCAN listens on a CAN interface for the status message of an ARS
+// 408, which gives away its sensor id.
+func probeCAN(name string, wait time.Duration, log utils.Logger) FoundRadar {
+	r := FoundRadar{Interface: name, SensorID: -1}
+	f, err := openCAN(name)
+	if err != nil {
+		log.Debugf("discover: %v", err)
+		return r
+	}
+	defer f.Close()
+	if err := f.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		log.Debugf("discover: %s: %v", name, err)
+		return r
+	}
+	var buf [canFrameSize]byte
+	for {
+		frame, err := readCANFrame(f, &buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Debugf("discover: %s: %v", name, err)
+			}
+			return r
+		}
+		for id := range 8 {
+			if base := uint32(0x10 * id); frame.id == ars408ClusterStatus+base || frame.id == ars408ObjectStatus+base {
+				log.Infof("discover: ARS 408 with sensor id %d on %s", id, name)
+				r.SensorID = id
+				return r
+			}
+		}
+	}
+}
+
+// WriteYAML writes a sensors.yaml skeleton enabling what was found; the
+// remaining settings take their defaults.
+func (d *Discovery) WriteYAML(w io.Writer) error {
+	b := &bytes.Buffer{}
+	fmt.Fprintln(b, "# Generated by sensor-logger discover; review before use.")
+	fmt.Fprintln(b)
+
+	fmt.Fprintln(b, "camera:")
+	for _, c := range d.Cameras {
+		fmt.Fprintf(b, "  # %s: %s, %s\n", c.Device, c.Card, strings.Join(c.Formats, ", "))
+	}
+	if len(d.Cameras) > 0 {
+		fmt.Fprintln(b, "  # The camera reader takes an MJPEG stream: serve the device with an")
+		fmt.Fprintln(b, "  # MJPEG streamer and set device to its URL.")
+	}
+	fmt.Fprintln(b, "  enabled: false")
+	fmt.Fprintln(b, "  device: sim")
+
+	fmt.Fprintln(b, "\ngps:")
+	if g, ok := first(d.GPS, b); ok {
+		fmt.Fprintf(b, "  enabled: true\n  device: %s\n  baud: %d\n  protocol: %s\n", g.Device, g.Baud, g.Protocol)
+	} else {
+		fmt.Fprintln(b, "  enabled: false\n  device: sim")
+	}
+
+	fmt.Fprintln(b, "\nimu:")
+	if m, ok := first(d.IMU, b); ok {
+		fmt.Fprintf(b, "  enabled: true\n  device: %s\n  baud: %d\n", m.Device, m.Baud)
+	} else {
+		fmt.Fprintln(b, "  enabled: false\n  device: sim")
+	}
+
+	fmt.Fprintln(b, "\nlidar:")
+	if len(d.Lidars) > 0 {
+		l := d.Lidars[0]
+		if l.Product != "" {
+			fmt.Fprintf(b, "  # %s sending from %s\n", l.Product, l.Sender)
+		} else {
+			fmt.Fprintf(b, "  # raw points from %s\n", l.Sender)
+		}
+		fmt.Fprintf(b, "  enabled: true\n  address: 0.0.0.0:%d\n  format: %s\n", l.Port, l.Format)
+	} else {
+		fmt.Fprintln(b, "  enabled: false\n  address: sim")
+	}
+
+	fmt.Fprintln(b, "\nradar:")
+	i := slices.IndexFunc(d.Radars, func(r FoundRadar) bool { return r.SensorID >= 0 })
+	switch {
+	case i >= 0:
+		r := d.Radars[i]
+		fmt.Fprintf(b, "  enabled: true\n  address: %s\n  protocol: %s\n  sensor_id: %d\n", r.Interface, utils.RadarARS408, r.SensorID)
+	case len(d.Radars) > 0:
+		fmt.Fprintf(b, "  # CAN interface %s is silent; is the radar powered?\n", d.Radars[0].Interface)
+		fmt.Fprintf(b, "  enabled: false\n  address: %s\n  protocol: %s\n", d.Radars[0].Interface, utils.RadarARS408)
+	default:
+		fmt.Fprintln(b, "  enabled: false\n  address: sim")
+	}
+
+	fmt.Fprintln(b, "\nenv:")
+	if len(d.Env) > 0 {
+		e := d.Env[0]
+		fmt.Fprintf(b, "  enabled: true\n  transport: %s\n  device: %s\n", e.Transport, e.Device)
+		if e.Transport == "i2c" {
+			fmt.Fprintf(b, "  i2c_address: 0x%02x\n", e.Address)
+		} else {
+			fmt.Fprintf(b, "  baud: %d\n", e.Baud)
+		}
+	} else {
+		fmt.Fprintln(b, "  enabled: false\n  device: sim")
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// first returns the first port found, noting the others as comments.
+func first(found []FoundSerial, b *bytes.Buffer) (FoundSerial, bool) {
+	if len(found) == 0 {
+		return FoundSerial{}, false
+	}
+	for _, s := range found[1:] {
+		fmt.Fprintf(b, "  # also on %s at %d baud\n", s.Device, s.Baud)
+	}
+	return found[0], true
+}
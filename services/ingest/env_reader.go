@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// EnvReader samples a BME280-style environment sensor at a low rate. Over
+// I2C the sensor is polled directly; over serial a bridge is expected to
+// print one "T=<°C>,H=<%>,P=<hPa>" line per reading.
+type EnvReader struct {
+	cfg    utils.EnvConfig
+	log    utils.Logger
+	remote <-chan models.EnvData
+	counters
+}
+
+func NewEnvReader(cfg utils.EnvConfig, log utils.Logger, clock utils.Clock) *EnvReader {
+	cfg.RateHz = checkRate(log, "env", cfg.RateHz)
+	r := &EnvReader{cfg: cfg, log: log, counters: counters{buffer: cfg.BufferSize, clock: clock}}
+	r.decimate(cfg.Decimate)
+	return r
+}
+
+func (r *EnvReader) Name() string { return "env" }
+
+// UseRemote makes the reader publish readings received from a remote agent.
+func (r *EnvReader) UseRemote(in <-chan models.EnvData) { r.remote = in }
+
+func (r *EnvReader) Run(ctx context.Context) error {
+	if r.remote != nil {
+		return forward(ctx, r.remote, &r.counters)
+	}
+	if r.cfg.Device == utils.SimDevice {
+		return r.poll(ctx, simEnv)
+	}
+	switch r.cfg.Transport {
+	case "i2c":
+		s, err := openBME280(r.cfg.Device, r.cfg.I2CAddress)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		return r.poll(ctx, s.read)
+	case "serial":
+		return r.runSerial(ctx)
+	}
+	return fmt.Errorf("unsupported env transport %q", r.cfg.Transport)
+}
+
+// poll calls read at the configured rate.
+func (r *EnvReader) poll(ctx context.Context, read func() (float64, float64, float64, error)) error {
+	ticker := utils.NewRateTicker(r.clock, r.cfg.RateHz)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !r.simTick() {
+			continue
+		}
+		t, h, p, err := read()
+		if err != nil {
+			return err
+		}
+		emit(models.EnvData{Timestamp: r.clock.Now(), TemperatureC: t, HumidityPct: h, PressureHPa: p}, &r.counters)
+	}
+}
+
+func (r *EnvReader) runSerial(ctx context.Context) error {
+	port, err := OpenSerial(r.cfg.Device, r.cfg.Baud)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		port.Close()
+	}()
+
+	sc := bufio.NewScanner(port)
+	for sc.Scan() {
+		d, err := parseEnvLine(sc.Text())
+		if err != nil {
+			r.log.Debugf("env: %v", err)
+			continue
+		}
+		d.Timestamp = r.clock.Now()
+		emit(d, &r.counters)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("env read: %w", err)
+	}
+	return fmt.Errorf("env: %s closed", r.cfg.Device)
+}
+
+func parseEnvLine(line string) (models.EnvData, error) {
+	var d models.EnvData
+	var seen int
+	for _, kv := range strings.Split(strings.TrimSpace(line), ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return d, fmt.Errorf("malformed field %q in %q", kv, line)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return d, fmt.Errorf("bad value %q in %q", v, line)
+		}
+		switch strings.ToUpper(strings.TrimSpace(k)) {
+		case "T":
+			d.TemperatureC = f
+		case "H":
+			d.HumidityPct = f
+		case "P":
+			d.PressureHPa = f
+		default:
+			continue
+		}
+		seen++
+	}
+	if seen != 3 {
+		return d, fmt.Errorf("incomplete reading %q", line)
+	}
+	return d, nil
+}
+
+func simEnv() (float64, float64, float64, error) {
+	return 24 + rand.NormFloat64()*0.1, 45 + rand.NormFloat64()*0.5, 1013.25 + rand.NormFloat64()*0.2, nil
+}
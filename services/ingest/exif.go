@@ -0,0 +1,95 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// EXIF tags carrying exposure information.
+const (
+	tagExifIFD      = 0x8769
+	tagExposureTime = 0x829a
+	tagISOSpeed     = 0x8827
+)
+
+// exifExposure extracts the exposure time (in microseconds) and the ISO
+// speed, converted to a gain in dB relative to ISO 100, from the EXIF APP1
+// segment of a JPEG. Values not present in the frame are nil.
+func exifExposure(jpg []byte) (exposureUs, gainDB *float64) {
+	tiff := exifSegment(jpg)
+	if len(tiff) < 8 {
+		return nil, nil
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, nil
+	}
+	ifd0 := readIFD(tiff, bo, bo.Uint32(tiff[4:]))
+	exif, ok := ifd0[tagExifIFD]
+	if !ok {
+		return nil, nil
+	}
+	tags := readIFD(tiff, bo, bo.Uint32(exif[8:]))
+
+	if e, ok := tags[tagExposureTime]; ok && bo.Uint16(e[2:]) == 5 {
+		off := bo.Uint32(e[8:])
+		if int(off)+8 <= len(tiff) {
+			num, den := bo.Uint32(tiff[off:]), bo.Uint32(tiff[off+4:])
+			if den != 0 {
+				v := 1e6 * float64(num) / float64(den)
+				exposureUs = &v
+			}
+		}
+	}
+	if e, ok := tags[tagISOSpeed]; ok && bo.Uint16(e[2:]) == 3 {
+		if iso := bo.Uint16(e[8:]); iso > 0 {
+			v := 20 * math.Log10(float64(iso)/100)
+			gainDB = &v
+		}
+	}
+	return exposureUs, gainDB
+}
+
+// exifSegment returns the TIFF structure inside the JPEG's EXIF APP1
+// segment, or nil.
+func exifSegment(jpg []byte) []byte {
+	if len(jpg) < 4 || jpg[0] != 0xff || jpg[1] != 0xd8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(jpg) && jpg[i] == 0xff; {
+		marker := jpg[i+1]
+		size := int(binary.BigEndian.Uint16(jpg[i+2:]))
+		if marker == 0xda || i+2+size > len(jpg) {
+			// Start of scan: no more metadata segments.
+			return nil
+		}
+		seg := jpg[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:]
+		}
+		i += 2 + size
+	}
+	return nil
+}
+
+// readIFD returns the 12-byte entries of the IFD at off, keyed by tag.
+func readIFD(tiff []byte, bo binary.ByteOrder, off uint32) map[uint16][]byte {
+	entries := map[uint16][]byte{}
+	if int(off)+2 > len(tiff) {
+		return entries
+	}
+	n := int(bo.Uint16(tiff[off:]))
+	p := int(off) + 2
+	for i := 0; i < n && p+12 <= len(tiff); i++ {
+		e := tiff[p : p+12]
+		entries[bo.Uint16(e)] = e
+		p += 12
+	}
+	return entries
+}
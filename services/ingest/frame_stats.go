@@ -0,0 +1,97 @@
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+// Luminance levels counted as crushed shadows and blown highlights.
+const (
+	darkLevel   = 5
+	brightLevel = 250
+)
+
+// imageStats decodes a JPEG frame and summarises its luminance histogram
+// and sharpness.
+func imageStats(data []byte) (*models.ImageStats, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	var (
+		pix    []uint8
+		stride int
+	)
+	b := img.Bounds()
+	switch m := img.(type) {
+	case *image.Gray:
+		pix, stride = m.Pix, m.Stride
+	case *image.YCbCr:
+		pix, stride = m.Y, m.YStride
+	default:
+		return nil, fmt.Errorf("unsupported color model %T", img)
+	}
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return nil, fmt.Errorf("frame too small (%dx%d)", w, h)
+	}
+
+	var hist [256]int
+	var sum float64
+	for y := 0; y < h; y++ {
+		for _, v := range pix[y*stride : y*stride+w] {
+			hist[v]++
+			sum += float64(v)
+		}
+	}
+	n := w * h
+	s := &models.ImageStats{
+		MeanLum: sum / float64(n),
+		P05Lum:  percentile(&hist, n, 0.05),
+		P50Lum:  percentile(&hist, n, 0.50),
+		P95Lum:  percentile(&hist, n, 0.95),
+	}
+	var dark, bright int
+	for v := 0; v <= darkLevel; v++ {
+		dark += hist[v]
+	}
+	for v := brightLevel; v < 256; v++ {
+		bright += hist[v]
+	}
+	s.DarkPct = 100 * float64(dark) / float64(n)
+	s.BrightPct = 100 * float64(bright) / float64(n)
+
+	// Variance of the 4-neighbour Laplacian over the interior pixels.
+	var lsum, lsq float64
+	for y := 1; y < h-1; y++ {
+		row := y * stride
+		for x := 1; x < w-1; x++ {
+			i := row + x
+			l := float64(pix[i-1]) + float64(pix[i+1]) + float64(pix[i-stride]) + float64(pix[i+stride]) - 4*float64(pix[i])
+			lsum += l
+			lsq += l * l
+		}
+	}
+	m := float64((w - 2) * (h - 2))
+	mean := lsum / m
+	s.Sharpness = lsq/m - mean*mean
+	return s, nil
+}
+
+// percentile returns the smallest level at or below which a fraction p of
+// the n pixels lie.
+func percentile(hist *[256]int, n int, p float64) float64 {
+	target := int(p * float64(n))
+	acc := 0
+	for v, c := range hist {
+		acc += c
+		if acc > target {
+			return float64(v)
+		}
+	}
+	return 255
+}
@@ -0,0 +1,43 @@
+package ingest
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+// freezeWatch follows the pictures of a camera for a frozen one. Frames
+// are compared by a hash of their encoded bytes: a camera that hangs
+// hands over the same buffer again, while a live one never encodes two
+// pictures alike, if only for the sensor noise.
+type freezeWatch struct {
+	hold time.Duration
+	seed maphash.Seed
+	hash uint64
+	// since is when the current picture was first grabbed.
+	since time.Time
+	// frozen is set once the current picture was held for hold.
+	frozen bool
+}
+
+func newFreezeWatch(hold time.Duration) *freezeWatch {
+	return &freezeWatch{hold: hold, seed: maphash.MakeSeed()}
+}
+
+// observe takes the frame data grabbed at ts and returns how long its
+// picture had been the same, 0 for a new one. frozen is true for the
+// frame with which the picture has been held for hold, moving for the
+// first new picture after that.
+func (w *freezeWatch) observe(data []byte, ts time.Time) (same time.Duration, frozen, moving bool) {
+	h := maphash.Bytes(w.seed, data)
+	if w.since.IsZero() || h != w.hash {
+		moving = w.frozen
+		w.hash, w.since, w.frozen = h, ts, false
+		return 0, false, moving
+	}
+	same = ts.Sub(w.since)
+	if !w.frozen && same >= w.hold {
+		w.frozen = true
+		return same, true, false
+	}
+	return same, false, false
+}
@@ -0,0 +1,334 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/ntrip"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const knotsToMps = 0.514444
+
+// GPSReader reads a serial receiver and publishes one GPSData per NMEA GGA
+// sentence, carrying speed and heading from the latest RMC, or per u-blox
+// UBX NAV-PVT solution.
+// With NTRIP enabled it also feeds the receiver RTCM corrections over the
+// same port.
+type GPSReader struct {
+	cfg utils.GPSConfig
+	log utils.Logger
+	// feed, when set, supplies the fixes instead of the device.
+	feed  <-chan models.GPSData
+	ntrip *ntrip.Client
+	counters
+}
+
+func NewGPSReader(cfg utils.GPSConfig, log utils.Logger, clock utils.Clock) *GPSReader {
+	cfg.RateHz = checkRate(log, "gps", cfg.RateHz)
+	r := &GPSReader{cfg: cfg, log: log, counters: counters{buffer: cfg.BufferSize, clock: clock}}
+	r.decimate(cfg.Decimate)
+	return r
+}
+
+func (r *GPSReader) Name() string { return "gps" }
+
+// UseRemote makes the reader publish fixes received from a remote agent.
+func (r *GPSReader) UseRemote(in <-chan models.GPSData) { r.feed = in }
+
+// UseINS makes the reader publish the fixes of a combined INS.
+func (r *GPSReader) UseINS(in <-chan models.GPSData) { r.feed = in }
+
+func (r *GPSReader) Run(ctx context.Context) error {
+	if r.feed != nil {
+		return forward(ctx, r.feed, &r.counters)
+	}
+	if r.cfg.NTRIP.Enabled {
+		c, err := ntrip.NewClient(r.cfg.NTRIP, r.log)
+		if err != nil {
+			return err
+		}
+		r.ntrip = c
+	}
+	if r.cfg.Device == utils.SimDevice {
+		if r.ntrip != nil {
+			go r.ntrip.Run(ctx, io.Discard)
+		}
+		return r.runSim(ctx)
+	}
+	port, err := OpenSerial(r.cfg.Device, r.cfg.Baud)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		port.Close()
+	}()
+	if r.ntrip != nil {
+		go r.ntrip.Run(ctx, port)
+	}
+
+	return r.read(ctx, port)
+}
+
+// read parses the receiver's output until it fails or ctx is done. NMEA
+// and UBX may be interleaved; which of them yields fixes depends on
+// cfg.Protocol.
+func (r *GPSReader) read(ctx context.Context, in io.Reader) error {
+	var (
+		fix    models.GPSData
+		ubx    = ubxDecoder{clock: r.clock}
+		useUBX = r.cfg.Protocol == utils.GPSUBX
+	)
+	br := bufio.NewReader(in)
+	for {
+		head, err := br.Peek(1)
+		if err != nil {
+			return r.readErr(ctx, err)
+		}
+		switch head[0] {
+		case '$':
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return r.readErr(ctx, err)
+			}
+			if !useUBX {
+				r.parseNMEA(strings.TrimSpace(line), &fix)
+			}
+		case ubxSync1:
+			f, err := readUBX(br)
+			if errors.Is(err, errUBXFrame) {
+				r.log.Debugf("gps: %v", err)
+				continue
+			}
+			if err != nil {
+				return r.readErr(ctx, err)
+			}
+			if r.cfg.Protocol == utils.GPSNMEA {
+				continue
+			}
+			if !useUBX && f.class == ubxClassNAV && f.id == ubxNavPVT {
+				r.log.Infof("gps: receiver sends UBX NAV-PVT, ignoring NMEA")
+				useUBX = true
+			}
+			g, ok, err := ubx.decode(f)
+			if err != nil {
+				r.log.Debugf("gps: %v", err)
+			}
+			if ok {
+				r.publish(g, "")
+			}
+		default:
+			br.Discard(1)
+		}
+	}
+}
+
+func (r *GPSReader) parseNMEA(line string, fix *models.GPSData) {
+	switch {
+	case isSentence(line, "RMC"):
+		if err := parseRMC(line, fix); err != nil {
+			r.log.Debugf("gps: %v", err)
+		}
+	case isSentence(line, "GGA"):
+		if err := parseGGA(line, fix); err != nil {
+			r.log.Debugf("gps: %v", err)
+			return
+		}
+		fix.Timestamp = r.clock.Now()
+		r.publish(*fix, line)
+	}
+}
+
+// publish emits fix and reports its position to the NTRIP caster, as the
+// receiver's GGA sentence gga or, if empty, one made from fix.
+func (r *GPSReader) publish(fix models.GPSData, gga string) {
+	if r.ntrip != nil {
+		if gga == "" {
+			gga = formatGGA(fix)
+		}
+		r.ntrip.SetPosition(gga)
+	}
+	emit(fix, &r.counters)
+}
+
+func (r *GPSReader) readErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err == io.EOF {
+		return fmt.Errorf("gps: %s closed", r.cfg.Device)
+	}
+	return fmt.Errorf("gps read: %w", err)
+}
+
+// runSim drives a 100 m radius circle at 10 m/s. With NTRIP enabled the
+// corrections are discarded, but the fix is reported as RTK fixed while
+// they keep arriving, and its GGA position is sent to the caster.
+func (r *GPSReader) runSim(ctx context.Context) error {
+	const (
+		lat0, lon0 = 29.8649, 77.8966
+		radius     = 100.0
+		speed      = 10.0
+	)
+	ticker := utils.NewRateTicker(r.clock, r.cfg.RateHz)
+	defer ticker.Stop()
+	start := r.clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !r.simTick() {
+			continue
+		}
+		now := r.clock.Now()
+		theta := now.Sub(start).Seconds() * speed / radius
+		north, east := radius*math.Sin(theta), radius*(1-math.Cos(theta))
+		fix := models.GPSData{
+			Timestamp:  now,
+			Lat:        lat0 + north/111320.0,
+			Lon:        lon0 + east/(111320.0*math.Cos(lat0*math.Pi/180)),
+			Alt:        268,
+			SpeedMps:   speed,
+			HeadingDeg: math.Mod(theta*180/math.Pi, 360),
+			HDOP:       0.9,
+			Satellites: 12,
+			FixQuality: 1,
+		}
+		if r.ntrip != nil {
+			if age, ok := r.ntrip.Age(); ok && age < simCorrectionTimeout {
+				fix.FixQuality = 4
+				s := age.Seconds()
+				fix.CorrectionAgeS = &s
+			}
+		}
+		r.publish(fix, "")
+	}
+}
+
+// simCorrectionTimeout is how long the simulated receiver keeps its RTK
+// fix after the last correction.
+const simCorrectionTimeout = 10 * time.Second
+
+// formatGGA renders fix as a GGA sentence, the form in which NTRIP casters
+// take the rover position.
+func formatGGA(fix models.GPSData) string {
+	coord := func(deg float64, width int, pos, neg string) string {
+		hemi := pos
+		if deg < 0 {
+			deg, hemi = -deg, neg
+		}
+		d := math.Floor(deg)
+		return fmt.Sprintf("%0*.0f%07.4f,%s", width, d, (deg-d)*60, hemi)
+	}
+	t := fix.Timestamp.UTC()
+	body := fmt.Sprintf("GPGGA,%02d%02d%05.2f,%s,%s,%d,%02d,%.1f,%.1f,M,0.0,M,,",
+		t.Hour(), t.Minute(), float64(t.Second())+float64(t.Nanosecond())/1e9,
+		coord(fix.Lat, 2, "N", "S"), coord(fix.Lon, 3, "E", "W"),
+		fix.FixQuality, fix.Satellites, fix.HDOP, fix.Alt)
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X", body, sum)
+}
+
+// isSentence reports whether line is an NMEA sentence of the given type
+// from any talker (GP, GN, GL, ...).
+func isSentence(line, kind string) bool {
+	return len(line) > 6 && line[0] == '$' && line[3:6] == kind
+}
+
+// nmeaFields validates the checksum, if present, and splits the sentence body.
+func nmeaFields(line string) ([]string, error) {
+	body := line[1:]
+	if i := strings.IndexByte(body, '*'); i >= 0 {
+		want, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bad checksum in %q", line)
+		}
+		var sum byte
+		for j := 0; j < i; j++ {
+			sum ^= body[j]
+		}
+		if uint64(sum) != want {
+			return nil, fmt.Errorf("checksum mismatch in %q", line)
+		}
+		body = body[:i]
+	}
+	return strings.Split(body, ","), nil
+}
+
+func parseGGA(line string, fix *models.GPSData) error {
+	f, err := nmeaFields(line)
+	if err != nil {
+		return err
+	}
+	if len(f) < 10 {
+		return fmt.Errorf("short GGA %q", line)
+	}
+	fix.FixQuality, _ = strconv.Atoi(f[6])
+	if fix.FixQuality == 0 {
+		return fmt.Errorf("no fix")
+	}
+	if fix.Lat, err = parseCoord(f[2], f[3]); err != nil {
+		return err
+	}
+	if fix.Lon, err = parseCoord(f[4], f[5]); err != nil {
+		return err
+	}
+	fix.Satellites, _ = strconv.Atoi(f[7])
+	fix.HDOP, _ = strconv.ParseFloat(f[8], 64)
+	fix.Alt, _ = strconv.ParseFloat(f[9], 64)
+	fix.CorrectionAgeS = nil
+	if len(f) > 13 {
+		if age, err := strconv.ParseFloat(f[13], 64); err == nil {
+			fix.CorrectionAgeS = &age
+		}
+	}
+	return nil
+}
+
+func parseRMC(line string, fix *models.GPSData) error {
+	f, err := nmeaFields(line)
+	if err != nil {
+		return err
+	}
+	if len(f) < 9 {
+		return fmt.Errorf("short RMC %q", line)
+	}
+	if f[2] != "A" {
+		return fmt.Errorf("RMC not valid")
+	}
+	if knots, err := strconv.ParseFloat(f[7], 64); err == nil {
+		fix.SpeedMps = knots * knotsToMps
+	}
+	if course, err := strconv.ParseFloat(f[8], 64); err == nil {
+		fix.HeadingDeg = course
+	}
+	return nil
+}
+
+// parseCoord converts NMEA ddmm.mmmm / dddmm.mmmm plus hemisphere to degrees.
+func parseCoord(v, hemi string) (float64, error) {
+	raw, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad coordinate %q", v)
+	}
+	deg := math.Floor(raw / 100)
+	deg += (raw - deg*100) / 60
+	if hemi == "S" || hemi == "W" {
+		deg = -deg
+	}
+	return deg, nil
+}
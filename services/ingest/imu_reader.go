@@ -0,0 +1,130 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const gravity = 9.80665
+
+// IMUReader reads comma-separated "ax,ay,az,gx,gy,gz[,mx,my,mz]" lines from
+// a serial IMU bridge and publishes them on its Mux.
+type IMUReader struct {
+	cfg utils.IMUConfig
+	log utils.Logger
+	// feed, when set, supplies the samples instead of the device.
+	feed <-chan models.IMUData
+
+	// Last sequence number, kept across runs.
+	seq uint64
+	counters
+}
+
+func NewIMUReader(cfg utils.IMUConfig, log utils.Logger, clock utils.Clock) *IMUReader {
+	cfg.RateHz = checkRate(log, "imu", cfg.RateHz)
+	r := &IMUReader{cfg: cfg, log: log, counters: counters{buffer: cfg.BufferSize, clock: clock}}
+	r.decimate(cfg.Decimate)
+	return r
+}
+
+func (r *IMUReader) Name() string { return "imu" }
+
+// UseRemote makes the reader publish samples received from a remote agent.
+func (r *IMUReader) UseRemote(in <-chan models.IMUData) { r.feed = in }
+
+// UseINS makes the reader publish the inertial samples of a combined INS.
+func (r *IMUReader) UseINS(in <-chan models.IMUData) { r.feed = in }
+
+func (r *IMUReader) Run(ctx context.Context) error {
+	if r.feed != nil {
+		return forward(ctx, r.feed, &r.counters)
+	}
+	if r.cfg.Device == utils.SimDevice {
+		return r.runSim(ctx)
+	}
+	port, err := OpenSerial(r.cfg.Device, r.cfg.Baud)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		port.Close()
+	}()
+
+	sc := bufio.NewScanner(port)
+	for sc.Scan() {
+		d, err := parseIMULine(sc.Text())
+		if err != nil {
+			r.log.Debugf("imu: %v", err)
+			continue
+		}
+		r.seq++
+		d.Seq = r.seq
+		d.Timestamp = r.clock.Now()
+		emit(d, &r.counters)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("imu read: %w", err)
+	}
+	return fmt.Errorf("imu: %s closed", r.cfg.Device)
+}
+
+func (r *IMUReader) runSim(ctx context.Context) error {
+	ticker := utils.NewRateTicker(r.clock, r.cfg.RateHz)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !r.simTick() {
+			continue
+		}
+		r.seq++
+		emit(models.IMUData{
+			Timestamp: r.clock.Now(),
+			Seq:       r.seq,
+			AccelX:    rand.NormFloat64() * 0.05,
+			AccelY:    rand.NormFloat64() * 0.05,
+			AccelZ:    gravity + rand.NormFloat64()*0.05,
+			GyroX:     rand.NormFloat64() * 0.002,
+			GyroY:     rand.NormFloat64() * 0.002,
+			GyroZ:     0.1 + rand.NormFloat64()*0.002,
+			MagX:      30 * math.Cos(float64(r.seq)*0.001),
+			MagY:      -30 * math.Sin(float64(r.seq)*0.001),
+			MagZ:      -35,
+		}, &r.counters)
+	}
+}
+
+func parseIMULine(line string) (models.IMUData, error) {
+	parts := strings.Split(strings.TrimSpace(line), ",")
+	if len(parts) != 6 && len(parts) != 9 {
+		return models.IMUData{}, fmt.Errorf("expected 6 or 9 fields, got %d in %q", len(parts), line)
+	}
+	v := make([]float64, 9)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return models.IMUData{}, fmt.Errorf("bad field %q in %q", p, line)
+		}
+		v[i] = f
+	}
+	return models.IMUData{
+		AccelX: v[0], AccelY: v[1], AccelZ: v[2],
+		GyroX: v[3], GyroY: v[4], GyroZ: v[5],
+		MagX: v[6], MagY: v[7], MagZ: v[8],
+	}, nil
+}
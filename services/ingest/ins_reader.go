@@ -0,0 +1,372 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// vnModes names the modes in bits 0-1 of a VectorNav INS status; bit 2
+// tells whether the GNSS fix is used.
+var vnModes = []string{"not_tracking", "aligning", "tracking", "gnss_lost"}
+
+const vnGNSSFix = 1 << 2
+
+// INSReader reads a combined INS, a VectorNav (ASCII $VNINS, $VNIMU and
+// $VNYMR) or an SBG (sbgECom EKF and IMU logs), on one serial port. Its
+// navigation solutions are published on its Mux; its inertial samples
+// and, once it has a position, fixes made of the solution are handed to
+// the imu and gps readers configured with device "ins", as a
+// RemoteSource hands them those of an agent.
+type INSReader struct {
+	cfg utils.INSConfig
+	log utils.Logger
+	imu chan models.IMUData
+	gps chan models.GPSData
+
+	// Last sequence numbers, kept across runs.
+	seq, imuSeq uint64
+	counters
+}
+
+// insFix is the position of a solution, in degrees and metres.
+type insFix struct {
+	lat, lon, alt float64
+	vAccM         *float64
+}
+
+func NewINSReader(cfg utils.INSConfig, log utils.Logger, clock utils.Clock) *INSReader {
+	cfg.RateHz = checkRate(log, "ins", cfg.RateHz)
+	r := &INSReader{
+		cfg:      cfg,
+		log:      log,
+		imu:      make(chan models.IMUData, cfg.BufferSize),
+		gps:      make(chan models.GPSData, cfg.BufferSize),
+		counters: counters{buffer: cfg.BufferSize, clock: clock},
+	}
+	r.decimate(cfg.Decimate)
+	return r
+}
+
+func (r *INSReader) Name() string { return "ins" }
+
+func (r *INSReader) IMU() <-chan models.IMUData { return r.imu }
+func (r *INSReader) GPS() <-chan models.GPSData { return r.gps }
+
+// Close closes the imu and gps channels, which ends the forwarding of the
+// readers that use them, and leaves the Mux.
+func (r *INSReader) Close() {
+	close(r.imu)
+	close(r.gps)
+	r.counters.Close()
+}
+
+func (r *INSReader) Run(ctx context.Context) error {
+	if r.cfg.Device == utils.SimDevice {
+		return r.runSim(ctx)
+	}
+	port, err := OpenSerial(r.cfg.Device, r.cfg.Baud)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		port.Close()
+	}()
+
+	br := bufio.NewReader(port)
+	if r.cfg.Protocol == utils.INSSBG {
+		err = r.readSBG(br)
+	} else {
+		err = r.readVectorNav(br)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err == io.EOF {
+		return fmt.Errorf("ins: %s closed", r.cfg.Device)
+	}
+	return fmt.Errorf("ins read: %w", err)
+}
+
+func (r *INSReader) readVectorNav(br *bufio.Reader) error {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		i := strings.Index(line, "$VN")
+		if i < 0 {
+			continue
+		}
+		if err := r.parseVectorNav(strings.TrimSpace(line[i:])); err != nil {
+			r.log.Debugf("ins: %v", err)
+		}
+	}
+}
+
+func (r *INSReader) parseVectorNav(line string) error {
+	f, err := vnFields(line)
+	if err != nil {
+		return err
+	}
+	switch f[0] {
+	case "VNINS":
+		if len(f) != 16 {
+			return fmt.Errorf("expected 16 fields, got %d in %q", len(f), line)
+		}
+		status, err := strconv.ParseUint(strings.TrimPrefix(f[3], "0x"), 16, 16)
+		if err != nil {
+			return fmt.Errorf("bad status %q in %q", f[3], line)
+		}
+		v, err := vnFloats(f[4:], line)
+		if err != nil {
+			return err
+		}
+		sol := models.INSData{
+			Timestamp: r.clock.Now(),
+			Mode:      vnModes[status&3],
+			Status:    uint32(status),
+			GNSSFix:   status&vnGNSSFix != 0,
+			YawDeg:    wrapDegrees(v[0]),
+			PitchDeg:  v[1],
+			RollDeg:   v[2],
+			VelN:      v[6],
+			VelE:      v[7],
+			VelD:      v[8],
+			AttAccDeg: v[9],
+			PosAccM:   v[10],
+			VelAccMps: v[11],
+		}
+		var pos *insFix
+		if status&3 != 0 {
+			pos = &insFix{lat: v[3], lon: v[4], alt: v[5]}
+		}
+		r.publish(sol, pos)
+	case "VNIMU":
+		if len(f) != 12 {
+			return fmt.Errorf("expected 12 fields, got %d in %q", len(f), line)
+		}
+		v, err := vnFloats(f[1:10], line)
+		if err != nil {
+			return err
+		}
+		r.publishIMU(imuSample([3]float64(v[3:6]), [3]float64(v[6:9]), [3]float64{v[0] * 100, v[1] * 100, v[2] * 100}))
+	case "VNYMR":
+		// The attitude comes with $VNINS; only the sensors are taken.
+		if len(f) != 13 {
+			return fmt.Errorf("expected 13 fields, got %d in %q", len(f), line)
+		}
+		v, err := vnFloats(f[4:13], line)
+		if err != nil {
+			return err
+		}
+		r.publishIMU(imuSample([3]float64(v[3:6]), [3]float64(v[6:9]), [3]float64{v[0] * 100, v[1] * 100, v[2] * 100}))
+	}
+	return nil
+}
+
+// vnFields checks the checksum of a VectorNav ASCII message, an 8-bit XOR
+// as in NMEA or a 16-bit CRC, and splits it after the "$".
+func vnFields(line string) ([]string, error) {
+	body, sum, ok := strings.Cut(line[1:], "*")
+	if !ok || len(sum) != 4 {
+		return nmeaFields(line)
+	}
+	want, err := strconv.ParseUint(sum, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad checksum in %q", line)
+	}
+	if uint64(vnCRC(body)) != want {
+		return nil, fmt.Errorf("checksum mismatch in %q", line)
+	}
+	return strings.Split(body, ","), nil
+}
+
+// vnCRC is the 16-bit CRC of VectorNav messages (CRC-16-CCITT from 0).
+func vnCRC(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc>>8 | crc<<8
+		crc ^= uint16(s[i])
+		crc ^= (crc & 0xff) >> 4
+		crc ^= crc << 12
+		crc ^= (crc & 0xff) << 5
+	}
+	return crc
+}
+
+func vnFloats(f []string, line string) ([]float64, error) {
+	v := make([]float64, len(f))
+	for i, s := range f {
+		x, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad field %q in %q", s, line)
+		}
+		v[i] = x
+	}
+	return v, nil
+}
+
+func (r *INSReader) readSBG(br *bufio.Reader) error {
+	sbg := sbgDecoder{clock: r.clock}
+	for {
+		head, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		if head[0] != sbgSync1 {
+			br.Discard(1)
+			continue
+		}
+		f, err := readSBG(br)
+		if errors.Is(err, errSBGFrame) {
+			r.log.Debugf("ins: %v", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		imu, sol, pos, err := sbg.decode(f)
+		if err != nil {
+			r.log.Debugf("ins: %v", err)
+		}
+		if imu != nil {
+			r.publishIMU(*imu)
+		}
+		if sol != nil {
+			r.publish(*sol, pos)
+		}
+	}
+}
+
+// runSim drives the circle of the simulated GPS, level, with the unit
+// tracking a GNSS fix.
+func (r *INSReader) runSim(ctx context.Context) error {
+	const (
+		lat0, lon0 = 29.8649, 77.8966
+		radius     = 100.0
+		speed      = 10.0
+	)
+	ticker := utils.NewRateTicker(r.clock, r.cfg.RateHz)
+	defer ticker.Stop()
+	start := r.clock.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !r.simTick() {
+			continue
+		}
+		theta := r.clock.Now().Sub(start).Seconds() * speed / radius
+		north, east := radius*math.Sin(theta), radius*(1-math.Cos(theta))
+		// Turning clockwise: the centripetal force is to the right.
+		r.publishIMU(models.IMUData{
+			AccelX: rand.NormFloat64() * 0.05,
+			AccelY: -speed*speed/radius + rand.NormFloat64()*0.05,
+			AccelZ: gravity + rand.NormFloat64()*0.05,
+			GyroX:  rand.NormFloat64() * 0.002,
+			GyroY:  rand.NormFloat64() * 0.002,
+			GyroZ:  -speed/radius + rand.NormFloat64()*0.002,
+		})
+		r.publish(models.INSData{
+			Timestamp: r.clock.Now(),
+			Mode:      vnModes[2],
+			Status:    2 | vnGNSSFix,
+			GNSSFix:   true,
+			YawDeg:    math.Mod(theta*180/math.Pi, 360),
+			VelN:      speed * math.Cos(theta),
+			VelE:      speed * math.Sin(theta),
+			AttAccDeg: 0.1,
+			PosAccM:   0.5,
+			VelAccMps: 0.05,
+		}, &insFix{
+			lat: lat0 + north/111320.0,
+			lon: lon0 + east/(111320.0*math.Cos(lat0*math.Pi/180)),
+			alt: 268,
+		})
+	}
+}
+
+// publish emits the solution and, with pos, hands the gps reader a fix of
+// it.
+func (r *INSReader) publish(sol models.INSData, pos *insFix) {
+	r.seq++
+	sol.Seq = r.seq
+	emit(sol, &r.counters)
+	if pos == nil {
+		return
+	}
+	fix := models.GPSData{
+		Timestamp:  sol.Timestamp,
+		Lat:        pos.lat,
+		Lon:        pos.lon,
+		Alt:        pos.alt,
+		SpeedMps:   math.Hypot(sol.VelN, sol.VelE),
+		HeadingDeg: wrapDegrees(math.Atan2(sol.VelE, sol.VelN) * 180 / math.Pi),
+		FixQuality: 6, // dead reckoning
+		VAccM:      pos.vAccM,
+	}
+	if sol.GNSSFix {
+		fix.FixQuality = 1
+	}
+	if sol.PosAccM > 0 {
+		hAcc := sol.PosAccM
+		fix.HAccM = &hAcc
+	}
+	if sol.VelAccMps > 0 {
+		sAcc := sol.VelAccMps
+		fix.SpeedAccMps = &sAcc
+	}
+	handOff(r.gps, fix, &r.counters)
+}
+
+// publishIMU hands d, stamped now, to the imu reader.
+func (r *INSReader) publishIMU(d models.IMUData) {
+	r.imuSeq++
+	d.Seq = r.imuSeq
+	d.Timestamp = r.clock.Now()
+	handOff(r.imu, d, &r.counters)
+}
+
+// handOff gives v to the reader of ch without blocking; a sample that
+// reader has no room for is counted as dropped by the INS.
+func handOff[T any](ch chan T, v T, c *counters) {
+	select {
+	case ch <- v:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// imuSample makes an IMU sample of readings on the forward, right and
+// down axes of an INS, turned to the forward, left and up axes of the
+// vehicle frame. Adding 0 keeps the absent magnetometer of an SBG from
+// reading -0.
+func imuSample(accel, gyro, mag [3]float64) models.IMUData {
+	return models.IMUData{
+		AccelX: accel[0], AccelY: -accel[1] + 0, AccelZ: -accel[2] + 0,
+		GyroX: gyro[0], GyroY: -gyro[1] + 0, GyroZ: -gyro[2] + 0,
+		MagX: mag[0], MagY: -mag[1] + 0, MagZ: -mag[2] + 0,
+	}
+}
+
+// wrapDegrees brings an angle into [0, 360).
+func wrapDegrees(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
@@ -0,0 +1,262 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	maxLidarDatagram = 65535
+	// maxSweepPackets ends a sweep whose head does not seem to turn; a
+	// revolution at the slowest 300 rpm in dual-return mode is 302 packets.
+	maxSweepPackets = 400
+	usPerHour       = 3600 * 1e6
+)
+
+// LidarReader receives point packets over UDP, or replays them from a
+// packet capture. In the raw format every datagram is published as one
+// LidarPacket of 16-byte points; in the vlp16 format Velodyne data packets
+// are decoded and published one LidarPacket per revolution of the head,
+// timestamped at its first firing.
+type LidarReader struct {
+	cfg    utils.LidarConfig
+	log    utils.Logger
+	remote <-chan models.LidarPacket
+	// capture, if set, is handed every datagram.
+	capture Capture
+
+	// Last packet sequence number; a restarted reader continues it.
+	seq uint64
+	// Warnings logged once per reader: a datagram that could not be
+	// decoded, and a sensor in another return mode than configured.
+	warnedDecode, warnedMode bool
+	// replayed is set once the capture of a pcap: address has been
+	// played to its end; it is not played again.
+	replayed bool
+	counters
+}
+
+func NewLidarReader(cfg utils.LidarConfig, log utils.Logger, clock utils.Clock) *LidarReader {
+	cfg.RateHz = checkRate(log, "lidar", cfg.RateHz)
+	r := &LidarReader{cfg: cfg, log: log, counters: counters{buffer: cfg.BufferSize, clock: clock}}
+	r.decimate(cfg.Decimate)
+	return r
+}
+
+func (r *LidarReader) Name() string { return "lidar" }
+
+// UseRemote makes the reader publish packets received from a remote agent.
+func (r *LidarReader) UseRemote(in <-chan models.LidarPacket) { r.remote = in }
+
+// UseCapture hands the datagrams of a lidar on the network to c as they
+// arrive.
+func (r *LidarReader) UseCapture(c Capture) { r.capture = c }
+
+func (r *LidarReader) Run(ctx context.Context) error {
+	if r.remote != nil {
+		return forward(ctx, r.remote, &r.counters)
+	}
+	if r.cfg.Address == utils.SimDevice {
+		return r.runSim(ctx)
+	}
+	if path, ok := strings.CutPrefix(r.cfg.Address, utils.PcapPrefix); ok {
+		return r.runPcap(ctx, path)
+	}
+	conn, err := net.ListenPacket("udp", r.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("lidar listen %s: %w", r.cfg.Address, err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, maxLidarDatagram)
+	var local netip.AddrPort
+	if a, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		local = a.AddrPort()
+	}
+	var sweep lidarSweep
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("lidar read: %w", err)
+		}
+		ts := r.clock.Now()
+		if a, ok := from.(*net.UDPAddr); ok && r.capture != nil {
+			r.capture(ts, udpPacket(a.AddrPort(), local, buf[:n]))
+		}
+		r.datagram(&sweep, buf[:n], ts, ts)
+	}
+}
+
+// datagram publishes the points of b, received at ts, or in the vlp16
+// format adds them to sweep. The sensor clock is dated by the host clock
+// at captured, which is ts unless b is replayed.
+func (r *LidarReader) datagram(sweep *lidarSweep, b []byte, ts, captured time.Time) {
+	if r.cfg.Format == utils.LidarVLP16 {
+		pkt, ok := r.decode(b)
+		if !ok {
+			return
+		}
+		// The head passed zero azimuth: the sweep is complete.
+		if sweep.packets > 0 && (pkt.azimuth < sweep.azimuth || sweep.packets >= maxSweepPackets) {
+			r.emitSweep(sweep)
+		}
+		sweep.add(pkt, ts, captured)
+		return
+	}
+	r.seq++
+	raw := make([]byte, len(b))
+	copy(raw, b)
+	emit(models.LidarPacket{
+		Timestamp: ts,
+		Seq:       r.seq,
+		NumPoints: len(b) / models.LidarPointSize,
+		RawCloud:  raw,
+	}, &r.counters)
+}
+
+// lidarSweep collects the decoded packets of one revolution.
+type lidarSweep struct {
+	start   time.Time // host time of the first firing
+	usec    uint32    // sensor time of the first firing
+	device  time.Time // usec placed in the hour nearest start
+	azimuth float64   // of the last packet
+	packets int
+	pts     []models.LidarPoint
+}
+
+// add appends the points of p, received at received, making their times
+// relative to the first firing of the sweep. The sensor clock gives the
+// spacing of the packets; the host clock only dates the sweep, and the
+// sensor clock by the hour nearest captured.
+func (s *lidarSweep) add(p vlpPacket, received, captured time.Time) {
+	if s.packets == 0 {
+		firing := -time.Duration(p.duration() * float64(time.Second))
+		s.start = received.Add(firing)
+		s.usec = p.usec
+		s.device = nearestHour(captured.Add(firing), p.usec)
+	}
+	// The sensor clock wraps at the hour.
+	dt := float32(float64((uint64(p.usec)+usPerHour-uint64(s.usec))%usPerHour) / 1e6)
+	for _, pt := range p.pts {
+		pt.Time += dt
+		s.pts = append(s.pts, pt)
+	}
+	s.azimuth = p.azimuth
+	s.packets++
+}
+
+// emitSweep publishes s unless it is empty and resets it.
+func (r *LidarReader) emitSweep(s *lidarSweep) {
+	if len(s.pts) > 0 {
+		r.seq++
+		device := s.device
+		emit(models.LidarPacket{
+			Timestamp: s.start,
+			Seq:       r.seq,
+			NumPoints: len(s.pts),
+			Format:    models.PointsXYZIRT,
+			RawCloud:  models.EncodePoints(s.pts, models.PointsXYZIRT),
+
+			DeviceTime: &device,
+		}, &r.counters)
+	}
+	*s = lidarSweep{pts: s.pts[:0]}
+}
+
+// nearestHour returns the time usec microseconds past an hour that is
+// nearest to host: the sensor clock only counts within the hour, and is
+// taken to be less than half an hour off.
+func nearestHour(host time.Time, usec uint32) time.Time {
+	t := host.Truncate(time.Hour).Add(time.Duration(usec) * time.Microsecond)
+	switch d := t.Sub(host); {
+	case d > 30*time.Minute:
+		t = t.Add(-time.Hour)
+	case d < -30*time.Minute:
+		t = t.Add(time.Hour)
+	}
+	return t
+}
+
+// decode decodes a Velodyne packet and keeps the returns of the configured
+// return mode. A sensor sending only the other single return is recorded
+// as it is.
+func (r *LidarReader) decode(b []byte) (vlpPacket, bool) {
+	pkt, err := decodeVLP16(b)
+	if err != nil {
+		if !r.warnedDecode {
+			r.log.Warnf("lidar: %v (further bad packets are skipped silently)", err)
+			r.warnedDecode = true
+		}
+		return pkt, false
+	}
+	pts, mode := pkt.pts, pkt.mode
+	var keep models.LidarReturn
+	switch r.cfg.ReturnMode {
+	case utils.ReturnStrongest:
+		keep = models.ReturnStrongest
+	case utils.ReturnLast:
+		keep = models.ReturnLast
+	default:
+		return pkt, true
+	}
+	if mode != vlpDual {
+		if len(pts) > 0 && pts[0].Return&keep == 0 && !r.warnedMode {
+			r.log.Warnf("lidar: sensor sends return mode 0x%02x, not %s returns; recording what it sends", mode, r.cfg.ReturnMode)
+			r.warnedMode = true
+		}
+		return pkt, true
+	}
+	kept := pts[:0]
+	for _, pt := range pts {
+		if pt.Return&keep != 0 {
+			kept = append(kept, pt)
+		}
+	}
+	pkt.pts = kept
+	return pkt, true
+}
+
+// runSim emits a ring of points around the sensor at the configured rate.
+func (r *LidarReader) runSim(ctx context.Context) error {
+	const pointsPerSweep = 360
+	ticker := utils.NewRateTicker(r.clock, r.cfg.RateHz)
+	defer ticker.Stop()
+	pts := make([]models.LidarPoint, pointsPerSweep)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !r.simTick() {
+			continue
+		}
+		r.seq++
+		for i := range pts {
+			a := float64(i) * math.Pi / 180
+			d := 10 + 2*math.Sin(a*4+float64(r.seq)*0.1)
+			pts[i] = models.LidarPoint{X: float32(d * math.Cos(a)), Y: float32(d * math.Sin(a)), Z: -1.5, Intensity: 50}
+		}
+		emit(models.LidarPacket{
+			Timestamp: r.clock.Now(),
+			Seq:       r.seq,
+			NumPoints: pointsPerSweep,
+			RawCloud:  models.EncodePoints(pts, models.PointsXYZI),
+		}, &r.counters)
+	}
+}
@@ -0,0 +1,373 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// pcapFile reads the packets of a capture, classic pcap (microsecond or
+// nanosecond, either byte order) or pcapng as Wireshark writes it.
+type pcapFile struct {
+	f     *os.File
+	r     *bufio.Reader
+	ng    bool
+	order binary.ByteOrder
+	// Of a classic capture: the link type and the fractions of a second
+	// of its timestamps.
+	link   uint32
+	perSec uint64
+	// Of a pcapng capture: the interfaces of the current section.
+	ifaces []pcapIface
+	buf    []byte
+}
+
+type pcapIface struct {
+	link   uint32
+	perSec uint64
+}
+
+const (
+	pcapngSection   = 0x0a0d0d0a
+	pcapngInterface = 1
+	pcapngEnhanced  = 6
+	pcapngByteOrder = 0x1a2b3c4d
+	// pcapngMaxBlock bounds the blocks read, so that a corrupt length is
+	// not taken for a huge packet.
+	pcapngMaxBlock = 16 << 20
+)
+
+func openPcap(path string) (*pcapFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &pcapFile{f: f, r: bufio.NewReaderSize(f, 256*1024)}
+	magic, err := p.r.Peek(4)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: not a packet capture", path)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(magic) == pcapngSection:
+		p.ng = true
+		return p, nil
+	case binary.LittleEndian.Uint32(magic) == 0xa1b2c3d4:
+		p.order, p.perSec = binary.LittleEndian, 1e6
+	case binary.BigEndian.Uint32(magic) == 0xa1b2c3d4:
+		p.order, p.perSec = binary.BigEndian, 1e6
+	case binary.LittleEndian.Uint32(magic) == 0xa1b23c4d:
+		p.order, p.perSec = binary.LittleEndian, 1e9
+	case binary.BigEndian.Uint32(magic) == 0xa1b23c4d:
+		p.order, p.perSec = binary.BigEndian, 1e9
+	default:
+		f.Close()
+		return nil, fmt.Errorf("%s: not a pcap or pcapng capture", path)
+	}
+	var hdr [24]byte
+	if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// The upper bits carry the FCS length of some captures.
+	p.link = p.order.Uint32(hdr[20:]) & 0xffff
+	return p, nil
+}
+
+func (p *pcapFile) Close() error { return p.f.Close() }
+
+// next returns the next packet, its link type and the time it was
+// captured. The packet is only valid until the next call. A capture cut
+// short in a packet ends before it with io.EOF.
+func (p *pcapFile) next() (time.Time, uint32, []byte, error) {
+	if p.ng {
+		return p.nextBlock()
+	}
+	var rec [16]byte
+	if _, err := io.ReadFull(p.r, rec[:]); err != nil {
+		return time.Time{}, 0, nil, eof(err)
+	}
+	n := p.order.Uint32(rec[8:])
+	if n > pcapngMaxBlock {
+		return time.Time{}, 0, nil, fmt.Errorf("packet of %d bytes", n)
+	}
+	if err := p.read(int(n)); err != nil {
+		return time.Time{}, 0, nil, err
+	}
+	sec, frac := p.order.Uint32(rec[0:]), p.order.Uint32(rec[4:])
+	ts := time.Unix(int64(sec), int64(frac)*int64(time.Second)/int64(p.perSec))
+	return ts, p.link, p.buf, nil
+}
+
+// nextBlock reads pcapng blocks up to the next enhanced packet block,
+// taking in the section headers and interfaces on the way.
+func (p *pcapFile) nextBlock() (time.Time, uint32, []byte, error) {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(p.r, hdr[:]); err != nil {
+			return time.Time{}, 0, nil, eof(err)
+		}
+		typ := binary.LittleEndian.Uint32(hdr[0:])
+		if typ == pcapngSection {
+			// The byte order of a section is known from its first field.
+			bom, err := p.r.Peek(4)
+			if err != nil {
+				return time.Time{}, 0, nil, eof(err)
+			}
+			if binary.LittleEndian.Uint32(bom) == pcapngByteOrder {
+				p.order = binary.LittleEndian
+			} else {
+				p.order = binary.BigEndian
+			}
+			p.ifaces = p.ifaces[:0]
+		} else if p.order == nil {
+			return time.Time{}, 0, nil, errors.New("pcapng block before the section header")
+		}
+		size := p.order.Uint32(hdr[4:])
+		if size < 12 || size%4 != 0 || size > pcapngMaxBlock {
+			return time.Time{}, 0, nil, fmt.Errorf("pcapng block of %d bytes", size)
+		}
+		if err := p.read(int(size) - 8); err != nil {
+			return time.Time{}, 0, nil, err
+		}
+		body := p.buf[:len(p.buf)-4]
+		switch p.order.Uint32(hdr[0:]) {
+		case pcapngInterface:
+			if len(body) < 8 {
+				return time.Time{}, 0, nil, errors.New("short pcapng interface block")
+			}
+			p.ifaces = append(p.ifaces, pcapIface{link: uint32(p.order.Uint16(body)), perSec: p.resolution(body[8:])})
+		case pcapngEnhanced:
+			if len(body) < 20 {
+				return time.Time{}, 0, nil, errors.New("short pcapng packet block")
+			}
+			id := p.order.Uint32(body[0:])
+			if int(id) >= len(p.ifaces) {
+				return time.Time{}, 0, nil, fmt.Errorf("pcapng packet of unknown interface %d", id)
+			}
+			ifc := p.ifaces[id]
+			n := p.order.Uint32(body[12:])
+			if int(n) > len(body)-20 {
+				return time.Time{}, 0, nil, errors.New("pcapng packet longer than its block")
+			}
+			t := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			hi, lo := bits.Mul64(t%ifc.perSec, uint64(time.Second))
+			ns, _ := bits.Div64(hi, lo, ifc.perSec)
+			return time.Unix(int64(t/ifc.perSec), int64(ns)), ifc.link, body[20 : 20+n], nil
+		}
+	}
+}
+
+// resolution returns the timestamp fractions per second given by the
+// if_tsresol option among opts, microseconds without it.
+func (p *pcapFile) resolution(opts []byte) uint64 {
+	for len(opts) >= 4 {
+		code, n := p.order.Uint16(opts), int(p.order.Uint16(opts[2:]))
+		opts = opts[4:]
+		if code == 0 || n > len(opts) {
+			break
+		}
+		if code == 9 && n == 1 {
+			v := opts[0]
+			if v&0x80 != 0 {
+				if v&0x7f < 64 {
+					return 1 << (v & 0x7f)
+				}
+				break
+			}
+			perSec := uint64(1)
+			for range min(v, 19) {
+				perSec *= 10
+			}
+			return perSec
+		}
+		opts = opts[(n+3)&^3:]
+	}
+	return 1e6
+}
+
+// read reads the next n bytes into p.buf.
+func (p *pcapFile) read(n int) error {
+	if cap(p.buf) < n {
+		p.buf = make([]byte, n)
+	}
+	p.buf = p.buf[:n]
+	_, err := io.ReadFull(p.r, p.buf)
+	return eof(err)
+}
+
+// eof makes the end of a capture cut short a plain end.
+func eof(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+	return err
+}
+
+// Link types that the UDP datagrams of a capture are taken from.
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+var (
+	errNotUDP     = errors.New("not a UDP datagram")
+	errFragmented = errors.New("fragmented datagram")
+)
+
+// udpDatagram returns the destination port and the payload of the UDP
+// datagram in pkt, a packet of link type link. Packets that are not UDP
+// give errNotUDP; fragments, which are not reassembled, errFragmented.
+func udpDatagram(link uint32, pkt []byte) (uint16, []byte, error) {
+	var ip []byte
+	switch link {
+	case linkRaw, linkIPv4, linkIPv6:
+		ip = pkt
+	case linkNull, linkLoop:
+		if len(pkt) < 4 {
+			return 0, nil, errNotUDP
+		}
+		ip = pkt[4:]
+	case linkEthernet:
+		i := 12
+		// Skip VLAN tags.
+		for len(pkt) >= i+2 && (binary.BigEndian.Uint16(pkt[i:]) == 0x8100 || binary.BigEndian.Uint16(pkt[i:]) == 0x88a8) {
+			i += 4
+		}
+		if len(pkt) < i+2 {
+			return 0, nil, errNotUDP
+		}
+		ip = pkt[i+2:]
+	case linkSLL:
+		if len(pkt) < 16 {
+			return 0, nil, errNotUDP
+		}
+		ip = pkt[16:]
+	case linkSLL2:
+		if len(pkt) < 20 {
+			return 0, nil, errNotUDP
+		}
+		ip = pkt[20:]
+	default:
+		return 0, nil, fmt.Errorf("link type %d is not supported", link)
+	}
+	if len(ip) < 20 {
+		return 0, nil, errNotUDP
+	}
+	var udp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		ihl := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		if ip[9] != 17 || ihl < 20 || total < ihl || total > len(ip) {
+			return 0, nil, errNotUDP
+		}
+		// More fragments, or an offset.
+		if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 {
+			return 0, nil, errFragmented
+		}
+		udp = ip[ihl:total]
+	case 6:
+		if len(ip) < 40 {
+			return 0, nil, errNotUDP
+		}
+		switch ip[6] {
+		case 17:
+		case 44:
+			return 0, nil, errFragmented
+		default:
+			return 0, nil, errNotUDP
+		}
+		udp = ip[40:min(len(ip), 40+int(binary.BigEndian.Uint16(ip[4:])))]
+	default:
+		return 0, nil, errNotUDP
+	}
+	if len(udp) < 8 {
+		return 0, nil, errNotUDP
+	}
+	n := int(binary.BigEndian.Uint16(udp[4:]))
+	if n < 8 || n > len(udp) {
+		return 0, nil, errNotUDP
+	}
+	return binary.BigEndian.Uint16(udp[2:]), udp[8:n], nil
+}
+
+// runPcap replays the UDP datagrams of the capture at path, keeping the
+// spacing they were captured with. Samples are stamped with the time
+// they are replayed at, so that they fuse with the other sensors; a
+// VLP-16's clock is dated by the capture. Once the capture has played to
+// its end the reader idles.
+func (r *LidarReader) runPcap(ctx context.Context, path string) error {
+	if r.replayed {
+		<-ctx.Done()
+		return nil
+	}
+	p, err := openPcap(path)
+	if err != nil {
+		return fmt.Errorf("lidar replay: %w", err)
+	}
+	defer p.Close()
+	var (
+		sweep        lidarSweep
+		first, start time.Time
+		n            int
+		warned       bool
+	)
+	for {
+		captured, link, pkt, err := p.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("lidar replay %s: %w", path, err)
+		}
+		port, b, err := udpDatagram(link, pkt)
+		if err != nil {
+			if err != errNotUDP && !warned {
+				r.log.Warnf("lidar: %s: skipping packets: %v", path, err)
+				warned = true
+			}
+			continue
+		}
+		if r.cfg.PcapPort != 0 && int(port) != r.cfg.PcapPort {
+			continue
+		}
+		if n == 0 {
+			first, start = captured, r.clock.Now()
+			r.log.Infof("lidar: replaying %s, captured %s", path, captured.UTC().Format(time.RFC3339))
+		}
+		at := start.Add(captured.Sub(first))
+		if d := at.Sub(r.clock.Now()); d > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-r.clock.After(d):
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+		r.datagram(&sweep, b, at, captured)
+		n++
+	}
+	r.emitSweep(&sweep)
+	r.replayed = true
+	if n == 0 {
+		r.log.Warnf("lidar: %s holds no UDP datagrams to replay", path)
+	} else {
+		r.log.Infof("lidar: replayed %d datagrams of %s", n, path)
+	}
+	<-ctx.Done()
+	return nil
+}
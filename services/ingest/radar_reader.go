@@ -0,0 +1,198 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// RadarReader connects to a radar (or its vendor bridge) over TCP and reads
+// one JSON object per line of the form {"targets":[{...}, ...]}, with the
+// time of the scan by the radar's clock in an optional "timestamp" (Unix
+// seconds), or with protocol ars408 reads a Continental ARS 408 on a
+// SocketCAN interface.
+type RadarReader struct {
+	cfg    utils.RadarConfig
+	log    utils.Logger
+	remote <-chan models.RadarScan
+	// capture, if set, is handed every CAN frame.
+	capture Capture
+
+	// Last scan sequence number, continued after a restart.
+	seq uint64
+	counters
+}
+
+func NewRadarReader(cfg utils.RadarConfig, log utils.Logger, clock utils.Clock) *RadarReader {
+	cfg.RateHz = checkRate(log, "radar", cfg.RateHz)
+	r := &RadarReader{cfg: cfg, log: log, counters: counters{buffer: cfg.BufferSize, clock: clock}}
+	r.decimate(cfg.Decimate)
+	return r
+}
+
+func (r *RadarReader) Name() string { return "radar" }
+
+type radarMessage struct {
+	Targets    []models.RadarTarget    `json:"targets"`
+	Detections []models.RadarDetection `json:"detections"`
+	Timestamp  *float64                `json:"timestamp"`
+}
+
+// UseRemote makes the reader publish scans received from a remote agent.
+func (r *RadarReader) UseRemote(in <-chan models.RadarScan) { r.remote = in }
+
+// UseCapture hands the CAN frames of an ARS 408 to c as they arrive,
+// those the reader ignores included.
+func (r *RadarReader) UseCapture(c Capture) { r.capture = c }
+
+func (r *RadarReader) Run(ctx context.Context) error {
+	if r.remote != nil {
+		return forward(ctx, r.remote, &r.counters)
+	}
+	if r.cfg.Address == utils.SimDevice {
+		return r.runSim(ctx)
+	}
+	if r.cfg.Protocol == utils.RadarARS408 {
+		return r.runARS408(ctx)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", r.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("radar dial %s: %w", r.cfg.Address, err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		var msg radarMessage
+		if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			r.log.Debugf("radar: %v", err)
+			continue
+		}
+		r.seq++
+		scan := models.RadarScan{Timestamp: r.clock.Now(), Seq: r.seq, Targets: msg.Targets}
+		if r.cfg.Detections {
+			scan.Detections = msg.Detections
+		}
+		if msg.Timestamp != nil {
+			t := time.Unix(0, int64(*msg.Timestamp*1e9)).UTC()
+			scan.DeviceTime = &t
+		}
+		emit(scan, &r.counters)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("radar read: %w", err)
+	}
+	return fmt.Errorf("radar: connection to %s closed", r.cfg.Address)
+}
+
+// runARS408 publishes one scan per measurement cycle of the sensor.
+func (r *RadarReader) runARS408(ctx context.Context) error {
+	f, err := openCAN(r.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("radar: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	a := newARS408(r.cfg.SensorID)
+	defer func() {
+		if a.partial > 0 {
+			r.log.Warnf("radar: %d cycles were missing targets", a.partial)
+		}
+	}()
+	publish := func(targets []models.RadarTarget) {
+		r.seq++
+		emit(models.RadarScan{Timestamp: r.clock.Now(), Seq: r.seq, Targets: targets}, &r.counters)
+	}
+	var in io.Reader = f
+	if r.capture != nil {
+		in = captureReader{f, r.capture, r.clock}
+	}
+	var buf [canFrameSize]byte
+	for {
+		frame, err := readCANFrame(in, &buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("radar read %s: %w", r.cfg.Address, err)
+		}
+		a.handle(frame, publish)
+	}
+}
+
+func (r *RadarReader) runSim(ctx context.Context) error {
+	ticker := utils.NewRateTicker(r.clock, r.cfg.RateHz)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if !r.simTick() {
+			continue
+		}
+		r.seq++
+		targets := make([]models.RadarTarget, 1+rand.Intn(8))
+		for i := range targets {
+			targets[i] = models.RadarTarget{
+				ID:          i,
+				RangeM:      5 + rand.Float64()*80,
+				AzimuthDeg:  rand.Float64()*90 - 45,
+				VelocityMps: rand.NormFloat64() * 3,
+				RCS:         rand.Float64() * 20,
+			}
+		}
+		scan := models.RadarScan{Timestamp: r.clock.Now(), Seq: r.seq, Targets: targets}
+		if r.cfg.Detections {
+			scan.Detections = simDetections(targets)
+		}
+		emit(scan, &r.counters)
+	}
+}
+
+// simDetections returns a few detections spread around each target, as
+// the cells it lit up, and some clutter.
+func simDetections(targets []models.RadarTarget) []models.RadarDetection {
+	var ds []models.RadarDetection
+	for _, t := range targets {
+		for range 3 + rand.Intn(6) {
+			ds = append(ds, models.RadarDetection{
+				RangeM:       t.RangeM + rand.NormFloat64()*0.3,
+				AzimuthDeg:   t.AzimuthDeg + rand.NormFloat64()*0.8,
+				ElevationDeg: rand.NormFloat64() * 1.5,
+				DopplerMps:   t.VelocityMps + rand.NormFloat64()*0.1,
+				PowerDB:      t.RCS + rand.NormFloat64()*2,
+			})
+		}
+	}
+	for range rand.Intn(20) {
+		ds = append(ds, models.RadarDetection{
+			RangeM:       1 + rand.Float64()*100,
+			AzimuthDeg:   rand.Float64()*90 - 45,
+			ElevationDeg: rand.NormFloat64() * 3,
+			PowerDB:      rand.Float64()*5 - 10,
+		})
+	}
+	return ds
+}
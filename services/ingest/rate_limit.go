@@ -0,0 +1,34 @@
+package ingest
+
+import (
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// tokenBucket lets rate samples a second through on average, in bursts of
+// up to burst. It is not safe for concurrent use; a reader emits from one
+// goroutine at a time.
+type tokenBucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+// allow reports whether a sample at now fits the rate, taking its token.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// UseRateLimit makes emit discard the samples over cfg, counting them as
+// limited rather than dropped.
+func (c *counters) UseRateLimit(cfg utils.RateLimitConfig) {
+	burst := float64(cfg.Burst)
+	c.limit = &tokenBucket{rate: cfg.MaxHz, burst: burst, tokens: burst, last: c.clock.Now()}
+}
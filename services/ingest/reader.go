@@ -0,0 +1,183 @@
+package ingest
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/thermal"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Reader is a sensor source. Run blocks until ctx is cancelled or the
+// device fails. It may be called again once it has returned, e.g. after
+// the device is reconnected; the reader keeps its place on the Mux and its
+// counters across runs, so its consumers never notice. Close must only be
+// called once Run will not be called again.
+type Reader interface {
+	Name() string
+	Run(ctx context.Context) error
+	Close()
+	Stats() Stats
+	counts() *counters
+}
+
+// Stats are the cumulative sample counters of a reader, plus the samples
+// it has waiting on its Mux out of the most it may have there.
+type Stats struct {
+	Produced uint64
+	Dropped  uint64
+	// Limited counts the samples over the reader's rate limit.
+	Limited  uint64
+	Queued   int
+	Capacity int
+}
+
+type counters struct {
+	produced atomic.Uint64
+	dropped  atomic.Uint64
+
+	// mux carries the samples to the pipeline; buffer is the number the
+	// reader may have waiting there, queued the number it has. room is
+	// the buffer as it has grown, up to maxBuffer; see GrowBuffer.
+	mux       *Mux
+	buffer    int
+	room      atomic.Int64
+	maxBuffer int
+	queued    atomic.Int64
+
+	// every is the configured decimation, 0 or 1 to keep every sample;
+	// seen counts the samples offered to emit.
+	every uint64
+	seen  atomic.Uint64
+
+	// gate thins out the ticks of a simulated device while the host runs
+	// hot; nil keeps them all.
+	gate *thermal.Gate
+
+	// limit discards the samples of a device that produces too fast; nil
+	// lets them all through.
+	limit   *tokenBucket
+	limited atomic.Uint64
+
+	// clock stamps the samples and drives the ticks of a simulated
+	// device.
+	clock utils.Clock
+}
+
+// UseThrottle makes a simulated device skip ticks as t says.
+func (c *counters) UseThrottle(t *thermal.Throttle) { c.gate = t.Gate() }
+
+// simTick reports whether a simulated device should produce a sample on
+// this tick.
+func (c *counters) simTick() bool { return c.gate == nil || c.gate.Keep() }
+
+// decimate makes emit keep only every nth sample, the first included.
+func (c *counters) decimate(n int) {
+	c.every = uint64(max(n, 1))
+}
+
+// UseBufferGrowth lets the buffer of the reader grow up to factor times
+// its size, see GrowBuffer. It must be called before NewMux.
+func (c *counters) UseBufferGrowth(factor int) { c.maxBuffer = factor * c.buffer }
+
+// GrowBuffer doubles the buffer of the reader, up to the most allowed by
+// UseBufferGrowth, and returns its new size; ok is false when it could
+// not grow.
+func (c *counters) GrowBuffer() (size int, ok bool) {
+	n := c.room.Load()
+	if n >= int64(c.maxBuffer) {
+		return int(n), false
+	}
+	n = min(2*n, int64(c.maxBuffer))
+	c.room.Store(n)
+	return int(n), true
+}
+
+func (c *counters) Stats() Stats {
+	return Stats{Produced: c.produced.Load(), Dropped: c.dropped.Load(), Limited: c.limited.Load(),
+		Queued: int(c.queued.Load()), Capacity: int(c.room.Load())}
+}
+
+// Close tells the Mux the reader will not emit again.
+func (c *counters) Close() { c.mux.leave() }
+
+func (c *counters) counts() *counters { return c }
+
+// checkRate clamps a rate that is zero, negative or absurdly high, with a
+// warning, so a reader constructed from an unvalidated config cannot panic.
+func checkRate(log utils.Logger, name string, rateHz int) int {
+	if c := utils.ClampRate(rateHz); c != rateHz {
+		log.Warnf("%s: rate of %d Hz is out of range, using %d Hz", name, rateHz, c)
+		return c
+	}
+	return rateHz
+}
+
+// emit hands v to the Mux without blocking. When the consumer falls
+// behind and the reader already has its buffer's worth of samples waiting,
+// the sample is dropped and counted rather than stalling the device.
+// Samples discarded by decimation are neither sent nor counted; those over
+// the rate limit are counted as limited.
+func emit(v models.SensorSample, c *counters) {
+	if c.every > 1 && (c.seen.Add(1)-1)%c.every != 0 {
+		return
+	}
+	if c.limit != nil && !c.limit.allow(c.clock.Now()) {
+		c.limited.Add(1)
+		return
+	}
+	if c.queued.Add(1) > c.room.Load() {
+		c.queued.Add(-1)
+		c.dropped.Add(1)
+		return
+	}
+	// C holds the buffers of all the readers, so there is room for v.
+	c.mux.C <- v
+	c.produced.Add(1)
+}
+
+// Mux carries the samples of several readers to one consumer on a single
+// channel, C. Every reader may have up to its buffer_size samples waiting
+// in C, or as many as its buffer has grown to, and drops its samples
+// beyond that, so a reader the consumer falls behind on cannot crowd out
+// the others. The consumer calls Done with every sample it takes from C.
+// C is closed once every reader is closed.
+type Mux struct {
+	C       chan models.SensorSample
+	readers map[string]*counters
+	open    atomic.Int64
+}
+
+// NewMux attaches rs, which must be readers of different sensors and must
+// not have run yet.
+func NewMux(rs ...Reader) *Mux {
+	m := &Mux{readers: make(map[string]*counters)}
+	size := 0
+	for _, r := range rs {
+		c := r.counts()
+		c.mux = m
+		m.readers[r.Name()] = c
+		c.room.Store(int64(c.buffer))
+		size += max(c.buffer, c.maxBuffer)
+	}
+	m.open.Store(int64(len(rs)))
+	m.C = make(chan models.SensorSample, size)
+	if len(rs) == 0 {
+		close(m.C)
+	}
+	return m
+}
+
+// Done frees the place v took in C.
+func (m *Mux) Done(v models.SensorSample) {
+	if c := m.readers[v.Sensor()]; c != nil {
+		c.queued.Add(-1)
+	}
+}
+
+func (m *Mux) leave() {
+	if m.open.Add(-1) == 0 {
+		close(m.C)
+	}
+}
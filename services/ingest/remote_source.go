@@ -0,0 +1,263 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/remote"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// syncBurst is the number of clock exchanges made right after an agent
+// connects, before its records are accepted.
+const syncBurst = 8
+
+// helloTimeout is how long a new connection has to send its Hello, so
+// that connections sending nothing do not pile up.
+const helloTimeout = 10 * time.Second
+
+// RemoteSource accepts connections from remote agents and hands their
+// records, corrected to the local clock, to the readers of sensors
+// configured with device "remote".
+type RemoteSource struct {
+	cfg utils.RemoteConfig
+	log utils.Logger
+
+	camera chan models.CameraFrame
+	gps    chan models.GPSData
+	imu    chan models.IMUData
+	lidar  chan models.LidarPacket
+	radar  chan models.RadarScan
+	env    chan models.EnvData
+	counters
+}
+
+func NewRemoteSource(cfg utils.RemoteConfig, log utils.Logger) *RemoteSource {
+	return &RemoteSource{
+		cfg:    cfg,
+		log:    log,
+		camera: make(chan models.CameraFrame, cfg.BufferSize),
+		gps:    make(chan models.GPSData, cfg.BufferSize),
+		imu:    make(chan models.IMUData, cfg.BufferSize),
+		lidar:  make(chan models.LidarPacket, cfg.BufferSize),
+		radar:  make(chan models.RadarScan, cfg.BufferSize),
+		env:    make(chan models.EnvData, cfg.BufferSize),
+	}
+}
+
+func (s *RemoteSource) Name() string { return "remote" }
+
+// Stats reports the occupancy summed over the per-sensor channels.
+func (s *RemoteSource) Stats() Stats {
+	st := s.counters.Stats()
+	st.Queued = len(s.camera) + len(s.gps) + len(s.imu) + len(s.lidar) + len(s.radar) + len(s.env)
+	st.Capacity = cap(s.camera) + cap(s.gps) + cap(s.imu) + cap(s.lidar) + cap(s.radar) + cap(s.env)
+	return st
+}
+
+// Close closes the per-sensor channels, which ends the forwarding of the
+// readers that use them.
+func (s *RemoteSource) Close() {
+	close(s.camera)
+	close(s.gps)
+	close(s.imu)
+	close(s.lidar)
+	close(s.radar)
+	close(s.env)
+}
+
+func (s *RemoteSource) Camera() <-chan models.CameraFrame { return s.camera }
+func (s *RemoteSource) GPS() <-chan models.GPSData        { return s.gps }
+func (s *RemoteSource) IMU() <-chan models.IMUData        { return s.imu }
+func (s *RemoteSource) Lidar() <-chan models.LidarPacket  { return s.lidar }
+func (s *RemoteSource) Radar() <-chan models.RadarScan    { return s.radar }
+func (s *RemoteSource) Env() <-chan models.EnvData        { return s.env }
+
+func (s *RemoteSource) Run(ctx context.Context) error {
+	a, err := auth.New(s.cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("remote: %w", err)
+	}
+	ln, err := a.Listen(s.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("remote listen %s: %w", s.cfg.Listen, err)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	s.log.Infof("remote: listening on %s", ln.Addr())
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("remote accept: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.serve(ctx, remote.NewConn(c), a); err != nil && ctx.Err() == nil {
+				s.log.Warnf("remote: agent %s: %v", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// serve handles one agent connection until it closes or ctx is cancelled.
+// Agents feed the logger data, so they need the control role.
+func (s *RemoteSource) serve(ctx context.Context, conn *remote.Conn, a *auth.Authenticator) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if err := conn.SetReadDeadline(time.Now().Add(helloTimeout)); err != nil {
+		return err
+	}
+	m, err := conn.Recv()
+	if err != nil {
+		return err
+	}
+	hello, err := remote.CheckHello(m)
+	if err != nil {
+		return err
+	}
+	if role := a.Role(hello.Token, conn.TLS()); role < auth.Control {
+		reason := fmt.Sprintf("role %s, needs %s", role, auth.Control)
+		conn.Send(&remote.Message{Kind: remote.KindReject, Reason: reason})
+		return fmt.Errorf("agent %q rejected: %s", hello.AgentID, reason)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	s.log.Infof("remote: agent %q connected from %s", hello.AgentID, conn.RemoteAddr())
+
+	clock := &remote.ClockEstimator{}
+	go s.ping(ctx, conn)
+
+	var lastReport time.Time
+	for {
+		m, err := conn.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, io.EOF) {
+				s.log.Infof("remote: agent %q disconnected", hello.AgentID)
+				return nil
+			}
+			return err
+		}
+		switch m.Kind {
+		case remote.KindPong:
+			if m.Sync == nil {
+				continue
+			}
+			clock.Add(time.Unix(0, m.Sync.T1), time.Unix(0, m.Sync.T2), time.Unix(0, m.Sync.T3), time.Now())
+			if off, rtt, _ := clock.Offset(); time.Since(lastReport) > time.Minute {
+				s.log.Infof("remote: agent %q clock offset %v (rtt %v)", hello.AgentID, off, rtt)
+				lastReport = time.Now()
+			}
+		case remote.KindRecord:
+			off, _, ok := clock.Offset()
+			if !ok || m.Record == nil {
+				// Timestamps can't be trusted until the first exchange.
+				s.dropped.Add(1)
+				continue
+			}
+			s.dispatch(m.Record, off)
+		}
+	}
+}
+
+// ping runs a burst of clock exchanges, then one per sync interval.
+func (s *RemoteSource) ping(ctx context.Context, conn *remote.Conn) {
+	send := func() bool {
+		err := conn.Send(&remote.Message{Kind: remote.KindPing, Sync: &remote.Sync{T1: time.Now().UnixNano()}})
+		return err == nil
+	}
+	for i := 0; i < syncBurst; i++ {
+		if !send() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ticker := time.NewTicker(time.Duration(s.cfg.SyncIntervalS) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !send() {
+				return
+			}
+		}
+	}
+}
+
+// dispatch converts the record's agent timestamp to the local clock and
+// hands it to the matching sensor channel.
+func (s *RemoteSource) dispatch(r *remote.Record, offset time.Duration) {
+	switch {
+	case r.Camera != nil:
+		r.Camera.Timestamp = r.Camera.Timestamp.Add(-offset).UTC()
+		send(s.camera, *r.Camera, &s.counters)
+	case r.GPS != nil:
+		r.GPS.Timestamp = r.GPS.Timestamp.Add(-offset).UTC()
+		send(s.gps, *r.GPS, &s.counters)
+	case r.IMU != nil:
+		r.IMU.Timestamp = r.IMU.Timestamp.Add(-offset).UTC()
+		send(s.imu, *r.IMU, &s.counters)
+	case r.Lidar != nil:
+		r.Lidar.Timestamp = r.Lidar.Timestamp.Add(-offset).UTC()
+		send(s.lidar, *r.Lidar, &s.counters)
+	case r.Radar != nil:
+		r.Radar.Timestamp = r.Radar.Timestamp.Add(-offset).UTC()
+		send(s.radar, *r.Radar, &s.counters)
+	case r.Env != nil:
+		r.Env.Timestamp = r.Env.Timestamp.Add(-offset).UTC()
+		send(s.env, *r.Env, &s.counters)
+	}
+}
+
+// send hands v to the channel of a remote sensor without blocking,
+// dropping and counting it when the sensor's reader falls behind.
+func send[T any](ch chan T, v T, c *counters) {
+	select {
+	case ch <- v:
+		c.produced.Add(1)
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// forward republishes samples received from a RemoteSource on a reader's
+// Mux, so remote sensors keep their own counters.
+func forward[T models.SensorSample](ctx context.Context, in <-chan T, c *counters) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case v, ok := <-in:
+			if !ok {
+				return nil
+			}
+			emit(v, c)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// sbgECom frame layout: sync chars, message id, class, little-endian
+// payload length, payload, a little-endian CRC-16 over id to payload and
+// an end char.
+const (
+	sbgSync1      = 0xFF
+	sbgSync2      = 0x5A
+	sbgETX        = 0x33
+	sbgMaxPayload = 4086
+
+	sbgClassLog    = 0x00
+	sbgLogIMUData  = 3
+	sbgLogEKFEuler = 6
+	sbgLogEKFNav   = 8
+)
+
+// Bits of the EKF solution status: the mode is in the low four bits.
+const (
+	sbgPositionValid = 1 << 7
+	sbgGPS1PosUsed   = 1 << 11
+	sbgGPS2PosUsed   = 1 << 15
+)
+
+// sbgModes names the solution modes of the EKF status.
+var sbgModes = []string{"uninitialized", "vertical_gyro", "ahrs", "nav_velocity", "nav_position"}
+
+// errSBGFrame reports a corrupt frame; the stream can be read on.
+var errSBGFrame = errors.New("bad sbgECom frame")
+
+type sbgFrame struct {
+	id, class byte
+	payload   []byte
+}
+
+// readSBG reads the frame at the start of br, which begins with sbgSync1.
+// On errSBGFrame br has moved past the sync char.
+func readSBG(br *bufio.Reader) (sbgFrame, error) {
+	hdr, err := br.Peek(6)
+	if err != nil {
+		return sbgFrame{}, err
+	}
+	n := int(binary.LittleEndian.Uint16(hdr[4:]))
+	if hdr[1] != sbgSync2 || n > sbgMaxPayload {
+		br.Discard(1)
+		return sbgFrame{}, errSBGFrame
+	}
+	buf := make([]byte, 6+n+3)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return sbgFrame{}, err
+	}
+	if sbgCRC(buf[2:6+n]) != binary.LittleEndian.Uint16(buf[6+n:]) || buf[8+n] != sbgETX {
+		return sbgFrame{}, fmt.Errorf("%w: checksum mismatch in %d-%d", errSBGFrame, buf[3], buf[2])
+	}
+	return sbgFrame{id: buf[2], class: buf[3], payload: buf[6 : 6+n]}, nil
+}
+
+// sbgCRC is the CRC-16 of sbgECom: polynomial 0x8408, reflected, from 0.
+func sbgCRC(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// sbgDecoder turns the EKF logs into solutions. The unit outputs the
+// EKF_EULER of an epoch before its EKF_NAV; a solution is made of a
+// navigation log and the latest attitude.
+type sbgDecoder struct {
+	clock           utils.Clock
+	euler, eulerStd [3]float64 // rad
+	hasEuler        bool
+}
+
+// decode returns the IMU sample or the solution f carries, if any, and
+// the position of the solution when it is valid.
+func (d *sbgDecoder) decode(f sbgFrame) (*models.IMUData, *models.INSData, *insFix, error) {
+	if f.class != sbgClassLog {
+		return nil, nil, nil, nil
+	}
+	p := f.payload
+	f32 := func(off int) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(p[off:]))) }
+	switch f.id {
+	case sbgLogIMUData:
+		if len(p) < 34 {
+			return nil, nil, nil, fmt.Errorf("short IMU_DATA (%d bytes)", len(p))
+		}
+		imu := imuSample([3]float64{f32(6), f32(10), f32(14)}, [3]float64{f32(18), f32(22), f32(26)}, [3]float64{})
+		return &imu, nil, nil, nil
+	case sbgLogEKFEuler:
+		if len(p) < 32 {
+			return nil, nil, nil, fmt.Errorf("short EKF_EULER (%d bytes)", len(p))
+		}
+		d.euler = [3]float64{f32(4), f32(8), f32(12)}
+		d.eulerStd = [3]float64{f32(16), f32(20), f32(24)}
+		d.hasEuler = true
+	case sbgLogEKFNav:
+		if len(p) < 72 {
+			return nil, nil, nil, fmt.Errorf("short EKF_NAV (%d bytes)", len(p))
+		}
+		if !d.hasEuler {
+			return nil, nil, nil, nil
+		}
+		f64 := func(off int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(p[off:])) }
+		status := binary.LittleEndian.Uint32(p[68:])
+		mode := fmt.Sprintf("mode_%d", status&0xF)
+		if int(status&0xF) < len(sbgModes) {
+			mode = sbgModes[status&0xF]
+		}
+		sol := models.INSData{
+			Timestamp: d.clock.Now(),
+			Mode:      mode,
+			Status:    status,
+			GNSSFix:   status&(sbgGPS1PosUsed|sbgGPS2PosUsed) != 0,
+			RollDeg:   d.euler[0] * 180 / math.Pi,
+			PitchDeg:  d.euler[1] * 180 / math.Pi,
+			YawDeg:    wrapDegrees(d.euler[2] * 180 / math.Pi),
+			VelN:      f32(4),
+			VelE:      f32(8),
+			VelD:      f32(12),
+			AttAccDeg: max(d.eulerStd[0], d.eulerStd[1], d.eulerStd[2]) * 180 / math.Pi,
+			PosAccM:   max(f32(56), f32(60)),
+			VelAccMps: max(f32(16), f32(20), f32(24)),
+		}
+		if status&sbgPositionValid == 0 {
+			return nil, &sol, nil, nil
+		}
+		vAcc := f32(64)
+		return nil, &sol, &insFix{lat: f64(28), lon: f64(36), alt: f64(44), vAccM: &vAcc}, nil
+	}
+	return nil, nil, nil, nil
+}
@@ -0,0 +1,246 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// pulseHistory is the number of recent pulses kept for matching, over a
+// second of a fast strobe.
+const pulseHistory = 256
+
+// TriggerReader timestamps the pulses of an external hardware trigger and
+// publishes them on its Mux. Every pulse is also kept in Pulses, for
+// matching the samples of other sensors to.
+type TriggerReader struct {
+	cfg    utils.TriggerConfig
+	log    utils.Logger
+	pulses *Pulses
+
+	// Last pulse ID, kept across runs.
+	id uint64
+	counters
+}
+
+func NewTriggerReader(cfg utils.TriggerConfig, log utils.Logger, clock utils.Clock) *TriggerReader {
+	cfg.RateHz = checkRate(log, "trigger", cfg.RateHz)
+	return &TriggerReader{
+		cfg:      cfg,
+		log:      log,
+		counters: counters{buffer: cfg.BufferSize, clock: clock},
+		pulses:   &Pulses{window: time.Duration(cfg.WindowMs) * time.Millisecond, clock: clock, added: make(chan struct{})},
+	}
+}
+
+func (r *TriggerReader) Name() string { return "trigger" }
+
+// Pulses returns the recent pulses of the trigger.
+func (r *TriggerReader) Pulses() *Pulses { return r.pulses }
+
+func (r *TriggerReader) Run(ctx context.Context) error {
+	switch {
+	case r.cfg.Device == utils.SimDevice:
+		return r.runSim(ctx)
+	case strings.HasPrefix(r.cfg.Device, utils.GPIOPrefix):
+		line, _ := strconv.Atoi(strings.TrimPrefix(r.cfg.Device, utils.GPIOPrefix))
+		return r.runGPIO(ctx, line)
+	}
+	return r.runSerial(ctx)
+}
+
+// pulse records a pulse at ts; id 0 takes the next number.
+func (r *TriggerReader) pulse(ts time.Time, id uint64) {
+	if id == 0 {
+		id = r.id + 1
+	}
+	r.id = id
+	p := models.TriggerPulse{Timestamp: ts, ID: id}
+	r.pulses.add(p)
+	emit(p, &r.counters)
+}
+
+func (r *TriggerReader) runSim(ctx context.Context) error {
+	ticker := utils.NewRateTicker(r.clock, r.cfg.RateHz)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		r.pulse(r.clock.Now(), 0)
+	}
+}
+
+// runSerial takes every line from the bridge as a pulse. A bridge that
+// prints its pulse count lets pulses lost on the way show as gaps in the
+// IDs; a count that restarts, as after a reset of the bridge, is ignored
+// and the IDs carry on.
+func (r *TriggerReader) runSerial(ctx context.Context) error {
+	port, err := OpenSerial(r.cfg.Device, r.cfg.Baud)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		port.Close()
+	}()
+
+	sc := bufio.NewScanner(port)
+	var offset uint64
+	for sc.Scan() {
+		ts := r.clock.Now()
+		var id uint64
+		if n, err := strconv.ParseUint(strings.TrimSpace(sc.Text()), 10, 64); err == nil {
+			if n+offset <= r.id {
+				offset = r.id
+			}
+			id = n + offset
+		}
+		r.pulse(ts, id)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("trigger read: %w", err)
+	}
+	return fmt.Errorf("trigger: %s closed", r.cfg.Device)
+}
+
+// runGPIO waits for the rising edges of a sysfs GPIO line, exporting it
+// first if needed.
+func (r *TriggerReader) runGPIO(ctx context.Context, line int) error {
+	value, err := openGPIO(line)
+	if err != nil {
+		return err
+	}
+	defer value.Close()
+	ep, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("trigger: epoll: %w", err)
+	}
+	defer syscall.Close(ep)
+	fd := int(value.Fd())
+	ev := syscall.EpollEvent{Events: syscall.EPOLLPRI | syscall.EPOLLERR, Fd: int32(fd)}
+	if err := syscall.EpollCtl(ep, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		return fmt.Errorf("trigger: epoll: %w", err)
+	}
+	buf := make([]byte, 8)
+	// sysfs reports an edge until the value is read again from the start.
+	rearm := func() error {
+		_, err := syscall.Pread(fd, buf, 0)
+		return err
+	}
+	if err := rearm(); err != nil {
+		return fmt.Errorf("trigger: read gpio%d: %w", line, err)
+	}
+	events := make([]syscall.EpollEvent, 1)
+	for ctx.Err() == nil {
+		n, err := syscall.EpollWait(ep, events, 100)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("trigger: wait on gpio%d: %w", line, err)
+		}
+		if n == 0 {
+			continue
+		}
+		ts := r.clock.Now()
+		if err := rearm(); err != nil {
+			return fmt.Errorf("trigger: read gpio%d: %w", line, err)
+		}
+		r.pulse(ts, 0)
+	}
+	return nil
+}
+
+// openGPIO configures line as an input reporting rising edges and opens
+// its value file.
+func openGPIO(line int) (*os.File, error) {
+	dir := fmt.Sprintf("/sys/class/gpio/gpio%d", line)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile("/sys/class/gpio/export", []byte(strconv.Itoa(line)), 0); err != nil {
+			return nil, fmt.Errorf("export gpio%d: %w", line, err)
+		}
+	}
+	for _, s := range []struct{ file, value string }{{"direction", "in"}, {"edge", "rising"}} {
+		if err := os.WriteFile(dir+"/"+s.file, []byte(s.value), 0); err != nil {
+			return nil, fmt.Errorf("gpio%d: set %s: %w", line, s.file, err)
+		}
+	}
+	f, err := os.Open(dir + "/value")
+	if err != nil {
+		return nil, fmt.Errorf("open gpio%d: %w", line, err)
+	}
+	return f, nil
+}
+
+// Pulses keeps the recent pulses of a trigger. It is safe for concurrent
+// use.
+type Pulses struct {
+	window time.Duration
+	clock  utils.Clock
+
+	mu   sync.Mutex
+	ring [pulseHistory]models.TriggerPulse
+	n    int // pulses added
+	// added is closed and replaced on every pulse, waking Match.
+	added chan struct{}
+}
+
+func (p *Pulses) add(pulse models.TriggerPulse) {
+	p.mu.Lock()
+	p.ring[p.n%pulseHistory] = pulse
+	p.n++
+	close(p.added)
+	p.added = make(chan struct{})
+	p.mu.Unlock()
+}
+
+// Match returns the pulse nearest to ts if it is within the window of the
+// trigger, nil otherwise. A pulse can be stamped after a sample it belongs
+// to, so Match waits for a pulse after ts or for the window to pass,
+// whichever comes first.
+func (p *Pulses) Match(ts time.Time) *models.TriggerMatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.n == 0 || !p.ring[(p.n-1)%pulseHistory].Timestamp.After(ts) {
+		wait := ts.Add(p.window).Sub(p.clock.Now())
+		if wait <= 0 {
+			break
+		}
+		added := p.added
+		p.mu.Unlock()
+		select {
+		case <-added:
+		case <-p.clock.After(wait):
+		}
+		p.mu.Lock()
+	}
+	var best *models.TriggerMatch
+	for i := p.n - 1; i >= max(0, p.n-pulseHistory); i-- {
+		pulse := p.ring[i%pulseHistory]
+		off := ts.Sub(pulse.Timestamp)
+		if off > p.window {
+			// Older pulses are further away still.
+			break
+		}
+		if off >= -p.window && (best == nil || off.Abs() < best.Offset.Abs()) {
+			best = &models.TriggerMatch{ID: pulse.ID, Offset: off}
+		}
+	}
+	return best
+}
@@ -0,0 +1,169 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// UBX frame layout: sync chars, class, id, little-endian payload length,
+// payload and a two-byte Fletcher checksum over class to payload.
+const (
+	ubxSync1      = 0xB5
+	ubxSync2      = 0x62
+	ubxMaxPayload = 1024
+
+	ubxClassNAV    = 0x01
+	ubxNavDOP      = 0x04
+	ubxNavPVT      = 0x07
+	ubxNavHPPOSLLH = 0x14
+)
+
+// errUBXFrame reports a corrupt frame; the stream can be read on.
+var errUBXFrame = errors.New("bad UBX frame")
+
+type ubxFrame struct {
+	class, id byte
+	payload   []byte
+}
+
+// readUBX reads the frame at the start of br, which begins with ubxSync1.
+// On errUBXFrame br has moved past the sync char.
+func readUBX(br *bufio.Reader) (ubxFrame, error) {
+	hdr, err := br.Peek(6)
+	if err != nil {
+		return ubxFrame{}, err
+	}
+	n := int(binary.LittleEndian.Uint16(hdr[4:]))
+	if hdr[1] != ubxSync2 || n > ubxMaxPayload {
+		br.Discard(1)
+		return ubxFrame{}, errUBXFrame
+	}
+	buf := make([]byte, 6+n+2)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return ubxFrame{}, err
+	}
+	var a, b byte
+	for _, c := range buf[2 : 6+n] {
+		a += c
+		b += a
+	}
+	if a != buf[6+n] || b != buf[7+n] {
+		return ubxFrame{}, fmt.Errorf("%w: checksum mismatch in %02x-%02x", errUBXFrame, buf[2], buf[3])
+	}
+	return ubxFrame{class: buf[2], id: buf[3], payload: buf[6 : 6+n]}, nil
+}
+
+// ubxDecoder turns NAV-PVT solutions into fixes. Once a NAV-HPPOSLLH has
+// been seen, each PVT fix waits for the high-precision position of the
+// same epoch, which u-blox receivers output after it; NAV-DOP, output
+// before it, supplies the HDOP.
+type ubxDecoder struct {
+	clock utils.Clock
+	hp    bool
+
+	pending    models.GPSData
+	pendingTOW uint32
+	hasPending bool
+
+	hdop    float64
+	hdopTOW uint32
+	hasHDOP bool
+}
+
+// decode returns a fix when f completes one.
+func (d *ubxDecoder) decode(f ubxFrame) (models.GPSData, bool, error) {
+	if f.class != ubxClassNAV {
+		return models.GPSData{}, false, nil
+	}
+	p := f.payload
+	switch f.id {
+	case ubxNavDOP:
+		if len(p) < 18 {
+			return models.GPSData{}, false, fmt.Errorf("short NAV-DOP (%d bytes)", len(p))
+		}
+		d.hdopTOW, d.hdop, d.hasHDOP = binary.LittleEndian.Uint32(p), float64(binary.LittleEndian.Uint16(p[12:]))/100, true
+	case ubxNavPVT:
+		if len(p) < 92 {
+			return models.GPSData{}, false, fmt.Errorf("short NAV-PVT (%d bytes)", len(p))
+		}
+		tow := binary.LittleEndian.Uint32(p)
+		fix, ok := d.parsePVT(p, tow)
+		prev, hadPrev := d.pending, d.hasPending
+		d.hasPending = false
+		if !ok {
+			return prev, hadPrev, nil
+		}
+		if !d.hp {
+			return fix, true, nil
+		}
+		d.pending, d.pendingTOW, d.hasPending = fix, tow, true
+		return prev, hadPrev, nil
+	case ubxNavHPPOSLLH:
+		if len(p) < 36 {
+			return models.GPSData{}, false, fmt.Errorf("short NAV-HPPOSLLH (%d bytes)", len(p))
+		}
+		d.hp = true
+		if !d.hasPending || binary.LittleEndian.Uint32(p[4:]) != d.pendingTOW {
+			return models.GPSData{}, false, nil
+		}
+		fix := d.pending
+		d.hasPending = false
+		if p[3]&1 == 0 { // invalidLlh clear
+			i32 := func(off int) float64 { return float64(int32(binary.LittleEndian.Uint32(p[off:]))) }
+			fix.Lon = i32(8)*1e-7 + float64(int8(p[24]))*1e-9
+			fix.Lat = i32(12)*1e-7 + float64(int8(p[25]))*1e-9
+			fix.Alt = (i32(20) + float64(int8(p[27]))*0.1) / 1000
+			hAcc := float64(binary.LittleEndian.Uint32(p[28:])) / 1e4
+			vAcc := float64(binary.LittleEndian.Uint32(p[32:])) / 1e4
+			fix.HAccM, fix.VAccM = &hAcc, &vAcc
+		}
+		return fix, true, nil
+	}
+	return models.GPSData{}, false, nil
+}
+
+// parsePVT converts a NAV-PVT payload; ok is false without a position fix.
+func (d *ubxDecoder) parsePVT(p []byte, tow uint32) (models.GPSData, bool) {
+	fixType, flags := p[20], p[21]
+	if flags&1 == 0 || fixType == 0 || fixType == 5 { // no fix or time only
+		return models.GPSData{}, false
+	}
+	i32 := func(off int) float64 { return float64(int32(binary.LittleEndian.Uint32(p[off:]))) }
+	u32 := func(off int) float64 { return float64(binary.LittleEndian.Uint32(p[off:])) }
+	fix := models.GPSData{
+		Timestamp:  d.clock.Now(),
+		Lon:        i32(24) * 1e-7,
+		Lat:        i32(28) * 1e-7,
+		Alt:        i32(36) / 1000,
+		SpeedMps:   i32(60) / 1000,
+		HeadingDeg: i32(64) * 1e-5,
+		Satellites: int(p[23]),
+	}
+	switch carrier := flags >> 6; {
+	case fixType == 1:
+		fix.FixQuality = 6 // dead reckoning
+	case carrier == 2:
+		fix.FixQuality = 4
+	case carrier == 1:
+		fix.FixQuality = 5
+	case flags&2 != 0:
+		fix.FixQuality = 2
+	default:
+		fix.FixQuality = 1
+	}
+	// PVT carries only the position DOP; prefer NAV-DOP's HDOP.
+	fix.HDOP = float64(binary.LittleEndian.Uint16(p[76:])) / 100
+	if d.hasHDOP && d.hdopTOW == tow {
+		fix.HDOP = d.hdop
+	}
+	hAcc, vAcc := u32(40)/1000, u32(44)/1000
+	sAcc, headAcc := u32(68)/1000, u32(72)*1e-5
+	fix.HAccM, fix.VAccM, fix.SpeedAccMps, fix.HeadingAccDeg = &hAcc, &vAcc, &sAcc, &headAcc
+	return fix, true
+}
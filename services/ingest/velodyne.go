@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+// A VLP-16 data packet holds 12 blocks of a 0xFFEE flag, the azimuth in
+// hundredths of a degree and 32 channels of distance (2 mm units) and
+// reflectivity: two firings of the 16 lasers. The timestamp of the first
+// firing, in microseconds past the hour, and two factory bytes, return
+// mode and product id, follow.
+const (
+	vlpPacketSize = 1206
+	vlpBlocks     = 12
+	vlpBlockSize  = 100
+	vlpLasers     = 16
+	vlpTimeOffset = 1200
+	vlpModeOffset = 1204
+
+	// Firing timing in microseconds: the lasers fire one after another,
+	// and a firing of all 16 takes 55.296 µs including recharge.
+	vlpLaserUs  = 2.304
+	vlpFiringUs = 55.296
+	vlpBlockUs  = 2 * vlpFiringUs
+
+	vlpStrongest = 0x37
+	vlpLast      = 0x38
+	vlpDual      = 0x39
+)
+
+// vlp16Elevation is the elevation of each laser channel in degrees.
+var vlp16Elevation = [vlpLasers]float64{-15, 1, -13, 3, -11, 5, -9, 7, -7, 9, -5, 11, -3, 13, -1, 15}
+
+// vlpPacket is a decoded data packet. Point times are relative to the
+// first firing, at usec microseconds past the hour of the sensor clock.
+type vlpPacket struct {
+	pts  []models.LidarPoint
+	mode byte
+	usec uint32
+	// azimuth of the first block in degrees
+	azimuth float64
+}
+
+// duration is the time from the first firing of the packet to the end of
+// its last, which in dual-return mode holds half as many firings.
+func (p *vlpPacket) duration() float64 {
+	if p.mode == vlpDual {
+		return vlpBlocks / 2 * vlpBlockUs / 1e6
+	}
+	return vlpBlocks * vlpBlockUs / 1e6
+}
+
+// vlpRing numbers laser channel ch by elevation, 0 for the lowest beam.
+func vlpRing(ch int) uint8 {
+	if ch%2 == 0 {
+		return uint8(ch / 2)
+	}
+	return uint8(vlpLasers/2 + ch/2)
+}
+
+// decodeVLP16 converts a data packet into points in the sensor frame (x
+// forward, y left, z up), skipping channels without a return. Each point
+// gets the azimuth the head had when its laser fired, interpolated
+// between the blocks. In dual-return mode the blocks come in pairs of the
+// same firings, see returnOf.
+func decodeVLP16(b []byte) (vlpPacket, error) {
+	if len(b) != vlpPacketSize {
+		return vlpPacket{}, fmt.Errorf("%d-byte datagram is not a VLP-16 data packet", len(b))
+	}
+	mode := b[vlpModeOffset]
+	stride := 1
+	if mode == vlpDual {
+		stride = 2
+	}
+	azimuth := func(i int) float64 {
+		return float64(binary.LittleEndian.Uint16(b[i*vlpBlockSize+2:])) / 100
+	}
+	pts := make([]models.LidarPoint, 0, vlpBlocks*2*vlpLasers)
+	for i := 0; i < vlpBlocks; i++ {
+		blk := b[i*vlpBlockSize:]
+		if binary.LittleEndian.Uint16(blk) != 0xEEFF {
+			return vlpPacket{}, fmt.Errorf("block %d has no 0xFFEE flag", i)
+		}
+		// The head turns by step until the next block's firings.
+		var step float64
+		if i+stride < vlpBlocks {
+			step = azimuth(i+stride) - azimuth(i)
+		} else {
+			step = azimuth(i) - azimuth(i-stride)
+		}
+		if step < 0 {
+			step += 360
+		}
+		ret := returnOf(mode, b, i)
+		for ch := 0; ch < 2*vlpLasers; ch++ {
+			c := blk[4+3*ch:]
+			dist := float64(binary.LittleEndian.Uint16(c)) * 0.002
+			if dist == 0 || (mode == vlpDual && ret[ch] == 0) {
+				continue
+			}
+			laser := ch % vlpLasers
+			offset := float64(ch/vlpLasers)*vlpFiringUs + float64(laser)*vlpLaserUs
+			a := (azimuth(i) + step*offset/vlpBlockUs) * math.Pi / 180
+			el := vlp16Elevation[laser] * math.Pi / 180
+			xy := dist * math.Cos(el)
+			pts = append(pts, models.LidarPoint{
+				X:         float32(xy * math.Cos(a)),
+				Y:         float32(-xy * math.Sin(a)),
+				Z:         float32(dist * math.Sin(el)),
+				Intensity: float32(c[2]),
+				Return:    ret[ch],
+				Ring:      vlpRing(laser),
+				Time:      float32((float64(i/stride)*vlpBlockUs + offset) / 1e6),
+			})
+		}
+	}
+	return vlpPacket{
+		pts:     pts,
+		mode:    mode,
+		usec:    binary.LittleEndian.Uint32(b[vlpTimeOffset:]),
+		azimuth: azimuth(0),
+	}, nil
+}
+
+// returnOf returns the echo type of every channel of block i, 0 for the
+// duplicate a dual-return packet carries when there was only one echo.
+// Dual-return blocks pair the last echo with the strongest one, or with
+// the second-strongest when the last echo was the strongest; the
+// reflectivities tell the two apart.
+func returnOf(mode byte, b []byte, i int) (ret [2 * vlpLasers]models.LidarReturn) {
+	switch mode {
+	case vlpStrongest:
+		for ch := range ret {
+			ret[ch] = models.ReturnStrongest
+		}
+		return ret
+	case vlpLast:
+		for ch := range ret {
+			ret[ch] = models.ReturnLast
+		}
+		return ret
+	case vlpDual:
+	default:
+		return ret
+	}
+	pair := i &^ 1
+	for ch := range ret {
+		last := b[pair*vlpBlockSize+4+3*ch:][:3]
+		other := b[(pair+1)*vlpBlockSize+4+3*ch:][:3]
+		switch {
+		case last[0] == other[0] && last[1] == other[1] && last[2] == other[2]:
+			if i == pair {
+				ret[ch] = models.ReturnLast | models.ReturnStrongest
+			}
+		case last[2] >= other[2]:
+			ret[ch] = models.ReturnLast | models.ReturnStrongest
+			if i != pair {
+				ret[ch] = models.ReturnSecond
+			}
+		default:
+			ret[ch] = models.ReturnLast
+			if i != pair {
+				ret[ch] = models.ReturnStrongest
+			}
+		}
+	}
+	return ret
+}
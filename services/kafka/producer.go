@@ -0,0 +1,434 @@
+// Package kafka produces messages to Apache Kafka (0.11 or later) over its
+// wire protocol: a metadata request to find the partition leaders, then
+// produce requests of uncompressed v2 record batches with acks=1. Messages
+// are partitioned by key as the Java client does.
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// API keys and the versions used.
+const (
+	apiProduce  = 0
+	apiMetadata = 3
+
+	produceVersion  = 3
+	metadataVersion = 1
+)
+
+// errorNames names the error codes a producer commonly sees.
+var errorNames = map[int16]string{
+	3:  "unknown topic or partition",
+	5:  "leader not available",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	29: "topic authorization failed",
+}
+
+func kafkaError(code int16) error {
+	if name, ok := errorNames[code]; ok {
+		return errors.New(name)
+	}
+	return fmt.Errorf("error code %d", code)
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures a Producer.
+type Options struct {
+	// Brokers are the bootstrap brokers, host:port.
+	Brokers  []string
+	Topic    string
+	ClientID string
+	// Timeout bounds each request and the broker's wait for the leader's
+	// write.
+	Timeout time.Duration
+}
+
+// Message is a record to produce. A nil key spreads messages over the
+// partitions in turn.
+type Message struct {
+	Key, Value []byte
+	Time       time.Time
+}
+
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// Producer produces to one topic. It is not safe for concurrent use.
+type Producer struct {
+	opts  Options
+	corr  int32
+	next  int // partition of the next unkeyed message
+	addrs map[int32]string
+	conns map[int32]*conn
+	// leaders holds the leader broker of each partition.
+	leaders []int32
+}
+
+// Dial fetches the topic's partition leaders from the first bootstrap
+// broker that answers.
+func Dial(opts Options) (*Producer, error) {
+	p := &Producer{opts: opts, addrs: map[int32]string{}, conns: map[int32]*conn{}}
+	var err error
+	for _, addr := range opts.Brokers {
+		if err = p.metadata(addr); err == nil {
+			return p, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no brokers")
+	}
+	return nil, fmt.Errorf("kafka: %w", err)
+}
+
+// metadata asks the broker at addr for the brokers and the partition
+// leaders of the topic.
+func (p *Producer) metadata(addr string) error {
+	c, err := p.dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.c.Close()
+	var req enc
+	req.i32(1)
+	req.str(p.opts.Topic)
+	d, err := p.roundTrip(c, apiMetadata, metadataVersion, req)
+	if err != nil {
+		return fmt.Errorf("metadata from %s: %w", addr, err)
+	}
+	for n := d.i32(); n > 0 && d.err == nil; n-- {
+		id, host, port := d.i32(), d.str(), d.i32()
+		d.nullableStr() // rack
+		p.addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.i32() // controller
+	for n := d.i32(); n > 0 && d.err == nil; n-- {
+		code, name := d.i16(), d.str()
+		d.i8() // internal
+		if name != p.opts.Topic {
+			d.skipPartitions()
+			continue
+		}
+		if code != 0 {
+			return fmt.Errorf("topic %s: %w", name, kafkaError(code))
+		}
+		parts := d.i32()
+		p.leaders = make([]int32, parts)
+		for range parts {
+			code, index, leader := d.i16(), d.i32(), d.i32()
+			d.skipInt32s() // replicas
+			d.skipInt32s() // in sync
+			// 9 says a replica is down; the leader still takes writes.
+			if code != 0 && code != 9 {
+				return fmt.Errorf("topic %s partition %d: %w", name, index, kafkaError(code))
+			}
+			if index >= 0 && int(index) < len(p.leaders) {
+				p.leaders[index] = leader
+			}
+		}
+	}
+	if d.err != nil {
+		return fmt.Errorf("metadata from %s: %w", addr, d.err)
+	}
+	if len(p.leaders) == 0 {
+		return fmt.Errorf("topic %s not found", p.opts.Topic)
+	}
+	return nil
+}
+
+// Produce sends msgs and waits for the partition leaders to acknowledge
+// them.
+func (p *Producer) Produce(msgs []Message) error {
+	byPart := map[int32][]Message{}
+	for _, m := range msgs {
+		var part int32
+		if m.Key == nil {
+			part = int32(p.next % len(p.leaders))
+			p.next++
+		} else {
+			part = int32(murmur2(m.Key)&0x7fffffff) % int32(len(p.leaders))
+		}
+		byPart[part] = append(byPart[part], m)
+	}
+	byLeader := map[int32][]int32{}
+	for part := range byPart {
+		leader := p.leaders[part]
+		byLeader[leader] = append(byLeader[leader], part)
+	}
+	for leader, parts := range byLeader {
+		if err := p.produce(leader, parts, byPart); err != nil {
+			return fmt.Errorf("kafka: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *Producer) produce(leader int32, parts []int32, byPart map[int32][]Message) error {
+	c, err := p.conn(leader)
+	if err != nil {
+		return err
+	}
+	var req enc
+	req.i16(-1) // no transactional ID
+	req.i16(1)  // acks
+	req.i32(int32(p.opts.Timeout / time.Millisecond))
+	req.i32(1)
+	req.str(p.opts.Topic)
+	req.i32(int32(len(parts)))
+	for _, part := range parts {
+		req.i32(part)
+		req.bytes(recordBatch(byPart[part]))
+	}
+	d, err := p.roundTrip(c, apiProduce, produceVersion, req)
+	if err != nil {
+		c.c.Close()
+		delete(p.conns, leader)
+		return fmt.Errorf("produce to %s: %w", p.addrs[leader], err)
+	}
+	for n := d.i32(); n > 0 && d.err == nil; n-- {
+		d.str()
+		for m := d.i32(); m > 0 && d.err == nil; m-- {
+			part, code := d.i32(), d.i16()
+			d.i64() // base offset
+			d.i64() // log append time
+			if code != 0 && d.err == nil {
+				return fmt.Errorf("produce to %s partition %d: %w", p.opts.Topic, part, kafkaError(code))
+			}
+		}
+	}
+	return d.err
+}
+
+// Close closes the broker connections.
+func (p *Producer) Close() error {
+	for _, c := range p.conns {
+		c.c.Close()
+	}
+	p.conns = map[int32]*conn{}
+	return nil
+}
+
+func (p *Producer) conn(id int32) (*conn, error) {
+	if c, ok := p.conns[id]; ok {
+		return c, nil
+	}
+	addr, ok := p.addrs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker %d", id)
+	}
+	c, err := p.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[id] = c
+	return c, nil
+}
+
+func (p *Producer) dial(addr string) (*conn, error) {
+	c, err := net.DialTimeout("tcp", addr, p.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: c, r: bufio.NewReader(c)}, nil
+}
+
+// roundTrip sends a request and returns the decoder of its response body.
+func (p *Producer) roundTrip(c *conn, api, version int16, body enc) (*dec, error) {
+	p.corr++
+	var req enc
+	req.i32(0) // size, set below
+	req.i16(api)
+	req.i16(version)
+	req.i32(p.corr)
+	req.str(p.opts.ClientID)
+	req = append(req, body...)
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+	c.c.SetDeadline(time.Now().Add(2 * p.opts.Timeout))
+	if _, err := c.c.Write(req); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	d := &dec{b: resp}
+	if corr := d.i32(); corr != p.corr {
+		return nil, fmt.Errorf("response %d to request %d", corr, p.corr)
+	}
+	return d, nil
+}
+
+// recordBatch encodes msgs as a v2 record batch.
+func recordBatch(msgs []Message) []byte {
+	first, last := msgs[0].Time.UnixMilli(), msgs[0].Time.UnixMilli()
+	for _, m := range msgs {
+		first = min(first, m.Time.UnixMilli())
+		last = max(last, m.Time.UnixMilli())
+	}
+	var body enc
+	body.i16(0) // attributes: no compression
+	body.i32(int32(len(msgs) - 1))
+	body.i64(first)
+	body.i64(last)
+	body.i64(-1) // producer ID
+	body.i16(-1) // producer epoch
+	body.i32(-1) // base sequence
+	body.i32(int32(len(msgs)))
+	for i, m := range msgs {
+		r := []byte{0} // attributes
+		r = binary.AppendVarint(r, m.Time.UnixMilli()-first)
+		r = binary.AppendVarint(r, int64(i))
+		if m.Key == nil {
+			r = binary.AppendVarint(r, -1)
+		} else {
+			r = binary.AppendVarint(r, int64(len(m.Key)))
+			r = append(r, m.Key...)
+		}
+		r = binary.AppendVarint(r, int64(len(m.Value)))
+		r = append(r, m.Value...)
+		r = binary.AppendVarint(r, 0) // headers
+		body = binary.AppendVarint(body, int64(len(r)))
+		body = append(body, r...)
+	}
+	var b enc
+	b.i64(0) // base offset
+	b.i32(int32(4 + 1 + 4 + len(body)))
+	b.i32(-1) // partition leader epoch
+	b = append(b, 2)
+	b.i32(int32(crc32.Checksum(body, castagnoli)))
+	return append(b, body...)
+}
+
+// murmur2 is the hash the Java client partitions keys with.
+func murmur2(data []byte) uint32 {
+	const m, r = 0x5bd1e995, 24
+	h := uint32(0x9747b28c) ^ uint32(len(data))
+	n := len(data) / 4
+	for i := range n {
+		k := binary.LittleEndian.Uint32(data[4*i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[4*n:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// enc appends big-endian protocol primitives.
+type enc []byte
+
+func (e *enc) i16(v int16) { *e = binary.BigEndian.AppendUint16(*e, uint16(v)) }
+func (e *enc) i32(v int32) { *e = binary.BigEndian.AppendUint32(*e, uint32(v)) }
+func (e *enc) i64(v int64) { *e = binary.BigEndian.AppendUint64(*e, uint64(v)) }
+
+func (e *enc) str(s string) {
+	e.i16(int16(len(s)))
+	*e = append(*e, s...)
+}
+
+func (e *enc) bytes(b []byte) {
+	e.i32(int32(len(b)))
+	*e = append(*e, b...)
+}
+
+// dec reads protocol primitives from a response, recording the first
+// short read.
+type dec struct {
+	b   []byte
+	err error
+}
+
+func (d *dec) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("short response")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *dec) i8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *dec) i16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *dec) i32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *dec) i64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *dec) str() string { return string(d.take(int(d.i16()))) }
+
+func (d *dec) nullableStr() {
+	if n := d.i16(); n > 0 {
+		d.take(int(n))
+	}
+}
+
+func (d *dec) skipInt32s() { d.take(4 * int(d.i32())) }
+
+// skipPartitions skips the partitions of a topic in a metadata response.
+func (d *dec) skipPartitions() {
+	for n := d.i32(); n > 0 && d.err == nil; n-- {
+		d.i16()
+		d.i32()
+		d.i32()
+		d.skipInt32s()
+		d.skipInt32s()
+	}
+}
@@ -0,0 +1,164 @@
+package mcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const opChunk = 0x06
+
+// Schema is a schema read from a file.
+type Schema struct {
+	Name, Encoding string
+	Data           []byte
+}
+
+// Channel is a channel read from a file; Schema is nil for a channel
+// without one.
+type Channel struct {
+	Topic, Encoding string
+	Schema          *Schema
+}
+
+// Message is a message read from a file.
+type Message struct {
+	Channel *Channel
+	Seq     uint32
+	LogTime time.Time
+	Data    []byte
+}
+
+// Reader reads the messages of an unchunked MCAP file, as written by
+// Writer, from the data section. It is not safe for concurrent use.
+type Reader struct {
+	f        *os.File
+	r        *bufio.Reader
+	schemas  map[uint16]*Schema
+	channels map[uint16]*Channel
+	done     bool
+}
+
+// Open opens the file at path and checks its magic.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{f: f, r: bufio.NewReaderSize(f, 256<<10), schemas: map[uint16]*Schema{}, channels: map[uint16]*Channel{}}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r.r, magic); err != nil || string(magic) != Magic {
+		f.Close()
+		return nil, fmt.Errorf("%s: not an MCAP file", path)
+	}
+	return r, nil
+}
+
+// Next returns the next message, io.EOF at the end of the data section
+// and io.ErrUnexpectedEOF for a file cut short, such as that of a session
+// that did not close cleanly.
+func (r *Reader) Next() (Message, error) {
+	for !r.done {
+		var h [9]byte
+		if _, err := io.ReadFull(r.r, h[:]); err != nil {
+			return Message{}, truncated(err)
+		}
+		content := make([]byte, binary.LittleEndian.Uint64(h[1:]))
+		if _, err := io.ReadFull(r.r, content); err != nil {
+			return Message{}, truncated(err)
+		}
+		d := decoder{b: content}
+		switch h[0] {
+		case opSchema:
+			id := d.u16()
+			s := &Schema{Name: d.str(), Encoding: d.str()}
+			s.Data = d.bytes(int(d.u32()))
+			if d.err == nil {
+				r.schemas[id] = s
+			}
+		case opChannel:
+			id := d.u16()
+			schema := d.u16()
+			c := &Channel{Topic: d.str(), Encoding: d.str(), Schema: r.schemas[schema]}
+			if d.err == nil {
+				r.channels[id] = c
+			}
+		case opMessage:
+			ch := d.u16()
+			seq := d.u32()
+			logTime := d.u64()
+			d.u64() // publish time
+			if d.err != nil {
+				return Message{}, fmt.Errorf("bad message record: %w", d.err)
+			}
+			c, ok := r.channels[ch]
+			if !ok {
+				return Message{}, fmt.Errorf("message on unknown channel %d", ch)
+			}
+			return Message{Channel: c, Seq: seq, LogTime: time.Unix(0, int64(logTime)), Data: d.b}, nil
+		case opChunk:
+			return Message{}, errors.New("chunked files are not supported")
+		case opDataEnd, opFooter:
+			r.done = true
+		}
+		if d.err != nil {
+			return Message{}, fmt.Errorf("bad record 0x%02x: %w", h[0], d.err)
+		}
+	}
+	return Message{}, io.EOF
+}
+
+// Close closes the file.
+func (r *Reader) Close() error { return r.f.Close() }
+
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// decoder reads the fields of a record, remembering the first overrun.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil || n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes(int(d.u32())))
+}
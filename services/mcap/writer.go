@@ -0,0 +1,201 @@
+// Package mcap writes MCAP files, the log format of Foxglove Studio and
+// ROS 2, without chunking or compression, and reads them back. The
+// summary section repeats the schemas and channels and carries the message
+// statistics; there is no message index, so readers scan the data section.
+package mcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// Magic starts and ends every MCAP file.
+const Magic = "\x89MCAP0\r\n"
+
+// Record opcodes.
+const (
+	opHeader     = 0x01
+	opFooter     = 0x02
+	opSchema     = 0x03
+	opChannel    = 0x04
+	opMessage    = 0x05
+	opStatistics = 0x0B
+	opDataEnd    = 0x0F
+)
+
+type schema struct {
+	id             uint16
+	name, encoding string
+	data           []byte
+}
+
+type channel struct {
+	id, schema      uint16
+	topic, encoding string
+	seq             uint32
+	count           uint64
+}
+
+// Writer writes one MCAP file. It is not safe for concurrent use.
+type Writer struct {
+	f   *os.File
+	w   *bufio.Writer
+	err error
+
+	schemas    []schema
+	channels   []*channel
+	messages   uint64
+	start, end uint64
+}
+
+// Create creates the file at path; library names the writer in its
+// header.
+func Create(path, library string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, w: bufio.NewWriterSize(f, 256<<10)}
+	w.w.WriteString(Magic)
+	w.record(opHeader, str(nil, ""), library)
+	return w, nil
+}
+
+// Schema adds a schema: name, its encoding (e.g. jsonschema) and its
+// definition. It returns the ID channels refer to.
+func (w *Writer) Schema(name, encoding string, data []byte) uint16 {
+	s := schema{id: uint16(len(w.schemas) + 1), name: name, encoding: encoding, data: data}
+	w.schemas = append(w.schemas, s)
+	w.w.Write(s.record())
+	return s.id
+}
+
+// Channel adds a topic carrying messages of the schema in the given
+// message encoding (e.g. json) and returns its ID.
+func (w *Writer) Channel(topic string, schemaID uint16, encoding string) uint16 {
+	c := &channel{id: uint16(len(w.channels)), schema: schemaID, topic: topic, encoding: encoding}
+	w.channels = append(w.channels, c)
+	w.w.Write(c.record())
+	return c.id
+}
+
+// Write appends a message of channel ch logged at ts.
+func (w *Writer) Write(ch uint16, ts time.Time, data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	c := w.channels[ch]
+	t := uint64(ts.UnixNano())
+	b := binary.LittleEndian.AppendUint16(nil, ch)
+	b = binary.LittleEndian.AppendUint32(b, c.seq)
+	b = binary.LittleEndian.AppendUint64(b, t)
+	b = binary.LittleEndian.AppendUint64(b, t)
+	w.record(opMessage, append(b, data...), "")
+	c.seq++
+	c.count++
+	if w.messages == 0 || t < w.start {
+		w.start = t
+	}
+	w.end = max(w.end, t)
+	w.messages++
+	return w.err
+}
+
+// Flush writes the buffered messages to the file.
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// Close ends the data section, writes the summary and footer and closes
+// the file.
+func (w *Writer) Close() error {
+	// A data section CRC of 0 tells readers not to check it.
+	w.record(opDataEnd, make([]byte, 4), "")
+	pos, err := w.f.Seek(0, 1)
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	summary := uint64(pos) + uint64(w.w.Buffered())
+	for _, s := range w.schemas {
+		w.w.Write(s.record())
+	}
+	for _, c := range w.channels {
+		w.w.Write(c.record())
+	}
+	w.record(opStatistics, w.statistics(), "")
+	footer := binary.LittleEndian.AppendUint64(nil, summary)
+	footer = binary.LittleEndian.AppendUint64(footer, 0)
+	w.record(opFooter, binary.LittleEndian.AppendUint32(footer, 0), "")
+	w.w.WriteString(Magic)
+	err = w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *Writer) statistics() []byte {
+	b := binary.LittleEndian.AppendUint64(nil, w.messages)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(w.schemas)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(w.channels)))
+	b = append(b, make([]byte, 12)...) // attachments, metadata, chunks
+	b = binary.LittleEndian.AppendUint64(b, w.start)
+	b = binary.LittleEndian.AppendUint64(b, w.end)
+	counts := make([]byte, 0, 10*len(w.channels))
+	for _, c := range w.channels {
+		counts = binary.LittleEndian.AppendUint16(counts, c.id)
+		counts = binary.LittleEndian.AppendUint64(counts, c.count)
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(counts)))
+	return append(b, counts...)
+}
+
+// record writes a record of content followed by the string tail, if any.
+func (w *Writer) record(op byte, content []byte, tail string) {
+	if w.err != nil {
+		return
+	}
+	if tail != "" {
+		content = str(content, tail)
+	}
+	var h [9]byte
+	h[0] = op
+	binary.LittleEndian.PutUint64(h[1:], uint64(len(content)))
+	w.w.Write(h[:])
+	_, w.err = w.w.Write(content)
+}
+
+func (s schema) record() []byte {
+	b := binary.LittleEndian.AppendUint16(nil, s.id)
+	b = str(b, s.name)
+	b = str(b, s.encoding)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s.data)))
+	return frame(opSchema, append(b, s.data...))
+}
+
+func (c *channel) record() []byte {
+	b := binary.LittleEndian.AppendUint16(nil, c.id)
+	b = binary.LittleEndian.AppendUint16(b, c.schema)
+	b = str(b, c.topic)
+	b = str(b, c.encoding)
+	// No metadata: an empty map is its zero byte length.
+	return frame(opChannel, binary.LittleEndian.AppendUint32(b, 0))
+}
+
+// frame returns a complete record.
+func frame(op byte, content []byte) []byte {
+	b := binary.LittleEndian.AppendUint64([]byte{op}, uint64(len(content)))
+	return append(b, content...)
+}
+
+// str appends s prefixed with its uint32 length.
+func str(b []byte, s string) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,111 @@
+// Package metrics renders the logger's counters in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric types.
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// Sample is one value of a metric.
+type Sample struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Collector returns the current samples of a component. It is called on
+// every scrape.
+type Collector func() []Sample
+
+// Registry holds the collectors exposed on /metrics.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+func NewRegistry() *Registry { return &Registry{} }
+
+// Register adds c to the scrape.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// WithLabel returns a collector adding the label key=value to every sample
+// of c, to tell apart the samples of several instances of a component.
+func WithLabel(c Collector, key, value string) Collector {
+	return func() []Sample {
+		samples := c()
+		for i, s := range samples {
+			l := make(map[string]string, len(s.Labels)+1)
+			for k, v := range s.Labels {
+				l[k] = v
+			}
+			l[key] = value
+			samples[i].Labels = l
+		}
+		return samples
+	}
+}
+
+// ServeHTTP renders the current samples for a scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.write(w)
+}
+
+// write renders every sample, grouped by metric name.
+func (r *Registry) write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.mu.Unlock()
+	byName := map[string][]Sample{}
+	var names []string
+	for _, c := range collectors {
+		for _, s := range c() {
+			if _, ok := byName[s.Name]; !ok {
+				names = append(names, s.Name)
+			}
+			byName[s.Name] = append(byName[s.Name], s)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		samples := byName[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, samples[0].Help, name, samples[0].Type)
+		for _, s := range samples {
+			fmt.Fprintf(w, "%s%s %g\n", name, labels(s.Labels), s.Value)
+		}
+	}
+}
+
+func labels(l map[string]string) string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l[k])
+		parts[i] = fmt.Sprintf(`%s="%s"`, k, v)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
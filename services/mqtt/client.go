@@ -0,0 +1,226 @@
+// Package mqtt implements a minimal MQTT 3.1.1 client that publishes at
+// QoS 0, enough to push telemetry to a broker such as Mosquitto.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeConnect    = 0x10
+	typeConnack    = 0x20
+	typePublish    = 0x30
+	typePingreq    = 0xc0
+	typePingresp   = 0xd0
+	typeDisconnect = 0xe0
+
+	dialTimeout = 5 * time.Second
+)
+
+// Options configures a connection.
+type Options struct {
+	// Broker is "tcp://host:port" or "host:port"; the port defaults to 1883.
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+}
+
+// Client is a connected MQTT session. Publish is safe for concurrent use.
+// Once the connection fails every call returns an error; callers reconnect
+// by dialling a new Client.
+type Client struct {
+	conn net.Conn
+	mu   sync.Mutex
+	w    *bufio.Writer
+	done chan struct{}
+	err  error
+}
+
+// Dial connects to the broker and completes the CONNECT handshake.
+func Dial(o Options) (*Client, error) {
+	addr := strings.TrimPrefix(o.Broker, "tcp://")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "1883")
+	}
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: dial %s: %w", addr, err)
+	}
+	c := &Client{conn: conn, w: bufio.NewWriter(conn), done: make(chan struct{})}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := c.connect(o); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	go c.read()
+	if o.KeepAlive > 0 {
+		go c.ping(o.KeepAlive)
+	}
+	return c, nil
+}
+
+func (c *Client) connect(o Options) error {
+	var vh []byte
+	vh = appendString(vh, "MQTT")
+	flags := byte(0x02) // clean session
+	if o.Username != "" {
+		flags |= 0x80
+	}
+	if o.Password != "" {
+		flags |= 0x40
+	}
+	vh = append(vh, 4, flags)
+	vh = binary.BigEndian.AppendUint16(vh, uint16(o.KeepAlive/time.Second))
+	vh = appendString(vh, o.ClientID)
+	if o.Username != "" {
+		vh = appendString(vh, o.Username)
+	}
+	if o.Password != "" {
+		vh = appendString(vh, o.Password)
+	}
+	if err := c.send(typeConnect, vh); err != nil {
+		return fmt.Errorf("mqtt: connect: %w", err)
+	}
+
+	r := bufio.NewReader(c.conn)
+	typ, body, err := readPacket(r)
+	if err != nil {
+		return fmt.Errorf("mqtt: connack: %w", err)
+	}
+	if typ != typeConnack || len(body) != 2 {
+		return errors.New("mqtt: expected CONNACK")
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("mqtt: broker refused connection (code %d)", body[1])
+	}
+	return nil
+}
+
+// Publish sends payload to topic at QoS 0.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	header := byte(typePublish)
+	if retain {
+		header |= 0x01
+	}
+	body := appendString(make([]byte, 0, 2+len(topic)+len(payload)), topic)
+	return c.send(header, append(body, payload...))
+}
+
+// Close sends DISCONNECT and closes the connection.
+func (c *Client) Close() error {
+	c.send(typeDisconnect, nil)
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Err returns the error that ended the connection, or nil while it is up.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) send(header byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.w.WriteByte(header)
+	c.w.Write(appendLength(nil, len(body)))
+	c.w.Write(body)
+	if err := c.w.Flush(); err != nil {
+		c.err = err
+		return err
+	}
+	return nil
+}
+
+// read consumes broker packets (only PINGRESP is expected) until the
+// connection fails.
+func (c *Client) read() {
+	defer close(c.done)
+	r := bufio.NewReader(c.conn)
+	for {
+		if _, _, err := readPacket(r); err != nil {
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = fmt.Errorf("mqtt: connection lost: %w", err)
+			}
+			c.mu.Unlock()
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *Client) ping(every time.Duration) {
+	t := time.NewTicker(every / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if c.send(typePingreq, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		mult *= 128
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return header & 0xf0, body, err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendLength(b []byte, n int) []byte {
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if n == 0 {
+			return b
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Package ntrip is a client for NTRIP casters, which stream RTCM
+// differential corrections that let a GNSS receiver reach an RTK fix.
+package ntrip
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	// readTimeout drops a connection on which the caster went quiet;
+	// corrections normally arrive every second.
+	readTimeout = 30 * time.Second
+)
+
+// Client receives the corrections of one mountpoint and copies them to the
+// receiver. It speaks NTRIP 2.0 and accepts NTRIP 1.0 ("ICY 200 OK")
+// replies.
+type Client struct {
+	cfg      utils.NTRIPConfig
+	password string
+	log      utils.Logger
+
+	mu  sync.Mutex
+	gga string
+
+	last atomic.Int64 // unix nanoseconds of the last correction data
+}
+
+func NewClient(cfg utils.NTRIPConfig, log utils.Logger) (*Client, error) {
+	password, err := utils.ReadSecret(cfg.Password, cfg.PasswordFile)
+	if err != nil {
+		return nil, fmt.Errorf("ntrip: password: %w", err)
+	}
+	return &Client{cfg: cfg, password: password, log: log}, nil
+}
+
+// SetPosition sets the GGA sentence reported to the caster.
+func (c *Client) SetPosition(gga string) {
+	c.mu.Lock()
+	c.gga = gga
+	c.mu.Unlock()
+}
+
+func (c *Client) position() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gga
+}
+
+// Age returns the time since corrections last arrived, false before the
+// first.
+func (c *Client) Age() (time.Duration, bool) {
+	last := c.last.Load()
+	if last == 0 {
+		return 0, false
+	}
+	return utils.Now().Sub(time.Unix(0, last)), true
+}
+
+// Run copies corrections to w until ctx is done, reconnecting after
+// ReconnectS when the connection fails.
+func (c *Client) Run(ctx context.Context, w io.Writer) {
+	for {
+		err := c.stream(ctx, w)
+		if ctx.Err() != nil {
+			return
+		}
+		c.log.Warnf("ntrip: %s/%s: %v; reconnecting in %d s", c.cfg.Caster, c.cfg.Mountpoint, err, c.cfg.ReconnectS)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(c.cfg.ReconnectS) * time.Second):
+		}
+	}
+}
+
+// stream runs one connection to the caster.
+func (c *Client) stream(ctx context.Context, w io.Writer) error {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", c.cfg.Caster)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := io.WriteString(conn, c.request()); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	body, err := readResponse(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	c.log.Infof("ntrip: receiving corrections from %s/%s", c.cfg.Caster, c.cfg.Mountpoint)
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.sendPosition(conn, done)
+
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		n, err := body.Read(buf)
+		if n > 0 {
+			c.last.Store(utils.Now().UnixNano())
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("write to receiver: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			return errors.New("caster closed the connection")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) request() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "GET /%s HTTP/1.1\r\n", c.cfg.Mountpoint)
+	fmt.Fprintf(&b, "Host: %s\r\n", c.cfg.Caster)
+	b.WriteString("Ntrip-Version: Ntrip/2.0\r\n")
+	b.WriteString("User-Agent: NTRIP Sensor-Logger\r\n")
+	if c.cfg.User != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(c.cfg.User + ":" + c.password))
+		fmt.Fprintf(&b, "Authorization: Basic %s\r\n", auth)
+	}
+	if gga := c.position(); gga != "" {
+		fmt.Fprintf(&b, "Ntrip-GGA: %s\r\n", gga)
+	}
+	b.WriteString("Connection: close\r\n\r\n")
+	return b.String()
+}
+
+// sendPosition reports the receiver position every GGAIntervalS until done.
+func (c *Client) sendPosition(conn net.Conn, done <-chan struct{}) {
+	t := time.NewTicker(time.Duration(c.cfg.GGAIntervalS) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		if gga := c.position(); gga != "" {
+			if _, err := io.WriteString(conn, gga+"\r\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readResponse checks the caster's reply and returns the correction
+// stream that follows it.
+func readResponse(br *bufio.Reader) (io.Reader, error) {
+	tp := textproto.NewReader(br)
+	status, err := tp.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("read reply: %w", err)
+	}
+	switch {
+	case status == "ICY 200 OK":
+		// NTRIP 1.0: the data follows directly.
+		return br, nil
+	case strings.HasPrefix(status, "SOURCETABLE"):
+		return nil, errors.New("mountpoint not found (caster sent its source table)")
+	case !strings.HasPrefix(status, "HTTP/1."):
+		return nil, fmt.Errorf("unexpected reply %q", status)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("read reply: %w", err)
+	}
+	_, code, _ := strings.Cut(status, " ")
+	if !strings.HasPrefix(code, "200") {
+		return nil, fmt.Errorf("caster replied %s", code)
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "gnss/sourcetable") {
+		return nil, errors.New("mountpoint not found (caster sent its source table)")
+	}
+	if strings.EqualFold(header.Get("Transfer-Encoding"), "chunked") {
+		return httputil.NewChunkedReader(br), nil
+	}
+	return br, nil
+}
@@ -0,0 +1,238 @@
+// Package overlay draws sensor data projected with the calibration over
+// camera frames, for checking the extrinsics by eye.
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/transform"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Dir is the directory of a session the overlays are saved under, in a
+// subdirectory per processor.
+const Dir = "overlays"
+
+// Target colours: approaching, receding and about stationary relative to
+// the radar.
+var (
+	approaching = color.RGBA{230, 40, 40, 255}
+	receding    = color.RGBA{40, 90, 230, 255}
+	stationary  = color.RGBA{40, 200, 60, 255}
+)
+
+// stationaryMps is the radial speed below which a target is drawn as
+// stationary.
+const stationaryMps = 0.5
+
+// RadarOverlay is a processor drawing the targets of the latest radar
+// scan onto a camera frame every interval_s: a disc per target, larger
+// the closer it is, coloured by its radial velocity. It passes every
+// sample on unchanged. The latest overlay is served over HTTP and, with
+// save, written to the session. Frames arriving while the previous
+// overlay is still being drawn are skipped.
+type RadarOverlay struct {
+	name   string
+	cfg    utils.RadarOverlayConfig
+	camera *transform.Camera
+	radar  *transform.Transformer
+	period time.Duration
+	maxAge time.Duration
+	log    utils.Logger
+
+	busy atomic.Bool
+
+	mu     sync.Mutex
+	scan   *models.RadarScan
+	last   time.Time // of the last frame drawn on
+	dir    string    // where overlays are saved, "" when they are not
+	latest []byte
+	failed bool
+}
+
+func NewRadarOverlay(name string, cfg utils.RadarOverlayConfig, cal utils.CalibrationConfig, log utils.Logger) *RadarOverlay {
+	return &RadarOverlay{
+		name:   name,
+		cfg:    cfg,
+		camera: transform.NewCamera(cal),
+		radar:  transform.NewTransformer(cal),
+		period: time.Duration(cfg.IntervalS * float64(time.Second)),
+		maxAge: time.Duration(cfg.MaxAgeMs) * time.Millisecond,
+		log:    log,
+	}
+}
+
+// UseSession saves the overlays from now on under the session in dir,
+// when save is set.
+func (o *RadarOverlay) UseSession(dir string) {
+	if !o.cfg.Save {
+		return
+	}
+	path := filepath.Join(dir, Dir, o.name)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		o.log.Errorf("%s: %v", o.name, err)
+		return
+	}
+	o.mu.Lock()
+	o.dir = path
+	o.mu.Unlock()
+}
+
+func (o *RadarOverlay) Process(s models.SensorSample) (models.SensorSample, bool) {
+	switch v := s.(type) {
+	case models.RadarScan:
+		o.mu.Lock()
+		o.scan = &v
+		o.mu.Unlock()
+	case models.CameraFrame:
+		o.observe(v)
+	}
+	return s, true
+}
+
+// observe draws the overlay on f when the interval is up and the
+// previous one is done.
+func (o *RadarOverlay) observe(f models.CameraFrame) {
+	if len(f.Data) == 0 || !o.busy.CompareAndSwap(false, true) {
+		return
+	}
+	defer o.busy.Store(false)
+	o.mu.Lock()
+	scan := o.scan
+	due := f.Timestamp.Sub(o.last) >= o.period
+	if due {
+		o.last = f.Timestamp
+	}
+	o.mu.Unlock()
+	if !due {
+		return
+	}
+	if scan != nil && f.Timestamp.Sub(scan.Timestamp).Abs() > o.maxAge {
+		scan = nil
+	}
+	data, err := o.draw(f, scan)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err != nil {
+		if !o.failed {
+			o.log.Warnf("%s: frame %d: %v (further errors are not logged)", o.name, f.FrameID, err)
+			o.failed = true
+		}
+		return
+	}
+	o.latest = data
+	if o.dir != "" {
+		path := filepath.Join(o.dir, fmt.Sprintf("%08d.jpg", f.FrameID))
+		if err := os.WriteFile(path, data, 0o644); err != nil && !o.failed {
+			o.log.Warnf("%s: %v (further errors are not logged)", o.name, err)
+			o.failed = true
+		}
+	}
+}
+
+// draw returns f as a JPEG with the targets of scan, if any, drawn on.
+func (o *RadarOverlay) draw(f models.CameraFrame, scan *models.RadarScan) ([]byte, error) {
+	src, err := jpeg.Decode(bytes.NewReader(f.Data))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+	if scan != nil {
+		pts, _ := o.radar.RadarTargets(*scan, transform.FrameVehicle)
+		// A disc is 2% of the image width at 10 m, no smaller than 3 pixels.
+		scale := 0.02 * float64(b.Dx()) * 10
+		for i, p := range pts {
+			u, v, depth, ok := o.camera.Project(p, b.Dx(), b.Dy())
+			if !ok {
+				continue
+			}
+			c := stationary
+			switch vel := scan.Targets[i].VelocityMps; {
+			case vel < -stationaryMps:
+				c = approaching
+			case vel > stationaryMps:
+				c = receding
+			}
+			disc(img, u, v, max(scale/depth, 3), c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: o.cfg.Quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// disc draws a disc of radius r at u, v in c, ringed in black so it shows
+// on any background.
+func disc(img *image.RGBA, u, v, r float64, c color.RGBA) {
+	ring := r + max(r/4, 1)
+	b := img.Bounds()
+	for y := max(int(v-ring), b.Min.Y); y <= min(int(v+ring), b.Max.Y-1); y++ {
+		for x := max(int(u-ring), b.Min.X); x <= min(int(u+ring), b.Max.X-1); x++ {
+			d := math.Hypot(float64(x)+0.5-u, float64(y)+0.5-v)
+			switch {
+			case d <= r:
+				img.SetRGBA(x, y, c)
+			case d <= ring:
+				img.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+			}
+		}
+	}
+}
+
+// ServeImage answers with the latest overlay.
+func (o *RadarOverlay) ServeImage(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	data := o.latest
+	o.mu.Unlock()
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+var page = template.Must(template.New("overlay").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Radar overlay {{.Name}}</title>
+<style>body{font-family:sans-serif;margin:2em}img{max-width:100%}</style></head>
+<body><h1>Radar overlay {{.Name}}</h1>
+<p>Radar targets projected onto the camera: red approaching, blue receding, green stationary; larger is closer.</p>
+<img id="latest" alt="waiting for an overlay…">
+<script>
+function refresh() {
+  document.getElementById("latest").src = "{{.Name}}/latest.jpg?t=" + Date.now();
+}
+refresh();
+setInterval(refresh, {{.RefreshMs}});
+</script>
+</body></html>
+`))
+
+// ServePage answers with a page showing the latest overlay, refreshed
+// every interval.
+func (o *RadarOverlay) ServePage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.Execute(w, struct {
+		Name      string
+		RefreshMs int
+	}{o.name, int(max(o.period, time.Second) / time.Millisecond)})
+}
@@ -0,0 +1,444 @@
+// Package pack bundles a closed session into a single archive, to move it
+// between machines as one file, and unpacks such an archive again. Every
+// file is checked against its SHA-256 both ways.
+package pack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// Archive formats.
+const (
+	TarZst = "tar.zst"
+	Zip    = "zip"
+)
+
+// ChecksumFile lists the SHA-256 of every other file of a packed session,
+// as sha256sum prints them, so that "sha256sum -c SHA256SUMS" also checks
+// an unpacked session.
+const ChecksumFile = "SHA256SUMS"
+
+// Format returns the format of the archive at path by its extension, ""
+// if it is not one.
+func Format(path string) string {
+	switch {
+	case strings.HasSuffix(path, "."+TarZst):
+		return TarZst
+	case strings.HasSuffix(path, "."+Zip):
+		return Zip
+	}
+	return ""
+}
+
+// Pack writes the closed session in dir to <out>/<session>.<format> and
+// returns its path. Its files are under <session>/: the manifest first,
+// at <session>/manifest.json, so that it can be read without going
+// through the frames and clouds, and ChecksumFile last. The archive is
+// read back and checked against the checksums before it takes its name;
+// a failed one is removed.
+func Pack(dir, out, format string) (string, error) {
+	session := filepath.Base(dir)
+	if _, err := os.Stat(filepath.Join(dir, views.ManifestFile)); err != nil {
+		return "", fmt.Errorf("%s has no %s: not a closed session", session, views.ManifestFile)
+	}
+	files, err := sessionFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(out, session+"."+format)
+	tmp := dst + views.PartialSuffix
+	if err := write(tmp, format, dir, session, files); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if _, err := unpack(tmp, format, ""); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("verify %s: %w", filepath.Base(dst), err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dst, nil
+}
+
+// sessionFiles returns the files of the session in dir, slash-separated
+// and relative to it, the manifest first and the rest sorted.
+func sessionFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", p)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); rel != views.ManifestFile && rel != ChecksumFile {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return append([]string{views.ManifestFile}, files...), err
+}
+
+// writer adds the members of an archive.
+type writer interface {
+	add(name string, size int64, mtime time.Time, r io.Reader) error
+	close() error
+}
+
+func write(path, format, dir, session string, files []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := bufio.NewWriterSize(f, 256*1024)
+	var w writer
+	if format == Zip {
+		w = &zipWriter{zw: zip.NewWriter(buf)}
+	} else {
+		zw, err := zstd.NewWriter(buf)
+		if err != nil {
+			return err
+		}
+		w = &tarWriter{zw: zw, tw: tar.NewWriter(zw)}
+	}
+	var sums strings.Builder
+	for _, rel := range files {
+		sum, err := addFile(w, filepath.Join(dir, filepath.FromSlash(rel)), session+"/"+rel)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, rel)
+	}
+	if err := w.add(session+"/"+ChecksumFile, int64(sums.Len()), time.Now(), strings.NewReader(sums.String())); err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// addFile adds the file at path as name and returns its checksum.
+func addFile(w writer, path, name string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if err := w.add(name, fi.Size(), fi.ModTime(), io.TeeReader(f, h)); err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type tarWriter struct {
+	zw *zstd.Encoder
+	tw *tar.Writer
+}
+
+func (t *tarWriter) add(name string, size int64, mtime time.Time, r io.Reader) error {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: size, ModTime: mtime.Truncate(time.Second)}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// A file that grew since it was listed is cut to its listed size; the
+	// checksum then covers what was archived.
+	_, err := io.CopyN(t.tw, r, size)
+	return err
+}
+
+func (t *tarWriter) close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.zw.Close()
+}
+
+// stored are the extensions of files that are compressed already and are
+// stored in zip archives as they are.
+var stored = map[string]bool{".jpg": true, ".png": true, ".webp": true, ".clz": true, ".gz": true, ".mp4": true}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(name string, size int64, mtime time.Time, r io.Reader) error {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime}
+	if stored[strings.ToLower(path.Ext(name))] {
+		hdr.Method = zip.Store
+	}
+	w, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, r, size)
+	return err
+}
+
+func (z *zipWriter) close() error { return z.zw.Close() }
+
+// member is a file of an archive being read.
+type member struct {
+	name  string
+	mtime time.Time
+	r     io.Reader
+}
+
+// walk calls fn with every file of the archive at path, in format, in
+// order.
+func walk(path, format string, fn func(m member) error) error {
+	switch format {
+	case Zip:
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(member{name: f.Name, mtime: f.Modified, r: rc})
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case TarZst:
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		zr, err := zstd.NewReader(bufio.NewReaderSize(f, 256*1024))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		tr := tar.NewReader(zr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := fn(member{name: hdr.Name, mtime: hdr.ModTime, r: tr}); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("%s: not a .%s or .%s archive", filepath.Base(path), TarZst, Zip)
+}
+
+// Verify checks every file of the archive at path against its checksum
+// and returns the session it holds.
+func Verify(path string) (string, error) {
+	return unpack(path, Format(path), "")
+}
+
+// VerifyDir checks the files of the unpacked session in dir against its
+// ChecksumFile. Files added since, such as a report, are not checked.
+func VerifyDir(dir string) error {
+	sums, err := os.ReadFile(filepath.Join(dir, ChecksumFile))
+	if err != nil {
+		return err
+	}
+	got := map[string]string{}
+	for _, line := range strings.Split(strings.TrimRight(string(sums), "\n"), "\n") {
+		_, rel, ok := strings.Cut(line, "  ")
+		if !ok || !fs.ValidPath(rel) {
+			return fmt.Errorf("%s: malformed line %q", ChecksumFile, line)
+		}
+		sum, err := hashFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		got[rel] = sum
+	}
+	return check(sums, got)
+}
+
+// hashFile returns the checksum of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Unpack extracts the archive at path into baseDir and returns the
+// session directory. The files are checked against their checksums as
+// they are extracted, into a hidden directory that takes the session's
+// name only once all of them matched. An existing session is not
+// overwritten.
+func Unpack(path, baseDir string) (string, error) {
+	return unpack(path, Format(path), baseDir)
+}
+
+// unpack reads the archive at path, extracting it into baseDir unless
+// that is empty, and checks it.
+func unpack(path, format, baseDir string) (string, error) {
+	var session, tmp string
+	got := map[string]string{}
+	var sums []byte
+	err := walk(path, format, func(m member) error {
+		top, rel, ok := strings.Cut(m.name, "/")
+		switch {
+		case !ok || top == "" || rel == "" || !fs.ValidPath(m.name):
+			return fmt.Errorf("%s: not a file of a packed session", m.name)
+		case session == "":
+			session = top
+			if baseDir != "" {
+				if _, err := os.Stat(filepath.Join(baseDir, session)); err == nil {
+					return fmt.Errorf("%s already exists in %s", session, baseDir)
+				}
+				tmp = filepath.Join(baseDir, ".unpack-"+session)
+				os.RemoveAll(tmp)
+			}
+		case top != session:
+			return fmt.Errorf("%s: not under %s/", m.name, session)
+		}
+		if rel == ChecksumFile {
+			var err error
+			sums, err = io.ReadAll(m.r)
+			return err
+		}
+		if _, dup := got[rel]; dup {
+			return fmt.Errorf("%s: archived twice", m.name)
+		}
+		h := sha256.New()
+		if tmp == "" {
+			if _, err := io.Copy(h, m.r); err != nil {
+				return fmt.Errorf("%s: %w", m.name, err)
+			}
+		} else if err := extract(filepath.Join(tmp, filepath.FromSlash(rel)), m, h); err != nil {
+			return err
+		}
+		got[rel] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err == nil {
+		err = check(sums, got)
+	}
+	if err == nil && session == "" {
+		err = errors.New("empty archive")
+	}
+	if err == nil && tmp != "" {
+		err = os.WriteFile(filepath.Join(tmp, ChecksumFile), sums, 0o644)
+	}
+	if err == nil && tmp != "" {
+		err = os.Rename(tmp, filepath.Join(baseDir, session))
+	}
+	if err != nil {
+		if tmp != "" {
+			os.RemoveAll(tmp)
+		}
+		return "", err
+	}
+	if baseDir != "" {
+		return filepath.Join(baseDir, session), nil
+	}
+	return session, nil
+}
+
+// extract writes the member m to path, hashing it with h.
+func extract(path string, m member, h io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.MultiWriter(f, h), m.r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.name, err)
+	}
+	os.Chtimes(path, m.mtime, m.mtime)
+	return nil
+}
+
+// check compares the checksums of the files read, got, with the listing
+// of ChecksumFile.
+func check(sums []byte, got map[string]string) error {
+	if sums == nil {
+		return fmt.Errorf("no %s", ChecksumFile)
+	}
+	listed := 0
+	for _, line := range strings.Split(strings.TrimRight(string(sums), "\n"), "\n") {
+		sum, rel, ok := strings.Cut(line, "  ")
+		if !ok {
+			return fmt.Errorf("%s: malformed line %q", ChecksumFile, line)
+		}
+		switch g, found := got[rel]; {
+		case !found:
+			return fmt.Errorf("%s is missing", rel)
+		case g != sum:
+			return fmt.Errorf("%s does not match its checksum", rel)
+		}
+		listed++
+	}
+	if listed != len(got) {
+		return fmt.Errorf("%d files not listed in %s", len(got)-listed, ChecksumFile)
+	}
+	return nil
+}
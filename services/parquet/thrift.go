@@ -0,0 +1,79 @@
+package parquet
+
+import "encoding/binary"
+
+// Thrift compact protocol types.
+const (
+	tI32    = 5
+	tI64    = 6
+	tBinary = 8
+	tList   = 9
+	tStruct = 12
+)
+
+// thrift encodes the Parquet metadata structures in the Thrift compact
+// protocol. Field IDs are delta-encoded against the last field of the
+// enclosing struct, hence the stack.
+type thrift struct {
+	b    []byte
+	last []int16
+}
+
+func newThrift() *thrift { return &thrift{last: []int16{0}} }
+
+func (t *thrift) field(id int16, typ byte) {
+	top := &t.last[len(t.last)-1]
+	if d := id - *top; d > 0 && d <= 15 {
+		t.b = append(t.b, byte(d)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = binary.AppendVarint(t.b, int64(id))
+	}
+	*top = id
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, tI32)
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, tI64)
+	t.b = binary.AppendVarint(t.b, v)
+}
+
+func (t *thrift) binary(id int16, v string) {
+	t.field(id, tBinary)
+	t.str(v)
+}
+
+func (t *thrift) str(v string) {
+	t.b = binary.AppendUvarint(t.b, uint64(len(v)))
+	t.b = append(t.b, v...)
+}
+
+// list starts a list field of n elements of type typ, which follow as
+// bare values (or begin/end pairs for structs).
+func (t *thrift) list(id int16, typ byte, n int) {
+	t.field(id, tList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|typ)
+	} else {
+		t.b = append(t.b, 0xf0|typ)
+		t.b = binary.AppendUvarint(t.b, uint64(n))
+	}
+}
+
+// structField starts a struct field; begin starts a struct list element.
+func (t *thrift) structField(id int16) {
+	t.field(id, tStruct)
+	t.begin()
+}
+
+func (t *thrift) begin() { t.last = append(t.last, 0) }
+
+// end closes the innermost struct.
+func (t *thrift) end() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
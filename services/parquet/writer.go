@@ -0,0 +1,201 @@
+// Package parquet writes Apache Parquet files of string columns: flat,
+// required UTF-8 columns, plain-encoded and uncompressed, one data page per
+// column chunk.
+package parquet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Magic starts and ends every Parquet file.
+const Magic = "PAR1"
+
+// Parquet enums used in the metadata.
+const (
+	typeByteArray     = 6
+	repRequired       = 0
+	convertedUTF8     = 0
+	encodingPlain     = 0
+	encodingRLE       = 3
+	codecUncompressed = 0
+	pageData          = 0
+)
+
+type columnChunk struct {
+	offset, size int64
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	rows    int64
+	size    int64
+}
+
+// Writer writes one Parquet file. Rows are buffered until a row group is
+// full and written on Close otherwise, so a file not closed has no footer
+// and cannot be read. It is not safe for concurrent use.
+type Writer struct {
+	f   *os.File
+	w   *bufio.Writer
+	pos int64
+	err error
+
+	columns   []string
+	groupRows int
+	values    [][]string // buffered row group, by column
+	groups    []rowGroup
+	rows      int64
+}
+
+// Create creates the file at path with the given columns, writing a row
+// group every groupRows rows.
+func Create(path string, columns []string, groupRows int) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("parquet: %s: no columns", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, w: bufio.NewWriterSize(f, 256<<10), columns: columns, groupRows: groupRows,
+		values: make([][]string, len(columns))}
+	w.write([]byte(Magic))
+	return w, w.err
+}
+
+// Write appends a row, a value per column.
+func (w *Writer) Write(row []string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: row of %d values for %d columns", len(row), len(w.columns))
+	}
+	for i, v := range row {
+		w.values[i] = append(w.values[i], v)
+	}
+	if len(w.values[0]) >= w.groupRows {
+		w.writeGroup()
+	}
+	return w.err
+}
+
+// Flush writes the completed row groups to the file.
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// Close writes the buffered rows and the footer and closes the file.
+func (w *Writer) Close() error {
+	if len(w.values[0]) > 0 {
+		w.writeGroup()
+	}
+	meta := w.footer()
+	w.write(meta)
+	w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta))))
+	w.write([]byte(Magic))
+	err := w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeGroup writes the buffered rows as a row group, a column chunk of
+// one page per column.
+func (w *Writer) writeGroup() {
+	g := rowGroup{rows: int64(len(w.values[0]))}
+	for i, vals := range w.values {
+		var data []byte
+		for _, v := range vals {
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(v)))
+			data = append(data, v...)
+		}
+		t := newThrift()
+		t.i32(1, pageData)
+		t.i32(2, int32(len(data)))
+		t.i32(3, int32(len(data)))
+		t.structField(5)
+		t.i32(1, int32(len(vals)))
+		t.i32(2, encodingPlain)
+		t.i32(3, encodingRLE)
+		t.i32(4, encodingRLE)
+		t.end()
+		t.end()
+		c := columnChunk{offset: w.pos, size: int64(len(t.b) + len(data))}
+		w.write(t.b)
+		w.write(data)
+		g.columns = append(g.columns, c)
+		g.size += c.size
+		w.values[i] = vals[:0]
+	}
+	w.groups = append(w.groups, g)
+	w.rows += g.rows
+}
+
+// footer encodes the FileMetaData: the schema, a root with a child per
+// column, and the row groups.
+func (w *Writer) footer() []byte {
+	t := newThrift()
+	t.i32(1, 1)
+	t.list(2, tStruct, len(w.columns)+1)
+	t.begin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(w.columns)))
+	t.end()
+	for _, name := range w.columns {
+		t.begin()
+		t.i32(1, typeByteArray)
+		t.i32(3, repRequired)
+		t.binary(4, name)
+		t.i32(6, convertedUTF8)
+		t.structField(10) // LogicalType
+		t.structField(1)  // STRING
+		t.end()
+		t.end()
+		t.end()
+	}
+	t.i64(3, w.rows)
+	t.list(4, tStruct, len(w.groups))
+	for _, g := range w.groups {
+		t.begin()
+		t.list(1, tStruct, len(g.columns))
+		for i, c := range g.columns {
+			t.begin()
+			t.i64(2, c.offset)
+			t.structField(3)
+			t.i32(1, typeByteArray)
+			t.list(2, tI32, 1)
+			t.b = binary.AppendVarint(t.b, encodingPlain)
+			t.list(3, tBinary, 1)
+			t.str(w.columns[i])
+			t.i32(4, codecUncompressed)
+			t.i64(5, g.rows)
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.end()
+	}
+	t.binary(6, "sensor-logger")
+	t.end()
+	return t.b
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(p)
+	w.pos += int64(len(p))
+}
@@ -0,0 +1,476 @@
+// Package phonelog reads the exports of phone sensor logging apps: their
+// GPS fixes and IMU samples, which "sensor-logger import" records into a
+// session.
+package phonelog
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+// Export formats.
+const (
+	// SensorLogger is the export of the Sensor Logger app (iOS and
+	// Android): a folder, or a zip of it, with one CSV per sensor, e.g.
+	// Location.csv and Accelerometer.csv, timed in Unix nanoseconds.
+	SensorLogger = "sensorlogger"
+	// PhysicsToolbox is the export of Physics Toolbox Sensor Suite: one
+	// CSV with a column per sensor axis, timed by the time of day or in
+	// seconds since the start.
+	PhysicsToolbox = "physicstoolbox"
+)
+
+// Formats lists the export formats.
+var Formats = []string{SensorLogger, PhysicsToolbox}
+
+// standardGravity converts g to m/s².
+const standardGravity = 9.80665
+
+// Log is what an export holds, in the units of the models and in time
+// order. IMU samples are in the axes of the phone; their Seq numbers them
+// from 0.
+type Log struct {
+	Format string
+	GPS    []models.GPSData
+	IMU    []models.IMUData
+}
+
+// Start returns the time of the first sample.
+func (l *Log) Start() time.Time {
+	var t time.Time
+	if len(l.GPS) > 0 {
+		t = l.GPS[0].Timestamp
+	}
+	if len(l.IMU) > 0 && (t.IsZero() || l.IMU[0].Timestamp.Before(t)) {
+		t = l.IMU[0].Timestamp
+	}
+	return t
+}
+
+// Read reads the export at path in format, told from path when empty: a
+// folder or zip is a SensorLogger export, a CSV file a PhysicsToolbox
+// one. start dates the times of an export that has none: a time of day
+// falls on the day of start (by default the day the file was last
+// modified), and seconds since the start count from it.
+func Read(path, format string, start time.Time) (*Log, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		switch ext := strings.ToLower(filepath.Ext(path)); {
+		case fi.IsDir() || ext == ".zip":
+			format = SensorLogger
+		case ext == ".csv":
+			format = PhysicsToolbox
+		default:
+			return nil, fmt.Errorf("%s: cannot tell the format, give it", filepath.Base(path))
+		}
+	}
+	var l *Log
+	switch format {
+	case SensorLogger:
+		l, err = readSensorLogger(path, fi)
+	case PhysicsToolbox:
+		if start.IsZero() {
+			start = fi.ModTime()
+		}
+		l, err = readPhysicsToolbox(path, start)
+	default:
+		return nil, fmt.Errorf("unknown format %q, want one of %s", format, strings.Join(Formats, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(l.GPS) == 0 && len(l.IMU) == 0 {
+		return nil, fmt.Errorf("%s: no GPS fixes or IMU samples", filepath.Base(path))
+	}
+	l.Format = format
+	sort.SliceStable(l.GPS, func(i, j int) bool { return l.GPS[i].Timestamp.Before(l.GPS[j].Timestamp) })
+	sort.SliceStable(l.IMU, func(i, j int) bool { return l.IMU[i].Timestamp.Before(l.IMU[j].Timestamp) })
+	for i := range l.IMU {
+		l.IMU[i].Seq = uint64(i)
+	}
+	return l, nil
+}
+
+// table is a CSV file of an export, its column names lowercased.
+type table struct {
+	rows  [][]string
+	index map[string]int
+}
+
+func readTable(r io.Reader) (*table, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header")
+	}
+	t := &table{rows: records[1:], index: map[string]int{}}
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, dup := t.index[name]; !dup {
+			t.index[name] = i
+		}
+	}
+	return t, nil
+}
+
+// col returns the index of the first column named one of names, or
+// starting with one of them and a space or parenthesis, as in "speed
+// (m/s)"; -1 if there is none.
+func (t *table) col(names ...string) int {
+	for _, n := range names {
+		if i, ok := t.index[n]; ok {
+			return i
+		}
+	}
+	best := -1
+	for name, i := range t.index {
+		for _, n := range names {
+			if strings.HasPrefix(name, n+" ") || strings.HasPrefix(name, n+"(") {
+				if best < 0 || i < best {
+					best = i
+				}
+			}
+		}
+	}
+	return best
+}
+
+// float parses cell c of row, reporting false for an absent or empty cell.
+func float(row []string, c int) (float64, bool) {
+	if c < 0 || c >= len(row) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(row[c]), 64)
+	return v, err == nil && !math.IsNaN(v)
+}
+
+// series is a three-axis stream of an export.
+type series struct {
+	t []time.Time
+	v [][3]float64
+}
+
+// at returns the value of s at ts, interpolated between the samples
+// around it and held beyond the first and last; zero for no samples.
+func (s *series) at(ts time.Time) [3]float64 {
+	n := len(s.t)
+	if n == 0 {
+		return [3]float64{}
+	}
+	i := sort.Search(n, func(i int) bool { return !s.t[i].Before(ts) })
+	switch {
+	case i == 0:
+		return s.v[0]
+	case i == n:
+		return s.v[n-1]
+	}
+	a, b := s.v[i-1], s.v[i]
+	k := float64(ts.Sub(s.t[i-1])) / float64(s.t[i].Sub(s.t[i-1]))
+	return [3]float64{a[0] + (b[0]-a[0])*k, a[1] + (b[1]-a[1])*k, a[2] + (b[2]-a[2])*k}
+}
+
+// readSensorLogger reads a Sensor Logger export, a folder or a zip. The
+// accelerations are those of TotalAcceleration.csv, or of
+// Accelerometer.csv with Gravity.csv added back, as an IMU measures them;
+// the rates of Gyroscope.csv and the field of Magnetometer.csv are taken
+// at their times.
+func readSensorLogger(p string, fi os.FileInfo) (*Log, error) {
+	var fsys fs.FS
+	if fi.IsDir() {
+		fsys = os.DirFS(p)
+	} else {
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		fsys = zr
+	}
+	// The files may be in a folder of the zip.
+	files := map[string]string{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			base := strings.ToLower(path.Base(name))
+			if _, dup := files[base]; !dup {
+				files[base] = name
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	open := func(name string) (*table, error) {
+		f, ok := files[strings.ToLower(name)]
+		if !ok {
+			return nil, nil
+		}
+		r, err := fsys.Open(f)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		t, err := readTable(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return t, nil
+	}
+	readSeries := func(name string) (*series, error) {
+		t, err := open(name)
+		if t == nil || err != nil {
+			return nil, err
+		}
+		s := &series{}
+		tc, xc, yc, zc := t.col("time"), t.col("x"), t.col("y"), t.col("z")
+		if tc < 0 || xc < 0 || yc < 0 || zc < 0 {
+			return nil, fmt.Errorf("%s: needs the columns time, x, y and z", name)
+		}
+		for _, row := range t.rows {
+			ts, ok := unixNano(row, tc)
+			x, okx := float(row, xc)
+			y, oky := float(row, yc)
+			z, okz := float(row, zc)
+			if ok && okx && oky && okz {
+				s.t = append(s.t, ts)
+				s.v = append(s.v, [3]float64{x, y, z})
+			}
+		}
+		return s, nil
+	}
+
+	l := &Log{}
+	loc, err := open("Location.csv")
+	if err != nil {
+		return nil, err
+	}
+	if loc != nil {
+		l.GPS, err = sensorLoggerFixes(loc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	accel, err := readSeries("TotalAcceleration.csv")
+	if err != nil {
+		return nil, err
+	}
+	if accel == nil {
+		if accel, err = readSeries("Accelerometer.csv"); err != nil {
+			return nil, err
+		}
+		gravity, err := readSeries("Gravity.csv")
+		if err != nil {
+			return nil, err
+		}
+		if accel != nil && gravity != nil {
+			for i, ts := range accel.t {
+				g := gravity.at(ts)
+				accel.v[i] = [3]float64{accel.v[i][0] + g[0], accel.v[i][1] + g[1], accel.v[i][2] + g[2]}
+			}
+		}
+	}
+	if accel == nil {
+		return l, nil
+	}
+	gyro, err := readSeries("Gyroscope.csv")
+	if err != nil {
+		return nil, err
+	}
+	mag, err := readSeries("Magnetometer.csv")
+	if err != nil {
+		return nil, err
+	}
+	for i, ts := range accel.t {
+		d := models.IMUData{Timestamp: ts}
+		d.AccelX, d.AccelY, d.AccelZ = accel.v[i][0], accel.v[i][1], accel.v[i][2]
+		if gyro != nil {
+			w := gyro.at(ts)
+			d.GyroX, d.GyroY, d.GyroZ = w[0], w[1], w[2]
+		}
+		if mag != nil {
+			b := mag.at(ts)
+			d.MagX, d.MagY, d.MagZ = b[0], b[1], b[2]
+		}
+		l.IMU = append(l.IMU, d)
+	}
+	return l, nil
+}
+
+// unixNano parses cell c of row as Unix nanoseconds.
+func unixNano(row []string, c int) (time.Time, bool) {
+	if c >= len(row) {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(strings.TrimSpace(row[c]), 10, 64)
+	return time.Unix(0, ns).UTC(), err == nil && ns > 0
+}
+
+// sensorLoggerFixes reads Location.csv. Speeds, courses and accuracies
+// the phone did not know are negative there.
+func sensorLoggerFixes(t *table) ([]models.GPSData, error) {
+	tc, latc, lonc := t.col("time"), t.col("latitude"), t.col("longitude")
+	if tc < 0 || latc < 0 || lonc < 0 {
+		return nil, errors.New("Location.csv: needs the columns time, latitude and longitude")
+	}
+	altc, speedc, bearingc := t.col("altitude"), t.col("speed"), t.col("bearing")
+	haccc, vaccc, saccc, baccc := t.col("horizontalaccuracy"), t.col("verticalaccuracy"), t.col("speedaccuracy"), t.col("bearingaccuracy")
+	var fixes []models.GPSData
+	for _, row := range t.rows {
+		ts, ok := unixNano(row, tc)
+		lat, oklat := float(row, latc)
+		lon, oklon := float(row, lonc)
+		if !ok || !oklat || !oklon {
+			continue
+		}
+		g := models.GPSData{Timestamp: ts, Lat: lat, Lon: lon, FixQuality: 1}
+		g.Alt, _ = float(row, altc)
+		if v, ok := float(row, speedc); ok && v >= 0 {
+			g.SpeedMps = v
+			g.SpeedAccMps = known(row, saccc)
+		}
+		if v, ok := float(row, bearingc); ok && v >= 0 {
+			g.HeadingDeg = v
+			g.HeadingAccDeg = known(row, baccc)
+		}
+		g.HAccM, g.VAccM = known(row, haccc), known(row, vaccc)
+		fixes = append(fixes, g)
+	}
+	return fixes, nil
+}
+
+// known returns cell c of row unless it is absent or negative.
+func known(row []string, c int) *float64 {
+	if v, ok := float(row, c); ok && v >= 0 {
+		return &v
+	}
+	return nil
+}
+
+// readPhysicsToolbox reads a Physics Toolbox CSV. Accelerations are the
+// g-forces gFx..gFz in m/s², or the linear accelerations ax..az of
+// exports without them; rates are wx..wz and the field Bx..Bz. A fix is
+// taken from every row whose position differs from the row before.
+func readPhysicsToolbox(p string, start time.Time) (*Log, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := readTable(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+	}
+	tc := t.col("time")
+	if tc < 0 {
+		return nil, fmt.Errorf("%s: no time column", filepath.Base(p))
+	}
+	accel, scale := [3]int{t.col("gfx"), t.col("gfy"), t.col("gfz")}, standardGravity
+	if accel[0] < 0 {
+		accel, scale = [3]int{t.col("ax"), t.col("ay"), t.col("az")}, 1
+	}
+	gyro := [3]int{t.col("wx"), t.col("wy"), t.col("wz")}
+	mag := [3]int{t.col("bx"), t.col("by"), t.col("bz")}
+	latc, lonc := t.col("latitude", "lat"), t.col("longitude", "lon")
+	altc, speedc := t.col("altitude", "alt"), t.col("speed")
+
+	clock := newDayClock(start)
+	l := &Log{}
+	var last *models.GPSData
+	for _, row := range t.rows {
+		if tc >= len(row) {
+			continue
+		}
+		ts, err := clock.at(strings.TrimSpace(row[tc]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(p), err)
+		}
+		if ax, ok := float(row, accel[0]); ok {
+			ay, _ := float(row, accel[1])
+			az, _ := float(row, accel[2])
+			d := models.IMUData{Timestamp: ts, AccelX: ax * scale, AccelY: ay * scale, AccelZ: az * scale}
+			d.GyroX, _ = float(row, gyro[0])
+			d.GyroY, _ = float(row, gyro[1])
+			d.GyroZ, _ = float(row, gyro[2])
+			d.MagX, _ = float(row, mag[0])
+			d.MagY, _ = float(row, mag[1])
+			d.MagZ, _ = float(row, mag[2])
+			l.IMU = append(l.IMU, d)
+		}
+		lat, oklat := float(row, latc)
+		lon, oklon := float(row, lonc)
+		if !oklat || !oklon || lat == 0 && lon == 0 || last != nil && last.Lat == lat && last.Lon == lon {
+			continue
+		}
+		g := models.GPSData{Timestamp: ts, Lat: lat, Lon: lon, FixQuality: 1}
+		g.Alt, _ = float(row, altc)
+		if v, ok := float(row, speedc); ok && v >= 0 {
+			g.SpeedMps = v
+		}
+		l.GPS = append(l.GPS, g)
+		last = &l.GPS[len(l.GPS)-1]
+	}
+	return l, nil
+}
+
+// dayClock dates the times of a Physics Toolbox export: seconds since
+// start, or times of day ("15:04:05:000" or "15:04:05.000") on the day
+// of start in its zone, running past midnight into the next.
+type dayClock struct {
+	start time.Time
+	day   time.Time
+	last  time.Duration
+}
+
+func newDayClock(start time.Time) *dayClock {
+	y, m, d := start.Date()
+	return &dayClock{start: start, day: time.Date(y, m, d, 0, 0, 0, 0, start.Location())}
+}
+
+func (c *dayClock) at(s string) (time.Time, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return c.start.Add(time.Duration(v * float64(time.Second))), nil
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '.' })
+	if len(parts) < 3 || len(parts) > 4 {
+		return time.Time{}, fmt.Errorf("time %q is neither seconds nor a time of day", s)
+	}
+	var v [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("time %q is neither seconds nor a time of day", s)
+		}
+		v[i] = n
+	}
+	if len(parts) == 4 {
+		// The fraction is in milliseconds, however many digits it has.
+		v[3] = int(float64(v[3]) * math.Pow10(3-len(parts[3])))
+	}
+	tod := time.Duration(v[0])*time.Hour + time.Duration(v[1])*time.Minute + time.Duration(v[2])*time.Second + time.Duration(v[3])*time.Millisecond
+	if tod < c.last-12*time.Hour {
+		c.day = c.day.AddDate(0, 0, 1)
+	}
+	c.last = tod
+	return c.day.Add(tod).UTC(), nil
+}
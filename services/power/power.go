@@ -0,0 +1,162 @@
+// Package power watches the supply voltage of the logger's computer and
+// closes the sessions cleanly before a failing supply cuts them short.
+package power
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/events"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/ingest"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	// restoreMarginV is how far above warn_v the supply must come back for
+	// power_restored, so a voltage hovering at the threshold does not
+	// flood events.csv.
+	restoreMarginV = 0.2
+	// retryDelay is the wait before reopening a monitor that failed.
+	retryDelay = 5 * time.Second
+	// simVolts is the supply read by device "sim".
+	simVolts = 12.6
+)
+
+// Monitor reads the supply voltage as configured by utils.PowerConfig and
+// publishes its power events to the bus of every pipeline.
+type Monitor struct {
+	cfg   utils.PowerConfig
+	buses []*events.Bus
+	log   utils.Logger
+
+	low     bool      // below warn_v
+	since   time.Time // of the first reading below low_v, zero above it
+	tripped atomic.Bool
+}
+
+func NewMonitor(cfg utils.PowerConfig, buses []*events.Bus, log utils.Logger) *Monitor {
+	return &Monitor{cfg: cfg, buses: buses, log: log}
+}
+
+// Run reads the supply until ctx is cancelled. Once it has stayed below
+// low_v for hold_s, Run calls closeSessions, once. A monitor that fails or
+// stops answering is reopened after a few seconds.
+func (m *Monitor) Run(ctx context.Context, closeSessions func()) {
+	m.log.Infof("power: watching the supply on %s, closing below %.2f V", m.cfg.Device, m.cfg.LowV)
+	for {
+		err := m.read(ctx, func(v float64) { m.observe(v, closeSessions) })
+		if ctx.Err() != nil {
+			return
+		}
+		m.log.Warnf("power: %v; retrying in %v", err, retryDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// Tripped reports whether the monitor closed the sessions.
+func (m *Monitor) Tripped() bool { return m.tripped.Load() }
+
+// Shutdown runs the shutdown command of the config.
+func (m *Monitor) Shutdown() error {
+	m.log.Warnf("power: shutting down: %s", strings.Join(m.cfg.ShutdownCommand, " "))
+	out, err := exec.Command(m.cfg.ShutdownCommand[0], m.cfg.ShutdownCommand[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("shutdown: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// read hands every reading of the device to observe until ctx is cancelled
+// or the device fails.
+func (m *Monitor) read(ctx context.Context, observe func(float64)) error {
+	if m.cfg.Device == utils.SimDevice {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				observe(simVolts)
+			}
+		}
+	}
+	port, err := ingest.OpenSerial(m.cfg.Device, m.cfg.Baud)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		port.Close()
+	}()
+	sc := bufio.NewScanner(port)
+	for sc.Scan() {
+		v, err := parseVolts(sc.Text())
+		if err != nil {
+			m.log.Debugf("power: %v", err)
+			continue
+		}
+		observe(v)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", m.cfg.Device, err)
+	}
+	return fmt.Errorf("%s closed", m.cfg.Device)
+}
+
+func (m *Monitor) observe(v float64, closeSessions func()) {
+	switch {
+	case !m.low && v < m.cfg.WarnV:
+		m.low = true
+		m.publish(events.PowerLow, events.Warn, "supply at %.2f V, below %.2f V", v, m.cfg.WarnV)
+	case m.low && v >= m.cfg.WarnV+restoreMarginV:
+		m.low = false
+		m.publish(events.PowerRestored, events.Info, "supply back at %.2f V", v)
+	}
+	if v >= m.cfg.LowV {
+		m.since = time.Time{}
+		return
+	}
+	now := time.Now()
+	if m.since.IsZero() {
+		m.since = now
+	}
+	hold := time.Duration(m.cfg.HoldS * float64(time.Second))
+	if now.Sub(m.since) >= hold && !m.tripped.Swap(true) {
+		m.publish(events.PowerShutdown, events.Error, "supply at %.2f V, below %.2f V for %v: closing the session", v, m.cfg.LowV, hold)
+		closeSessions()
+	}
+}
+
+func (m *Monitor) publish(kind, level, format string, args ...any) {
+	for _, b := range m.buses {
+		b.Publishf(kind, level, "power", format, args...)
+	}
+}
+
+// parseVolts parses a reading such as "12.31", "V=12.31" or "12.31V".
+func parseVolts(line string) (float64, error) {
+	s := strings.TrimSpace(line)
+	if k, v, ok := strings.Cut(s, "="); ok && strings.EqualFold(strings.TrimSpace(k), "V") {
+		s = strings.TrimSpace(v)
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "V"), "v")
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad reading %q", line)
+	}
+	return v, nil
+}
@@ -0,0 +1,358 @@
+// Package preflight checks before a session starts that the devices of the
+// enabled sensors are there and that the storage can keep up with them, so
+// a misconfigured rig fails at the start of a drive instead of halfway.
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const dialTimeout = 3 * time.Second
+
+// Approximate size in bytes of one record, from simulated sessions; radar
+// scans are taken to hold eight targets. -dry-run measures the real sizes.
+const (
+	cameraRow   = 100
+	gpsRow      = 125
+	imuRow      = 95
+	imuRecord   = 60
+	lidarRow    = 45
+	radarRow    = 400
+	radarRecord = 250
+	envRow      = 60
+	fusedRow    = 200
+	// A saved lidar packet is at most an Ethernet frame.
+	cloudBytes = 1500
+)
+
+// frameBitsPerPixel is the size of a saved frame by frame_format: about
+// 1 bit per pixel for JPEG, several times that for the lossless formats
+// and exactly 12 for I420.
+var frameBitsPerPixel = map[string]float64{"jpeg": 1, "webp": 8, "png": 12, "raw": 12}
+
+// cloudRatio is the size of a saved cloud relative to the raw points by
+// cloud_compression. Real sweeps compress less than simulated ones.
+var cloudRatio = map[string]float64{"none": 1, "deflate": 0.7, "zstd": 0.65, "quantized": 0.35}
+
+// Run runs the pre-flight checks for a session of duration, falling back
+// to preflight.duration_min when it is zero. Disk checks are skipped when
+// disk is false, as for a dry run, and missing devices only logged when
+// missing_devices.wait is set. The error lists every failed check.
+func Run(sensors *utils.SensorsConfig, storage *utils.StorageConfig, duration time.Duration, disk bool, log utils.Logger) error {
+	errs := checkDevices(sensors)
+	if sensors.MissingDevices.Wait {
+		// The readers wait for these devices, see utils.MissingDevicesConfig.
+		for i, err := range errs {
+			if err != nil {
+				log.Warnf("preflight: %v; recording without it until it is found", err)
+				errs[i] = nil
+			}
+		}
+	}
+	if disk {
+		cfg := storage.Preflight
+		rate := EstimateRate(sensors, storage) * cfg.Margin
+		if duration == 0 {
+			duration = time.Duration(cfg.DurationMin * float64(time.Minute))
+		}
+		log.Infof("preflight: estimated data rate %s/s", utils.FormatBytes(int64(rate/cfg.Margin)))
+		if err := os.MkdirAll(storage.BaseDir, 0o755); err != nil {
+			errs = append(errs, fmt.Errorf("base_dir: %w", err))
+		} else {
+			if duration > 0 {
+				errs = append(errs, checkFreeSpace(storage.BaseDir, int64(rate*duration.Seconds()), duration))
+			}
+			if cfg.WriteTestMB > 0 {
+				errs = append(errs, checkWriteSpeed(storage.BaseDir, cfg.WriteTestMB, rate, log))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EstimateRate returns the bytes per second a session of the enabled
+// sensors writes at their configured rates.
+func EstimateRate(sensors *utils.SensorsConfig, storage *utils.StorageConfig) float64 {
+	rate := float64(sensors.Fusion.RateHz) * fusedRow
+	if c := sensors.Camera; c.Enabled {
+		rate += kept(c.FPS, c.Decimate) * (cameraRow + float64(FrameSize(sensors, storage)))
+	}
+	if sensors.GPS.Enabled {
+		rate += kept(sensors.GPS.RateHz, sensors.GPS.Decimate) * gpsRow
+	}
+	if sensors.IMU.Enabled {
+		row := float64(imuRow)
+		if storage.Binary("imu") {
+			row = imuRecord
+		}
+		rate += kept(sensors.IMU.RateHz, sensors.IMU.Decimate) * row
+	}
+	if sensors.Lidar.Enabled {
+		row := float64(lidarRow)
+		if storage.SaveClouds {
+			row += cloudBytes * cloudRatio[storage.CloudCompression]
+		}
+		if storage.Transform.Lidar {
+			row += cloudBytes
+		}
+		rate += kept(sensors.Lidar.RateHz, sensors.Lidar.Decimate) * row
+	}
+	if sensors.Radar.Enabled {
+		row := float64(radarRow)
+		if storage.Binary("radar") {
+			row = radarRecord
+		}
+		rate += kept(sensors.Radar.RateHz, sensors.Radar.Decimate) * row
+	}
+	if sensors.Env.Enabled {
+		rate += kept(sensors.Env.RateHz, sensors.Env.Decimate) * envRow
+	}
+	return rate
+}
+
+// FrameSize returns the estimated bytes of a saved frame, both images of a
+// stereo pair; 0 when frames are not saved.
+func FrameSize(sensors *utils.SensorsConfig, storage *utils.StorageConfig) int {
+	c := sensors.Camera
+	if !c.Enabled || !storage.SaveFrames {
+		return 0
+	}
+	w, h := c.Width, c.Height
+	if storage.FrameProcessing.Enabled {
+		w, h = processedSize(storage.FrameProcessing, w, h)
+	}
+	frame := float64(w*h) * frameBitsPerPixel[storage.FrameFormat] / 8
+	if c.Stereo.Enabled {
+		frame *= 2
+	}
+	return int(frame)
+}
+
+// processedSize returns the size of a w×h frame once cropped and scaled.
+func processedSize(fp utils.FrameProcessingConfig, w, h int) (int, int) {
+	r := fp.ROI.Rect().Intersect(image.Rect(0, 0, w, h))
+	w, h = r.Dx(), r.Dy()
+	if fp.MaxWidth > 0 && w > fp.MaxWidth {
+		w, h = fp.MaxWidth, h*fp.MaxWidth/w
+	}
+	return w, h
+}
+
+// kept returns the samples per second of a sensor sampling at rateHz and
+// decimated by every.
+func kept(rateHz, every int) float64 {
+	return float64(rateHz) / float64(max(every, 1))
+}
+
+func checkFreeSpace(dir string, need int64, duration time.Duration) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return fmt.Errorf("free space of %s: %w", dir, err)
+	}
+	free := int64(st.Bavail) * st.Bsize
+	if free < need {
+		return fmt.Errorf("%s has %s free but a %v session needs about %s: free up space, point base_dir at a larger disk or record for less",
+			dir, utils.FormatBytes(free), duration, utils.FormatBytes(need))
+	}
+	return nil
+}
+
+// checkWriteSpeed runs WriteSpeed, failing when dir wrote slower than rate
+// bytes per second.
+func checkWriteSpeed(dir string, mb int, rate float64, log utils.Logger) error {
+	speed, err := WriteSpeed(dir, mb)
+	if err != nil {
+		return err
+	}
+	log.Infof("preflight: %s writes %s/s", dir, utils.FormatBytes(int64(speed)))
+	if speed < rate {
+		return fmt.Errorf("%s writes %s/s, below the %s/s needed: use a faster disk, lower camera fps or resolution, or stop saving frames or clouds",
+			dir, utils.FormatBytes(int64(speed)), utils.FormatBytes(int64(rate)))
+	}
+	return nil
+}
+
+// WriteSpeed writes mb MiB to a scratch file in dir and syncs it, and
+// returns the bytes per second that took.
+func WriteSpeed(dir string, mb int) (float64, error) {
+	f, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		return 0, fmt.Errorf("write test in %s: %w", dir, err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	chunk := make([]byte, 1<<20)
+	start := time.Now()
+	for range mb {
+		if _, err := f.Write(chunk); err != nil {
+			return 0, fmt.Errorf("write test in %s: %w", dir, err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("write test in %s: %w", dir, err)
+	}
+	return float64(mb<<20) / time.Since(start).Seconds(), nil
+}
+
+// deviceSensors are the sensors checkDevices checks, in order.
+var deviceSensors = []string{"camera", "gps", "imu", "env", "trigger", "ins", "lidar", "radar"}
+
+// checkDevices checks that the device of every enabled sensor is present
+// and usable; sim and remote sensors pass.
+func checkDevices(sensors *utils.SensorsConfig) []error {
+	var errs []error
+	for _, name := range deviceSensors {
+		errs = append(errs, CheckDevice(sensors, name))
+	}
+	return errs
+}
+
+// CheckDevice checks that the device of the named sensor is present and
+// usable. Disabled, sim and remote sensors pass, as do those read through
+// the INS and unknown names.
+func CheckDevice(sensors *utils.SensorsConfig, name string) error {
+	switch name {
+	case "camera":
+		c := sensors.Camera
+		if !c.Enabled {
+			return nil
+		}
+		var errs []error
+		if local(c.Device) {
+			errs = append(errs, checkURL("camera", c.Device))
+		}
+		if c.Stereo.Enabled && local(c.Stereo.RightDevice) {
+			errs = append(errs, checkURL("camera right", c.Stereo.RightDevice))
+		}
+		return errors.Join(errs...)
+	case "gps":
+		if c := sensors.GPS; c.Enabled && local(c.Device) {
+			return checkDeviceFile("gps", c.Device)
+		}
+	case "imu":
+		if c := sensors.IMU; c.Enabled && local(c.Device) {
+			return checkDeviceFile("imu", c.Device)
+		}
+	case "env":
+		if c := sensors.Env; c.Enabled && local(c.Device) {
+			return checkDeviceFile("env", c.Device)
+		}
+	case "trigger":
+		c := sensors.Trigger
+		if !c.Enabled || !local(c.Device) {
+			return nil
+		}
+		if line, ok := strings.CutPrefix(c.Device, utils.GPIOPrefix); ok {
+			if _, err := os.Stat("/sys/class/gpio/export"); err != nil {
+				return fmt.Errorf("trigger: no sysfs gpio for line %s: %w", line, err)
+			}
+			return nil
+		}
+		return checkDeviceFile("trigger", c.Device)
+	case "ins":
+		if c := sensors.INS; c.Enabled && local(c.Device) {
+			return checkDeviceFile("ins", c.Device)
+		}
+	case "lidar":
+		c := sensors.Lidar
+		if !c.Enabled || !local(c.Address) {
+			return nil
+		}
+		if path, ok := strings.CutPrefix(c.Address, utils.PcapPrefix); ok {
+			f, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("lidar: cannot replay %w", err)
+			}
+			f.Close()
+			return nil
+		}
+		conn, err := net.ListenPacket("udp", c.Address)
+		if err != nil {
+			return fmt.Errorf("lidar: cannot listen on %s: %w; is another logger running?", c.Address, err)
+		}
+		conn.Close()
+	case "radar":
+		c := sensors.Radar
+		if !c.Enabled || !local(c.Address) {
+			return nil
+		}
+		if c.Protocol == utils.RadarARS408 {
+			return checkCAN("radar", c.Address)
+		}
+		return dial("radar", c.Address)
+	}
+	return nil
+}
+
+func local(device string) bool {
+	return device != utils.SimDevice && device != utils.RemoteDevice && device != utils.INSDevice
+}
+
+func checkDeviceFile(sensor, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("%s: %s not found: check the cable and the device in sensors.yaml (candidates: %s)", sensor, path, candidates(path))
+	}
+	const rw = 4 | 2 // R_OK | W_OK
+	if err := syscall.Access(path, rw); err != nil {
+		return fmt.Errorf("%s: no access to %s: %w; add the user to the device's group (often dialout or i2c)", sensor, path, err)
+	}
+	return nil
+}
+
+// candidates lists the devices next to path with the same name prefix,
+// e.g. the other /dev/ttyUSB* ports.
+func candidates(path string) string {
+	prefix := strings.TrimRight(path, "0123456789")
+	matches, _ := filepath.Glob(prefix + "*")
+	if len(matches) == 0 {
+		return "none"
+	}
+	return strings.Join(matches, ", ")
+}
+
+func checkURL(sensor, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%s: %q is not a stream URL", sensor, raw)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return dial(sensor, host)
+}
+
+func checkCAN(sensor, name string) error {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("%s: no CAN interface %s: check the adapter and its driver", sensor, name)
+	}
+	if ifi.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("%s: %s is down: bring it up, e.g. ip link set %s up type can bitrate 500000", sensor, name, name)
+	}
+	return nil
+}
+
+func dial(sensor, addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("%s: cannot reach %s: %w; check that it is powered and on the network", sensor, addr, err)
+	}
+	conn.Close()
+	return nil
+}
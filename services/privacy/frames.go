@@ -0,0 +1,125 @@
+package privacy
+
+import (
+	"archive/tar"
+	"bufio"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// frameEditor replaces and removes the saved frames of a session where
+// the session keeps them. The changes are in place once commit returns.
+type frameEditor interface {
+	views.FrameReader
+	replace(name string, data []byte) error
+	remove(name string) error
+	commit() error
+}
+
+// openFrameEditor opens the frames of the session in dir for editing.
+// Frames put to S3 cannot be edited: that is a views.ErrRemoteFrames.
+func openFrameEditor(dir string) (frameEditor, error) {
+	r, err := views.OpenFrames(dir)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := r.(*views.TarFrameReader); ok {
+		return &tarFrames{TarFrameReader: t, path: filepath.Join(dir, views.FramesTar), edits: map[string][]byte{}}, nil
+	}
+	return dirFrames{r.(views.DirFrameReader)}, nil
+}
+
+// dirFrames edits the frames saved as files, each at once.
+type dirFrames struct{ views.DirFrameReader }
+
+func (d dirFrames) file(name string) string {
+	return filepath.Join(string(d.DirFrameReader), filepath.FromSlash(name))
+}
+
+func (d dirFrames) replace(name string, data []byte) error { return replaceFile(d.file(name), data) }
+
+func (d dirFrames) remove(name string) error { return os.Remove(d.file(name)) }
+
+func (dirFrames) commit() error { return nil }
+
+// tarFrames edits the members of views.FramesTar. The edits are kept
+// until commit writes the archive again through a temporary file.
+type tarFrames struct {
+	*views.TarFrameReader
+	path string
+	// edits are the new contents of the edited members, nil for those
+	// removed.
+	edits map[string][]byte
+}
+
+func (t *tarFrames) replace(name string, data []byte) error {
+	t.edits[name] = data
+	return nil
+}
+
+func (t *tarFrames) remove(name string) error {
+	if _, err := t.ReadFrame(name); err != nil {
+		return err
+	}
+	t.edits[name] = nil
+	return nil
+}
+
+func (t *tarFrames) commit() error {
+	if len(t.edits) == 0 {
+		return nil
+	}
+	tmp := t.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(f, 64*1024)
+	tw := tar.NewWriter(bw)
+	err = func() error {
+		for _, name := range t.Names() {
+			data, edited := t.edits[name]
+			if edited && data == nil {
+				continue
+			}
+			if !edited {
+				var err error
+				if data, err = t.ReadFrame(name); err != nil {
+					return err
+				}
+			}
+			hdr := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     0o644,
+				Size:     int64(len(data)),
+				ModTime:  time.Now().Truncate(time.Second),
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(data); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, t.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	t.edits = map[string][]byte{}
+	return nil
+}
@@ -0,0 +1,118 @@
+package privacy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Geofence is a polygon in WGS84 degrees: an outer ring and its holes,
+// each a closed list of [lon, lat] points as in GeoJSON.
+type Geofence struct {
+	Name  string
+	Rings [][][2]float64
+}
+
+// Contains reports whether lat, lon lies inside the fence, by the even-odd
+// rule over every ring, so that holes are outside. Edges are straight in
+// degrees, which is close enough at the size of a depot or a street.
+func (g Geofence) Contains(lat, lon float64) bool {
+	in := false
+	for _, ring := range g.Rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+				in = !in
+			}
+		}
+	}
+	return in
+}
+
+// geoJSON is the part of a GeoJSON object LoadGeofences reads.
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Features    []geoJSON       `json:"features"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Geometries  []geoJSON       `json:"geometries"`
+	Properties  map[string]any  `json:"properties"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// LoadGeofences reads the polygons of the GeoJSON file at path: a
+// FeatureCollection, a Feature or a geometry, with Polygon and
+// MultiPolygon geometries. A feature's "name" property names its fences;
+// the others are numbered in file order.
+func LoadGeofences(path string) ([]Geofence, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc geoJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	var fences []Geofence
+	if err := collect(doc, "", &fences); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(fences) == 0 {
+		return nil, fmt.Errorf("%s: no polygons", path)
+	}
+	for i := range fences {
+		if fences[i].Name == "" {
+			fences[i].Name = fmt.Sprintf("#%d", i+1)
+		}
+	}
+	return fences, nil
+}
+
+func collect(g geoJSON, name string, fences *[]Geofence) error {
+	switch g.Type {
+	case "FeatureCollection":
+		for _, f := range g.Features {
+			if err := collect(f, "", fences); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if n, ok := g.Properties["name"].(string); ok {
+			name = n
+		}
+		if g.Geometry != nil {
+			return collect(*g.Geometry, name, fences)
+		}
+	case "GeometryCollection":
+		for _, sub := range g.Geometries {
+			if err := collect(sub, name, fences); err != nil {
+				return err
+			}
+		}
+	case "Polygon":
+		var rings [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return fmt.Errorf("polygon %s: %w", name, err)
+		}
+		return addPolygon(fences, name, rings)
+	case "MultiPolygon":
+		var polys [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polys); err != nil {
+			return fmt.Errorf("multipolygon %s: %w", name, err)
+		}
+		for _, rings := range polys {
+			if err := addPolygon(fences, name, rings); err != nil {
+				return err
+			}
+		}
+	}
+	// Points and lines enclose nothing.
+	return nil
+}
+
+func addPolygon(fences *[]Geofence, name string, rings [][][2]float64) error {
+	if len(rings) == 0 || len(rings[0]) < 4 {
+		return fmt.Errorf("polygon %s: a ring needs at least 4 positions", name)
+	}
+	*fences = append(*fences, Geofence{Name: name, Rings: rings})
+	return nil
+}
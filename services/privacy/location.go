@@ -0,0 +1,273 @@
+package privacy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// LocationKeyFile holds the key the location columns of a session are
+// encrypted with, itself encrypted with the group key.
+const LocationKeyFile = "location.key"
+
+// sealedPrefix starts every encrypted value.
+const sealedPrefix = "enc1:"
+
+// keyAlgorithm is the cipher of the values and of the session key.
+const keyAlgorithm = "AES-256-GCM"
+
+// LocationColumns are the columns of each file LocationCipher encrypts.
+var LocationColumns = map[string][2]string{
+	views.GPSCSV:   {"lat", "lon"},
+	views.FusedCSV: {"gps_lat", "gps_lon"},
+}
+
+// LocationCipher encrypts the latitude and longitude values of a session.
+// Each value is sealed on its own with a random nonce, bound to whether
+// it is a latitude or a longitude so the two cannot be swapped.
+type LocationCipher struct {
+	aead cipher.AEAD
+	// keyFile is the content of LocationKeyFile, empty for a cipher
+	// made with NewLocationCipher.
+	keyFile []byte
+}
+
+// NewLocationCipher returns a cipher for the 32-byte session key.
+func NewLocationCipher(key []byte) (*LocationCipher, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &LocationCipher{aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, not 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal returns v encrypted as the value of column, one of
+// LocationColumns. Empty values stay empty.
+func (c *LocationCipher) Seal(column, v string) string {
+	if v == "" {
+		return ""
+	}
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(seal(c.aead, []byte(v), field(column)))
+}
+
+// Open returns the value of column Seal encrypted as v. Values not
+// encrypted are returned as they are.
+func (c *LocationCipher) Open(column, v string) (string, error) {
+	if !Sealed(v) {
+		return v, nil
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(v, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(c.aead, data, field(column))
+	return string(plain), err
+}
+
+// field is what a value of column is bound to: lat or lon, the same in
+// gps.csv and fused.csv.
+func field(column string) []byte {
+	return []byte(strings.TrimPrefix(column, "gps_"))
+}
+
+// Sealed reports whether v was encrypted by a LocationCipher.
+func Sealed(v string) bool {
+	return strings.HasPrefix(v, sealedPrefix)
+}
+
+func seal(aead cipher.AEAD, plain, ad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plain, ad)
+}
+
+func open(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("value too short")
+	}
+	n := aead.NonceSize()
+	plain, err := aead.Open(nil, data[:n], data[n:], ad)
+	if err != nil {
+		return nil, errors.New("cannot decrypt, wrong key or damaged value")
+	}
+	return plain, nil
+}
+
+// LoadGroupKey reads the group key from path: 32 bytes, hex-encoded, as
+// written by "openssl rand -hex 32".
+func LoadGroupKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s: want 32 bytes in hex", path)
+	}
+	return key, nil
+}
+
+// locationKey is the content of LocationKeyFile. GroupKey identifies the
+// group key by the start of its SHA-256, so that the wrong key can be told
+// apart from a damaged file.
+type locationKey struct {
+	Algorithm string `json:"algorithm"`
+	GroupKey  string `json:"group_key"`
+	Wrapped   string `json:"wrapped_key"`
+}
+
+// keyAD binds a wrapped session key to its use.
+var keyAD = []byte("sensor-logger location key")
+
+func groupKeyID(group []byte) string {
+	sum := sha256.Sum256(group)
+	return hex.EncodeToString(sum[:8])
+}
+
+// CreateLocationKey draws the key of the session in dir, saves it there
+// encrypted with group and returns its cipher.
+func CreateLocationKey(dir string, group []byte) (*LocationCipher, error) {
+	wrap, err := newAEAD(group)
+	if err != nil {
+		return nil, fmt.Errorf("group key: %w", err)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	c, err := NewLocationCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := json.MarshalIndent(locationKey{
+		Algorithm: keyAlgorithm,
+		GroupKey:  groupKeyID(group),
+		Wrapped:   base64.StdEncoding.EncodeToString(seal(wrap, key, keyAD)),
+	}, "", "  ")
+	c.keyFile = append(data, '\n')
+	return c, c.WriteKey(dir)
+}
+
+// WriteKey saves the encrypted session key in dir, for a session that
+// moves there.
+func (c *LocationCipher) WriteKey(dir string) error {
+	return os.WriteFile(filepath.Join(dir, LocationKeyFile), c.keyFile, 0o644)
+}
+
+// OpenLocationKey returns the cipher of the session in dir, whose key is
+// decrypted with group.
+func OpenLocationKey(dir string, group []byte) (*LocationCipher, error) {
+	path := filepath.Join(dir, LocationKeyFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var k locationKey
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if k.Algorithm != keyAlgorithm {
+		return nil, fmt.Errorf("%s: unknown algorithm %q", path, k.Algorithm)
+	}
+	if k.GroupKey != groupKeyID(group) {
+		return nil, fmt.Errorf("%s: encrypted with another group key (%s)", path, k.GroupKey)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(k.Wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	wrap, err := newAEAD(group)
+	if err != nil {
+		return nil, fmt.Errorf("group key: %w", err)
+	}
+	key, err := open(wrap, wrapped, keyAD)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c, err := NewLocationCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	c.keyFile = data
+	return c, nil
+}
+
+// DecryptLocation writes the location columns of gps.csv and fused.csv of
+// the closed session in dir back in the clear, with the session key
+// decrypted by group, and returns how many values it decrypted. The
+// manifest records that the session is no longer encrypted.
+func DecryptLocation(dir string, group []byte) (int, error) {
+	c, err := OpenLocationKey(dir, group)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("no %s, the locations of the session are not encrypted", LocationKeyFile)
+	}
+	if err != nil {
+		return 0, err
+	}
+	m, err := views.ReadManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("no %s, the session did not close cleanly", views.ManifestFile)
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, file := range []string{views.GPSCSV, views.FusedCSV} {
+		path := filepath.Join(dir, file)
+		t, err := views.ReadTable(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		opened := 0
+		for _, name := range LocationColumns[file] {
+			col := t.Col(name)
+			if col < 0 {
+				continue
+			}
+			for r, row := range t.Rows {
+				if col >= len(row) || !Sealed(row[col]) {
+					continue
+				}
+				v, err := c.Open(name, row[col])
+				if err != nil {
+					return n, fmt.Errorf("%s row %d %s: %w", file, r+1, name, err)
+				}
+				row[col] = v
+				opened++
+			}
+		}
+		if opened == 0 {
+			continue
+		}
+		if err := writeTable(path, t); err != nil {
+			return n, err
+		}
+		n += opened
+	}
+	m.LocationEncrypted = false
+	return n, views.WriteManifest(dir, m)
+}
@@ -0,0 +1,364 @@
+package privacy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/export"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/report"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// fusedGPSColumns are the columns of fused.csv a redaction clears.
+var fusedGPSColumns = []string{
+	"gps_lat", "gps_lon", "gps_alt", "gps_speed_mps", "gps_heading_deg", "gps_age_ms", "gps_fill",
+	"gps_utm_easting", "gps_utm_northing", "gps_utm_zone", "gps_enu_x", "gps_enu_y",
+}
+
+// frameColumns are the columns of camera.csv holding frame paths.
+var frameColumns = []string{"path", "right_path"}
+
+// Redact removes from the closed session in dir what was recorded inside
+// fences: the rows of gps.csv, the GPS columns of fused.csv and the saved
+// frames, which are pixelated instead when blur is set (JPEG and PNG
+// frames; frames in other formats are removed), in views.FramesTar when
+// the session saved them there. Whether the vehicle was inside at a given
+// time is taken from the last GPS fix before it, or the first fix for the
+// time before that. The GPS tracks, the preview and the report of the
+// session are written again from what is left, and the redaction is
+// recorded in the manifest. A session whose frames were put to S3 is not
+// redacted.
+func Redact(dir string, fences []Geofence, blur bool) (views.Redaction, error) {
+	r := views.Redaction{Time: utils.Now(), Blur: blur}
+	for _, f := range fences {
+		r.Fences = append(r.Fences, f.Name)
+	}
+	m, err := views.ReadManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r, fmt.Errorf("no %s, the session did not close cleanly", views.ManifestFile)
+	}
+	if err != nil {
+		return r, err
+	}
+	// The frames are opened first, so that a session whose frames cannot
+	// be redacted is left as it is.
+	frames, err := openFrameEditor(dir)
+	if err != nil {
+		return r, err
+	}
+	defer frames.Close()
+	gps, err := views.ReadTable(filepath.Join(dir, views.GPSCSV))
+	if err != nil {
+		return r, err
+	}
+	tl := timeline(gps, fences)
+	if len(tl) == 0 {
+		return r, fmt.Errorf("%s has no fixes to place the session by", views.GPSCSV)
+	}
+
+	keep := gps.Rows[:0:0]
+	for i, row := range gps.Rows {
+		if ts, ok := gps.Time(i); ok && tl.inside(ts) {
+			r.GPSRows++
+			continue
+		}
+		keep = append(keep, row)
+	}
+	gps.Rows = keep
+	if err := writeTable(filepath.Join(dir, views.GPSCSV), gps); err != nil {
+		return r, err
+	}
+	m.Rows[views.GPSCSV] = int64(len(gps.Rows))
+
+	if r.FusedRows, err = redactFused(dir, tl); err != nil {
+		return r, err
+	}
+	if r.Frames, err = redactFrames(dir, frames, tl, blur); err != nil {
+		return r, err
+	}
+
+	for format, file := range export.TrackFiles {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			continue
+		}
+		if _, err := export.WriteTracks(dir, dir, []string{format}, export.Filter{}); err != nil {
+			return r, err
+		}
+	}
+	// The projection is anchored at the first fix; an ENU frame gives
+	// that position away.
+	if p := m.Projection; p != nil && p.Origin != nil {
+		for _, f := range fences {
+			if f.Contains(p.Origin.Lat, p.Origin.Lon) {
+				p.Origin = nil
+				break
+			}
+		}
+	}
+	m.Redactions = append(m.Redactions, r)
+	if err := views.WriteManifest(dir, m); err != nil {
+		return r, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, export.PreviewDir)); err == nil {
+		if _, err := export.WritePreview(dir, filepath.Join(dir, export.PreviewDir), utils.DefaultPreview, export.Filter{}); err != nil {
+			return r, fmt.Errorf("preview: %w", err)
+		}
+	}
+	// The report may hold copies of the frames.
+	for format, file := range report.Files {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			continue
+		}
+		if _, err := report.Write(dir, format); err != nil {
+			return r, fmt.Errorf("report: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// fixState is whether the vehicle was inside a fence from a fix on.
+type fixState struct {
+	at     time.Time
+	inside bool
+}
+
+// fixTimeline is the fixes of a session in time order.
+type fixTimeline []fixState
+
+func timeline(gps *views.Table, fences []Geofence) fixTimeline {
+	var tl fixTimeline
+	for i := range gps.Rows {
+		ts, ok := gps.Time(i)
+		lat, okLat := gps.Float(i, "lat")
+		lon, okLon := gps.Float(i, "lon")
+		if q, _ := strconv.Atoi(gps.String(i, "fix_quality")); !ok || !okLat || !okLon || q == 0 {
+			continue
+		}
+		in := false
+		for _, f := range fences {
+			if f.Contains(lat, lon) {
+				in = true
+				break
+			}
+		}
+		tl = append(tl, fixState{ts, in})
+	}
+	sort.SliceStable(tl, func(i, j int) bool { return tl[i].at.Before(tl[j].at) })
+	return tl
+}
+
+// inside reports whether the vehicle was inside a fence at ts.
+func (tl fixTimeline) inside(ts time.Time) bool {
+	i := sort.Search(len(tl), func(i int) bool { return tl[i].at.After(ts) })
+	return tl[max(i-1, 0)].inside
+}
+
+// redactFused clears the GPS columns and the GPS bit of present_mask of
+// the rows of fused.csv inside, and returns how many it changed.
+func redactFused(dir string, tl fixTimeline) (int, error) {
+	path := filepath.Join(dir, views.FusedCSV)
+	t, err := views.ReadTable(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	mask := t.Col("present_mask")
+	n := 0
+	for i, row := range t.Rows {
+		ts, ok := t.Time(i)
+		if !ok || !tl.inside(ts) || t.String(i, "gps_lat") == "" {
+			continue
+		}
+		for _, name := range fusedGPSColumns {
+			if c := t.Col(name); c >= 0 && c < len(row) {
+				row[c] = ""
+			}
+		}
+		if mask >= 0 && mask < len(row) {
+			if v, err := strconv.Atoi(row[mask]); err == nil {
+				row[mask] = strconv.Itoa(v &^ models.PresentGPS)
+			}
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, writeTable(path, t)
+}
+
+// redactFrames pixelates or removes the frames saved inside and returns
+// how many. A removed frame loses its path in camera.csv and its entry in
+// the blob journal.
+func redactFrames(dir string, frames frameEditor, tl fixTimeline, blur bool) (int, error) {
+	path := filepath.Join(dir, views.CameraCSV)
+	t, err := views.ReadTable(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := map[string]bool{}
+	n := 0
+	for i, row := range t.Rows {
+		ts, ok := t.Time(i)
+		if !ok || !tl.inside(ts) {
+			continue
+		}
+		for _, name := range frameColumns {
+			c := t.Col(name)
+			if c < 0 || c >= len(row) || row[c] == "" {
+				continue
+			}
+			if blur {
+				err := pixelateFrame(frames, row[c])
+				if err == nil {
+					n++
+					continue
+				}
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				if !errors.Is(err, errNoBlur) {
+					return n, fmt.Errorf("%s: %w", row[c], err)
+				}
+			}
+			if err := frames.remove(row[c]); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return n, err
+			}
+			removed[row[c]] = true
+			row[c] = ""
+			n++
+		}
+	}
+	if err := frames.commit(); err != nil {
+		return n, err
+	}
+	if len(removed) == 0 {
+		return n, nil
+	}
+	if err := writeTable(path, t); err != nil {
+		return n, err
+	}
+	journal := filepath.Join(dir, views.BlobJournal)
+	j, err := views.ReadTable(journal)
+	if errors.Is(err, fs.ErrNotExist) {
+		return n, nil
+	}
+	if err != nil {
+		return n, err
+	}
+	keep := j.Rows[:0]
+	for i, row := range j.Rows {
+		if !removed[j.String(i, "path")] {
+			keep = append(keep, row)
+		}
+	}
+	j.Rows = keep
+	return n, writeTable(journal, j)
+}
+
+// errNoBlur is returned for frames in a format pixelateFrame cannot
+// rewrite.
+var errNoBlur = errors.New("cannot pixelate this format")
+
+// pixelateFrame replaces the JPEG or PNG frame name with a pixelated
+// copy, coarse enough that faces and number plates cannot be made out.
+// Any EXIF of a JPEG is dropped with it.
+func pixelateFrame(frames frameEditor, name string) error {
+	ext := strings.ToLower(path.Ext(name))
+	if ext != ".jpg" && ext != ".png" {
+		return errNoBlur
+	}
+	data, err := frames.ReadFrame(name)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	out := pixelate(img)
+	var buf bytes.Buffer
+	if ext == ".png" {
+		err = png.Encode(&buf, out)
+	} else {
+		err = jpeg.Encode(&buf, out, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return err
+	}
+	return frames.replace(name, buf.Bytes())
+}
+
+// pixelate averages img over blocks of 1/32 of its width, at least 8
+// pixels.
+func pixelate(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	block := max(b.Dx()/32, 8)
+	out := image.NewRGBA(b)
+	for y0 := b.Min.Y; y0 < b.Max.Y; y0 += block {
+		for x0 := b.Min.X; x0 < b.Max.X; x0 += block {
+			cell := image.Rect(x0, y0, x0+block, y0+block).Intersect(b)
+			var r, g, bl, n uint64
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					cr, cg, cb, _ := img.At(x, y).RGBA()
+					r, g, bl, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), n+1
+				}
+			}
+			c := color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), 0xff}
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					out.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// writeTable replaces the CSV file at path with t.
+func writeTable(path string, t *views.Table) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(t.Header)
+	w.WriteAll(t.Rows)
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return replaceFile(path, buf.Bytes())
+}
+
+// replaceFile writes data to path through a temporary file, so that a
+// failure leaves the old content.
+func replaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Package privacy keeps samples recorded in sensitive places, such as a
+// depot or the drivers' homes, out of a session.
+package privacy
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// ZoneFilter drops the samples of its sensors while the last GPS fix lies
+// within one of its zones, and before the first fix, when the vehicle
+// could be anywhere. Fixes themselves pass unless gps is one of its
+// sensors.
+type ZoneFilter struct {
+	name string
+	cfg  utils.PrivacyZoneConfig
+	log  utils.Logger
+
+	mu     sync.Mutex
+	fixed  bool
+	inside string // the zone the vehicle is in, "" outside
+
+	dropped atomic.Uint64
+}
+
+func NewZoneFilter(name string, cfg utils.PrivacyZoneConfig, log utils.Logger) *ZoneFilter {
+	return &ZoneFilter{name: name, cfg: cfg, log: log}
+}
+
+// Process returns v and whether to keep it.
+func (z *ZoneFilter) Process(v models.SensorSample) (models.SensorSample, bool) {
+	if g, ok := v.(models.GPSData); ok && g.FixQuality > 0 {
+		z.locate(g)
+	}
+	if !slices.Contains(z.cfg.Sensors, v.Sensor()) {
+		return v, true
+	}
+	z.mu.Lock()
+	keep := z.fixed && z.inside == ""
+	z.mu.Unlock()
+	if !keep {
+		z.dropped.Add(1)
+	}
+	return v, keep
+}
+
+// Dropped returns the number of samples dropped.
+func (z *ZoneFilter) Dropped() uint64 {
+	return z.dropped.Load()
+}
+
+func (z *ZoneFilter) locate(g models.GPSData) {
+	inside := ""
+	for i, zone := range z.cfg.Zones {
+		e, n, _ := utils.GeodeticToENU(g.Lat, g.Lon, 0, zone.Lat, zone.Lon, 0)
+		if math.Hypot(e, n) <= zone.RadiusM {
+			inside = zone.Name
+			if inside == "" {
+				inside = fmt.Sprintf("#%d", i+1)
+			}
+			break
+		}
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if !z.fixed {
+		z.fixed = true
+		if inside == "" {
+			z.log.Infof("%s: first fix outside the privacy zones, recording %v", z.name, z.cfg.Sensors)
+		}
+	}
+	if inside != z.inside {
+		if inside != "" {
+			z.log.Infof("%s: entered privacy zone %s, dropping %v", z.name, inside, z.cfg.Sensors)
+		} else {
+			z.log.Infof("%s: left privacy zone %s, recording %v", z.name, z.inside, z.cfg.Sensors)
+		}
+		z.inside = inside
+	}
+}
@@ -0,0 +1,111 @@
+package quality
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+const (
+	// driftWindow is how many intervals the drift is fitted over.
+	driftWindow = 60
+	// clockStep is a change of the offset between two intervals taken for
+	// the device clock being set rather than drifting; the drift is fitted
+	// afresh after it.
+	clockStep = time.Second
+)
+
+// ClockSync estimates the offset of a sensor's clock from the host clock
+// in the manner of NTP: over every interval the sample that arrived with
+// the least delay, that is with the largest device minus host time, gives
+// the offset, and the drift is fitted by least squares over the last
+// intervals. It is safe for concurrent use.
+type ClockSync struct {
+	sensor   string
+	interval time.Duration
+
+	mu      sync.Mutex
+	start   time.Time // of the interval, zero before the first sample
+	best    models.ClockOffset
+	least   time.Duration // smallest Device - Host of the interval
+	history []models.ClockOffset
+}
+
+func NewClockSync(sensor string, interval time.Duration) *ClockSync {
+	return &ClockSync{sensor: sensor, interval: interval}
+}
+
+// Observe records a sample that arrived at host and was stamped device by
+// the sensor. It returns the estimate of the interval before once host is
+// past it.
+func (c *ClockSync) Observe(host, device time.Time) (models.ClockOffset, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out models.ClockOffset
+	done := false
+	if !c.start.IsZero() && host.Sub(c.start) >= c.interval {
+		out, done = c.close(), true
+	}
+	off := device.Sub(host)
+	if c.best.Samples == 0 {
+		c.start = host
+		c.best = models.ClockOffset{Sensor: c.sensor, Host: host, Device: device, Offset: off}
+		c.least = off
+	} else if off > c.best.Offset {
+		c.best.Host, c.best.Device, c.best.Offset = host, device, off
+	}
+	c.least = min(c.least, off)
+	c.best.Samples++
+	return out, done
+}
+
+// Flush returns the estimate of the interval in progress, if it has a
+// sample.
+func (c *ClockSync) Flush() (models.ClockOffset, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.best.Samples == 0 {
+		return models.ClockOffset{}, false
+	}
+	return c.close(), true
+}
+
+// close completes the estimate of the interval and starts the next.
+func (c *ClockSync) close() models.ClockOffset {
+	o := c.best
+	o.Spread = o.Offset - c.least
+	if n := len(c.history); n > 0 {
+		if d := o.Offset - c.history[n-1].Offset; d > clockStep || d < -clockStep {
+			c.history = c.history[:0]
+		}
+	}
+	c.history = append(c.history, o)
+	if len(c.history) > driftWindow {
+		c.history = c.history[1:]
+	}
+	o.DriftPPM, o.DriftKnown = fitDrift(c.history)
+	c.best = models.ClockOffset{}
+	return o
+}
+
+// fitDrift returns the slope of the offsets of h over their host times in
+// microseconds a second.
+func fitDrift(h []models.ClockOffset) (float64, bool) {
+	if len(h) < 2 {
+		return 0, false
+	}
+	t0 := h[0].Host
+	var sx, sy, sxx, sxy float64
+	for _, o := range h {
+		x := o.Host.Sub(t0).Seconds()
+		y := float64(o.Offset) / 1e3 // µs
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	n := float64(len(h))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, false
+	}
+	return (n*sxy - sx*sy) / den, true
+}
@@ -0,0 +1,85 @@
+// Package quality inspects sensor streams for recording defects.
+package quality
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// gapFactor is how many nominal sample periods may pass without a sample
+// before the silence counts as a gap.
+const gapFactor = 2.5
+
+// GapDetector tracks the samples of one sensor and reports gaps: jumps in
+// the sequence number (samples dropped between device and recorder) and
+// silences much longer than the nominal period (device stalls). It is safe
+// for concurrent use.
+type GapDetector struct {
+	sensor string
+	period time.Duration
+	stride uint64
+
+	mu      sync.Mutex
+	last    time.Time
+	lastSeq uint64
+	summary models.GapSummary
+}
+
+// NewGapDetector returns a detector for a sensor sampling at rateHz of
+// which every decimate-th sample is kept, see utils.CameraConfig.
+func NewGapDetector(sensor string, rateHz, decimate int) *GapDetector {
+	stride := uint64(max(decimate, 1))
+	return &GapDetector{sensor: sensor, period: utils.Period(rateHz) * time.Duration(stride), stride: stride}
+}
+
+// ObserveSeq records a sample that carries a sequence number.
+func (d *GapDetector) ObserveSeq(ts time.Time, seq uint64) (models.Gap, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var missing uint64
+	if d.summary.Samples > 0 && seq > d.lastSeq+d.stride {
+		missing = (seq-d.lastSeq)/d.stride - 1
+	}
+	d.lastSeq = seq
+	return d.observe(ts, missing)
+}
+
+// ObserveTime records a sample without a sequence number; only silences
+// are detected.
+func (d *GapDetector) ObserveTime(ts time.Time) (models.Gap, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.observe(ts, 0)
+}
+
+func (d *GapDetector) observe(ts time.Time, missing uint64) (models.Gap, bool) {
+	prev := d.last
+	first := d.summary.Samples == 0
+	d.last = ts
+	d.summary.Samples++
+	if first {
+		return models.Gap{}, false
+	}
+	dt := ts.Sub(prev)
+	if missing == 0 && dt > time.Duration(gapFactor*float64(d.period)) {
+		missing = uint64(math.Round(float64(dt)/float64(d.period))) - 1
+	}
+	if missing == 0 {
+		return models.Gap{}, false
+	}
+	d.summary.Gaps++
+	d.summary.Missing += missing
+	d.summary.MaxGapMs = max(d.summary.MaxGapMs, dt.Milliseconds())
+	return models.Gap{Sensor: d.sensor, Start: prev, End: ts, Missing: missing}, true
+}
+
+// Summary returns the totals so far.
+func (d *GapDetector) Summary() models.GapSummary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.summary
+}
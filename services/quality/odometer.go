@@ -0,0 +1,94 @@
+package quality
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	// movingSpeedMps is the GPS speed from which the vehicle counts as
+	// moving; below it the fixes wander about a standing vehicle and add
+	// no distance.
+	movingSpeedMps = 0.5
+	// odometerGap is the longest span between two fixes the odometer
+	// bridges; longer outages count as neither moving nor stopped.
+	odometerGap = 5 * time.Second
+)
+
+// Odometry is the distance travelled over a session and how fast, from
+// its GPS fixes. MeanSpeedMps is over the moving time.
+type Odometry struct {
+	DistanceM    float64 `json:"distance_m"`
+	MaxSpeedMps  float64 `json:"max_speed_mps"`
+	MeanSpeedMps float64 `json:"mean_speed_mps"`
+	MovingS      float64 `json:"moving_s"`
+	StoppedS     float64 `json:"stopped_s"`
+}
+
+func (o Odometry) String() string {
+	return fmt.Sprintf("%.2f km, max %.1f m/s, mean %.1f m/s, moving %s, stopped %s", o.DistanceM/1000,
+		o.MaxSpeedMps, o.MeanSpeedMps, seconds(o.MovingS), seconds(o.StoppedS))
+}
+
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Second).String()
+}
+
+// Odometer adds up the distance between consecutive fixes with a
+// position, and the time between them as moving or stopped by their
+// speed. It is safe for concurrent use.
+type Odometer struct {
+	mu    sync.Mutex
+	last  *models.GPSData
+	total Odometry
+}
+
+func NewOdometer() *Odometer {
+	return &Odometer{}
+}
+
+// ObserveGPS records a fix; fixes without a position are ignored.
+func (o *Odometer) ObserveGPS(g models.GPSData) {
+	if g.FixQuality == 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.total.MaxSpeedMps = max(o.total.MaxSpeedMps, g.SpeedMps)
+	prev := o.last
+	o.last = &g
+	if prev == nil {
+		return
+	}
+	dt := g.Timestamp.Sub(prev.Timestamp)
+	if dt <= 0 || dt > odometerGap {
+		return
+	}
+	if max(prev.SpeedMps, g.SpeedMps) < movingSpeedMps {
+		o.total.StoppedS += dt.Seconds()
+		return
+	}
+	e, n, _ := utils.GeodeticToENU(g.Lat, g.Lon, 0, prev.Lat, prev.Lon, 0)
+	o.total.DistanceM += math.Hypot(e, n)
+	o.total.MovingS += dt.Seconds()
+}
+
+// Summary returns the totals so far, nil before the first fix with a
+// position.
+func (o *Odometer) Summary() *Odometry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.last == nil {
+		return nil
+	}
+	s := o.total
+	if s.MovingS > 0 {
+		s.MeanSpeedMps = s.DistanceM / s.MovingS
+	}
+	return &s
+}
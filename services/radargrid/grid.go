@@ -0,0 +1,139 @@
+// Package radargrid accumulates radar detections into a 2D occupancy or
+// intensity grid per fusion window, for a quick visual of radar scenes.
+package radargrid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"sync"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Grid is a range_m deep grid in the radar's sensor frame, far rows first
+// and the sensor's left on the left. A cartesian grid has square cells of
+// cell_m; a polar grid has cell_m range bins by azimuth_step_deg columns.
+// Add and Take are safe for concurrent use.
+type Grid struct {
+	cfg        utils.RadarGridConfig
+	rows, cols int
+	halfWidth  float64 // metres either side of the boresight, cartesian only
+
+	mu    sync.Mutex
+	cells []float32
+	n     int
+}
+
+func New(cfg utils.RadarGridConfig) *Grid {
+	g := &Grid{cfg: cfg, rows: int(math.Ceil(cfg.RangeM / cfg.CellM))}
+	if cfg.Projection == utils.GridPolar {
+		g.cols = int(math.Ceil(cfg.FOVDeg / cfg.AzimuthStepDeg))
+	} else {
+		g.halfWidth = cfg.RangeM * math.Sin(math.Min(cfg.FOVDeg, 180)/2*math.Pi/180)
+		g.cols = max(int(math.Ceil(2*g.halfWidth/cfg.CellM)), 1)
+	}
+	g.cells = make([]float32, g.rows*g.cols)
+	return g
+}
+
+// Add accumulates the targets of s: one per detection for occupancy, the
+// linear RCS in m² for intensity. Targets outside the grid are ignored.
+func (g *Grid) Add(s models.RadarScan) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, t := range s.Targets {
+		i, ok := g.cell(t)
+		if !ok {
+			continue
+		}
+		v := float32(1)
+		if g.cfg.Value == utils.GridIntensity {
+			v = float32(math.Pow(10, t.RCS/10))
+		}
+		g.cells[i] += v
+		g.n++
+	}
+}
+
+func (g *Grid) cell(t models.RadarTarget) (int, bool) {
+	if t.RangeM < 0 || t.RangeM >= g.cfg.RangeM || math.Abs(t.AzimuthDeg) > g.cfg.FOVDeg/2 {
+		return 0, false
+	}
+	var row, col int
+	if g.cfg.Projection == utils.GridPolar {
+		row = g.rows - 1 - int(t.RangeM/g.cfg.CellM)
+		col = int((g.cfg.FOVDeg/2 - t.AzimuthDeg) / g.cfg.AzimuthStepDeg)
+	} else {
+		az := t.AzimuthDeg * math.Pi / 180
+		x, y := t.RangeM*math.Cos(az), t.RangeM*math.Sin(az)
+		row = g.rows - 1 - int(x/g.cfg.CellM)
+		col = int((g.halfWidth - y) / g.cfg.CellM)
+	}
+	if row < 0 || row >= g.rows || col < 0 || col >= g.cols {
+		return 0, false
+	}
+	return row*g.cols + col, true
+}
+
+// Take returns the grid accumulated since the last Take, encoded in the
+// configured format, and clears it. It returns nil for a window without
+// detections.
+func (g *Grid) Take() ([]byte, error) {
+	g.mu.Lock()
+	if g.n == 0 {
+		g.mu.Unlock()
+		return nil, nil
+	}
+	cells := g.cells
+	g.cells, g.n = make([]float32, len(cells)), 0
+	g.mu.Unlock()
+
+	if g.cfg.Format == utils.GridNPY {
+		return encodeNPY(cells, g.rows, g.cols), nil
+	}
+	return encodePNG(cells, g.rows, g.cols)
+}
+
+// encodePNG renders cells as 8-bit grey, scaled so the fullest cell is
+// white.
+func encodePNG(cells []float32, rows, cols int) ([]byte, error) {
+	var peak float32
+	for _, v := range cells {
+		peak = max(peak, v)
+	}
+	img := image.NewGray(image.Rect(0, 0, cols, rows))
+	for i, v := range cells {
+		if v > 0 {
+			img.Pix[i] = uint8(math.Round(float64(max(v/peak*255, 1))))
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("radar grid: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeNPY writes cells as a little-endian float32 rows×cols NumPy array
+// (format version 1.0).
+func encodeNPY(cells []float32, rows, cols int) []byte {
+	header := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", rows, cols)
+	// Magic, version and length take 10 bytes; pad the header so the data
+	// starts on a 64-byte boundary.
+	pad := 64 - (10+len(header)+1)%64
+	if pad == 64 {
+		pad = 0
+	}
+	header += string(bytes.Repeat([]byte{' '}, pad)) + "\n"
+	var buf bytes.Buffer
+	buf.WriteString("\x93NUMPY\x01\x00")
+	binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+	binary.Write(&buf, binary.LittleEndian, cells)
+	return buf.Bytes()
+}
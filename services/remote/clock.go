@@ -0,0 +1,58 @@
+package remote
+
+import (
+	"sync"
+	"time"
+)
+
+// clockWindow is the number of recent exchanges the estimator keeps.
+const clockWindow = 16
+
+type clockSample struct {
+	offset time.Duration
+	rtt    time.Duration
+}
+
+// ClockEstimator estimates the offset of an agent's clock relative to the
+// local clock from NTP-style exchanges. The sample with the smallest
+// round-trip time in the recent window is used, as it has the least
+// queuing asymmetry. It is safe for concurrent use.
+type ClockEstimator struct {
+	mu      sync.Mutex
+	samples []clockSample
+	next    int
+}
+
+// Add records one exchange: t1 local send, t2 agent receive, t3 agent
+// send, t4 local receive.
+func (e *ClockEstimator) Add(t1, t2, t3, t4 time.Time) {
+	s := clockSample{
+		offset: (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		rtt:    t4.Sub(t1) - t3.Sub(t2),
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.samples) < clockWindow {
+		e.samples = append(e.samples, s)
+		return
+	}
+	e.samples[e.next] = s
+	e.next = (e.next + 1) % clockWindow
+}
+
+// Offset returns agent clock minus local clock and the round-trip time of
+// the sample it came from. ok is false until the first exchange.
+func (e *ClockEstimator) Offset() (offset, rtt time.Duration, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.samples) == 0 {
+		return 0, 0, false
+	}
+	best := e.samples[0]
+	for _, s := range e.samples[1:] {
+		if s.rtt < best.rtt {
+			best = s
+		}
+	}
+	return best.offset, best.rtt, true
+}
@@ -0,0 +1,144 @@
+// Package remote implements the wire protocol between Sensor-Logger agents
+// running on other computers and the central logger, plus clock-offset
+// estimation between the two.
+package remote
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+// ProtocolVersion is exchanged in Hello; both ends must match. Version 1
+// was gob-encoded.
+const ProtocolVersion = 2
+
+// Kind identifies the payload of a Message.
+type Kind uint8
+
+const (
+	KindHello Kind = iota + 1
+	KindRecord
+	KindPing
+	KindPong
+	KindReject
+)
+
+// Message is the unit sent over a connection. Exactly one payload field
+// matching Kind is set; Reason explains a KindReject before the logger
+// closes the connection.
+type Message struct {
+	Kind   Kind
+	Hello  *Hello
+	Sync   *Sync
+	Record *Record
+	Reason string
+}
+
+// Hello is the first message an agent sends. Token authenticates the
+// agent to a logger that requires it.
+type Hello struct {
+	Version int
+	AgentID string
+	Token   string
+}
+
+// Sync carries the timestamps of one NTP-style exchange, in Unix
+// nanoseconds: T1 logger send, T2 agent receive, T3 agent send.
+type Sync struct {
+	T1, T2, T3 int64
+}
+
+// Record carries one sensor sample; exactly one field is set. Timestamps
+// are in the agent's clock.
+type Record struct {
+	Camera *models.CameraFrame
+	GPS    *models.GPSData
+	IMU    *models.IMUData
+	Lidar  *models.LidarPacket
+	Radar  *models.RadarScan
+	Env    *models.EnvData
+}
+
+// Conn is a stream of length-delimited protobuf messages (see wire.go)
+// over a network connection. Send is safe for concurrent use; Recv must be
+// called from one goroutine.
+type Conn struct {
+	c   net.Conn
+	w   *bufio.Writer
+	r   *bufio.Reader
+	wmu sync.Mutex
+}
+
+func NewConn(c net.Conn) *Conn {
+	return &Conn{c: c, w: bufio.NewWriter(c), r: bufio.NewReader(c)}
+}
+
+func (c *Conn) Send(m *Message) error {
+	msg := m.marshal()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.w.Write(binary.AppendUvarint(nil, uint64(len(msg)))); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(msg); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *Conn) Recv() (*Message, error) {
+	n, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxMessage {
+		return nil, fmt.Errorf("message of %d bytes, at most %d", n, MaxMessage)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	m := &Message{}
+	if err := m.unmarshal(msg); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *Conn) RemoteAddr() net.Addr { return c.c.RemoteAddr() }
+
+// SetReadDeadline sets the deadline of Recv; the zero time clears it.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.c.SetReadDeadline(t) }
+
+// TLS returns the TLS state of the connection, nil for plain TCP.
+func (c *Conn) TLS() *tls.ConnectionState {
+	if tc, ok := c.c.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		return &cs
+	}
+	return nil
+}
+
+func (c *Conn) Close() error { return c.c.Close() }
+
+// CheckHello validates the first message of a connection.
+func CheckHello(m *Message) (*Hello, error) {
+	if m.Kind != KindHello || m.Hello == nil {
+		return nil, fmt.Errorf("expected hello, got message kind %d", m.Kind)
+	}
+	if m.Hello.Version != ProtocolVersion {
+		return nil, fmt.Errorf("agent %q speaks protocol %d, want %d", m.Hello.AgentID, m.Hello.Version, ProtocolVersion)
+	}
+	return m.Hello, nil
+}
@@ -0,0 +1,74 @@
+package remote
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+func TestConnRoundTrip(t *testing.T) {
+	ts := time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	dev := ts.Add(-3 * time.Millisecond)
+	f := func(v float64) *float64 { return &v }
+	trigger := &models.TriggerMatch{ID: 42, Offset: -1500 * time.Microsecond}
+	msgs := []*Message{
+		{Kind: KindHello, Hello: &Hello{Version: ProtocolVersion, AgentID: "roof", Token: "secret"}},
+		{Kind: KindPing, Sync: &Sync{T1: ts.UnixNano()}},
+		{Kind: KindPong, Sync: &Sync{T1: 1, T2: -2, T3: 3}},
+		{Kind: KindReject, Reason: "role read, needs control"},
+		{Kind: KindRecord, Record: &Record{Camera: &models.CameraFrame{
+			Timestamp: ts, FrameID: 7, Width: 640, Height: 480, ExposureUs: f(0), GainDB: f(-1.5),
+			Stats: &models.ImageStats{MeanLum: 120.5, P05Lum: 3, P50Lum: 118, P95Lum: 250, DarkPct: 4.25, BrightPct: 0.1, Sharpness: 310.7},
+			Data:  []byte{0xff, 0xd8, 0xff, 0xd9}, Path: "frames/7.jpg",
+			Right:   &models.StereoFrame{Width: 640, Height: 480, Skew: 250 * time.Microsecond, Data: []byte{1, 2}, Path: "frames/7_right.jpg"},
+			Trigger: trigger, Frozen: 2 * time.Second,
+		}}},
+		{Kind: KindRecord, Record: &Record{GPS: &models.GPSData{
+			Timestamp: ts, Lat: 29.865123456789, Lon: -77.897, Alt: 268.4, SpeedMps: 13.9, HeadingDeg: 359.99, HDOP: 0.8,
+			Satellites: 12, FixQuality: 4, CorrectionAgeS: f(1.2), HAccM: f(0.02), VAccM: f(0.03), SpeedAccMps: f(0), HeadingAccDeg: f(0.5),
+			Projected: &models.Projection{X: 500123.25, Y: 3304567.5, Zone: "44R"},
+		}}},
+		{Kind: KindRecord, Record: &Record{IMU: &models.IMUData{
+			Timestamp: ts, Seq: 1 << 40, AccelX: 0.01, AccelY: -0.02, AccelZ: 9.80665, GyroX: 0.001, GyroY: 0, GyroZ: -0.003,
+			MagX: 21.5, MagY: -4, MagZ: 40.25,
+			Attitude: &models.Attitude{RollDeg: 1, PitchDeg: -2, YawDeg: 180, QW: 0.5, QX: 0.5, QY: -0.5, QZ: 0.5},
+		}}},
+		{Kind: KindRecord, Record: &Record{Lidar: &models.LidarPacket{
+			Timestamp: ts, Seq: 9, NumPoints: 384, Format: "vlp16", RawCloud: []byte{1, 2, 3}, Path: "clouds/9.bin",
+			Trigger: trigger, DeviceTime: &dev,
+		}}},
+		{Kind: KindRecord, Record: &Record{Radar: &models.RadarScan{
+			Timestamp: ts, Seq: 3,
+			Targets:        []models.RadarTarget{{ID: -1, RangeM: 12.5, AzimuthDeg: -3, VelocityMps: 1.25, RCS: -10}, {}},
+			Detections:     []models.RadarDetection{{RangeM: 40, AzimuthDeg: 10, ElevationDeg: -1, DopplerMps: -2.5, PowerDB: 30}},
+			DetectionsPath: "radar_detections/3.bin", DeviceTime: &dev,
+		}}},
+		{Kind: KindRecord, Record: &Record{Env: &models.EnvData{Timestamp: ts, TemperatureC: -4.5, HumidityPct: 80, PressureHPa: 1013.25}}},
+		{Kind: KindRecord, Record: &Record{Env: &models.EnvData{Timestamp: ts}}},
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	send, recv := NewConn(a), NewConn(b)
+	go func() {
+		for _, m := range msgs {
+			if err := send.Send(m); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i, want := range msgs {
+		got, err := recv.Recv()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("message %d:\ngot  %+v\nwant %+v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package remote
+
+import (
+	"context"
+	"sync"
+)
+
+// Queue is a FIFO of records bounded by total payload size. When full, the
+// oldest records are dropped so the freshest data survives a long outage.
+// It is safe for concurrent use.
+type Queue struct {
+	mu       sync.Mutex
+	items    []*Record
+	bytes    int
+	maxBytes int
+	dropped  uint64
+	notify   chan struct{}
+}
+
+func NewQueue(maxBytes int) *Queue {
+	return &Queue{maxBytes: maxBytes, notify: make(chan struct{}, 1)}
+}
+
+// Push appends r, evicting the oldest records if the size bound is exceeded.
+func (q *Queue) Push(r *Record) {
+	q.mu.Lock()
+	q.items = append(q.items, r)
+	q.bytes += r.Size()
+	for q.bytes > q.maxBytes && len(q.items) > 1 {
+		q.bytes -= q.items[0].Size()
+		q.items[0] = nil
+		q.items = q.items[1:]
+		q.dropped++
+	}
+	q.mu.Unlock()
+	q.wake()
+}
+
+// PushFront puts r back at the head, for a record whose send failed.
+func (q *Queue) PushFront(r *Record) {
+	q.mu.Lock()
+	q.items = append([]*Record{r}, q.items...)
+	q.bytes += r.Size()
+	q.mu.Unlock()
+	q.wake()
+}
+
+// Pop blocks until a record is available or ctx is cancelled.
+func (q *Queue) Pop(ctx context.Context) (*Record, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			r := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.bytes -= r.Size()
+			q.mu.Unlock()
+			return r, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-q.notify:
+		}
+	}
+}
+
+// Len returns the number of queued records and their total size.
+func (q *Queue) Len() (n, bytes int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items), q.bytes
+}
+
+// Dropped returns the number of records evicted because the queue was full.
+func (q *Queue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+func (q *Queue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// recordOverhead approximates the encoded size of a record's fixed fields.
+const recordOverhead = 128
+
+// Size approximates the encoded size of r in bytes.
+func (r *Record) Size() int {
+	n := recordOverhead
+	switch {
+	case r.Camera != nil:
+		n += len(r.Camera.Data)
+	case r.Lidar != nil:
+		n += len(r.Lidar.RawCloud)
+	case r.Radar != nil:
+		n += 40 * len(r.Radar.Targets)
+	}
+	return n
+}
@@ -0,0 +1,635 @@
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+// Messages are sent in the protobuf wire format, each preceded by its
+// length as a uvarint (as writeDelimitedTo writes them), so that agents
+// can be written in any language with a protobuf library and the schema
+// below. Timestamps are Unix nanoseconds; durations are nanoseconds.
+// Unknown fields are skipped.
+//
+//	syntax = "proto3";
+//	enum Kind { KIND_UNSPECIFIED = 0; HELLO = 1; RECORD = 2; PING = 3; PONG = 4; REJECT = 5; }
+//	message Message {
+//	  Kind kind = 1;
+//	  Hello hello = 2;
+//	  Sync sync = 3;
+//	  Record record = 4;
+//	  string reason = 5;
+//	}
+//	message Hello { int64 version = 1; string agent_id = 2; string token = 3; }
+//	message Sync { int64 t1 = 1; int64 t2 = 2; int64 t3 = 3; }
+//	message Record {
+//	  oneof sample {
+//	    CameraFrame camera = 1; GPSData gps = 2; IMUData imu = 3;
+//	    LidarPacket lidar = 4; RadarScan radar = 5; EnvData env = 6;
+//	  }
+//	}
+//	message CameraFrame {
+//	  sfixed64 timestamp_ns = 1;
+//	  uint64 frame_id = 2;
+//	  int64 width = 3; int64 height = 4;
+//	  optional double exposure_us = 5; optional double gain_db = 6;
+//	  ImageStats stats = 7;
+//	  bytes data = 8; string path = 9;
+//	  StereoFrame right = 10;
+//	  TriggerMatch trigger = 11;
+//	  int64 frozen_ns = 12;
+//	}
+//	message ImageStats {
+//	  double mean_lum = 1; double p05_lum = 2; double p50_lum = 3; double p95_lum = 4;
+//	  double dark_pct = 5; double bright_pct = 6; double sharpness = 7;
+//	}
+//	message StereoFrame { int64 width = 1; int64 height = 2; int64 skew_ns = 3; bytes data = 4; string path = 5; }
+//	message TriggerMatch { uint64 id = 1; int64 offset_ns = 2; }
+//	message GPSData {
+//	  sfixed64 timestamp_ns = 1;
+//	  double lat = 2; double lon = 3; double alt = 4;
+//	  double speed_mps = 5; double heading_deg = 6; double hdop = 7;
+//	  int64 satellites = 8; int64 fix_quality = 9;
+//	  optional double correction_age_s = 10;
+//	  optional double h_acc_m = 11; optional double v_acc_m = 12;
+//	  optional double speed_acc_mps = 13; optional double heading_acc_deg = 14;
+//	  Projection projected = 15;
+//	}
+//	message Projection { double x = 1; double y = 2; string zone = 3; }
+//	message IMUData {
+//	  sfixed64 timestamp_ns = 1;
+//	  uint64 seq = 2;
+//	  double ax = 3; double ay = 4; double az = 5;
+//	  double gx = 6; double gy = 7; double gz = 8;
+//	  double mx = 9; double my = 10; double mz = 11;
+//	  Attitude attitude = 12;
+//	}
+//	message Attitude {
+//	  double roll_deg = 1; double pitch_deg = 2; double yaw_deg = 3;
+//	  double qw = 4; double qx = 5; double qy = 6; double qz = 7;
+//	}
+//	message LidarPacket {
+//	  sfixed64 timestamp_ns = 1;
+//	  uint64 seq = 2;
+//	  int64 num_points = 3;
+//	  string format = 4; bytes raw_cloud = 5; string path = 6;
+//	  TriggerMatch trigger = 7;
+//	  optional sfixed64 device_time_ns = 8;
+//	}
+//	message RadarScan {
+//	  sfixed64 timestamp_ns = 1;
+//	  uint64 seq = 2;
+//	  repeated RadarTarget targets = 3;
+//	  repeated RadarDetection detections = 4;
+//	  string detections_path = 5;
+//	  optional sfixed64 device_time_ns = 6;
+//	}
+//	message RadarTarget { int64 id = 1; double range_m = 2; double azimuth_deg = 3; double velocity_mps = 4; double rcs_dbsm = 5; }
+//	message RadarDetection {
+//	  double range_m = 1; double azimuth_deg = 2; double elevation_deg = 3;
+//	  double doppler_mps = 4; double power_db = 5;
+//	}
+//	message EnvData { sfixed64 timestamp_ns = 1; double temperature_c = 2; double humidity_pct = 3; double pressure_hpa = 4; }
+
+// MaxMessage bounds the length of a message a Conn accepts.
+const MaxMessage = 64 << 20
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// appendVarint appends a varint field unless it is 0, as proto3 leaves
+// fields at their default out.
+func appendVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendInt(b []byte, field int, v int64) []byte {
+	return appendVarint(b, field, uint64(v))
+}
+
+func appendFixed64(b []byte, field int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(appendTag(b, field, wireFixed64), v)
+}
+
+func appendDouble(b []byte, field int, v float64) []byte {
+	if math.Float64bits(v) == 0 {
+		return b
+	}
+	return appendFixed64(b, field, math.Float64bits(v))
+}
+
+// appendOptDouble appends an optional double field if v is set.
+func appendOptDouble(b []byte, field int, v *float64) []byte {
+	if v == nil {
+		return b
+	}
+	return appendFixed64(b, field, math.Float64bits(*v))
+}
+
+func appendTime(b []byte, field int, t time.Time) []byte {
+	return appendFixed64(b, field, uint64(t.UnixNano()))
+}
+
+func appendOptTime(b []byte, field int, t *time.Time) []byte {
+	if t == nil {
+		return b
+	}
+	return appendTime(b, field, *t)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return appendMessage(b, field, v)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendMessage appends an embedded message, also an empty one, whose
+// presence is what it carries.
+func appendMessage(b []byte, field int, msg []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(msg)))
+	return append(b, msg...)
+}
+
+// wireField is one decoded field. For varint and fixed fields the value is
+// in v; length-delimited fields are in data.
+type wireField struct {
+	num  int
+	wire int
+	v    uint64
+	data []byte
+}
+
+func (f wireField) int() int                { return int(int64(f.v)) }
+func (f wireField) double() float64         { return math.Float64frombits(f.v) }
+func (f wireField) duration() time.Duration { return time.Duration(int64(f.v)) }
+func (f wireField) time() time.Time         { return time.Unix(0, int64(f.v)).UTC() }
+
+func (f wireField) optDouble() *float64 {
+	v := f.double()
+	return &v
+}
+
+func (f wireField) optTime() *time.Time {
+	t := f.time()
+	return &t
+}
+
+// eachField calls fn for every field of msg in order.
+func eachField(msg []byte, fn func(wireField) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errTruncated
+		}
+		msg = msg[n:]
+		f := wireField{num: int(tag >> 3), wire: int(tag & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.v, n = binary.Uvarint(msg); n <= 0 {
+				return errTruncated
+			}
+			msg = msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return errTruncated
+			}
+			f.v, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return errTruncated
+			}
+			f.data, msg = msg[n:n+int(l)], msg[n+int(l):]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return errTruncated
+			}
+			f.v, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d", f.wire)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode decodes the embedded message of f into a new T.
+func decode[T any](f wireField, fn func(*T, wireField) error) (*T, error) {
+	v := new(T)
+	return v, eachField(f.data, func(f wireField) error { return fn(v, f) })
+}
+
+func (m *Message) marshal() []byte {
+	b := appendVarint(nil, 1, uint64(m.Kind))
+	if h := m.Hello; h != nil {
+		var hb []byte
+		hb = appendInt(hb, 1, int64(h.Version))
+		hb = appendString(hb, 2, h.AgentID)
+		hb = appendString(hb, 3, h.Token)
+		b = appendMessage(b, 2, hb)
+	}
+	if s := m.Sync; s != nil {
+		b = appendMessage(b, 3, appendInt(appendInt(appendInt(nil, 1, s.T1), 2, s.T2), 3, s.T3))
+	}
+	if r := m.Record; r != nil {
+		b = appendMessage(b, 4, r.marshal())
+	}
+	return appendString(b, 5, m.Reason)
+}
+
+func (m *Message) unmarshal(msg []byte) error {
+	*m = Message{}
+	return eachField(msg, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			m.Kind = Kind(f.v)
+		case 2:
+			m.Hello, err = decode(f, func(h *Hello, f wireField) error {
+				switch f.num {
+				case 1:
+					h.Version = f.int()
+				case 2:
+					h.AgentID = string(f.data)
+				case 3:
+					h.Token = string(f.data)
+				}
+				return nil
+			})
+		case 3:
+			m.Sync, err = decode(f, func(s *Sync, f wireField) error {
+				switch f.num {
+				case 1:
+					s.T1 = int64(f.v)
+				case 2:
+					s.T2 = int64(f.v)
+				case 3:
+					s.T3 = int64(f.v)
+				}
+				return nil
+			})
+		case 4:
+			m.Record, err = decode(f, (*Record).unmarshalField)
+		case 5:
+			m.Reason = string(f.data)
+		}
+		return err
+	})
+}
+
+func (r *Record) marshal() []byte {
+	switch {
+	case r.Camera != nil:
+		return appendMessage(nil, 1, marshalCamera(r.Camera))
+	case r.GPS != nil:
+		return appendMessage(nil, 2, marshalGPS(r.GPS))
+	case r.IMU != nil:
+		return appendMessage(nil, 3, marshalIMU(r.IMU))
+	case r.Lidar != nil:
+		return appendMessage(nil, 4, marshalLidar(r.Lidar))
+	case r.Radar != nil:
+		return appendMessage(nil, 5, marshalRadar(r.Radar))
+	case r.Env != nil:
+		return appendMessage(nil, 6, marshalEnv(r.Env))
+	}
+	return nil
+}
+
+func (r *Record) unmarshalField(f wireField) (err error) {
+	switch f.num {
+	case 1:
+		r.Camera, err = decode(f, unmarshalCamera)
+	case 2:
+		r.GPS, err = decode(f, unmarshalGPS)
+	case 3:
+		r.IMU, err = decode(f, unmarshalIMU)
+	case 4:
+		r.Lidar, err = decode(f, unmarshalLidar)
+	case 5:
+		r.Radar, err = decode(f, unmarshalRadar)
+	case 6:
+		r.Env, err = decode(f, unmarshalEnv)
+	}
+	return err
+}
+
+func marshalCamera(c *models.CameraFrame) []byte {
+	b := appendTime(nil, 1, c.Timestamp)
+	b = appendVarint(b, 2, c.FrameID)
+	b = appendInt(b, 3, int64(c.Width))
+	b = appendInt(b, 4, int64(c.Height))
+	b = appendOptDouble(b, 5, c.ExposureUs)
+	b = appendOptDouble(b, 6, c.GainDB)
+	if s := c.Stats; s != nil {
+		var sb []byte
+		for i, v := range []float64{s.MeanLum, s.P05Lum, s.P50Lum, s.P95Lum, s.DarkPct, s.BrightPct, s.Sharpness} {
+			sb = appendDouble(sb, i+1, v)
+		}
+		b = appendMessage(b, 7, sb)
+	}
+	b = appendBytes(b, 8, c.Data)
+	b = appendString(b, 9, c.Path)
+	if r := c.Right; r != nil {
+		rb := appendInt(nil, 1, int64(r.Width))
+		rb = appendInt(rb, 2, int64(r.Height))
+		rb = appendInt(rb, 3, int64(r.Skew))
+		rb = appendBytes(rb, 4, r.Data)
+		rb = appendString(rb, 5, r.Path)
+		b = appendMessage(b, 10, rb)
+	}
+	if t := c.Trigger; t != nil {
+		b = appendMessage(b, 11, marshalTrigger(t))
+	}
+	return appendInt(b, 12, int64(c.Frozen))
+}
+
+func unmarshalCamera(c *models.CameraFrame, f wireField) (err error) {
+	switch f.num {
+	case 1:
+		c.Timestamp = f.time()
+	case 2:
+		c.FrameID = f.v
+	case 3:
+		c.Width = f.int()
+	case 4:
+		c.Height = f.int()
+	case 5:
+		c.ExposureUs = f.optDouble()
+	case 6:
+		c.GainDB = f.optDouble()
+	case 7:
+		c.Stats, err = decode(f, func(s *models.ImageStats, f wireField) error {
+			if p := field(f.num, 1, &s.MeanLum, &s.P05Lum, &s.P50Lum, &s.P95Lum, &s.DarkPct, &s.BrightPct, &s.Sharpness); p != nil {
+				*p = f.double()
+			}
+			return nil
+		})
+	case 8:
+		c.Data = f.data
+	case 9:
+		c.Path = string(f.data)
+	case 10:
+		c.Right, err = decode(f, func(r *models.StereoFrame, f wireField) error {
+			switch f.num {
+			case 1:
+				r.Width = f.int()
+			case 2:
+				r.Height = f.int()
+			case 3:
+				r.Skew = f.duration()
+			case 4:
+				r.Data = f.data
+			case 5:
+				r.Path = string(f.data)
+			}
+			return nil
+		})
+	case 11:
+		c.Trigger, err = decode(f, unmarshalTrigger)
+	case 12:
+		c.Frozen = f.duration()
+	}
+	return err
+}
+
+func marshalTrigger(t *models.TriggerMatch) []byte {
+	return appendInt(appendVarint(nil, 1, t.ID), 2, int64(t.Offset))
+}
+
+func unmarshalTrigger(t *models.TriggerMatch, f wireField) error {
+	switch f.num {
+	case 1:
+		t.ID = f.v
+	case 2:
+		t.Offset = f.duration()
+	}
+	return nil
+}
+
+// field returns which of fields, numbered from first, is field num; nil
+// if none is.
+func field[T any](num, first int, fields ...*T) *T {
+	if i := num - first; i >= 0 && i < len(fields) {
+		return fields[i]
+	}
+	return nil
+}
+
+func marshalGPS(g *models.GPSData) []byte {
+	b := appendTime(nil, 1, g.Timestamp)
+	for i, v := range []float64{g.Lat, g.Lon, g.Alt, g.SpeedMps, g.HeadingDeg, g.HDOP} {
+		b = appendDouble(b, i+2, v)
+	}
+	b = appendInt(b, 8, int64(g.Satellites))
+	b = appendInt(b, 9, int64(g.FixQuality))
+	for i, v := range []*float64{g.CorrectionAgeS, g.HAccM, g.VAccM, g.SpeedAccMps, g.HeadingAccDeg} {
+		b = appendOptDouble(b, i+10, v)
+	}
+	if p := g.Projected; p != nil {
+		b = appendMessage(b, 15, appendString(appendDouble(appendDouble(nil, 1, p.X), 2, p.Y), 3, p.Zone))
+	}
+	return b
+}
+
+func unmarshalGPS(g *models.GPSData, f wireField) (err error) {
+	switch f.num {
+	case 1:
+		g.Timestamp = f.time()
+	case 8:
+		g.Satellites = f.int()
+	case 9:
+		g.FixQuality = f.int()
+	case 15:
+		g.Projected, err = decode(f, func(p *models.Projection, f wireField) error {
+			if f.num == 3 {
+				p.Zone = string(f.data)
+			} else if q := field(f.num, 1, &p.X, &p.Y); q != nil {
+				*q = f.double()
+			}
+			return nil
+		})
+	default:
+		if p := field(f.num, 2, &g.Lat, &g.Lon, &g.Alt, &g.SpeedMps, &g.HeadingDeg, &g.HDOP); p != nil {
+			*p = f.double()
+		} else if p := field(f.num, 10, &g.CorrectionAgeS, &g.HAccM, &g.VAccM, &g.SpeedAccMps, &g.HeadingAccDeg); p != nil {
+			*p = f.optDouble()
+		}
+	}
+	return err
+}
+
+func marshalIMU(d *models.IMUData) []byte {
+	b := appendTime(nil, 1, d.Timestamp)
+	b = appendVarint(b, 2, d.Seq)
+	for i, v := range []float64{d.AccelX, d.AccelY, d.AccelZ, d.GyroX, d.GyroY, d.GyroZ, d.MagX, d.MagY, d.MagZ} {
+		b = appendDouble(b, i+3, v)
+	}
+	if a := d.Attitude; a != nil {
+		var ab []byte
+		for i, v := range []float64{a.RollDeg, a.PitchDeg, a.YawDeg, a.QW, a.QX, a.QY, a.QZ} {
+			ab = appendDouble(ab, i+1, v)
+		}
+		b = appendMessage(b, 12, ab)
+	}
+	return b
+}
+
+func unmarshalIMU(d *models.IMUData, f wireField) (err error) {
+	switch f.num {
+	case 1:
+		d.Timestamp = f.time()
+	case 2:
+		d.Seq = f.v
+	case 12:
+		d.Attitude, err = decode(f, func(a *models.Attitude, f wireField) error {
+			if p := field(f.num, 1, &a.RollDeg, &a.PitchDeg, &a.YawDeg, &a.QW, &a.QX, &a.QY, &a.QZ); p != nil {
+				*p = f.double()
+			}
+			return nil
+		})
+	default:
+		if p := field(f.num, 3, &d.AccelX, &d.AccelY, &d.AccelZ, &d.GyroX, &d.GyroY, &d.GyroZ, &d.MagX, &d.MagY, &d.MagZ); p != nil {
+			*p = f.double()
+		}
+	}
+	return err
+}
+
+func marshalLidar(l *models.LidarPacket) []byte {
+	b := appendTime(nil, 1, l.Timestamp)
+	b = appendVarint(b, 2, l.Seq)
+	b = appendInt(b, 3, int64(l.NumPoints))
+	b = appendString(b, 4, l.Format)
+	b = appendBytes(b, 5, l.RawCloud)
+	b = appendString(b, 6, l.Path)
+	if t := l.Trigger; t != nil {
+		b = appendMessage(b, 7, marshalTrigger(t))
+	}
+	return appendOptTime(b, 8, l.DeviceTime)
+}
+
+func unmarshalLidar(l *models.LidarPacket, f wireField) (err error) {
+	switch f.num {
+	case 1:
+		l.Timestamp = f.time()
+	case 2:
+		l.Seq = f.v
+	case 3:
+		l.NumPoints = f.int()
+	case 4:
+		l.Format = string(f.data)
+	case 5:
+		l.RawCloud = f.data
+	case 6:
+		l.Path = string(f.data)
+	case 7:
+		l.Trigger, err = decode(f, unmarshalTrigger)
+	case 8:
+		l.DeviceTime = f.optTime()
+	}
+	return err
+}
+
+func marshalRadar(s *models.RadarScan) []byte {
+	b := appendTime(nil, 1, s.Timestamp)
+	b = appendVarint(b, 2, s.Seq)
+	for _, t := range s.Targets {
+		tb := appendInt(nil, 1, int64(t.ID))
+		for i, v := range []float64{t.RangeM, t.AzimuthDeg, t.VelocityMps, t.RCS} {
+			tb = appendDouble(tb, i+2, v)
+		}
+		b = appendMessage(b, 3, tb)
+	}
+	for _, d := range s.Detections {
+		var db []byte
+		for i, v := range []float64{d.RangeM, d.AzimuthDeg, d.ElevationDeg, d.DopplerMps, d.PowerDB} {
+			db = appendDouble(db, i+1, v)
+		}
+		b = appendMessage(b, 4, db)
+	}
+	b = appendString(b, 5, s.DetectionsPath)
+	return appendOptTime(b, 6, s.DeviceTime)
+}
+
+func unmarshalRadar(s *models.RadarScan, f wireField) error {
+	switch f.num {
+	case 1:
+		s.Timestamp = f.time()
+	case 2:
+		s.Seq = f.v
+	case 3:
+		t, err := decode(f, func(t *models.RadarTarget, f wireField) error {
+			if f.num == 1 {
+				t.ID = f.int()
+			} else if p := field(f.num, 2, &t.RangeM, &t.AzimuthDeg, &t.VelocityMps, &t.RCS); p != nil {
+				*p = f.double()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.Targets = append(s.Targets, *t)
+	case 4:
+		d, err := decode(f, func(d *models.RadarDetection, f wireField) error {
+			if p := field(f.num, 1, &d.RangeM, &d.AzimuthDeg, &d.ElevationDeg, &d.DopplerMps, &d.PowerDB); p != nil {
+				*p = f.double()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		s.Detections = append(s.Detections, *d)
+	case 5:
+		s.DetectionsPath = string(f.data)
+	case 6:
+		s.DeviceTime = f.optTime()
+	}
+	return nil
+}
+
+func marshalEnv(e *models.EnvData) []byte {
+	b := appendTime(nil, 1, e.Timestamp)
+	for i, v := range []float64{e.TemperatureC, e.HumidityPct, e.PressureHPa} {
+		b = appendDouble(b, i+2, v)
+	}
+	return b
+}
+
+func unmarshalEnv(e *models.EnvData, f wireField) error {
+	if f.num == 1 {
+		e.Timestamp = f.time()
+	} else if p := field(f.num, 2, &e.TemperatureC, &e.HumidityPct, &e.PressureHPa); p != nil {
+		*p = f.double()
+	}
+	return nil
+}
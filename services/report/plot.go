@@ -0,0 +1,138 @@
+package report
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+)
+
+var (
+	plotBG   = color.RGBA{255, 255, 255, 255}
+	plotAxis = color.RGBA{160, 160, 160, 255}
+	plotLine = color.RGBA{31, 119, 180, 255}
+	plotMark = color.RGBA{214, 39, 40, 255}
+)
+
+const plotMargin = 10
+
+// writeTrackPNG draws an east/north track with equal scale on both axes
+// and marks the start point. It returns the east and north extent in
+// metres.
+func writeTrackPNG(path string, pts [][2]float64) ([2]float64, error) {
+	const size = 600
+	minX, maxX, minY, maxY := bounds(pts)
+	ext := [2]float64{maxX - minX, maxY - minY}
+	scale := float64(size-2*plotMargin) / math.Max(math.Max(ext[0], ext[1]), 1)
+	img := newCanvas(size, size)
+	px := func(p [2]float64) (int, int) {
+		return plotMargin + int((p[0]-minX)*scale), size - plotMargin - int((p[1]-minY)*scale)
+	}
+	polyline(img, pts, px, plotLine)
+	x, y := px(pts[0])
+	for dx := -3; dx <= 3; dx++ {
+		for dy := -3; dy <= 3; dy++ {
+			img.Set(x+dx, y+dy, plotMark)
+		}
+	}
+	return ext, savePNG(path, img)
+}
+
+// writeSeriesPNG draws y over x scaled to fill the plot and returns the y
+// range.
+func writeSeriesPNG(path string, pts [][2]float64) ([2]float64, error) {
+	const w, h = 800, 250
+	minX, maxX, minY, maxY := bounds(pts)
+	if maxY == minY {
+		maxY = minY + 1
+	}
+	sx := float64(w-2*plotMargin) / math.Max(maxX-minX, 1e-9)
+	sy := float64(h-2*plotMargin) / (maxY - minY)
+	img := newCanvas(w, h)
+	polyline(img, pts, func(p [2]float64) (int, int) {
+		return plotMargin + int((p[0]-minX)*sx), h - plotMargin - int((p[1]-minY)*sy)
+	}, plotLine)
+	return [2]float64{minY, maxY}, savePNG(path, img)
+}
+
+func bounds(pts [][2]float64) (minX, maxX, minY, maxY float64) {
+	minX, maxX, minY, maxY = pts[0][0], pts[0][0], pts[0][1], pts[0][1]
+	for _, p := range pts[1:] {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	return
+}
+
+// newCanvas returns a blank image with a frame around the plot area.
+func newCanvas(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(plotBG), image.Point{}, draw.Src)
+	for x := plotMargin; x <= w-plotMargin; x++ {
+		img.Set(x, plotMargin, plotAxis)
+		img.Set(x, h-plotMargin, plotAxis)
+	}
+	for y := plotMargin; y <= h-plotMargin; y++ {
+		img.Set(plotMargin, y, plotAxis)
+		img.Set(w-plotMargin, y, plotAxis)
+	}
+	return img
+}
+
+func polyline(img *image.RGBA, pts [][2]float64, px func([2]float64) (int, int), c color.Color) {
+	x0, y0 := px(pts[0])
+	for _, p := range pts[1:] {
+		x1, y1 := px(p)
+		line(img, x0, y0, x1, y1, c)
+		x0, y0 = x1, y1
+	}
+}
+
+// line draws a segment with Bresenham's algorithm.
+func line(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
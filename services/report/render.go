@@ -0,0 +1,138 @@
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Output file names, by format.
+var Files = map[string]string{
+	"html": "report.html",
+	"md":   "report.md",
+}
+
+var funcs = map[string]any{
+	"bytes": utils.FormatBytes,
+	"f1":    func(v float64) string { return fmt.Sprintf("%.1f", v) },
+	"f2":    func(v float64) string { return fmt.Sprintf("%.2f", v) },
+	"ts":    func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+}
+
+// Write builds the report of the session in dir and writes it into dir in
+// format, one of Files, returning its path.
+func Write(dir, format string) (string, error) {
+	name, ok := Files[format]
+	if !ok {
+		return "", fmt.Errorf("unknown format %q (html or md)", format)
+	}
+	r, err := Build(dir)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := Render(f, r, format); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// Render writes r in format "html" or "md".
+func Render(w io.Writer, r *Report, format string) error {
+	switch format {
+	case "html":
+		return htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(htmlTemplate)).Execute(w, r)
+	case "md":
+		return template.Must(template.New("md").Funcs(funcs).Parse(mdTemplate)).Execute(w, r)
+	}
+	return fmt.Errorf("unknown report format %q (html or md)", format)
+}
+
+const htmlTemplate = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Session}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+img.frame { width: 24%; margin-right: 1%; }
+</style></head><body>
+<h1>{{.Session}}</h1>
+<p>{{ts .Start}} &ndash; {{ts .End}} ({{.Duration}})</p>
+
+<h2>Sensors</h2>
+{{with .GapNote}}<p><em>{{.}}</em></p>{{end}}
+<table>
+<tr><th>Sensor</th><th>Samples</th><th>Rate (Hz)</th><th>Missing</th><th>Drop %</th><th>Gaps</th><th>Max gap (ms)</th></tr>
+{{range .Sensors}}<tr><td>{{.Name}}</td><td>{{.Samples}}</td><td>{{f2 .RateHz}}</td><td>{{.Missing}}</td><td>{{f2 .DropPct}}</td><td>{{.Gaps}}</td><td>{{.MaxGapMs}}</td></tr>
+{{end}}</table>
+{{with .ThrottleNote}}<p>{{.}}</p>{{end}}
+
+{{if .Track}}<h2>GPS track</h2>
+<p>{{f1 .DistanceM}} m travelled; plot spans {{f1 (index .TrackExtent 0)}} m east &times; {{f1 (index .TrackExtent 1)}} m north, start marked in red.</p>
+<img src="{{.Track}}" alt="GPS track">
+{{end}}
+{{if .IMU}}<h2>IMU acceleration magnitude</h2>
+<p>{{f2 (index .IMURange 0)}} &ndash; {{f2 (index .IMURange 1)}} m/s&sup2; over the session.</p>
+<img src="{{.IMU}}" alt="IMU acceleration magnitude">
+{{end}}
+{{if .SampleFrames}}<h2>Sample frames</h2>
+<p>{{range .SampleFrames}}<img class="frame" src="{{.}}" alt="{{.}}">{{end}}</p>
+{{end}}
+<h2>Storage</h2>
+<table>
+<tr><th>Entry</th><th>Files</th><th>Size</th></tr>
+{{range .Storage}}<tr><td>{{.Name}}</td><td>{{.Files}}</td><td>{{bytes .Bytes}}</td></tr>
+{{end}}<tr><th>Total</th><th></th><th>{{bytes .StorageTotal}}</th></tr>
+</table>
+</body></html>
+`
+
+const mdTemplate = `# {{.Session}}
+
+{{ts .Start}} – {{ts .End}} ({{.Duration}})
+
+## Sensors
+{{with .GapNote}}
+_{{.}}_
+{{end}}
+| Sensor | Samples | Rate (Hz) | Missing | Drop % | Gaps | Max gap (ms) |
+|---|---:|---:|---:|---:|---:|---:|
+{{range .Sensors}}| {{.Name}} | {{.Samples}} | {{f2 .RateHz}} | {{.Missing}} | {{f2 .DropPct}} | {{.Gaps}} | {{.MaxGapMs}} |
+{{end}}{{with .ThrottleNote}}
+{{.}}
+{{end}}
+{{if .Track}}## GPS track
+
+{{f1 .DistanceM}} m travelled; plot spans {{f1 (index .TrackExtent 0)}} m east × {{f1 (index .TrackExtent 1)}} m north, start marked in red.
+
+![GPS track]({{.Track}})
+{{end}}
+{{if .IMU}}## IMU acceleration magnitude
+
+{{f2 (index .IMURange 0)}} – {{f2 (index .IMURange 1)}} m/s² over the session.
+
+![IMU acceleration magnitude]({{.IMU}})
+{{end}}
+{{if .SampleFrames}}## Sample frames
+
+{{range .SampleFrames}}![{{.}}]({{.}}) {{end}}
+{{end}}
+## Storage
+
+| Entry | Files | Size |
+|---|---:|---:|
+{{range .Storage}}| {{.Name}} | {{.Files}} | {{bytes .Bytes}} |
+{{end}}| **Total** | | {{bytes .StorageTotal}} |
+`
@@ -0,0 +1,342 @@
+// Package report summarises a recorded session as an HTML or Markdown page
+// with plots, for a quick look at a recording without loading the data.
+package report
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+	"github.com/lkumar3-iitr/Sensor-Logger/views"
+)
+
+// Plot files written next to the report.
+const (
+	TrackPNG = "report_track.png"
+	IMUPNG   = "report_imu.png"
+)
+
+// maxSampleFrames is the number of camera frames shown in the report.
+const maxSampleFrames = 4
+
+// sampleFramePrefix starts the names of the copies of the sample frames
+// written next to the report when the frames are not files of the session,
+// e.g. report_frame_1.jpg.
+const sampleFramePrefix = "report_frame_"
+
+// Report is everything shown on the summary page of one session.
+type Report struct {
+	Session  string
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+
+	Sensors []SensorStats
+	GapNote string
+	// ThrottleNote tells how the simulated sensors were throttled for the
+	// host temperature, empty if they were not.
+	ThrottleNote string
+
+	// Track is the GPS track plot, empty without fixes; TrackExtent is
+	// its east/north extent in metres.
+	Track       string
+	TrackExtent [2]float64
+	DistanceM   float64
+
+	// IMU is the accelerometer magnitude plot, empty without IMU data.
+	IMU          string
+	IMURange     [2]float64
+	SampleFrames []string
+
+	Storage      []StorageEntry
+	StorageTotal int64
+}
+
+// SensorStats is one row of the sensor table.
+type SensorStats struct {
+	Name     string
+	File     string
+	Samples  int
+	RateHz   float64
+	Missing  uint64
+	DropPct  float64
+	Gaps     uint64
+	MaxGapMs int64
+}
+
+// StorageEntry is the size of one file or directory of the session.
+type StorageEntry struct {
+	Name  string
+	Files int
+	Bytes int64
+}
+
+// sensorFiles lists the per-sensor CSV files in report order, with the
+// binary log read instead when the CSV file is absent. Radar rows are per
+// target, so its samples are counted by distinct scan_seq.
+var sensorFiles = []struct{ name, file, bin, seqCol string }{
+	{"camera", views.CameraCSV, "", ""},
+	{"gps", views.GPSCSV, "", ""},
+	{"imu", views.IMUCSV, views.IMUBin, ""},
+	{"lidar", views.LidarCSV, "", ""},
+	{"radar", views.RadarCSV, views.RadarBin, "scan_seq"},
+	{"env", views.EnvCSV, "", ""},
+}
+
+// Build reads the session in dir and writes the plot images into it.
+func Build(dir string) (*Report, error) {
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a session directory", dir)
+	}
+	r := &Report{Session: filepath.Base(dir)}
+	manifest, err := views.ReadManifest(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if manifest == nil {
+		r.GapNote = "No manifest.json (session not closed cleanly); drop figures are unavailable."
+	}
+
+	tables := map[string]*views.Table{}
+	for _, s := range sensorFiles {
+		file := s.file
+		t, err := views.ReadTable(filepath.Join(dir, file))
+		if errors.Is(err, fs.ErrNotExist) && s.bin != "" {
+			file = s.bin
+			t, err = views.ReadRecordTable(filepath.Join(dir, file))
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tables[s.name] = t
+		st := SensorStats{Name: s.name, File: file}
+		first, last, n := span(t, s.seqCol)
+		st.Samples = n
+		if n > 1 {
+			st.RateHz = float64(n-1) / last.Sub(first).Seconds()
+		}
+		if n > 0 && (r.Start.IsZero() || first.Before(r.Start)) {
+			r.Start = first
+		}
+		if last.After(r.End) {
+			r.End = last
+		}
+		if manifest != nil {
+			g := manifest.Gaps[s.name]
+			st.Missing, st.Gaps, st.MaxGapMs = g.Missing, g.Gaps, g.MaxGapMs
+			if total := uint64(n) + g.Missing; total > 0 {
+				st.DropPct = 100 * float64(g.Missing) / float64(total)
+			}
+		}
+		r.Sensors = append(r.Sensors, st)
+	}
+	if manifest != nil {
+		r.Start, r.End = manifest.Start, manifest.End
+		if t := manifest.SimThrottle; t != nil {
+			r.ThrottleNote = fmt.Sprintf("Simulated sensors throttled for %.0f s, down to %.0f%% of their rates; the host peaked at %.1f °C.",
+				t.ThrottledS, t.MinRatePct, t.MaxTempC)
+			if t.ThrottledS == 0 {
+				r.ThrottleNote = fmt.Sprintf("Simulated sensors not throttled; the host peaked at %.1f °C.", t.MaxTempC)
+			}
+		}
+	}
+	r.Duration = r.End.Sub(r.Start).Round(time.Second)
+
+	if t := tables["gps"]; t != nil {
+		if err := r.plotTrack(dir, t); err != nil {
+			return nil, err
+		}
+	}
+	if t := tables["imu"]; t != nil {
+		if err := r.plotIMU(dir, t); err != nil {
+			return nil, err
+		}
+	}
+	if t := tables["camera"]; t != nil {
+		if r.SampleFrames, err = sampleFrames(dir, t); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.storage(dir); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// span returns the first and last timestamps of t and its sample count,
+// counting distinct values of seqCol when it is set.
+func span(t *views.Table, seqCol string) (first, last time.Time, n int) {
+	prev := ""
+	for i := range t.Rows {
+		ts, ok := t.Time(i)
+		if !ok {
+			continue
+		}
+		if seqCol != "" {
+			seq := t.String(i, seqCol)
+			if seq == prev {
+				continue
+			}
+			prev = seq
+		}
+		if n == 0 {
+			first = ts
+		}
+		last = ts
+		n++
+	}
+	return first, last, n
+}
+
+func (r *Report) plotTrack(dir string, t *views.Table) error {
+	var pts [][2]float64
+	var lat0, lon0 float64
+	for i := range t.Rows {
+		if q, _ := t.Float(i, "fix_quality"); q <= 0 {
+			continue
+		}
+		lat, ok1 := t.Float(i, "lat")
+		lon, ok2 := t.Float(i, "lon")
+		if !ok1 || !ok2 {
+			continue
+		}
+		if len(pts) == 0 {
+			lat0, lon0 = lat, lon
+		}
+		e, n, _ := utils.GeodeticToENU(lat, lon, 0, lat0, lon0, 0)
+		if len(pts) > 0 {
+			p := pts[len(pts)-1]
+			r.DistanceM += math.Hypot(e-p[0], n-p[1])
+		}
+		pts = append(pts, [2]float64{e, n})
+	}
+	if len(pts) < 2 {
+		return nil
+	}
+	ext, err := writeTrackPNG(filepath.Join(dir, TrackPNG), pts)
+	if err != nil {
+		return err
+	}
+	r.Track, r.TrackExtent = TrackPNG, ext
+	return nil
+}
+
+func (r *Report) plotIMU(dir string, t *views.Table) error {
+	var pts [][2]float64
+	var t0 time.Time
+	for i := range t.Rows {
+		ts, ok := t.Time(i)
+		ax, ok1 := t.Float(i, "ax")
+		ay, ok2 := t.Float(i, "ay")
+		az, ok3 := t.Float(i, "az")
+		if !ok || !ok1 || !ok2 || !ok3 {
+			continue
+		}
+		if t0.IsZero() {
+			t0 = ts
+		}
+		pts = append(pts, [2]float64{ts.Sub(t0).Seconds(), math.Sqrt(ax*ax + ay*ay + az*az)})
+	}
+	if len(pts) < 2 {
+		return nil
+	}
+	yr, err := writeSeriesPNG(filepath.Join(dir, IMUPNG), pts)
+	if err != nil {
+		return err
+	}
+	r.IMU, r.IMURange = IMUPNG, yr
+	return nil
+}
+
+// sampleFrames picks up to maxSampleFrames saved frames spread evenly over
+// the session. Frames in views.FramesTar are copied next to the report;
+// frames put to S3 are an error.
+func sampleFrames(dir string, t *views.Table) ([]string, error) {
+	// The copies of an earlier report go, as the frames may have changed.
+	old, _ := filepath.Glob(filepath.Join(dir, sampleFramePrefix+"*"))
+	for _, f := range old {
+		if err := os.Remove(f); err != nil {
+			return nil, err
+		}
+	}
+	var paths []string
+	for i := range t.Rows {
+		if p := t.String(i, "path"); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	frames, err := views.OpenFrames(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer frames.Close()
+	paths = slices.DeleteFunc(paths, func(p string) bool { return !frames.HasFrame(p) })
+	if len(paths) > maxSampleFrames {
+		out := make([]string, maxSampleFrames)
+		for i := range out {
+			out[i] = paths[i*(len(paths)-1)/(maxSampleFrames-1)]
+		}
+		paths = out
+	}
+	if _, ok := frames.(views.DirFrameReader); ok {
+		return paths, nil
+	}
+	for i, p := range paths {
+		data, err := frames.ReadFrame(p)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = fmt.Sprintf("%s%d%s", sampleFramePrefix, i+1, path.Ext(p))
+		if err := os.WriteFile(filepath.Join(dir, paths[i]), data, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// storage totals the size of every top-level entry of the session.
+func (r *Report) storage(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		se := StorageEntry{Name: e.Name()}
+		err := filepath.WalkDir(filepath.Join(dir, e.Name()), func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			se.Files++
+			se.Bytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			se.Name += "/"
+		}
+		r.Storage = append(r.Storage, se)
+		r.StorageTotal += se.Bytes
+	}
+	sort.Slice(r.Storage, func(i, j int) bool { return r.Storage[i].Bytes > r.Storage[j].Bytes })
+	return nil
+}
@@ -0,0 +1,88 @@
+package rosbag
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Message serializes ROS messages: little-endian primitives, strings and
+// variable-length arrays prefixed with their uint32 length, nested
+// messages inline.
+type Message struct {
+	b []byte
+}
+
+// Bytes returns the serialized message.
+func (m *Message) Bytes() []byte { return m.b }
+
+func (m *Message) Bool(v bool) *Message {
+	if v {
+		return m.Uint8(1)
+	}
+	return m.Uint8(0)
+}
+
+func (m *Message) Int8(v int8) *Message { return m.Uint8(uint8(v)) }
+
+func (m *Message) Uint8(v uint8) *Message {
+	m.b = append(m.b, v)
+	return m
+}
+
+func (m *Message) Uint16(v uint16) *Message {
+	m.b = binary.LittleEndian.AppendUint16(m.b, v)
+	return m
+}
+
+func (m *Message) Uint32(v uint32) *Message {
+	m.b = binary.LittleEndian.AppendUint32(m.b, v)
+	return m
+}
+
+func (m *Message) Float64(v float64) *Message {
+	m.b = binary.LittleEndian.AppendUint64(m.b, math.Float64bits(v))
+	return m
+}
+
+// Float64s writes values of a fixed-size array.
+func (m *Message) Float64s(vs ...float64) *Message {
+	for _, v := range vs {
+		m.Float64(v)
+	}
+	return m
+}
+
+func (m *Message) String(s string) *Message {
+	m.Uint32(uint32(len(s)))
+	m.b = append(m.b, s...)
+	return m
+}
+
+// Bytes8 writes a uint8[] array.
+func (m *Message) Bytes8(p []byte) *Message {
+	m.Uint32(uint32(len(p)))
+	m.b = append(m.b, p...)
+	return m
+}
+
+func (m *Message) Time(t time.Time) *Message {
+	sec, nsec := rosTime(t)
+	return m.Uint32(sec).Uint32(nsec)
+}
+
+// Header writes a std_msgs/Header.
+func (m *Message) Header(seq uint32, stamp time.Time, frameID string) *Message {
+	return m.Uint32(seq).Time(stamp).String(frameID)
+}
+
+// Vector3 writes a geometry_msgs/Vector3.
+func (m *Message) Vector3(x, y, z float64) *Message { return m.Float64s(x, y, z) }
+
+// Quaternion writes a geometry_msgs/Quaternion.
+func (m *Message) Quaternion(x, y, z, w float64) *Message { return m.Float64s(x, y, z, w) }
+
+// rosTime splits t into the seconds and nanoseconds of a ROS time.
+func rosTime(t time.Time) (uint32, uint32) {
+	return uint32(t.Unix()), uint32(t.Nanosecond())
+}
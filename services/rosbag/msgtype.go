@@ -0,0 +1,184 @@
+package rosbag
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+)
+
+// MsgType is a ROS message type: its .msg definition and the message
+// types it uses. The MD5 sum and full definition that bag connections
+// carry are derived from them as genmsg does.
+type MsgType struct {
+	Name string // e.g. "sensor_msgs/Imu"
+	Def  string
+	Deps []*MsgType
+}
+
+// builtins are the ROS primitive types.
+var builtins = map[string]bool{
+	"bool": true, "int8": true, "uint8": true, "int16": true, "uint16": true, "int32": true, "uint32": true,
+	"int64": true, "uint64": true, "float32": true, "float64": true, "string": true, "time": true,
+	"duration": true, "byte": true, "char": true,
+}
+
+// MD5 returns the MD5 sum of t: of its constants and then its fields, one
+// per line, with the types of nested messages replaced by their sums.
+func (t *MsgType) MD5() string {
+	var consts, fields []string
+	for _, line := range strings.Split(t.Def, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		typ, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		if name, value, ok := strings.Cut(rest, "="); ok {
+			consts = append(consts, typ+" "+strings.TrimSpace(name)+"="+strings.TrimSpace(value))
+			continue
+		}
+		base, _, _ := strings.Cut(typ, "[")
+		if !builtins[base] {
+			typ = t.dep(base).MD5()
+		}
+		fields = append(fields, typ+" "+rest)
+	}
+	sum := md5.Sum([]byte(strings.Join(append(consts, fields...), "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// dep returns the dependency named name, with or without its package;
+// Header is std_msgs/Header.
+func (t *MsgType) dep(name string) *MsgType {
+	if name == "Header" {
+		name = "std_msgs/Header"
+	}
+	for _, d := range t.Deps {
+		if d.Name == name || strings.HasSuffix(d.Name, "/"+name) {
+			return d
+		}
+	}
+	panic("rosbag: " + t.Name + " uses undeclared type " + name)
+}
+
+// FullText returns the definition of t followed by those of every message
+// type it uses, the message_definition of a connection.
+func (t *MsgType) FullText() string {
+	var b strings.Builder
+	b.WriteString(t.Def)
+	seen := map[*MsgType]bool{}
+	var walk func(*MsgType)
+	walk = func(m *MsgType) {
+		for _, d := range m.Deps {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			b.WriteString("\n" + strings.Repeat("=", 80) + "\nMSG: " + d.Name + "\n" + d.Def)
+			walk(d)
+		}
+	}
+	walk(t)
+	return b.String()
+}
+
+// Message types written by the exporters.
+var (
+	Header = &MsgType{Name: "std_msgs/Header", Def: `uint32 seq
+time stamp
+string frame_id`}
+
+	Vector3 = &MsgType{Name: "geometry_msgs/Vector3", Def: `float64 x
+float64 y
+float64 z`}
+
+	Quaternion = &MsgType{Name: "geometry_msgs/Quaternion", Def: `float64 x
+float64 y
+float64 z
+float64 w`}
+
+	Transform = &MsgType{Name: "geometry_msgs/Transform", Def: `Vector3 translation
+Quaternion rotation`, Deps: []*MsgType{Vector3, Quaternion}}
+
+	TransformStamped = &MsgType{Name: "geometry_msgs/TransformStamped", Def: `Header header
+string child_frame_id
+Transform transform`, Deps: []*MsgType{Header, Transform}}
+
+	TFMessage = &MsgType{Name: "tf2_msgs/TFMessage", Def: `geometry_msgs/TransformStamped[] transforms`,
+		Deps: []*MsgType{TransformStamped}}
+
+	CompressedImage = &MsgType{Name: "sensor_msgs/CompressedImage", Def: `Header header
+string format
+uint8[] data`, Deps: []*MsgType{Header}}
+
+	PointField = &MsgType{Name: "sensor_msgs/PointField", Def: `uint8 INT8    = 1
+uint8 UINT8   = 2
+uint8 INT16   = 3
+uint8 UINT16  = 4
+uint8 INT32   = 5
+uint8 UINT32  = 6
+uint8 FLOAT32 = 7
+uint8 FLOAT64 = 8
+string name
+uint32 offset
+uint8  datatype
+uint32 count`}
+
+	PointCloud2 = &MsgType{Name: "sensor_msgs/PointCloud2", Def: `Header header
+uint32 height
+uint32 width
+PointField[] fields
+bool    is_bigendian
+uint32  point_step
+uint32  row_step
+uint8[] data
+bool is_dense`, Deps: []*MsgType{Header, PointField}}
+
+	NavSatStatus = &MsgType{Name: "sensor_msgs/NavSatStatus", Def: `int8 STATUS_NO_FIX =  -1
+int8 STATUS_FIX =      0
+int8 STATUS_SBAS_FIX = 1
+int8 STATUS_GBAS_FIX = 2
+int8 status
+uint16 SERVICE_GPS =     1
+uint16 SERVICE_GLONASS = 2
+uint16 SERVICE_COMPASS = 4
+uint16 SERVICE_GALILEO = 8
+uint16 service`}
+
+	NavSatFix = &MsgType{Name: "sensor_msgs/NavSatFix", Def: `Header header
+NavSatStatus status
+float64 latitude
+float64 longitude
+float64 altitude
+float64[9] position_covariance
+uint8 COVARIANCE_TYPE_UNKNOWN = 0
+uint8 COVARIANCE_TYPE_APPROXIMATED = 1
+uint8 COVARIANCE_TYPE_DIAGONAL_KNOWN = 2
+uint8 COVARIANCE_TYPE_KNOWN = 3
+uint8 position_covariance_type`, Deps: []*MsgType{Header, NavSatStatus}}
+
+	Imu = &MsgType{Name: "sensor_msgs/Imu", Def: `Header header
+geometry_msgs/Quaternion orientation
+float64[9] orientation_covariance
+geometry_msgs/Vector3 angular_velocity
+float64[9] angular_velocity_covariance
+geometry_msgs/Vector3 linear_acceleration
+float64[9] linear_acceleration_covariance`, Deps: []*MsgType{Header, Quaternion, Vector3}}
+
+	MagneticField = &MsgType{Name: "sensor_msgs/MagneticField", Def: `Header header
+geometry_msgs/Vector3 magnetic_field
+float64[9] magnetic_field_covariance`, Deps: []*MsgType{Header, Vector3}}
+
+	Temperature = &MsgType{Name: "sensor_msgs/Temperature", Def: `Header header
+float64 temperature
+float64 variance`, Deps: []*MsgType{Header}}
+
+	RelativeHumidity = &MsgType{Name: "sensor_msgs/RelativeHumidity", Def: `Header header
+float64 relative_humidity
+float64 variance`, Deps: []*MsgType{Header}}
+
+	FluidPressure = &MsgType{Name: "sensor_msgs/FluidPressure", Def: `Header header
+float64 fluid_pressure
+float64 variance`, Deps: []*MsgType{Header}}
+)
@@ -0,0 +1,223 @@
+// Package rosbag writes ROS 1 bag files (format version 2.0) with the
+// standard message types, without a ROS installation.
+package rosbag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+const magic = "#ROSBAG V2.0\n"
+
+// Record opcodes.
+const (
+	opMessageData = 0x02
+	opBagHeader   = 0x03
+	opIndexData   = 0x04
+	opChunk       = 0x05
+	opChunkInfo   = 0x06
+	opConnection  = 0x07
+)
+
+const (
+	// bagHeaderSize is the padded size of the bag header record, which is
+	// rewritten in place once the index position is known.
+	bagHeaderSize = 4096
+	// chunkSize is the uncompressed size at which a chunk is closed.
+	chunkSize = 768 << 10
+)
+
+type connection struct {
+	id      uint32
+	topic   string
+	typ     *MsgType
+	latched bool
+	header  []byte // connection record header, written in the index too
+	data    []byte // connection header of the record data
+}
+
+type indexEntry struct {
+	ts     time.Time
+	offset uint32
+}
+
+type chunkInfo struct {
+	pos        uint64
+	start, end time.Time
+	counts     map[uint32]uint32
+}
+
+// Writer writes one bag file. Messages are stored uncompressed in chunks,
+// each followed by its index, and the connections and chunk infos are
+// written on Close.
+type Writer struct {
+	f   *os.File
+	w   *bufio.Writer
+	pos uint64
+
+	conns []*connection
+	infos []chunkInfo
+
+	chunk      bytes.Buffer
+	chunkIndex map[uint32][]indexEntry
+	chunkConns map[uint32]bool // connections whose record is in the chunk
+	start, end time.Time
+}
+
+// Create creates the bag at path.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, w: bufio.NewWriterSize(f, 1<<20), chunkIndex: map[uint32][]indexEntry{}, chunkConns: map[uint32]bool{}}
+	w.write([]byte(magic))
+	w.write(bagHeader(0, 0, 0))
+	return w, nil
+}
+
+// Connection adds a topic carrying messages of typ and returns its ID.
+// Latched topics, such as static transforms, are delivered to late
+// subscribers on playback.
+func (w *Writer) Connection(topic string, typ *MsgType, latched bool) uint32 {
+	c := &connection{id: uint32(len(w.conns)), topic: topic, typ: typ, latched: latched}
+	c.header = header("op", []byte{opConnection}, "conn", u32(c.id), "topic", []byte(topic))
+	fields := []any{"topic", []byte(topic), "type", []byte(typ.Name), "md5sum", []byte(typ.MD5()),
+		"message_definition", []byte(typ.FullText()), "callerid", []byte("/sensor_logger")}
+	if latched {
+		fields = append(fields, "latching", []byte("1"))
+	}
+	c.data = header(fields...)
+	w.conns = append(w.conns, c)
+	return c.id
+}
+
+// Write appends msg, a serialized message of connection conn received at
+// ts.
+func (w *Writer) Write(conn uint32, ts time.Time, msg []byte) error {
+	if int(conn) >= len(w.conns) {
+		return fmt.Errorf("rosbag: unknown connection %d", conn)
+	}
+	if !w.chunkConns[conn] {
+		c := w.conns[conn]
+		w.chunk.Write(record(c.header, c.data))
+		w.chunkConns[conn] = true
+	}
+	sec, nsec := rosTime(ts)
+	w.chunkIndex[conn] = append(w.chunkIndex[conn], indexEntry{ts, uint32(w.chunk.Len())})
+	w.chunk.Write(record(header("op", []byte{opMessageData}, "conn", u32(conn), "time", u64(uint64(nsec)<<32|uint64(sec))), msg))
+	if w.start.IsZero() || ts.Before(w.start) {
+		w.start = ts
+	}
+	if ts.After(w.end) {
+		w.end = ts
+	}
+	if w.chunk.Len() >= chunkSize {
+		return w.flushChunk()
+	}
+	return nil
+}
+
+// flushChunk writes the open chunk and its index records.
+func (w *Writer) flushChunk() error {
+	if w.chunk.Len() == 0 {
+		return nil
+	}
+	info := chunkInfo{pos: w.pos, start: w.start, end: w.end, counts: map[uint32]uint32{}}
+	w.write(record(header("op", []byte{opChunk}, "compression", []byte("none"), "size", u32(uint32(w.chunk.Len()))), w.chunk.Bytes()))
+	for _, c := range w.conns {
+		entries := w.chunkIndex[c.id]
+		if len(entries) == 0 {
+			continue
+		}
+		data := make([]byte, 0, 12*len(entries))
+		for _, e := range entries {
+			sec, nsec := rosTime(e.ts)
+			data = binary.LittleEndian.AppendUint32(data, sec)
+			data = binary.LittleEndian.AppendUint32(data, nsec)
+			data = binary.LittleEndian.AppendUint32(data, e.offset)
+		}
+		w.write(record(header("op", []byte{opIndexData}, "ver", u32(1), "conn", u32(c.id), "count", u32(uint32(len(entries)))), data))
+		info.counts[c.id] = uint32(len(entries))
+	}
+	w.infos = append(w.infos, info)
+	w.chunk.Reset()
+	w.chunkIndex, w.chunkConns = map[uint32][]indexEntry{}, map[uint32]bool{}
+	w.start, w.end = time.Time{}, time.Time{}
+	return w.w.Flush()
+}
+
+// Close writes the last chunk and the index and closes the file.
+func (w *Writer) Close() error {
+	err := w.flushChunk()
+	indexPos := w.pos
+	for _, c := range w.conns {
+		w.write(record(c.header, c.data))
+	}
+	for _, info := range w.infos {
+		var data []byte
+		for _, c := range w.conns {
+			if n, ok := info.counts[c.id]; ok {
+				data = binary.LittleEndian.AppendUint32(data, c.id)
+				data = binary.LittleEndian.AppendUint32(data, n)
+			}
+		}
+		ssec, snsec := rosTime(info.start)
+		esec, ensec := rosTime(info.end)
+		w.write(record(header("op", []byte{opChunkInfo}, "ver", u32(1), "chunk_pos", u64(info.pos),
+			"start_time", u64(uint64(snsec)<<32|uint64(ssec)), "end_time", u64(uint64(ensec)<<32|uint64(esec)),
+			"count", u32(uint32(len(info.counts)))), data))
+	}
+	if ferr := w.w.Flush(); err == nil {
+		err = ferr
+	}
+	if err == nil {
+		_, err = w.f.WriteAt(bagHeader(indexPos, len(w.conns), len(w.infos)), int64(len(magic)))
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *Writer) write(p []byte) {
+	w.w.Write(p)
+	w.pos += uint64(len(p))
+}
+
+// bagHeader returns the bag header record padded with spaces to
+// bagHeaderSize.
+func bagHeader(indexPos uint64, conns, chunks int) []byte {
+	h := header("op", []byte{opBagHeader}, "index_pos", u64(indexPos), "conn_count", u32(uint32(conns)), "chunk_count", u32(uint32(chunks)))
+	return record(h, bytes.Repeat([]byte(" "), bagHeaderSize-8-len(h)))
+}
+
+// header encodes alternating field names and values as a record header.
+func header(fields ...any) []byte {
+	var b []byte
+	for i := 0; i < len(fields); i += 2 {
+		name, value := fields[i].(string), fields[i+1].([]byte)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(name)+1+len(value)))
+		b = append(b, name...)
+		b = append(b, '=')
+		b = append(b, value...)
+	}
+	return b
+}
+
+// record frames a record: header and data, each prefixed with its length.
+func record(header, data []byte) []byte {
+	b := make([]byte, 0, 8+len(header)+len(data))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(header)))
+	b = append(b, header...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func u32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+
+func u64(v uint64) []byte { return binary.LittleEndian.AppendUint64(nil, v) }
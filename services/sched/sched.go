@@ -0,0 +1,58 @@
+// Package sched tunes the OS thread a reader runs on, so that a camera
+// reader on a busy SoC is not starved by encoding: CPU affinity, niceness
+// and SCHED_FIFO priority.
+package sched
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// schedFIFO is the Linux SCHED_FIFO policy, which package syscall does not
+// export.
+const schedFIFO = 1
+
+// Apply locks the calling goroutine to its OS thread and applies cfg to
+// that thread. The goroutine must not unlock it again: when the goroutine
+// exits, the runtime then discards the tuned thread instead of handing it
+// to other goroutines. A setting that fails is reported in the error; the
+// others are applied regardless.
+func Apply(cfg utils.SchedulingConfig) error {
+	runtime.LockOSThread()
+	var errs []error
+	if len(cfg.CPUs) > 0 {
+		if err := setAffinity(cfg.CPUs); err != nil {
+			errs = append(errs, fmt.Errorf("cpus %v: %w", cfg.CPUs, err))
+		}
+	}
+	if cfg.Nice != nil {
+		// Linux applies PRIO_PROCESS with who 0 to the calling thread only.
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *cfg.Nice); err != nil {
+			errs = append(errs, fmt.Errorf("nice %d: %w", *cfg.Nice, err))
+		}
+	}
+	if cfg.FIFOPriority > 0 {
+		param := struct{ priority int32 }{int32(cfg.FIFOPriority)}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, schedFIFO, uintptr(unsafe.Pointer(&param))); errno != 0 {
+			errs = append(errs, fmt.Errorf("fifo_priority %d: %w", cfg.FIFOPriority, errno))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setAffinity(cpus []int) error {
+	var mask [utils.MaxCPUs / 64]uint64
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+// Package sqlite writes SQLite 3 database files of append-only tables
+// without linking SQLite: rows are packed into table b-tree leaf pages as
+// they arrive, and the interior pages and the schema are written on Close.
+package sqlite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	pageSize = 4096
+	// headerSize is the database header at the start of page 1.
+	headerSize = 100
+
+	pageInterior = 0x05
+	pageLeaf     = 0x0d
+
+	// fanout bounds the children of an interior page: 12 header bytes and
+	// at most 15 bytes per cell (pointer, 9-byte key, cell offset).
+	fanout = (pageSize - 12) / 15
+
+	// lockPage holds the lock bytes SQLite takes at 1 GiB into the file.
+	// It is written zeroed and never used.
+	lockPage = 0x40000000/pageSize + 1
+)
+
+// child is a page of a b-tree and the largest rowid under it.
+type child struct {
+	page uint32
+	key  int64
+}
+
+// leaf collects the cells of the leaf page being filled.
+type leaf struct {
+	cells [][]byte
+	size  int
+	key   int64
+}
+
+func (l *leaf) fits(cell []byte, off int) bool {
+	return off+8+2*(len(l.cells)+1)+l.size+len(cell) <= pageSize
+}
+
+func (l *leaf) add(cell []byte, key int64) {
+	l.cells = append(l.cells, cell)
+	l.size += len(cell)
+	l.key = key
+}
+
+type table struct {
+	name    string
+	columns int
+	sql     string
+	rowid   int64
+	leaf    leaf
+	leaves  []child
+}
+
+// Writer writes one database file. It is not safe for concurrent use.
+type Writer struct {
+	f     *os.File
+	w     *bufio.Writer
+	pages uint32
+	err   error
+
+	tables []*table
+}
+
+// Create creates the database at path. Page 1, holding the header and
+// the schema, is reserved until Close.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, w: bufio.NewWriterSize(f, 256<<10)}
+	w.page(make([]byte, pageSize))
+	return w, w.err
+}
+
+// Table adds a table of untyped columns and returns its index for Insert.
+func (w *Writer) Table(name string, columns []string) int {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = quote(c)
+	}
+	sql := "CREATE TABLE " + quote(name) + " (" + strings.Join(quoted, ", ") + ")"
+	w.tables = append(w.tables, &table{name: name, columns: len(columns), sql: sql})
+	return len(w.tables) - 1
+}
+
+// Insert appends a row to table t. Values are nil, int64, float64 or
+// string, one per column.
+func (w *Writer) Insert(t int, values []any) error {
+	if w.err != nil {
+		return w.err
+	}
+	tb := w.tables[t]
+	if len(values) != tb.columns {
+		return fmt.Errorf("sqlite: %s: row of %d values for %d columns", tb.name, len(values), tb.columns)
+	}
+	rec, err := record(values)
+	if err != nil {
+		return fmt.Errorf("sqlite: %s: %w", tb.name, err)
+	}
+	tb.rowid++
+	cell := w.cell(tb.rowid, rec)
+	if !tb.leaf.fits(cell, 0) {
+		tb.leaves = append(tb.leaves, w.leafPage(&tb.leaf))
+	}
+	tb.leaf.add(cell, tb.rowid)
+	return w.err
+}
+
+// Flush writes the completed pages to the file.
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// Close writes the pending leaf and the interior pages of every table,
+// then the schema and header on page 1, and closes the file.
+func (w *Writer) Close() error {
+	var schema leaf
+	var schemaLeaves []child
+	for i, tb := range w.tables {
+		if len(tb.leaf.cells) > 0 || len(tb.leaves) == 0 {
+			tb.leaves = append(tb.leaves, w.leafPage(&tb.leaf))
+		}
+		root := w.interior(tb.leaves)
+		rec, _ := record([]any{"table", tb.name, tb.name, int64(root), tb.sql})
+		cell := w.cell(int64(i+1), rec)
+		if !schema.fits(cell, 0) {
+			schemaLeaves = append(schemaLeaves, w.leafPage(&schema))
+		}
+		schema.add(cell, int64(i+1))
+	}
+	var page1 []byte
+	if len(schemaLeaves) == 0 && schema.fits(nil, headerSize) {
+		page1 = build(pageLeaf, headerSize, schema.cells, 0)
+	} else {
+		if len(schema.cells) > 0 {
+			schemaLeaves = append(schemaLeaves, w.leafPage(&schema))
+		}
+		page1 = w.root(schemaLeaves)
+	}
+	err := w.Flush()
+	if err == nil {
+		copy(page1, w.header())
+		_, err = w.f.WriteAt(page1, 0)
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// interior writes the interior pages above children and returns the root
+// page; a single child is its own root.
+func (w *Writer) interior(children []child) uint32 {
+	for len(children) > 1 {
+		children = w.level(children)
+	}
+	return children[0].page
+}
+
+// level writes a level of interior pages over children.
+func (w *Writer) level(children []child) []child {
+	var parents []child
+	for len(children) > 0 {
+		n := min(len(children), fanout)
+		parents = append(parents, w.interiorPage(children[:n]))
+		children = children[n:]
+	}
+	return parents
+}
+
+// root builds the interior root of the schema on page 1 over leaves.
+func (w *Writer) root(leaves []child) []byte {
+	for len(leaves) > fanout {
+		leaves = w.level(leaves)
+	}
+	return build(pageInterior, headerSize, interiorCells(leaves), leaves[len(leaves)-1].page)
+}
+
+func (w *Writer) interiorPage(children []child) child {
+	last := children[len(children)-1]
+	return child{w.page(build(pageInterior, 0, interiorCells(children), last.page)), last.key}
+}
+
+// interiorCells returns a cell per child but the last, which is the
+// right-most pointer.
+func interiorCells(children []child) [][]byte {
+	cells := make([][]byte, 0, len(children)-1)
+	for _, c := range children[:len(children)-1] {
+		cell := binary.BigEndian.AppendUint32(nil, c.page)
+		cells = append(cells, putVarint(cell, uint64(c.key)))
+	}
+	return cells
+}
+
+// leafPage writes l as a leaf page and empties it.
+func (w *Writer) leafPage(l *leaf) child {
+	c := child{w.page(build(pageLeaf, 0, l.cells, 0)), l.key}
+	*l = leaf{}
+	return c
+}
+
+// cell returns the table leaf cell of a row, writing the overflow pages
+// of a payload too large for a page.
+func (w *Writer) cell(rowid int64, payload []byte) []byte {
+	c := putVarint(nil, uint64(len(payload)))
+	c = putVarint(c, uint64(rowid))
+	local := localPayload(len(payload))
+	c = append(c, payload[:local]...)
+	if local == len(payload) {
+		return c
+	}
+	rest := payload[local:]
+	c = binary.BigEndian.AppendUint32(c, next(w.pages))
+	for len(rest) > 0 {
+		n := min(len(rest), pageSize-4)
+		p := make([]byte, pageSize)
+		if n < len(rest) {
+			binary.BigEndian.PutUint32(p, next(next(w.pages)))
+		}
+		copy(p[4:], rest[:n])
+		w.page(p)
+		rest = rest[n:]
+	}
+	return c
+}
+
+// localPayload returns how much of a payload of n bytes is stored in the
+// leaf cell, the rest going to overflow pages.
+func localPayload(n int) int {
+	const u = pageSize
+	x := u - 35
+	if n <= x {
+		return n
+	}
+	m := (u-12)*32/255 - 23
+	if k := m + (n-m)%(u-4); k <= x {
+		return k
+	}
+	return m
+}
+
+// page appends a page to the file and returns its number.
+func (w *Writer) page(p []byte) uint32 {
+	if next(w.pages) != w.pages+1 {
+		w.write(make([]byte, pageSize))
+	}
+	w.pages = next(w.pages)
+	w.write(p)
+	return w.pages
+}
+
+func (w *Writer) write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
+
+// next returns the number of the page page appends after page n.
+func next(n uint32) uint32 {
+	if n+1 == lockPage {
+		return n + 2
+	}
+	return n + 1
+}
+
+// build lays out a b-tree page whose header starts at off: the cell
+// pointers after the header, the cells packed at the end of the page.
+func build(typ byte, off int, cells [][]byte, right uint32) []byte {
+	p := make([]byte, pageSize)
+	h := p[off:]
+	h[0] = typ
+	hsz := 8
+	if typ == pageInterior {
+		hsz = 12
+		binary.BigEndian.PutUint32(h[8:], right)
+	}
+	binary.BigEndian.PutUint16(h[3:], uint16(len(cells)))
+	top := pageSize
+	for i, c := range cells {
+		top -= len(c)
+		copy(p[top:], c)
+		binary.BigEndian.PutUint16(h[hsz+2*i:], uint16(top))
+	}
+	binary.BigEndian.PutUint16(h[5:], uint16(top))
+	return p
+}
+
+// header returns the database header.
+func (w *Writer) header() []byte {
+	h := make([]byte, headerSize)
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	h[18], h[19] = 1, 1 // rollback journal
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1) // change counter
+	binary.BigEndian.PutUint32(h[28:], w.pages)
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1) // valid for change 1
+	binary.BigEndian.PutUint32(h[96:], 3045000)
+	return h
+}
+
+// record encodes values in the record format: a header of serial types,
+// then the values.
+func record(values []any) ([]byte, error) {
+	var hdr, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			hdr = putVarint(hdr, 0)
+		case int64:
+			switch {
+			case v == 0:
+				hdr = putVarint(hdr, 8)
+			case v == 1:
+				hdr = putVarint(hdr, 9)
+			default:
+				typ, n := intType(v)
+				hdr = putVarint(hdr, typ)
+				for i := n - 1; i >= 0; i-- {
+					body = append(body, byte(v>>(8*i)))
+				}
+			}
+		case float64:
+			hdr = putVarint(hdr, 7)
+			body = binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			hdr = putVarint(hdr, uint64(13+2*len(v)))
+			body = append(body, v...)
+		default:
+			return nil, fmt.Errorf("unsupported value %T", v)
+		}
+	}
+	n := len(hdr) + 1
+	if len(putVarint(nil, uint64(n))) > 1 {
+		n = len(hdr) + len(putVarint(nil, uint64(len(hdr)+2)))
+	}
+	rec := putVarint(nil, uint64(n))
+	return append(append(rec, hdr...), body...), nil
+}
+
+// intType returns the serial type and size of the smallest integer
+// encoding holding v.
+func intType(v int64) (uint64, int) {
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1, 1
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2, 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3, 3
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4, 4
+	case v >= -1<<47 && v < 1<<47:
+		return 5, 6
+	}
+	return 6, 8
+}
+
+// putVarint appends v as a SQLite varint: big-endian groups of 7 bits,
+// the ninth byte holding 8.
+func putVarint(b []byte, v uint64) []byte {
+	if v > 1<<56-1 {
+		var buf [9]byte
+		buf[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			buf[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(b, buf[:]...)
+	}
+	var groups [8]byte
+	n := 0
+	for {
+		groups[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i > 0; i-- {
+		b = append(b, groups[i]|0x80)
+	}
+	return append(b, groups[0])
+}
+
+// quote quotes an SQL identifier.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
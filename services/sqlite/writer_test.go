@@ -0,0 +1,51 @@
+package sqlite
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestWriterPastLockPage writes a database larger than 1 GiB, whose
+// overflow chains and leaves run across the lock-byte page, and checks it
+// with the sqlite3 shell.
+func TestWriterPastLockPage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes more than 1 GiB")
+	}
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("no sqlite3 shell")
+	}
+	path := filepath.Join(t.TempDir(), "big.sqlite")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb := w.Table("blobs", []string{"n", "data"})
+	const rows = 1100
+	data := strings.Repeat("x", 1<<20)
+	for i := range rows {
+		if err := w.Insert(tb, []any{int64(i), data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.pages < lockPage {
+		t.Fatalf("%d pages, the lock-byte page is %d", w.pages, lockPage)
+	}
+
+	out, err := exec.Command(sqlite3, path,
+		"PRAGMA integrity_check",
+		"SELECT count(*), sum(length(data) = 1048576) FROM blobs",
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "ok\n1100|1100"; got != want {
+		t.Errorf("sqlite3 reports\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+// Package sysstat samples the resource use of the logger and of the host
+// from /proc and /sys. Values a host does not expose, such as the
+// temperature of a virtual machine, are left out of the samples.
+package sysstat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Collector takes samples, each covering the time since the previous
+// one. It is not safe for concurrent use.
+type Collector struct {
+	last      time.Time
+	cpu       time.Duration
+	hostBusy  uint64
+	hostTotal uint64
+}
+
+// NewCollector returns a collector whose first sample covers the time
+// since it was created.
+func NewCollector() *Collector {
+	c := &Collector{last: time.Now(), cpu: processCPU()}
+	c.hostBusy, c.hostTotal = hostCPU()
+	return c
+}
+
+// Sample returns the current resource use; dir is the session directory,
+// whose block device the disk queue is read from.
+func (c *Collector) Sample(dir string) models.SystemStats {
+	now := time.Now()
+	s := models.SystemStats{
+		Timestamp:  utils.Now(),
+		RSSBytes:   rss(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+	}
+	cpu := processCPU()
+	if elapsed := now.Sub(c.last); elapsed > 0 {
+		s.CPUPct = 100 * float64(cpu-c.cpu) / float64(elapsed)
+	}
+	busy, total := hostCPU()
+	if total > c.hostTotal {
+		s.HostCPUPct = 100 * float64(busy-c.hostBusy) / float64(total-c.hostTotal)
+	}
+	c.last, c.cpu, c.hostBusy, c.hostTotal = now, cpu, busy, total
+	if n, ok := diskInflight(dir); ok {
+		s.DiskInflight = &n
+	}
+	if t, ok := Temperature(); ok {
+		s.TemperatureC = &t
+	}
+	if f, ok := readNumber("/sys/devices/system/cpu/cpu0/cpufreq/scaling_cur_freq"); ok {
+		mhz := f / 1000
+		s.CPUFreqMHz = &mhz
+	}
+	return s
+}
+
+// processCPU returns the user and system CPU time used by the process.
+func processCPU() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// hostCPU returns the busy and total jiffies of all CPUs from /proc/stat;
+// idle and iowait count as not busy.
+func hostCPU() (busy, total uint64) {
+	b, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0
+	}
+	line, _, _ := strings.Cut(string(b), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0
+	}
+	var idle uint64
+	// guest and guest_nice are included in user and nice already.
+	for i, f := range fields[1:min(len(fields), 9)] {
+		v, _ := strconv.ParseUint(f, 10, 64)
+		total += v
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return total - idle, total
+}
+
+// rss returns the resident set size of the process from /proc/self/statm.
+func rss() int64 {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, _ := strconv.ParseInt(fields[1], 10, 64)
+	return pages * int64(os.Getpagesize())
+}
+
+// diskInflight returns the read and write requests in flight on the block
+// device holding dir, false when it is not on a block device.
+func diskInflight(dir string) (int, bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return 0, false
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	b, err := os.ReadFile(fmt.Sprintf("/sys/dev/block/%d:%d/inflight", major, minor))
+	if err != nil {
+		return 0, false
+	}
+	n := 0
+	for _, f := range strings.Fields(string(b)) {
+		v, _ := strconv.Atoi(f)
+		n += v
+	}
+	return n, true
+}
+
+// Temperature returns the temperature of the hottest thermal zone in
+// degrees Celsius, false when the host exposes none.
+func Temperature() (float64, bool) {
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+	hottest, ok := 0.0, false
+	for _, z := range zones {
+		if v, found := readNumber(z); found && (!ok || v/1000 > hottest) {
+			hottest, ok = v/1000, true
+		}
+	}
+	return hottest, ok
+}
+
+// readNumber reads a file holding a single number, as in sysfs.
+func readNumber(path string) (float64, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	return v, err == nil
+}
@@ -0,0 +1,121 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spool is a first-in first-out queue of encoded messages kept in a file,
+// one per line, so that the messages not yet delivered survive a restart.
+// Pushes append to the file; taking messages off rewrites it.
+type spool struct {
+	path string
+	max  int
+
+	mu      sync.Mutex
+	lines   [][]byte
+	size    int
+	dropped int64
+}
+
+// openSpool loads the messages left in the file at path, keeping the
+// newest max bytes of them.
+func openSpool(path string, max int) (*spool, error) {
+	s := &spool{path: path, max: max}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, max+1)
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			s.lines = append(s.lines, bytes.Clone(sc.Bytes()))
+			s.size += len(sc.Bytes()) + 1
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if s.trim() {
+		return s, s.rewrite()
+	}
+	return s, nil
+}
+
+// push queues line, dropping the oldest messages to stay within max.
+func (s *spool) push(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, line)
+	s.size += len(line) + 1
+	if s.trim() {
+		return s.rewrite()
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// peek returns up to n of the oldest messages.
+func (s *spool) peek(n int) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lines[:min(n, len(s.lines)):min(n, len(s.lines))]
+}
+
+// pop removes the n oldest messages, once delivered.
+func (s *spool) pop(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n = min(n, len(s.lines))
+	for _, l := range s.lines[:n] {
+		s.size -= len(l) + 1
+	}
+	s.lines = s.lines[n:]
+	return s.rewrite()
+}
+
+// len returns the number of messages waiting and the number dropped.
+func (s *spool) len() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.lines), s.dropped
+}
+
+// trim drops the oldest messages over max and reports whether it did.
+func (s *spool) trim() bool {
+	n := 0
+	for s.size > s.max && n < len(s.lines) {
+		s.size -= len(s.lines[n]) + 1
+		n++
+	}
+	s.lines = s.lines[n:]
+	s.dropped += int64(n)
+	return n > 0
+}
+
+// rewrite replaces the file with the messages left.
+func (s *spool) rewrite() error {
+	tmp := s.path + ".tmp"
+	var buf bytes.Buffer
+	for _, l := range s.lines {
+		buf.Write(l)
+		buf.WriteByte('\n')
+	}
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,287 @@
+// Package telemetry sends the status of a logger and its GPS position to
+// a fleet server while it records, storing the messages on disk while the
+// link is down and forwarding them once it is back.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/mqtt"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	sendTimeout = 15 * time.Second
+	maxBackoff  = 5 * time.Minute
+	// flushTimeout bounds the last attempt to deliver as the logger stops.
+	flushTimeout = 5 * time.Second
+)
+
+// Message kinds.
+const (
+	KindStatus   = "status"
+	KindPosition = "position"
+)
+
+// Message is one report of a logger, encoded as JSON.
+type Message struct {
+	Kind     string    `json:"kind"`
+	Vehicle  string    `json:"vehicle"`
+	Session  string    `json:"session"`
+	Time     time.Time `json:"time"`
+	Status   *Status   `json:"status,omitempty"`
+	Position *Position `json:"position,omitempty"`
+}
+
+// Status summarises the health of a logger since the previous status.
+type Status struct {
+	UptimeS   float64 `json:"uptime_s"`
+	Recording bool    `json:"recording"`
+	// Healthy is false once a write failed or while errors were reported.
+	Healthy       bool                    `json:"healthy"`
+	DiskFreeBytes int64                   `json:"disk_free_bytes"`
+	Sensors       map[string]SensorStatus `json:"sensors"`
+	// Events are the warnings and errors published since the previous
+	// status, the last MaxEvents of them.
+	Events []models.Event `json:"events,omitempty"`
+	// Spooled and Dropped count the messages waiting to be delivered and
+	// those dropped with the spool full.
+	Spooled int   `json:"spooled"`
+	Dropped int64 `json:"dropped"`
+}
+
+// SensorStatus is the state of one reader over the last status interval.
+type SensorStatus struct {
+	RateHz   float64 `json:"rate_hz"`
+	Dropped  uint64  `json:"dropped"`
+	Restarts uint64  `json:"restarts"`
+}
+
+// Position is a GPS breadcrumb.
+type Position struct {
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	Alt        float64 `json:"alt"`
+	SpeedMps   float64 `json:"speed_mps"`
+	HeadingDeg float64 `json:"heading_deg"`
+	FixQuality int     `json:"fix_quality"`
+	Satellites int     `json:"satellites"`
+}
+
+// MaxEvents bounds the events of a status.
+const MaxEvents = 10
+
+// sender delivers a batch of encoded messages and returns how many of the
+// first it delivered.
+type sender interface {
+	send(ctx context.Context, batch [][]byte) (int, error)
+	close()
+}
+
+// Uplink queues messages in its spool and delivers them in order.
+type Uplink struct {
+	cfg    utils.TelemetryConfig
+	log    utils.Logger
+	spool  *spool
+	sender sender
+	wake   chan struct{}
+	sent   int64
+}
+
+func NewUplink(cfg utils.TelemetryConfig, log utils.Logger) (*Uplink, error) {
+	sp, err := openSpool(cfg.Spool, cfg.SpoolKB<<10)
+	if err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	u := &Uplink{cfg: cfg, log: log, spool: sp, wake: make(chan struct{}, 1)}
+	switch cfg.Transport {
+	case utils.TelemetryMQTT:
+		u.sender = &mqttSender{cfg: cfg.MQTT}
+	default:
+		token, err := utils.ReadSecret(cfg.Token, cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("token: %w", err)
+		}
+		tc, err := auth.ClientTLS(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		u.sender = &httpSender{url: cfg.URL, token: token, log: log, client: &http.Client{
+			Timeout:   sendTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tc},
+		}}
+	}
+	if n, _ := sp.len(); n > 0 {
+		log.Infof("telemetry: %d messages left from an earlier run", n)
+	}
+	return u, nil
+}
+
+// Queue adds m to the spool.
+func (u *Uplink) Queue(m Message) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		u.log.Errorf("telemetry: encode: %v", err)
+		return
+	}
+	if err := u.spool.push(b); err != nil {
+		u.log.Warnf("telemetry: spool: %v", err)
+	}
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Backlog returns the number of messages waiting and the number dropped
+// with the spool full.
+func (u *Uplink) Backlog() (int, int64) {
+	return u.spool.len()
+}
+
+// Run delivers the queued messages until ctx is cancelled, backing off
+// while the server is unreachable, then makes one last attempt.
+func (u *Uplink) Run(ctx context.Context) {
+	retry := time.Duration(u.cfg.RetryS) * time.Second
+	backoff := retry
+	for {
+		select {
+		case <-ctx.Done():
+			last, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			u.deliver(last)
+			cancel()
+			u.sender.close()
+			n, _ := u.spool.len()
+			u.log.Infof("telemetry: delivered %d messages, %d left in %s", u.sent, n, u.cfg.Spool)
+			return
+		case <-u.wake:
+		}
+		if err := u.deliver(ctx); err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			u.log.Warnf("telemetry: %v (retrying in %v)", err, backoff)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxBackoff)
+			select {
+			case u.wake <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		backoff = retry
+	}
+}
+
+// deliver sends the spool batch by batch until it is empty or a batch
+// fails.
+func (u *Uplink) deliver(ctx context.Context) error {
+	for {
+		batch := u.spool.peek(u.cfg.Batch)
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := u.sender.send(ctx, batch)
+		if n > 0 {
+			u.sent += int64(n)
+			if err := u.spool.pop(n); err != nil {
+				u.log.Warnf("telemetry: spool: %v", err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// httpSender POSTs a batch as a JSON array. A client error other than a
+// timeout or rate limit rejects the batch for good; it is counted as
+// delivered so that it does not hold up the messages after it.
+type httpSender struct {
+	url    string
+	token  string
+	log    utils.Logger
+	client *http.Client
+}
+
+func (h *httpSender) send(ctx context.Context, batch [][]byte) (int, error) {
+	body := append([]byte{'['}, bytes.Join(batch, []byte{','})...)
+	body = append(body, ']')
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return len(batch), nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		h.log.Warnf("telemetry: %s rejected %d messages: %s", h.url, len(batch), resp.Status)
+		return len(batch), nil
+	}
+	return 0, fmt.Errorf("%s: %s", h.url, resp.Status)
+}
+
+func (h *httpSender) close() {
+	h.client.CloseIdleConnections()
+}
+
+// mqttSender publishes each message at QoS 0, reconnecting after a
+// failure.
+type mqttSender struct {
+	cfg    utils.MQTTConfig
+	client *mqtt.Client
+}
+
+func (m *mqttSender) send(_ context.Context, batch [][]byte) (int, error) {
+	if m.client != nil && m.client.Err() != nil {
+		m.close()
+	}
+	if m.client == nil {
+		c, err := mqtt.Dial(mqtt.Options{
+			Broker:    m.cfg.Broker,
+			ClientID:  m.cfg.ClientID,
+			Username:  m.cfg.Username,
+			Password:  m.cfg.Password,
+			KeepAlive: time.Duration(m.cfg.KeepAliveS) * time.Second,
+		})
+		if err != nil {
+			return 0, err
+		}
+		m.client = c
+	}
+	for i, b := range batch {
+		if err := m.client.Publish(m.cfg.Topic, b, m.cfg.Retain); err != nil {
+			m.close()
+			return i, err
+		}
+	}
+	return len(batch), nil
+}
+
+func (m *mqttSender) close() {
+	if m.client != nil {
+		m.client.Close()
+		m.client = nil
+	}
+}
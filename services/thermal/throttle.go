@@ -0,0 +1,127 @@
+// Package thermal slows simulated sensors down while the host runs hot.
+package thermal
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/sysstat"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// logStep is the change of level that is worth a log line.
+const logStep = 0.1
+
+// Throttle sets the share of their ticks the simulated devices keep from
+// the temperature of the host, as configured by utils.SimThrottleConfig.
+// It is safe for concurrent use.
+type Throttle struct {
+	cfg utils.SimThrottleConfig
+	log utils.Logger
+
+	level atomic.Uint64 // float64 bits, 1 keeps every tick
+
+	mu     sync.Mutex
+	logged float64
+	stats  Stats
+}
+
+// Stats summarises the throttling of a session.
+type Stats struct {
+	MaxTempC   float64 `json:"max_temp_c"`
+	MinRatePct float64 `json:"min_rate_pct"`
+	ThrottledS float64 `json:"throttled_s"`
+}
+
+func NewThrottle(cfg utils.SimThrottleConfig, log utils.Logger) *Throttle {
+	t := &Throttle{cfg: cfg, log: log, logged: 1, stats: Stats{MinRatePct: 100}}
+	t.level.Store(math.Float64bits(1))
+	return t
+}
+
+// Run reads the temperature every interval until ctx is cancelled. On a
+// host without a thermal zone it logs so and returns, throttling nothing.
+func (t *Throttle) Run(ctx context.Context) {
+	interval := time.Duration(t.cfg.IntervalS * float64(time.Second))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		temp, ok := sysstat.Temperature()
+		if !ok {
+			t.log.Warnf("thermal: the host reports no temperature; simulated sensors are not throttled")
+			return
+		}
+		t.update(temp, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update sets the level for temp, counting interval as throttled when
+// the level is below 1.
+func (t *Throttle) update(temp float64, interval time.Duration) {
+	level := 1.0
+	floor := t.cfg.MinRatePct / 100
+	switch {
+	case temp >= t.cfg.LimitC:
+		level = floor
+	case temp > t.cfg.StartC:
+		level = 1 - (1-floor)*(temp-t.cfg.StartC)/(t.cfg.LimitC-t.cfg.StartC)
+	}
+	t.level.Store(math.Float64bits(level))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.MaxTempC = max(t.stats.MaxTempC, temp)
+	t.stats.MinRatePct = min(t.stats.MinRatePct, 100*level)
+	if level < 1 {
+		t.stats.ThrottledS += interval.Seconds()
+	}
+	switch {
+	case level == 1 && t.logged < 1:
+		t.log.Infof("thermal: %.1f °C, simulated sensors back at full rate", temp)
+	case level < 1 && math.Abs(level-t.logged) >= logStep:
+		t.log.Warnf("thermal: %.1f °C, simulated sensors at %.0f%% of their rates", temp, 100*level)
+	default:
+		return
+	}
+	t.logged = level
+}
+
+// Level returns the share of ticks kept, between min_rate_pct/100 and 1.
+func (t *Throttle) Level() float64 {
+	return math.Float64frombits(t.level.Load())
+}
+
+// Stats returns the throttling so far.
+func (t *Throttle) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// Gate returns a gate for one device; every device needs its own.
+func (t *Throttle) Gate() *Gate { return &Gate{t: t} }
+
+// Gate spreads the ticks a device keeps evenly over the ones it skips. It
+// is not safe for concurrent use.
+type Gate struct {
+	t      *Throttle
+	credit float64
+}
+
+// Keep reports whether the device should produce a sample on this tick.
+func (g *Gate) Keep() bool {
+	g.credit += g.t.Level()
+	if g.credit < 1 {
+		return false
+	}
+	g.credit--
+	return true
+}
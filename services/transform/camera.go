@@ -0,0 +1,54 @@
+package transform
+
+import "github.com/lkumar3-iitr/Sensor-Logger/utils"
+
+// Camera projects points of the vehicle frame onto the image of the
+// calibrated camera, through its lens distortion.
+type Camera struct {
+	k         utils.CameraIntrinsics
+	toOptical utils.Mat4
+}
+
+// NewCamera returns the projection of the camera of cal, whose intrinsics
+// must be set.
+func NewCamera(cal utils.CalibrationConfig) *Camera {
+	return &Camera{
+		k:         cal.Camera,
+		toOptical: cal.SensorToVehicle("camera").Mul(utils.OpticalToBody).InverseRigid(),
+	}
+}
+
+// Project returns the pixel of p, a point of the vehicle frame, in an
+// image of w×h pixels (scaled from the calibrated size), and its depth
+// along the optical axis in metres. ok is false for points behind the
+// camera or outside the image.
+func (c *Camera) Project(p Point, w, h int) (u, v, depth float64, ok bool) {
+	x, y, z := c.toOptical.Apply(p.X, p.Y, p.Z)
+	if z <= 0.1 {
+		return 0, 0, 0, false
+	}
+	x, y = c.distort(x/z, y/z)
+	u = (c.k.Fx*x + c.k.Cx) * float64(w) / float64(c.k.Width)
+	v = (c.k.Fy*y + c.k.Cy) * float64(h) / float64(c.k.Height)
+	if u < 0 || v < 0 || u >= float64(w) || v >= float64(h) {
+		return 0, 0, 0, false
+	}
+	return u, v, z, true
+}
+
+// distort applies the OpenCV lens model (k1, k2, p1, p2[, k3[, k4, k5,
+// k6]]) to the normalized image point x, y.
+func (c *Camera) distort(x, y float64) (float64, float64) {
+	d := c.k.Distortion
+	if len(d) == 0 {
+		return x, y
+	}
+	var k [8]float64
+	copy(k[:], d)
+	k1, k2, p1, p2, k3, k4, k5, k6 := k[0], k[1], k[2], k[3], k[4], k[5], k[6], k[7]
+	r2 := x*x + y*y
+	r4, r6 := r2*r2, r2*r2*r2
+	radial := (1 + k1*r2 + k2*r4 + k3*r6) / (1 + k4*r2 + k5*r4 + k6*r6)
+	return x*radial + 2*p1*x*y + p2*(r2+2*x*x),
+		y*radial + p1*(r2+2*y*y) + 2*p2*x*y
+}
@@ -0,0 +1,109 @@
+package transform
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// maxMotionAge is how old the speed or yaw rate may be at the time of a
+// sweep; an older one is taken as zero.
+const maxMotionAge = time.Second
+
+// Deskewer removes the motion distortion of lidar sweeps: a point fired
+// late in a sweep was measured from a vehicle that had moved on since the
+// sweep started. The ego motion is taken as constant over a sweep, a
+// forward speed from the latest GPS fix and a yaw rate from the IMU gyro.
+// It is safe for concurrent use.
+type Deskewer struct {
+	lidarToVehicle utils.Mat4
+	vehicleToLidar utils.Mat4
+	imuMount       utils.Mat4 // IMU to vehicle rotation
+
+	mu        sync.Mutex
+	speed     float64 // m/s
+	speedAt   time.Time
+	yawRate   float64 // rad/s, counter-clockwise
+	yawRateAt time.Time
+}
+
+func NewDeskewer(cal utils.CalibrationConfig) *Deskewer {
+	d := &Deskewer{lidarToVehicle: cal.SensorToVehicle("lidar"), imuMount: cal.SensorToVehicle("imu")}
+	d.vehicleToLidar = d.lidarToVehicle.InverseRigid()
+	d.imuMount[0][3], d.imuMount[1][3], d.imuMount[2][3] = 0, 0, 0
+	return d
+}
+
+// ObserveGPS takes the speed of a fix; the vehicle is assumed to drive
+// forwards.
+func (d *Deskewer) ObserveGPS(g models.GPSData) {
+	if g.FixQuality == 0 {
+		return
+	}
+	d.mu.Lock()
+	d.speed, d.speedAt = g.SpeedMps, g.Timestamp
+	d.mu.Unlock()
+}
+
+// ObserveIMU takes the yaw rate from the gyro in the vehicle frame.
+func (d *Deskewer) ObserveIMU(m models.IMUData) {
+	_, _, gz := d.imuMount.Apply(m.GyroX, m.GyroY, m.GyroZ)
+	d.mu.Lock()
+	d.yawRate, d.yawRateAt = gz, m.Timestamp
+	d.mu.Unlock()
+}
+
+// motion returns the speed and yaw rate at t.
+func (d *Deskewer) motion(t time.Time) (speed, yawRate float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t.Sub(d.speedAt).Abs() <= maxMotionAge {
+		speed = d.speed
+	}
+	if t.Sub(d.yawRateAt).Abs() <= maxMotionAge {
+		yawRate = d.yawRate
+	}
+	return speed, yawRate
+}
+
+// Deskew returns p with every point moved to where the sensor would have
+// seen it at p.Timestamp. Packets without point times, and sweeps while
+// the vehicle is not known to move, are returned as they are.
+func (d *Deskewer) Deskew(p models.LidarPacket) models.LidarPacket {
+	if p.PointFormat() != models.PointsXYZIRT {
+		return p
+	}
+	v, w := d.motion(p.Timestamp)
+	if v == 0 && w == 0 {
+		return p
+	}
+	pts := p.DecodePoints()
+	for i, pt := range pts {
+		m := d.vehicleToLidar.Mul(egoMotion(v, w, float64(pt.Time))).Mul(d.lidarToVehicle)
+		x, y, z := m.Apply(float64(pt.X), float64(pt.Y), float64(pt.Z))
+		pts[i].X, pts[i].Y, pts[i].Z = float32(x), float32(y), float32(z)
+	}
+	p.RawCloud = models.EncodePoints(pts, p.Format)
+	return p
+}
+
+// egoMotion returns the pose after dt seconds of driving at speed v while
+// turning at yaw rate w, in the vehicle frame at the start: an arc of a
+// circle, or a straight line when w is zero.
+func egoMotion(v, w, dt float64) utils.Mat4 {
+	yaw := w * dt
+	x, y := v*dt, 0.0
+	if math.Abs(w) > 1e-9 {
+		x, y = v*math.Sin(yaw)/w, v*(1-math.Cos(yaw))/w
+	}
+	c, s := math.Cos(yaw), math.Sin(yaw)
+	return utils.Mat4{
+		{c, -s, 0, x},
+		{s, c, 0, y},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+}
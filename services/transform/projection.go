@@ -0,0 +1,59 @@
+package transform
+
+import (
+	"sync"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Projector places GPS fixes in the flat frame of a projection of
+// storage.yaml, anchored at the first fix it is given: the UTM zone of
+// that fix, or an ENU frame at it. It is safe for concurrent use.
+type Projector struct {
+	kind string
+
+	mu     sync.Mutex
+	origin *models.GPSData
+	zone   int
+	south  bool
+}
+
+// NewProjector returns the projector of kind, utils.ProjectionUTM or
+// utils.ProjectionENU.
+func NewProjector(kind string) *Projector {
+	return &Projector{kind: kind}
+}
+
+// Kind returns the kind of the projection.
+func (p *Projector) Kind() string { return p.kind }
+
+// Project returns the position of g, nil for a fix without a position.
+func (p *Projector) Project(g models.GPSData) *models.Projection {
+	if g.FixQuality == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	if p.origin == nil {
+		o := g
+		o.Projected = nil
+		p.origin = &o
+		p.zone, p.south = utils.UTMZone(g.Lat, g.Lon)
+	}
+	origin, zone, south := *p.origin, p.zone, p.south
+	p.mu.Unlock()
+	if p.kind == utils.ProjectionENU {
+		e, n, _ := utils.GeodeticToENU(g.Lat, g.Lon, g.Alt, origin.Lat, origin.Lon, origin.Alt)
+		return &models.Projection{X: e, Y: n}
+	}
+	e, n := utils.LatLonToUTM(g.Lat, g.Lon, zone, south)
+	return &models.Projection{X: e, Y: n, Zone: utils.UTMZoneName(zone, south)}
+}
+
+// Origin returns the fix the projection is anchored at, nil before the
+// first.
+func (p *Projector) Origin() *models.GPSData {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.origin
+}
@@ -0,0 +1,130 @@
+// Package transform expresses sensor measurements in the vehicle frame or
+// in a local ENU world frame, using the configured extrinsics and the ego
+// pose derived from GPS.
+package transform
+
+import (
+	"math"
+	"sync"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Frame names the target frame of a transform.
+type Frame string
+
+const (
+	// FrameVehicle is x forward, y left, z up at the vehicle origin.
+	FrameVehicle Frame = "vehicle"
+	// FrameWorld is east, north, up relative to the session's first GPS fix.
+	FrameWorld Frame = "world"
+)
+
+// Point is a transformed 3D point in metres.
+type Point struct {
+	X, Y, Z float64
+}
+
+// Transformer holds the static extrinsics and the latest ego pose. It is
+// safe for concurrent use.
+type Transformer struct {
+	lidarToVehicle utils.Mat4
+	radarToVehicle utils.Mat4
+	gpsAntenna     [3]float64
+
+	mu           sync.RWMutex
+	origin       *models.GPSData
+	vehicleToENU utils.Mat4
+	havePose     bool
+}
+
+func NewTransformer(cal utils.CalibrationConfig) *Transformer {
+	t := &Transformer{
+		lidarToVehicle: cal.SensorToVehicle("lidar"),
+		radarToVehicle: cal.SensorToVehicle("radar"),
+	}
+	if e, ok := cal.Extrinsics["gps"]; ok {
+		t.gpsAntenna = e.Translation
+	}
+	return t
+}
+
+// UpdatePose sets the ego pose from a GPS fix. The first fix becomes the
+// ENU origin. Only yaw is known from GPS, so roll and pitch are taken as
+// zero; the antenna lever arm is removed using the gps extrinsic.
+func (t *Transformer) UpdatePose(g models.GPSData) {
+	if g.FixQuality == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.origin == nil {
+		o := g
+		t.origin = &o
+	}
+	e, n, u := utils.GeodeticToENU(g.Lat, g.Lon, g.Alt, t.origin.Lat, t.origin.Lon, t.origin.Alt)
+	// Heading is clockwise from north; ENU yaw is counter-clockwise from east.
+	yaw := (90 - g.HeadingDeg) * math.Pi / 180
+	cy, sy := math.Cos(yaw), math.Sin(yaw)
+	ax, ay, az := t.gpsAntenna[0], t.gpsAntenna[1], t.gpsAntenna[2]
+	t.vehicleToENU = utils.Mat4{
+		{cy, -sy, 0, e - (cy*ax - sy*ay)},
+		{sy, cy, 0, n - (sy*ax + cy*ay)},
+		{0, 0, 1, u - az},
+		{0, 0, 0, 1},
+	}
+	t.havePose = true
+}
+
+// Origin returns the ENU origin fix, or nil before the first fix.
+func (t *Transformer) Origin() *models.GPSData {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.origin
+}
+
+// toFrame returns the transform from the vehicle frame to frame. ok is
+// false when a world transform is requested before the first GPS fix.
+func (t *Transformer) toFrame(frame Frame) (m utils.Mat4, ok bool) {
+	if frame == FrameVehicle {
+		return utils.Identity4(), true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.vehicleToENU, t.havePose
+}
+
+// LidarPoints returns the points of p expressed in frame, keeping
+// intensities. ok is false when the world pose is not yet known.
+func (t *Transformer) LidarPoints(p models.LidarPacket, frame Frame) (pts []models.LidarPoint, ok bool) {
+	toFrame, ok := t.toFrame(frame)
+	if !ok {
+		return nil, false
+	}
+	m := toFrame.Mul(t.lidarToVehicle)
+	pts = p.DecodePoints()
+	for i, pt := range pts {
+		x, y, z := m.Apply(float64(pt.X), float64(pt.Y), float64(pt.Z))
+		pts[i].X, pts[i].Y, pts[i].Z = float32(x), float32(y), float32(z)
+	}
+	return pts, true
+}
+
+// RadarTargets returns the position of every target of s in frame, in the
+// same order as s.Targets. Targets are placed in the radar's horizontal
+// plane since no elevation is reported.
+func (t *Transformer) RadarTargets(s models.RadarScan, frame Frame) (pts []Point, ok bool) {
+	toFrame, ok := t.toFrame(frame)
+	if !ok {
+		return nil, false
+	}
+	m := toFrame.Mul(t.radarToVehicle)
+	pts = make([]Point, len(s.Targets))
+	for i, tg := range s.Targets {
+		az := tg.AzimuthDeg * math.Pi / 180
+		x, y, z := m.Apply(tg.RangeM*math.Cos(az), tg.RangeM*math.Sin(az), 0)
+		pts[i] = Point{X: x, Y: y, Z: z}
+	}
+	return pts, true
+}
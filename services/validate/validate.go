@@ -0,0 +1,219 @@
+// Package validate flags physically impossible sensor records, such as a
+// negative HDOP or an acceleration beyond the IMU's range, and tallies
+// them per sensor.
+package validate
+
+import (
+	"maps"
+	"math"
+	"sync"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+const (
+	// maxAccel is the ±16 g full scale of the IMU, in m/s².
+	maxAccel = 16 * 9.80665
+	// maxGyro is the ±2000 °/s full scale of the IMU, in rad/s.
+	maxGyro = 2000 * math.Pi / 180
+	// Operating range of the BME280 environment sensor, in °C.
+	minTemperature, maxTemperature = -40, 85
+)
+
+// Each check returns why a record is invalid, or "" for a valid one. Only
+// the first problem found is reported.
+
+func Camera(f models.CameraFrame) string {
+	if f.Width <= 0 || f.Height <= 0 {
+		return "non-positive size"
+	}
+	return ""
+}
+
+func GPS(g models.GPSData) string {
+	switch {
+	case !finite(g.Lat, g.Lon, g.Alt, g.SpeedMps, g.HeadingDeg, g.HDOP):
+		return "not a number"
+	case g.Lat < -90 || g.Lat > 90:
+		return "lat out of range"
+	case g.Lon < -180 || g.Lon > 180:
+		return "lon out of range"
+	case g.HDOP < 0:
+		return "negative hdop"
+	case g.SpeedMps < 0:
+		return "negative speed"
+	case g.Satellites < 0:
+		return "negative satellites"
+	}
+	return ""
+}
+
+func IMU(d models.IMUData) string {
+	switch {
+	case !finite(d.AccelX, d.AccelY, d.AccelZ, d.GyroX, d.GyroY, d.GyroZ, d.MagX, d.MagY, d.MagZ):
+		return "not a number"
+	case math.Abs(d.AccelX) > maxAccel || math.Abs(d.AccelY) > maxAccel || math.Abs(d.AccelZ) > maxAccel:
+		return "accel above 16 g"
+	case math.Abs(d.GyroX) > maxGyro || math.Abs(d.GyroY) > maxGyro || math.Abs(d.GyroZ) > maxGyro:
+		return "gyro above 2000 deg/s"
+	}
+	return ""
+}
+
+func Lidar(p models.LidarPacket) string {
+	if p.NumPoints <= 0 {
+		return "no points"
+	}
+	return ""
+}
+
+// Radar checks every target of a scan; one bad target invalidates the scan.
+func Radar(s models.RadarScan) string {
+	for _, t := range s.Targets {
+		switch {
+		case !finite(t.RangeM, t.AzimuthDeg, t.VelocityMps, t.RCS):
+			return "not a number"
+		case t.RangeM < 0:
+			return "negative range"
+		case t.AzimuthDeg < -180 || t.AzimuthDeg > 180:
+			return "azimuth out of range"
+		}
+	}
+	for _, d := range s.Detections {
+		switch {
+		case !finite(d.RangeM, d.AzimuthDeg, d.ElevationDeg, d.DopplerMps, d.PowerDB):
+			return "detection not a number"
+		case d.RangeM < 0:
+			return "negative detection range"
+		}
+	}
+	return ""
+}
+
+func Env(e models.EnvData) string {
+	switch {
+	case !finite(e.TemperatureC, e.HumidityPct, e.PressureHPa):
+		return "not a number"
+	case e.TemperatureC < minTemperature || e.TemperatureC > maxTemperature:
+		return "temperature out of range"
+	case e.HumidityPct < 0 || e.HumidityPct > 100:
+		return "humidity out of range"
+	case e.PressureHPa <= 0:
+		return "non-positive pressure"
+	}
+	return ""
+}
+
+func INS(d models.INSData) string {
+	switch {
+	case !finite(d.RollDeg, d.PitchDeg, d.YawDeg, d.VelN, d.VelE, d.VelD, d.AttAccDeg, d.PosAccM, d.VelAccMps):
+		return "not a number"
+	case d.RollDeg < -180 || d.RollDeg > 180:
+		return "roll out of range"
+	case d.PitchDeg < -90 || d.PitchDeg > 90:
+		return "pitch out of range"
+	case d.AttAccDeg < 0 || d.PosAccM < 0 || d.VelAccMps < 0:
+		return "negative accuracy"
+	}
+	return ""
+}
+
+// Fused returns the first problem of any sample r carries, or "".
+func Fused(r models.FusedRecord) string {
+	for _, reason := range []string{
+		ptr(r.Camera, Camera), ptr(r.GPS, GPS), ptr(r.IMU, IMU),
+		ptr(r.Lidar, Lidar), ptr(r.Radar, Radar), ptr(r.Env, Env),
+	} {
+		if reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// Drop clears the samples of r that fail their check.
+func Drop(r *models.FusedRecord) {
+	if r.Camera != nil && Camera(*r.Camera) != "" {
+		r.Camera = nil
+	}
+	if r.GPS != nil && GPS(*r.GPS) != "" {
+		r.GPS = nil
+	}
+	if r.IMU != nil && IMU(*r.IMU) != "" {
+		r.IMU = nil
+	}
+	if len(r.IMUBatch) > 0 {
+		// A new slice: the batch is shared with the other fused outputs.
+		var batch []models.IMUData
+		for _, d := range r.IMUBatch {
+			if IMU(d) == "" {
+				batch = append(batch, d)
+			}
+		}
+		r.IMUBatch = batch
+	}
+	if r.Lidar != nil && Lidar(*r.Lidar) != "" {
+		r.Lidar = nil
+	}
+	if r.Radar != nil && Radar(*r.Radar) != "" {
+		r.Radar = nil
+	}
+	if r.Env != nil && Env(*r.Env) != "" {
+		r.Env = nil
+	}
+}
+
+func ptr[T any](v *T, check func(T) string) string {
+	if v == nil {
+		return ""
+	}
+	return check(*v)
+}
+
+func finite(vs ...float64) bool {
+	for _, v := range vs {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tally counts invalid records by sensor and reason. It is safe for
+// concurrent use.
+type Tally struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int64
+}
+
+func NewTally() *Tally {
+	return &Tally{counts: map[string]map[string]int64{}}
+}
+
+// Add counts an invalid record of sensor; an empty reason is a valid
+// record and not counted.
+func (t *Tally) Add(sensor, reason string) {
+	if reason == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts[sensor] == nil {
+		t.counts[sensor] = map[string]int64{}
+	}
+	t.counts[sensor][reason]++
+}
+
+// Counts returns a copy of the counts, nil when every record was valid.
+func (t *Tally) Counts() map[string]map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.counts) == 0 {
+		return nil
+	}
+	out := make(map[string]map[string]int64, len(t.counts))
+	for sensor, reasons := range t.counts {
+		out[sensor] = maps.Clone(reasons)
+	}
+	return out
+}
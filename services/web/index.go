@@ -0,0 +1,24 @@
+package web
+
+import (
+	"html/template"
+	"net/http"
+	"os"
+)
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Sensor-Logger on {{.Host}}</title>
+<style>body{font-family:sans-serif;margin:2em}li{margin:.4em 0}</style></head>
+<body><h1>Sensor-Logger on {{.Host}}</h1>
+<ul>{{range .Links}}<li><a href="{{.Path}}">{{.Title}}</a></li>{{end}}</ul>
+</body></html>
+`))
+
+func (s *Server) index(w http.ResponseWriter, _ *http.Request) {
+	host, _ := os.Hostname()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTmpl.Execute(w, struct {
+		Host  string
+		Links []link
+	}{host, s.links})
+}
@@ -0,0 +1,87 @@
+// Package web serves the logger's HTTP surface: metrics, camera
+// thumbnails and a status page for operators, all on one address.
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+)
+
+// tokenCookie holds a token passed as ?token= so that the pages' own
+// requests (images, JSON) are authenticated too.
+const tokenCookie = "sensor_logger_token"
+
+// Server collects the handlers of the components enabled in a run and
+// serves them on one listener.
+type Server struct {
+	mux   *http.ServeMux
+	auth  *auth.Authenticator
+	links []link
+}
+
+type link struct{ Path, Title string }
+
+// NewServer returns a server whose handlers are guarded by a (nil for an
+// open server).
+func NewServer(a *auth.Authenticator) *Server {
+	s := &Server{mux: http.NewServeMux(), auth: a}
+	s.mux.Handle("GET /{$}", s.guard(auth.Read, http.HandlerFunc(s.index)))
+	return s
+}
+
+// Handle registers h for pattern (see http.ServeMux), for clients with at
+// least role. A non-empty title lists path on the status page.
+func (s *Server) Handle(pattern string, role auth.Role, h http.Handler, path, title string) {
+	s.mux.Handle(pattern, s.guard(role, h))
+	if title != "" {
+		s.links = append(s.links, link{path, title})
+	}
+}
+
+// Serve listens on addr and serves in the background.
+func (s *Server) Serve(addr string) (*http.Server, error) {
+	ln, err := s.auth.Listen(addr)
+	if err != nil {
+		return nil, fmt.Errorf("http listen %s: %w", addr, err)
+	}
+	srv := &http.Server{Handler: s.mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// guard takes the token from an "Authorization: Bearer" header, a token
+// query parameter or the token cookie, in that order.
+func (s *Server) guard(role auth.Role, h http.Handler) http.Handler {
+	if !s.auth.Required() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, query := "", false
+		if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			tok = strings.TrimSpace(v)
+		} else if v := r.URL.Query().Get("token"); v != "" {
+			tok, query = v, true
+		} else if c, err := r.Cookie(tokenCookie); err == nil {
+			tok = c.Value
+		}
+		switch got := s.auth.Role(tok, r.TLS); {
+		case got == auth.None:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sensor-logger"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		case got < role:
+			http.Error(w, "forbidden: needs the "+role.String()+" role", http.StatusForbidden)
+			return
+		}
+		if query {
+			http.SetCookie(w, &http.Cookie{
+				Name: tokenCookie, Value: tok, Path: "/",
+				HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode,
+			})
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,401 @@
+// Package zmq implements a minimal ZeroMQ PUB socket (ZMTP 3.x, NULL or
+// PLAIN security) over TCP, enough for libzmq and pyzmq SUB sockets to
+// connect and subscribe by topic prefix.
+package zmq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+
+	greetingSize     = 64
+	handshakeTimeout = 5 * time.Second
+	maxFrameSize     = 1 << 20
+)
+
+// PubSocket fans published messages out to every connected subscriber
+// whose subscription matches the topic. Slow subscribers lose messages
+// once their queue of hwm messages is full; Publish never blocks.
+type PubSocket struct {
+	ln    net.Listener
+	hwm   int
+	plain func(user, password string) bool
+
+	mu      sync.Mutex
+	peers   map[*peer]struct{}
+	closed  bool
+	dropped atomic.Uint64
+}
+
+type peer struct {
+	conn net.Conn
+	out  chan [2][]byte
+
+	mu   sync.Mutex
+	subs [][]byte
+}
+
+// Listen binds a PUB socket. endpoint is "tcp://host:port"; a host of "*"
+// binds all interfaces. A non-nil plain makes the socket a PLAIN server
+// that admits the subscribers whose credentials it accepts.
+func Listen(endpoint string, hwm int, plain func(user, password string) bool) (*PubSocket, error) {
+	addr, ok := strings.CutPrefix(endpoint, "tcp://")
+	if !ok {
+		return nil, fmt.Errorf("zmq: unsupported endpoint %q (only tcp:// is supported)", endpoint)
+	}
+	addr = strings.Replace(addr, "*", "", 1)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("zmq: listen %s: %w", endpoint, err)
+	}
+	s := &PubSocket{ln: ln, hwm: hwm, plain: plain, peers: map[*peer]struct{}{}}
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the bound address.
+func (s *PubSocket) Addr() net.Addr { return s.ln.Addr() }
+
+// Dropped returns the number of messages discarded for slow subscribers.
+func (s *PubSocket) Dropped() uint64 { return s.dropped.Load() }
+
+// Publish sends a two-frame message [topic, payload] to matching subscribers.
+func (s *PubSocket) Publish(topic string, payload []byte) {
+	t := []byte(topic)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.peers {
+		if !p.subscribed(t) {
+			continue
+		}
+		select {
+		case p.out <- [2][]byte{t, payload}:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Close stops accepting subscribers and disconnects the existing ones.
+func (s *PubSocket) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for p := range s.peers {
+		p.conn.Close()
+	}
+	s.mu.Unlock()
+	return s.ln.Close()
+}
+
+func (s *PubSocket) accept() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(c)
+	}
+}
+
+func (s *PubSocket) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := handshake(c, r, s.plain); err != nil {
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	p := &peer{conn: c, out: make(chan [2][]byte, s.hwm)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.peers[p] = struct{}{}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := bufio.NewWriter(c)
+		for msg := range p.out {
+			if writeFrame(w, flagMore, msg[0]) != nil || writeFrame(w, 0, msg[1]) != nil {
+				c.Close()
+				return
+			}
+			if len(p.out) == 0 && w.Flush() != nil {
+				c.Close()
+				return
+			}
+		}
+	}()
+	p.readSubscriptions(r)
+	c.Close()
+	s.mu.Lock()
+	delete(s.peers, p)
+	close(p.out)
+	s.mu.Unlock()
+	<-done
+}
+
+// handshake exchanges greetings and READY commands as a PUB socket, using
+// the NULL mechanism, or PLAIN when plain is set.
+func handshake(c net.Conn, r *bufio.Reader, plain func(user, password string) bool) error {
+	mechanism := "NULL"
+	if plain != nil {
+		mechanism = "PLAIN"
+	}
+	var g [greetingSize]byte
+	g[0], g[9], g[10], g[11] = 0xFF, 0x7F, 3, 0
+	copy(g[12:], mechanism)
+	if plain != nil {
+		g[32] = 1 // as-server
+	}
+	if _, err := c.Write(g[:]); err != nil {
+		return err
+	}
+	var peer [greetingSize]byte
+	if _, err := io.ReadFull(r, peer[:]); err != nil {
+		return err
+	}
+	if peer[0] != 0xFF || peer[9] != 0x7F || peer[10] < 3 {
+		return errors.New("zmq: peer does not speak ZMTP 3")
+	}
+	if mech := string(bytes.TrimRight(peer[12:32], "\x00")); mech != mechanism {
+		return fmt.Errorf("zmq: peer uses security mechanism %q, want %s", mech, mechanism)
+	}
+
+	// With NULL both sides send READY; a PLAIN server answers the client's
+	// INITIATE with it.
+	w := bufio.NewWriter(c)
+	if plain != nil {
+		if err := plainAuth(r, w, plain); err != nil {
+			return err
+		}
+		if err := readReady(r, "INITIATE"); err != nil {
+			return err
+		}
+	}
+	var ready bytes.Buffer
+	ready.WriteByte(5)
+	ready.WriteString("READY")
+	writeProperty(&ready, "Socket-Type", "PUB")
+	if err := writeFrame(w, flagCommand, ready.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if plain != nil {
+		return nil
+	}
+	return readReady(r, "READY")
+}
+
+// readReady reads the peer's READY (or INITIATE) command and checks its
+// socket type.
+func readReady(r *bufio.Reader, want string) error {
+	flags, body, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	name, props := parseCommand(body)
+	if flags&flagCommand == 0 || name != want {
+		return fmt.Errorf("zmq: expected %s command", want)
+	}
+	if st := props["Socket-Type"]; st != "SUB" && st != "XSUB" {
+		return fmt.Errorf("zmq: PUB socket cannot talk to %s", st)
+	}
+	return nil
+}
+
+// plainAuth reads the client's HELLO and answers WELCOME, or ERROR when
+// plain rejects the credentials (ZMTP RFC 24).
+func plainAuth(r *bufio.Reader, w *bufio.Writer, plain func(user, password string) bool) error {
+	flags, body, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	if flags&flagCommand == 0 || len(body) < 6 || string(body[:6]) != "\x05HELLO" {
+		return errors.New("zmq: expected HELLO command")
+	}
+	user, rest, ok := shortString(body[6:])
+	password, _, ok2 := shortString(rest)
+	if !ok || !ok2 {
+		return errors.New("zmq: malformed HELLO command")
+	}
+	if !plain(user, password) {
+		const reason = "invalid credentials"
+		var cmd bytes.Buffer
+		cmd.WriteString("\x05ERROR")
+		cmd.WriteByte(byte(len(reason)))
+		cmd.WriteString(reason)
+		writeFrame(w, flagCommand, cmd.Bytes())
+		w.Flush()
+		return errors.New("zmq: subscriber rejected: " + reason)
+	}
+	if err := writeFrame(w, flagCommand, []byte("\x07WELCOME")); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// shortString splits a string with a one-byte length prefix off b.
+func shortString(b []byte) (string, []byte, bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, false
+	}
+	return string(b[1 : 1+b[0]]), b[1+b[0]:], true
+}
+
+// readSubscriptions processes subscription changes until the peer
+// disconnects. ZMTP 3.0 peers send them as messages prefixed with 1
+// (subscribe) or 0 (cancel); ZMTP 3.1 peers use SUBSCRIBE/CANCEL commands.
+func (p *peer) readSubscriptions(r *bufio.Reader) {
+	for {
+		flags, body, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		switch {
+		case flags&flagCommand != 0:
+			if len(body) == 0 || int(body[0]) >= len(body) {
+				continue
+			}
+			name, topic := string(body[1:1+body[0]]), body[1+body[0]:]
+			switch name {
+			case "SUBSCRIBE":
+				p.subscribe(topic)
+			case "CANCEL":
+				p.cancel(topic)
+			}
+		case len(body) > 0 && body[0] == 1:
+			p.subscribe(body[1:])
+		case len(body) > 0 && body[0] == 0:
+			p.cancel(body[1:])
+		}
+	}
+}
+
+func (p *peer) subscribe(topic []byte) {
+	p.mu.Lock()
+	p.subs = append(p.subs, bytes.Clone(topic))
+	p.mu.Unlock()
+}
+
+func (p *peer) cancel(topic []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, s := range p.subs {
+		if bytes.Equal(s, topic) {
+			p.subs = append(p.subs[:i], p.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *peer) subscribed(topic []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.subs {
+		if bytes.HasPrefix(topic, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFrame(w *bufio.Writer, flags byte, body []byte) error {
+	if len(body) > 255 {
+		flags |= flagLong
+	}
+	if err := w.WriteByte(flags); err != nil {
+		return err
+	}
+	if flags&flagLong != 0 {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(body)))
+		if _, err := w.Write(n[:]); err != nil {
+			return err
+		}
+	} else if err := w.WriteByte(byte(len(body))); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+func readFrame(r *bufio.Reader) (flags byte, body []byte, err error) {
+	if flags, err = r.ReadByte(); err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&flagLong != 0 {
+		var n [8]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(n[:])
+	} else {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("zmq: frame of %d bytes from subscriber", size)
+	}
+	body = make([]byte, size)
+	_, err = io.ReadFull(r, body)
+	return flags, body, err
+}
+
+func writeProperty(b *bytes.Buffer, name, value string) {
+	b.WriteByte(byte(len(name)))
+	b.WriteString(name)
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(value)))
+	b.Write(n[:])
+	b.WriteString(value)
+}
+
+// parseCommand splits a command frame into its name and properties.
+func parseCommand(body []byte) (string, map[string]string) {
+	props := map[string]string{}
+	if len(body) == 0 || int(body[0]) >= len(body) {
+		return "", props
+	}
+	name := string(body[1 : 1+body[0]])
+	rest := body[1+body[0]:]
+	for len(rest) > 0 {
+		nl := int(rest[0])
+		if len(rest) < 1+nl+4 {
+			break
+		}
+		key := string(rest[1 : 1+nl])
+		vl := int(binary.BigEndian.Uint32(rest[1+nl:]))
+		rest = rest[1+nl+4:]
+		if len(rest) < vl {
+			break
+		}
+		props[key] = string(rest[:vl])
+		rest = rest[vl:]
+	}
+	return name, props
+}
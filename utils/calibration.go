@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+)
+
+// CalibrationConfig holds the camera intrinsics and the extrinsic transform
+// of every sensor to the vehicle reference frame (x forward, y left, z up,
+// origin at the rear axle on the ground).
+type CalibrationConfig struct {
+	Camera     CameraIntrinsics     `yaml:"camera"`
+	Extrinsics map[string]Extrinsic `yaml:"extrinsics"`
+}
+
+// CameraIntrinsics is the pinhole model of the camera in pixels.
+// Distortion holds OpenCV-order coefficients (k1, k2, p1, p2[, k3...]).
+type CameraIntrinsics struct {
+	Width      int       `yaml:"width"`
+	Height     int       `yaml:"height"`
+	Fx         float64   `yaml:"fx"`
+	Fy         float64   `yaml:"fy"`
+	Cx         float64   `yaml:"cx"`
+	Cy         float64   `yaml:"cy"`
+	Distortion []float64 `yaml:"distortion,omitempty"`
+}
+
+// Extrinsic is the mounting pose of a sensor in the vehicle frame. Rotation
+// is roll, pitch, yaw in degrees applied as Rz(yaw)·Ry(pitch)·Rx(roll).
+// For the camera the pose describes the camera body (x forward, y left,
+// z up); see OpticalToBody for the projection frame.
+type Extrinsic struct {
+	Translation [3]float64 `yaml:"translation"`
+	Rotation    [3]float64 `yaml:"rotation_rpy_deg"`
+}
+
+// calibratedSensors are the sensor names accepted under extrinsics.
+var calibratedSensors = map[string]bool{
+	"camera": true, "gps": true, "imu": true, "lidar": true, "radar": true,
+}
+
+// Configured reports whether any calibration was provided.
+func (c CalibrationConfig) Configured() bool {
+	return c.Camera.set() || len(c.Extrinsics) > 0
+}
+
+func (k CameraIntrinsics) set() bool {
+	return k.Width != 0 || k.Height != 0 || k.Fx != 0 || k.Fy != 0 || k.Cx != 0 || k.Cy != 0 || len(k.Distortion) > 0
+}
+
+// Validate checks the calibration for missing or physically meaningless values.
+func (c CalibrationConfig) Validate() error {
+	if c.Camera.set() {
+		if err := c.Camera.Validate(); err != nil {
+			return fmt.Errorf("camera intrinsics: %w", err)
+		}
+	}
+	for name, e := range c.Extrinsics {
+		if !calibratedSensors[name] {
+			return fmt.Errorf("extrinsics: unknown sensor %q", name)
+		}
+		for _, v := range append(e.Translation[:], e.Rotation[:]...) {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("extrinsics %s: non-finite value", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks that the intrinsics describe a usable pinhole camera.
+func (k CameraIntrinsics) Validate() error {
+	if k.Width <= 0 || k.Height <= 0 {
+		return fmt.Errorf("width and height must be positive")
+	}
+	if k.Fx <= 0 || k.Fy <= 0 {
+		return fmt.Errorf("fx and fy must be positive")
+	}
+	if k.Cx < 0 || k.Cx > float64(k.Width) || k.Cy < 0 || k.Cy > float64(k.Height) {
+		return fmt.Errorf("principal point (%.1f, %.1f) outside %dx%d image", k.Cx, k.Cy, k.Width, k.Height)
+	}
+	switch len(k.Distortion) {
+	case 0, 4, 5, 8:
+	default:
+		return fmt.Errorf("distortion must have 0, 4, 5 or 8 coefficients, got %d", len(k.Distortion))
+	}
+	return nil
+}
+
+// K returns the 3x3 camera matrix.
+func (k CameraIntrinsics) K() [3][3]float64 {
+	return [3][3]float64{{k.Fx, 0, k.Cx}, {0, k.Fy, k.Cy}, {0, 0, 1}}
+}
+
+// SensorToVehicle returns the extrinsic of name, or the identity when the
+// sensor has no calibration entry.
+func (c CalibrationConfig) SensorToVehicle(name string) Mat4 {
+	if e, ok := c.Extrinsics[name]; ok {
+		return e.Matrix()
+	}
+	return Identity4()
+}
+
+// Mat4 is a homogeneous 4x4 transform.
+type Mat4 [4][4]float64
+
+func Identity4() Mat4 {
+	return Mat4{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+}
+
+// OpticalToBody maps the camera optical frame (x right, y down, z forward)
+// used for projection to the camera body frame (x forward, y left, z up).
+var OpticalToBody = Mat4{{0, 0, 1, 0}, {-1, 0, 0, 0}, {0, -1, 0, 0}, {0, 0, 0, 1}}
+
+// Matrix returns the sensor-to-vehicle transform of e.
+func (e Extrinsic) Matrix() Mat4 {
+	r, p, y := e.Rotation[0]*math.Pi/180, e.Rotation[1]*math.Pi/180, e.Rotation[2]*math.Pi/180
+	cr, sr := math.Cos(r), math.Sin(r)
+	cp, sp := math.Cos(p), math.Sin(p)
+	cy, sy := math.Cos(y), math.Sin(y)
+	return Mat4{
+		{cy * cp, cy*sp*sr - sy*cr, cy*sp*cr + sy*sr, e.Translation[0]},
+		{sy * cp, sy*sp*sr + cy*cr, sy*sp*cr - cy*sr, e.Translation[1]},
+		{-sp, cp * sr, cp * cr, e.Translation[2]},
+		{0, 0, 0, 1},
+	}
+}
+
+// Quaternion returns the rotation of e as the unit quaternion (x, y, z, w).
+func (e Extrinsic) Quaternion() (x, y, z, w float64) {
+	sr, cr := math.Sincos(e.Rotation[0] * math.Pi / 360)
+	sp, cp := math.Sincos(e.Rotation[1] * math.Pi / 360)
+	sy, cy := math.Sincos(e.Rotation[2] * math.Pi / 360)
+	return sr*cp*cy - cr*sp*sy,
+		cr*sp*cy + sr*cp*sy,
+		cr*cp*sy - sr*sp*cy,
+		cr*cp*cy + sr*sp*sy
+}
+
+// Mul returns m·n.
+func (m Mat4) Mul(n Mat4) Mat4 {
+	var out Mat4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				out[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return out
+}
+
+// InverseRigid inverts a rotation-plus-translation transform.
+func (m Mat4) InverseRigid() Mat4 {
+	var out Mat4
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			out[i][j] = m[j][i]
+		}
+	}
+	for i := 0; i < 3; i++ {
+		out[i][3] = -(out[i][0]*m[0][3] + out[i][1]*m[1][3] + out[i][2]*m[2][3])
+	}
+	out[3][3] = 1
+	return out
+}
+
+// Apply transforms the point (x, y, z).
+func (m Mat4) Apply(x, y, z float64) (float64, float64, float64) {
+	return m[0][0]*x + m[0][1]*y + m[0][2]*z + m[0][3],
+		m[1][0]*x + m[1][1]*y + m[1][2]*z + m[1][3],
+		m[2][0]*x + m[2][1]*y + m[2][2]*z + m[2][3]
+}
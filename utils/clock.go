@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClockJump is a step of the wall clock against the monotonic clock seen
+// by Now, e.g. an NTP step. At is the wall-clock time just after it;
+// StepS is positive when the clock jumped forward.
+type ClockJump struct {
+	At    time.Time `json:"at"`
+	StepS float64   `json:"step_s"`
+}
+
+// Clock is a source of time and of ticks. The readers and the fusion and
+// recording controllers are given one when they are made: RealClock when
+// recording, a FakeClock in tests and imports.
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a ticker firing every d, which must be positive.
+	NewTicker(d time.Duration) *Ticker
+	// After returns a channel receiving the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker delivers ticks of a Clock on C, dropping those a slow receiver
+// misses, as time.Ticker does.
+type Ticker struct {
+	C    <-chan time.Time
+	stop func()
+}
+
+// Stop turns the ticker off. C is not closed.
+func (t *Ticker) Stop() { t.stop() }
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return Now() }
+
+func (realClock) NewTicker(d time.Duration) *Ticker {
+	t := time.NewTicker(d)
+	return &Ticker{C: t.C, stop: t.Stop}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// RealClock is the clock of the host. Its Now is Now, with the clock
+// guard.
+var RealClock Clock = realClock{}
+
+var clock struct {
+	mu        sync.Mutex
+	mode      string
+	threshold time.Duration
+	// last is the time of the last call to Now, with its monotonic
+	// reading; out is what it returned.
+	last time.Time
+	out  time.Time
+	// offset is the sum of the jumps taken out in ClockCorrect mode.
+	offset time.Duration
+	jumps  []ClockJump
+	count  atomic.Int64
+}
+
+// SetClockGuard makes Now check every timestamp for jumps of the wall
+// clock larger than threshold, handled as mode. Without it Now returns
+// the wall clock as is.
+func SetClockGuard(mode string, threshold time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.mode, clock.threshold = mode, threshold
+	clock.last, clock.out, clock.offset = time.Time{}, time.Time{}, 0
+}
+
+// Now returns the current wall-clock time of the host in UTC. All sensor
+// samples are stamped through this, by way of RealClock, so every file
+// shares one time base. With the clock guard set, it also detects
+// wall-clock jumps between two calls and, in ClockCorrect mode, never goes
+// backwards.
+func Now() time.Time {
+	t := time.Now()
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if clock.threshold <= 0 {
+		return t.UTC()
+	}
+	if !clock.last.IsZero() {
+		// Round(0) strips the monotonic reading, so the first difference
+		// is of the wall clock and the second of the monotonic one.
+		step := t.Round(0).Sub(clock.last.Round(0)) - t.Sub(clock.last)
+		if step > clock.threshold || step < -clock.threshold {
+			clock.jumps = append(clock.jumps, ClockJump{At: t.UTC(), StepS: step.Seconds()})
+			clock.count.Add(1)
+			if clock.mode == ClockCorrect {
+				clock.offset += step
+			}
+		}
+	}
+	clock.last = t
+	out := t.UTC().Add(-clock.offset)
+	if clock.mode == ClockCorrect && out.Before(clock.out) {
+		out = clock.out
+	}
+	clock.out = out
+	return out
+}
+
+// ClockJumpCount returns the number of clock jumps detected so far.
+func ClockJumpCount() int {
+	return int(clock.count.Load())
+}
+
+// ClockJumps returns the clock jumps detected so far, oldest first.
+func ClockJumps() []ClockJump {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return append([]ClockJump(nil), clock.jumps...)
+}
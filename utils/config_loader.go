@@ -0,0 +1,2502 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SimDevice selects the synthetic backend of a reader instead of hardware.
+const SimDevice = "sim"
+
+// RemoteDevice makes a reader take its samples from a remote agent
+// connected to the remote source instead of local hardware.
+const RemoteDevice = "remote"
+
+// SensorsConfig mirrors config/sensors.yaml.
+type SensorsConfig struct {
+	Camera CameraConfig `yaml:"camera"`
+	GPS    GPSConfig    `yaml:"gps"`
+	IMU    IMUConfig    `yaml:"imu"`
+	Lidar  LidarConfig  `yaml:"lidar"`
+	Radar  RadarConfig  `yaml:"radar"`
+	Env    EnvConfig    `yaml:"env"`
+	INS    INSConfig    `yaml:"ins"`
+	Fusion FusionConfig `yaml:"fusion"`
+	Remote RemoteConfig `yaml:"remote"`
+	Agent  AgentConfig  `yaml:"agent"`
+
+	Trigger     TriggerConfig     `yaml:"trigger"`
+	Adaptive    AdaptiveConfig    `yaml:"adaptive"`
+	Calibration CalibrationConfig `yaml:"calibration"`
+	Restart     RestartConfig     `yaml:"restart"`
+	SimThrottle SimThrottleConfig `yaml:"sim_throttle"`
+
+	AdaptiveBuffers AdaptiveBuffersConfig `yaml:"adaptive_buffers"`
+	MissingDevices  MissingDevicesConfig  `yaml:"missing_devices"`
+
+	// Scheduling tunes the OS thread of a reader, keyed by reader name.
+	Scheduling map[string]SchedulingConfig `yaml:"scheduling"`
+	// RateLimits caps the sample rate of a reader, keyed by reader name.
+	RateLimits map[string]RateLimitConfig `yaml:"rate_limits"`
+
+	// Profile is the profile applied, see LoadSensorsConfig.
+	Profile Profile `yaml:"-"`
+}
+
+// SimThrottleConfig slows the simulated sensors down while the host runs
+// hot, so a long bench or soak run on an embedded board does not overheat
+// it. Every IntervalS the hottest thermal zone is read: above StartC the
+// simulated devices skip ticks to lower their rates, linearly down to
+// MinRatePct percent at LimitC and above. Hardware sensors are left alone.
+type SimThrottleConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	StartC     float64 `yaml:"start_c"`
+	LimitC     float64 `yaml:"limit_c"`
+	MinRatePct float64 `yaml:"min_rate_pct"`
+	IntervalS  float64 `yaml:"interval_s"`
+}
+
+// AdaptiveBuffersConfig grows the buffer of a reader whose samples keep
+// more than 80% of it filled for HoldS seconds, as a bursty device does
+// that the pipeline only just keeps up with. The buffer doubles each
+// time, up to MaxFactor times its buffer_size, and does not shrink again
+// while the pipeline runs.
+type AdaptiveBuffersConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	MaxFactor int     `yaml:"max_factor"`
+	HoldS     float64 `yaml:"hold_s"`
+}
+
+// SchedulingConfig pins a reader to an OS thread and tunes that thread:
+// CPUs restricts it to the listed cores, Nice sets its niceness and a
+// non-zero FIFOPriority (1-99) runs it under SCHED_FIFO. Settings the
+// system refuses, typically for lack of CAP_SYS_NICE, are logged and
+// skipped.
+type SchedulingConfig struct {
+	CPUs         []int `yaml:"cpus"`
+	Nice         *int  `yaml:"nice"`
+	FIFOPriority int   `yaml:"fifo_priority"`
+}
+
+// MaxCPUs bounds the core numbers in SchedulingConfig.CPUs, the size of
+// the kernel's default CPU set.
+const MaxCPUs = 1024
+
+// scheduledReaders are the reader names accepted under scheduling.
+var scheduledReaders = map[string]bool{
+	"camera": true, "gps": true, "imu": true, "lidar": true, "radar": true, "env": true, "trigger": true, "remote": true,
+	"ins": true,
+}
+
+// RateLimitConfig guards the pipeline against a sensor that floods it,
+// such as a misconfigured lidar sending ten times its packets: a token
+// bucket lets MaxHz samples a second through on average, in bursts of up
+// to Burst (a second's worth by default), and the reader discards the
+// rest before they reach fusion or the disk.
+type RateLimitConfig struct {
+	MaxHz float64 `yaml:"max_hz"`
+	Burst int     `yaml:"burst"`
+}
+
+// RestartConfig runs a reader again after its device fails, first after
+// DelayMs, then with the delay doubling up to MaxDelayMs while it keeps
+// failing. When disabled, a failed reader stays down until restarted by
+// hand.
+type RestartConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	DelayMs    int  `yaml:"delay_ms"`
+	MaxDelayMs int  `yaml:"max_delay_ms"`
+}
+
+// MissingDevicesConfig lets the logger start with the device of an
+// enabled sensor missing, a camera unplugged or a serial adapter not yet
+// enumerated, instead of failing the pre-flight check. With Wait, the
+// reader of such a device is only started once the device is found,
+// looked for every RetryS; a device gone after a failure of its reader is
+// waited for the same way rather than retried with the restart backoff.
+type MissingDevicesConfig struct {
+	Wait   bool    `yaml:"wait"`
+	RetryS float64 `yaml:"retry_s"`
+}
+
+// UsesRemote reports whether any enabled sensor is fed by a remote agent.
+func (c *SensorsConfig) UsesRemote() bool {
+	return (c.Camera.Enabled && c.Camera.Device == RemoteDevice) ||
+		(c.GPS.Enabled && c.GPS.Device == RemoteDevice) ||
+		(c.IMU.Enabled && c.IMU.Device == RemoteDevice) ||
+		(c.Lidar.Enabled && c.Lidar.Address == RemoteDevice) ||
+		(c.Radar.Enabled && c.Radar.Address == RemoteDevice) ||
+		(c.Env.Enabled && c.Env.Device == RemoteDevice)
+}
+
+// CameraConfig configures the camera reader. Device is either "sim" or an
+// MJPEG stream URL. FrameStats decodes every frame to compute luminance and
+// sharpness statistics.
+//
+// Decimate, here and in the other sensors, makes the reader keep only
+// every Decimate-th sample and discard the others before they reach the
+// pipeline; 1 keeps them all. Sequence numbers and frame ids still count
+// every sample, so they step by Decimate.
+type CameraConfig struct {
+	Enabled    bool         `yaml:"enabled"`
+	Device     string       `yaml:"device"`
+	Width      int          `yaml:"width"`
+	Height     int          `yaml:"height"`
+	FPS        int          `yaml:"fps"`
+	BufferSize int          `yaml:"buffer_size"`
+	FrameStats bool         `yaml:"frame_stats"`
+	Decimate   int          `yaml:"decimate"`
+	Stereo     StereoConfig `yaml:"stereo"`
+	Freeze     FreezeConfig `yaml:"freeze"`
+}
+
+// StereoConfig makes the camera the left one of a stereo pair whose right
+// camera is RightDevice, "sim" or an MJPEG stream URL like Device. Both are
+// grabbed at the same time on every frame.
+type StereoConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	RightDevice string `yaml:"right_device"`
+}
+
+// FreezeConfig detects a frozen camera, one that keeps delivering the
+// same picture byte for byte, as a hung USB camera behind a streaming
+// bridge does. Once the picture has not changed for HoldS seconds the
+// camera counts as frozen: with action flag that is logged, and camera.csv
+// tells in frozen_ms how long each frame's picture had been the same;
+// with restart the reader also fails, to be restarted as after a
+// disconnect (see RestartConfig).
+type FreezeConfig struct {
+	Enabled bool    `yaml:"enabled"`
+	HoldS   float64 `yaml:"hold_s"`
+	Action  string  `yaml:"action"`
+}
+
+// Actions of FreezeConfig.
+const (
+	FreezeFlag    = "flag"
+	FreezeRestart = "restart"
+)
+
+// GPSConfig configures the GPS reader. Protocol is nmea, ubx (u-blox
+// binary) or auto, which takes UBX once the receiver sends it and NMEA
+// until then.
+type GPSConfig struct {
+	Enabled    bool        `yaml:"enabled"`
+	Device     string      `yaml:"device"`
+	Baud       int         `yaml:"baud"`
+	Protocol   string      `yaml:"protocol"`
+	RateHz     int         `yaml:"rate_hz"`
+	BufferSize int         `yaml:"buffer_size"`
+	Decimate   int         `yaml:"decimate"`
+	NTRIP      NTRIPConfig `yaml:"ntrip"`
+}
+
+// Protocols of GPSConfig.
+const (
+	GPSAuto = "auto"
+	GPSNMEA = "nmea"
+	GPSUBX  = "ubx"
+)
+
+// NTRIPConfig streams RTCM corrections from an NTRIP caster (host:port) to
+// the receiver over its serial port. The receiver's GGA position is sent
+// back every GGAIntervalS, which network (VRS) mountpoints need; a dropped
+// connection is retried after ReconnectS.
+type NTRIPConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Caster       string `yaml:"caster"`
+	Mountpoint   string `yaml:"mountpoint"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	GGAIntervalS int    `yaml:"gga_interval_s"`
+	ReconnectS   int    `yaml:"reconnect_s"`
+}
+
+// IMUConfig configures the IMU reader.
+type IMUConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Device     string `yaml:"device"`
+	Baud       int    `yaml:"baud"`
+	RateHz     int    `yaml:"rate_hz"`
+	BufferSize int    `yaml:"buffer_size"`
+	Decimate   int    `yaml:"decimate"`
+}
+
+// LidarConfig configures the UDP lidar reader. Address is the local
+// listen address, "sim", or "pcap:<file>" to replay the datagrams of a
+// packet capture at the pace they were captured; PcapPort then keeps only
+// those sent to that UDP port (0 keeps all). Format is raw (datagrams of
+// 16-byte points) or vlp16 (Velodyne VLP-16 data packets); for vlp16,
+// ReturnMode keeps the strongest, the last or, with dual, every return
+// the sensor reports.
+type LidarConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Address    string `yaml:"address"`
+	Format     string `yaml:"format"`
+	ReturnMode string `yaml:"return_mode"`
+	RateHz     int    `yaml:"rate_hz"`
+	BufferSize int    `yaml:"buffer_size"`
+	Decimate   int    `yaml:"decimate"`
+
+	PcapPort int `yaml:"pcap_port"`
+}
+
+// PcapPrefix starts the address of a lidar replayed from a packet
+// capture, e.g. "pcap:drive.pcap".
+const PcapPrefix = "pcap:"
+
+// Formats and return modes of LidarConfig.
+const (
+	LidarRaw   = "raw"
+	LidarVLP16 = "vlp16"
+
+	ReturnStrongest = "strongest"
+	ReturnLast      = "last"
+	ReturnDual      = "dual"
+)
+
+// RadarConfig configures the radar reader. With protocol json Address is
+// the host:port of a TCP bridge sending JSON target lists; with ars408 it
+// is the SocketCAN interface of a Continental ARS 408, whose configured
+// SensorID (0-7) offsets its CAN identifiers.
+type RadarConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Address    string `yaml:"address"`
+	Protocol   string `yaml:"protocol"`
+	SensorID   int    `yaml:"sensor_id"`
+	RateHz     int    `yaml:"rate_hz"`
+	BufferSize int    `yaml:"buffer_size"`
+	Decimate   int    `yaml:"decimate"`
+	// Detections keeps the raw detections the radar sends with each
+	// scan (json), or simulates them (sim); they are dropped otherwise.
+	Detections bool `yaml:"detections"`
+}
+
+// Protocols of RadarConfig.
+const (
+	RadarJSON   = "json"
+	RadarARS408 = "ars408"
+)
+
+// EnvConfig configures the environment (temperature, humidity, pressure)
+// reader. Transport is "i2c" for a BME280 on an I2C bus or "serial" for a
+// bridge printing "T=..,H=..,P=.." lines.
+type EnvConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Transport    string `yaml:"transport"`
+	Device       string `yaml:"device"`
+	I2CAddress   int    `yaml:"i2c_address"`
+	Baud         int    `yaml:"baud"`
+	RateHz       int    `yaml:"rate_hz"`
+	BufferSize   int    `yaml:"buffer_size"`
+	Decimate     int    `yaml:"decimate"`
+	FusedColumns bool   `yaml:"fused_columns"`
+}
+
+// TriggerConfig reads an external hardware trigger, the strobe a rig wires
+// to every sensor it fires together. Device is "sim", "gpio:<n>" for a
+// sysfs GPIO line whose rising edges are the pulses, or a serial port of a
+// bridge printing one line per pulse; a line holding a number is taken as
+// the bridge's pulse count. RateHz is the pulse rate, of the simulated
+// trigger and for gap detection. Camera frames and lidar sweeps of local
+// devices are tied to the nearest pulse within WindowMs.
+type TriggerConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Device     string `yaml:"device"`
+	Baud       int    `yaml:"baud"`
+	RateHz     int    `yaml:"rate_hz"`
+	WindowMs   int    `yaml:"window_ms"`
+	BufferSize int    `yaml:"buffer_size"`
+}
+
+// GPIOPrefix starts the device of a trigger on a GPIO line, e.g. "gpio:17".
+const GPIOPrefix = "gpio:"
+
+// INSConfig configures a combined INS, a unit with its own IMU and GNSS
+// receiver sending both and its navigation solution on one serial port.
+// Enabling it makes it the device of the imu and gps readers, whatever
+// theirs says (see INSDevice), at its RateHz; the solution goes to
+// ins.csv. Device is "sim" or the serial port.
+type INSConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Device     string `yaml:"device"`
+	Baud       int    `yaml:"baud"`
+	Protocol   string `yaml:"protocol"`
+	RateHz     int    `yaml:"rate_hz"`
+	BufferSize int    `yaml:"buffer_size"`
+	Decimate   int    `yaml:"decimate"`
+}
+
+// INSDevice is the device of the imu and gps readers fed by the INS.
+const INSDevice = "ins"
+
+// INS protocols: VectorNav ASCII messages ($VNINS, $VNIMU, $VNYMR) of a
+// VN-200 or VN-300, or the sbgECom binary logs of an SBG Ellipse
+// (IMU_DATA, EKF_EULER, EKF_NAV).
+const (
+	INSVectorNav = "vectornav"
+	INSSBG       = "sbg"
+)
+
+// AdaptiveConfig thins out saved frames and clouds while the vehicle is
+// stationary. The vehicle counts as stationary once the GPS speed has been
+// below StationarySpeedMps for HoldS, and as moving again as soon as it
+// exceeds MovingSpeedMps or the GPS has no fix. While stationary, CameraFPS
+// frames and LidarHz clouds per second are saved (0 pauses saving).
+type AdaptiveConfig struct {
+	Enabled            bool    `yaml:"enabled"`
+	StationarySpeedMps float64 `yaml:"stationary_speed_mps"`
+	MovingSpeedMps     float64 `yaml:"moving_speed_mps"`
+	HoldS              float64 `yaml:"hold_s"`
+	CameraFPS          float64 `yaml:"camera_fps"`
+	LidarHz            float64 `yaml:"lidar_hz"`
+}
+
+// FusionConfig configures the fusion ticker.
+type FusionConfig struct {
+	RateHz     int            `yaml:"rate_hz"`
+	BufferSize int            `yaml:"buffer_size"`
+	Heading    HeadingConfig  `yaml:"heading"`
+	Attitude   AttitudeConfig `yaml:"attitude"`
+	Align      AlignConfig    `yaml:"align"`
+	// IMUBatch carries every IMU sample of a fusion window in the fused
+	// record rather than only the last, see models.FusedRecord.IMUBatch.
+	IMUBatch bool `yaml:"imu_batch"`
+	// Stale sets, by sensor name, what a fused record carries for a
+	// sensor that sent nothing during its window; clear for the sensors
+	// left out.
+	Stale map[string]StaleConfig `yaml:"stale"`
+}
+
+// Policies of StaleConfig.
+const (
+	StaleClear       = "clear"
+	StaleHold        = "hold_last"
+	StaleInterpolate = "interpolate"
+)
+
+// StaleConfig is the policy of a sensor for the fused records it sent
+// nothing for: nothing (clear), its last sample while no older than
+// MaxAgeMs (hold_last), or a sample interpolated between the samples on
+// either side of the record when they are no more than MaxAgeMs apart
+// (interpolate, for gps, imu and env). Interpolation holds the fused
+// records back until the next sample, at most MaxAgeMs.
+type StaleConfig struct {
+	Policy   string `yaml:"policy"`
+	MaxAgeMs int    `yaml:"max_age_ms"`
+}
+
+// AlignConfig ticks the fusion at the multiples of its period from the
+// Unix epoch, PhaseMs later (less than a period), and stamps fused records with the tick, so
+// loggers with synchronized clocks fuse at the same instants; see
+// AlignedTicker.
+type AlignConfig struct {
+	Enabled bool `yaml:"enabled"`
+	PhaseMs int  `yaml:"phase_ms"`
+}
+
+// HeadingConfig adds a magnetometer heading column to fused.csv. Alpha is
+// the complementary-filter weight of the gyro-propagated heading against
+// the magnetic one; DeclinationDeg (east positive) overrides the
+// declination estimated from the GPS position.
+type HeadingConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Alpha          float64  `yaml:"alpha"`
+	DeclinationDeg *float64 `yaml:"declination_deg"`
+}
+
+// Filters of AttitudeConfig.
+const (
+	AttitudeMadgwick = "madgwick"
+	AttitudeMahony   = "mahony"
+)
+
+// AttitudeConfig adds the attitude of the vehicle to imu.csv, estimated
+// from every IMU sample by a Madgwick or Mahony filter. Beta is the
+// Madgwick gain, Kp and Ki the proportional and integral gains of Mahony.
+type AttitudeConfig struct {
+	Enabled bool    `yaml:"enabled"`
+	Filter  string  `yaml:"filter"`
+	Beta    float64 `yaml:"beta"`
+	Kp      float64 `yaml:"kp"`
+	Ki      float64 `yaml:"ki"`
+}
+
+// RemoteConfig configures the TCP listener that remote agents stream
+// their records to.
+type RemoteConfig struct {
+	Listen        string     `yaml:"listen"`
+	SyncIntervalS int        `yaml:"sync_interval_s"`
+	BufferSize    int        `yaml:"buffer_size"`
+	Auth          AuthConfig `yaml:"auth"`
+}
+
+// AgentConfig configures the agent subcommand, which forwards the local
+// sensors to a central logger's remote listener.
+type AgentConfig struct {
+	Server   string `yaml:"server"`
+	ID       string `yaml:"id"`
+	BufferMB int    `yaml:"buffer_mb"`
+
+	// Token (or the contents of TokenFile) is presented to a logger that
+	// requires authentication; TLS secures the link.
+	Token     string          `yaml:"token"`
+	TokenFile string          `yaml:"token_file"`
+	TLS       ClientTLSConfig `yaml:"tls"`
+}
+
+// Roles granted by AuthConfig. Read covers metrics, thumbnails and live
+// streams; control covers changing the logger's state or feeding it data.
+const (
+	RoleRead    = "read"
+	RoleControl = "control"
+)
+
+// AuthConfig protects a network surface. With neither tokens nor a client
+// CA configured the surface is open. Clients present one of Tokens or,
+// with TLS.ClientCA set, a certificate signed by it.
+type AuthConfig struct {
+	Tokens []TokenConfig `yaml:"tokens"`
+	TLS    TLSConfig     `yaml:"tls"`
+}
+
+// TokenConfig is a static bearer token, given inline or read from a file,
+// and the role it grants.
+type TokenConfig struct {
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+	Role      string `yaml:"role"`
+}
+
+// TLSConfig is the certificate a surface serves TLS with. ClientCA
+// additionally requires client certificates signed by it (mTLS); their
+// role is taken from the certificate's organizational unit, read unless
+// it is "control".
+type TLSConfig struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+// ClientTLSConfig is the client side of TLSConfig. CA verifies the server
+// (default: the system roots); Cert and Key are presented for mTLS.
+type ClientTLSConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	CA      string `yaml:"ca"`
+	Cert    string `yaml:"cert"`
+	Key     string `yaml:"key"`
+}
+
+// Enabled reports whether the surface requires authentication.
+func (a *AuthConfig) Enabled() bool {
+	return len(a.Tokens) > 0 || a.TLS.ClientCA != ""
+}
+
+func (a *AuthConfig) validate() error {
+	for i, t := range a.Tokens {
+		if (t.Token == "") == (t.TokenFile == "") {
+			return fmt.Errorf("tokens[%d]: set exactly one of token and token_file", i)
+		}
+		if t.Role != RoleRead && t.Role != RoleControl {
+			return fmt.Errorf("tokens[%d]: role must be read or control, got %q", i, t.Role)
+		}
+	}
+	if (a.TLS.Cert == "") != (a.TLS.Key == "") {
+		return errors.New("tls: cert and key go together")
+	}
+	if a.TLS.ClientCA != "" && a.TLS.Cert == "" {
+		return errors.New("tls: client_ca needs cert and key")
+	}
+	return nil
+}
+
+// ReadSecret returns value, or the trimmed contents of file when value is
+// empty.
+func ReadSecret(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// DefaultStopTimeoutS is the stop_timeout_s of storage.yaml when unset,
+// and bounds stopping the readers of an agent.
+const DefaultStopTimeoutS = 30
+
+// StorageConfig mirrors config/storage.yaml.
+type StorageConfig struct {
+	BaseDir         string `yaml:"base_dir"`
+	SaveFrames      bool   `yaml:"save_frames"`
+	SaveClouds      bool   `yaml:"save_clouds"`
+	FlushIntervalMs int    `yaml:"flush_interval_ms"`
+	OnWriteError    string `yaml:"on_write_error"`
+	FallbackDir     string `yaml:"fallback_dir"`
+
+	// SaveRadarDetections saves the raw detections of every radar scan
+	// that has them under radar_detections/, listed in
+	// radar_detections.csv; see models.RadarDetection.
+	SaveRadarDetections bool `yaml:"save_radar_detections"`
+
+	// RecordIDs adds the session UUID and an ID unique within the session
+	// to every row of the CSV files, so that rows can be told apart and
+	// joined across files and sessions; see views.RecordIDColumn.
+	RecordIDs bool `yaml:"record_ids"`
+
+	// CapturePackets also writes what a lidar on the network and an
+	// ARS 408 radar send, before it is decoded, to lidar.pcap and
+	// radar.pcap.
+	CapturePackets bool `yaml:"capture_packets"`
+
+	// StopTimeoutS bounds each step of stopping a session: the readers,
+	// fusion and outputs winding down, then the pending writes of the
+	// recording. A stage still running after it is reported in the log and
+	// the manifest, and the files are closed without it.
+	StopTimeoutS int `yaml:"stop_timeout_s"`
+
+	// FrameSync bounds write-back of saved frames and clouds: none, dsync,
+	// direct or paced (sync every SyncChunkMB).
+	FrameSync   string `yaml:"frame_sync"`
+	SyncChunkMB int    `yaml:"sync_chunk_mb"`
+
+	// FrameFormat is the format saved frames are stored in: jpeg as
+	// captured, webp (lossless), png or raw (I420), transcoded by
+	// FrameWorkers encoders.
+	FrameFormat  string `yaml:"frame_format"`
+	FrameWorkers int    `yaml:"frame_workers"`
+	// FrameEXIF embeds the capture time and the position, speed and
+	// course of the last GPS fix in every saved frame, which must be JPEG;
+	// only the time with LocationEncryption.
+	FrameEXIF bool `yaml:"frame_exif"`
+
+	FrameProcessing FrameProcessingConfig `yaml:"frame_processing"`
+
+	// FrameStore is where saved frames go instead of files under the
+	// session directory.
+	FrameStore FrameStoreConfig `yaml:"frame_store"`
+
+	// CloudCompression is how saved clouds are stored: none, deflate, zstd
+	// or quantized, which rounds coordinates to CloudStepMM first.
+	CloudCompression string  `yaml:"cloud_compression"`
+	CloudStepMM      float64 `yaml:"cloud_step_mm"`
+
+	// BinarySensors lists the sensors (imu, radar) logged to an indexed
+	// binary file instead of CSV, indexed every BinaryIndexIntervalMs.
+	BinarySensors         []string `yaml:"binary_sensors"`
+	BinaryIndexIntervalMs int      `yaml:"binary_index_interval_ms"`
+
+	// Tracks lists the formats (gpx, geojson) the GPS track is exported in
+	// when the session closes.
+	Tracks []string `yaml:"tracks"`
+
+	// Preview writes a small copy of each session for remote triage when
+	// it closes, see PreviewConfig.
+	Preview PreviewConfig `yaml:"preview"`
+
+	LocationEncryption LocationEncryptionConfig `yaml:"location_encryption"`
+
+	Units UnitsConfig `yaml:"units"`
+
+	// Projection adds the position of every fix in a flat frame to
+	// gps.csv and fused.csv: ProjectionUTM or ProjectionENU, "" for none.
+	Projection string `yaml:"projection"`
+
+	// Sinks lists the formats the sensor and fused records are written
+	// in; the csv sink is required.
+	Sinks []SinkConfig `yaml:"sinks"`
+
+	// Tags label every session in its manifest, e.g. [vehicle-3]; -tags
+	// adds to them. See CleanTags.
+	Tags []string `yaml:"tags"`
+
+	// CustomColumns are constant columns added to every row of fused.csv
+	// and listed in the manifest, e.g. vehicle_id: truck-07, so that the
+	// rows of a fleet can be joined by them. They follow the columns of
+	// the fused record in the order of CustomColumnNames.
+	CustomColumns map[string]string `yaml:"custom_columns"`
+
+	Transform  TransformConfig  `yaml:"transform"`
+	ZMQ        ZMQConfig        `yaml:"zmq"`
+	Thumbnails ThumbnailsConfig `yaml:"thumbnails"`
+	Foxglove   FoxgloveConfig   `yaml:"foxglove"`
+
+	FusedOutputs []FusedOutputConfig `yaml:"fused_outputs"`
+
+	// Stages orders the consumers of the raw samples, see StageRecording,
+	// and the Processors placed among them.
+	Stages     []string          `yaml:"stages"`
+	Processors []ProcessorConfig `yaml:"processors"`
+
+	RadarGrid RadarGridConfig `yaml:"radar_grid"`
+
+	Hooks HooksConfig `yaml:"hooks"`
+	Pack  PackConfig  `yaml:"pack"`
+
+	Validation ValidationConfig `yaml:"validation"`
+	Clock      ClockConfig      `yaml:"clock"`
+
+	SystemStats SystemStatsConfig `yaml:"system_stats"`
+
+	Preflight PreflightConfig `yaml:"preflight"`
+	Resume    ResumeConfig    `yaml:"resume"`
+	Degrade   DegradeConfig   `yaml:"degrade"`
+
+	// Power is process-wide: with several pipelines, the first one's
+	// applies.
+	Power PowerConfig `yaml:"power"`
+
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+	Alerts    AlertsConfig    `yaml:"alerts"`
+
+	// Auth protects the HTTP server (-http-addr) and the ZeroMQ stream.
+	Auth AuthConfig `yaml:"auth"`
+
+	// DryRun, set by -dry-run, counts what would be recorded instead of
+	// writing the session.
+	DryRun bool `yaml:"-"`
+}
+
+// CleanTags splits comma-separated tags, lowercases and trims them, and
+// drops empty and repeated ones, keeping the order they came in.
+func CleanTags(tags []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range tags {
+		for _, tag := range strings.Split(t, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				out = append(out, tag)
+			}
+		}
+	}
+	return out
+}
+
+// PreflightConfig checks before recording that the devices of the enabled
+// sensors are present and that base_dir can take a session of DurationMin
+// (or -duration) at the data rate estimated from the sensor config: it
+// needs Margin times the estimated size free and must sustain Margin times
+// the rate in a WriteTestMB write test (0 skips the test).
+type PreflightConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	WriteTestMB int     `yaml:"write_test_mb"`
+	DurationMin float64 `yaml:"duration_min"`
+	Margin      float64 `yaml:"margin"`
+}
+
+// ResumeConfig continues the last session instead of starting a new one
+// when it did not close cleanly and was written to less than WindowS ago,
+// so a logger restarted after a crash or power cut keeps a drive in one
+// session.
+type ResumeConfig struct {
+	Enabled bool `yaml:"enabled"`
+	WindowS int  `yaml:"window_s"`
+}
+
+// SystemStatsConfig samples the CPU and memory use of the logger and the
+// host's disk queue and temperature to system.csv every IntervalS.
+type SystemStatsConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	IntervalS int  `yaml:"interval_s"`
+}
+
+// LocationEncryptionConfig encrypts the latitude and longitude columns
+// of gps.csv and fused.csv, in every sink, with a key drawn for each
+// session. That key is kept in the session encrypted with the group key
+// in KeyFile (32 bytes in hex), so that only those given the group key
+// can place the recordings; the other columns stay in the clear.
+type LocationEncryptionConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	KeyFile string `yaml:"key_file"`
+}
+
+func (c *LocationEncryptionConfig) applyDefaults(tracks []string) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.KeyFile == "" {
+		return errors.New("key_file is required")
+	}
+	if len(tracks) > 0 {
+		return errors.New("tracks would export the positions in the clear, leave it empty")
+	}
+	return nil
+}
+
+// Projections of StorageConfig.Projection. UTM is in the zone of the first
+// fix of the session throughout, so that a session crossing into the next
+// zone stays continuous; ENU is east and north in metres of the first fix.
+const (
+	ProjectionUTM = "utm"
+	ProjectionENU = "enu"
+)
+
+// Units of UnitsConfig.
+const (
+	UnitMps = "m/s"
+	UnitKmh = "km/h"
+	UnitDeg = "deg"
+	UnitRad = "rad"
+)
+
+// UnitsConfig sets the units of the speeds (m/s or km/h) and angles (deg
+// or rad) of the session records, in every sink, and the decimals of
+// their latitudes and longitudes. The columns keep their names, e.g.
+// speed_mps in km/h; the manifest records the units the session was
+// written in. Rates of turn (IMU gx, gy, gz) stay in rad/s.
+type UnitsConfig struct {
+	Speed          string `yaml:"speed" json:"speed"`
+	Angle          string `yaml:"angle" json:"angle"`
+	LatLonDecimals int    `yaml:"lat_lon_decimals" json:"lat_lon_decimals"`
+}
+
+// DefaultUnits are the units of the models, and of sessions recorded
+// before the units could be set.
+var DefaultUnits = UnitsConfig{Speed: UnitMps, Angle: UnitDeg, LatLonDecimals: 8}
+
+func (u *UnitsConfig) applyDefaults() error {
+	if u.Speed == "" {
+		u.Speed = DefaultUnits.Speed
+	}
+	if u.Angle == "" {
+		u.Angle = DefaultUnits.Angle
+	}
+	if u.LatLonDecimals == 0 {
+		u.LatLonDecimals = DefaultUnits.LatLonDecimals
+	}
+	if u.Speed != UnitMps && u.Speed != UnitKmh {
+		return fmt.Errorf("speed must be %s or %s", UnitMps, UnitKmh)
+	}
+	if u.Angle != UnitDeg && u.Angle != UnitRad {
+		return fmt.Errorf("angle must be %s or %s", UnitDeg, UnitRad)
+	}
+	if u.LatLonDecimals < 1 || u.LatLonDecimals > DefaultUnits.LatLonDecimals {
+		return fmt.Errorf("lat_lon_decimals must be 1-%d", DefaultUnits.LatLonDecimals)
+	}
+	return nil
+}
+
+// FrameProcessingConfig crops saved frames to ROI and scales them down to
+// at most MaxWidth pixels wide, on the FrameWorkers encoders; frames in
+// format jpeg are re-encoded at Quality. Frames are saved as captured while
+// full resolution is asked for: for FullResS after an event of a kind
+// listed in FullResOn, or over HTTP.
+type FrameProcessingConfig struct {
+	Enabled   bool      `yaml:"enabled"`
+	ROI       ROIConfig `yaml:"roi"`
+	MaxWidth  int       `yaml:"max_width"`
+	Quality   int       `yaml:"quality"`
+	FullResS  int       `yaml:"full_res_s"`
+	FullResOn []string  `yaml:"full_res_on"`
+}
+
+// ROIConfig is a region of a frame in pixels from its top left corner; a
+// zero Width or Height extends it to the edge of the frame.
+type ROIConfig struct {
+	X      int `yaml:"x" json:"x"`
+	Y      int `yaml:"y" json:"y"`
+	Width  int `yaml:"width" json:"width"`
+	Height int `yaml:"height" json:"height"`
+}
+
+// Rect returns the region as a rectangle, extended far beyond any frame
+// where it reaches the edge.
+func (r ROIConfig) Rect() image.Rectangle {
+	const edge = 1 << 30
+	w, h := r.Width, r.Height
+	if w == 0 {
+		w = edge
+	}
+	if h == 0 {
+		h = edge
+	}
+	return image.Rect(r.X, r.Y, r.X+w, r.Y+h)
+}
+
+// Binary reports whether sensor is logged in the binary record format.
+func (cfg *StorageConfig) Binary(sensor string) bool {
+	return slices.Contains(cfg.BinarySensors, sensor)
+}
+
+// CustomColumnNames returns the names of cfg.CustomColumns, sorted.
+func (cfg *StorageConfig) CustomColumnNames() []string {
+	names := make([]string, 0, len(cfg.CustomColumns))
+	for name := range cfg.CustomColumns {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// customColumnName is what a custom column can be named.
+var customColumnName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// FrameStoreConfig selects how saved frames are kept: as files under the
+// session directory (dir), as members of frames.tar in it (tar), or as
+// objects of an S3 bucket under <prefix>/<session>/ (s3). The paths in
+// camera.csv are the same for all three.
+type FrameStoreConfig struct {
+	Type string   `yaml:"type"`
+	S3   S3Config `yaml:"s3"`
+}
+
+// Frame store types, see FrameStoreConfig.
+const (
+	FrameStoreDir = "dir"
+	FrameStoreTar = "tar"
+	FrameStoreS3  = "s3"
+)
+
+// S3Config uploads frames to Bucket of an S3-compatible service at
+// Endpoint (e.g. https://s3.eu-central-1.amazonaws.com or a MinIO server),
+// addressed by path and signed with the access key (Signature V4);
+// without one the uploads are anonymous. At most MaxPending uploads are
+// in flight, each bounded by TimeoutS: frames beyond them are not saved.
+type S3Config struct {
+	Endpoint            string `yaml:"endpoint"`
+	Region              string `yaml:"region"`
+	Bucket              string `yaml:"bucket"`
+	Prefix              string `yaml:"prefix"`
+	AccessKeyID         string `yaml:"access_key_id"`
+	SecretAccessKey     string `yaml:"secret_access_key"`
+	SecretAccessKeyFile string `yaml:"secret_access_key_file"`
+	MaxPending          int    `yaml:"max_pending"`
+	TimeoutS            int    `yaml:"timeout_s"`
+}
+
+// Policies for a failed write, see StorageConfig.OnWriteError.
+const (
+	// WriteErrorContinue keeps recording the files that still work.
+	WriteErrorContinue = "continue"
+	// WriteErrorAbort stops the run on the first failed write.
+	WriteErrorAbort = "abort"
+)
+
+// ValidationConfig flags physically impossible records (negative HDOP,
+// accelerations beyond 16 g, ...) and counts them per sensor in the
+// manifest. Action decides what else happens to them.
+type ValidationConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Action  string `yaml:"action"`
+}
+
+// Actions of ValidationConfig.
+const (
+	// ValidationCount only counts invalid records.
+	ValidationCount = "count"
+	// ValidationMark adds a valid column (1 or 0) to the sensor CSVs and
+	// fused.csv.
+	ValidationMark = "mark"
+	// ValidationDrop leaves invalid records out of the session files,
+	// fused.csv included.
+	ValidationDrop = "drop"
+)
+
+// ClockConfig sets how timestamps handle a step of the wall clock (NTP,
+// a manual date change) larger than JumpMs between two samples, detected
+// against the monotonic clock; see SetClockGuard. The clocks of the
+// sensors that stamp their samples are compared with the host clock every
+// SyncIntervalS, see models.ClockOffset.
+type ClockConfig struct {
+	Mode          string `yaml:"mode"`
+	JumpMs        int    `yaml:"jump_ms"`
+	SyncIntervalS int    `yaml:"sync_interval_s"`
+}
+
+// Clock modes, see ClockConfig.Mode.
+const (
+	// ClockLog only logs and records the jumps.
+	ClockLog = "log"
+	// ClockCorrect keeps stamping from the monotonic clock across a jump,
+	// so timestamps stay in order and spaced as they were taken.
+	ClockCorrect = "correct"
+	// ClockFlag keeps the wall clock and adds a clock_event column to the
+	// sensor CSVs and fused.csv, set on the first record after a jump.
+	ClockFlag = "flag"
+)
+
+// Built-in stages of StorageConfig.Stages, which consume the raw samples
+// fusion hands on. A processor listed among them changes or drops the
+// samples on their way to the stages after it.
+const (
+	StageRecording  = "recording"
+	StageZMQ        = "zmq"
+	StageThumbnails = "thumbnails"
+	StageFoxglove   = "foxglove"
+	StageTelemetry  = "telemetry"
+)
+
+// DefaultStages is the order of the stages when none is configured.
+var DefaultStages = []string{StageRecording, StageZMQ, StageThumbnails, StageFoxglove, StageTelemetry}
+
+// Types of ProcessorConfig built into the logger.
+const (
+	ProcessorPrivacyZone  = "privacy_zone"
+	ProcessorRadarOverlay = "radar_overlay"
+	ProcessorLidarDepth   = "lidar_depth"
+)
+
+// ProcessorConfig declares a processor, placed in StorageConfig.Stages by
+// Name.
+type ProcessorConfig struct {
+	Name         string             `yaml:"name"`
+	Type         string             `yaml:"type"`
+	PrivacyZone  PrivacyZoneConfig  `yaml:"privacy_zone"`
+	RadarOverlay RadarOverlayConfig `yaml:"radar_overlay"`
+	LidarDepth   LidarDepthConfig   `yaml:"lidar_depth"`
+}
+
+// PrivacyZoneConfig drops the samples of Sensors while the vehicle is
+// within one of Zones, judged by the last GPS fix, and until the first.
+type PrivacyZoneConfig struct {
+	Sensors []string     `yaml:"sensors"`
+	Zones   []ZoneConfig `yaml:"zones"`
+}
+
+// RadarOverlayConfig draws the radar targets onto a camera frame every
+// IntervalS, using the latest scan if it is at most MaxAgeMs apart from
+// the frame. Save writes the overlays to the session besides serving the
+// latest one.
+type RadarOverlayConfig struct {
+	IntervalS float64 `yaml:"interval_s"`
+	MaxAgeMs  int     `yaml:"max_age_ms"`
+	Quality   int     `yaml:"quality"`
+	Save      bool    `yaml:"save"`
+}
+
+// LidarDepthConfig projects each lidar sweep into the first camera frame
+// at most MaxAgeMs from it.
+type LidarDepthConfig struct {
+	MaxAgeMs int `yaml:"max_age_ms"`
+}
+
+// ZoneConfig is a circle of RadiusM metres around Lat, Lon.
+type ZoneConfig struct {
+	Name    string  `yaml:"name"`
+	Lat     float64 `yaml:"lat"`
+	Lon     float64 `yaml:"lon"`
+	RadiusM float64 `yaml:"radius_m"`
+}
+
+// Sinks of fused records.
+const (
+	FusedSinkCSV   = "csv"
+	FusedSinkMQTT  = "mqtt"
+	FusedSinkArrow = "arrow"
+)
+
+// Session sinks, see StorageConfig.Sinks.
+const (
+	SinkCSV     = "csv"
+	SinkJSONL   = "jsonl"
+	SinkParquet = "parquet"
+	SinkSQLite  = "sqlite"
+	SinkMCAP    = "mcap"
+	SinkKafka   = "kafka"
+)
+
+// DefaultRowGroupRows is the RowGroupRows of a parquet sink that sets
+// none.
+const DefaultRowGroupRows = 16384
+
+// SinkConfig is one format of the session records. RowGroupRows is the
+// rows of a Parquet row group, held in memory until written.
+type SinkConfig struct {
+	Type         string      `yaml:"type"`
+	RowGroupRows int         `yaml:"row_group_rows"`
+	Kafka        KafkaConfig `yaml:"kafka"`
+}
+
+// KafkaConfig configures producing the session records to a Kafka topic
+// as JSON, keyed by sensor. TimeoutS bounds each request to a broker.
+type KafkaConfig struct {
+	Brokers  []string `yaml:"brokers"`
+	Topic    string   `yaml:"topic"`
+	ClientID string   `yaml:"client_id"`
+	TimeoutS int      `yaml:"timeout_s"`
+}
+
+// FusedOutputConfig is one consumer of fused records. RateHz decimates
+// the fusion rate (0 = every record). Exactly one output must use the csv
+// sink, which is the session's fused.csv.
+type FusedOutputConfig struct {
+	Name       string      `yaml:"name"`
+	Sink       string      `yaml:"sink"`
+	RateHz     int         `yaml:"rate_hz"`
+	BufferSize int         `yaml:"buffer_size"`
+	MQTT       MQTTConfig  `yaml:"mqtt"`
+	Arrow      ArrowConfig `yaml:"arrow"`
+}
+
+// ArrowConfig serves fused records as an Apache Arrow IPC stream to every
+// client connecting to Listen: the schema, then a record batch of up to
+// BatchRows records at least every FlushMs. Queue bounds the batches held
+// for a slow client, which loses the ones beyond it.
+type ArrowConfig struct {
+	Listen    string `yaml:"listen"`
+	BatchRows int    `yaml:"batch_rows"`
+	FlushMs   int    `yaml:"flush_ms"`
+	Queue     int    `yaml:"queue"`
+}
+
+// MQTTConfig configures publishing to an MQTT broker at QoS 0.
+type MQTTConfig struct {
+	Broker     string `yaml:"broker"`
+	Topic      string `yaml:"topic"`
+	ClientID   string `yaml:"client_id"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	KeepAliveS int    `yaml:"keepalive_s"`
+	Retain     bool   `yaml:"retain"`
+}
+
+// TransformConfig selects which measurements are additionally written in
+// the vehicle or world (ENU) frame. Deskew corrects saved lidar sweeps for
+// the ego motion during the sweep.
+type TransformConfig struct {
+	Frame  string `yaml:"frame"`
+	Lidar  bool   `yaml:"lidar"`
+	Radar  bool   `yaml:"radar"`
+	Deskew bool   `yaml:"deskew"`
+}
+
+// ZMQConfig configures live republishing of the raw sensor streams on a
+// ZeroMQ PUB socket, one topic per sensor. HWM bounds the messages queued
+// per subscriber.
+type ZMQConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Endpoint     string `yaml:"endpoint"`
+	IncludeBlobs bool   `yaml:"include_blobs"`
+	HWM          int    `yaml:"hwm"`
+}
+
+// FoxgloveConfig configures the Foxglove WebSocket bridge served at
+// /foxglove with -http-addr. Queue bounds the messages queued per client.
+type FoxgloveConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Queue   int  `yaml:"queue"`
+}
+
+// ThumbnailsConfig configures the in-memory ring of recent camera
+// thumbnails served over HTTP. Frames are sampled at RateHz, scaled to fit
+// Width×Height and kept for Seconds.
+type ThumbnailsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Width   int  `yaml:"width"`
+	Height  int  `yaml:"height"`
+	RateHz  int  `yaml:"rate_hz"`
+	Seconds int  `yaml:"seconds"`
+	Quality int  `yaml:"quality"`
+}
+
+// PreviewConfig configures the preview of a session: fused.csv thinned to
+// RateHz, a video of the camera at FPS frames per second scaled to Width,
+// and the GPS track simplified to within ToleranceM metres.
+type PreviewConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	RateHz     float64 `yaml:"rate_hz"`
+	FPS        float64 `yaml:"fps"`
+	Width      int     `yaml:"width"`
+	Quality    int     `yaml:"quality"`
+	ToleranceM float64 `yaml:"tolerance_m"`
+}
+
+// DefaultPreview holds the preview settings left unset in storage.yaml,
+// and those of "sensor-logger export -format preview".
+var DefaultPreview = PreviewConfig{RateHz: 1, FPS: 1, Width: 320, Quality: 60, ToleranceM: 5}
+
+// Steps of DegradeConfig.
+const (
+	DegradeJPEGQuality     = "jpeg_quality"
+	DegradeAlternateFrames = "alternate_frames"
+	DegradeNoClouds        = "no_clouds"
+)
+
+// DegradeConfig sheds saved data while the disk cannot keep up. Every
+// WindowS the 95th percentile of the frame and cloud write latencies is
+// taken: above LatencyMs the next of Steps takes effect, below half of it
+// the last one taken is lifted. The steps re-encode JPEG frames at
+// JPEGQuality, save every other frame and stop saving lidar clouds.
+type DegradeConfig struct {
+	Enabled     bool     `yaml:"enabled"`
+	Steps       []string `yaml:"steps"`
+	LatencyMs   int      `yaml:"latency_ms"`
+	WindowS     float64  `yaml:"window_s"`
+	JPEGQuality int      `yaml:"jpeg_quality"`
+}
+
+func (d *DegradeConfig) applyDefaults() error {
+	if d.Steps == nil {
+		d.Steps = []string{DegradeJPEGQuality, DegradeAlternateFrames, DegradeNoClouds}
+	}
+	for i, step := range d.Steps {
+		if !slices.Contains([]string{DegradeJPEGQuality, DegradeAlternateFrames, DegradeNoClouds}, step) {
+			return fmt.Errorf("steps: unknown step %q", step)
+		}
+		if slices.Contains(d.Steps[:i], step) {
+			return fmt.Errorf("steps: %s listed twice", step)
+		}
+	}
+	if d.LatencyMs == 0 {
+		d.LatencyMs = 500
+	}
+	if d.WindowS == 0 {
+		d.WindowS = 10
+	}
+	if d.JPEGQuality == 0 {
+		d.JPEGQuality = 50
+	}
+	if d.LatencyMs < 0 || d.WindowS < 0 || d.JPEGQuality < 1 || d.JPEGQuality > 100 {
+		return errors.New("latency_ms and window_s must be positive and jpeg_quality between 1 and 100")
+	}
+	return nil
+}
+
+// PowerConfig watches the supply voltage of the logger's computer, read
+// from a UPS HAT or a vehicle voltage monitor on the serial port Device,
+// which prints one reading per line ("12.31" or "V=12.31"); "sim" reads a
+// steady supply. Below WarnV a power_low event is recorded. Once the
+// voltage has stayed below LowV for HoldS the sessions are closed as on
+// SIGTERM and, with Shutdown, ShutdownCommand is run after them.
+type PowerConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Device          string   `yaml:"device"`
+	Baud            int      `yaml:"baud"`
+	WarnV           float64  `yaml:"warn_v"`
+	LowV            float64  `yaml:"low_v"`
+	HoldS           float64  `yaml:"hold_s"`
+	Shutdown        bool     `yaml:"shutdown"`
+	ShutdownCommand []string `yaml:"shutdown_command"`
+}
+
+func (c *PowerConfig) applyDefaults() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Device == "" {
+		return errors.New("device is required")
+	}
+	if c.Baud == 0 {
+		c.Baud = 9600
+	}
+	if c.HoldS == 0 {
+		c.HoldS = 5
+	}
+	if c.WarnV == 0 {
+		c.WarnV = c.LowV
+	}
+	if len(c.ShutdownCommand) == 0 {
+		c.ShutdownCommand = []string{"systemctl", "poweroff"}
+	}
+	if c.LowV <= 0 || c.WarnV < c.LowV || c.HoldS < 0 {
+		return errors.New("low_v must be positive, warn_v at least low_v and hold_s not negative")
+	}
+	return nil
+}
+
+// Transports of TelemetryConfig.
+const (
+	TelemetryHTTP = "http"
+	TelemetryMQTT = "mqtt"
+)
+
+// TelemetryConfig configures the uplink of status reports, every
+// StatusIntervalS, and GPS breadcrumbs, every PositionIntervalS, to a
+// fleet server: POSTed in batches of up to Batch to URL over http, or
+// published one by one through MQTT. Messages not yet delivered wait in
+// the Spool file, bounded to SpoolKB, oldest dropped first, and are
+// retried with a backoff from RetryS up to 5 minutes, across restarts.
+type TelemetryConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Transport string `yaml:"transport"`
+	// Vehicle names the logger in every message; the host name by
+	// default.
+	Vehicle string `yaml:"vehicle"`
+
+	URL       string          `yaml:"url"`
+	Token     string          `yaml:"token"`
+	TokenFile string          `yaml:"token_file"`
+	TLS       ClientTLSConfig `yaml:"tls"`
+	MQTT      MQTTConfig      `yaml:"mqtt"`
+
+	StatusIntervalS   int    `yaml:"status_interval_s"`
+	PositionIntervalS int    `yaml:"position_interval_s"`
+	Batch             int    `yaml:"batch"`
+	RetryS            int    `yaml:"retry_s"`
+	Spool             string `yaml:"spool"`
+	SpoolKB           int    `yaml:"spool_kb"`
+}
+
+// applyDefaults fills in the telemetry settings left unset; the spool
+// defaults to telemetry.spool in baseDir.
+func (c *TelemetryConfig) applyDefaults(baseDir string) error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.Transport {
+	case "", TelemetryHTTP:
+		c.Transport = TelemetryHTTP
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return fmt.Errorf("url must be an http:// or https:// URL, got %q", c.URL)
+		}
+	case TelemetryMQTT:
+		if c.MQTT.Broker == "" {
+			return errors.New("mqtt.broker is required")
+		}
+		if c.MQTT.Topic == "" {
+			c.MQTT.Topic = "sensor-logger/telemetry"
+		}
+		if c.MQTT.KeepAliveS == 0 {
+			c.MQTT.KeepAliveS = 30
+		}
+	default:
+		return fmt.Errorf("transport must be http or mqtt, got %q", c.Transport)
+	}
+	if c.Vehicle == "" {
+		c.Vehicle, _ = os.Hostname()
+	}
+	if c.MQTT.ClientID == "" {
+		c.MQTT.ClientID = "sensor-logger-telemetry-" + c.Vehicle
+	}
+	if c.StatusIntervalS == 0 {
+		c.StatusIntervalS = 30
+	}
+	if c.PositionIntervalS == 0 {
+		c.PositionIntervalS = 5
+	}
+	if c.Batch == 0 {
+		c.Batch = 100
+	}
+	if c.RetryS == 0 {
+		c.RetryS = 10
+	}
+	if c.Spool == "" {
+		c.Spool = filepath.Join(baseDir, "telemetry.spool")
+	}
+	if c.SpoolKB == 0 {
+		c.SpoolKB = 1024
+	}
+	if c.StatusIntervalS < 0 || c.PositionIntervalS < 0 || c.Batch < 0 || c.RetryS < 0 || c.SpoolKB < 0 {
+		return errors.New("status_interval_s, position_interval_s, batch, retry_s and spool_kb must be positive")
+	}
+	return nil
+}
+
+// Types of AlertRuleConfig.
+const (
+	AlertSpeed    = "speed"
+	AlertGeofence = "geofence"
+	AlertStall    = "stall"
+)
+
+// AlertsConfig watches the fused records, at up to RateHz, for the
+// conditions of Rules. A rule whose condition has held for its for_s is
+// raised as an alert event, logged and written to events.csv, and cleared
+// once it no longer holds. Both are also published to MQTT and POSTed to
+// Webhook when those are set.
+type AlertsConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	RateHz  int               `yaml:"rate_hz"`
+	Rules   []AlertRuleConfig `yaml:"rules"`
+	MQTT    MQTTConfig        `yaml:"mqtt"`
+	Webhook WebhookConfig     `yaml:"webhook"`
+}
+
+// AlertRuleConfig is one condition of AlertsConfig: the GPS speed above
+// AboveMps (speed), the last fix outside every polygon of the GeoJSON file
+// Zones (geofence), or no sample of Sensor for ForS (stall).
+type AlertRuleConfig struct {
+	Name     string  `yaml:"name"`
+	Type     string  `yaml:"type"`
+	AboveMps float64 `yaml:"above_mps"`
+	Zones    string  `yaml:"zones"`
+	Sensor   string  `yaml:"sensor"`
+	ForS     float64 `yaml:"for_s"`
+}
+
+// WebhookConfig POSTs JSON to URL, with Token as a bearer token when set.
+type WebhookConfig struct {
+	URL      string `yaml:"url"`
+	Token    string `yaml:"token"`
+	TimeoutS int    `yaml:"timeout_s"`
+}
+
+// applyDefaults fills in the alert settings left unset and checks the
+// rules.
+func (c *AlertsConfig) applyDefaults() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.RateHz == 0 {
+		c.RateHz = 10
+	}
+	if c.RateHz < 0 || c.RateHz > MaxRateHz {
+		return fmt.Errorf("rate_hz must be between 1 and %d, got %d", MaxRateHz, c.RateHz)
+	}
+	if len(c.Rules) == 0 {
+		return errors.New("rules are required")
+	}
+	names := map[string]bool{}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("%s-%d", r.Type, i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("rule name %q is taken", r.Name)
+		}
+		names[r.Name] = true
+		if r.ForS < 0 {
+			return fmt.Errorf("rule %s: for_s must not be negative, got %g", r.Name, r.ForS)
+		}
+		switch r.Type {
+		case AlertSpeed:
+			if r.AboveMps <= 0 {
+				return fmt.Errorf("rule %s: above_mps must be positive, got %g", r.Name, r.AboveMps)
+			}
+		case AlertGeofence:
+			if r.Zones == "" {
+				return fmt.Errorf("rule %s: zones is required", r.Name)
+			}
+		case AlertStall:
+			if r.Sensor == "" {
+				return fmt.Errorf("rule %s: sensor is required", r.Name)
+			}
+			if r.ForS == 0 {
+				r.ForS = 5
+			}
+		default:
+			return fmt.Errorf("rule %s: type must be speed, geofence or stall, got %q", r.Name, r.Type)
+		}
+	}
+	if c.MQTT.Broker != "" {
+		if c.MQTT.Topic == "" {
+			c.MQTT.Topic = "sensor-logger/alerts"
+		}
+		if c.MQTT.ClientID == "" {
+			host, _ := os.Hostname()
+			c.MQTT.ClientID = "sensor-logger-alerts-" + host
+		}
+		if c.MQTT.KeepAliveS == 0 {
+			c.MQTT.KeepAliveS = 30
+		}
+	}
+	if c.Webhook.URL != "" {
+		if !strings.HasPrefix(c.Webhook.URL, "http://") && !strings.HasPrefix(c.Webhook.URL, "https://") {
+			return fmt.Errorf("webhook.url must be an http:// or https:// URL, got %q", c.Webhook.URL)
+		}
+		if c.Webhook.TimeoutS == 0 {
+			c.Webhook.TimeoutS = 5
+		}
+		if c.Webhook.TimeoutS < 0 {
+			return fmt.Errorf("webhook.timeout_s must be positive, got %d", c.Webhook.TimeoutS)
+		}
+	}
+	return nil
+}
+
+// Projections, values and formats of RadarGridConfig.
+const (
+	GridCartesian = "cartesian"
+	GridPolar     = "polar"
+	GridOccupancy = "occupancy"
+	GridIntensity = "intensity"
+	GridPNG       = "png"
+	GridNPY       = "npy"
+)
+
+// RadarGridConfig configures the radar grid saved per fused.csv row:
+// detections within RangeM and FOVDeg of the radar, counted (occupancy)
+// or summed as linear RCS (intensity), in CellM cells or, for the polar
+// projection, CellM range bins by AzimuthStepDeg.
+type RadarGridConfig struct {
+	Enabled        bool    `yaml:"enabled"`
+	Projection     string  `yaml:"projection"`
+	Value          string  `yaml:"value"`
+	Format         string  `yaml:"format"`
+	CellM          float64 `yaml:"cell_m"`
+	RangeM         float64 `yaml:"range_m"`
+	FOVDeg         float64 `yaml:"fov_deg"`
+	AzimuthStepDeg float64 `yaml:"azimuth_step_deg"`
+}
+
+func (g *RadarGridConfig) applyDefaults() error {
+	for _, d := range []struct {
+		v       *string
+		def     string
+		allowed []string
+		name    string
+	}{
+		{&g.Projection, GridCartesian, []string{GridCartesian, GridPolar}, "projection"},
+		{&g.Value, GridOccupancy, []string{GridOccupancy, GridIntensity}, "value"},
+		{&g.Format, GridPNG, []string{GridPNG, GridNPY}, "format"},
+	} {
+		if *d.v == "" {
+			*d.v = d.def
+		}
+		if !slices.Contains(d.allowed, *d.v) {
+			return fmt.Errorf("%s must be one of %s, got %q", d.name, strings.Join(d.allowed, ", "), *d.v)
+		}
+	}
+	for _, d := range []struct {
+		v   *float64
+		def float64
+	}{{&g.CellM, 0.5}, {&g.RangeM, 50}, {&g.FOVDeg, 120}, {&g.AzimuthStepDeg, 2}} {
+		if *d.v == 0 {
+			*d.v = d.def
+		}
+	}
+	if g.CellM < 0 || g.RangeM < 0 || g.AzimuthStepDeg < 0 || g.FOVDeg < 0 || g.FOVDeg > 360 {
+		return errors.New("cell_m, range_m and azimuth_step_deg must be positive and fov_deg between 0 and 360")
+	}
+	if g.RangeM/g.CellM > 4096 || g.FOVDeg/g.AzimuthStepDeg > 4096 {
+		return errors.New("grid larger than 4096 cells a side")
+	}
+	return nil
+}
+
+// HooksConfig lists the commands run on every closed session, in order.
+// At most Concurrency sessions are processed at once; TimeoutS (0 = none)
+// bounds each command.
+type HooksConfig struct {
+	Concurrency int          `yaml:"concurrency"`
+	TimeoutS    int          `yaml:"timeout_s"`
+	Commands    []HookConfig `yaml:"commands"`
+}
+
+// PackConfig bundles every session into one archive in Dir (default: the
+// directory of the session) when it closes, after the hooks ran, as
+// "sessions pack" does.
+// Format is tar.zst or zip. With Remove the session directory is deleted
+// once its archive checked out.
+type PackConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Format  string `yaml:"format"`
+	Dir     string `yaml:"dir"`
+	Remove  bool   `yaml:"remove"`
+}
+
+// HookConfig is one post-processing command. "{session}" in Command is
+// replaced by the session directory.
+type HookConfig struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+}
+
+// LoadSensorsConfig reads and defaults the sensors config at path with
+// the named profile applied, none when profile is empty.
+func LoadSensorsConfig(path, profile string) (*SensorsConfig, error) {
+	doc, p, err := loadSensorsYAML(path, profile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &SensorsConfig{}
+	if err := doc.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	cfg.Profile = p
+	cfg.applyDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Calibration.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: calibration: %w", path, err)
+	}
+	if a := cfg.Adaptive; a.Enabled && a.MovingSpeedMps < a.StationarySpeedMps {
+		return nil, fmt.Errorf("%s: adaptive: moving_speed_mps must not be below stationary_speed_mps", path)
+	}
+	return cfg, nil
+}
+
+// DefaultSensorsConfig returns the config of an empty sensors.yaml: no
+// sensor enabled, everything else at its default.
+func DefaultSensorsConfig() *SensorsConfig {
+	cfg := &SensorsConfig{}
+	cfg.applyDefaults()
+	return cfg
+}
+
+// LoadCalibration reads a calib.yaml written into a session directory.
+func LoadCalibration(path string) (*CalibrationConfig, error) {
+	cal := &CalibrationConfig{}
+	if err := loadYAML(path, cal); err != nil {
+		return nil, err
+	}
+	if err := cal.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cal, nil
+}
+
+// PipelineConfig is one of the pipelines run side by side with -pipelines:
+// a name, unique among them, and the sensors and storage configs of the
+// pipeline, with Profile applied.
+type PipelineConfig struct {
+	Name    string `yaml:"name"`
+	Sensors string `yaml:"sensors"`
+	Storage string `yaml:"storage"`
+	Profile string `yaml:"profile"`
+}
+
+// pipelineName is what a pipeline can be named: it prefixes HTTP paths.
+var pipelineName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// LoadPipelinesConfig reads the pipelines listed under pipelines in the
+// file at path.
+func LoadPipelinesConfig(path string) ([]PipelineConfig, error) {
+	var doc struct {
+		Pipelines []PipelineConfig `yaml:"pipelines"`
+	}
+	if err := loadYAML(path, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Pipelines) == 0 {
+		return nil, fmt.Errorf("%s: no pipelines", path)
+	}
+	seen := map[string]bool{}
+	for i, p := range doc.Pipelines {
+		switch {
+		case !pipelineName.MatchString(p.Name):
+			return nil, fmt.Errorf("%s: pipeline %d: name must be lowercase letters, digits, - and _, got %q", path, i, p.Name)
+		case seen[p.Name]:
+			return nil, fmt.Errorf("%s: pipeline %s is listed twice", path, p.Name)
+		case p.Sensors == "" || p.Storage == "":
+			return nil, fmt.Errorf("%s: pipeline %s: sensors and storage are required", path, p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return doc.Pipelines, nil
+}
+
+// LoadStorageConfig reads and defaults the storage config at path with
+// the storage overrides of profile applied.
+func LoadStorageConfig(path string, profile Profile) (*StorageConfig, error) {
+	doc, err := readYAML(path)
+	if err != nil {
+		return nil, err
+	}
+	if profile.storage != nil {
+		mergeYAML(doc, profile.storage)
+	}
+	cfg := &StorageConfig{}
+	if err := doc.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if cfg.BaseDir == "" {
+		cfg.BaseDir = "data"
+	}
+	if cfg.FlushIntervalMs == 0 {
+		cfg.FlushIntervalMs = 1000
+	}
+	if cfg.StopTimeoutS == 0 {
+		cfg.StopTimeoutS = DefaultStopTimeoutS
+	}
+	cfg.Tags = CleanTags(cfg.Tags)
+	for name := range cfg.CustomColumns {
+		if !customColumnName.MatchString(name) {
+			return nil, fmt.Errorf("%s: custom_columns: %q is not a column name (lowercase letters, digits and _)", path, name)
+		}
+	}
+	if cfg.FlushIntervalMs < 0 {
+		return nil, fmt.Errorf("%s: flush_interval_ms must be positive, got %d", path, cfg.FlushIntervalMs)
+	}
+	if cfg.StopTimeoutS < 0 {
+		return nil, fmt.Errorf("%s: stop_timeout_s must be positive, got %d", path, cfg.StopTimeoutS)
+	}
+	if cfg.ZMQ.Endpoint == "" {
+		cfg.ZMQ.Endpoint = "tcp://*:5556"
+	}
+	if cfg.ZMQ.HWM == 0 {
+		cfg.ZMQ.HWM = 1000
+	}
+	if cfg.Foxglove.Queue == 0 {
+		cfg.Foxglove.Queue = 256
+	}
+	if cfg.Foxglove.Queue < 0 {
+		return nil, fmt.Errorf("%s: foxglove: queue must be positive, got %d", path, cfg.Foxglove.Queue)
+	}
+	t := &cfg.Thumbnails
+	if t.Width == 0 {
+		t.Width = 320
+	}
+	if t.Height == 0 {
+		t.Height = 180
+	}
+	if t.RateHz == 0 {
+		t.RateHz = 2
+	}
+	if t.Seconds == 0 {
+		t.Seconds = 30
+	}
+	if t.Quality == 0 {
+		t.Quality = 70
+	}
+	if t.Width < 0 || t.Height < 0 || !ValidRate(t.RateHz) || t.Seconds < 0 || t.Quality < 1 || t.Quality > 100 {
+		return nil, fmt.Errorf("%s: thumbnails: width, height and seconds must be positive, rate_hz between 1 and %d and quality between 1 and 100", path, MaxRateHz)
+	}
+	switch cfg.FrameSync {
+	case "":
+		cfg.FrameSync = "none"
+	case "none", "dsync", "direct", "paced":
+	default:
+		return nil, fmt.Errorf("%s: frame_sync must be none, dsync, direct or paced, got %q", path, cfg.FrameSync)
+	}
+	if cfg.SyncChunkMB == 0 {
+		cfg.SyncChunkMB = 64
+	}
+	switch cfg.FrameFormat {
+	case "":
+		cfg.FrameFormat = "jpeg"
+	case "jpeg", "webp", "png", "raw":
+	default:
+		return nil, fmt.Errorf("%s: frame_format must be jpeg, webp, png or raw, got %q", path, cfg.FrameFormat)
+	}
+	if cfg.FrameEXIF && cfg.FrameFormat != "jpeg" {
+		return nil, fmt.Errorf("%s: frame_exif needs frame_format jpeg, got %q", path, cfg.FrameFormat)
+	}
+	if cfg.FrameWorkers == 0 {
+		cfg.FrameWorkers = runtime.NumCPU()
+	}
+	if cfg.FrameWorkers < 0 {
+		return nil, fmt.Errorf("%s: frame_workers must be positive, got %d", path, cfg.FrameWorkers)
+	}
+	if fp := &cfg.FrameProcessing; fp.Enabled {
+		if fp.Quality == 0 {
+			fp.Quality = 90
+		}
+		if fp.FullResS == 0 {
+			fp.FullResS = 10
+		}
+		r := fp.ROI
+		switch {
+		case r.X < 0 || r.Y < 0 || r.Width < 0 || r.Height < 0:
+			return nil, fmt.Errorf("%s: frame_processing.roi must not be negative", path)
+		case fp.MaxWidth < 0:
+			return nil, fmt.Errorf("%s: frame_processing.max_width must not be negative, got %d", path, fp.MaxWidth)
+		case fp.Quality < 1 || fp.Quality > 100:
+			return nil, fmt.Errorf("%s: frame_processing.quality must be between 1 and 100, got %d", path, fp.Quality)
+		case fp.FullResS < 0:
+			return nil, fmt.Errorf("%s: frame_processing.full_res_s must be positive, got %d", path, fp.FullResS)
+		case cfg.FrameFormat == "raw":
+			return nil, fmt.Errorf("%s: frame_processing cannot be used with frame_format raw, whose files do not record their size", path)
+		}
+	}
+	switch store := &cfg.FrameStore; store.Type {
+	case "":
+		store.Type = FrameStoreDir
+	case FrameStoreDir, FrameStoreTar:
+	case FrameStoreS3:
+		s3 := &store.S3
+		if s3.Region == "" {
+			s3.Region = "us-east-1"
+		}
+		if s3.MaxPending == 0 {
+			s3.MaxPending = 64
+		}
+		if s3.TimeoutS == 0 {
+			s3.TimeoutS = 30
+		}
+		u, err := url.Parse(s3.Endpoint)
+		switch {
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			return nil, fmt.Errorf("%s: frame_store.s3.endpoint must be an http or https URL, got %q", path, s3.Endpoint)
+		case s3.Bucket == "":
+			return nil, fmt.Errorf("%s: frame_store.s3 needs a bucket", path)
+		case s3.SecretAccessKey != "" && s3.SecretAccessKeyFile != "":
+			return nil, fmt.Errorf("%s: frame_store.s3: set secret_access_key or secret_access_key_file, not both", path)
+		case s3.AccessKeyID != "" && s3.SecretAccessKey == "" && s3.SecretAccessKeyFile == "":
+			return nil, fmt.Errorf("%s: frame_store.s3.access_key_id needs secret_access_key or secret_access_key_file", path)
+		case s3.MaxPending < 0 || s3.TimeoutS < 0:
+			return nil, fmt.Errorf("%s: frame_store.s3: max_pending and timeout_s must be positive", path)
+		}
+	default:
+		return nil, fmt.Errorf("%s: frame_store.type must be dir, tar or s3, got %q", path, store.Type)
+	}
+	switch cfg.CloudCompression {
+	case "":
+		cfg.CloudCompression = "none"
+	case "none", "deflate", "zstd", "quantized":
+	default:
+		return nil, fmt.Errorf("%s: cloud_compression must be none, deflate, zstd or quantized, got %q", path, cfg.CloudCompression)
+	}
+	if cfg.CloudStepMM == 0 {
+		cfg.CloudStepMM = 1
+	}
+	if cfg.CloudStepMM < 0 {
+		return nil, fmt.Errorf("%s: cloud_step_mm must be positive, got %g", path, cfg.CloudStepMM)
+	}
+	for _, name := range cfg.BinarySensors {
+		if name != "imu" && name != "radar" {
+			return nil, fmt.Errorf("%s: binary_sensors supports imu and radar, got %q", path, name)
+		}
+	}
+	for _, format := range cfg.Tracks {
+		if format != "gpx" && format != "geojson" {
+			return nil, fmt.Errorf("%s: tracks supports gpx and geojson, got %q", path, format)
+		}
+	}
+	pv := &cfg.Preview
+	if pv.RateHz == 0 {
+		pv.RateHz = DefaultPreview.RateHz
+	}
+	if pv.FPS == 0 {
+		pv.FPS = DefaultPreview.FPS
+	}
+	if pv.Width == 0 {
+		pv.Width = DefaultPreview.Width
+	}
+	if pv.Quality == 0 {
+		pv.Quality = DefaultPreview.Quality
+	}
+	if pv.ToleranceM == 0 {
+		pv.ToleranceM = DefaultPreview.ToleranceM
+	}
+	if pv.RateHz < 0 || pv.FPS < 0 || pv.Width < 0 || pv.ToleranceM < 0 || pv.Quality < 1 || pv.Quality > 100 {
+		return nil, fmt.Errorf("%s: preview: rate_hz, fps, width and tolerance_m must be positive and quality between 1 and 100", path)
+	}
+	if cfg.BinaryIndexIntervalMs == 0 {
+		cfg.BinaryIndexIntervalMs = 1000
+	}
+	if cfg.BinaryIndexIntervalMs < 0 {
+		return nil, fmt.Errorf("%s: binary_index_interval_ms must be positive, got %d", path, cfg.BinaryIndexIntervalMs)
+	}
+	switch cfg.OnWriteError {
+	case "":
+		cfg.OnWriteError = WriteErrorContinue
+	case WriteErrorContinue, WriteErrorAbort:
+	default:
+		return nil, fmt.Errorf("%s: on_write_error must be continue or abort, got %q", path, cfg.OnWriteError)
+	}
+	switch cfg.Validation.Action {
+	case "":
+		cfg.Validation.Action = ValidationCount
+	case ValidationCount, ValidationMark, ValidationDrop:
+	default:
+		return nil, fmt.Errorf("%s: validation.action must be count, mark or drop, got %q", path, cfg.Validation.Action)
+	}
+	switch cfg.Transform.Frame {
+	case "":
+		cfg.Transform.Frame = "vehicle"
+	case "vehicle", "world":
+	default:
+		return nil, fmt.Errorf("%s: transform.frame must be vehicle or world, got %q", path, cfg.Transform.Frame)
+	}
+	if cfg.SystemStats.IntervalS == 0 {
+		cfg.SystemStats.IntervalS = 5
+	}
+	if cfg.SystemStats.IntervalS < 0 {
+		return nil, fmt.Errorf("%s: system_stats.interval_s must be positive, got %d", path, cfg.SystemStats.IntervalS)
+	}
+	if cfg.Resume.WindowS == 0 {
+		cfg.Resume.WindowS = 300
+	}
+	if cfg.Resume.WindowS < 0 {
+		return nil, fmt.Errorf("%s: resume.window_s must be positive, got %d", path, cfg.Resume.WindowS)
+	}
+	if cfg.Preflight.Margin == 0 {
+		cfg.Preflight.Margin = 1.5
+	}
+	if p := cfg.Preflight; p.Margin < 1 || p.WriteTestMB < 0 || p.DurationMin < 0 {
+		return nil, fmt.Errorf("%s: preflight: margin must be at least 1, write_test_mb and duration_min not negative", path)
+	}
+	if err := cfg.RadarGrid.applyDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: radar_grid: %w", path, err)
+	}
+	if err := cfg.Degrade.applyDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: degrade: %w", path, err)
+	}
+	if err := cfg.Power.applyDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: power: %w", path, err)
+	}
+	if err := cfg.Telemetry.applyDefaults(cfg.BaseDir); err != nil {
+		return nil, fmt.Errorf("%s: telemetry: %w", path, err)
+	}
+	if err := cfg.Alerts.applyDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: alerts: %w", path, err)
+	}
+	if err := cfg.LocationEncryption.applyDefaults(cfg.Tracks); err != nil {
+		return nil, fmt.Errorf("%s: location_encryption: %w", path, err)
+	}
+	if err := cfg.Units.applyDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: units: %w", path, err)
+	}
+	switch cfg.Projection {
+	case "", ProjectionUTM, ProjectionENU:
+	default:
+		return nil, fmt.Errorf("%s: projection: unknown projection %q (%s or %s)", path, cfg.Projection, ProjectionUTM, ProjectionENU)
+	}
+	if cfg.Projection != "" && cfg.LocationEncryption.Enabled {
+		return nil, fmt.Errorf("%s: projection would write the positions in the clear, leave it empty with location_encryption", path)
+	}
+	if err := cfg.Auth.validate(); err != nil {
+		return nil, fmt.Errorf("%s: auth: %w", path, err)
+	}
+	h := &cfg.Hooks
+	if h.Concurrency == 0 {
+		h.Concurrency = 1
+	}
+	if h.Concurrency < 0 || h.TimeoutS < 0 {
+		return nil, fmt.Errorf("%s: hooks: concurrency and timeout_s must not be negative", path)
+	}
+	for i := range h.Commands {
+		c := &h.Commands[i]
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("%s: hooks: command %d is empty", path, i)
+		}
+		if c.Name == "" {
+			c.Name = filepath.Base(c.Command[0])
+		}
+	}
+	switch cfg.Pack.Format {
+	case "":
+		cfg.Pack.Format = "tar.zst"
+	case "tar.zst", "zip":
+	default:
+		return nil, fmt.Errorf("%s: pack.format must be tar.zst or zip, got %q", path, cfg.Pack.Format)
+	}
+	switch cfg.Clock.Mode {
+	case "":
+		cfg.Clock.Mode = ClockLog
+	case ClockLog, ClockCorrect, ClockFlag:
+	default:
+		return nil, fmt.Errorf("%s: clock.mode must be log, correct or flag, got %q", path, cfg.Clock.Mode)
+	}
+	if cfg.Clock.JumpMs == 0 {
+		cfg.Clock.JumpMs = 100
+	}
+	if cfg.Clock.JumpMs < 0 {
+		return nil, fmt.Errorf("%s: clock.jump_ms must be positive, got %d", path, cfg.Clock.JumpMs)
+	}
+	if cfg.Clock.SyncIntervalS == 0 {
+		cfg.Clock.SyncIntervalS = 1
+	}
+	if cfg.Clock.SyncIntervalS < 0 {
+		return nil, fmt.Errorf("%s: clock.sync_interval_s must be positive, got %d", path, cfg.Clock.SyncIntervalS)
+	}
+	if err := cfg.applyFusedOutputDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.applySinkDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.applyStageDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// applyStageDefaults checks that every stage is known and placed once,
+// and that none of the enabled ones is left out.
+func (cfg *StorageConfig) applyStageDefaults() error {
+	processors := map[string]bool{}
+	for i := range cfg.Processors {
+		p := &cfg.Processors[i]
+		if p.Name == "" || p.Type == "" {
+			return fmt.Errorf("processors: processor %d needs a name and a type", i)
+		}
+		if slices.Contains(DefaultStages, p.Name) || processors[p.Name] {
+			return fmt.Errorf("processors: name %q is taken", p.Name)
+		}
+		processors[p.Name] = true
+		switch p.Type {
+		case ProcessorPrivacyZone:
+			if err := p.PrivacyZone.applyDefaults(p.Name); err != nil {
+				return err
+			}
+		case ProcessorRadarOverlay:
+			if err := p.RadarOverlay.applyDefaults(p.Name); err != nil {
+				return err
+			}
+		case ProcessorLidarDepth:
+			if p.LidarDepth.MaxAgeMs == 0 {
+				p.LidarDepth.MaxAgeMs = 50
+			}
+			if p.LidarDepth.MaxAgeMs < 0 {
+				return fmt.Errorf("processor %s: max_age_ms must be positive", p.Name)
+			}
+		}
+	}
+	if len(cfg.Stages) == 0 {
+		cfg.Stages = DefaultStages
+	}
+	placed := map[string]bool{}
+	for _, st := range cfg.Stages {
+		if !slices.Contains(DefaultStages, st) && !processors[st] {
+			return fmt.Errorf("stages: unknown stage %q (%s or a processor)", st, strings.Join(DefaultStages, ", "))
+		}
+		if placed[st] {
+			return fmt.Errorf("stages: %s is listed twice", st)
+		}
+		placed[st] = true
+	}
+	enabled := []bool{true, cfg.ZMQ.Enabled, cfg.Thumbnails.Enabled, cfg.Foxglove.Enabled, cfg.Telemetry.Enabled}
+	for i, st := range DefaultStages {
+		if enabled[i] && !placed[st] {
+			return fmt.Errorf("stages: %s is enabled but not listed", st)
+		}
+	}
+	for _, p := range cfg.Processors {
+		if !placed[p.Name] {
+			return fmt.Errorf("stages: processor %s is not listed", p.Name)
+		}
+	}
+	return nil
+}
+
+func (z *PrivacyZoneConfig) applyDefaults(name string) error {
+	if len(z.Sensors) == 0 {
+		z.Sensors = []string{"camera"}
+	}
+	if len(z.Zones) == 0 {
+		return fmt.Errorf("processor %s: privacy_zone needs zones", name)
+	}
+	for j, zone := range z.Zones {
+		if zone.RadiusM <= 0 || zone.Lat < -90 || zone.Lat > 90 || zone.Lon < -180 || zone.Lon > 180 {
+			return fmt.Errorf("processor %s: zone %d needs a lat, lon and positive radius_m", name, j)
+		}
+	}
+	return nil
+}
+
+func (o *RadarOverlayConfig) applyDefaults(name string) error {
+	if o.IntervalS == 0 {
+		o.IntervalS = 1
+	}
+	if o.MaxAgeMs == 0 {
+		o.MaxAgeMs = 200
+	}
+	if o.Quality == 0 {
+		o.Quality = 80
+	}
+	if o.IntervalS < 0 || o.MaxAgeMs < 0 {
+		return fmt.Errorf("processor %s: interval_s and max_age_ms must be positive", name)
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		return fmt.Errorf("processor %s: quality must be 1-100", name)
+	}
+	return nil
+}
+
+func (cfg *StorageConfig) applySinkDefaults() error {
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = []SinkConfig{{Type: SinkCSV}}
+	}
+	seen := map[string]bool{}
+	for i := range cfg.Sinks {
+		s := &cfg.Sinks[i]
+		if seen[s.Type] {
+			return fmt.Errorf("sinks: %s is listed twice", s.Type)
+		}
+		seen[s.Type] = true
+		switch s.Type {
+		case SinkCSV, SinkJSONL, SinkSQLite, SinkMCAP:
+		case SinkParquet:
+			if s.RowGroupRows == 0 {
+				s.RowGroupRows = DefaultRowGroupRows
+			}
+			if s.RowGroupRows < 0 {
+				return fmt.Errorf("sinks: parquet: row_group_rows must be positive, got %d", s.RowGroupRows)
+			}
+		case SinkKafka:
+			k := &s.Kafka
+			if len(k.Brokers) == 0 {
+				return fmt.Errorf("sinks: kafka: brokers is required")
+			}
+			if k.Topic == "" {
+				k.Topic = "sensor-logger"
+			}
+			if k.ClientID == "" {
+				host, _ := os.Hostname()
+				k.ClientID = "sensor-logger-" + host
+			}
+			if k.TimeoutS == 0 {
+				k.TimeoutS = 10
+			}
+			if k.TimeoutS < 0 {
+				return fmt.Errorf("sinks: kafka: timeout_s must be positive, got %d", k.TimeoutS)
+			}
+		default:
+			return fmt.Errorf("sinks: unknown type %q (csv, jsonl, parquet, sqlite, mcap or kafka)", s.Type)
+		}
+	}
+	if !seen[SinkCSV] {
+		return fmt.Errorf("sinks must include csv, which resume, export and replay read")
+	}
+	return nil
+}
+
+func (cfg *StorageConfig) applyFusedOutputDefaults() error {
+	if len(cfg.FusedOutputs) == 0 {
+		cfg.FusedOutputs = []FusedOutputConfig{{Name: "disk", Sink: FusedSinkCSV}}
+	}
+	csvOutputs := 0
+	for i := range cfg.FusedOutputs {
+		o := &cfg.FusedOutputs[i]
+		if o.Name == "" {
+			o.Name = fmt.Sprintf("%s-%d", o.Sink, i)
+		}
+		if o.BufferSize == 0 {
+			o.BufferSize = 64
+		}
+		if o.BufferSize < 0 {
+			return fmt.Errorf("fused output %s: buffer_size must not be negative, got %d", o.Name, o.BufferSize)
+		}
+		if o.RateHz < 0 || o.RateHz > MaxRateHz {
+			return fmt.Errorf("fused output %s: rate_hz must be between 0 and %d, got %d", o.Name, MaxRateHz, o.RateHz)
+		}
+		switch o.Sink {
+		case FusedSinkCSV:
+			csvOutputs++
+		case FusedSinkMQTT:
+			if o.MQTT.Broker == "" {
+				return fmt.Errorf("fused output %s: mqtt.broker is required", o.Name)
+			}
+			if o.MQTT.Topic == "" {
+				o.MQTT.Topic = "sensor-logger/fused"
+			}
+			if o.MQTT.ClientID == "" {
+				host, _ := os.Hostname()
+				o.MQTT.ClientID = "sensor-logger-" + host
+			}
+			if o.MQTT.KeepAliveS == 0 {
+				o.MQTT.KeepAliveS = 30
+			}
+		case FusedSinkArrow:
+			a := &o.Arrow
+			if a.Listen == "" {
+				a.Listen = ":7410"
+			}
+			if a.BatchRows == 0 {
+				a.BatchRows = 10
+			}
+			if a.FlushMs == 0 {
+				a.FlushMs = 1000
+			}
+			if a.Queue == 0 {
+				a.Queue = 16
+			}
+			if a.BatchRows < 0 || a.FlushMs < 0 || a.Queue < 0 {
+				return fmt.Errorf("fused output %s: arrow batch_rows, flush_ms and queue must be positive", o.Name)
+			}
+		default:
+			return fmt.Errorf("fused output %s: unknown sink %q (csv, mqtt or arrow)", o.Name, o.Sink)
+		}
+	}
+	if csvOutputs != 1 {
+		return fmt.Errorf("fused_outputs must contain exactly one csv output, got %d", csvOutputs)
+	}
+	return nil
+}
+
+func loadYAML(path string, out any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// validate rejects the values applyDefaults leaves alone but the readers
+// cannot run with: negative or excessive rates and negative buffer sizes.
+func (c *SensorsConfig) validate() error {
+	for _, r := range []struct {
+		name string
+		hz   int
+	}{
+		{"camera.fps", c.Camera.FPS},
+		{"gps.rate_hz", c.GPS.RateHz},
+		{"imu.rate_hz", c.IMU.RateHz},
+		{"lidar.rate_hz", c.Lidar.RateHz},
+		{"radar.rate_hz", c.Radar.RateHz},
+		{"env.rate_hz", c.Env.RateHz},
+		{"trigger.rate_hz", c.Trigger.RateHz},
+		{"ins.rate_hz", c.INS.RateHz},
+		{"fusion.rate_hz", c.Fusion.RateHz},
+	} {
+		if !ValidRate(r.hz) {
+			return fmt.Errorf("%s must be between 1 and %d, got %d", r.name, MaxRateHz, r.hz)
+		}
+	}
+	for _, b := range []struct {
+		name string
+		n    int
+	}{
+		{"camera", c.Camera.BufferSize}, {"gps", c.GPS.BufferSize}, {"imu", c.IMU.BufferSize},
+		{"lidar", c.Lidar.BufferSize}, {"radar", c.Radar.BufferSize}, {"env", c.Env.BufferSize},
+		{"trigger", c.Trigger.BufferSize}, {"ins", c.INS.BufferSize}, {"fusion", c.Fusion.BufferSize},
+		{"remote", c.Remote.BufferSize},
+	} {
+		if b.n < 0 {
+			return fmt.Errorf("%s.buffer_size must not be negative, got %d", b.name, b.n)
+		}
+	}
+	for _, d := range []struct {
+		name string
+		n    int
+	}{
+		{"camera", c.Camera.Decimate}, {"gps", c.GPS.Decimate}, {"imu", c.IMU.Decimate},
+		{"lidar", c.Lidar.Decimate}, {"radar", c.Radar.Decimate}, {"env", c.Env.Decimate},
+		{"ins", c.INS.Decimate},
+	} {
+		if d.n < 1 {
+			return fmt.Errorf("%s.decimate must be positive, got %d", d.name, d.n)
+		}
+	}
+	if p := Period(c.Fusion.RateHz); c.Fusion.Align.PhaseMs < 0 || time.Duration(c.Fusion.Align.PhaseMs)*time.Millisecond >= p {
+		return fmt.Errorf("fusion.align.phase_ms must be within the fusion period of %v, got %d", p, c.Fusion.Align.PhaseMs)
+	}
+	if c.Remote.SyncIntervalS < 1 {
+		return fmt.Errorf("remote.sync_interval_s must be positive, got %d", c.Remote.SyncIntervalS)
+	}
+	if err := c.Remote.Auth.validate(); err != nil {
+		return fmt.Errorf("remote.auth: %w", err)
+	}
+	if t := c.Agent.TLS; (t.Cert == "") != (t.Key == "") {
+		return errors.New("agent.tls: cert and key go together")
+	}
+	if c.Adaptive.CameraFPS < 0 || c.Adaptive.LidarHz < 0 {
+		return fmt.Errorf("adaptive.camera_fps and adaptive.lidar_hz must not be negative")
+	}
+	if t := c.SimThrottle; t.LimitC <= t.StartC || t.MinRatePct <= 0 || t.MinRatePct > 100 || t.IntervalS <= 0 {
+		return errors.New("sim_throttle: limit_c must be above start_c, min_rate_pct in (0, 100] and interval_s positive")
+	}
+	if c.Restart.DelayMs < 0 || c.Restart.MaxDelayMs < c.Restart.DelayMs {
+		return errors.New("restart: delay_ms must be positive and at most max_delay_ms")
+	}
+	if b := c.AdaptiveBuffers; b.MaxFactor < 1 || b.HoldS <= 0 {
+		return errors.New("adaptive_buffers: max_factor must be at least 1 and hold_s positive")
+	}
+	if c.MissingDevices.RetryS <= 0 {
+		return errors.New("missing_devices: retry_s must be positive")
+	}
+	for name, s := range c.Scheduling {
+		if !scheduledReaders[name] {
+			return fmt.Errorf("scheduling: unknown reader %q", name)
+		}
+		for _, cpu := range s.CPUs {
+			if cpu < 0 || cpu >= MaxCPUs {
+				return fmt.Errorf("scheduling.%s.cpus: no cpu %d", name, cpu)
+			}
+		}
+		if s.Nice != nil && (*s.Nice < -20 || *s.Nice > 19) {
+			return fmt.Errorf("scheduling.%s.nice must be between -20 and 19, got %d", name, *s.Nice)
+		}
+		if s.FIFOPriority < 0 || s.FIFOPriority > 99 {
+			return fmt.Errorf("scheduling.%s.fifo_priority must be between 0 and 99, got %d", name, s.FIFOPriority)
+		}
+	}
+	for name, l := range c.RateLimits {
+		if !scheduledReaders[name] || name == "remote" {
+			return fmt.Errorf("rate_limits: unknown reader %q", name)
+		}
+		if l.MaxHz <= 0 || l.MaxHz > MaxRateHz {
+			return fmt.Errorf("rate_limits.%s.max_hz must be positive and at most %d, got %g", name, MaxRateHz, l.MaxHz)
+		}
+		if l.Burst < 0 {
+			return fmt.Errorf("rate_limits.%s.burst must not be negative, got %d", name, l.Burst)
+		}
+	}
+	if f := c.Lidar.Format; f != LidarRaw && f != LidarVLP16 {
+		return fmt.Errorf("lidar.format must be raw or vlp16, got %q", f)
+	}
+	if m := c.Lidar.ReturnMode; m != ReturnStrongest && m != ReturnLast && m != ReturnDual {
+		return fmt.Errorf("lidar.return_mode must be strongest, last or dual, got %q", m)
+	}
+	if path, ok := strings.CutPrefix(c.Lidar.Address, PcapPrefix); ok && path == "" {
+		return errors.New("lidar.address: pcap: needs the path of a capture")
+	}
+	if p := c.Lidar.PcapPort; p < 0 || p > 65535 {
+		return fmt.Errorf("lidar.pcap_port must be a UDP port or 0, got %d", p)
+	}
+	if s := c.Camera.Stereo; s.Enabled && (s.RightDevice == "" || s.RightDevice == RemoteDevice) {
+		return errors.New("camera.stereo.right_device must be sim or a stream URL")
+	}
+	if f := c.Camera.Freeze; f.Enabled {
+		if f.HoldS <= 0 {
+			return fmt.Errorf("camera.freeze.hold_s must be positive, got %g", f.HoldS)
+		}
+		if f.Action != FreezeFlag && f.Action != FreezeRestart {
+			return fmt.Errorf("camera.freeze.action must be flag or restart, got %q", f.Action)
+		}
+	}
+	if t := c.Trigger; t.Enabled {
+		if t.Device == "" || t.Device == RemoteDevice {
+			return errors.New("trigger.device must be sim, gpio:<n> or a serial port")
+		}
+		if n, ok := strings.CutPrefix(t.Device, GPIOPrefix); ok {
+			if _, err := strconv.ParseUint(n, 10, 16); err != nil {
+				return fmt.Errorf("trigger.device: bad gpio line %q", n)
+			}
+		}
+		if t.WindowMs < 1 {
+			return fmt.Errorf("trigger.window_ms must be positive, got %d", t.WindowMs)
+		}
+	}
+	if i := c.INS; i.Enabled {
+		if i.Device == "" || i.Device == RemoteDevice || i.Device == INSDevice {
+			return errors.New("ins.device must be sim or a serial port")
+		}
+		if i.Protocol != INSVectorNav && i.Protocol != INSSBG {
+			return fmt.Errorf("ins.protocol must be vectornav or sbg, got %q", i.Protocol)
+		}
+		if c.GPS.NTRIP.Enabled {
+			return errors.New("gps.ntrip: corrections for an INS are configured on the unit")
+		}
+	} else if c.IMU.Device == INSDevice || c.GPS.Device == INSDevice {
+		return errors.New("imu and gps take device ins only with ins.enabled")
+	}
+	if p := c.Radar.Protocol; p != RadarJSON && p != RadarARS408 {
+		return fmt.Errorf("radar.protocol must be json or ars408, got %q", p)
+	}
+	if c.Radar.SensorID < 0 || c.Radar.SensorID > 7 {
+		return fmt.Errorf("radar.sensor_id must be between 0 and 7, got %d", c.Radar.SensorID)
+	}
+	if p := c.GPS.Protocol; p != GPSAuto && p != GPSNMEA && p != GPSUBX {
+		return fmt.Errorf("gps.protocol must be auto, nmea or ubx, got %q", p)
+	}
+	if n := c.GPS.NTRIP; n.Enabled {
+		if n.Caster == "" || n.Mountpoint == "" {
+			return errors.New("gps.ntrip: caster and mountpoint are required")
+		}
+		if c.GPS.Device == RemoteDevice {
+			return errors.New("gps.ntrip: corrections for a remote receiver are configured on its agent")
+		}
+	}
+	if a := c.Fusion.Heading.Alpha; a < 0 || a >= 1 {
+		return fmt.Errorf("fusion.heading.alpha must be in [0, 1), got %g", a)
+	}
+	if d := c.Fusion.Heading.DeclinationDeg; d != nil && math.Abs(*d) > 180 {
+		return fmt.Errorf("fusion.heading.declination_deg must be within ±180, got %g", *d)
+	}
+	if a := c.Fusion.Attitude; a.Enabled {
+		if a.Filter != AttitudeMadgwick && a.Filter != AttitudeMahony {
+			return fmt.Errorf("fusion.attitude.filter must be madgwick or mahony, got %q", a.Filter)
+		}
+		if a.Beta < 0 || a.Kp < 0 || a.Ki < 0 {
+			return errors.New("fusion.attitude: beta, kp and ki must not be negative")
+		}
+	}
+	for name, st := range c.Fusion.Stale {
+		if !slices.Contains([]string{"camera", "gps", "imu", "lidar", "radar", "env"}, name) {
+			return fmt.Errorf("fusion.stale: unknown sensor %q", name)
+		}
+		switch st.Policy {
+		case StaleClear, StaleHold:
+		case StaleInterpolate:
+			if name != "gps" && name != "imu" && name != "env" {
+				return fmt.Errorf("fusion.stale.%s: interpolate supports gps, imu and env", name)
+			}
+		default:
+			return fmt.Errorf("fusion.stale.%s: policy must be clear, hold_last or interpolate, got %q", name, st.Policy)
+		}
+		if st.MaxAgeMs < 0 {
+			return fmt.Errorf("fusion.stale.%s: max_age_ms must be positive, got %d", name, st.MaxAgeMs)
+		}
+	}
+	return nil
+}
+
+func (c *SensorsConfig) applyDefaults() {
+	if c.Camera.FPS == 0 {
+		c.Camera.FPS = 30
+	}
+	if c.Camera.Width == 0 {
+		c.Camera.Width = 640
+	}
+	if c.Camera.Height == 0 {
+		c.Camera.Height = 480
+	}
+	if c.Camera.Freeze.HoldS == 0 {
+		c.Camera.Freeze.HoldS = 2
+	}
+	if c.Camera.Freeze.Action == "" {
+		c.Camera.Freeze.Action = FreezeFlag
+	}
+	if c.GPS.RateHz == 0 {
+		c.GPS.RateHz = 1
+	}
+	if c.GPS.Baud == 0 {
+		c.GPS.Baud = 9600
+	}
+	if c.GPS.Protocol == "" {
+		c.GPS.Protocol = GPSAuto
+	}
+	if c.GPS.NTRIP.GGAIntervalS == 0 {
+		c.GPS.NTRIP.GGAIntervalS = 10
+	}
+	if c.GPS.NTRIP.ReconnectS == 0 {
+		c.GPS.NTRIP.ReconnectS = 5
+	}
+	if c.IMU.RateHz == 0 {
+		c.IMU.RateHz = 100
+	}
+	if c.IMU.Baud == 0 {
+		c.IMU.Baud = 115200
+	}
+	if c.Lidar.RateHz == 0 {
+		c.Lidar.RateHz = 10
+	}
+	if c.Lidar.Format == "" {
+		c.Lidar.Format = LidarRaw
+	}
+	if c.Lidar.ReturnMode == "" {
+		c.Lidar.ReturnMode = ReturnDual
+	}
+	if c.Radar.RateHz == 0 {
+		c.Radar.RateHz = 20
+	}
+	if c.Radar.Protocol == "" {
+		c.Radar.Protocol = RadarJSON
+	}
+	if c.Env.Transport == "" {
+		c.Env.Transport = "i2c"
+	}
+	if c.Env.I2CAddress == 0 {
+		c.Env.I2CAddress = 0x76
+	}
+	if c.Env.Baud == 0 {
+		c.Env.Baud = 9600
+	}
+	if c.Env.RateHz == 0 {
+		c.Env.RateHz = 1
+	}
+	if c.Trigger.Baud == 0 {
+		c.Trigger.Baud = 115200
+	}
+	if c.Trigger.RateHz == 0 {
+		c.Trigger.RateHz = 30
+	}
+	if c.Trigger.WindowMs == 0 {
+		c.Trigger.WindowMs = 10
+	}
+	if c.INS.Baud == 0 {
+		c.INS.Baud = 115200
+	}
+	if c.INS.Protocol == "" {
+		c.INS.Protocol = INSVectorNav
+	}
+	if c.INS.RateHz == 0 {
+		c.INS.RateHz = 50
+	}
+	for name, l := range c.RateLimits {
+		if l.Burst == 0 {
+			l.Burst = max(1, int(math.Ceil(l.MaxHz)))
+			c.RateLimits[name] = l
+		}
+	}
+	for _, d := range []*int{
+		&c.Camera.Decimate, &c.GPS.Decimate, &c.IMU.Decimate, &c.Lidar.Decimate, &c.Radar.Decimate, &c.Env.Decimate,
+		&c.INS.Decimate,
+	} {
+		if *d == 0 {
+			*d = 1
+		}
+	}
+	if c.Fusion.RateHz == 0 {
+		c.Fusion.RateHz = 10
+	}
+	for name, st := range c.Fusion.Stale {
+		if st.Policy == "" {
+			st.Policy = StaleClear
+		}
+		if st.MaxAgeMs == 0 {
+			st.MaxAgeMs = 1000
+		}
+		c.Fusion.Stale[name] = st
+	}
+	if c.Fusion.Heading.Alpha == 0 {
+		c.Fusion.Heading.Alpha = 0.98
+	}
+	if c.Fusion.Attitude.Filter == "" {
+		c.Fusion.Attitude.Filter = AttitudeMadgwick
+	}
+	if c.Fusion.Attitude.Beta == 0 {
+		c.Fusion.Attitude.Beta = 0.1
+	}
+	if c.Fusion.Attitude.Kp == 0 {
+		c.Fusion.Attitude.Kp = 1
+	}
+	if c.Remote.Listen == "" {
+		c.Remote.Listen = ":7400"
+	}
+	if c.Remote.SyncIntervalS == 0 {
+		c.Remote.SyncIntervalS = 5
+	}
+	if c.Agent.ID == "" {
+		c.Agent.ID, _ = os.Hostname()
+	}
+	if c.Agent.BufferMB == 0 {
+		c.Agent.BufferMB = 256
+	}
+	if c.Adaptive.StationarySpeedMps == 0 {
+		c.Adaptive.StationarySpeedMps = 0.5
+	}
+	if c.Adaptive.MovingSpeedMps == 0 {
+		c.Adaptive.MovingSpeedMps = 1.5
+	}
+	if c.Adaptive.HoldS == 0 {
+		c.Adaptive.HoldS = 10
+	}
+	if c.Restart.DelayMs == 0 {
+		c.Restart.DelayMs = 1000
+	}
+	if c.SimThrottle.StartC == 0 {
+		c.SimThrottle.StartC = 70
+	}
+	if c.SimThrottle.LimitC == 0 {
+		c.SimThrottle.LimitC = 85
+	}
+	if c.SimThrottle.MinRatePct == 0 {
+		c.SimThrottle.MinRatePct = 10
+	}
+	if c.SimThrottle.IntervalS == 0 {
+		c.SimThrottle.IntervalS = 2
+	}
+	if c.Restart.MaxDelayMs == 0 {
+		c.Restart.MaxDelayMs = 30000
+	}
+	if c.AdaptiveBuffers.MaxFactor == 0 {
+		c.AdaptiveBuffers.MaxFactor = 4
+	}
+	if c.AdaptiveBuffers.HoldS == 0 {
+		c.AdaptiveBuffers.HoldS = 2
+	}
+	if c.MissingDevices.RetryS == 0 {
+		c.MissingDevices.RetryS = 2
+	}
+	if c.INS.Enabled {
+		// The INS takes the place of the imu and gps devices.
+		c.IMU.Enabled, c.IMU.Device, c.IMU.RateHz = true, INSDevice, c.INS.RateHz
+		c.GPS.Enabled, c.GPS.Device, c.GPS.RateHz = true, INSDevice, c.INS.RateHz
+	}
+	for _, n := range []*int{
+		&c.Camera.BufferSize, &c.GPS.BufferSize, &c.IMU.BufferSize, &c.Lidar.BufferSize,
+		&c.Radar.BufferSize, &c.Env.BufferSize, &c.Trigger.BufferSize, &c.INS.BufferSize,
+		&c.Fusion.BufferSize, &c.Remote.BufferSize,
+	} {
+		if *n == 0 {
+			*n = 64
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock that stands still until Advance moves it on, for
+// tests and for running simulated sensors faster than real time. It is
+// safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// fakeWaiter is a ticker, or a timer when period is 0, due at at.
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewFakeClock returns a clock reading start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *FakeClock) NewTicker(d time.Duration) *Ticker {
+	if d <= 0 {
+		panic("utils: non-positive interval for NewTicker")
+	}
+	w := f.wait(d, d)
+	return &Ticker{C: w.c, stop: func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.waiters = slices.DeleteFunc(f.waiters, func(o *fakeWaiter) bool { return o == w })
+	}}
+}
+
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	return f.wait(d, 0).c
+}
+
+func (f *FakeClock) wait(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{at: f.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- f.now
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// Advance moves the clock on by d, firing the tickers and timers due on
+// the way in the order of their instants. Each tick carries its instant;
+// a tick the receiver has not taken yet when the next is due is dropped,
+// as by time.Ticker, so a test that wants every tick advances a period at
+// a time.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for {
+		next := -1
+		for i, w := range f.waiters {
+			if !w.at.After(end) && (next < 0 || w.at.Before(f.waiters[next].at)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		w := f.waiters[next]
+		f.now = w.at
+		select {
+		case w.c <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			f.waiters = slices.Delete(f.waiters, next, next+1)
+		}
+	}
+	f.now = end
+}
+
+// Waiters returns the number of tickers and pending timers on the clock,
+// so that a test can wait for the goroutines it started to be waiting
+// before it advances the clock.
+func (f *FakeClock) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
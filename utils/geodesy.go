@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+)
+
+// WGS84 ellipsoid.
+const (
+	wgs84A  = 6378137.0
+	wgs84F  = 1 / 298.257223563
+	wgs84E2 = wgs84F * (2 - wgs84F)
+)
+
+// GeodeticToECEF converts latitude/longitude in degrees and altitude in
+// metres to earth-centred earth-fixed coordinates.
+func GeodeticToECEF(lat, lon, alt float64) (x, y, z float64) {
+	phi, lam := lat*math.Pi/180, lon*math.Pi/180
+	sp, cp := math.Sin(phi), math.Cos(phi)
+	n := wgs84A / math.Sqrt(1-wgs84E2*sp*sp)
+	x = (n + alt) * cp * math.Cos(lam)
+	y = (n + alt) * cp * math.Sin(lam)
+	z = (n*(1-wgs84E2) + alt) * sp
+	return x, y, z
+}
+
+// GeodeticToENU returns the east/north/up offset in metres of a position
+// from the reference position (lat0, lon0, alt0).
+func GeodeticToENU(lat, lon, alt, lat0, lon0, alt0 float64) (e, n, u float64) {
+	x, y, z := GeodeticToECEF(lat, lon, alt)
+	x0, y0, z0 := GeodeticToECEF(lat0, lon0, alt0)
+	dx, dy, dz := x-x0, y-y0, z-z0
+	phi, lam := lat0*math.Pi/180, lon0*math.Pi/180
+	sp, cp := math.Sin(phi), math.Cos(phi)
+	sl, cl := math.Sin(lam), math.Cos(lam)
+	e = -sl*dx + cl*dy
+	n = -sp*cl*dx - sp*sl*dy + cp*dz
+	u = cp*cl*dx + cp*sl*dy + sp*dz
+	return e, n, u
+}
+
+// UTMZone returns the UTM zone (1-60) of lat, lon in degrees, with the
+// exceptions around Norway and Svalbard, and whether it is the southern
+// half, whose northings start at 10,000 km.
+func UTMZone(lat, lon float64) (zone int, south bool) {
+	lon = math.Mod(math.Mod(lon+180, 360)+360, 360) - 180
+	zone = min(int((lon+180)/6)+1, 60)
+	switch {
+	case lat >= 56 && lat < 64 && lon >= 3 && lon < 12:
+		zone = 32
+	case lat >= 72 && lat < 84 && lon >= 0 && lon < 42:
+		zone = 31 + 2*int((lon+3)/12)
+	}
+	return zone, lat < 0
+}
+
+// UTMZoneName names a zone by its number and half, e.g. "32N".
+func UTMZoneName(zone int, south bool) string {
+	if south {
+		return strconv.Itoa(zone) + "S"
+	}
+	return strconv.Itoa(zone) + "N"
+}
+
+// utmK0 is the scale of UTM on its central meridians.
+const utmK0 = 0.9996
+
+// LatLonToUTM returns the easting and northing in metres of lat, lon in
+// degrees in the given UTM zone, by the Krüger series to the third order
+// of the flattening (well under a millimetre within a zone). Positions
+// outside the zone are projected onto it, less accurately the further
+// out they are.
+func LatLonToUTM(lat, lon float64, zone int, south bool) (easting, northing float64) {
+	n := wgs84F / (2 - wgs84F)
+	a := wgs84A / (1 + n) * (1 + n*n/4 + n*n*n*n/64)
+	alpha := [3]float64{
+		n/2 - 2*n*n/3 + 5*n*n*n/16,
+		13*n*n/48 - 3*n*n*n/5,
+		61 * n * n * n / 240,
+	}
+	phi := lat * math.Pi / 180
+	dlam := math.Remainder(lon-float64(zone*6-183), 360) * math.Pi / 180
+	c := 2 * math.Sqrt(n) / (1 + n)
+	t := math.Sinh(math.Atanh(math.Sin(phi)) - c*math.Atanh(c*math.Sin(phi)))
+	xi0 := math.Atan2(t, math.Cos(dlam))
+	eta0 := math.Atanh(math.Sin(dlam) / math.Sqrt(1+t*t))
+	xi, eta := xi0, eta0
+	for j, al := range alpha {
+		k := 2 * float64(j+1)
+		xi += al * math.Sin(k*xi0) * math.Cosh(k*eta0)
+		eta += al * math.Cos(k*xi0) * math.Sinh(k*eta0)
+	}
+	easting = 500000 + utmK0*a*eta
+	northing = utmK0 * a * xi
+	if south {
+		northing += 10000000
+	}
+	return easting, northing
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the minimum severity a Logger will emit.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+}
+
+// ParseLevel maps "debug", "info", "warn" or "error" to a Level.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger is the logging interface taken by controllers, readers and
+// writers. The CLI passes the process-wide logger returned by L; library
+// users can pass their own, or Discard.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// TextLogger is a small leveled logger writing one line per message.
+type TextLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+}
+
+// NewLogger returns a TextLogger writing to out at the given level.
+func NewLogger(out io.Writer, level Level) *TextLogger {
+	return &TextLogger{out: out, level: level}
+}
+
+// Discard is a Logger that drops every message.
+var Discard Logger = NewLogger(io.Discard, LevelError+1)
+
+var (
+	globalMu sync.Mutex
+	global   = NewLogger(os.Stderr, LevelInfo)
+)
+
+// InitLogger configures the process-wide logger used by the CLI. When path is non-empty,
+// output is written to both stderr and the file at path.
+func InitLogger(path, level string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stderr
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		out = io.MultiWriter(os.Stderr, f)
+	}
+	globalMu.Lock()
+	global = NewLogger(out, lvl)
+	globalMu.Unlock()
+	return nil
+}
+
+// L returns the process-wide logger.
+func L() Logger {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	return global
+}
+
+func (l *TextLogger) logf(level Level, format string, args ...any) {
+	if level < l.level {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "%s %-5s %s\n", time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), levelNames[level], msg)
+}
+
+func (l *TextLogger) Debugf(format string, args ...any) { l.logf(LevelDebug, format, args...) }
+func (l *TextLogger) Infof(format string, args ...any)  { l.logf(LevelInfo, format, args...) }
+func (l *TextLogger) Warnf(format string, args ...any)  { l.logf(LevelWarn, format, args...) }
+func (l *TextLogger) Errorf(format string, args ...any) { l.logf(LevelError, format, args...) }
+
+// prefixLogger prepends a prefix to every message of a Logger.
+type prefixLogger struct {
+	l      Logger
+	prefix string
+}
+
+// WithPrefix returns a Logger writing to l with prefix before every
+// message.
+func WithPrefix(l Logger, prefix string) Logger {
+	return prefixLogger{l, prefix}
+}
+
+func (p prefixLogger) Debugf(format string, args ...any) {
+	p.l.Debugf("%s%s", p.prefix, fmt.Sprintf(format, args...))
+}
+func (p prefixLogger) Infof(format string, args ...any) {
+	p.l.Infof("%s%s", p.prefix, fmt.Sprintf(format, args...))
+}
+func (p prefixLogger) Warnf(format string, args ...any) {
+	p.l.Warnf("%s%s", p.prefix, fmt.Sprintf(format, args...))
+}
+func (p prefixLogger) Errorf(format string, args ...any) {
+	p.l.Errorf("%s%s", p.prefix, fmt.Sprintf(format, args...))
+}
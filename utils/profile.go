@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profilesKey is the section of sensors.yaml holding the profiles.
+const profilesKey = "profiles"
+
+// Profile is the entry of the profiles section of sensors.yaml selected
+// with -profile: sensors.yaml keys, whose values replace those of the
+// base configuration, and under storage the same for storage.yaml.
+// Mappings are merged key by key, so a profile names only what differs;
+// lists and scalars are replaced whole.
+type Profile struct {
+	Name    string
+	storage *yaml.Node
+}
+
+// readYAML parses the document at path, an empty mapping for an empty
+// file.
+func readYAML(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	return doc.Content[0], nil
+}
+
+// loadSensorsYAML reads the sensors config at path with the profiles
+// section taken out and, unless name is empty, profile name merged in.
+func loadSensorsYAML(path, name string) (*yaml.Node, Profile, error) {
+	doc, err := readYAML(path)
+	if err != nil {
+		return nil, Profile{}, err
+	}
+	profiles := takeKey(doc, profilesKey)
+	if name == "" {
+		return doc, Profile{}, nil
+	}
+	var names []string
+	if profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			names = append(names, profiles.Content[i].Value)
+		}
+	}
+	i := slices.Index(names, name)
+	if i < 0 {
+		if len(names) == 0 {
+			return nil, Profile{}, fmt.Errorf("%s: no profile %q, the file defines none", path, name)
+		}
+		return nil, Profile{}, fmt.Errorf("%s: no profile %q (have %s)", path, name, strings.Join(names, ", "))
+	}
+	overlay := profiles.Content[2*i+1]
+	if overlay.Kind != yaml.MappingNode {
+		return nil, Profile{}, fmt.Errorf("%s: profile %q must be a mapping", path, name)
+	}
+	p := Profile{Name: name}
+	overlay = cloneNode(overlay)
+	if st := takeKey(overlay, "storage"); st != nil {
+		if st.Kind != yaml.MappingNode {
+			return nil, Profile{}, fmt.Errorf("%s: profile %q: storage must be a mapping", path, name)
+		}
+		p.storage = st
+	}
+	mergeYAML(doc, overlay)
+	return doc, p, nil
+}
+
+// takeKey removes key from the mapping m and returns its value, nil when
+// absent.
+func takeKey(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			v := m.Content[i+1]
+			m.Content = slices.Delete(m.Content, i, i+2)
+			return v
+		}
+	}
+	return nil
+}
+
+// mergeYAML merges src into dst: keys of mappings are merged recursively,
+// any other value replaces the one in dst.
+func mergeYAML(dst, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		*dst = *src
+		return
+	}
+outer:
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		k, v := src.Content[i], src.Content[i+1]
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == k.Value {
+				mergeYAML(dst.Content[j+1], v)
+				continue outer
+			}
+		}
+		dst.Content = append(dst.Content, k, v)
+	}
+}
+
+func cloneNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		c.Content[i] = cloneNode(child)
+	}
+	return &c
+}
@@ -0,0 +1,86 @@
+package utils
+
+import "time"
+
+// MaxRateHz is the highest sample or tick rate accepted anywhere.
+const MaxRateHz = 10000
+
+// ValidRate reports whether rateHz is within [1, MaxRateHz].
+func ValidRate(rateHz int) bool {
+	return rateHz >= 1 && rateHz <= MaxRateHz
+}
+
+// ClampRate limits rateHz to [1, MaxRateHz].
+func ClampRate(rateHz int) int {
+	return min(max(rateHz, 1), MaxRateHz)
+}
+
+// Period returns the interval between ticks at rateHz. The rate is
+// clamped first, so a zero or negative rate never divides by zero or
+// yields a non-positive interval.
+func Period(rateHz int) time.Duration {
+	return time.Second / time.Duration(ClampRate(rateHz))
+}
+
+// NewRateTicker returns a ticker of c firing at rateHz, clamped as by
+// Period.
+func NewRateTicker(c Clock, rateHz int) *Ticker {
+	return c.NewTicker(Period(rateHz))
+}
+
+// AlignedTicker ticks at the instants k/rate seconds after the Unix epoch
+// for whole k, shifted by a phase, on a Clock. Two processes
+// whose clocks agree tick at the same instants whenever they start. C
+// receives the instant of each tick rather than the time it was
+// delivered; ticks a slow receiver misses are dropped, as by time.Ticker.
+type AlignedTicker struct {
+	C    <-chan time.Time
+	stop chan struct{}
+}
+
+// NewAlignedTicker returns a ticker of clock at rateHz, clamped as by
+// Period, whose ticks fall phase after the instants of the rate.
+func NewAlignedTicker(clock Clock, rateHz int, phase time.Duration) *AlignedTicker {
+	c := make(chan time.Time, 1)
+	t := &AlignedTicker{C: c, stop: make(chan struct{})}
+	go t.run(clock, c, int64(ClampRate(rateHz)), phase%time.Second)
+	return t
+}
+
+func (t *AlignedTicker) run(clock Clock, c chan<- time.Time, rate int64, phase time.Duration) {
+	// index returns the last tick at or before t; at returns tick k,
+	// rounded up to the nanosecond so that index(at(k)) is k. Seconds and
+	// nanoseconds are kept apart so that nothing overflows.
+	index := func(t time.Time) int64 {
+		t = t.Add(-phase)
+		return t.Unix()*rate + int64(t.Nanosecond())*rate/1e9
+	}
+	at := func(k int64) time.Time {
+		return time.Unix(k/rate, (k%rate*1e9+rate-1)/rate).Add(phase).UTC()
+	}
+	now := clock.Now()
+	next := index(now) + 1
+	wait := clock.After(at(next).Sub(now))
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-wait:
+		}
+		k := index(clock.Now())
+		if k >= next {
+			select {
+			case c <- at(k):
+			default:
+			}
+			next = k + 1
+		}
+		// Also after waking early, e.g. when the wall clock stepped back.
+		wait = clock.After(at(next).Sub(clock.Now()))
+	}
+}
+
+// Stop turns the ticker off. C is not closed.
+func (t *AlignedTicker) Stop() {
+	close(t.stop)
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatTimestamp renders t as Unix seconds with microsecond precision,
+// the timestamp format used in every CSV file.
+func FormatTimestamp(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMicro())/1e6, 'f', 6, 64)
+}
+
+// ParseTimestamp is the inverse of FormatTimestamp.
+func ParseTimestamp(s string) (time.Time, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMicro(int64(math.Round(f * 1e6))).UTC(), nil
+}
+
+const (
+	sessionPrefix = "session_"
+	sessionLayout = "20060102_150405"
+)
+
+// SessionName returns the directory name used for a session started at t.
+func SessionName(t time.Time) string {
+	return sessionPrefix + t.UTC().Format(sessionLayout)
+}
+
+// SessionStart is the inverse of SessionName, to the second.
+func SessionStart(name string) (time.Time, bool) {
+	t, err := time.Parse(sessionLayout, strings.TrimPrefix(name, sessionPrefix))
+	return t, err == nil
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// forcedWait is how long a WaitUntil still waits once ForceStop was
+// called, so that steps about to finish do.
+const forcedWait = 200 * time.Millisecond
+
+var (
+	forced    = make(chan struct{})
+	forceOnce sync.Once
+)
+
+// ForceStop cuts every WaitUntil, running or to come, short to forcedWait,
+// for an operator who will not wait out the stop deadlines.
+func ForceStop() {
+	forceOnce.Do(func() { close(forced) })
+}
+
+// Forced reports whether ForceStop was called.
+func Forced() bool {
+	select {
+	case <-forced:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitUntil calls wait and returns true once it returns, or false at
+// deadline if it has not. A wait left running at deadline keeps its
+// goroutine until it returns.
+func WaitUntil(deadline time.Time, wait func()) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	return waitFor(timer.C, wait)
+}
+
+// WaitUnlessForced calls wait and returns true once it returns, or false
+// if ForceStop cuts it short, as WaitUntil without a deadline.
+func WaitUnlessForced(wait func()) bool {
+	return waitFor(nil, wait)
+}
+
+// waitFor runs wait until it returns, timeout fires or ForceStop was
+// called and forcedWait has passed.
+func waitFor(timeout <-chan time.Time, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wait()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-timeout:
+		return false
+	case <-forced:
+	}
+	grace := time.NewTimer(forcedWait)
+	defer grace.Stop()
+	select {
+	case <-done:
+		return true
+	case <-grace.C:
+		return false
+	}
+}
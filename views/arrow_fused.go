@@ -0,0 +1,209 @@
+package views
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/arrow"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// arrowHelloTimeout bounds the wait for the token of a client when
+// authentication is required, arrowWriteTimeout a write to a client that
+// stopped reading.
+const (
+	arrowHelloTimeout = 5 * time.Second
+	arrowWriteTimeout = 10 * time.Second
+)
+
+// ArrowFusedServer streams fused records to the clients connected to its
+// socket as an Apache Arrow IPC stream, for notebooks following a drive
+// live: the columns are those of fused.csv, typed as in the record
+// schemas. Each client gets the schema on connecting, then the batches
+// from there on and EOS when the session stops.
+type ArrowFusedServer struct {
+	name   string
+	cfg    utils.ArrowConfig
+	log    utils.Logger
+	auth   *auth.Authenticator
+	layout models.FusedLayout
+	units  *UnitConverter
+	fields []arrow.Field
+	schema []byte
+	ln     net.Listener
+
+	mu      sync.Mutex
+	clients map[*arrowClient]bool
+	closed  bool
+	wg      sync.WaitGroup
+	batches uint64
+	records uint64
+}
+
+type arrowClient struct {
+	out     chan []byte
+	dropped int
+}
+
+// NewArrowFusedServer listens for clients of output o, whose records have
+// the optional columns of layout, in units as in fused.csv. a
+// authenticates them; clients need the read role.
+func NewArrowFusedServer(o utils.FusedOutputConfig, layout models.FusedLayout, units utils.UnitsConfig, a *auth.Authenticator, log utils.Logger) (*ArrowFusedServer, error) {
+	ln, err := a.Listen(o.Arrow.Listen)
+	if err != nil {
+		return nil, err
+	}
+	s := &ArrowFusedServer{name: o.Name, cfg: o.Arrow, log: log, auth: a, layout: layout, ln: ln, clients: map[*arrowClient]bool{}}
+	s.units = NewUnitConverter(FusedColumns(layout), units)
+	for _, c := range FusedColumns(layout) {
+		t := arrow.Float64
+		switch columnType(c) {
+		case "integer":
+			t = arrow.Int64
+		case "string":
+			t = arrow.String
+		}
+		s.fields = append(s.fields, arrow.Field{Name: c, Type: t})
+	}
+	s.schema = arrow.Schema(s.fields)
+	log.Infof("%s: serving fused records as Arrow IPC on %s", o.Name, ln.Addr())
+	return s, nil
+}
+
+// Run streams the records from in until it is closed, then ends the
+// stream of every client and stops listening.
+func (s *ArrowFusedServer) Run(in <-chan models.FusedRecord) {
+	go s.accept()
+	flush := time.NewTicker(time.Duration(s.cfg.FlushMs) * time.Millisecond)
+	defer flush.Stop()
+	var rows [][]string
+	send := func() {
+		if len(rows) > 0 {
+			s.broadcast(arrow.RecordBatch(s.fields, rows))
+			rows = rows[:0]
+		}
+	}
+	for done := false; !done; {
+		select {
+		case rec, ok := <-in:
+			if !ok {
+				done = true
+				break
+			}
+			rows = append(rows, s.units.Convert(rec.CSVRow(s.layout)))
+			s.records++
+			if len(rows) >= s.cfg.BatchRows {
+				send()
+			}
+		case <-flush.C:
+			send()
+		}
+	}
+	send()
+	s.ln.Close()
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.clients {
+		close(c.out)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	s.log.Infof("%s: streamed %d fused records in %d Arrow batches", s.name, s.records, s.batches)
+}
+
+// broadcast queues a batch for every client, dropping it for those whose
+// queue is full.
+func (s *ArrowFusedServer) broadcast(batch []byte) {
+	s.batches++
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c.out <- batch:
+		default:
+			c.dropped++
+		}
+	}
+}
+
+func (s *ArrowFusedServer) accept() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Warnf("%s: accept: %v", s.name, err)
+			}
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+		}()
+	}
+}
+
+// serve writes the stream to one client until the server stops or the
+// client goes away. With authentication required the client first sends
+// its token on a line of its own, an empty one with a client certificate.
+func (s *ArrowFusedServer) serve(conn net.Conn) {
+	defer conn.Close()
+	addr := conn.RemoteAddr()
+	if s.auth.Required() {
+		conn.SetReadDeadline(time.Now().Add(arrowHelloTimeout))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			s.log.Warnf("%s: client %s sent no token: %v", s.name, addr, err)
+			return
+		}
+		var cs *tls.ConnectionState
+		if tc, ok := conn.(*tls.Conn); ok {
+			st := tc.ConnectionState()
+			cs = &st
+		}
+		if role := s.auth.Role(strings.TrimSpace(line), cs); role < auth.Read {
+			s.log.Warnf("%s: client %s rejected: role %s, needs %s", s.name, addr, role, auth.Read)
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+	}
+
+	c := &arrowClient{out: make(chan []byte, s.cfg.Queue)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.clients[c] = true
+	s.mu.Unlock()
+	s.log.Infof("%s: client %s connected", s.name, addr)
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		dropped := c.dropped
+		s.mu.Unlock()
+		s.log.Infof("%s: client %s disconnected (%d batches dropped)", s.name, addr, dropped)
+	}()
+
+	write := func(b []byte) bool {
+		conn.SetWriteDeadline(time.Now().Add(arrowWriteTimeout))
+		_, err := conn.Write(b)
+		return err == nil
+	}
+	if !write(s.schema) {
+		return
+	}
+	for batch := range c.out {
+		if !write(batch) {
+			return
+		}
+	}
+	write(arrow.EOS)
+}
@@ -0,0 +1,199 @@
+package views
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobJournal lists, one per row, the frames, clouds and grids of a
+// session that were completely written. The recorder appends a path once
+// its file is closed and flushes the journal before the CSV files, so a
+// path referenced by a CSV row but missing from the journal was cut short
+// or never written.
+const BlobJournal = "blobs.journal"
+
+// BlobJournalHeader is the header of BlobJournal.
+var BlobJournalHeader = []string{"path"}
+
+// blobColumn reports whether CSV column name holds the path of a file in
+// the session directory.
+func blobColumn(name string) bool {
+	return name == "path" || name == "radar_grid" || strings.HasSuffix(name, "_path")
+}
+
+// ReconcileBlobs makes the CSV files of the session in dir consistent with
+// its frames, clouds and grids: a path missing from BlobJournal is cleared
+// from its row and the file, if any, removed. It returns the paths
+// cleared. The CSV files must not be open for writing.
+//
+// A session without a journal, recorded before it existed, is trusted as
+// it is and the journal is created from the files present.
+func ReconcileBlobs(dir string) ([]string, error) {
+	journal := filepath.Join(dir, BlobJournal)
+	t, err := ReadTable(journal)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, seedBlobJournal(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(t.Rows))
+	for i := range t.Rows {
+		done[t.String(i, "path")] = true
+	}
+	names, err := sessionCSVs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var cleared []string
+	for _, name := range names {
+		c, err := rewriteBlobPaths(name, func(p string) bool {
+			if done[p] {
+				return false
+			}
+			if err := os.Remove(filepath.Join(dir, filepath.FromSlash(p))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return false
+			}
+			return true
+		})
+		cleared = append(cleared, c...)
+		if err != nil {
+			return cleared, err
+		}
+	}
+	return cleared, nil
+}
+
+// BlobRefs returns the paths of the frames, clouds and grids the CSV files
+// of the session in dir refer to, by the name of the file referring to
+// them, e.g. camera.csv.
+func BlobRefs(dir string) (map[string][]string, error) {
+	names, err := sessionCSVs(dir)
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string][]string{}
+	for _, name := range names {
+		t, err := ReadTable(name)
+		if err != nil {
+			return nil, err
+		}
+		base := strings.TrimSuffix(filepath.Base(name), PartialSuffix)
+		for c, col := range t.Header {
+			if !blobColumn(col) {
+				continue
+			}
+			for _, row := range t.Rows {
+				if c < len(row) && row[c] != "" {
+					refs[base] = append(refs[base], row[c])
+				}
+			}
+		}
+	}
+	return refs, nil
+}
+
+// seedBlobJournal creates the journal of a session recorded without one
+// from the referenced files that exist.
+func seedBlobJournal(dir string) error {
+	names, err := sessionCSVs(dir)
+	if err != nil {
+		return err
+	}
+	w, err := NewCSVWriter(filepath.Join(dir, BlobJournal), BlobJournalHeader)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		t, err := ReadTable(name)
+		if err != nil {
+			continue
+		}
+		for c, col := range t.Header {
+			if !blobColumn(col) {
+				continue
+			}
+			for _, row := range t.Rows {
+				if c < len(row) && row[c] != "" && exists(filepath.Join(dir, filepath.FromSlash(row[c]))) {
+					w.Write([]string{row[c]})
+				}
+			}
+		}
+	}
+	return w.Close()
+}
+
+// rewriteBlobPaths clears the cells of the path columns of the CSV file at
+// name for which clear returns true, and returns those paths. The file is
+// replaced only if a cell changed; a last row cut short by a crash is
+// dropped then.
+func rewriteBlobPaths(name string, clear func(string) bool) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[:i+1]
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	var cols []int
+	for c, col := range records[0] {
+		if blobColumn(col) {
+			cols = append(cols, c)
+		}
+	}
+	var cleared []string
+	for _, row := range records[1:] {
+		for _, c := range cols {
+			if c < len(row) && row[c] != "" && clear(row[c]) {
+				cleared = append(cleared, row[c])
+				row[c] = ""
+			}
+		}
+	}
+	if len(cleared) == 0 {
+		return nil, nil
+	}
+	var out bytes.Buffer
+	w := csv.NewWriter(&out)
+	w.WriteAll(records)
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return cleared, nil
+}
+
+// sessionCSVs returns the CSV files of the session in dir, closed or
+// partial.
+func sessionCSVs(dir string) ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	partial, err := filepath.Glob(filepath.Join(dir, "*.csv"+PartialSuffix))
+	return append(names, partial...), err
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
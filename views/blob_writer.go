@@ -0,0 +1,149 @@
+package views
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Write modes of a BlobWriter.
+const (
+	// SyncNone leaves write-back to the page cache.
+	SyncNone = "none"
+	// SyncDSync opens files with O_DSYNC, so each write returns once the
+	// data is on the device.
+	SyncDSync = "dsync"
+	// SyncDirect writes with O_DIRECT, bypassing the page cache entirely.
+	SyncDirect = "direct"
+	// SyncPaced writes through the page cache but syncs the files written
+	// after every chunk of data, so dirty pages never pile up.
+	SyncPaced = "paced"
+)
+
+// directAlign is the buffer and length alignment used for O_DIRECT, which
+// covers the logical block size of common devices.
+const directAlign = 4096
+
+// BlobWriter writes frame and cloud files with bounded write-back, for
+// platforms where flushing gigabytes of dirty pages stalls the system for
+// seconds. It is safe for concurrent use.
+type BlobWriter struct {
+	mode  string
+	chunk int64
+	log   utils.Logger
+
+	mu       sync.Mutex
+	dirty    int64
+	pending  []string
+	syncMu   sync.Mutex
+	noDirect atomic.Bool
+}
+
+// NewBlobWriter returns a writer in mode; chunk is the number of bytes
+// between filesystem syncs in SyncPaced mode.
+func NewBlobWriter(mode string, chunk int64, log utils.Logger) *BlobWriter {
+	return &BlobWriter{mode: mode, chunk: chunk, log: log}
+}
+
+// WriteFile creates path holding data.
+func (b *BlobWriter) WriteFile(path string, data []byte) error {
+	switch b.mode {
+	case SyncDSync:
+		return writeFlags(path, data, syscall.O_DSYNC)
+	case SyncDirect:
+		if !b.noDirect.Load() {
+			err := writeDirect(path, data)
+			if !errors.Is(err, syscall.EINVAL) {
+				return err
+			}
+			// The filesystem (e.g. tmpfs) does not support O_DIRECT.
+			if !b.noDirect.Swap(true) {
+				b.log.Warnf("storage: O_DIRECT unsupported for %s, using O_DSYNC", filepath.Dir(path))
+			}
+		}
+		return writeFlags(path, data, syscall.O_DSYNC)
+	case SyncPaced:
+		if err := writeFlags(path, data, 0); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.dirty += int64(len(data))
+		b.pending = append(b.pending, path)
+		var batch []string
+		if b.dirty >= b.chunk {
+			batch, b.pending, b.dirty = b.pending, nil, 0
+		}
+		b.mu.Unlock()
+		if batch != nil {
+			b.sync(batch)
+		}
+		return nil
+	}
+	return writeFlags(path, data, 0)
+}
+
+// sync flushes a batch of written files to the device, one batch at a
+// time.
+func (b *BlobWriter) sync(paths []string) {
+	b.syncMu.Lock()
+	defer b.syncMu.Unlock()
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Fdatasync(int(f.Fd())); err != nil {
+			b.log.Warnf("storage: fdatasync %s: %v", p, err)
+		}
+		f.Close()
+	}
+}
+
+func writeFlags(path string, data []byte, flags int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|flags, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeDirect writes data with O_DIRECT from an aligned buffer padded to
+// the alignment, then truncates the padding away.
+func writeDirect(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_DIRECT, 0o644)
+	if err != nil {
+		return err
+	}
+	padded := (len(data) + directAlign - 1) / directAlign * directAlign
+	buf := alignedBuffer(padded)
+	copy(buf, data)
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Truncate(int64(len(data))); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func alignedBuffer(n int) []byte {
+	raw := make([]byte, n+directAlign)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) % directAlign); rem != 0 {
+		off = directAlign - rem
+	}
+	return raw[off : off+n]
+}
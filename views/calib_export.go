@@ -0,0 +1,68 @@
+package views
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// File names of the calibration outputs written into a session.
+const (
+	CalibYAML  = "calib.yaml"
+	KITTICalib = "calib.txt"
+)
+
+// WriteCalibration writes cal into dir both as calib.yaml (loadable with
+// utils.LoadCalibration) and as a KITTI object-detection calib.txt.
+func WriteCalibration(dir string, cal utils.CalibrationConfig) error {
+	data, err := yaml.Marshal(cal)
+	if err != nil {
+		return fmt.Errorf("marshal calibration: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, CalibYAML), data, 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", CalibYAML, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, KITTICalib), []byte(kittiCalib(cal)), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", KITTICalib, err)
+	}
+	return nil
+}
+
+// kittiCalib renders the P0-P3, R0_rect, Tr_velo_to_cam and Tr_imu_to_velo
+// lines. There is a single rectified camera, so P0-P3 are identical.
+func kittiCalib(cal utils.CalibrationConfig) string {
+	k := cal.Camera.K()
+	p := [3][4]float64{
+		{k[0][0], k[0][1], k[0][2], 0},
+		{k[1][0], k[1][1], k[1][2], 0},
+		{k[2][0], k[2][1], k[2][2], 0},
+	}
+	camOptical := cal.SensorToVehicle("camera").Mul(utils.OpticalToBody)
+	lidar := cal.SensorToVehicle("lidar")
+	veloToCam := camOptical.InverseRigid().Mul(lidar)
+	imuToVelo := lidar.InverseRigid().Mul(cal.SensorToVehicle("imu"))
+
+	var b strings.Builder
+	for i := 0; i < 4; i++ {
+		writeKITTILine(&b, fmt.Sprintf("P%d", i), p[0][:], p[1][:], p[2][:])
+	}
+	writeKITTILine(&b, "R0_rect", []float64{1, 0, 0}, []float64{0, 1, 0}, []float64{0, 0, 1})
+	writeKITTILine(&b, "Tr_velo_to_cam", veloToCam[0][:], veloToCam[1][:], veloToCam[2][:])
+	writeKITTILine(&b, "Tr_imu_to_velo", imuToVelo[0][:], imuToVelo[1][:], imuToVelo[2][:])
+	return b.String()
+}
+
+func writeKITTILine(b *strings.Builder, key string, rows ...[]float64) {
+	b.WriteString(key + ":")
+	for _, row := range rows {
+		for _, v := range row {
+			fmt.Fprintf(b, " %.12e", v)
+		}
+	}
+	b.WriteByte('\n')
+}
@@ -0,0 +1,140 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// File names of the per-session CSV outputs, see models.SchemaColumns.
+const (
+	CameraCSV           = models.CameraCSV
+	GPSCSV              = models.GPSCSV
+	IMUCSV              = models.IMUCSV
+	LidarCSV            = models.LidarCSV
+	RadarCSV            = models.RadarCSV
+	EnvCSV              = models.EnvCSV
+	TriggerCSV          = models.TriggerCSV
+	INSCSV              = models.INSCSV
+	FusedCSV            = models.FusedCSV
+	FusedIMUCSV         = models.FusedIMUCSV
+	RadarTransformedCSV = models.RadarTransformedCSV
+	RadarDetectionsCSV  = models.RadarDetectionsCSV
+	GapsCSV             = models.GapsCSV
+	SystemCSV           = models.SystemCSV
+	EventsCSV           = models.EventsCSV
+	ClockSyncCSV        = models.ClockSyncCSV
+)
+
+// SchemaColumns is models.SchemaColumns, the column order of every CSV
+// file, keyed by file name. The recorder and the model headers take their
+// columns from it; CheckSchema holds the models' rows to it.
+var SchemaColumns = models.SchemaColumns
+
+// FusedOptionalColumns is models.FusedOptionalColumns, the fused.csv
+// column groups present only when their option is enabled.
+var FusedOptionalColumns = models.FusedOptionalColumns
+
+// The columns marking the rows of the CSV files, see models.ValidColumn.
+const (
+	ValidColumn      = models.ValidColumn
+	ClockEventColumn = models.ClockEventColumn
+	SessionIDColumn  = models.SessionIDColumn
+	RecordIDColumn   = models.RecordIDColumn
+)
+
+// FusedColumns returns the columns of fused.csv for layout l, before the
+// valid and clock_event marks.
+func FusedColumns(l models.FusedLayout) []string {
+	return models.FusedRecord{}.CSVHeader(l)
+}
+
+// CheckSchema compares SchemaColumns with the headers and rows the models
+// write, and returns an error naming every file whose columns differ or
+// whose rows have a cell too many or too few. fused.csv is checked
+// without optional columns, with all of them in either projection, and
+// in each of layouts. The recording controller refuses to start on one,
+// as the rows of such a file would land under the wrong columns.
+func CheckSchema(layouts ...models.FusedLayout) error {
+	// Samples with every optional field set, next to the zero values.
+	stats := &models.ImageStats{}
+	inflight := 0
+	radar := models.RadarScan{Targets: []models.RadarTarget{{}}}
+	heading := 0.0
+	fused := models.FusedRecord{
+		Camera: &models.CameraFrame{}, GPS: &models.GPSData{Projected: &models.Projection{}}, IMU: &models.IMUData{},
+		Lidar: &models.LidarPacket{}, Radar: &radar, Env: &models.EnvData{}, HeadingDeg: &heading,
+		IMUBatch: []models.IMUData{{}}, Held: models.PresentGPS,
+	}
+	type check struct {
+		file           string
+		schema, header []string
+		rows           [][]string
+	}
+	checks := []check{
+		{CameraCSV, SchemaColumns[CameraCSV], models.CameraFrame{}.CSVHeader(),
+			[][]string{models.CameraFrame{}.CSVRow(), models.CameraFrame{Stats: stats}.CSVRow()}},
+		{GPSCSV, SchemaColumns[GPSCSV], models.GPSData{}.CSVHeader(), [][]string{models.GPSData{}.CSVRow()}},
+		{IMUCSV, SchemaColumns[IMUCSV], models.IMUData{}.CSVHeader(), [][]string{models.IMUData{}.CSVRow()}},
+		{FusedIMUCSV, SchemaColumns[FusedIMUCSV], models.IMUData{}.BatchCSVHeader(),
+			[][]string{models.IMUData{}.BatchCSVRow(time.Time{})}},
+		{LidarCSV, SchemaColumns[LidarCSV], models.LidarPacket{}.CSVHeader(), [][]string{models.LidarPacket{}.CSVRow()}},
+		{RadarCSV, SchemaColumns[RadarCSV], models.RadarScan{}.CSVHeader(), radar.CSVRows()},
+		{RadarDetectionsCSV, SchemaColumns[RadarDetectionsCSV], models.RadarScan{}.DetectionsCSVHeader(),
+			[][]string{radar.DetectionsCSVRow()}},
+		{EnvCSV, SchemaColumns[EnvCSV], models.EnvData{}.CSVHeader(), [][]string{models.EnvData{}.CSVRow()}},
+		{TriggerCSV, SchemaColumns[TriggerCSV], models.TriggerPulse{}.CSVHeader(), [][]string{models.TriggerPulse{}.CSVRow()}},
+		{INSCSV, SchemaColumns[INSCSV], models.INSData{}.CSVHeader(), [][]string{models.INSData{}.CSVRow()}},
+		{GapsCSV, SchemaColumns[GapsCSV], models.Gap{}.CSVHeader(), [][]string{models.Gap{}.CSVRow()}},
+		{SystemCSV, SchemaColumns[SystemCSV], models.SystemStats{}.CSVHeader(),
+			[][]string{models.SystemStats{}.CSVRow(), models.SystemStats{DiskInflight: &inflight}.CSVRow()}},
+		{EventsCSV, SchemaColumns[EventsCSV], models.Event{}.CSVHeader(), [][]string{models.Event{}.CSVRow()}},
+		{ClockSyncCSV, SchemaColumns[ClockSyncCSV], models.ClockOffset{}.CSVHeader(),
+			[][]string{models.ClockOffset{}.CSVRow(), models.ClockOffset{DriftKnown: true}.CSVRow()}},
+	}
+	full := models.FusedLayout{Env: true, Heading: true, RadarGrid: true, IMUBatch: true, Projection: utils.ProjectionUTM, Fill: []string{"camera", "gps", "imu", "lidar", "radar", "env"}}
+	enu := full
+	enu.Projection = utils.ProjectionENU
+	for _, l := range append([]models.FusedLayout{{}, full, enu}, layouts...) {
+		// The header of fused.csv is built from SchemaColumns for l.
+		header := FusedColumns(l)
+		checks = append(checks, check{fmt.Sprintf("%s (layout %+v)", FusedCSV, l), header, header,
+			[][]string{models.FusedRecord{}.CSVRow(l), fused.CSVRow(l)}})
+	}
+	var errs []error
+	for _, c := range checks {
+		if i := mismatch(c.schema, c.header); i >= 0 {
+			errs = append(errs, fmt.Errorf("%s: column %d is %s in SchemaColumns but %s in the model",
+				c.file, i+1, columnAt(c.schema, i), columnAt(c.header, i)))
+			continue
+		}
+		for _, row := range c.rows {
+			if len(row) != len(c.schema) {
+				errs = append(errs, fmt.Errorf("%s: the model writes rows of %d cells for %d columns", c.file, len(row), len(c.schema)))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mismatch returns the index of the first column where a and b differ,
+// -1 if they are the same.
+func mismatch(a, b []string) int {
+	for i := range max(len(a), len(b)) {
+		if i >= len(a) || i >= len(b) || a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func columnAt(cols []string, i int) string {
+	if i < len(cols) {
+		return cols[i]
+	}
+	return "missing"
+}
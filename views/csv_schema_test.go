@@ -0,0 +1,82 @@
+package views
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// fusedLayouts returns every combination of the optional fused.csv column
+// groups: the four switches, the projections and the fill sensors.
+func fusedLayouts() []models.FusedLayout {
+	var layouts []models.FusedLayout
+	for opts := range 1 << 4 {
+		for _, proj := range []string{"", utils.ProjectionUTM, utils.ProjectionENU} {
+			for fill := range 1 << len(models.FusedSensors) {
+				l := models.FusedLayout{
+					Env: opts&1 != 0, Heading: opts&2 != 0, RadarGrid: opts&4 != 0, IMUBatch: opts&8 != 0,
+					Projection: proj,
+				}
+				for i, s := range models.FusedSensors {
+					if fill&(1<<i) != 0 {
+						l.Fill = append(l.Fill, s.Name)
+					}
+				}
+				layouts = append(layouts, l)
+			}
+		}
+	}
+	return layouts
+}
+
+func TestCheckSchema(t *testing.T) {
+	for _, l := range fusedLayouts() {
+		t.Run(fmt.Sprintf("%+v", l), func(t *testing.T) {
+			if err := CheckSchema(l); err != nil {
+				t.Fatal(err)
+			}
+			cols := FusedColumns(l)
+			if !slices.Equal(cols[:len(SchemaColumns[FusedCSV])], SchemaColumns[FusedCSV]) {
+				t.Errorf("fused columns %v do not start with SchemaColumns", cols)
+			}
+			seen := map[string]bool{}
+			for _, c := range cols {
+				if seen[c] {
+					t.Errorf("column %s twice in %v", c, cols)
+				}
+				seen[c] = true
+			}
+		})
+	}
+}
+
+func TestCheckSchemaMismatch(t *testing.T) {
+	tests := []struct {
+		file string
+		edit func([]string) []string
+		want string
+	}{
+		{GPSCSV, func(c []string) []string { return append(c, "extra") }, "gps.csv: the model writes rows of 14 cells for 15 columns"},
+		{IMUCSV, func(c []string) []string { return c[:len(c)-1] }, "imu.csv: the model writes rows of 11 cells for 10 columns"},
+		{FusedCSV, func(c []string) []string { return append(c, "extra") }, "the model writes rows of 23 cells for 24 columns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			orig := SchemaColumns[tt.file]
+			t.Cleanup(func() { SchemaColumns[tt.file] = orig })
+			SchemaColumns[tt.file] = tt.edit(slices.Clone(orig))
+
+			err := CheckSchema()
+			if err == nil {
+				t.Fatalf("no error for edited %s", tt.file)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package views
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// PartialSuffix marks a CSV file still being written: a CSVWriter writes
+// to path+PartialSuffix and renames it to path once closed, so a file
+// left with the suffix was cut short by a crash or a failed close.
+const PartialSuffix = ".partial"
+
+// SessionFile returns path if it exists, otherwise its partial file if
+// that exists, otherwise path.
+func SessionFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		if _, err := os.Stat(path + PartialSuffix); err == nil {
+			return path + PartialSuffix
+		}
+	}
+	return path
+}
+
+// CSVWriter appends rows to one CSV file. It is safe for concurrent use.
+type CSVWriter struct {
+	mu     sync.Mutex
+	path   string
+	header []string
+	f      io.WriteCloser
+	buf    *bufio.Writer
+	csv    *csv.Writer
+	rows   int64
+	bytes  countingWriter
+	errs   WriterErrors
+	io     ioStats
+
+	// partial is the file written until Close, empty for a discarding
+	// writer.
+	partial string
+}
+
+// WriterErrors counts the failed operations of a CSVWriter.
+type WriterErrors struct {
+	Write int64 `json:"write"`
+	Flush int64 `json:"flush"`
+}
+
+// WriterStats is a snapshot of the I/O of one session file, a CSV file or
+// a binary log.
+type WriterStats struct {
+	Rows int64
+	// Bytes is the size of the file; Pending of them are still buffered
+	// in memory.
+	Bytes   int64
+	Pending int64
+	// Flushes counts the flushes to the operating system, FlushTime adds
+	// up their durations and MaxFlush is the slowest.
+	Flushes   int64
+	FlushTime time.Duration
+	MaxFlush  time.Duration
+	Errors    WriterErrors
+	// Err is the last failed write or flush, nil while the file is
+	// healthy. Reopen clears it.
+	Err error
+}
+
+// ioStats holds the flush timings and last error of a writer, guarded by
+// the writer's mutex.
+type ioStats struct {
+	flushes   int64
+	flushTime time.Duration
+	maxFlush  time.Duration
+	err       error
+}
+
+// flushed records a flush that took d.
+func (s *ioStats) flushed(d time.Duration) {
+	s.flushes++
+	s.flushTime += d
+	s.maxFlush = max(s.maxFlush, d)
+}
+
+// fill copies the stats into ws.
+func (s *ioStats) fill(ws *WriterStats) {
+	ws.Flushes, ws.FlushTime, ws.MaxFlush, ws.Err = s.flushes, s.flushTime, s.maxFlush, s.err
+}
+
+// countingWriter counts the bytes passed through to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewCSVWriter creates the partial file of path and writes header as its
+// first row. Close renames it to path.
+func NewCSVWriter(path string, header []string) (*CSVWriter, error) {
+	f, err := os.Create(path + PartialSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	buf := bufio.NewWriterSize(f, 64*1024)
+	w := &CSVWriter{path: path, header: header, partial: path + PartialSuffix, f: f, buf: buf}
+	w.bytes.w = buf
+	w.csv = csv.NewWriter(&w.bytes)
+	if err := w.csv.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write header %s: %w", path, err)
+	}
+	return w, nil
+}
+
+// AppendCSVWriter continues the CSV file at path left by an earlier run,
+// or its partial file, which must have the same header. A last row cut
+// short by a crash is removed. A file that was closed goes back to being
+// partial until Close. Rows and Bytes include what the file already holds.
+func AppendCSVWriter(path string, header []string) (*CSVWriter, error) {
+	partial := path + PartialSuffix
+	if SessionFile(path) == path {
+		if err := os.Rename(path, partial); err != nil {
+			return nil, fmt.Errorf("open %s: %w", path, err)
+		}
+	}
+	f, err := os.OpenFile(partial, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	rows, end, err := scanCSV(f, header)
+	if err == nil {
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("append to %s: %w", path, err)
+	}
+	buf := bufio.NewWriterSize(f, 64*1024)
+	w := &CSVWriter{path: path, header: header, partial: partial, f: f, buf: buf, rows: rows}
+	w.bytes = countingWriter{w: buf, n: end}
+	w.csv = csv.NewWriter(&w.bytes)
+	return w, nil
+}
+
+// scanCSV checks that f starts with header and returns the number of data
+// rows and the offset just past the last complete line.
+func scanCSV(f *os.File, header []string) (rows, end int64, err error) {
+	var want bytes.Buffer
+	cw := csv.NewWriter(&want)
+	cw.Write(header)
+	cw.Flush()
+	br := bufio.NewReaderSize(f, 64*1024)
+	first, err := br.ReadBytes('\n')
+	if err != nil || !bytes.Equal(first, want.Bytes()) {
+		return 0, 0, errors.New("its columns differ from the configured ones")
+	}
+	end = int64(len(first))
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return rows, end, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		rows++
+		end += int64(len(line))
+	}
+}
+
+// NewDiscardCSVWriter returns a CSVWriter that counts rows and bytes
+// without storing them, for dry runs. Its Path is name.
+func NewDiscardCSVWriter(name string, header []string) *CSVWriter {
+	buf := bufio.NewWriterSize(discardFile{}, 64*1024)
+	w := &CSVWriter{path: name, header: header, f: discardFile{}, buf: buf}
+	w.bytes.w = buf
+	w.csv = csv.NewWriter(&w.bytes)
+	w.csv.Write(header)
+	return w
+}
+
+// discardFile is the file of a discarding writer.
+type discardFile struct{}
+
+func (discardFile) Write(p []byte) (int, error) { return len(p), nil }
+
+func (discardFile) Close() error { return nil }
+
+// Write appends one row. Rows are buffered until Flush or Close. Once the
+// underlying file has failed every later Write fails too.
+func (w *CSVWriter) Write(row []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.csv.Write(row); err != nil {
+		w.errs.Write++
+		w.io.err = err
+		return err
+	}
+	w.rows++
+	return nil
+}
+
+// Flush pushes buffered rows to the operating system.
+func (w *CSVWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	start := time.Now()
+	w.csv.Flush()
+	err := w.csv.Error()
+	if err == nil {
+		err = w.buf.Flush()
+	}
+	w.io.flushed(time.Since(start))
+	if err != nil {
+		w.errs.Flush++
+		w.io.err = err
+		return err
+	}
+	return nil
+}
+
+// Reopen closes the current file, abandoning rows that could not be
+// written and leaving it partial, and continues in a new file at path
+// starting with the header. Row and byte counts carry on from the old
+// file.
+func (w *CSVWriter) Reopen(path string) error {
+	f, err := os.Create(path + PartialSuffix)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.f.Close()
+	w.path, w.partial, w.f = path, path+PartialSuffix, f
+	w.buf = bufio.NewWriterSize(f, 64*1024)
+	w.bytes.w = w.buf
+	w.csv = csv.NewWriter(&w.bytes)
+	w.io.err = nil
+	if err := w.csv.Write(w.header); err != nil {
+		return fmt.Errorf("write header %s: %w", path, err)
+	}
+	return nil
+}
+
+// Errors returns the number of failed writes and flushes so far.
+func (w *CSVWriter) Errors() WriterErrors {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.errs
+}
+
+// Stats returns the counters of the file.
+func (w *CSVWriter) Stats() WriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.csv.Flush()
+	s := WriterStats{Rows: w.rows, Bytes: w.bytes.n, Pending: int64(w.buf.Buffered()), Errors: w.errs}
+	w.io.fill(&s)
+	return s
+}
+
+// Close flushes and closes the file and, if that succeeded, renames the
+// partial file to the path of the writer.
+func (w *CSVWriter) Close() error {
+	err := w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && w.partial != "" {
+		err = os.Rename(w.partial, w.path)
+	}
+	return err
+}
+
+// Rows returns the number of data rows written, excluding the header.
+func (w *CSVWriter) Rows() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rows
+}
+
+// Bytes returns the size of the file including rows not yet flushed.
+func (w *CSVWriter) Bytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.csv.Flush()
+	return w.bytes.n
+}
+
+// Path returns the file path of the writer.
+func (w *CSVWriter) Path() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.path
+}
@@ -0,0 +1,77 @@
+package views
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Table is a CSV file of a session read back into memory.
+type Table struct {
+	Header []string
+	Rows   [][]string
+	index  map[string]int
+}
+
+// ReadTable reads the CSV file at path, or its partial file when a
+// session did not close cleanly (see SessionFile). The first row is the
+// header.
+func ReadTable(path string) (*Table, error) {
+	path = SessionFile(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("read %s: missing header", path)
+	}
+	return newTable(records[0], records[1:]), nil
+}
+
+func newTable(header []string, rows [][]string) *Table {
+	t := &Table{Header: header, Rows: rows, index: map[string]int{}}
+	for i, name := range t.Header {
+		t.index[name] = i
+	}
+	return t
+}
+
+// Col returns the index of the named column, or -1.
+func (t *Table) Col(name string) int {
+	if i, ok := t.index[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// String returns the named cell of row i, or "" if the column is absent.
+func (t *Table) String(i int, name string) string {
+	c := t.Col(name)
+	if c < 0 || c >= len(t.Rows[i]) {
+		return ""
+	}
+	return t.Rows[i][c]
+}
+
+// Float parses the named cell of row i. Empty or absent cells report false.
+func (t *Table) Float(i int, name string) (float64, bool) {
+	v, err := strconv.ParseFloat(t.String(i, name), 64)
+	return v, err == nil
+}
+
+// Time parses the timestamp column of row i.
+func (t *Table) Time(i int) (time.Time, bool) {
+	ts, err := utils.ParseTimestamp(t.String(i, "timestamp"))
+	return ts, err == nil
+}
@@ -0,0 +1,279 @@
+package views
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/foxglove"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/framecodec"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/transform"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Foxglove point field types.
+const (
+	fgUint8   = 1
+	fgFloat32 = 7
+)
+
+// JSON schemas of the Foxglove message types published, see
+// https://docs.foxglove.dev/docs/visualization/message-schemas.
+var (
+	fgTimeSchema = `{"type":"object","properties":{"sec":{"type":"integer"},"nsec":{"type":"integer"}}}`
+	fgVecSchema  = `{"type":"object","properties":{"x":{"type":"number"},"y":{"type":"number"},"z":{"type":"number"}}}`
+	fgQuatSchema = `{"type":"object","properties":{"x":{"type":"number"},"y":{"type":"number"},"z":{"type":"number"},"w":{"type":"number"}}}`
+	fgBytes      = `{"type":"string","contentEncoding":"base64"}`
+
+	fgCompressedImageSchema = `{"type":"object","properties":{"timestamp":` + fgTimeSchema +
+		`,"frame_id":{"type":"string"},"data":` + fgBytes + `,"format":{"type":"string"}}}`
+	fgPointCloudSchema = `{"type":"object","properties":{"timestamp":` + fgTimeSchema +
+		`,"frame_id":{"type":"string"},"pose":{"type":"object","properties":{"position":` + fgVecSchema + `,"orientation":` + fgQuatSchema + `}}` +
+		`,"point_stride":{"type":"integer"},"fields":{"type":"array","items":{"type":"object","properties":` +
+		`{"name":{"type":"string"},"offset":{"type":"integer"},"type":{"type":"integer"}}}},"data":` + fgBytes + `}}`
+	fgLocationFixSchema = `{"type":"object","properties":{"timestamp":` + fgTimeSchema +
+		`,"frame_id":{"type":"string"},"latitude":{"type":"number"},"longitude":{"type":"number"},"altitude":{"type":"number"}` +
+		`,"position_covariance":{"type":"array","items":{"type":"number"},"minItems":9,"maxItems":9},"position_covariance_type":{"type":"integer"}}}`
+	fgFrameTransformsSchema = `{"type":"object","properties":{"transforms":{"type":"array","items":{"type":"object","properties":{"timestamp":` + fgTimeSchema +
+		`,"parent_frame_id":{"type":"string"},"child_frame_id":{"type":"string"},"translation":` + fgVecSchema + `,"rotation":` + fgQuatSchema + `}}}}}`
+)
+
+type fgTime struct {
+	Sec  uint32 `json:"sec"`
+	Nsec uint32 `json:"nsec"`
+}
+
+func fgTimeOf(t time.Time) fgTime {
+	return fgTime{Sec: uint32(t.Unix()), Nsec: uint32(t.Nanosecond())}
+}
+
+type fgVector struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+type fgQuaternion struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+	W float64 `json:"w"`
+}
+
+type fgPose struct {
+	Position    fgVector     `json:"position"`
+	Orientation fgQuaternion `json:"orientation"`
+}
+
+type fgField struct {
+	Name   string `json:"name"`
+	Offset uint32 `json:"offset"`
+	Type   int    `json:"type"`
+}
+
+type fgPointCloud struct {
+	Timestamp   fgTime    `json:"timestamp"`
+	FrameID     string    `json:"frame_id"`
+	Pose        fgPose    `json:"pose"`
+	PointStride int       `json:"point_stride"`
+	Fields      []fgField `json:"fields"`
+	Data        []byte    `json:"data"`
+}
+
+type fgFrameTransform struct {
+	Timestamp     fgTime       `json:"timestamp"`
+	ParentFrameID string       `json:"parent_frame_id"`
+	ChildFrameID  string       `json:"child_frame_id"`
+	Translation   fgVector     `json:"translation"`
+	Rotation      fgQuaternion `json:"rotation"`
+}
+
+// identityPose places a cloud at the origin of its frame.
+var identityPose = fgPose{Orientation: fgQuaternion{W: 1}}
+
+// lidarFields describes the points of models.PointsXYZIRT; the other
+// formats are prefixes of it.
+var lidarFields = []fgField{
+	{"x", 0, fgFloat32}, {"y", 4, fgFloat32}, {"z", 8, fgFloat32}, {"intensity", 12, fgFloat32},
+	{"return", 16, fgUint8}, {"ring", 17, fgUint8}, {"time", 20, fgFloat32},
+}
+
+// radarFields describes the points FoxgloveBridge builds from radar
+// targets: x, y, z, radial velocity and RCS as float32.
+var radarFields = []fgField{
+	{"x", 0, fgFloat32}, {"y", 4, fgFloat32}, {"z", 8, fgFloat32}, {"velocity", 12, fgFloat32}, {"rcs", 16, fgFloat32},
+}
+
+// FoxgloveBridge publishes every sample on the channels of a Foxglove
+// WebSocket server: frames as foxglove.CompressedImage, lidar clouds and
+// radar targets as foxglove.PointCloud, fixes as foxglove.LocationFix and
+// the IMU, environment and INS samples as they are. The calibration
+// extrinsics are latched on /tf as foxglove.FrameTransforms, placing each
+// sensor frame in the vehicle frame. Samples are only encoded while a
+// client is subscribed to their channel.
+type FoxgloveBridge struct {
+	srv *foxglove.Server
+	log utils.Logger
+
+	camera, lidar, radar, gps, imu, env, ins uint32
+}
+
+func NewFoxgloveBridge(srv *foxglove.Server, cal utils.CalibrationConfig, log utils.Logger) *FoxgloveBridge {
+	b := &FoxgloveBridge{srv: srv, log: log}
+	advertise := func(topic, schemaName, schema string) uint32 {
+		return srv.Advertise(foxglove.Channel{
+			Topic: topic, Encoding: "json", SchemaName: schemaName, Schema: schema, SchemaEncoding: "jsonschema",
+		}).ID
+	}
+	b.camera = advertise("/camera", "foxglove.CompressedImage", fgCompressedImageSchema)
+	b.lidar = advertise("/lidar", "foxglove.PointCloud", fgPointCloudSchema)
+	b.radar = advertise("/radar", "foxglove.PointCloud", fgPointCloudSchema)
+	b.gps = advertise("/gps", "foxglove.LocationFix", fgLocationFixSchema)
+	b.imu = advertise("/imu", "sensor_logger.IMU", objectSchema("seq", "ax", "ay", "az", "gx", "gy", "gz", "mx", "my", "mz"))
+	b.env = advertise("/env", "sensor_logger.Env", objectSchema("temperature_c", "humidity_pct", "pressure_hpa"))
+	b.ins = advertise("/ins", "sensor_logger.INS", objectSchema("seq", "status", "roll_deg", "pitch_deg", "yaw_deg",
+		"vel_n_mps", "vel_e_mps", "vel_d_mps", "att_acc_deg", "pos_acc_m", "vel_acc_mps"))
+	if len(cal.Extrinsics) > 0 {
+		tf := advertise("/tf", "foxglove.FrameTransforms", fgFrameTransformsSchema)
+		now := utils.Now()
+		names := make([]string, 0, len(cal.Extrinsics))
+		for name := range cal.Extrinsics {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		var transforms []fgFrameTransform
+		for _, name := range names {
+			e := cal.Extrinsics[name]
+			x, y, z, w := e.Quaternion()
+			transforms = append(transforms, fgFrameTransform{
+				Timestamp: fgTimeOf(now), ParentFrameID: string(transform.FrameVehicle), ChildFrameID: name,
+				Translation: fgVector{e.Translation[0], e.Translation[1], e.Translation[2]},
+				Rotation:    fgQuaternion{x, y, z, w},
+			})
+		}
+		if payload, err := json.Marshal(map[string]any{"transforms": transforms}); err == nil {
+			srv.Latch(tf, now, payload)
+		}
+	}
+	return b
+}
+
+// objectSchema returns the schema of a JSON object with a timestamp string
+// and the given numeric properties.
+func objectSchema(numbers ...string) string {
+	var b strings.Builder
+	b.WriteString(`{"type":"object","properties":{"timestamp":{"type":"string"}`)
+	for _, n := range numbers {
+		b.WriteString(`,"` + n + `":{"type":"number"}`)
+	}
+	b.WriteString(`}}`)
+	return b.String()
+}
+
+func (b *FoxgloveBridge) RecordCamera(f models.CameraFrame) {
+	if len(f.Data) == 0 || !b.srv.Subscribed(b.camera) {
+		return
+	}
+	format := framecodec.Sniff(f.Data)
+	if format == "" {
+		return
+	}
+	b.publish(b.camera, f.Timestamp, map[string]any{
+		"timestamp": fgTimeOf(f.Timestamp), "frame_id": "camera", "data": f.Data, "format": format,
+	})
+}
+
+func (b *FoxgloveBridge) RecordGPS(g models.GPSData) {
+	if !b.srv.Subscribed(b.gps) {
+		return
+	}
+	// Covariance type 2 is diagonal known, 0 unknown.
+	var cov [9]float64
+	covType := 0
+	if g.HAccM != nil && g.VAccM != nil {
+		cov[0], cov[4], cov[8] = *g.HAccM**g.HAccM, *g.HAccM**g.HAccM, *g.VAccM**g.VAccM
+		covType = 2
+	}
+	b.publish(b.gps, g.Timestamp, map[string]any{
+		"timestamp": fgTimeOf(g.Timestamp), "frame_id": "gps",
+		"latitude": g.Lat, "longitude": g.Lon, "altitude": g.Alt,
+		"position_covariance": cov, "position_covariance_type": covType,
+	})
+}
+
+func (b *FoxgloveBridge) RecordIMU(m models.IMUData) {
+	if b.srv.Subscribed(b.imu) {
+		b.publish(b.imu, m.Timestamp, m)
+	}
+}
+
+func (b *FoxgloveBridge) RecordLidar(l models.LidarPacket) {
+	if len(l.RawCloud) == 0 || !b.srv.Subscribed(b.lidar) {
+		return
+	}
+	stride := models.PointSize(l.PointFormat())
+	n := len(lidarFields)
+	for n > 0 && int(lidarFields[n-1].Offset) >= stride {
+		n--
+	}
+	b.publish(b.lidar, l.Timestamp, fgPointCloud{
+		Timestamp: fgTimeOf(l.Timestamp), FrameID: "lidar", Pose: identityPose,
+		PointStride: stride, Fields: lidarFields[:n], Data: l.RawCloud,
+	})
+}
+
+func (b *FoxgloveBridge) RecordRadar(s models.RadarScan) {
+	if !b.srv.Subscribed(b.radar) {
+		return
+	}
+	const stride = 20
+	data := make([]byte, 0, len(s.Targets)*stride)
+	for _, t := range s.Targets {
+		sin, cos := math.Sincos(t.AzimuthDeg * math.Pi / 180)
+		for _, v := range []float64{t.RangeM * cos, t.RangeM * sin, 0, t.VelocityMps, t.RCS} {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(v)))
+		}
+	}
+	b.publish(b.radar, s.Timestamp, fgPointCloud{
+		Timestamp: fgTimeOf(s.Timestamp), FrameID: "radar", Pose: identityPose,
+		PointStride: stride, Fields: radarFields, Data: data,
+	})
+}
+
+func (b *FoxgloveBridge) RecordEnv(e models.EnvData) {
+	if b.srv.Subscribed(b.env) {
+		b.publish(b.env, e.Timestamp, e)
+	}
+}
+
+// RecordTrigger publishes nothing: the pulses only matter through the
+// trigger IDs of the frames and clouds.
+func (b *FoxgloveBridge) RecordTrigger(models.TriggerPulse) {}
+
+func (b *FoxgloveBridge) RecordINS(d models.INSData) {
+	if b.srv.Subscribed(b.ins) {
+		b.publish(b.ins, d.Timestamp, d)
+	}
+}
+
+// Close disconnects all clients and logs how many messages slow clients
+// missed.
+func (b *FoxgloveBridge) Close() error {
+	if n := b.srv.Dropped(); n > 0 {
+		b.log.Warnf("foxglove: %d messages dropped for slow clients", n)
+	}
+	return b.srv.Close()
+}
+
+func (b *FoxgloveBridge) publish(channel uint32, ts time.Time, v any) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		b.log.Errorf("foxglove: encode: %v", err)
+		return
+	}
+	b.srv.Publish(channel, ts, payload)
+}
@@ -0,0 +1,134 @@
+package views
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FrameReader reads back the frames a session saved, by the names
+// camera.csv refers to them by. A frame that is not there is an
+// fs.ErrNotExist.
+type FrameReader interface {
+	ReadFrame(name string) ([]byte, error)
+	// HasFrame reports whether the frame is there, without reading it.
+	HasFrame(name string) bool
+	Close() error
+}
+
+// ErrRemoteFrames is returned for the frames of a session put to S3,
+// which are not read back from the session directory.
+var ErrRemoteFrames = errors.New("frames are not in the session")
+
+// OpenFrames returns a reader of the frames of the session in dir, as its
+// manifest records them: files of the session, or the members of
+// FramesTar. A session without a manifest has its frames in FramesTar if
+// that is there. Frames put to S3 are an ErrRemoteFrames.
+func OpenFrames(dir string) (FrameReader, error) {
+	store := ""
+	m, err := ReadManifest(dir)
+	switch {
+	case err == nil:
+		store = m.FrameStore
+	case errors.Is(err, fs.ErrNotExist):
+		if _, err := os.Stat(filepath.Join(dir, FramesTar)); err == nil {
+			store = FramesTar
+		}
+	default:
+		return nil, err
+	}
+	switch store {
+	case "":
+		return DirFrameReader(dir), nil
+	case FramesTar:
+		return OpenTarFrames(filepath.Join(dir, FramesTar))
+	}
+	return nil, fmt.Errorf("%w but in %s", ErrRemoteFrames, store)
+}
+
+// DirFrameReader reads the frames saved as files under the session
+// directory.
+type DirFrameReader string
+
+func (d DirFrameReader) ReadFrame(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+func (d DirFrameReader) HasFrame(name string) bool {
+	_, err := os.Stat(filepath.Join(string(d), filepath.FromSlash(name)))
+	return err == nil
+}
+
+func (DirFrameReader) Close() error { return nil }
+
+// TarFrameReader reads the frames of a FramesTar archive. The members are
+// indexed when it is opened; of a frame saved twice, the last is read. A
+// member cut short by a crash is left out.
+type TarFrameReader struct {
+	f       *os.File
+	members map[string]tarMember
+	names   []string
+}
+
+type tarMember struct{ off, size int64 }
+
+// OpenTarFrames indexes the archive at path.
+func OpenTarFrames(path string) (*TarFrameReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &TarFrameReader{f: f, members: map[string]tarMember{}}
+	cr := &countingReader{r: bufio.NewReaderSize(f, 64*1024)}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, tar.ErrHeader) {
+				f.Close()
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			break
+		}
+		off := cr.n
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			break
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, ok := r.members[hdr.Name]; !ok {
+			r.names = append(r.names, hdr.Name)
+		}
+		r.members[hdr.Name] = tarMember{off, hdr.Size}
+	}
+	return r, nil
+}
+
+func (r *TarFrameReader) ReadFrame(name string) ([]byte, error) {
+	m, ok := r.members[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: FramesTar + ":" + name, Err: fs.ErrNotExist}
+	}
+	data := make([]byte, m.size)
+	if _, err := r.f.ReadAt(data, m.off); err != nil {
+		return nil, fmt.Errorf("%s:%s: %w", FramesTar, name, err)
+	}
+	return data, nil
+}
+
+func (r *TarFrameReader) HasFrame(name string) bool {
+	_, ok := r.members[name]
+	return ok
+}
+
+// Names returns the names of the frames of the archive, in the order they
+// were first saved.
+func (r *TarFrameReader) Names() []string { return r.names }
+
+func (r *TarFrameReader) Close() error { return r.f.Close() }
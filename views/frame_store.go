@@ -0,0 +1,183 @@
+package views
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FrameStore keeps the frames a session saves. Names are slash-separated
+// paths relative to the session, e.g. frames/00000042.jpg, as camera.csv
+// refers to them. Put returns once the frame is stored; it is safe for
+// concurrent use.
+type FrameStore interface {
+	Put(name string, data []byte) error
+	Close() error
+}
+
+// DirFrameStore saves every frame as a file under the session directory
+// with a BlobWriter, the default.
+type DirFrameStore struct {
+	blobs *BlobWriter
+	mu    sync.RWMutex
+	dir   string
+}
+
+// NewDirFrameStore returns a store writing under dir with blobs.
+func NewDirFrameStore(dir string, blobs *BlobWriter) *DirFrameStore {
+	return &DirFrameStore{blobs: blobs, dir: dir}
+}
+
+func (s *DirFrameStore) Put(name string, data []byte) error {
+	s.mu.RLock()
+	dir := s.dir
+	s.mu.RUnlock()
+	return s.blobs.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), data)
+}
+
+// Reopen saves the frames from now on under dir.
+func (s *DirFrameStore) Reopen(dir string) error {
+	s.mu.Lock()
+	s.dir = dir
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *DirFrameStore) Close() error { return nil }
+
+// FramesTar is the archive holding the frames of a session saved with
+// frame_store tar.
+const FramesTar = "frames.tar"
+
+// TarFrameStore appends frames to FramesTar in the session directory, one
+// member per frame named as the file it would otherwise be. A member is
+// complete on disk, padding included, when Put returns, so an archive cut
+// short by a crash loses only the frame being written; it lacks the
+// end-of-archive marker, which tar does not need.
+type TarFrameStore struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	tw   *tar.Writer
+}
+
+// NewTarFrameStore creates FramesTar in dir.
+func NewTarFrameStore(dir string) (*TarFrameStore, error) {
+	s := &TarFrameStore{}
+	if err := s.create(dir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AppendTarFrameStore continues FramesTar in dir left by an earlier run
+// after its last complete member, dropping a member cut short by a crash
+// and the end-of-archive marker.
+func AppendTarFrameStore(dir string) (*TarFrameStore, error) {
+	path := filepath.Join(dir, FramesTar)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	cr := &countingReader{r: bufio.NewReaderSize(f, 64*1024)}
+	tr := tar.NewReader(cr)
+	var end int64
+	for {
+		if _, err = tr.Next(); err != nil {
+			break
+		}
+		if _, err = io.Copy(io.Discard, tr); err != nil {
+			break
+		}
+		// The reader has not consumed the padding of the member yet.
+		end = (cr.n + tarBlock - 1) / tarBlock * tarBlock
+	}
+	f.Close()
+	if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, tar.ErrHeader) {
+		return nil, fmt.Errorf("append to %s: %w", path, err)
+	}
+	if f, err = openTruncated(path, end); err != nil {
+		return nil, err
+	}
+	return &TarFrameStore{path: path, f: f, tw: tar.NewWriter(f)}, nil
+}
+
+// tarBlock is the size members of a tar archive are padded to.
+const tarBlock = 512
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (s *TarFrameStore) create(dir string) error {
+	path := filepath.Join(dir, FramesTar)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	s.path, s.f, s.tw = path, f, tar.NewWriter(f)
+	return nil
+}
+
+func (s *TarFrameStore) Put(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now().Truncate(time.Second),
+	}
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := s.tw.Write(data); err != nil {
+		return err
+	}
+	return s.tw.Flush()
+}
+
+// Reopen ends the current archive and continues in a new one in dir.
+func (s *TarFrameStore) Reopen(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, tw := s.f, s.tw
+	if err := s.create(dir); err != nil {
+		return err
+	}
+	tw.Close()
+	f.Close()
+	return nil
+}
+
+// Close writes the end-of-archive marker and closes the archive.
+func (s *TarFrameStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.tw.Close()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Path returns the file path of the archive.
+func (s *TarFrameStore) Path() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path
+}
@@ -0,0 +1,172 @@
+package views
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// S3FrameStore uploads every frame as an object of an S3 bucket under
+// <prefix>/<session>/, one PUT each, see utils.S3Config. Put fails at
+// once while MaxPending uploads are in flight rather than queueing frames
+// in memory behind a slow link.
+type S3FrameStore struct {
+	cfg     utils.S3Config
+	secret  string
+	base    *url.URL
+	prefix  string
+	client  *http.Client
+	pending chan struct{}
+}
+
+// NewS3FrameStore returns a store putting the frames of session to the
+// bucket of cfg.
+func NewS3FrameStore(cfg utils.S3Config, session string) (*S3FrameStore, error) {
+	secret, err := utils.ReadSecret(cfg.SecretAccessKey, cfg.SecretAccessKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("s3 secret access key: %w", err)
+	}
+	base, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("s3 endpoint: %w", err)
+	}
+	return &S3FrameStore{
+		cfg:     cfg,
+		secret:  secret,
+		base:    base,
+		prefix:  path.Join(cfg.Prefix, filepath.Base(session)),
+		client:  &http.Client{Timeout: time.Duration(cfg.TimeoutS) * time.Second},
+		pending: make(chan struct{}, cfg.MaxPending),
+	}, nil
+}
+
+// URL returns the s3:// URL of the prefix the frames of the session are
+// put under.
+func (s *S3FrameStore) URL() string {
+	return "s3://" + s.cfg.Bucket + "/" + s.prefix + "/"
+}
+
+func (s *S3FrameStore) Put(name string, data []byte) error {
+	select {
+	case s.pending <- struct{}{}:
+		defer func() { <-s.pending }()
+	default:
+		return fmt.Errorf("s3: %d uploads in flight", cap(s.pending))
+	}
+	key := path.Join(s.prefix, name)
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + key
+	u.RawPath = s3EscapePath(u.Path)
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	if s.cfg.AccessKeyID != "" {
+		s.sign(req, data, time.Now())
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3: put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("s3: put %s: %s %s", key, resp.Status, s3ErrorCode(body))
+	}
+	return nil
+}
+
+// s3ErrorCode returns the code of the XML error body of a response, e.g.
+// AccessDenied, or "" without one.
+func s3ErrorCode(body []byte) string {
+	_, rest, ok := bytes.Cut(body, []byte("<Code>"))
+	if !ok {
+		return ""
+	}
+	code, _, _ := bytes.Cut(rest, []byte("</Code>"))
+	return string(code)
+}
+
+// sign adds the headers and Authorization of AWS Signature Version 4 to
+// req, which sends payload, as of now.
+func (s *S3FrameStore) sign(req *http.Request, payload []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, s3EscapePath(req.URL.Path), req.URL.RawQuery)
+	for _, k := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", k, signed[k])
+	}
+	fmt.Fprintf(&canonical, "\n%s\n%s", strings.Join(names, ";"), payloadHash)
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	crSum := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crSum[:])
+	key := []byte("AWS4" + s.secret)
+	for _, part := range []string{day, s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, strings.Join(names, ";"), hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath percent-encodes p as Signature V4 wants it: every byte but
+// the unreserved characters and the slashes.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', strings.IndexByte("-._~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Close drops the idle connections to the service. Uploads still in
+// flight finish or time out on their own.
+func (s *S3FrameStore) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,208 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// SchemaVersion is the version of the record schemas. It changes when a
+// column is removed, renamed or changes type; adding an optional column
+// keeps it.
+const SchemaVersion = 1
+
+// SchemaBaseURI prefixes the $id of every record schema, which ends in
+// v<SchemaVersion>/<table>.json as under schema/ in the repository.
+const SchemaBaseURI = "https://github.com/lkumar3-iitr/Sensor-Logger/schema/"
+
+// RecordSchema describes the records of a table as the jsonl, mcap and
+// kafka sinks write them: an object with a property per column, null for
+// an empty cell, and in jsonl and kafka the table under "sensor".
+type RecordSchema struct {
+	Table string
+	// Columns are in every record; Optional only in the sessions with
+	// the option adding them enabled, e.g. stereo or validation marks.
+	Columns  []string
+	Optional []string
+	// Custom are the custom columns of a session (see
+	// utils.StorageConfig.CustomColumns), strings or numbers. The
+	// published schemas have none.
+	Custom []string
+}
+
+// marks are the columns validation, the clock guard and record IDs add
+// to every table of sensor records.
+var marks = []string{ValidColumn, ClockEventColumn, SessionIDColumn, RecordIDColumn}
+
+// RecordSchemas lists the schemas of the sink tables.
+var RecordSchemas = []RecordSchema{
+	{"camera", SchemaColumns[CameraCSV],
+		slices.Concat(models.CameraFrame{}.StereoCSVHeader(), (*models.TriggerMatch)(nil).CSVHeader(),
+			models.CameraFrame{}.FreezeCSVHeader(), marks), nil},
+	{"gps", SchemaColumns[GPSCSV], slices.Concat(
+		models.ProjectionCSVHeader(utils.ProjectionUTM), models.ProjectionCSVHeader(utils.ProjectionENU), marks), nil},
+	{"imu", SchemaColumns[IMUCSV], slices.Concat(models.AttitudeCSVHeader(), marks), nil},
+	{"lidar", SchemaColumns[LidarCSV], slices.Concat((*models.TriggerMatch)(nil).CSVHeader(), marks), nil},
+	{"radar", SchemaColumns[RadarCSV], marks, nil},
+	{"env", SchemaColumns[EnvCSV], marks, nil},
+	{"trigger", SchemaColumns[TriggerCSV], marks, nil},
+	{"ins", SchemaColumns[INSCSV], marks, nil},
+	{FusedTable, SchemaColumns[FusedCSV], slices.Concat(
+		FusedOptionalColumns["env"], FusedOptionalColumns["heading"], FusedOptionalColumns["radar_grid"],
+		FusedOptionalColumns["imu_batch"], FusedOptionalColumns["utm"], FusedOptionalColumns["enu"],
+		FusedOptionalColumns["fill"], marks), nil},
+	{FusedIMUTable, SchemaColumns[FusedIMUCSV], marks, nil},
+}
+
+// CheckCustomColumns returns an error naming the first of names that is
+// already a column of fused records, optional ones included.
+func CheckCustomColumns(names []string) error {
+	s, _ := LookupRecordSchema(FusedTable)
+	for _, name := range names {
+		if name == "sensor" || slices.Contains(s.Columns, name) || slices.Contains(s.Optional, name) {
+			return fmt.Errorf("%s is a column of %s already", name, FusedCSV)
+		}
+	}
+	return nil
+}
+
+// LookupRecordSchema returns the schema of table.
+func LookupRecordSchema(table string) (RecordSchema, bool) {
+	for _, s := range RecordSchemas {
+		if s.Table == table {
+			return s, true
+		}
+	}
+	return RecordSchema{}, false
+}
+
+// integerColumns and stringColumns type the columns; the others hold
+// numbers. sealedColumns hold a string instead where location encryption
+// sealed them.
+var (
+	integerColumns = map[string]bool{
+		"frame_id": true, "width": true, "height": true, "seq": true, "num_points": true,
+		"scan_seq": true, "target_id": true, "satellites": true, "fix_quality": true, "trigger_id": true,
+		"cam_frame_id": true, "lidar_seq": true, "lidar_num_points": true, "radar_seq": true,
+		"radar_num_targets": true, "present_mask": true, "imu_count": true, ValidColumn: true,
+		RecordIDColumn: true, "frozen_ms": true, "status": true, "gnss_fix": true,
+	}
+	stringColumns = map[string]bool{
+		"path": true, "right_path": true, "point_format": true, "radar_grid": true,
+		"utm_zone": true, "gps_utm_zone": true, SessionIDColumn: true, "mode": true,
+		"cam_fill": true, "gps_fill": true, "imu_fill": true, "lidar_fill": true, "radar_fill": true, "env_fill": true,
+	}
+	sealedColumns = map[string]bool{"lat": true, "lon": true, "gps_lat": true, "gps_lon": true}
+)
+
+func columnType(c string) string {
+	switch {
+	case integerColumns[c]:
+		return "integer"
+	case stringColumns[c]:
+		return "string"
+	}
+	return "number"
+}
+
+// ID returns the $id of the schema.
+func (s RecordSchema) ID() string {
+	return fmt.Sprintf("%sv%d/%s.json", SchemaBaseURI, SchemaVersion, s.Table)
+}
+
+// JSON returns the schema as a JSON Schema (draft 2020-12) document.
+func (s RecordSchema) JSON() []byte {
+	props := map[string]any{"sensor": map[string]any{"const": s.Table}}
+	for _, c := range slices.Concat(s.Columns, s.Optional) {
+		t := columnType(c)
+		if c == "timestamp" {
+			props[c] = map[string]any{"type": t, "description": "Unix time in seconds"}
+			continue
+		}
+		types := []string{t, "null"}
+		if sealedColumns[c] {
+			types = []string{t, "string", "null"}
+		}
+		props[c] = map[string]any{"type": types}
+	}
+	for _, c := range s.Custom {
+		props[c] = map[string]any{"type": []string{"string", "number", "null"}}
+	}
+	doc := map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  s.ID(),
+		"title":                "sensor-logger " + s.Table + " record",
+		"type":                 "object",
+		"properties":           props,
+		"required":             s.Columns,
+		"additionalProperties": false,
+	}
+	b, _ := json.MarshalIndent(doc, "", "  ")
+	return append(b, '\n')
+}
+
+// Validate checks a record decoded with json.Decoder.UseNumber against
+// the schema. It returns every problem found, sorted.
+func (s RecordSchema) Validate(rec map[string]any) []string {
+	var problems []string
+	for _, c := range s.Columns {
+		if _, ok := rec[c]; !ok {
+			problems = append(problems, fmt.Sprintf("missing %s", c))
+		}
+	}
+	for k, v := range rec {
+		if k == "sensor" {
+			if v != s.Table {
+				problems = append(problems, fmt.Sprintf("sensor is %v, not %s", v, s.Table))
+			}
+			continue
+		}
+		if slices.Contains(s.Custom, k) {
+			if v != nil && !hasType(v, "string") && !hasType(v, "number") {
+				problems = append(problems, fmt.Sprintf("%s is %s, not a string or number", k, describe(v)))
+			}
+			continue
+		}
+		if !slices.Contains(s.Columns, k) && !slices.Contains(s.Optional, k) {
+			problems = append(problems, fmt.Sprintf("unknown column %s", k))
+			continue
+		}
+		if v == nil {
+			if k == "timestamp" {
+				problems = append(problems, "timestamp is null")
+			}
+			continue
+		}
+		if !hasType(v, columnType(k)) && !(sealedColumns[k] && hasType(v, "string")) {
+			problems = append(problems, fmt.Sprintf("%s is %s, not %s", k, describe(v), columnType(k)))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func hasType(v any, t string) bool {
+	switch v := v.(type) {
+	case json.Number:
+		if t == "integer" {
+			return !strings.ContainsAny(string(v), ".eE")
+		}
+		return t == "number"
+	case string:
+		return t == "string"
+	}
+	return false
+}
+
+// describe returns v, quoted when it is a string.
+func describe(v any) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
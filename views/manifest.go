@@ -0,0 +1,289 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/capture"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/degrade"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/quality"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/thermal"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// ManifestFile is the session summary written when a session closes.
+const ManifestFile = "manifest.json"
+
+// Manifest describes a finished session for QA and indexing tools.
+type Manifest struct {
+	Session   string    `json:"session"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	DurationS float64   `json:"duration_s"`
+
+	// SessionID is the UUID of the session, see SessionIDFile.
+	SessionID string `json:"session_id,omitempty"`
+	// Custom are the custom columns of fused.csv and their values, see
+	// utils.StorageConfig.CustomColumns.
+	Custom map[string]string `json:"custom_columns,omitempty"`
+
+	// Profile is the sensors.yaml profile the session ran with.
+	Profile string `json:"profile,omitempty"`
+
+	// Tags label the session for searching by conditions, e.g. "rain",
+	// "night"; Notes are the free-text notes taken on it.
+	Tags  []string `json:"tags,omitempty"`
+	Notes []Note   `json:"notes,omitempty"`
+
+	// Rows is the number of data rows of every CSV file (records of every
+	// binary log), by file name.
+	Rows map[string]int64 `json:"rows"`
+
+	// Finalized is set when every CSV file was closed and renamed from
+	// its partial file (see PartialSuffix); Partial lists the files of the
+	// session left partial otherwise, by a failed close or a crashed
+	// earlier run. Manifests written before finalization have neither.
+	Finalized bool     `json:"finalized"`
+	Partial   []string `json:"partial,omitempty"`
+
+	// Unstopped lists the stages still running when the session was
+	// closed, past stop_timeout_s: the data they held last is missing.
+	Unstopped []string `json:"unstopped,omitempty"`
+	// ForcedShutdown is set when the operator interrupted the stop, which
+	// then gave up on the stages and writes still pending.
+	ForcedShutdown bool `json:"forced_shutdown,omitempty"`
+
+	// WriteErrors counts failed writes and flushes of every file;
+	// SaveErrors counts frames and clouds that could not be saved.
+	WriteErrors map[string]WriterErrors `json:"write_errors"`
+	SaveErrors  int64                   `json:"save_errors"`
+
+	// JPEGFallbacks counts the frames saved as captured, not in the
+	// configured frame_format, because the encoders were behind.
+	JPEGFallbacks int64 `json:"jpeg_fallbacks,omitempty"`
+
+	// FrameStore is where the saved frames are when not files of the
+	// session: FramesTar, or the s3:// URL of the prefix they were put
+	// under. The frame paths of camera.csv name them within it.
+	FrameStore string `json:"frame_store,omitempty"`
+
+	// FrameProcessing is set when saved frames were cropped and scaled.
+	FrameProcessing *FrameProcessing `json:"frame_processing,omitempty"`
+
+	// Failover is set when the session moved to the fallback directory.
+	Failover *Failover `json:"failover,omitempty"`
+
+	// Interruptions lists the spans the logger was down in a session that
+	// was resumed after a restart; see utils.ResumeConfig. Gap, fix and
+	// anomaly counts and the odometer cover only the run since the last
+	// one.
+	Interruptions []Interruption `json:"interruptions,omitempty"`
+
+	// ClockJumps lists the steps of the wall clock detected during the
+	// session; see utils.ClockConfig for how timestamps took them.
+	ClockJumps []utils.ClockJump `json:"clock_jumps,omitempty"`
+
+	// Adaptive is set when frames and clouds were thinned out while the
+	// vehicle was stationary.
+	Adaptive *capture.Stats `json:"adaptive,omitempty"`
+
+	// Degrade is set when frames and clouds were shed because the disk
+	// could not keep up.
+	Degrade *degrade.Stats `json:"degrade,omitempty"`
+
+	// SimThrottle is set when the simulated sensors ran under the thermal
+	// throttle and the host reported its temperature.
+	SimThrottle *thermal.Stats `json:"sim_throttle,omitempty"`
+
+	// Gaps holds the gap totals of every sensor; GapReport has the same
+	// as one readable line per sensor, e.g. "camera: 37 gaps, max 412 ms".
+	Gaps      map[string]models.GapSummary `json:"gaps"`
+	GapReport []string                     `json:"gap_report"`
+
+	// Fixes counts the GPS fixes by fix quality, e.g. "rtk_fixed": 3420,
+	// to tell how much of a trajectory is survey grade.
+	Fixes map[string]int64 `json:"fixes,omitempty"`
+
+	// Odometer is the distance travelled and the speeds of the session,
+	// from its fixes, in m/s whatever the units of the records.
+	Odometer *quality.Odometry `json:"odometer,omitempty"`
+
+	// Anomalies counts the records that failed validation, by sensor and
+	// reason, e.g. "gps": {"negative hdop": 3}.
+	Anomalies map[string]map[string]int64 `json:"anomalies,omitempty"`
+
+	// Hooks holds the outcome of the post-processing commands of the last
+	// hooks run on the session.
+	Hooks []HookResult `json:"hooks,omitempty"`
+
+	// Redactions lists the runs of "sessions redact" on the session.
+	Redactions []Redaction `json:"redactions,omitempty"`
+
+	// LocationEncrypted is set while the latitude and longitude columns
+	// are encrypted with the key in location.key; see
+	// utils.LocationEncryptionConfig.
+	LocationEncrypted bool `json:"location_encrypted,omitempty"`
+
+	// Units are those of the speeds, angles and positions of the records;
+	// see utils.UnitsConfig. Sessions without them are in
+	// utils.DefaultUnits.
+	Units utils.UnitsConfig `json:"units"`
+
+	// Projection is set when gps.csv and fused.csv have the projected
+	// position of every fix; see utils.StorageConfig.Projection.
+	Projection *Projection `json:"projection,omitempty"`
+}
+
+// Projection describes the projected columns of a session: their kind,
+// the UTM zone they are in and the first fix, which anchors the zone or
+// the ENU frame. Zone and Origin are unset without a fix.
+type Projection struct {
+	Kind   string            `json:"kind"`
+	Zone   string            `json:"zone,omitempty"`
+	Origin *ProjectionOrigin `json:"origin,omitempty"`
+}
+
+// ProjectionOrigin is the position of the first fix of a session.
+type ProjectionOrigin struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	Alt float64 `json:"alt"`
+}
+
+// Note is a free-text note on a session. At is "start" or "stop" for the
+// notes taken as the session started and stopped, empty for those added
+// over HTTP while it ran.
+type Note struct {
+	Time time.Time `json:"time"`
+	At   string    `json:"at,omitempty"`
+	Text string    `json:"text"`
+}
+
+// Interruption is a span between the last write before a logger restart
+// and the resumption of the session.
+type Interruption struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	DurationS float64   `json:"duration_s"`
+}
+
+// HookResult is the outcome of one post-processing command. Output keeps
+// the end of the command's combined output when it failed. Skipped is set
+// for the commands after a failed one, which are not run.
+type HookResult struct {
+	Name      string    `json:"name"`
+	Start     time.Time `json:"start,omitzero"`
+	DurationS float64   `json:"duration_s"`
+	ExitCode  int       `json:"exit_code"`
+	Error     string    `json:"error,omitempty"`
+	Output    string    `json:"output,omitempty"`
+	Skipped   bool      `json:"skipped,omitempty"`
+}
+
+// Redaction records what a redaction removed from a session: the rows of
+// gps.csv and the rows of fused.csv whose GPS columns it cleared inside
+// Fences, and the frames it removed, or pixelated with Blur.
+type Redaction struct {
+	Time      time.Time `json:"time"`
+	Fences    []string  `json:"fences"`
+	Blur      bool      `json:"blur,omitempty"`
+	GPSRows   int       `json:"gps_rows"`
+	FusedRows int       `json:"fused_rows"`
+	Frames    int       `json:"frames"`
+}
+
+// FixReport summarizes Fixes as one line, best quality first, e.g.
+// "rtk_fixed 3420 (95.0%), rtk_float 180 (5.0%)". It is empty without
+// fixes.
+func (m *Manifest) FixReport() string {
+	var total int64
+	for _, n := range m.Fixes {
+		total += n
+	}
+	var parts []string
+	for _, q := range []int{4, 5, 2, 3, 1, 6, 7, 8} {
+		name := models.FixQualityName(q)
+		if n := m.Fixes[name]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d (%.1f%%)", name, n, float64(n)*100/float64(total)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// AnomalyReport summarizes Anomalies as one line per sensor, e.g.
+// "gps: 4 invalid (negative hdop 3, lat out of range 1)".
+func (m *Manifest) AnomalyReport() []string {
+	var lines []string
+	for _, sensor := range sortedKeys(m.Anomalies) {
+		reasons := m.Anomalies[sensor]
+		var total int64
+		parts := make([]string, 0, len(reasons))
+		for _, r := range sortedKeys(reasons) {
+			total += reasons[r]
+			parts = append(parts, fmt.Sprintf("%s %d", r, reasons[r]))
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d invalid (%s)", sensor, total, strings.Join(parts, ", ")))
+	}
+	return lines
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FrameProcessing summarises the cropping and scaling of the saved
+// frames: the region kept and the width frames were scaled down to, the
+// frames processed, those saved at full resolution, and those not saved
+// because the encoders were behind.
+type FrameProcessing struct {
+	ROI      utils.ROIConfig `json:"roi"`
+	MaxWidth int             `json:"max_width,omitempty"`
+	Frames   int64           `json:"frames"`
+	FullRes  int64           `json:"full_res"`
+	Skipped  int64           `json:"skipped"`
+}
+
+// Failover records the switch of a session to the fallback directory.
+// Files in From end at At; the files in To start there.
+type Failover struct {
+	At     time.Time `json:"at"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// ReadManifest reads the manifest of the session in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", ManifestFile, err)
+	}
+	return m, nil
+}
+
+// WriteManifest writes m as indented JSON into dir.
+func WriteManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", ManifestFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package views
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/mqtt"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const mqttRetryInterval = 10 * time.Second
+
+// MQTTFusedPublisher publishes fused records as JSON to an MQTT topic,
+// without camera images or lidar clouds. While the broker is unreachable
+// records are dropped and the connection is retried periodically.
+type MQTTFusedPublisher struct {
+	name string
+	cfg  utils.MQTTConfig
+	log  utils.Logger
+
+	client    *mqtt.Client
+	nextDial  time.Time
+	published uint64
+	skipped   uint64
+}
+
+func NewMQTTFusedPublisher(o utils.FusedOutputConfig, log utils.Logger) *MQTTFusedPublisher {
+	return &MQTTFusedPublisher{name: o.Name, cfg: o.MQTT, log: log}
+}
+
+// Run publishes records from in until it is closed.
+func (p *MQTTFusedPublisher) Run(in <-chan models.FusedRecord) {
+	for rec := range in {
+		if !p.connected() {
+			p.skipped++
+			continue
+		}
+		payload, err := json.Marshal(telemetry(rec))
+		if err != nil {
+			p.log.Errorf("%s: encode: %v", p.name, err)
+			continue
+		}
+		if err := p.client.Publish(p.cfg.Topic, payload, p.cfg.Retain); err != nil {
+			p.log.Warnf("%s: publish to %s: %v", p.name, p.cfg.Broker, err)
+			p.disconnect()
+			p.skipped++
+			continue
+		}
+		p.published++
+	}
+	p.disconnect()
+	p.log.Infof("%s: published %d fused records to %s (%d skipped while disconnected)",
+		p.name, p.published, p.cfg.Broker, p.skipped)
+}
+
+func (p *MQTTFusedPublisher) connected() bool {
+	if p.client != nil && p.client.Err() != nil {
+		p.log.Warnf("%s: %v", p.name, p.client.Err())
+		p.disconnect()
+	}
+	if p.client != nil {
+		return true
+	}
+	if time.Now().Before(p.nextDial) {
+		return false
+	}
+	c, err := mqtt.Dial(mqtt.Options{
+		Broker:    p.cfg.Broker,
+		ClientID:  p.cfg.ClientID,
+		Username:  p.cfg.Username,
+		Password:  p.cfg.Password,
+		KeepAlive: time.Duration(p.cfg.KeepAliveS) * time.Second,
+	})
+	if err != nil {
+		p.log.Warnf("%s: %v (retrying in %v)", p.name, err, mqttRetryInterval)
+		p.nextDial = time.Now().Add(mqttRetryInterval)
+		return false
+	}
+	p.log.Infof("%s: connected to %s, publishing on %s", p.name, p.cfg.Broker, p.cfg.Topic)
+	p.client = c
+	return true
+}
+
+func (p *MQTTFusedPublisher) disconnect() {
+	if p.client != nil {
+		p.client.Close()
+		p.client = nil
+		p.nextDial = time.Now().Add(mqttRetryInterval)
+	}
+}
+
+// telemetry strips the bulky payloads from a fused record.
+func telemetry(rec models.FusedRecord) models.FusedRecord {
+	if rec.Camera != nil {
+		c := *rec.Camera
+		c.Data = nil
+		rec.Camera = &c
+	}
+	if rec.Lidar != nil {
+		l := *rec.Lidar
+		l.RawCloud = nil
+		rec.Lidar = &l
+	}
+	return rec
+}
@@ -0,0 +1,237 @@
+package views
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// File names of the packet captures of a session.
+const (
+	LidarPcap = "lidar.pcap"
+	RadarPcap = "radar.pcap"
+)
+
+// Link types of the packet captures: IPv4 or IPv6 packets without a link
+// header for UDP devices, and SocketCAN frames (identifier big-endian)
+// for CAN devices.
+const (
+	LinkTypeRaw = 101
+	LinkTypeCAN = 227
+)
+
+// pcapSnapLen is the largest packet the captures hold whole; a UDP
+// datagram is no larger.
+const pcapSnapLen = 65535
+
+// A capture is a classic pcap file: a 24-byte header of magic
+// (microsecond timestamps), version 2.4, zone and accuracy (0), snap
+// length and link type, then per packet a 16-byte header of timestamp
+// (seconds, microseconds), captured and original length, and the packet.
+// Everything is little-endian.
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapHeaderSize   = 24
+	pcapRecordHeader = 16
+)
+
+// PcapWriter appends packets to a pcap file, with the same counters and
+// failover behaviour as CSVWriter. It is safe for concurrent use.
+type PcapWriter struct {
+	mu       sync.Mutex
+	path     string
+	linkType uint32
+	discard  bool
+	f        io.WriteCloser
+	buf      *bufio.Writer
+	rows     int64
+	bytes    int64
+	errs     WriterErrors
+	io       ioStats
+	scratch  []byte
+}
+
+// NewPcapWriter creates the capture at path for packets of linkType.
+func NewPcapWriter(path string, linkType uint32) (*PcapWriter, error) {
+	w := &PcapWriter{linkType: linkType}
+	if err := w.create(path); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// AppendPcapWriter continues the capture at path left by an earlier run.
+// A packet cut short by a crash is removed.
+func AppendPcapWriter(path string, linkType uint32) (*PcapWriter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &PcapWriter{path: path, linkType: linkType}
+	br := bufio.NewReaderSize(f, 64*1024)
+	var hdr [pcapHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("append to %s: %w", path, err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:]) != pcapMagic || binary.LittleEndian.Uint32(hdr[20:]) != linkType {
+		f.Close()
+		return nil, fmt.Errorf("append to %s: not a capture of link type %d", path, linkType)
+	}
+	end := int64(pcapHeaderSize)
+	for {
+		var rec [pcapRecordHeader]byte
+		if _, err = io.ReadFull(br, rec[:]); err != nil {
+			break
+		}
+		n := int64(binary.LittleEndian.Uint32(rec[8:]))
+		if _, err = br.Discard(int(n)); err != nil {
+			break
+		}
+		end += pcapRecordHeader + n
+		w.rows++
+	}
+	f.Close()
+	if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("append to %s: %w", path, err)
+	}
+	if w.f, err = openTruncated(path, end); err != nil {
+		return nil, err
+	}
+	w.bytes = end
+	w.buf = bufio.NewWriterSize(w.f, 64*1024)
+	return w, nil
+}
+
+// NewDiscardPcapWriter returns a PcapWriter that counts packets and bytes
+// without storing them, for dry runs. Its Path is name.
+func NewDiscardPcapWriter(name string, linkType uint32) *PcapWriter {
+	w := &PcapWriter{linkType: linkType, discard: true}
+	w.create(name)
+	return w
+}
+
+func (w *PcapWriter) create(path string) error {
+	var f io.WriteCloser = discardFile{}
+	if !w.discard {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return fmt.Errorf("create %s: %w", path, err)
+		}
+	}
+	w.path, w.f = path, f
+	w.buf = bufio.NewWriterSize(f, 64*1024)
+	var hdr [pcapHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2)
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], w.linkType)
+	w.buf.Write(hdr[:])
+	w.bytes += pcapHeaderSize
+	return nil
+}
+
+// Write appends packet, received at ts. Packets are buffered until Flush
+// or Close; those over the snap length are cut to it.
+func (w *PcapWriter) Write(ts time.Time, packet []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	captured := packet[:min(len(packet), pcapSnapLen)]
+	w.scratch = binary.LittleEndian.AppendUint32(w.scratch[:0], uint32(ts.Unix()))
+	w.scratch = binary.LittleEndian.AppendUint32(w.scratch, uint32(ts.Nanosecond()/1000))
+	w.scratch = binary.LittleEndian.AppendUint32(w.scratch, uint32(len(captured)))
+	w.scratch = binary.LittleEndian.AppendUint32(w.scratch, uint32(len(packet)))
+	w.scratch = append(w.scratch, captured...)
+	n, err := w.buf.Write(w.scratch)
+	w.bytes += int64(n)
+	if err != nil {
+		w.errs.Write++
+		w.io.err = err
+		return err
+	}
+	w.rows++
+	return nil
+}
+
+// Flush pushes buffered packets to the operating system.
+func (w *PcapWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	start := time.Now()
+	err := w.buf.Flush()
+	w.io.flushed(time.Since(start))
+	if err != nil {
+		w.errs.Flush++
+		w.io.err = err
+		return err
+	}
+	return nil
+}
+
+// Reopen closes the current capture, abandoning packets that could not
+// be written, and continues in a new capture at path. Packet and byte
+// counts carry on from the old file.
+func (w *PcapWriter) Reopen(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f := w.f
+	if err := w.create(path); err != nil {
+		return err
+	}
+	f.Close()
+	w.io.err = nil
+	return nil
+}
+
+// Errors returns the number of failed writes and flushes so far.
+func (w *PcapWriter) Errors() WriterErrors {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.errs
+}
+
+// Close flushes and closes the capture.
+func (w *PcapWriter) Close() error {
+	err := w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Rows returns the number of packets written.
+func (w *PcapWriter) Rows() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rows
+}
+
+// Bytes returns the size of the capture including packets not yet
+// flushed.
+func (w *PcapWriter) Bytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bytes
+}
+
+// Stats returns the counters of the capture.
+func (w *PcapWriter) Stats() WriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := WriterStats{Rows: w.rows, Bytes: w.bytes, Pending: int64(w.buf.Buffered()), Errors: w.errs}
+	w.io.fill(&s)
+	return s
+}
+
+// Path returns the file path of the capture.
+func (w *PcapWriter) Path() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.path
+}
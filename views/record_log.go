@@ -0,0 +1,501 @@
+package views
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+)
+
+// File names of the binary sensor logs. Each has a sparse time index next
+// to it, named with IndexSuffix appended.
+const (
+	IMUBin   = "imu.bin"
+	RadarBin = "radar.bin"
+
+	IndexSuffix = ".idx"
+)
+
+// A record log starts with recordMagic, a format version byte and the
+// record kind ("imu" or "radar") as a uvarint-length-prefixed string.
+// Records follow as uvarint-length-prefixed protobuf messages (see
+// models/wire.go), the same framing as protobuf's delimited streams.
+//
+// The index starts with indexMagic and holds 16-byte entries of timestamp
+// (Unix ns) and byte offset of a record, both little-endian, for the
+// first record and then at most one record per index interval.
+const (
+	recordMagic   = "SLREC"
+	indexMagic    = "SLIDX"
+	recordVersion = 1
+
+	indexEntrySize = 16
+	maxRecordSize  = 16 << 20
+)
+
+// RecordWriter appends binary records to a log file and its index. It has
+// the same counters and failover behaviour as CSVWriter and is safe for
+// concurrent use.
+type RecordWriter struct {
+	mu       sync.Mutex
+	path     string
+	kind     string
+	interval time.Duration
+	discard  bool
+	f        io.WriteCloser
+	buf      *bufio.Writer
+	idxFile  io.WriteCloser
+	idx      *bufio.Writer
+	off      int64
+	indexed  time.Time
+	rows     int64
+	bytes    int64
+	errs     WriterErrors
+	io       ioStats
+	scratch  []byte
+}
+
+// NewRecordWriter creates the log at path and its index. A record is
+// indexed at most every interval.
+func NewRecordWriter(path, kind string, interval time.Duration) (*RecordWriter, error) {
+	w := &RecordWriter{kind: kind, interval: interval}
+	if err := w.create(path); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// AppendRecordWriter continues the log at path of the given kind left by
+// an earlier run. A record cut short by a crash is removed, as are index
+// entries pointing past the last whole record.
+func AppendRecordWriter(path, kind string, interval time.Duration) (*RecordWriter, error) {
+	r, err := OpenRecordLog(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &RecordWriter{path: path, kind: kind, interval: interval, off: r.start}
+	for err == nil {
+		var msg []byte
+		if msg, err = r.Next(); err == nil {
+			w.off += int64(len(binary.AppendUvarint(nil, uint64(len(msg))))) + int64(len(msg))
+			w.rows++
+		}
+	}
+	index := r.index
+	r.Close()
+	if r.kind != kind {
+		return nil, fmt.Errorf("append to %s: holds %s records, not %s", path, r.kind, kind)
+	}
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("append to %s: %w", path, err)
+	}
+	for len(index) > 0 && index[len(index)-1].Offset >= w.off {
+		index = index[:len(index)-1]
+	}
+	if len(index) > 0 {
+		w.indexed = index[len(index)-1].Time
+	}
+	w.bytes = w.off
+	if w.f, err = openTruncated(path, w.off); err != nil {
+		return nil, err
+	}
+	var idxSize int64
+	if len(index) > 0 {
+		idxSize = int64(len(indexMagic) + len(index)*indexEntrySize)
+	}
+	if w.idxFile, err = openTruncated(path+IndexSuffix, idxSize); err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	w.buf = bufio.NewWriterSize(w.f, 64*1024)
+	w.idx = bufio.NewWriterSize(w.idxFile, 4*1024)
+	if len(index) == 0 {
+		w.idx.WriteString(indexMagic)
+	}
+	return w, nil
+}
+
+// openTruncated opens path for appending after truncating it to size,
+// creating it empty if needed.
+func openTruncated(path string, size int64) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, fmt.Errorf("append to %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// NewDiscardRecordWriter returns a RecordWriter that counts records and
+// bytes without storing them, for dry runs. Its Path is name.
+func NewDiscardRecordWriter(name, kind string, interval time.Duration) *RecordWriter {
+	w := &RecordWriter{kind: kind, interval: interval, discard: true}
+	w.create(name)
+	return w
+}
+
+func (w *RecordWriter) create(path string) error {
+	var f, idx io.WriteCloser = discardFile{}, discardFile{}
+	if !w.discard {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return fmt.Errorf("create %s: %w", path, err)
+		}
+		if idx, err = os.Create(path + IndexSuffix); err != nil {
+			f.Close()
+			return fmt.Errorf("create %s: %w", path+IndexSuffix, err)
+		}
+	}
+	w.path, w.f, w.idxFile = path, f, idx
+	w.buf = bufio.NewWriterSize(f, 64*1024)
+	w.idx = bufio.NewWriterSize(idx, 4*1024)
+	w.indexed = time.Time{}
+
+	hdr := append([]byte(recordMagic), recordVersion)
+	hdr = binary.AppendUvarint(hdr, uint64(len(w.kind)))
+	hdr = append(hdr, w.kind...)
+	w.buf.Write(hdr)
+	w.off = int64(len(hdr))
+	w.bytes += int64(len(hdr))
+	w.idx.WriteString(indexMagic)
+	return nil
+}
+
+// Write appends one record with timestamp ts. Records are buffered until
+// Flush or Close.
+func (w *RecordWriter) Write(ts time.Time, rec encoding.BinaryMarshaler) error {
+	msg, err := rec.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.indexed.IsZero() || ts.Sub(w.indexed) >= w.interval {
+		var e [indexEntrySize]byte
+		binary.LittleEndian.PutUint64(e[0:], uint64(ts.UnixNano()))
+		binary.LittleEndian.PutUint64(e[8:], uint64(w.off))
+		if _, err := w.idx.Write(e[:]); err != nil {
+			w.errs.Write++
+			w.io.err = fmt.Errorf("write %s: %w", w.path+IndexSuffix, err)
+			return w.io.err
+		}
+		w.indexed = ts
+	}
+	w.scratch = binary.AppendUvarint(w.scratch[:0], uint64(len(msg)))
+	w.scratch = append(w.scratch, msg...)
+	n, err := w.buf.Write(w.scratch)
+	w.off += int64(n)
+	w.bytes += int64(n)
+	if err != nil {
+		w.errs.Write++
+		w.io.err = err
+		return err
+	}
+	w.rows++
+	return nil
+}
+
+// Flush pushes buffered records and index entries to the operating system.
+func (w *RecordWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	start := time.Now()
+	err := w.buf.Flush()
+	if ierr := w.idx.Flush(); err == nil {
+		err = ierr
+	}
+	w.io.flushed(time.Since(start))
+	if err != nil {
+		w.errs.Flush++
+		w.io.err = err
+		return err
+	}
+	return nil
+}
+
+// Reopen closes the current log, abandoning records that could not be
+// written, and continues in a new log and index at path. Row and byte
+// counts carry on from the old file.
+func (w *RecordWriter) Reopen(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, idx := w.f, w.idxFile
+	if err := w.create(path); err != nil {
+		return err
+	}
+	f.Close()
+	idx.Close()
+	w.io.err = nil
+	return nil
+}
+
+// Errors returns the number of failed writes and flushes so far.
+func (w *RecordWriter) Errors() WriterErrors {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.errs
+}
+
+// Close flushes and closes the log and its index.
+func (w *RecordWriter) Close() error {
+	err := w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := w.idxFile.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Rows returns the number of records written.
+func (w *RecordWriter) Rows() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rows
+}
+
+// Bytes returns the size of the log including records not yet flushed.
+func (w *RecordWriter) Bytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bytes
+}
+
+// Stats returns the counters of the log; Pending includes buffered index
+// entries.
+func (w *RecordWriter) Stats() WriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := WriterStats{Rows: w.rows, Bytes: w.bytes, Pending: int64(w.buf.Buffered() + w.idx.Buffered()), Errors: w.errs}
+	w.io.fill(&s)
+	return s
+}
+
+// Path returns the file path of the log.
+func (w *RecordWriter) Path() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.path
+}
+
+// IndexEntry locates one record of a log.
+type IndexEntry struct {
+	Time   time.Time
+	Offset int64
+}
+
+// RecordReader reads a binary log sequentially or from a point in time.
+type RecordReader struct {
+	f       *os.File
+	r       *bufio.Reader
+	kind    string
+	start   int64
+	index   []IndexEntry
+	pending []byte
+}
+
+// OpenRecordLog opens the log at path and loads its index if present.
+// Without an index, Seek scans from the first record.
+func OpenRecordLog(path string) (*RecordReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &RecordReader{f: f, r: bufio.NewReaderSize(f, 64*1024)}
+	if err := r.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	r.index, err = readIndex(path + IndexSuffix)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		f.Close()
+		return nil, fmt.Errorf("read %s: %w", path+IndexSuffix, err)
+	}
+	return r, nil
+}
+
+func (r *RecordReader) readHeader() error {
+	magic := make([]byte, len(recordMagic)+1)
+	if _, err := io.ReadFull(r.r, magic); err != nil {
+		return err
+	}
+	if string(magic[:len(recordMagic)]) != recordMagic {
+		return errors.New("not a record log")
+	}
+	if v := magic[len(recordMagic)]; v != recordVersion {
+		return fmt.Errorf("unsupported record log version %d", v)
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil || n > 64 {
+		return errors.New("bad record log header")
+	}
+	kind := make([]byte, n)
+	if _, err := io.ReadFull(r.r, kind); err != nil {
+		return err
+	}
+	r.kind = string(kind)
+	r.start = int64(len(magic)) + int64(len(binary.AppendUvarint(nil, n))) + int64(n)
+	return nil
+}
+
+func readIndex(path string) ([]IndexEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < len(indexMagic) || string(b[:len(indexMagic)]) != indexMagic {
+		return nil, errors.New("not a record index")
+	}
+	b = b[len(indexMagic):]
+	// A trailing partial entry from an unclean shutdown is ignored.
+	entries := make([]IndexEntry, 0, len(b)/indexEntrySize)
+	for ; len(b) >= indexEntrySize; b = b[indexEntrySize:] {
+		entries = append(entries, IndexEntry{
+			Time:   time.Unix(0, int64(binary.LittleEndian.Uint64(b))).UTC(),
+			Offset: int64(binary.LittleEndian.Uint64(b[8:])),
+		})
+	}
+	return entries, nil
+}
+
+// Kind returns the record kind of the log, "imu" or "radar".
+func (r *RecordReader) Kind() string { return r.kind }
+
+// Index returns the sparse time index, empty if the log has none.
+func (r *RecordReader) Index() []IndexEntry { return r.index }
+
+// Next returns the next encoded record, or io.EOF after the last one. A
+// record cut short by an unclean shutdown reports io.ErrUnexpectedEOF.
+func (r *RecordReader) Next() ([]byte, error) {
+	if msg := r.pending; msg != nil {
+		r.pending = nil
+		return msg, nil
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("record of %d bytes", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r.r, msg); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return msg, nil
+}
+
+// Seek positions the reader at the first record at or after t, using the
+// index to skip to the nearest earlier indexed record.
+func (r *RecordReader) Seek(t time.Time) error {
+	off := r.start
+	if i := sort.Search(len(r.index), func(i int) bool { return r.index[i].Time.After(t) }); i > 0 {
+		off = r.index[i-1].Offset
+	}
+	if _, err := r.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	r.r.Reset(r.f)
+	r.pending = nil
+	for {
+		msg, err := r.Next()
+		if err != nil {
+			return err
+		}
+		ts, err := models.RecordTime(msg)
+		if err != nil {
+			return err
+		}
+		if !ts.Before(t) {
+			r.pending = msg
+			return nil
+		}
+	}
+}
+
+// Close closes the log.
+func (r *RecordReader) Close() error { return r.f.Close() }
+
+// ReadRecordTable reads a whole binary log into a Table with the columns
+// of the equivalent CSV file, so tools written against the CSV files can
+// consume either. A truncated final record is dropped.
+func ReadRecordTable(path string) (*Table, error) {
+	r, err := OpenRecordLog(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var header []string
+	var decode func([]byte) ([][]string, error)
+	// attitude is set once an IMU record carries one, see
+	// utils.AttitudeConfig.
+	attitude := false
+	switch r.Kind() {
+	case "imu":
+		header = SchemaColumns[IMUCSV]
+		decode = func(msg []byte) ([][]string, error) {
+			var d models.IMUData
+			err := d.UnmarshalBinary(msg)
+			row := d.CSVRow()
+			if d.Attitude != nil {
+				attitude = true
+				row = append(row, d.Attitude.CSVRow()...)
+			}
+			return [][]string{row}, err
+		}
+	case "radar":
+		header = SchemaColumns[RadarCSV]
+		decode = func(msg []byte) ([][]string, error) {
+			var s models.RadarScan
+			err := s.UnmarshalBinary(msg)
+			return s.CSVRows(), err
+		}
+	default:
+		return nil, fmt.Errorf("read %s: unknown record kind %q", path, r.Kind())
+	}
+	var rows [][]string
+	for {
+		msg, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		rs, err := decode(msg)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		rows = append(rows, rs...)
+	}
+	if attitude {
+		header = append(slices.Clone(header), models.AttitudeCSVHeader()...)
+		for i, row := range rows {
+			if len(row) < len(header) {
+				rows[i] = append(row, make([]string, len(header)-len(row))...)
+			}
+		}
+	}
+	return newTable(header, rows), nil
+}
@@ -0,0 +1,105 @@
+package views
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SessionIDFile holds the UUID of a session. It is written when the
+// session is created, so that a resumed session keeps its ID.
+const SessionIDFile = "session.id"
+
+// NewSessionID returns a random (version 4) UUID.
+func NewSessionID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// WriteSessionID stores id as the ID of the session in dir.
+func WriteSessionID(dir, id string) error {
+	return os.WriteFile(filepath.Join(dir, SessionIDFile), []byte(id+"\n"), 0o644)
+}
+
+// ReadSessionID returns the ID of the session in dir. For sessions
+// recorded before sessions had one, the error satisfies
+// errors.Is(err, fs.ErrNotExist).
+func ReadSessionID(dir string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, SessionIDFile))
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimSpace(string(b))
+	if id == "" {
+		return "", fmt.Errorf("%s is empty", SessionIDFile)
+	}
+	return id, nil
+}
+
+// recordIDTail is how much of the end of a file LastRecordID reads; the
+// rows written last are in it.
+const recordIDTail = 64 << 10
+
+// LastRecordID returns the highest record ID in the last rows of the CSV
+// file at path, 0 when it has no RecordIDColumn or no rows. Rows are
+// written close to the order of their IDs, so the highest is among the
+// last.
+func LastRecordID(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	if errors.Is(err, io.EOF) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	col := slices.Index(header, RecordIDColumn)
+	if col < 0 {
+		return 0, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	from := max(fi.Size()-recordIDTail, 0)
+	tail := make([]byte, fi.Size()-from)
+	if _, err := f.ReadAt(tail, from); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	// Start at a line boundary; the header line is skipped either way.
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	r := csv.NewReader(bytes.NewReader(tail))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	var last uint64
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return last, nil
+		}
+		// A line cut at the start of the tail, or a torn last line.
+		if err != nil || col >= len(row) {
+			continue
+		}
+		if id, err := strconv.ParseUint(row[col], 10, 64); err == nil {
+			last = max(last, id)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// FusedTable names the fused records among the tables of a sink.
+const FusedTable = "fused"
+
+// FusedIMUTable names the IMU samples batched into fused records, see
+// models.FusedRecord.IMUBatch.
+const FusedIMUTable = "fused_imu"
+
+// Sink receives the records of a session in one format: the rows of each
+// enabled sensor and of the fused records, with the columns of their CSV
+// files. Sinks are written from several goroutines and are safe for
+// concurrent use.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	WriteSensor(sensor string, ts time.Time, row []string) error
+	WriteFused(ts time.Time, row []string) error
+	Flush() error
+	Close() error
+}
+
+// DirSink is a sink writing files in the session directory. Reopen starts
+// new files in dir when the session fails over; what was written to the
+// old ones stays there.
+type DirSink interface {
+	Sink
+	Reopen(dir string) error
+}
+
+// SinkTable is a table a sink receives: a sensor name, FusedTable or
+// FusedIMUTable, and
+// its columns.
+type SinkTable struct {
+	Name    string
+	Columns []string
+}
+
+// OpenSink opens the sink of cfg in the session directory dir, other than
+// the csv sink. run numbers the resumptions of the session; formats that
+// cannot be appended to start a file per run.
+func OpenSink(cfg utils.SinkConfig, dir string, run int, tables []SinkTable, log utils.Logger) (Sink, error) {
+	switch cfg.Type {
+	case utils.SinkJSONL:
+		return NewJSONLSink(dir, tables)
+	case utils.SinkParquet:
+		return NewParquetSink(dir, run, tables, cfg.RowGroupRows)
+	case utils.SinkSQLite:
+		return NewSQLiteSink(dir, run, tables)
+	case utils.SinkMCAP:
+		return NewMCAPSink(dir, run, tables)
+	case utils.SinkKafka:
+		return NewKafkaSink(cfg.Kafka, tables, log), nil
+	}
+	return nil, fmt.Errorf("sink %s: not supported here", cfg.Type)
+}
+
+// runName names the file of a sink for run of the session: base.ext, then
+// base-r1.ext and so on.
+func runName(base, ext string, run int) string {
+	if run == 0 {
+		return base + "." + ext
+	}
+	return fmt.Sprintf("%s-r%d.%s", base, run, ext)
+}
+
+// CSVSink is the csv sink: a CSV file per sensor and fused.csv. Rows of a
+// sensor without a file, disabled or logged in binary, are skipped.
+type CSVSink struct {
+	tables []string
+	files  map[string]*CSVWriter
+}
+
+func NewCSVSink() *CSVSink {
+	return &CSVSink{files: map[string]*CSVWriter{}}
+}
+
+// Add sets the file of table.
+func (s *CSVSink) Add(table string, w *CSVWriter) {
+	s.tables = append(s.tables, table)
+	s.files[table] = w
+}
+
+// File returns the file of table, nil if it has none.
+func (s *CSVSink) File(table string) *CSVWriter { return s.files[table] }
+
+// Files returns the files in the order they were added.
+func (s *CSVSink) Files() []*CSVWriter {
+	ws := make([]*CSVWriter, len(s.tables))
+	for i, t := range s.tables {
+		ws[i] = s.files[t]
+	}
+	return ws
+}
+
+func (s *CSVSink) Name() string { return utils.SinkCSV }
+
+func (s *CSVSink) WriteSensor(sensor string, _ time.Time, row []string) error {
+	if w := s.files[sensor]; w != nil {
+		return w.Write(row)
+	}
+	return nil
+}
+
+func (s *CSVSink) WriteFused(ts time.Time, row []string) error {
+	return s.WriteSensor(FusedTable, ts, row)
+}
+
+func (s *CSVSink) Flush() error {
+	var errs []error
+	for _, w := range s.Files() {
+		errs = append(errs, w.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *CSVSink) Close() error {
+	var errs []error
+	for _, w := range s.Files() {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// appendJSONRow appends row as a JSON object keyed by its columns. Values
+// that are JSON numbers are written as numbers and empty values as null;
+// a non-empty table is added under "sensor".
+func appendJSONRow(b []byte, table string, columns, row []string) []byte {
+	b = append(b, '{')
+	if table != "" {
+		b = append(b, `"sensor":`...)
+		b = appendJSONString(b, table)
+	}
+	for i, c := range columns {
+		if i > 0 || table != "" {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, c)
+		b = append(b, ':')
+		var v string
+		if i < len(row) {
+			v = row[i]
+		}
+		switch {
+		case v == "":
+			b = append(b, "null"...)
+		case isNumber(v):
+			b = append(b, v...)
+		default:
+			b = appendJSONString(b, v)
+		}
+	}
+	return append(b, '}')
+}
+
+func appendJSONString(b []byte, s string) []byte {
+	q, _ := json.Marshal(s)
+	return append(b, q...)
+}
+
+// isNumber reports whether v is a number in JSON syntax.
+func isNumber(v string) bool {
+	if _, err := strconv.ParseFloat(v, 64); err != nil {
+		return false
+	}
+	return json.Valid([]byte(v))
+}
+
+// sqlValue converts a CSV value to the SQLite value stored for it:
+// integers and reals as such, empty values as NULL.
+func sqlValue(v string) any {
+	if v == "" {
+		return nil
+	}
+	if !isNumber(v) {
+		return v
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n
+	}
+	f, _ := strconv.ParseFloat(v, 64)
+	return f
+}
@@ -0,0 +1,345 @@
+package views
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/mcap"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/parquet"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/sqlite"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// JSONLFile is the file of the jsonl sink.
+const JSONLFile = "session.jsonl"
+
+// JSONLSink writes JSONLFile: a JSON object per record in the order they
+// arrive, its table under "sensor". A resumed session appends to it after
+// dropping a line cut short.
+type JSONLSink struct {
+	mu      sync.Mutex
+	columns map[string][]string
+	f       *os.File
+	w       *bufio.Writer
+	scratch []byte
+}
+
+func NewJSONLSink(dir string, tables []SinkTable) (*JSONLSink, error) {
+	s := &JSONLSink{columns: map[string][]string{}}
+	for _, t := range tables {
+		s.columns[t.Name] = t.Columns
+	}
+	return s, s.open(dir)
+}
+
+func (s *JSONLSink) open(dir string) error {
+	path := filepath.Join(dir, JSONLFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	end, err := lastLineEnd(f)
+	if err == nil {
+		err = f.Truncate(end)
+	}
+	if err == nil {
+		_, err = f.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("append to %s: %w", path, err)
+	}
+	s.f, s.w = f, bufio.NewWriterSize(f, 64*1024)
+	return nil
+}
+
+// lastLineEnd returns the offset just past the last newline of f, 0 if it
+// has none.
+func lastLineEnd(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 64*1024)
+	for end := fi.Size(); end > 0; {
+		start := max(0, end-int64(len(buf)))
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+func (s *JSONLSink) Name() string { return utils.SinkJSONL }
+
+func (s *JSONLSink) WriteSensor(sensor string, _ time.Time, row []string) error {
+	columns, ok := s.columns[sensor]
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scratch = append(appendJSONRow(s.scratch[:0], sensor, columns, row), '\n')
+	_, err := s.w.Write(s.scratch)
+	return err
+}
+
+func (s *JSONLSink) WriteFused(ts time.Time, row []string) error {
+	return s.WriteSensor(FusedTable, ts, row)
+}
+
+func (s *JSONLSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *JSONLSink) Reopen(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.f.Close()
+	return s.open(dir)
+}
+
+func (s *JSONLSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ParquetSink writes a Parquet file per table, <table>.parquet, of string
+// columns. Rows are held in memory until a row group is full, and a file
+// is only readable once closed, so a resumed session starts new files,
+// <table>-r<N>.parquet.
+type ParquetSink struct {
+	mu        sync.Mutex
+	run       int
+	groupRows int
+	tables    []SinkTable
+	files     map[string]*parquet.Writer
+}
+
+func NewParquetSink(dir string, run int, tables []SinkTable, groupRows int) (*ParquetSink, error) {
+	s := &ParquetSink{run: run, groupRows: groupRows, tables: tables}
+	return s, s.open(dir)
+}
+
+func (s *ParquetSink) open(dir string) error {
+	s.files = map[string]*parquet.Writer{}
+	for _, t := range s.tables {
+		w, err := parquet.Create(filepath.Join(dir, runName(t.Name, "parquet", s.run)), t.Columns, s.groupRows)
+		if err != nil {
+			s.closeFiles()
+			return err
+		}
+		s.files[t.Name] = w
+	}
+	return nil
+}
+
+func (s *ParquetSink) closeFiles() error {
+	var errs []error
+	for _, w := range s.files {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ParquetSink) Name() string { return utils.SinkParquet }
+
+func (s *ParquetSink) WriteSensor(sensor string, _ time.Time, row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w := s.files[sensor]; w != nil {
+		return w.Write(row)
+	}
+	return nil
+}
+
+func (s *ParquetSink) WriteFused(ts time.Time, row []string) error {
+	return s.WriteSensor(FusedTable, ts, row)
+}
+
+func (s *ParquetSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, w := range s.files {
+		errs = append(errs, w.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ParquetSink) Reopen(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeFiles()
+	return s.open(dir)
+}
+
+func (s *ParquetSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFiles()
+}
+
+// SQLiteSink writes session.sqlite, a table per sensor and one of the
+// fused records, with numbers stored as integers or reals and empty values
+// as NULL. The schema is written on Close, so a resumed session starts a
+// new database, session-r<N>.sqlite.
+type SQLiteSink struct {
+	mu     sync.Mutex
+	run    int
+	tables []SinkTable
+	db     *sqlite.Writer
+	index  map[string]int
+	values []any
+}
+
+func NewSQLiteSink(dir string, run int, tables []SinkTable) (*SQLiteSink, error) {
+	s := &SQLiteSink{run: run, tables: tables}
+	return s, s.open(dir)
+}
+
+func (s *SQLiteSink) open(dir string) error {
+	db, err := sqlite.Create(filepath.Join(dir, runName("session", "sqlite", s.run)))
+	if err != nil {
+		return err
+	}
+	s.db, s.index = db, map[string]int{}
+	for _, t := range s.tables {
+		s.index[t.Name] = db.Table(t.Name, t.Columns)
+	}
+	return nil
+}
+
+func (s *SQLiteSink) Name() string { return utils.SinkSQLite }
+
+func (s *SQLiteSink) WriteSensor(sensor string, _ time.Time, row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.index[sensor]
+	if !ok {
+		return nil
+	}
+	s.values = s.values[:0]
+	for _, v := range row {
+		s.values = append(s.values, sqlValue(v))
+	}
+	return s.db.Insert(t, s.values)
+}
+
+func (s *SQLiteSink) WriteFused(ts time.Time, row []string) error {
+	return s.WriteSensor(FusedTable, ts, row)
+}
+
+func (s *SQLiteSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Flush()
+}
+
+func (s *SQLiteSink) Reopen(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db.Close()
+	return s.open(dir)
+}
+
+func (s *SQLiteSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+// MCAPSink writes session.mcap, a channel per table (/gps, /fused, ...)
+// of JSON messages logged at the record timestamps, for Foxglove Studio
+// and the mcap tools. Each channel carries the RecordSchema of its table. The summary is written on Close, so a resumed
+// session starts a new file, session-r<N>.mcap.
+type MCAPSink struct {
+	mu       sync.Mutex
+	run      int
+	tables   []SinkTable
+	w        *mcap.Writer
+	channels map[string]uint16
+	scratch  []byte
+}
+
+func NewMCAPSink(dir string, run int, tables []SinkTable) (*MCAPSink, error) {
+	s := &MCAPSink{run: run, tables: tables}
+	return s, s.open(dir)
+}
+
+func (s *MCAPSink) open(dir string) error {
+	w, err := mcap.Create(filepath.Join(dir, runName("session", "mcap", s.run)), "sensor-logger")
+	if err != nil {
+		return err
+	}
+	s.w, s.channels = w, map[string]uint16{}
+	for _, t := range s.tables {
+		schema, _ := LookupRecordSchema(t.Name)
+		if t.Name == FusedTable {
+			for _, c := range t.Columns {
+				if !slices.Contains(schema.Columns, c) && !slices.Contains(schema.Optional, c) {
+					schema.Custom = append(schema.Custom, c)
+				}
+			}
+		}
+		id := w.Schema("sensor_logger/"+t.Name, "jsonschema", schema.JSON())
+		s.channels[t.Name] = w.Channel("/"+t.Name, id, "json")
+	}
+	return nil
+}
+
+func (s *MCAPSink) Name() string { return utils.SinkMCAP }
+
+func (s *MCAPSink) WriteSensor(sensor string, ts time.Time, row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[sensor]
+	if !ok {
+		return nil
+	}
+	s.scratch = appendJSONRow(s.scratch[:0], "", s.tables[ch].Columns, row)
+	return s.w.Write(ch, ts, s.scratch)
+}
+
+func (s *MCAPSink) WriteFused(ts time.Time, row []string) error {
+	return s.WriteSensor(FusedTable, ts, row)
+}
+
+func (s *MCAPSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+func (s *MCAPSink) Reopen(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Close()
+	return s.open(dir)
+}
+
+func (s *MCAPSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Close()
+}
@@ -0,0 +1,154 @@
+package views
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/services/kafka"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+const (
+	kafkaRetryInterval = 10 * time.Second
+	// kafkaMaxBatch hands a batch to the producer before the next flush
+	// when the records come in faster than flushes.
+	kafkaMaxBatch = 4096
+)
+
+// KafkaSink produces the records as JSON objects to a Kafka topic, keyed
+// by table so that each sensor stays in order on its partition. Batches
+// are sent in the background at every flush; while the brokers are
+// unreachable, or the producer is behind, batches are dropped and the
+// connection is retried periodically. Its writes never fail.
+type KafkaSink struct {
+	cfg     utils.KafkaConfig
+	log     utils.Logger
+	columns map[string][]string
+
+	mu    sync.Mutex
+	batch []kafka.Message
+	// queueDropped counts the records dropped with the queue full.
+	queueDropped int64
+
+	queue chan []kafka.Message
+	done  chan struct{}
+
+	// Owned by run.
+	producer *kafka.Producer
+	nextDial time.Time
+	sent     int64
+	dropped  int64
+}
+
+func NewKafkaSink(cfg utils.KafkaConfig, tables []SinkTable, log utils.Logger) *KafkaSink {
+	s := &KafkaSink{cfg: cfg, log: log, columns: map[string][]string{},
+		queue: make(chan []kafka.Message, 4), done: make(chan struct{})}
+	for _, t := range tables {
+		s.columns[t.Name] = t.Columns
+	}
+	go s.run()
+	return s
+}
+
+func (s *KafkaSink) Name() string { return utils.SinkKafka }
+
+func (s *KafkaSink) WriteSensor(sensor string, ts time.Time, row []string) error {
+	columns, ok := s.columns[sensor]
+	if !ok {
+		return nil
+	}
+	m := kafka.Message{Key: []byte(sensor), Value: appendJSONRow(nil, sensor, columns, row), Time: ts}
+	s.mu.Lock()
+	s.batch = append(s.batch, m)
+	full := len(s.batch) >= kafkaMaxBatch
+	s.mu.Unlock()
+	if full {
+		s.Flush()
+	}
+	return nil
+}
+
+func (s *KafkaSink) WriteFused(ts time.Time, row []string) error {
+	return s.WriteSensor(FusedTable, ts, row)
+}
+
+// Flush hands the records written since the last flush to the producer.
+func (s *KafkaSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.batch) == 0 {
+		return nil
+	}
+	select {
+	case s.queue <- s.batch:
+	default:
+		s.queueDropped += int64(len(s.batch))
+	}
+	s.batch = nil
+	return nil
+}
+
+// Close sends the last records and waits for the producer.
+func (s *KafkaSink) Close() error {
+	s.mu.Lock()
+	if len(s.batch) > 0 {
+		s.queue <- s.batch
+		s.batch = nil
+	}
+	close(s.queue)
+	dropped := s.queueDropped
+	s.mu.Unlock()
+	<-s.done
+	s.log.Infof("kafka: produced %d records to %s (%d dropped)", s.sent, s.cfg.Topic, s.dropped+dropped)
+	return nil
+}
+
+func (s *KafkaSink) run() {
+	defer close(s.done)
+	for batch := range s.queue {
+		if !s.connected() {
+			s.dropped += int64(len(batch))
+			continue
+		}
+		if err := s.producer.Produce(batch); err != nil {
+			s.log.Warnf("kafka: %v (retrying in %v)", err, kafkaRetryInterval)
+			s.disconnect()
+			s.dropped += int64(len(batch))
+			continue
+		}
+		s.sent += int64(len(batch))
+	}
+	s.disconnect()
+}
+
+func (s *KafkaSink) connected() bool {
+	if s.producer != nil {
+		return true
+	}
+	if time.Now().Before(s.nextDial) {
+		return false
+	}
+	p, err := kafka.Dial(kafka.Options{
+		Brokers:  s.cfg.Brokers,
+		Topic:    s.cfg.Topic,
+		ClientID: s.cfg.ClientID,
+		Timeout:  time.Duration(s.cfg.TimeoutS) * time.Second,
+	})
+	if err != nil {
+		s.log.Warnf("%v (retrying in %v)", err, kafkaRetryInterval)
+		s.nextDial = time.Now().Add(kafkaRetryInterval)
+		return false
+	}
+	s.log.Infof("kafka: connected to %s, producing to %s", strings.Join(s.cfg.Brokers, ","), s.cfg.Topic)
+	s.producer = p
+	return true
+}
+
+func (s *KafkaSink) disconnect() {
+	if s.producer != nil {
+		s.producer.Close()
+		s.producer = nil
+		s.nextDial = time.Now().Add(kafkaRetryInterval)
+	}
+}
@@ -0,0 +1,255 @@
+package views
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Thumbnail is one downscaled camera frame held by Thumbnails.
+type Thumbnail struct {
+	Seq       uint64    `json:"seq"`
+	FrameID   uint64    `json:"frame_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	jpeg      []byte
+}
+
+// Thumbnails keeps downscaled JPEGs of the most recent camera frames in a
+// ring and serves them over HTTP, so operators can check the camera without
+// touching the frames on disk. It is a SampleRecorder that only looks at
+// camera frames; decoding and scaling happen in the background and frames
+// arriving while the previous one is still being scaled are skipped.
+type Thumbnails struct {
+	cfg    utils.ThumbnailsConfig
+	log    utils.Logger
+	period time.Duration
+	in     chan models.CameraFrame
+	done   chan struct{}
+
+	last time.Time // of the last frame sampled, touched only by RecordCamera
+
+	mu   sync.Mutex
+	ring []Thumbnail
+	next int
+	seq  uint64
+	fail int
+}
+
+func NewThumbnails(cfg utils.ThumbnailsConfig, log utils.Logger) *Thumbnails {
+	t := &Thumbnails{
+		cfg:    cfg,
+		log:    log,
+		period: utils.Period(cfg.RateHz),
+		in:     make(chan models.CameraFrame, 1),
+		done:   make(chan struct{}),
+		ring:   make([]Thumbnail, 0, max(cfg.RateHz*cfg.Seconds, 1)),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Thumbnails) RecordCamera(f models.CameraFrame) {
+	if len(f.Data) == 0 || f.Timestamp.Sub(t.last) < t.period {
+		return
+	}
+	select {
+	case t.in <- f:
+		t.last = f.Timestamp
+	default:
+	}
+}
+
+func (t *Thumbnails) RecordGPS(models.GPSData)          {}
+func (t *Thumbnails) RecordIMU(models.IMUData)          {}
+func (t *Thumbnails) RecordLidar(models.LidarPacket)    {}
+func (t *Thumbnails) RecordRadar(models.RadarScan)      {}
+func (t *Thumbnails) RecordEnv(models.EnvData)          {}
+func (t *Thumbnails) RecordTrigger(models.TriggerPulse) {}
+func (t *Thumbnails) RecordINS(models.INSData)          {}
+
+// Close stops the background encoder.
+func (t *Thumbnails) Close() {
+	close(t.in)
+	<-t.done
+}
+
+func (t *Thumbnails) run() {
+	defer close(t.done)
+	var buf bytes.Buffer
+	for f := range t.in {
+		src, err := jpeg.Decode(bytes.NewReader(f.Data))
+		if err == nil {
+			buf.Reset()
+			dst := downscale(src, t.cfg.Width, t.cfg.Height)
+			if err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: t.cfg.Quality}); err == nil {
+				t.push(Thumbnail{
+					FrameID:   f.FrameID,
+					Timestamp: f.Timestamp,
+					Width:     dst.Bounds().Dx(),
+					Height:    dst.Bounds().Dy(),
+					jpeg:      bytes.Clone(buf.Bytes()),
+				})
+				continue
+			}
+		}
+		t.mu.Lock()
+		t.fail++
+		first := t.fail == 1
+		t.mu.Unlock()
+		if first {
+			t.log.Warnf("thumbnails: frame %d: %v (further errors are not logged)", f.FrameID, err)
+		}
+	}
+}
+
+func (t *Thumbnails) push(th Thumbnail) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	th.Seq = t.seq
+	if len(t.ring) < cap(t.ring) {
+		t.ring = append(t.ring, th)
+		return
+	}
+	t.ring[t.next] = th
+	t.next = (t.next + 1) % len(t.ring)
+}
+
+// List returns the thumbnails held, oldest first.
+func (t *Thumbnails) List() []Thumbnail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Thumbnail, 0, len(t.ring))
+	out = append(out, t.ring[t.next:]...)
+	return append(out, t.ring[:t.next]...)
+}
+
+// ServeList answers with the thumbnails held as a JSON array, oldest first.
+func (t *Thumbnails) ServeList(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(t.List())
+}
+
+// ServeImage answers /thumbnails/{name} with the JPEG named <seq>.jpg, or
+// the newest one for latest.jpg.
+func (t *Thumbnails) ServeImage(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(r.PathValue("name"), ".jpg")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	list := t.List()
+	var img *Thumbnail
+	if name == "latest" {
+		if len(list) > 0 {
+			img = &list[len(list)-1]
+		}
+	} else if seq, err := strconv.ParseUint(name, 10, 64); err == nil {
+		for i := range list {
+			if list[i].Seq == seq {
+				img = &list[i]
+			}
+		}
+	}
+	if img == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	if name == "latest" {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.Write(img.jpeg)
+}
+
+var cameraPage = template.Must(template.New("camera").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Camera</title>
+<style>body{font-family:sans-serif;margin:2em}#strip img{margin:2px;width:{{.Small}}px}#latest{max-width:100%}</style></head>
+<body><h1>Camera</h1>
+<p id="info">waiting for frames…</p>
+<img id="latest" alt="">
+<div id="strip"></div>
+<script>
+async function refresh() {
+  const list = await (await fetch("thumbnails")).json();
+  if (!list || list.length == 0) return;
+  const last = list[list.length - 1];
+  document.getElementById("latest").src = "thumbnails/" + last.seq + ".jpg";
+  document.getElementById("info").textContent = "frame " + last.frame_id + " at " + last.timestamp +
+    ", " + list.length + " thumbnails held";
+  const strip = document.getElementById("strip");
+  strip.innerHTML = "";
+  for (const t of list.slice().reverse()) {
+    const img = document.createElement("img");
+    img.src = "thumbnails/" + t.seq + ".jpg";
+    img.title = t.timestamp;
+    strip.appendChild(img);
+  }
+}
+refresh();
+setInterval(refresh, {{.RefreshMs}});
+</script>
+</body></html>
+`))
+
+// ServePage answers with a page showing the newest thumbnail and a strip of
+// the ones before it, refreshed once per sample period.
+func (t *Thumbnails) ServePage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	cameraPage.Execute(w, struct{ Small, RefreshMs int }{t.cfg.Width / 2, int(max(t.period, time.Second) / time.Millisecond)})
+}
+
+// downscale fits src into w×h keeping its aspect ratio, averaging the
+// luma of each source block. Images already small enough are returned as
+// they are.
+func downscale(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= w && sh <= h {
+		return src
+	}
+	if sw*h > sh*w {
+		h = max(sh*w/sw, 1)
+	} else {
+		w = max(sw*h/sh, 1)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	ycc, isYCC := src.(*image.YCbCr)
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*sh/h, b.Min.Y+(y+1)*sh/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*sw/w, b.Min.X+(x+1)*sw/w
+			cx, cy := (x0+x1)/2, (y0+y1)/2
+			if !isYCC {
+				dst.Set(x, y, src.At(cx, cy))
+				continue
+			}
+			var sum, n int
+			for sy := y0; sy < y1; sy++ {
+				row := ycc.YOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum += int(ycc.Y[row+sx-x0])
+					n++
+				}
+			}
+			ci := ycc.COffset(cx, cy)
+			r, g, bl := color.YCbCrToRGB(uint8(sum/max(n, 1)), ycc.Cb[ci], ycc.Cr[ci])
+			dst.SetRGBA(x, y, color.RGBA{r, g, bl, 255})
+		}
+	}
+	return dst
+}
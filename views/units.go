@@ -0,0 +1,153 @@
+package views
+
+import (
+	"errors"
+	"io/fs"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Quantities of the columns whose values follow utils.UnitsConfig.
+const (
+	quantitySpeed  = "speed"
+	quantityAngle  = "angle"
+	quantityLatLon = "latlon"
+)
+
+// unitColumns are the columns of the session records written in the units
+// of storage.yaml, by quantity. The models write them in m/s and degrees.
+var unitColumns = map[string]string{
+	"speed_mps": quantitySpeed, "speed_acc_mps": quantitySpeed, "velocity_mps": quantitySpeed,
+	"gps_speed_mps": quantitySpeed,
+	"heading_deg":   quantityAngle, "heading_acc_deg": quantityAngle, "azimuth_deg": quantityAngle,
+	"gps_heading_deg": quantityAngle, "roll_deg": quantityAngle, "pitch_deg": quantityAngle, "yaw_deg": quantityAngle,
+	"lat": quantityLatLon, "lon": quantityLatLon, "gps_lat": quantityLatLon, "gps_lon": quantityLatLon,
+}
+
+// UnitConverter rewrites the rows of a table from the units of the models
+// into those of a utils.UnitsConfig.
+type UnitConverter struct {
+	cols     []int
+	scale    []float64 // 0 rounds the latitude or longitude to decimals
+	extra    []int     // decimals added to those of the value
+	decimals int
+}
+
+// NewUnitConverter returns the converter of the rows of a table with
+// columns into u, nil when u changes none of them.
+func NewUnitConverter(columns []string, u utils.UnitsConfig) *UnitConverter {
+	c := &UnitConverter{decimals: u.LatLonDecimals}
+	for i, name := range columns {
+		scale, extra := 0.0, 0
+		switch unitColumns[name] {
+		case quantitySpeed:
+			if u.Speed != utils.UnitKmh {
+				continue
+			}
+			scale = 3.6
+		case quantityAngle:
+			if u.Angle != utils.UnitRad {
+				continue
+			}
+			// A hundredth of a degree is 0.00017 rad.
+			scale, extra = math.Pi/180, 2
+		case quantityLatLon:
+			if u.LatLonDecimals == utils.DefaultUnits.LatLonDecimals {
+				continue
+			}
+		default:
+			continue
+		}
+		c.cols = append(c.cols, i)
+		c.scale = append(c.scale, scale)
+		c.extra = append(c.extra, extra)
+	}
+	if len(c.cols) == 0 {
+		return nil
+	}
+	return c
+}
+
+// Convert rewrites row in place and returns it. Empty cells and those
+// that are not numbers, such as encrypted positions, are left alone.
+func (c *UnitConverter) Convert(row []string) []string {
+	if c == nil {
+		return row
+	}
+	for j, i := range c.cols {
+		if i >= len(row) {
+			continue
+		}
+		v, err := strconv.ParseFloat(row[i], 64)
+		if err != nil {
+			continue
+		}
+		if c.scale[j] == 0 {
+			row[i] = strconv.FormatFloat(v, 'f', c.decimals, 64)
+			continue
+		}
+		row[i] = strconv.FormatFloat(v*c.scale[j], 'f', decimals(row[i])+c.extra[j], 64)
+	}
+	return row
+}
+
+// decimals returns the number of digits after the point of v.
+func decimals(v string) int {
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		return len(v) - i - 1
+	}
+	return 0
+}
+
+// ToModelUnits converts the speeds and angles of t, read from a session
+// written in u, back into the m/s and degrees of the models, so that
+// tools reading a session need not care about its units.
+func (t *Table) ToModelUnits(u utils.UnitsConfig) {
+	for name, q := range unitColumns {
+		i := t.Col(name)
+		if i < 0 {
+			continue
+		}
+		scale := 1.0
+		switch {
+		case q == quantitySpeed && u.Speed == utils.UnitKmh:
+			scale = 1 / 3.6
+		case q == quantityAngle && u.Angle == utils.UnitRad:
+			scale = 180 / math.Pi
+		default:
+			continue
+		}
+		for _, row := range t.Rows {
+			if i >= len(row) {
+				continue
+			}
+			if v, err := strconv.ParseFloat(row[i], 64); err == nil {
+				row[i] = strconv.FormatFloat(v*scale, 'f', -1, 64)
+			}
+		}
+	}
+}
+
+// SessionUnits returns the units the session in dir was recorded in, from
+// its manifest. A session without one, still open or not closed cleanly,
+// is taken to be in fallback, utils.DefaultUnits when zero; sessions from
+// before the units could be set are in utils.DefaultUnits.
+func SessionUnits(dir string, fallback utils.UnitsConfig) (utils.UnitsConfig, error) {
+	m, err := ReadManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		if fallback.Speed == "" {
+			return utils.DefaultUnits, nil
+		}
+		return fallback, nil
+	}
+	if err != nil {
+		return utils.UnitsConfig{}, err
+	}
+	if m.Units.Speed == "" {
+		return utils.DefaultUnits, nil
+	}
+	return m.Units, nil
+}
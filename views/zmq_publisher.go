@@ -0,0 +1,95 @@
+package views
+
+import (
+	"encoding/json"
+
+	"github.com/lkumar3-iitr/Sensor-Logger/models"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/auth"
+	"github.com/lkumar3-iitr/Sensor-Logger/services/zmq"
+	"github.com/lkumar3-iitr/Sensor-Logger/utils"
+)
+
+// Topics published by ZMQPublisher, one per sensor.
+const (
+	TopicCamera = "camera"
+	TopicGPS    = "gps"
+	TopicIMU    = "imu"
+	TopicLidar  = "lidar"
+	TopicRadar  = "radar"
+	TopicEnv    = "env"
+	// TopicTrigger carries the pulses of the hardware trigger.
+	TopicTrigger = "trigger"
+	// TopicINS carries the solutions of a combined INS.
+	TopicINS = "ins"
+)
+
+// ZMQPublisher republishes every raw sample as JSON on a ZeroMQ PUB socket,
+// as a [topic, payload] message. Camera JPEGs and lidar clouds are left out
+// unless blobs are enabled. Publishing never blocks the caller.
+type ZMQPublisher struct {
+	sock  *zmq.PubSocket
+	blobs bool
+	log   utils.Logger
+}
+
+func NewZMQPublisher(cfg utils.ZMQConfig, a *auth.Authenticator, log utils.Logger) (*ZMQPublisher, error) {
+	// Subscribers authenticate with ZMTP PLAIN, the token as password.
+	var plain func(user, password string) bool
+	if a.Required() {
+		plain = func(_, password string) bool { return a.Role(password, nil) >= auth.Read }
+	}
+	sock, err := zmq.Listen(cfg.Endpoint, cfg.HWM, plain)
+	if err != nil {
+		return nil, err
+	}
+	return &ZMQPublisher{sock: sock, blobs: cfg.IncludeBlobs, log: log}, nil
+}
+
+func (p *ZMQPublisher) RecordCamera(f models.CameraFrame) {
+	if !p.blobs {
+		f.Data = nil
+		if f.Right != nil {
+			right := *f.Right
+			right.Data = nil
+			f.Right = &right
+		}
+	}
+	p.publish(TopicCamera, f)
+}
+
+func (p *ZMQPublisher) RecordGPS(g models.GPSData) { p.publish(TopicGPS, g) }
+
+func (p *ZMQPublisher) RecordIMU(m models.IMUData) { p.publish(TopicIMU, m) }
+
+func (p *ZMQPublisher) RecordLidar(l models.LidarPacket) {
+	if !p.blobs {
+		l.RawCloud = nil
+	}
+	p.publish(TopicLidar, l)
+}
+
+func (p *ZMQPublisher) RecordRadar(s models.RadarScan) { p.publish(TopicRadar, s) }
+
+func (p *ZMQPublisher) RecordEnv(e models.EnvData) { p.publish(TopicEnv, e) }
+
+func (p *ZMQPublisher) RecordTrigger(t models.TriggerPulse) { p.publish(TopicTrigger, t) }
+
+func (p *ZMQPublisher) RecordINS(d models.INSData) { p.publish(TopicINS, d) }
+
+// Close disconnects all subscribers and logs how many messages slow
+// subscribers missed.
+func (p *ZMQPublisher) Close() error {
+	if n := p.sock.Dropped(); n > 0 {
+		p.log.Warnf("zmq: %d messages dropped for slow subscribers", n)
+	}
+	return p.sock.Close()
+}
+
+func (p *ZMQPublisher) publish(topic string, v any) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		p.log.Errorf("zmq: encode %s: %v", topic, err)
+		return
+	}
+	p.sock.Publish(topic, payload)
+}